@@ -0,0 +1,269 @@
+// Command allinone runs the reader, the writer and an embedded keygen in
+// one process, over an in-memory store saved to a file or a SQLite
+// database, for small self-hosted deployments and demos: no GCP project,
+// Kubernetes or memcache is needed.
+//
+// The writer API is served under /write/ and redirects everywhere else,
+// on the reader's BIND_ADDR. Both are configured by the environment
+// variables of the reader and the writer, which -config may set from a
+// file.
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+
+	"github.com/FlorinBalint/shortener/pkg/kubeflake"
+	"github.com/FlorinBalint/shortener/pkg/lifecycle"
+	"github.com/FlorinBalint/shortener/pkg/reader"
+	"github.com/FlorinBalint/shortener/pkg/sqlitestore"
+	"github.com/FlorinBalint/shortener/pkg/statusz"
+	"github.com/FlorinBalint/shortener/pkg/urlstore"
+	"github.com/FlorinBalint/shortener/pkg/writer"
+)
+
+var (
+	configFile   = flag.String("config", "", "File of KEY=VALUE lines setting the environment variables of the reader and the writer; variables set in the environment win")
+	storeKind    = flag.String("store", os.Getenv("STORE"), "Where links are kept: memory (the default), saved to -data, or sqlite, in the database file -data (env STORE)")
+	dataFile     = flag.String("data", os.Getenv("DATA_FILE"), "File the links are kept in. For -store=memory, they are loaded from it on start and saved to it every -save.interval and on shutdown, and empty keeps them in memory only; -store=sqlite requires it (env DATA_FILE)")
+	saveInterval = flag.Duration("save.interval", time.Minute, "How often to save the links to -data, with -store=memory")
+	epoch        = flag.String("epoch", "2025-01-01T00:00:00Z", "Start of key time (RFC 3339) of the embedded keygen; must not be in the future, nor change once keys were generated")
+)
+
+// Set at build time with -ldflags "-X main.version=... -X main.commit=...".
+var (
+	version = "dev"
+	commit  = "none"
+)
+
+// loadEnvFile sets the variables of the KEY=VALUE lines of the file at
+// path that the environment does not set. Blank lines and lines starting
+// with # are skipped; values may be quoted.
+func loadEnvFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		k, v, ok := strings.Cut(line, "=")
+		k = strings.TrimSpace(strings.TrimPrefix(k, "export "))
+		if !ok || k == "" {
+			return fmt.Errorf("%s:%d: want KEY=VALUE", path, n)
+		}
+		v = strings.TrimSpace(v)
+		if uq, err := strconv.Unquote(v); err == nil {
+			v = uq
+		} else if len(v) >= 2 && v[0] == '\'' && v[len(v)-1] == '\'' {
+			v = v[1 : len(v)-1]
+		}
+		if _, set := os.LookupEnv(k); !set {
+			os.Setenv(k, v)
+		}
+	}
+	return sc.Err()
+}
+
+// newKeygen returns the embedded keygen, the only one minting keys for
+// the store, so its cluster and machine IDs are 0.
+func newKeygen() (*kubeflake.Kubeflake, error) {
+	epochTime, err := time.Parse(time.RFC3339, *epoch)
+	if err != nil {
+		return nil, fmt.Errorf("invalid -epoch: %w", err)
+	}
+	return kubeflake.NewWithOptions(
+		kubeflake.WithEpoch(epochTime),
+		kubeflake.WithClusterID(kubeflake.FixedID(0)),
+		kubeflake.WithMachineID(kubeflake.FixedID(0)),
+	)
+}
+
+// dataStore saves the links of a memory store to a file.
+type dataStore struct {
+	*urlstore.MemoryClient
+	path string
+	// mu serializes saves.
+	mu sync.Mutex
+}
+
+// load reads the links saved to the file, if there is one.
+func (s *dataStore) load() error {
+	f, err := os.Open(s.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return s.Load(f)
+}
+
+// save writes the links to a temporary file renamed over the file, so a
+// crash while saving leaves the last save whole.
+func (s *dataStore) save() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	w := bufio.NewWriter(tmp)
+	err = s.Save(w)
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
+
+// saveEvery saves the links every interval until ctx is done.
+func (s *dataStore) saveEvery(ctx context.Context, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			if err := s.save(); err != nil {
+				log.Printf("saving links to %s: %v", s.path, err)
+			}
+		}
+	}
+}
+
+func main() {
+	flag.Parse()
+	if *configFile != "" {
+		if err := loadEnvFile(*configFile); err != nil {
+			fmt.Println("Error reading config:", err)
+			os.Exit(1)
+		}
+	}
+	readerCfg := reader.LoadConfigFromEnv()
+	writerCfg := writer.LoadConfigFromEnv()
+
+	keygen, err := newKeygen()
+	if err != nil {
+		fmt.Println("Error creating keygen:", err)
+		os.Exit(1)
+	}
+	writerCfg.KeyGenerator = func(context.Context) (string, error) { return keygen.NextKey() }
+
+	var store urlstore.Client
+	var data *dataStore
+	switch *storeKind {
+	case "", "memory":
+		mem := urlstore.NewMemoryClient()
+		store = mem
+		if *dataFile != "" {
+			data = &dataStore{MemoryClient: mem, path: *dataFile}
+			if err := data.load(); err != nil {
+				fmt.Printf("Error loading links from %s: %v\n", *dataFile, err)
+				os.Exit(1)
+			}
+		}
+	case "sqlite":
+		if *dataFile == "" {
+			fmt.Println("-store=sqlite requires -data")
+			os.Exit(1)
+		}
+		db, err := sqlitestore.Open(context.Background(), *dataFile)
+		if err != nil {
+			fmt.Printf("Error opening %s: %v\n", *dataFile, err)
+			os.Exit(1)
+		}
+		defer db.Close()
+		store = db
+	default:
+		fmt.Printf("Unknown -store %q, want memory or sqlite\n", *storeKind)
+		os.Exit(1)
+	}
+
+	status := statusz.New("allinone", statusz.NewBuild(version, commit))
+	status.Set("reader_config", statusz.Redact(readerCfg))
+	status.Set("writer_config", statusz.Redact(writerCfg))
+	status.Set("bit_layout", statusz.Redact(keygen.Layout()))
+	statusz.Serve(readerCfg.StatuszAddr, status)
+
+	writes := writer.NewHandlerWithStore(writerCfg, store)
+	defer writes.Close()
+	reads := reader.NewHandlerWithStore(readerCfg, store)
+	defer reads.Close()
+	status.SetFunc("caches", func() any { return reads.CacheStats() })
+	status.SetFunc("degrade", func() any { return reads.DegradeStats() })
+
+	mux := http.NewServeMux()
+	mux.Handle("/write/", writes)
+	mux.Handle("/", reads)
+
+	ctx, stopSaving := context.WithCancel(context.Background())
+	if data != nil {
+		go data.saveEvery(ctx, *saveInterval)
+	}
+
+	// The reader answers /ready; the writer is made ready with it.
+	warm := func(ctx context.Context) error {
+		g, ctx := errgroup.WithContext(ctx)
+		g.Go(func() error { return reads.Warm(ctx) })
+		g.Go(func() error {
+			if err := writes.Warm(ctx); err != nil {
+				return err
+			}
+			writes.Readiness().Set(true)
+			return nil
+		})
+		return g.Wait()
+	}
+	log.Printf("allinone: serving on %s, links %s", readerCfg.BindAddr, storage(*storeKind, *dataFile))
+	srv := &http.Server{Addr: readerCfg.BindAddr, Handler: mux}
+	if err := lifecycle.Run(srv, reads.Readiness(), warm, readerCfg.Lifecycle); err != nil {
+		fmt.Println("Error serving:", err)
+	}
+	stopSaving()
+	if data != nil {
+		if err := data.save(); err != nil {
+			fmt.Printf("Error saving links to %s: %v\n", *dataFile, err)
+		}
+	}
+}
+
+// storage describes where the links are kept, for the start-up log.
+func storage(kind, path string) string {
+	switch {
+	case kind == "sqlite":
+		return "in the SQLite database " + path
+	case path == "":
+		return "in memory only"
+	}
+	return "saved to " + path
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"google.golang.org/api/googleapi"
+	storage "google.golang.org/api/storage/v1"
+
+	"github.com/FlorinBalint/shortener/pkg/gcputil"
+	"github.com/FlorinBalint/shortener/pkg/hygiene"
+	"github.com/FlorinBalint/shortener/pkg/urlstore"
+)
+
+var (
+	batchSize   = flag.Int("batch.size", 500, "Entries scanned per store page")
+	maxFindings = flag.Int("max_findings", 1000, "Findings of each kind listed in the report; all are counted")
+	largest     = flag.Int("largest", 20, "How many of the largest entries to list")
+	format      = flag.String("format", "json", "Report format: json or csv")
+	out         = flag.String("out", "-", "Where to write the report: a file, gs://bucket/object, or - for stdout")
+)
+
+func main() {
+	flag.Parse()
+	if *format != "json" && *format != "csv" {
+		fmt.Println("Error: -format must be json or csv, not", *format)
+		os.Exit(1)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	dsClient, err := gcputil.NewDSClient(ctx, os.Getenv("GCP_PROJECT"), os.Getenv("DS_ENDPOINT"), os.Getenv("DS_NAMESPACE"))
+	if err != nil {
+		fmt.Println("Error creating datastore client:", err)
+		os.Exit(1)
+	}
+	// The scan only reads, so the store is used without the cache, and
+	// entries are reported as stored.
+	store := urlstore.NewClient(dsClient)
+	defer store.Close()
+
+	report, err := hygiene.Scan(ctx, store, hygiene.Config{
+		BatchSize:   *batchSize,
+		MaxFindings: *maxFindings,
+		Largest:     *largest,
+	}, time.Now())
+	if err != nil {
+		fmt.Println("Error scanning the store:", err)
+		os.Exit(1)
+	}
+
+	var buf bytes.Buffer
+	if *format == "csv" {
+		err = report.WriteCSV(&buf)
+	} else {
+		err = report.WriteJSON(&buf)
+	}
+	if err == nil {
+		err = write(ctx, *out, &buf)
+	}
+	if err != nil {
+		fmt.Println("Error writing the report:", err)
+		os.Exit(1)
+	}
+	fmt.Fprintf(os.Stderr, "scanned %d: %d expired, %d deleted, %d invalid targets, %d duplicate targets\n",
+		report.Stats.Entries, report.ExpiredCount, report.DeletedCount, report.InvalidCount, report.DuplicateCount)
+}
+
+// write copies the report to dest: stdout for "-", a Cloud Storage object
+// for gs://bucket/object, a local file otherwise.
+func write(ctx context.Context, dest string, r io.Reader) error {
+	if dest == "-" {
+		_, err := io.Copy(os.Stdout, r)
+		return err
+	}
+	if path, ok := strings.CutPrefix(dest, "gs://"); ok {
+		bucket, object, ok := strings.Cut(path, "/")
+		if !ok || bucket == "" || object == "" {
+			return fmt.Errorf("%q is not gs://bucket/object", dest)
+		}
+		svc, err := storage.NewService(ctx)
+		if err != nil {
+			return err
+		}
+		contentType := "application/json"
+		if *format == "csv" {
+			contentType = "text/csv"
+		}
+		_, err = svc.Objects.Insert(bucket, &storage.Object{Name: object, ContentType: contentType}).
+			Media(r, googleapi.ContentType(contentType)).
+			Context(ctx).Do()
+		return err
+	}
+	f, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/FlorinBalint/shortener/pkg/archive"
+	"github.com/FlorinBalint/shortener/pkg/discovery"
+	"github.com/FlorinBalint/shortener/pkg/gcputil"
+	"github.com/FlorinBalint/shortener/pkg/janitor"
+	"github.com/FlorinBalint/shortener/pkg/machineclaim"
+	"github.com/FlorinBalint/shortener/pkg/urlstore"
+)
+
+var (
+	retention = flag.Duration("retention", 30*24*time.Hour, "How long expired or soft-deleted entries are kept before purging")
+	interval  = flag.Duration("interval", time.Hour, "Time between sweeps")
+	once      = flag.Bool("once", false, "Sweep once and exit (e.g. when run as a CronJob)")
+	batchSize = flag.Int("batch.size", 500, "Entries scanned per store page")
+	dryRun    = flag.Bool("dry_run", false, "Only report what would be purged")
+	claimTTL  = flag.Duration("claim.ttl", 30*time.Second, "How long a keygen's machine claim lives without heartbeats; 3 of keygen's -claim.heartbeat")
+
+	archiveBucket = flag.String("archive.bucket", os.Getenv("ARCHIVE_BUCKET"), "Cloud Storage bucket to move cold links to; empty archives nothing. Readers need the same ARCHIVE_BUCKET to restore them")
+	archiveAfter  = flag.Duration("archive.after", 180*24*time.Hour, "How long a link must go unused and unchanged to be archived")
+)
+
+func main() {
+	flag.Parse()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	dsClient, err := gcputil.NewDSClient(ctx, os.Getenv("GCP_PROJECT"), os.Getenv("DS_ENDPOINT"), os.Getenv("DS_NAMESPACE"))
+	if err != nil {
+		fmt.Println("Error creating datastore client:", err)
+		os.Exit(1)
+	}
+	var store urlstore.Client = urlstore.NewClient(dsClient)
+
+	// Evict purged keys from the cache too, so readers stop serving them at once.
+	servers, endpoint := os.Getenv("MEMCACHE_SERVERS"), os.Getenv("MEMCACHE_DISCOVERY_ENDPOINT")
+	if err := discovery.ValidateMemcache(servers, endpoint); err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(2)
+	}
+	if memcached := cmp.Or(servers, endpoint); memcached != "" {
+		mc, err := discovery.NewMemcache(servers, endpoint)
+		if err != nil {
+			log.Printf("memcache disabled (init failed for %s): %v", memcached, err)
+		} else {
+			store = urlstore.WithCacheAside(store, mc)
+		}
+	}
+	defer store.Close()
+
+	j := janitor.New(store, janitor.Config{
+		Retention: *retention,
+		BatchSize: *batchSize,
+		DryRun:    *dryRun,
+	})
+
+	var archiver *archive.Archiver
+	if *archiveBucket != "" {
+		bucket, err := archive.NewGCSStore(ctx, *archiveBucket, os.Getenv("DS_NAMESPACE"))
+		if err != nil {
+			fmt.Println("Error creating archive client:", err)
+			os.Exit(1)
+		}
+		archiver = archive.NewArchiver(store, bucket, archive.NewDSUsageStore(dsClient), archive.Config{
+			After:     *archiveAfter,
+			BatchSize: *batchSize,
+			DryRun:    *dryRun,
+		})
+	}
+
+	claims := machineclaim.NewDSStore(dsClient)
+	if *once {
+		auditClaims(ctx, claims)
+		archiveCold(ctx, archiver)
+		res, err := j.Sweep(ctx)
+		if err != nil {
+			fmt.Println("Error during sweep:", err)
+			os.Exit(1)
+		}
+		fmt.Printf("scanned %d, purged %d, failed %d\n", res.Scanned, res.Purged, res.Failed)
+		return
+	}
+	go func() {
+		t := time.NewTicker(*interval)
+		defer t.Stop()
+		for {
+			auditClaims(ctx, claims)
+			archiveCold(ctx, archiver)
+			select {
+			case <-ctx.Done():
+				return
+			case <-t.C:
+			}
+		}
+	}()
+	j.Run(ctx, *interval)
+}
+
+// archiveCold moves the links unused for -archive.after to the archive,
+// if there is one.
+func archiveCold(ctx context.Context, a *archive.Archiver) {
+	if a == nil {
+		return
+	}
+	res, err := a.Sweep(ctx)
+	if err != nil {
+		log.Printf("archive: sweep aborted after %d entries: %v", res.Scanned, err)
+		return
+	}
+	log.Printf("archive: scanned %d, archived %d, failed %d (dry run: %v)", res.Scanned, res.Archived, res.Failed, *dryRun)
+}
+
+// auditClaims logs the keygens' stale and conflicting machine claims and
+// deletes those silent for longer than -retention.
+func auditClaims(ctx context.Context, claims machineclaim.Store) {
+	retention := *retention
+	if *dryRun {
+		retention = 0
+	}
+	gens, err := machineclaim.Audit(ctx, claims, *claimTTL, retention)
+	if err != nil {
+		log.Printf("machine claim: audit failed: %v", err)
+		return
+	}
+	log.Printf("machine claim: audited %d generators", len(gens))
+}
@@ -1,12 +1,29 @@
 package main
 
 import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"log"
 	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/FlorinBalint/shortener/pkg/gcputil"
+	"github.com/FlorinBalint/shortener/pkg/httpmw"
+	"github.com/FlorinBalint/shortener/pkg/keylayout"
+	"github.com/FlorinBalint/shortener/pkg/keyspace"
 	"github.com/FlorinBalint/shortener/pkg/kubeflake"
+	"github.com/FlorinBalint/shortener/pkg/machineclaim"
+	"github.com/FlorinBalint/shortener/pkg/selftest"
+	"github.com/FlorinBalint/shortener/pkg/statusz"
+	"github.com/FlorinBalint/shortener/pkg/tlsutil"
 )
 
 var (
@@ -14,34 +31,265 @@ var (
 	bitsMachine  = flag.Int("bits.machine", 6, "Number of bits for machine ID")
 	bitsSequence = flag.Int("bits.sequence", 11, "Number of bits for sequence ID")
 	bitsCluster  = flag.Int("bits.cluster", 7, "Number of bits for cluster ID")
+	epoch        = flag.String("epoch", "2025-01-01T00:00:00Z", "Start of ID time (RFC 3339); must not be in the future")
+	layoutVer    = flag.Int("layout.version", 0, "Layout version stamped on keys; bump it with any change to -bits.* or -epoch so new keys cannot collide with old ones. 0 mints unstamped keys")
+	forceLayout  = flag.Bool("force-layout", false, "Start even if the layout differs from the one recorded in Datastore, and record this one instead")
+	clusterID    = flag.String("cluster.id", os.Getenv("KEYGEN_CLUSTER_ID"), "Fixed cluster ID, instead of the one derived from the GCP zone, for running off GCP (env KEYGEN_CLUSTER_ID)")
+	machineIDs   = flag.String("machine.id", cmp.Or(os.Getenv("KEYGEN_MACHINE_ID"), "ordinal"), "Where the machine ID comes from: \"ordinal\", the StatefulSet ordinal in the pod name, \"registry\", the lowest machine ID of the cluster free in Datastore, for running as a Deployment, or a fixed number (env KEYGEN_MACHINE_ID)")
+	namespaces   = flag.String("namespaces", os.Getenv("KEYGEN_NAMESPACES_FILE"), "JSON file of the key namespaces served on /generate/v1/{namespace} besides links, each with its own epoch and bit layout (see pkg/keyspace; env KEYGEN_NAMESPACES_FILE)")
+	heartbeat    = flag.Duration("claim.heartbeat", 10*time.Second, "How often to renew this pod's claim on its cluster and machine IDs in Datastore; a claim silent for 3 beats may be taken over")
+
+	tlsCert     = flag.String("tls.cert", "", "Server certificate (PEM); enables mutual TLS")
+	tlsKey      = flag.String("tls.key", "", "Server private key (PEM)")
+	tlsClientCA = flag.String("tls.client_ca", "", "CA bundle (PEM) client certificates must chain to")
+
+	accessLog = flag.Bool("log.access", os.Getenv("ACCESS_LOG") == "true", "Log a line per request (env ACCESS_LOG)")
+
+	statuszAddr = flag.String("statusz.address", ":9093", "Status page listen address, apart from the traffic; \"off\" disables it")
+	checkConfig = flag.Bool("check-config", false, "Check the flags, Datastore access and the recorded key layout, print a report and exit, non-zero on failure; claims no machine ID")
 )
 
+// Set at build time with -ldflags "-X main.version=... -X main.commit=...".
+var (
+	version = "dev"
+	commit  = "none"
+)
+
+// identity is what tells keygen pods' IDs apart.
+type identity struct {
+	Pod       string `json:"pod"`
+	Zone      string `json:"zone"`
+	ClusterID int    `json:"cluster_id"`
+	MachineID int    `json:"machine_id"`
+}
+
 type keygenHandler struct {
 	kubeFlake *kubeflake.Kubeflake
+	// namespaces are the generators by key namespace, links included.
+	namespaces map[string]*kubeflake.Kubeflake
+	identity   identity
+	// requireClientCert rejects key requests without a verified client certificate.
+	requireClientCert bool
 }
 
-func newHandler() (keygenHandler, error) {
+// newHandler creates the handler; client, nil without GCP_PROJECT, holds
+// the machine claims -machine.id=registry allocates from. With checkOnly
+// the registry is left alone and the machine ID is 0.
+func newHandler(client *gcputil.DSClient, checkOnly bool) (keygenHandler, error) {
+	epochTime, err := time.Parse(time.RFC3339, *epoch)
+	if err != nil {
+		return keygenHandler{}, fmt.Errorf("invalid -epoch: %w", err)
+	}
 	statefulSetPod := gcputil.NewStatefulSetPod()
+	clusterIDs := statefulSetPod.ClusterID
+	if *clusterID != "" {
+		n, err := parseID(*clusterID, *bitsCluster)
+		if err != nil {
+			return keygenHandler{}, fmt.Errorf("invalid -cluster.id: %w", err)
+		}
+		clusterIDs = kubeflake.FixedID(n).ID
+	}
+	machineID := statefulSetPod.PodID
+	switch *machineIDs {
+	case "ordinal":
+	case "registry":
+		if client == nil {
+			return keygenHandler{}, errors.New("-machine.id=registry requires GCP_PROJECT")
+		}
+		if *bitsMachine <= 0 {
+			return keygenHandler{}, errors.New("-machine.id=registry requires -bits.machine")
+		}
+		machineID = func() (int, error) { return allocateMachine(client, statefulSetPod, clusterIDs) }
+		if checkOnly {
+			machineID = func() (int, error) { return 0, nil }
+		}
+	default:
+		n, err := parseID(*machineIDs, *bitsMachine)
+		if err != nil {
+			return keygenHandler{}, fmt.Errorf("invalid -machine.id: %w", err)
+		}
+		machineID = kubeflake.FixedID(n).ID
+	}
 	settings := kubeflake.Settings{
 		BitsCluster:  *bitsCluster,
 		BitsMachine:  *bitsMachine,
 		BitsSequence: *bitsSequence,
-		ClusterId:    statefulSetPod.ClusterID,
-		MachineId:    statefulSetPod.PodID,
+		EpochTime:    epochTime,
+		Version:      *layoutVer,
+		ClusterId:    clusterIDs,
+		MachineId:    machineID,
 	}
 
 	kubeFlake, err := kubeflake.New(settings)
 	if err != nil {
 		return keygenHandler{}, fmt.Errorf("failed to create Kubeflake: %w", err)
 	}
+	pod, err := statefulSetPod.PodName()
+	if err != nil {
+		return keygenHandler{}, err
+	}
+	// A derived cluster ID comes from the zone, so this cannot fail now;
+	// with a fixed one there may be no zone, as off GCP.
+	var zone string
+	if *clusterID == "" {
+		zone, _ = gcputil.GCPZone(context.Background())
+	}
+	l := kubeFlake.Layout()
+	generators := map[string]*kubeflake.Kubeflake{keyspace.Links: kubeFlake}
+	if *namespaces != "" {
+		ns, err := keyspace.Load(*namespaces)
+		if err != nil {
+			return keygenHandler{}, err
+		}
+		// The namespaces share the pod's IDs, resolved, or allocated from
+		// the registry, once.
+		settings.ClusterId = kubeflake.FixedID(l.ClusterID).ID
+		settings.MachineId = kubeflake.FixedID(l.MachineID).ID
+		for _, n := range ns {
+			kf, err := kubeflake.New(n.Settings(settings))
+			if err != nil {
+				return keygenHandler{}, fmt.Errorf("key namespace %s: %w", n.Name, err)
+			}
+			generators[n.Name] = kf
+		}
+	}
 
 	return keygenHandler{
-		kubeFlake: kubeFlake,
+		kubeFlake:  kubeFlake,
+		namespaces: generators,
+		identity:   identity{Pod: pod, Zone: zone, ClusterID: l.ClusterID, MachineID: l.MachineID},
 	}, nil
 }
 
-func (h *keygenHandler) generateKey(w http.ResponseWriter, r *http.Request) {
-	key, err := h.kubeFlake.NextKey()
+// layoutStore returns the store of the layout of namespace.
+func layoutStore(client *gcputil.DSClient, namespace string) keylayout.Store {
+	if namespace == keyspace.Links {
+		return keylayout.NewDSStore(client)
+	}
+	return keylayout.NewNamespaceDSStore(client, namespace)
+}
+
+// namespaceNames returns the names of h's namespaces, sorted.
+func (h *keygenHandler) namespaceNames() []string {
+	names := make([]string, 0, len(h.namespaces))
+	for name := range h.namespaces {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// dsClient connects to the Datastore holding the key layout and machine
+// claims, or returns nil without GCP_PROJECT, as when running locally.
+func dsClient() (*gcputil.DSClient, error) {
+	project := os.Getenv("GCP_PROJECT")
+	if project == "" {
+		log.Printf("key layout: GCP_PROJECT unset, not checking the layout or machine IDs")
+		return nil, nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	return gcputil.NewDSClient(ctx, project, os.Getenv("DS_ENDPOINT"), os.Getenv("DS_NAMESPACE"))
+}
+
+// parseID parses an ID set by flag, which must fit in bits.
+func parseID(s string, bits int) (int, error) {
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not a number", s)
+	}
+	if n < 0 || bits > 0 && n >= 1<<bits {
+		return 0, fmt.Errorf("%d does not fit in %d bits", n, bits)
+	}
+	return n, nil
+}
+
+// allocateMachine claims the lowest free machine ID of the pod's cluster.
+func allocateMachine(client *gcputil.DSClient, p *gcputil.StatefulSetPod, clusterID func() (int, error)) (int, error) {
+	cluster, err := clusterID()
+	if err != nil {
+		return 0, err
+	}
+	pod, err := p.PodName()
+	if err != nil {
+		return 0, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	c, err := machineclaim.Allocate(ctx, machineclaim.NewDSStore(client),
+		machineclaim.Claim{ClusterID: cluster, Pod: pod}, 1<<*bitsMachine, 3**heartbeat)
+	if err != nil {
+		return 0, err
+	}
+	log.Printf("machine claim: allocated cluster %d, machine %d to %s", c.ClusterID, c.MachineID, pod)
+	return c.MachineID, nil
+}
+
+// checkLayout compares the layout of each namespace against the one
+// recorded in Datastore, recording it on first boot.
+func checkLayout(client *gcputil.DSClient, h *keygenHandler) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	for _, name := range h.namespaceNames() {
+		l := keylayout.Of(h.namespaces[name].Layout())
+		if err := keylayout.Check(ctx, layoutStore(client, name), l, *forceLayout); err != nil {
+			return fmt.Errorf("namespace %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// claimMachine claims the pod's cluster and machine IDs, failing if
+// another live pod holds them, and keeps the claim alive in the
+// background. Losing it later exits: both pods would mint the same keys.
+func claimMachine(client *gcputil.DSClient, id identity) error {
+	store := machineclaim.NewDSStore(client)
+	c := machineclaim.Claim{ClusterID: id.ClusterID, MachineID: id.MachineID, Pod: id.Pod, Zone: id.Zone}
+	ttl := 3 * *heartbeat
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := machineclaim.Acquire(ctx, store, c, ttl); err != nil {
+		return err
+	}
+	go func() {
+		err := machineclaim.Keep(context.Background(), store, c, *heartbeat, ttl)
+		log.Fatalf("machine claim: lost cluster %d, machine %d: %v", c.ClusterID, c.MachineID, err)
+	}()
+	return nil
+}
+
+// selfTest checks the flags and, with GCP_PROJECT, that Datastore takes
+// writes and that the layout matches the recorded one.
+func selfTest(client *gcputil.DSClient) selftest.Report {
+	var handler keygenHandler
+	checks := []selftest.Check{
+		{Name: "config", Fatal: true, Run: func(ctx context.Context) error {
+			var err error
+			handler, err = newHandler(client, true)
+			return err
+		}},
+	}
+	if client != nil {
+		checks = append(checks,
+			selftest.Check{Name: "datastore", Run: selftest.DatastoreWrite(client)},
+			selftest.Check{Name: "key_layout", Run: func(ctx context.Context) error {
+				for _, name := range handler.namespaceNames() {
+					err := keylayout.Compare(ctx, layoutStore(client, name), keylayout.Of(handler.namespaces[name].Layout()))
+					if errors.Is(err, keylayout.ErrMismatch) && *forceLayout {
+						continue
+					}
+					if err != nil {
+						return fmt.Errorf("namespace %s: %w", name, err)
+					}
+				}
+				return nil
+			}})
+	}
+	return selftest.Run(context.Background(), "keygen", 0, checks...)
+}
+
+func generateKey(w http.ResponseWriter, kf *kubeflake.Kubeflake) {
+	key, err := kf.NextKey()
 	if err != nil {
 		http.Error(w, fmt.Sprintf("failed to generate key: %v", err), http.StatusInternalServerError)
 		return
@@ -59,22 +307,106 @@ func (h *keygenHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	switch r.URL.Path {
 	case "/health":
 		w.WriteHeader(http.StatusOK)
+	case "/identity":
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(h.identity)
 	case "/generate/v1":
-		h.generateKey(w, r)
+		h.serveKey(w, r, h.kubeFlake)
 	default:
-		http.NotFound(w, r)
+		namespace, ok := strings.CutPrefix(r.URL.Path, "/generate/v1/")
+		kf := h.namespaces[namespace]
+		if !ok || kf == nil {
+			http.NotFound(w, r)
+			return
+		}
+		h.serveKey(w, r, kf)
+	}
+}
+
+// serveKey answers with a key of kf.
+func (h *keygenHandler) serveKey(w http.ResponseWriter, r *http.Request, kf *kubeflake.Kubeflake) {
+	if h.requireClientCert {
+		tlsutil.RequireClientCert(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			generateKey(w, kf)
+		})).ServeHTTP(w, r)
+		return
 	}
+	generateKey(w, kf)
 }
 
 func main() {
-	handler, err := newHandler()
+	flag.Parse()
+	client, err := dsClient()
+	if err != nil {
+		fmt.Println("Error connecting to Datastore:", err)
+		os.Exit(1)
+	}
+	if *checkConfig {
+		report := selfTest(client)
+		report.Print(os.Stdout)
+		if !report.OK() {
+			os.Exit(1)
+		}
+		return
+	}
+	gcputil.ResolveLocation(context.Background()).SetLogPrefix()
+	handler, err := newHandler(client, false)
 	if err != nil {
 		fmt.Println("Error creating handler:", err)
 		return
 	}
+	if client != nil {
+		if err := checkLayout(client, &handler); err != nil {
+			fmt.Println("Error checking key layout:", err)
+			fmt.Println("Run with -force-layout to replace the recorded layout.")
+			os.Exit(1)
+		}
+		if err := claimMachine(client, handler.identity); err != nil {
+			fmt.Println("Error claiming machine IDs:", err)
+			if errors.Is(err, machineclaim.ErrDuplicate) {
+				fmt.Println("Another keygen runs with the same IDs; check its pod name and zone.")
+			}
+			os.Exit(1)
+		}
+	}
+
+	status := statusz.New("keygen", statusz.NewBuild(version, commit))
+	flags := make(map[string]string)
+	flag.VisitAll(func(f *flag.Flag) { flags[f.Name] = f.Value.String() })
+	status.Set("config", flags)
+	status.Set("bit_layout", statusz.Redact(handler.kubeFlake.Layout()))
+	if len(handler.namespaces) > 1 {
+		layouts := make(map[string]any, len(handler.namespaces))
+		for name, kf := range handler.namespaces {
+			layouts[name] = statusz.Redact(kf.Layout())
+		}
+		status.Set("namespaces", layouts)
+	}
+	status.Set("identity", handler.identity)
+	statusz.Serve(*statuszAddr, status)
 
-	http.Handle("/", &handler)
-	if err := http.ListenAndServe(*listenAddr, nil); err != nil {
+	common, err := httpmw.Common(httpmw.Options{Service: "keygen", AccessLog: *accessLog})
+	if err != nil {
+		fmt.Println("Error creating middlewares:", err)
+		return
+	}
+	files := tlsutil.Files{CertFile: *tlsCert, KeyFile: *tlsKey, CAFile: *tlsClientCA}
+	if !files.Enabled() {
+		http.Handle("/", common(&handler))
+		if err := http.ListenAndServe(*listenAddr, nil); err != nil {
+			panic(err)
+		}
+		return
+	}
+
+	tlsConfig, err := tlsutil.ServerConfig(files)
+	if err != nil {
+		fmt.Println("Error configuring TLS:", err)
+		return
+	}
+	handler.requireClientCert = true
+	srv := &http.Server{Addr: *listenAddr, Handler: common(&handler), TLSConfig: tlsConfig}
+	if err := srv.ListenAndServeTLS("", ""); err != nil {
 		panic(err)
 	}
 }
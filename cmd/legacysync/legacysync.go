@@ -0,0 +1,178 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/FlorinBalint/shortener/pkg/discovery"
+	"github.com/FlorinBalint/shortener/pkg/gcputil"
+	"github.com/FlorinBalint/shortener/pkg/legacysync"
+	"github.com/FlorinBalint/shortener/pkg/urlstore"
+)
+
+var (
+	provider   = flag.String("provider", "", "Legacy shortener to sync from: bitly or rebrandly")
+	domain     = flag.String("domain", "", "Only sync the links of this legacy domain, e.g. bit.ly; empty syncs every domain")
+	bitlyGroup = flag.String("bitly.group", os.Getenv("BITLY_GROUP"), "GUID of the Bitly group to sync")
+	interval   = flag.Duration("interval", 5*time.Minute, "Time between syncs")
+	once       = flag.Bool("once", false, "Sync once and exit (e.g. when run as a CronJob)")
+	dryRun     = flag.Bool("dry_run", false, "Only report what would be synced")
+	full       = flag.Bool("full", false, "Ignore the saved checkpoint and go over every legacy link again")
+	conflicts  = flag.String("conflicts", "", "File to append conflicts to, as JSON lines; empty prints them")
+)
+
+func main() {
+	flag.Parse()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	p, err := newProvider(ctx)
+	if err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(2)
+	}
+
+	dsClient, err := gcputil.NewDSClient(ctx, os.Getenv("GCP_PROJECT"), os.Getenv("DS_ENDPOINT"), os.Getenv("DS_NAMESPACE"))
+	if err != nil {
+		fmt.Println("Error creating datastore client:", err)
+		os.Exit(1)
+	}
+	var store urlstore.Client = urlstore.NewClient(dsClient)
+
+	// Evict updated keys from the cache too, so readers follow the new targets at once.
+	servers, endpoint := os.Getenv("MEMCACHE_SERVERS"), os.Getenv("MEMCACHE_DISCOVERY_ENDPOINT")
+	if err := discovery.ValidateMemcache(servers, endpoint); err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(2)
+	}
+	if memcached := cmp.Or(servers, endpoint); memcached != "" {
+		mc, err := discovery.NewMemcache(servers, endpoint)
+		if err != nil {
+			log.Printf("memcache disabled (init failed for %s): %v", memcached, err)
+		} else {
+			store = urlstore.WithCacheAside(store, mc)
+		}
+	}
+	// Targets are encrypted like the writer does, if it does.
+	setup := urlstore.Setup{TargetKMSKey: os.Getenv("TARGET_KMS_KEY"), TargetWrappedKey: os.Getenv("TARGET_WRAPPED_KEY")}
+	targets, err := setup.TargetCipher(ctx)
+	if err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(2)
+	}
+	store = urlstore.Encrypted(store, targets)
+	defer store.Close()
+
+	checkpoints := legacysync.NewDSCheckpoints(dsClient)
+	cfg := legacysync.Config{DryRun: *dryRun, Full: *full}
+	if *once {
+		if !syncAndReport(ctx, legacysync.New(p, store, checkpoints, cfg), p.Name()) {
+			os.Exit(1)
+		}
+		return
+	}
+	t := time.NewTicker(*interval)
+	defer t.Stop()
+	for {
+		syncAndReport(ctx, legacysync.New(p, store, checkpoints, cfg), p.Name())
+		// Only the first sync is full; the next ones pick up after it.
+		cfg.Full = false
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// newProvider returns the provider selected by -provider, with its
+// credentials from BITLY_TOKEN or REBRANDLY_API_KEY, which may be secret
+// references (see gcputil.ResolveSecret).
+func newProvider(ctx context.Context) (legacysync.Provider, error) {
+	switch *provider {
+	case "bitly":
+		if *bitlyGroup == "" {
+			return nil, fmt.Errorf("-bitly.group is required")
+		}
+		token, err := credential(ctx, "BITLY_TOKEN")
+		if err != nil {
+			return nil, err
+		}
+		return &legacysync.Bitly{Token: token, Group: *bitlyGroup, Domain: *domain, Client: httpClient}, nil
+	case "rebrandly":
+		key, err := credential(ctx, "REBRANDLY_API_KEY")
+		if err != nil {
+			return nil, err
+		}
+		return &legacysync.Rebrandly{APIKey: key, Domain: *domain, Client: httpClient}, nil
+	}
+	return nil, fmt.Errorf("-provider must be bitly or rebrandly, got %q", *provider)
+}
+
+// credential resolves the secret named by the env var k.
+func credential(ctx context.Context, k string) (string, error) {
+	ref := os.Getenv(k)
+	if ref == "" {
+		return "", fmt.Errorf("%s is required", k)
+	}
+	v, err := gcputil.ResolveSecret(ctx, ref)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", k, err)
+	}
+	return v, nil
+}
+
+// httpClient calls the legacy APIs.
+var httpClient = &http.Client{Timeout: 30 * time.Second}
+
+// syncAndReport runs a sync, logs its result and reports its conflicts.
+// It returns whether everything was synced or reported.
+func syncAndReport(ctx context.Context, r *legacysync.Runner, name string) bool {
+	start := time.Now()
+	res, err := r.Run(ctx)
+	log.Printf("legacysync: %s: scanned %d, created %d, updated %d, unchanged %d, conflicts %d, failed %d in %v (dry run: %v)",
+		name, res.Scanned, res.Created, res.Updated, res.Unchanged, len(res.Conflicts), res.Failed, time.Since(start), *dryRun)
+	if rerr := report(res.Conflicts); rerr != nil {
+		log.Printf("legacysync: reporting conflicts failed: %v", rerr)
+		return false
+	}
+	if err != nil {
+		log.Printf("legacysync: %s: sync aborted (the next one resumes from the last checkpoint): %v", name, err)
+		return false
+	}
+	return res.Failed == 0
+}
+
+// report appends conflicts to -conflicts, or prints them.
+func report(cs []legacysync.Conflict) error {
+	if len(cs) == 0 {
+		return nil
+	}
+	var w io.Writer = os.Stdout
+	if *conflicts != "" {
+		f, err := os.OpenFile(*conflicts, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	enc := json.NewEncoder(w)
+	for _, c := range cs {
+		if err := enc.Encode(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/FlorinBalint/shortener/pkg/loadgen"
+)
+
+var (
+	writerURL    = flag.String("writer", os.Getenv("LOADGEN_WRITER_URL"), "Base URL of the writer, e.g. https://sho.rt (env LOADGEN_WRITER_URL)")
+	readerURL    = flag.String("reader", os.Getenv("LOADGEN_READER_URL"), "Base URL short links are served on; empty uses -writer (env LOADGEN_READER_URL)")
+	mix          = flag.String("mix", "write=1,hot=8,miss=1", "Weights of link creates, hot link reads and random miss reads")
+	rate         = flag.Float64("rate", 50, "Requests sent per second, whatever the latency")
+	duration     = flag.Duration("duration", time.Minute, "How long the traffic is sent")
+	workers      = flag.Int("workers", loadgen.DefaultWorkers, "Bound of the requests in flight; arrivals beyond it are dropped and counted")
+	hotKeys      = flag.Int("hot.keys", loadgen.DefaultHotKeys, "Hot links created before the run")
+	hotSkew      = flag.Float64("hot.skew", loadgen.DefaultHotSkew, "Zipf exponent of the hot link reads, above 1; the larger, the more go to the hottest")
+	target       = flag.String("target", "https://example.com/", "URL the created links redirect to")
+	linkTTL      = flag.Duration("link.ttl", loadgen.DefaultLinkTTL, "How long the created links live before the janitor removes them")
+	maxErrorRate = flag.Float64("max.error.rate", 0.01, "Error rate of any op above which the run fails")
+	format       = flag.String("format", "text", "Report format: text or json")
+	out          = flag.String("out", "-", "File the report is written to, or - for stdout")
+)
+
+func main() {
+	flag.Parse()
+	if *writerURL == "" {
+		fmt.Println("Error: -writer is required")
+		os.Exit(2)
+	}
+	if *format != "text" && *format != "json" {
+		fmt.Println("Error: -format must be text or json, not", *format)
+		os.Exit(2)
+	}
+	m, err := loadgen.ParseMix(*mix)
+	if err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(2)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Credentials come from the environment only, out of process listings.
+	report, err := loadgen.Run(ctx, loadgen.Config{
+		WriterURL: *writerURL,
+		ReaderURL: *readerURL,
+		APIKey:    os.Getenv("LOADGEN_API_KEY"),
+		Token:     os.Getenv("LOADGEN_TOKEN"),
+		Rate:      *rate,
+		Duration:  *duration,
+		Workers:   *workers,
+		Mix:       m,
+		HotKeys:   *hotKeys,
+		HotSkew:   *hotSkew,
+		Target:    *target,
+		LinkTTL:   *linkTTL,
+	})
+	if err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(1)
+	}
+
+	var buf bytes.Buffer
+	if *format == "json" {
+		err = report.WriteJSON(&buf)
+	} else {
+		report.Print(&buf)
+	}
+	if err == nil {
+		if *out == "-" {
+			_, err = os.Stdout.Write(buf.Bytes())
+		} else {
+			err = os.WriteFile(*out, buf.Bytes(), 0o644)
+		}
+	}
+	if err != nil {
+		fmt.Println("Error writing the report:", err)
+		os.Exit(1)
+	}
+	if *out != "-" && *format != "text" {
+		report.Print(os.Stderr)
+	}
+	if !report.OK(*maxErrorRate) {
+		os.Exit(1)
+	}
+}
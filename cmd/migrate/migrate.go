@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/FlorinBalint/shortener/pkg/gcputil"
+	"github.com/FlorinBalint/shortener/pkg/migrate"
+	"github.com/FlorinBalint/shortener/pkg/urlstore"
+)
+
+var (
+	batchSize = flag.Int("batch.size", 500, "Entries scanned per store page")
+	dryRun    = flag.Bool("dry_run", false, "Only report how many entries need migrating")
+	restart   = flag.Bool("restart", false, "Ignore the saved checkpoint and scan the whole store again")
+	list      = flag.Bool("list", false, "List the migrations and exit")
+)
+
+func main() {
+	flag.Parse()
+
+	if *list {
+		for _, m := range urlstore.Migrations {
+			fmt.Printf("v%d -> v%d: %s\n", m.From, m.From+1, m.Description)
+		}
+		return
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	dsClient, err := gcputil.NewDSClient(ctx, os.Getenv("GCP_PROJECT"), os.Getenv("DS_ENDPOINT"), os.Getenv("DS_NAMESPACE"))
+	if err != nil {
+		fmt.Println("Error creating datastore client:", err)
+		os.Exit(1)
+	}
+	// The redirect cache only holds targets, which migrations leave alone,
+	// so the store is rewritten without evicting it.
+	store := urlstore.NewClient(dsClient)
+	defer store.Close()
+
+	r := migrate.New(store, migrate.NewDSCheckpoints(dsClient), migrate.Config{
+		BatchSize: *batchSize,
+		DryRun:    *dryRun,
+		Restart:   *restart,
+	})
+	res, err := r.Run(ctx)
+	fmt.Printf("schema v%d: scanned %d, migrated %d, skipped %d, failed %d (dry run: %v)\n",
+		urlstore.CurrentSchemaVersion, res.Scanned, res.Migrated, res.Skipped, res.Failed, *dryRun)
+	if err != nil {
+		fmt.Println("Error during migration (rerun to resume from the last checkpoint):", err)
+		os.Exit(1)
+	}
+	if res.Failed > 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/FlorinBalint/shortener/pkg/smoke"
+)
+
+var (
+	writerURL   = flag.String("writer", os.Getenv("SMOKE_WRITER_URL"), "Base URL of the writer, e.g. https://sho.rt (env SMOKE_WRITER_URL)")
+	readerURL   = flag.String("reader", os.Getenv("SMOKE_READER_URL"), "Base URL short links are served on; empty uses -writer (env SMOKE_READER_URL)")
+	target      = flag.String("target", "https://example.com/", "URL the smoke link redirects to; each run adds its own smoke query parameter")
+	campaign    = flag.String("campaign", "", "Existing campaign the link joins, whose stats must count it; empty skips the check")
+	wait        = flag.Duration("wait", smoke.DefaultWait, "How long a create or delete may take to reach the reader")
+	stepTimeout = flag.Duration("step.timeout", time.Minute, "Bound of each step, waits included")
+	format      = flag.String("format", "text", "Report format: text, json or junit")
+	out         = flag.String("out", "-", "File the report is written to, or - for stdout")
+)
+
+func main() {
+	flag.Parse()
+	if *writerURL == "" {
+		fmt.Println("Error: -writer is required")
+		os.Exit(2)
+	}
+	if *format != "text" && *format != "json" && *format != "junit" {
+		fmt.Println("Error: -format must be text, json or junit, not", *format)
+		os.Exit(2)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Credentials come from the environment only, out of process listings.
+	report := smoke.Run(ctx, smoke.Config{
+		WriterURL: *writerURL,
+		ReaderURL: *readerURL,
+		APIKey:    os.Getenv("SMOKE_API_KEY"),
+		Token:     os.Getenv("SMOKE_TOKEN"),
+		Target:    *target,
+		Campaign:  *campaign,
+		Wait:      *wait,
+	}, *stepTimeout)
+
+	var buf bytes.Buffer
+	var err error
+	switch *format {
+	case "json":
+		err = report.WriteJSON(&buf)
+	case "junit":
+		err = report.WriteJUnit(&buf)
+	default:
+		report.Print(&buf)
+	}
+	if err == nil {
+		if *out == "-" {
+			_, err = os.Stdout.Write(buf.Bytes())
+		} else {
+			err = os.WriteFile(*out, buf.Bytes(), 0o644)
+		}
+	}
+	if err != nil {
+		fmt.Println("Error writing the report:", err)
+		os.Exit(1)
+	}
+	if *out != "-" && *format != "text" {
+		report.Print(os.Stderr)
+	}
+	if !report.OK() {
+		os.Exit(1)
+	}
+}
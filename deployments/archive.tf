@@ -0,0 +1,26 @@
+# Archive of cold links (pkg/archive). The janitor, run with
+# -archive.bucket, moves links unused for -archive.after here; readers
+# record the links they serve and restore archived ones on access, so they
+# need Datastore write access besides reading the bucket. The janitor's
+# identity needs roles/storage.objectAdmin on the bucket.
+
+resource "google_storage_bucket" "archive" {
+  project                     = local.actual_project
+  name                        = "${local.actual_project}-${var.app_name}-archive"
+  location                    = local.derived_region
+  storage_class               = "COLDLINE"
+  uniform_bucket_level_access = true
+  labels                      = { app = var.app_name }
+}
+
+resource "google_storage_bucket_iam_member" "reader_archive" {
+  bucket = google_storage_bucket.archive.name
+  role   = "roles/storage.objectViewer"
+  member = "serviceAccount:${google_service_account.reader-sa.email}"
+}
+
+resource "google_project_iam_member" "reader_datastore_write" {
+  project = local.actual_project
+  role    = "roles/datastore.user"
+  member  = "serviceAccount:${google_service_account.reader-sa.email}"
+}
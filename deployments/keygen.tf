@@ -61,13 +61,20 @@ resource "kubernetes_service" "keygen-svc" {
   ]
 }
 
-# Google SA for keygen (no project roles granted)
+# Google SA for keygen
 resource "google_service_account" "keygen_sa" {
   project      = local.actual_project
   account_id   = "${var.app_name}-keygen"
   display_name = "Keygen Workload Identity"
 }
 
+# Keygen records its ID layout and claims its machine IDs in Datastore
+resource "google_project_iam_member" "keygen_datastore" {
+  project = local.actual_project
+  role    = "roles/datastore.user"
+  member  = "serviceAccount:${google_service_account.keygen_sa.email}"
+}
+
 # Kubernetes SA for keygen, annotated for Workload Identity
 resource "kubernetes_service_account" "keygen" {
   metadata {
@@ -125,6 +132,14 @@ resource "kubernetes_stateful_set" "keygen" {
           image             = "${local.reg_region}-docker.pkg.dev/${local.actual_project}/${var.repo}/${local.keygen_image}:${var.image_tag}"
           image_pull_policy = "Always"
           args              = (length(var.container_args) > 0 ? var.container_args : ["-address=:8083"])
+          env {
+            name  = "GCP_PROJECT"
+            value = local.actual_project
+          }
+          env {
+            name  = "DS_ENDPOINT"
+            value = var.ds_endpoint
+          }
           port {
             name           = "http"
             container_port = local.keygen_pod_port
@@ -91,6 +91,9 @@ resource "kubernetes_deployment" "reader" {
       spec {
         service_account_name = kubernetes_service_account.reader.metadata[0].name
 
+        # Lame duck (LAME_DUCK_DELAY, 10s) plus draining (DRAIN_TIMEOUT, 15s).
+        termination_grace_period_seconds = 30
+
         container {
           name              = "reader"
           image             = "${local.reg_region}-docker.pkg.dev/${local.actual_project}/${var.repo}/${local.reader_image}:${var.image_tag}"
@@ -111,6 +114,11 @@ resource "kubernetes_deployment" "reader" {
             value = ":8080"
           }
 
+          env {
+            name  = "ARCHIVE_BUCKET"
+            value = google_storage_bucket.archive.name
+          }
+
           /*
           env {
             name = "MEMCACHE_DISCOVERY_ENDPOINT"
@@ -125,7 +133,7 @@ resource "kubernetes_deployment" "reader" {
 
           readiness_probe {
             http_get {
-              path = "/health"
+              path = "/ready"
               port = local.reader_port
             }
             initial_delay_seconds = 3
@@ -29,6 +29,10 @@ else
   gcloud firestore databases create --region="$REGION" --type=datastore-mode
 fi
 
+# === COMPOSITE INDEXES ===
+SCRIPT_DIR="$(cd "$(dirname "${BASH_SOURCE[0]}")" && pwd)"
+gcloud datastore indexes create "${SCRIPT_DIR}/index.yaml" --quiet
+
 # === BACKUP BUCKET ===
 if gsutil ls -b "$BACKUP_BUCKET" >/dev/null 2>&1; then
   echo "Bucket $BACKUP_BUCKET already exists."
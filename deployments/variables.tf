@@ -162,6 +162,12 @@ variable "ssl_domains" {
   default     = []
 }
 
+variable "async_writes" {
+  description = "Answer creates with 202 once queued on Pub/Sub, applying them in the background (see deployments/writequeue.tf)."
+  type        = bool
+  default     = false
+}
+
 # Zones where your cluster runs. Leave empty to use the cluster location only.
 variable "lb_zones" {
   description = "Zones to look for standalone NEGs. If empty, defaults to [var.location]."
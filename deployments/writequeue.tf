@@ -0,0 +1,79 @@
+# Queue of link creations for the writer's async mode (ASYNC_WRITES).
+# Every writer pulls from the one subscription; failed creates are retried
+# with backoff, then parked on the dead-letter topic.
+
+resource "google_pubsub_topic" "write_queue" {
+  project = local.actual_project
+  name    = "${var.app_name}-writes"
+  labels  = { app = var.app_name }
+}
+
+resource "google_pubsub_topic" "write_queue_dead_letter" {
+  project = local.actual_project
+  name    = "${var.app_name}-writes-dead-letter"
+  labels  = { app = var.app_name }
+}
+
+resource "google_pubsub_subscription" "write_queue" {
+  project = local.actual_project
+  name    = "${var.app_name}-writes-writer"
+  topic   = google_pubsub_topic.write_queue.id
+
+  ack_deadline_seconds       = 30
+  message_retention_duration = "604800s"
+
+  retry_policy {
+    minimum_backoff = "1s"
+    maximum_backoff = "60s"
+  }
+
+  dead_letter_policy {
+    dead_letter_topic     = google_pubsub_topic.write_queue_dead_letter.id
+    max_delivery_attempts = 20
+  }
+}
+
+resource "google_pubsub_subscription" "write_queue_dead_letter" {
+  project = local.actual_project
+  name    = "${var.app_name}-writes-dead-letter"
+  topic   = google_pubsub_topic.write_queue_dead_letter.id
+
+  message_retention_duration = "604800s"
+}
+
+resource "google_pubsub_topic_iam_member" "writer_publish" {
+  project = local.actual_project
+  topic   = google_pubsub_topic.write_queue.name
+  role    = "roles/pubsub.publisher"
+  member  = "serviceAccount:${google_service_account.writer-sa.email}"
+}
+
+resource "google_pubsub_subscription_iam_member" "writer_subscribe" {
+  project      = local.actual_project
+  subscription = google_pubsub_subscription.write_queue.name
+  role         = "roles/pubsub.subscriber"
+  member       = "serviceAccount:${google_service_account.writer-sa.email}"
+}
+
+# Pub/Sub forwards undeliverable messages with its own service agent.
+data "google_project" "current" {
+  project_id = local.actual_project
+}
+
+locals {
+  pubsub_agent = "serviceAccount:service-${data.google_project.current.number}@gcp-sa-pubsub.iam.gserviceaccount.com"
+}
+
+resource "google_pubsub_topic_iam_member" "dead_letter_publish" {
+  project = local.actual_project
+  topic   = google_pubsub_topic.write_queue_dead_letter.name
+  role    = "roles/pubsub.publisher"
+  member  = local.pubsub_agent
+}
+
+resource "google_pubsub_subscription_iam_member" "dead_letter_subscribe" {
+  project      = local.actual_project
+  subscription = google_pubsub_subscription.write_queue.name
+  role         = "roles/pubsub.subscriber"
+  member       = local.pubsub_agent
+}
@@ -97,6 +97,9 @@ resource "kubernetes_deployment" "writer" {
       spec {
         service_account_name = kubernetes_service_account.writer.metadata[0].name
 
+        # Lame duck (LAME_DUCK_DELAY, 10s) plus draining (DRAIN_TIMEOUT, 15s).
+        termination_grace_period_seconds = 30
+
         container {
           name              = "writer"
           image             = "${local.reg_region}-docker.pkg.dev/${local.actual_project}/${var.repo}/${local.writer_image}:${var.image_tag}"
@@ -121,10 +124,22 @@ resource "kubernetes_deployment" "writer" {
             name  = "BIND_ADDR"
             value = ":8081"
           }
+          env {
+            name  = "ASYNC_WRITES"
+            value = tostring(var.async_writes)
+          }
+          env {
+            name  = "WRITE_QUEUE_TOPIC"
+            value = google_pubsub_topic.write_queue.id
+          }
+          env {
+            name  = "WRITE_QUEUE_SUBSCRIPTION"
+            value = google_pubsub_subscription.write_queue.id
+          }
 
           readiness_probe {
             http_get {
-              path = "/health"
+              path = "/ready"
               port = local.writer_port
             }
             initial_delay_seconds = 3
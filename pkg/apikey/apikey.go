@@ -0,0 +1,177 @@
+// Package apikey manages the API keys clients send to the writer: issuing,
+// rotating and freezing them, and authenticating requests.
+//
+// A key's secret has the form "<id>.<random>", so the key record can be
+// looked up by ID; only a SHA-256 of the secret is stored.
+package apikey
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/datastore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/FlorinBalint/shortener/pkg/gcputil"
+)
+
+// Errors returned by stores and the Registry.
+var (
+	ErrNotFound      = errors.New("api key not found")
+	ErrAlreadyExists = errors.New("api key already exists")
+	// ErrInvalid is returned for malformed, unknown or outdated secrets.
+	ErrInvalid = errors.New("invalid api key")
+	ErrFrozen  = errors.New("api key is frozen")
+)
+
+// Key is the stored record of an API key.
+type Key struct {
+	ID   string `json:"id"`
+	Name string `json:"name,omitempty"`
+	// Tenant the key writes to, in multi-tenant deployments.
+	Tenant string `json:"tenant,omitempty"`
+	// SecretHash is the hex SHA-256 of the current secret.
+	SecretHash string `json:"secret_hash"`
+	// Frozen keys are rejected until unfrozen.
+	Frozen bool `json:"frozen"`
+	// Version is bumped on every change, to invalidate replica caches.
+	Version   int64     `json:"version"`
+	CreatedAt time.Time `json:"created_at"`
+	RotatedAt time.Time `json:"rotated_at,omitzero"`
+}
+
+// Store persists API keys by ID.
+type Store interface {
+	// Create stores a new key and fails with ErrAlreadyExists if the ID is taken.
+	Create(ctx context.Context, key Key) error
+	// Get returns the key with id, or ErrNotFound.
+	Get(ctx context.Context, id string) (Key, error)
+	// Update atomically applies update to the key with id, or returns ErrNotFound.
+	Update(ctx context.Context, id string, update func(*Key) error) error
+	// List returns all keys ordered by ID.
+	List(ctx context.Context) ([]Key, error)
+}
+
+// DSStore stores API keys in Datastore, under kind "api_key".
+type DSStore struct {
+	client *gcputil.DSClient
+}
+
+var _ Store = (*DSStore)(nil)
+
+func NewDSStore(client *gcputil.DSClient) *DSStore {
+	return &DSStore{client: client}
+}
+
+// Create implements Store.
+func (s *DSStore) Create(ctx context.Context, key Key) error {
+	return mapDSError(gcputil.PutNewValue(s.client, ctx, "api_key", key.ID, key))
+}
+
+// Get implements Store.
+func (s *DSStore) Get(ctx context.Context, id string) (Key, error) {
+	key, err := gcputil.GetValue[Key](s.client, ctx, "api_key", id)
+	return key, mapDSError(err)
+}
+
+// Update implements Store.
+func (s *DSStore) Update(ctx context.Context, id string, update func(*Key) error) error {
+	return mapDSError(gcputil.UpdateValue(s.client, ctx, "api_key", id, update))
+}
+
+// List implements Store. Key counts are expected to stay small.
+func (s *DSStore) List(ctx context.Context) ([]Key, error) {
+	var keys []Key
+	q := gcputil.ListQuery{Limit: 500}
+	for {
+		values, next, err := gcputil.ListValues[Key](s.client, ctx, "api_key", q)
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range values {
+			keys = append(keys, v.Value)
+		}
+		if next == "" {
+			return keys, nil
+		}
+		q.Cursor = next
+	}
+}
+
+func mapDSError(err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, datastore.ErrNoSuchEntity):
+		return ErrNotFound
+	case status.Code(err) == codes.AlreadyExists:
+		return ErrAlreadyExists
+	default:
+		return err
+	}
+}
+
+// MemoryStore is an in-process Store, for tests and local development.
+type MemoryStore struct {
+	mu   sync.Mutex
+	keys map[string]Key
+}
+
+var _ Store = (*MemoryStore)(nil)
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{keys: make(map[string]Key)}
+}
+
+// Create implements Store.
+func (s *MemoryStore) Create(ctx context.Context, key Key) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.keys[key.ID]; ok {
+		return ErrAlreadyExists
+	}
+	s.keys[key.ID] = key
+	return nil
+}
+
+// Get implements Store.
+func (s *MemoryStore) Get(ctx context.Context, id string) (Key, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key, ok := s.keys[id]
+	if !ok {
+		return Key{}, ErrNotFound
+	}
+	return key, nil
+}
+
+// Update implements Store.
+func (s *MemoryStore) Update(ctx context.Context, id string, update func(*Key) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key, ok := s.keys[id]
+	if !ok {
+		return ErrNotFound
+	}
+	if err := update(&key); err != nil {
+		return err
+	}
+	s.keys[id] = key
+	return nil
+}
+
+// List implements Store.
+func (s *MemoryStore) List(ctx context.Context) ([]Key, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys := make([]Key, 0, len(s.keys))
+	for _, k := range s.keys {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].ID < keys[j].ID })
+	return keys, nil
+}
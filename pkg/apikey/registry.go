@@ -0,0 +1,237 @@
+package apikey
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/gomemcache/memcache"
+)
+
+// Cache is the subset of the memcache client used to share key versions
+// between replicas. *memcache.Client satisfies it.
+type Cache interface {
+	Get(key string) (*memcache.Item, error)
+	Set(item *memcache.Item) error
+	Add(item *memcache.Item) error
+}
+
+var _ Cache = (*memcache.Client)(nil)
+
+// defaultLocalTTL bounds how long a replica trusts its copy of a key when
+// the shared cache cannot confirm it.
+const defaultLocalTTL = time.Minute
+
+type localKey struct {
+	key       Key
+	fetchedAt time.Time
+}
+
+// Registry issues and authenticates API keys.
+//
+// Each replica keeps keys in process. Changes made through the Registry
+// publish the key's version in the shared cache, and replicas reload a key
+// whose cached version no longer matches, so freezing takes effect on every
+// replica at its next request. Without a shared cache, or when it fails,
+// a replica's copy is trusted for up to one minute.
+type Registry struct {
+	store Store
+	cache Cache // nil without a shared cache
+
+	mu    sync.Mutex
+	local map[string]localKey
+	ttl   time.Duration
+	now   func() time.Time
+}
+
+// NewRegistry returns a registry over store, invalidating replica caches
+// through cache, which may be nil.
+func NewRegistry(store Store, cache Cache) *Registry {
+	return &Registry{
+		store: store,
+		cache: cache,
+		local: make(map[string]localKey),
+		ttl:   defaultLocalTTL,
+		now:   time.Now,
+	}
+}
+
+// versionKey names the shared cache item holding a key's version. The colon
+// keeps it apart from URL keys, which cannot contain one.
+func versionKey(id string) string {
+	return "apikey:" + id
+}
+
+func newSecret(id string) (secret, hash string, err error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	secret = id + "." + base64.RawURLEncoding.EncodeToString(b)
+	return secret, hashSecret(secret), nil
+}
+
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// Issue creates a key for tenant, which is empty in single-tenant
+// deployments, and returns it with its secret, which is not stored and
+// cannot be retrieved later.
+func (r *Registry) Issue(ctx context.Context, name, tenant string) (Key, string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return Key{}, "", err
+	}
+	id := hex.EncodeToString(b)
+	secret, hash, err := newSecret(id)
+	if err != nil {
+		return Key{}, "", err
+	}
+	key := Key{
+		ID:         id,
+		Name:       name,
+		Tenant:     tenant,
+		SecretHash: hash,
+		Version:    1,
+		CreatedAt:  r.now().UTC(),
+	}
+	if err := r.store.Create(ctx, key); err != nil {
+		return Key{}, "", err
+	}
+	return key, secret, nil
+}
+
+// Rotate replaces the secret of key id; the previous secret stops working.
+func (r *Registry) Rotate(ctx context.Context, id string) (Key, string, error) {
+	secret, hash, err := newSecret(id)
+	if err != nil {
+		return Key{}, "", err
+	}
+	key, err := r.update(ctx, id, func(k *Key) {
+		k.SecretHash = hash
+		k.RotatedAt = r.now().UTC()
+	})
+	if err != nil {
+		return Key{}, "", err
+	}
+	return key, secret, nil
+}
+
+// SetFrozen freezes or unfreezes key id.
+func (r *Registry) SetFrozen(ctx context.Context, id string, frozen bool) (Key, error) {
+	return r.update(ctx, id, func(k *Key) { k.Frozen = frozen })
+}
+
+// Get returns key id, as cached for Authenticate, or ErrNotFound.
+func (r *Registry) Get(ctx context.Context, id string) (Key, error) {
+	return r.lookup(ctx, id)
+}
+
+// List returns all keys.
+func (r *Registry) List(ctx context.Context) ([]Key, error) {
+	return r.store.List(ctx)
+}
+
+func (r *Registry) update(ctx context.Context, id string, change func(*Key)) (Key, error) {
+	var updated Key
+	err := r.store.Update(ctx, id, func(k *Key) error {
+		change(k)
+		k.Version++
+		updated = *k
+		return nil
+	})
+	if err != nil {
+		return Key{}, err
+	}
+	r.mu.Lock()
+	r.local[id] = localKey{key: updated, fetchedAt: r.now()}
+	r.mu.Unlock()
+	if r.cache != nil {
+		if err := r.cache.Set(versionItem(updated)); err != nil {
+			log.Printf("apikey: publish version of %s: %v", id, err)
+		}
+	}
+	return updated, nil
+}
+
+func versionItem(key Key) *memcache.Item {
+	return &memcache.Item{
+		Key:   versionKey(key.ID),
+		Value: []byte(strconv.FormatInt(key.Version, 10)),
+	}
+}
+
+// Authenticate returns the key a secret belongs to. It fails with
+// ErrInvalid for unknown or rotated secrets and ErrFrozen for frozen keys.
+func (r *Registry) Authenticate(ctx context.Context, secret string) (Key, error) {
+	id, _, ok := strings.Cut(secret, ".")
+	if !ok || id == "" {
+		return Key{}, ErrInvalid
+	}
+	key, err := r.lookup(ctx, id)
+	if errors.Is(err, ErrNotFound) {
+		return Key{}, ErrInvalid
+	}
+	if err != nil {
+		return Key{}, err
+	}
+	if subtle.ConstantTimeCompare([]byte(hashSecret(secret)), []byte(key.SecretHash)) != 1 {
+		return Key{}, ErrInvalid
+	}
+	if key.Frozen {
+		return key, ErrFrozen
+	}
+	return key, nil
+}
+
+// lookup returns key id from the replica cache while the shared cache
+// confirms its version, and from the store otherwise.
+func (r *Registry) lookup(ctx context.Context, id string) (Key, error) {
+	r.mu.Lock()
+	cached, ok := r.local[id]
+	r.mu.Unlock()
+	if ok && r.current(cached) {
+		return cached.key, nil
+	}
+
+	key, err := r.store.Get(ctx, id)
+	if err != nil {
+		return Key{}, err
+	}
+	r.mu.Lock()
+	r.local[id] = localKey{key: key, fetchedAt: r.now()}
+	r.mu.Unlock()
+	if r.cache != nil {
+		// Only fill a missing version: a concurrent change may have
+		// published a newer one since the read.
+		if err := r.cache.Add(versionItem(key)); err != nil && !errors.Is(err, memcache.ErrNotStored) {
+			log.Printf("apikey: publish version of %s: %v", id, err)
+		}
+	}
+	return key, nil
+}
+
+// current reports whether a replica's copy of a key may still be used.
+func (r *Registry) current(cached localKey) bool {
+	if r.cache != nil {
+		item, err := r.cache.Get(versionKey(cached.key.ID))
+		switch {
+		case err == nil:
+			return string(item.Value) == strconv.FormatInt(cached.key.Version, 10)
+		case errors.Is(err, memcache.ErrCacheMiss):
+			return false
+		}
+	}
+	return r.now().Sub(cached.fetchedAt) < r.ttl
+}
@@ -0,0 +1,102 @@
+package apikey_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/FlorinBalint/shortener/pkg/apikey"
+	"github.com/FlorinBalint/shortener/pkg/testutil"
+)
+
+func TestIssueAuthenticate(t *testing.T) {
+	ctx := context.Background()
+	r := apikey.NewRegistry(apikey.NewMemoryStore(), nil)
+	key, secret, err := r.Issue(ctx, "ci", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := r.Authenticate(ctx, secret)
+	if err != nil || got.ID != key.ID || got.Name != "ci" {
+		t.Fatalf("Authenticate: %+v, %v", got, err)
+	}
+	for _, bad := range []string{"", "no-dot", key.ID + ".wrong", "unknown.secret"} {
+		if _, err := r.Authenticate(ctx, bad); !errors.Is(err, apikey.ErrInvalid) {
+			t.Errorf("Authenticate(%q): want ErrInvalid, got %v", bad, err)
+		}
+	}
+}
+
+func TestRotate(t *testing.T) {
+	ctx := context.Background()
+	r := apikey.NewRegistry(apikey.NewMemoryStore(), nil)
+	key, old, _ := r.Issue(ctx, "", "")
+	if _, err := r.Authenticate(ctx, old); err != nil {
+		t.Fatal(err)
+	}
+	rotated, secret, err := r.Rotate(ctx, key.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rotated.ID != key.ID || rotated.RotatedAt.IsZero() {
+		t.Fatalf("unexpected rotated key: %+v", rotated)
+	}
+	if _, err := r.Authenticate(ctx, old); !errors.Is(err, apikey.ErrInvalid) {
+		t.Fatalf("old secret: want ErrInvalid, got %v", err)
+	}
+	if _, err := r.Authenticate(ctx, secret); err != nil {
+		t.Fatalf("new secret: %v", err)
+	}
+	if got, err := r.Get(ctx, key.ID); err != nil || !got.RotatedAt.Equal(rotated.RotatedAt) {
+		t.Fatalf("Get: want the rotated key, got %+v, %v", got, err)
+	}
+	if _, err := r.Get(ctx, "unknown"); !errors.Is(err, apikey.ErrNotFound) {
+		t.Fatalf("Get(unknown): want ErrNotFound, got %v", err)
+	}
+}
+
+// Two replicas share a store and a cache: freezing through one is seen by
+// the other at its next request, although it cached the key.
+func TestFreezeInvalidatesOtherReplicas(t *testing.T) {
+	ctx := context.Background()
+	store := apikey.NewMemoryStore()
+	cache := testutil.NewFakeCache()
+	admin := apikey.NewRegistry(store, cache)
+	replica := apikey.NewRegistry(store, cache)
+
+	key, secret, _ := admin.Issue(ctx, "", "")
+	if _, err := replica.Authenticate(ctx, secret); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := admin.SetFrozen(ctx, key.ID, true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := replica.Authenticate(ctx, secret); !errors.Is(err, apikey.ErrFrozen) {
+		t.Fatalf("frozen: want ErrFrozen, got %v", err)
+	}
+
+	if _, err := admin.SetFrozen(ctx, key.ID, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := replica.Authenticate(ctx, secret); err != nil {
+		t.Fatalf("unfrozen: %v", err)
+	}
+}
+
+func TestReplicaCacheServesRepeatedLookups(t *testing.T) {
+	ctx := context.Background()
+	cache := testutil.NewFakeCache()
+	r := apikey.NewRegistry(apikey.NewMemoryStore(), cache)
+	_, secret, _ := r.Issue(ctx, "", "")
+	for i := 0; i < 3; i++ {
+		if _, err := r.Authenticate(ctx, secret); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The first lookup loads the key from the store; later ones only
+	// confirm its version.
+	if cache.Misses != 0 || cache.Hits != 2 {
+		t.Fatalf("want 2 version checks, got %d misses, %d hits", cache.Misses, cache.Hits)
+	}
+}
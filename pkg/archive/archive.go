@@ -0,0 +1,179 @@
+// Package archive moves cold links out of Datastore into a compressed
+// archive in Cloud Storage, where storage costs a fraction.
+//
+// The reader records the day each link was last used (see Tracker). The
+// archiver, run by the janitor, moves active links neither used nor
+// changed for a while to the archive, one gzipped JSON object per link,
+// and deletes them from the store. A reader missing a key looks it up in
+// the archive and restores it on access, so archiving only slows the first
+// redirect of a link coming back to life.
+package archive
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"google.golang.org/api/googleapi"
+	storage "google.golang.org/api/storage/v1"
+
+	"github.com/FlorinBalint/shortener/pkg/urlstore"
+)
+
+// ErrNotFound is returned for keys the archive does not hold.
+var ErrNotFound = errors.New("not archived")
+
+// Store keeps archived entries by key.
+type Store interface {
+	// Put archives entry under key, replacing any archived before.
+	Put(ctx context.Context, key urlstore.UrlKey, entry urlstore.URLEntry) error
+	// Get returns the entry archived under key, or ErrNotFound.
+	Get(ctx context.Context, key urlstore.UrlKey) (urlstore.URLEntry, error)
+}
+
+// encode renders an entry as gzipped JSON.
+func encode(entry urlstore.URLEntry) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if err := json.NewEncoder(zw).Encode(entry); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// decode reads an entry encoded by encode.
+func decode(r io.Reader) (urlstore.URLEntry, error) {
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return urlstore.URLEntry{}, err
+	}
+	defer zr.Close()
+	var entry urlstore.URLEntry
+	err = json.NewDecoder(zr).Decode(&entry)
+	return entry, err
+}
+
+// GCSStore archives entries in a Cloud Storage bucket, as objects named
+// <prefix>/<key>.json.gz. Entries are archived as stored, so encrypted
+// targets stay encrypted.
+type GCSStore struct {
+	svc    *storage.Service
+	bucket string
+	prefix string
+}
+
+var _ Store = (*GCSStore)(nil)
+
+// NewGCSStore archives in bucket, under prefix (e.g. the Datastore
+// namespace) if set.
+func NewGCSStore(ctx context.Context, bucket, prefix string) (*GCSStore, error) {
+	svc, err := storage.NewService(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &GCSStore{svc: svc, bucket: bucket, prefix: prefix}, nil
+}
+
+func (s *GCSStore) object(key urlstore.UrlKey) string {
+	name := string(key) + ".json.gz"
+	if s.prefix != "" {
+		name = s.prefix + "/" + name
+	}
+	return name
+}
+
+// Put implements Store.
+func (s *GCSStore) Put(ctx context.Context, key urlstore.UrlKey, entry urlstore.URLEntry) error {
+	b, err := encode(entry)
+	if err != nil {
+		return err
+	}
+	obj := &storage.Object{Name: s.object(key), ContentType: "application/gzip"}
+	_, err = s.svc.Objects.Insert(s.bucket, obj).
+		Media(bytes.NewReader(b), googleapi.ContentType("application/gzip")).
+		Context(ctx).Do()
+	return err
+}
+
+// Get implements Store.
+func (s *GCSStore) Get(ctx context.Context, key urlstore.UrlKey) (urlstore.URLEntry, error) {
+	resp, err := s.svc.Objects.Get(s.bucket, s.object(key)).Context(ctx).Download()
+	var gerr *googleapi.Error
+	if errors.As(err, &gerr) && gerr.Code == http.StatusNotFound {
+		return urlstore.URLEntry{}, ErrNotFound
+	}
+	if err != nil {
+		return urlstore.URLEntry{}, err
+	}
+	defer resp.Body.Close()
+	return decode(resp.Body)
+}
+
+// MemoryStore is an in-process Store, for tests and local development.
+type MemoryStore struct {
+	mu      sync.Mutex
+	objects map[urlstore.UrlKey][]byte
+}
+
+var _ Store = (*MemoryStore)(nil)
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{objects: make(map[urlstore.UrlKey][]byte)}
+}
+
+// Put implements Store.
+func (s *MemoryStore) Put(ctx context.Context, key urlstore.UrlKey, entry urlstore.URLEntry) error {
+	b, err := encode(entry)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.objects[key] = b
+	return nil
+}
+
+// Get implements Store.
+func (s *MemoryStore) Get(ctx context.Context, key urlstore.UrlKey) (urlstore.URLEntry, error) {
+	s.mu.Lock()
+	b, ok := s.objects[key]
+	s.mu.Unlock()
+	if !ok {
+		return urlstore.URLEntry{}, ErrNotFound
+	}
+	return decode(bytes.NewReader(b))
+}
+
+// Restore puts the entry archived under key back into store and returns
+// it. The archived copy is kept, so concurrent restores, and readers
+// still caching the miss, find it too; an entry already back in the store
+// is left as it is and returned instead.
+func Restore(ctx context.Context, archive Store, store urlstore.Client, key urlstore.UrlKey) (urlstore.URLEntry, error) {
+	entry, err := archive.Get(ctx, key)
+	if err != nil {
+		return urlstore.URLEntry{}, err
+	}
+	if entry.Expired(time.Now()) {
+		// It ended while archived.
+		return urlstore.URLEntry{}, ErrNotFound
+	}
+	err = store.CreateEntry(ctx, key, entry)
+	if errors.Is(err, urlstore.ErrAlreadyExists) {
+		return store.GetEntry(ctx, key)
+	}
+	if err != nil {
+		return urlstore.URLEntry{}, err
+	}
+	log.Printf("archive: restored %q", key)
+	return entry, nil
+}
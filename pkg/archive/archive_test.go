@@ -0,0 +1,124 @@
+package archive
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/FlorinBalint/shortener/pkg/urlstore"
+)
+
+func TestArchiver_Sweep(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2026, 9, 1, 0, 0, 0, 0, time.UTC)
+	old := now.Add(-365 * 24 * time.Hour)
+	store := urlstore.NewMemoryClient()
+	for key, e := range map[urlstore.UrlKey]urlstore.URLEntry{
+		"cold":    {URLTarget: "https://example.com/cold", CreationTimestamp: old},
+		"used":    {URLTarget: "https://example.com/used", CreationTimestamp: old},
+		"edited":  {URLTarget: "https://example.com/edited", CreationTimestamp: old, UpdatedAt: now.Add(-time.Hour)},
+		"new":     {URLTarget: "https://example.com/new", CreationTimestamp: now.Add(-time.Hour)},
+		"stopped": {URLTarget: "https://example.com/stopped", CreationTimestamp: old},
+	} {
+		if err := store.CreateEntry(ctx, key, e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	usage := NewMemoryUsageStore()
+	bucket := NewMemoryStore()
+	a := NewArchiver(store, bucket, usage, Config{After: 90 * 24 * time.Hour})
+	a.now = func() time.Time { return now }
+
+	if res, err := a.Sweep(ctx); err != nil || res.Archived != 0 {
+		t.Fatalf("usage not recorded yet: want nothing archived, got %+v, %v", res, err)
+	}
+
+	usage.Start(ctx, old)
+	usage.Touch(ctx, []urlstore.UrlKey{"used"}, now.Add(-24*time.Hour))
+	usage.Touch(ctx, []urlstore.UrlKey{"stopped"}, now.Add(-200*24*time.Hour))
+	res, err := a.Sweep(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Scanned != 5 || res.Archived != 2 {
+		t.Fatalf("want 2 of 5 archived, got %+v", res)
+	}
+	for _, key := range []urlstore.UrlKey{"cold", "stopped"} {
+		if _, err := store.GetEntry(ctx, key); !errors.Is(err, urlstore.ErrNotFound) {
+			t.Errorf("%s: want it gone from the store, got %v", key, err)
+		}
+		if e, err := bucket.Get(ctx, key); err != nil || e.URLTarget != "https://example.com/"+string(key) {
+			t.Errorf("%s: want it archived, got %+v, %v", key, e, err)
+		}
+	}
+	for _, key := range []urlstore.UrlKey{"used", "edited", "new"} {
+		if _, err := store.GetEntry(ctx, key); err != nil {
+			t.Errorf("%s: want it kept, got %v", key, err)
+		}
+	}
+
+	e, err := Restore(ctx, bucket, store, "cold")
+	if err != nil || e.URLTarget != "https://example.com/cold" {
+		t.Fatalf("restore: got %+v, %v", e, err)
+	}
+	if _, err := store.GetEntry(ctx, "cold"); err != nil {
+		t.Fatalf("want it back in the store, got %v", err)
+	}
+	if _, err := Restore(ctx, bucket, store, "cold"); err != nil {
+		t.Fatalf("restoring again: %v", err)
+	}
+	if _, err := Restore(ctx, bucket, store, "never"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("never archived: want ErrNotFound, got %v", err)
+	}
+}
+
+func TestTracker(t *testing.T) {
+	ctx := context.Background()
+	usage := &countingUsage{MemoryUsageStore: NewMemoryUsageStore()}
+	tr := NewTracker(usage)
+	day := time.Date(2026, 9, 1, 10, 0, 0, 0, time.UTC)
+	tr.now = func() time.Time { return day }
+
+	tr.Touch("a")
+	tr.Touch("a")
+	tr.Touch("b")
+	if err := tr.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if usage.touched != 2 {
+		t.Fatalf("want 2 keys written, got %d", usage.touched)
+	}
+	if since, _ := usage.Since(ctx); !since.Equal(day.Truncate(24 * time.Hour)) {
+		t.Fatalf("want recording started today, got %v", since)
+	}
+
+	tr.Touch("a")
+	if err := tr.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if usage.touched != 2 {
+		t.Fatalf("want a key written once a day, got %d writes", usage.touched)
+	}
+
+	day = day.Add(24 * time.Hour)
+	tr.Touch("a")
+	if err := tr.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	got, _ := usage.Get(ctx, []urlstore.UrlKey{"a", "b"})
+	if !got["a"].Day.Equal(day.Truncate(24*time.Hour)) || got["b"].Day.Equal(got["a"].Day) {
+		t.Fatalf("want a recorded on the next day and b not, got %+v", got)
+	}
+}
+
+// countingUsage counts the keys written.
+type countingUsage struct {
+	*MemoryUsageStore
+	touched int
+}
+
+func (c *countingUsage) Touch(ctx context.Context, keys []urlstore.UrlKey, day time.Time) error {
+	c.touched += len(keys)
+	return c.MemoryUsageStore.Touch(ctx, keys, day)
+}
@@ -0,0 +1,113 @@
+package archive
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/FlorinBalint/shortener/pkg/urlstore"
+)
+
+// Config configures an Archiver.
+//
+// After is how long a link must go unused and unchanged to be archived.
+// BatchSize is the number of entries scanned per store page (default 500).
+// With DryRun set, cold entries are only counted.
+type Config struct {
+	After     time.Duration
+	BatchSize int
+	DryRun    bool
+}
+
+// Result summarizes a sweep.
+type Result struct {
+	Scanned  int
+	Archived int
+	Failed   int
+}
+
+// Archiver moves cold links from a store to an archive.
+type Archiver struct {
+	store   urlstore.Client
+	archive Store
+	usage   UsageStore
+	cfg     Config
+	now     func() time.Time
+}
+
+// NewArchiver returns an Archiver sweeping store. Pass a cache-aside store
+// so archived entries are also evicted from the cache, and no encrypting
+// one: entries are archived as stored.
+func NewArchiver(store urlstore.Client, archive Store, usage UsageStore, cfg Config) *Archiver {
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = 500
+	}
+	return &Archiver{store: store, archive: archive, usage: usage, cfg: cfg, now: time.Now}
+}
+
+// Cold reports whether entry, last used on lastUsed (zero if not since
+// usage is recorded), neither changed nor was used since cutoff.
+func Cold(entry urlstore.URLEntry, lastUsed, cutoff time.Time) bool {
+	return entry.ModifiedAt().Before(cutoff) && lastUsed.Before(cutoff)
+}
+
+// Sweep scans the whole store once and archives cold entries. Nothing is
+// archived until usage has been recorded for at least After, since links
+// without a recorded use would all look cold. Individual failures are
+// logged and counted; a failing scan aborts the sweep and returns the
+// partial result.
+func (a *Archiver) Sweep(ctx context.Context) (Result, error) {
+	var res Result
+	cutoff := a.now().Add(-a.cfg.After)
+	since, err := a.usage.Since(ctx)
+	if err != nil {
+		return res, err
+	}
+	if since.IsZero() || since.After(cutoff) {
+		log.Printf("archive: link usage recorded since %v, archiving starts %v after it", since, a.cfg.After)
+		return res, nil
+	}
+	opts := urlstore.ListOptions{Limit: a.cfg.BatchSize}
+	for {
+		page, err := a.store.ListEntries(ctx, opts)
+		if err != nil {
+			return res, err
+		}
+		keys := make([]urlstore.UrlKey, len(page.Entries))
+		for i, ke := range page.Entries {
+			keys[i] = ke.Key
+		}
+		usages, err := a.usage.Get(ctx, keys)
+		if err != nil {
+			return res, err
+		}
+		for _, ke := range page.Entries {
+			res.Scanned++
+			if !Cold(ke.Entry, usages[ke.Key].Day, cutoff) {
+				continue
+			}
+			if a.cfg.DryRun {
+				res.Archived++
+				continue
+			}
+			if err := a.move(ctx, ke.Key, ke.Entry); err != nil {
+				log.Printf("archive: archiving %q failed: %v", ke.Key, err)
+				res.Failed++
+				continue
+			}
+			res.Archived++
+		}
+		if page.NextCursor == "" {
+			return res, nil
+		}
+		opts.Cursor = page.NextCursor
+	}
+}
+
+// move archives the entry under key, then deletes it from the store.
+func (a *Archiver) move(ctx context.Context, key urlstore.UrlKey, entry urlstore.URLEntry) error {
+	if err := a.archive.Put(ctx, key, entry); err != nil {
+		return err
+	}
+	return a.store.DeleteEntry(ctx, key)
+}
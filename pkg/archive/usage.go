@@ -0,0 +1,256 @@
+package archive
+
+import (
+	"context"
+	"errors"
+	"log"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/datastore"
+
+	"github.com/FlorinBalint/shortener/pkg/gcputil"
+	"github.com/FlorinBalint/shortener/pkg/urlstore"
+)
+
+// Usage is the last use of a link, to the day.
+type Usage struct {
+	Day time.Time `json:"day"`
+}
+
+// UsageStore keeps the day each link was last used, and since when uses
+// are recorded at all: links unused since then may be cold, while those
+// older than that are unknown.
+type UsageStore interface {
+	// Touch records keys as used on day.
+	Touch(ctx context.Context, keys []urlstore.UrlKey, day time.Time) error
+	// Get returns the usage of keys, leaving out those never recorded.
+	Get(ctx context.Context, keys []urlstore.UrlKey) (map[urlstore.UrlKey]Usage, error)
+	// Since returns the day uses started being recorded, or the zero time.
+	Since(ctx context.Context) (time.Time, error)
+	// Start records day as the start of recording, unless one is.
+	Start(ctx context.Context, day time.Time) error
+}
+
+// maxTouchBatch is the most usages written at once, Datastore's limit.
+const maxTouchBatch = 500
+
+// DSUsageStore keeps usages in Datastore, as entities of kind
+// "link_usage" named by key, and the start of recording as entity
+// "since" of kind "link_usage_start".
+type DSUsageStore struct {
+	client *gcputil.DSClient
+}
+
+var _ UsageStore = (*DSUsageStore)(nil)
+
+func NewDSUsageStore(client *gcputil.DSClient) *DSUsageStore {
+	return &DSUsageStore{client: client}
+}
+
+// Touch implements UsageStore.
+func (s *DSUsageStore) Touch(ctx context.Context, keys []urlstore.UrlKey, day time.Time) error {
+	for batch := range slices.Chunk(keys, maxTouchBatch) {
+		values := make(map[string]Usage, len(batch))
+		for _, k := range batch {
+			values[string(k)] = Usage{Day: day}
+		}
+		if err := gcputil.PutValues(s.client, ctx, "link_usage", values); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Get implements UsageStore.
+func (s *DSUsageStore) Get(ctx context.Context, keys []urlstore.UrlKey) (map[urlstore.UrlKey]Usage, error) {
+	names := make([]string, len(keys))
+	for i, k := range keys {
+		names[i] = string(k)
+	}
+	byName, err := gcputil.GetValues[Usage](s.client, ctx, "link_usage", names)
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[urlstore.UrlKey]Usage, len(byName))
+	for name, u := range byName {
+		out[urlstore.UrlKey(name)] = u
+	}
+	return out, nil
+}
+
+// Since implements UsageStore.
+func (s *DSUsageStore) Since(ctx context.Context) (time.Time, error) {
+	u, err := gcputil.GetValue[Usage](s.client, ctx, "link_usage_start", "since")
+	if errors.Is(err, datastore.ErrNoSuchEntity) {
+		return time.Time{}, nil
+	}
+	return u.Day, err
+}
+
+// Start implements UsageStore.
+func (s *DSUsageStore) Start(ctx context.Context, day time.Time) error {
+	return gcputil.UpsertValue(s.client, ctx, "link_usage_start", "since", func(u *Usage) error {
+		if u.Day.IsZero() {
+			u.Day = day
+		}
+		return nil
+	})
+}
+
+// MemoryUsageStore is an in-process UsageStore, for tests.
+type MemoryUsageStore struct {
+	mu     sync.Mutex
+	usages map[urlstore.UrlKey]Usage
+	since  time.Time
+}
+
+var _ UsageStore = (*MemoryUsageStore)(nil)
+
+func NewMemoryUsageStore() *MemoryUsageStore {
+	return &MemoryUsageStore{usages: make(map[urlstore.UrlKey]Usage)}
+}
+
+// Touch implements UsageStore.
+func (s *MemoryUsageStore) Touch(ctx context.Context, keys []urlstore.UrlKey, day time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, k := range keys {
+		s.usages[k] = Usage{Day: day}
+	}
+	return nil
+}
+
+// Get implements UsageStore.
+func (s *MemoryUsageStore) Get(ctx context.Context, keys []urlstore.UrlKey) (map[urlstore.UrlKey]Usage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make(map[urlstore.UrlKey]Usage)
+	for _, k := range keys {
+		if u, ok := s.usages[k]; ok {
+			out[k] = u
+		}
+	}
+	return out, nil
+}
+
+// Since implements UsageStore.
+func (s *MemoryUsageStore) Since(ctx context.Context) (time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.since, nil
+}
+
+// Start implements UsageStore.
+func (s *MemoryUsageStore) Start(ctx context.Context, day time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.since.IsZero() {
+		s.since = day
+	}
+	return nil
+}
+
+// Tracker records the links a reader serves in a UsageStore, writing each
+// at most once a day: touches are collected in memory and flushed in
+// batches.
+type Tracker struct {
+	store UsageStore
+	now   func() time.Time
+
+	mu sync.Mutex
+	// day is the day being recorded; recorded holds the keys written for
+	// it and pending those still to write.
+	day      time.Time
+	recorded map[urlstore.UrlKey]bool
+	pending  map[urlstore.UrlKey]bool
+	started  bool
+}
+
+// NewTracker records usages in store.
+func NewTracker(store UsageStore) *Tracker {
+	return &Tracker{
+		store:    store,
+		now:      time.Now,
+		recorded: make(map[urlstore.UrlKey]bool),
+		pending:  make(map[urlstore.UrlKey]bool),
+	}
+}
+
+// today is the current day, in UTC.
+func (t *Tracker) today() time.Time {
+	return t.now().UTC().Truncate(24 * time.Hour)
+}
+
+// Touch notes a use of key, to be written by the next Flush.
+func (t *Tracker) Touch(key urlstore.UrlKey) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if day := t.today(); !day.Equal(t.day) {
+		t.day = day
+		clear(t.recorded)
+	}
+	if !t.recorded[key] {
+		t.pending[key] = true
+	}
+}
+
+// Flush writes the uses noted since the last flush. Keys failing to
+// write are kept for the next one. Flushes must not run concurrently.
+func (t *Tracker) Flush(ctx context.Context) error {
+	t.mu.Lock()
+	day, keys := t.day, slices.Collect(maps.Keys(t.pending))
+	clear(t.pending)
+	t.mu.Unlock()
+	if len(keys) == 0 {
+		return nil
+	}
+	if !t.started {
+		if err := t.store.Start(ctx, day); err != nil {
+			t.requeue(keys)
+			return err
+		}
+		t.started = true
+	}
+	if err := t.store.Touch(ctx, keys, day); err != nil {
+		t.requeue(keys)
+		return err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if day.Equal(t.day) {
+		for _, k := range keys {
+			t.recorded[k] = true
+		}
+	}
+	return nil
+}
+
+func (t *Tracker) requeue(keys []urlstore.UrlKey) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, k := range keys {
+		t.pending[k] = true
+	}
+}
+
+// Run flushes every interval until ctx is done, then once more.
+func (t *Tracker) Run(ctx context.Context, interval time.Duration) {
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			if err := t.Flush(context.WithoutCancel(ctx)); err != nil {
+				log.Printf("archive: recording link usage: %v", err)
+			}
+			return
+		case <-tick.C:
+			if err := t.Flush(ctx); err != nil {
+				log.Printf("archive: recording link usage: %v", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,260 @@
+// Package bundle wraps exports in a format that may be encrypted and
+// signed, so that backups holding targets with secrets in their query can
+// be kept in shared buckets.
+//
+// A bundle is made of JSON lines: a header, frames and a trailer.
+//
+//	{"bundle":"shortener-export","version":1,"id":"...","encrypted":true,"signed":true}
+//	{"seq":0,"data":"<base64>"}
+//	...
+//	{"seq":n,"final":true}
+//	{"signature":"t=...,kid=...,v1=..."}
+//
+// Frames carry the payload, e.g. a page of NDJSON records each, sealed
+// with AES-256-GCM when encrypted, bound to the header, their sequence
+// number and whether they are final, so frames cannot be reordered,
+// dropped or spliced from another bundle. The signature, an HMAC-SHA256 of
+// the digest of every line before it (see pkg/hmacsig), proves who wrote
+// the bundle to holders of the signing keys, who need not be able to
+// decrypt it.
+package bundle
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"time"
+
+	"github.com/FlorinBalint/shortener/pkg/envelope"
+	"github.com/FlorinBalint/shortener/pkg/hmacsig"
+)
+
+// format and version identify bundles in their header.
+const (
+	format  = "shortener-export"
+	version = 1
+)
+
+// signatureWindow accepts the signatures of bundles whatever their age:
+// backups are restored long after they were written.
+const signatureWindow = 100 * 365 * 24 * time.Hour
+
+// Errors returned by Open.
+var (
+	ErrMalformed = errors.New("bundle: malformed")
+	ErrTruncated = errors.New("bundle: truncated")
+	ErrNoCipher  = errors.New("bundle: encrypted, but no decryption key is configured")
+	ErrNoKeys    = errors.New("bundle: signed, but no signing keys are configured")
+	ErrSignature = errors.New("bundle: invalid signature")
+)
+
+type header struct {
+	Bundle    string `json:"bundle"`
+	Version   int    `json:"version"`
+	ID        string `json:"id"`
+	Encrypted bool   `json:"encrypted,omitempty"`
+	Signed    bool   `json:"signed,omitempty"`
+}
+
+type frame struct {
+	Seq   uint64 `json:"seq"`
+	Final bool   `json:"final,omitempty"`
+	Data  []byte `json:"data,omitempty"`
+}
+
+type trailer struct {
+	Signature string `json:"signature,omitempty"`
+}
+
+// Writer writes a bundle. Nothing is buffered: each Write is a frame.
+type Writer struct {
+	w      io.Writer
+	cipher *envelope.Cipher
+	keys   *hmacsig.Keyring
+	header []byte
+	seq    uint64
+	digest hash.Hash
+}
+
+// NewWriter writes the header of a bundle to w, encrypted with c and
+// signed with keys, either of which may be nil but not both.
+func NewWriter(w io.Writer, c *envelope.Cipher, keys *hmacsig.Keyring) (*Writer, error) {
+	if c == nil && keys == nil {
+		return nil, errors.New("bundle: needs a cipher, signing keys or both")
+	}
+	id := make([]byte, 16)
+	rand.Read(id)
+	h, err := json.Marshal(header{Bundle: format, Version: version, ID: hex.EncodeToString(id), Encrypted: c != nil, Signed: keys != nil})
+	if err != nil {
+		return nil, err
+	}
+	bw := &Writer{w: w, cipher: c, keys: keys, header: h, digest: sha256.New()}
+	if err := bw.line(h); err != nil {
+		return nil, err
+	}
+	return bw, nil
+}
+
+// line writes a line, counting it in the signed digest.
+func (bw *Writer) line(b []byte) error {
+	b = append(b, '\n')
+	bw.digest.Write(b)
+	_, err := bw.w.Write(b)
+	return err
+}
+
+// writeFrame writes the next frame holding p.
+func (bw *Writer) writeFrame(p []byte, final bool) error {
+	f := frame{Seq: bw.seq, Final: final, Data: p}
+	if bw.cipher != nil {
+		f.Data = bw.cipher.Seal(p, aad(bw.header, bw.seq, final))
+	}
+	bw.seq++
+	b, err := json.Marshal(f)
+	if err != nil {
+		return err
+	}
+	return bw.line(b)
+}
+
+// Write writes p as a frame.
+func (bw *Writer) Write(p []byte) (int, error) {
+	if err := bw.writeFrame(p, false); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Close writes the final frame and the trailer; a bundle without them is
+// truncated. It does not close the underlying writer.
+func (bw *Writer) Close() error {
+	if err := bw.writeFrame(nil, true); err != nil {
+		return err
+	}
+	var t trailer
+	if bw.keys != nil {
+		t.Signature = bw.keys.Sign(bw.digest.Sum(nil), time.Now())
+	}
+	b, err := json.Marshal(t)
+	if err != nil {
+		return err
+	}
+	_, err = bw.w.Write(append(b, '\n'))
+	return err
+}
+
+// aad binds a frame to its bundle, position and finality.
+func aad(header []byte, seq uint64, final bool) []byte {
+	b := binary.BigEndian.AppendUint64(bytes.Clone(header), seq)
+	if final {
+		return append(b, 1)
+	}
+	return append(b, 0)
+}
+
+// IsBundle reports whether data starts with the header of a bundle,
+// rather than, e.g., a plain NDJSON export.
+func IsBundle(data []byte) bool {
+	first, _, _ := bytes.Cut(data, []byte("\n"))
+	var h header
+	return json.Unmarshal(first, &h) == nil && h.Bundle == format
+}
+
+// Info describes an opened bundle.
+type Info struct {
+	Encrypted bool
+	Signed    bool
+}
+
+// Open verifies and decrypts the bundle data, and returns its payload. A
+// signed bundle needs keys, and an encrypted one c; a bundle that is
+// neither is only checked to be complete.
+func Open(data []byte, c *envelope.Cipher, keys *hmacsig.Keyring) ([]byte, Info, error) {
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	sc.Buffer(nil, len(data)+1)
+	digest := sha256.New()
+	// next returns the next line, counted in the digest.
+	next := func() ([]byte, error) {
+		if !sc.Scan() {
+			if err := sc.Err(); err != nil {
+				return nil, err
+			}
+			return nil, ErrTruncated
+		}
+		digest.Write(sc.Bytes())
+		digest.Write([]byte("\n"))
+		return sc.Bytes(), nil
+	}
+
+	line, err := next()
+	if err != nil {
+		return nil, Info{}, err
+	}
+	var h header
+	if err := json.Unmarshal(line, &h); err != nil || h.Bundle != format {
+		return nil, Info{}, ErrMalformed
+	}
+	if h.Version != version {
+		return nil, Info{}, fmt.Errorf("bundle: unsupported version %d", h.Version)
+	}
+	info := Info{Encrypted: h.Encrypted, Signed: h.Signed}
+	switch {
+	case h.Encrypted && c == nil:
+		return nil, info, ErrNoCipher
+	case h.Signed && keys == nil:
+		return nil, info, ErrNoKeys
+	}
+	headerLine := bytes.Clone(line)
+
+	var payload []byte
+	for seq := uint64(0); ; seq++ {
+		line, err := next()
+		if err != nil {
+			return nil, info, err
+		}
+		var f frame
+		if err := json.Unmarshal(line, &f); err != nil || f.Seq != seq {
+			return nil, info, ErrMalformed
+		}
+		p := f.Data
+		if h.Encrypted {
+			if p, err = c.Open(f.Data, aad(headerLine, seq, f.Final)); err != nil {
+				return nil, info, fmt.Errorf("bundle: frame %d: %w", seq, err)
+			}
+		}
+		payload = append(payload, p...)
+		if f.Final {
+			break
+		}
+	}
+
+	// The trailer signs the lines before it.
+	signed := digest.Sum(nil)
+	line, err = next()
+	if err != nil {
+		return nil, info, err
+	}
+	var t trailer
+	if err := json.Unmarshal(line, &t); err != nil {
+		return nil, info, ErrMalformed
+	}
+	for sc.Scan() {
+		if len(bytes.TrimSpace(sc.Bytes())) > 0 {
+			return nil, info, ErrMalformed
+		}
+	}
+	if h.Signed {
+		if err := keys.Verify(t.Signature, signed, time.Now(), signatureWindow); err != nil {
+			return nil, info, fmt.Errorf("%w: %v", ErrSignature, err)
+		}
+	}
+	return payload, info, nil
+}
@@ -0,0 +1,117 @@
+package bundle_test
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/FlorinBalint/shortener/pkg/bundle"
+	"github.com/FlorinBalint/shortener/pkg/envelope"
+	"github.com/FlorinBalint/shortener/pkg/hmacsig"
+)
+
+const (
+	page1 = `{"url_key":"a","url_target":"https://example.com/?token=secret"}` + "\n"
+	page2 = `{"url_key":"b","url_target":"https://example.com/b"}` + "\n"
+)
+
+func write(t *testing.T, c *envelope.Cipher, keys *hmacsig.Keyring, pages ...string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := bundle.NewWriter(&buf, c, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range pages {
+		if _, err := w.Write([]byte(p)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestRoundTrip(t *testing.T) {
+	c, _ := envelope.NewCipher(bytes.Repeat([]byte{7}, envelope.DataKeySize))
+	keys, _ := hmacsig.ParseKeyring("k2:new-secret,k1:old-secret")
+	tests := []struct {
+		name string
+		c    *envelope.Cipher
+		keys *hmacsig.Keyring
+	}{
+		{"encrypted", c, nil},
+		{"signed", nil, keys},
+		{"both", c, keys},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := write(t, tt.c, tt.keys, page1, page2)
+			if !bundle.IsBundle(data) {
+				t.Fatal("IsBundle() = false")
+			}
+			if tt.c != nil && bytes.Contains(data, []byte("secret")) {
+				t.Fatal("encrypted bundle holds a target in the clear")
+			}
+			got, info, err := bundle.Open(data, tt.c, tt.keys)
+			if err != nil || string(got) != page1+page2 {
+				t.Fatalf("Open() = %q, %v", got, err)
+			}
+			if info.Encrypted != (tt.c != nil) || info.Signed != (tt.keys != nil) {
+				t.Errorf("Info = %+v", info)
+			}
+		})
+	}
+
+	if _, err := bundle.NewWriter(new(bytes.Buffer), nil, nil); err == nil {
+		t.Error("want an error for a bundle neither encrypted nor signed")
+	}
+	if bundle.IsBundle([]byte(page1)) {
+		t.Error("IsBundle(NDJSON) = true")
+	}
+}
+
+func TestOpen_Rejects(t *testing.T) {
+	c, _ := envelope.NewCipher(bytes.Repeat([]byte{7}, envelope.DataKeySize))
+	other, _ := envelope.NewCipher(bytes.Repeat([]byte{8}, envelope.DataKeySize))
+	keys, _ := hmacsig.ParseKeyring("k1:secret")
+	stranger, _ := hmacsig.ParseKeyring("k1:other")
+	data := write(t, c, keys, page1, page2)
+	lines := strings.SplitAfter(string(data), "\n")
+	// lines: header, two frames, the final frame, the trailer and "".
+	without := func(i int) []byte {
+		return []byte(strings.Join(append(append([]string(nil), lines[:i]...), lines[i+1:]...), ""))
+	}
+	swapped := []byte(lines[0] + lines[2] + lines[1] + lines[3] + lines[4])
+	spliced := write(t, c, nil, page2)
+
+	tests := []struct {
+		name string
+		data []byte
+		c    *envelope.Cipher
+		keys *hmacsig.Keyring
+		want error
+	}{
+		{"no cipher", data, nil, keys, bundle.ErrNoCipher},
+		{"no keys", data, c, nil, bundle.ErrNoKeys},
+		{"other cipher", data, other, keys, envelope.ErrDecrypt},
+		{"other keys", data, c, stranger, bundle.ErrSignature},
+		{"truncated", []byte(lines[0] + lines[1]), c, keys, bundle.ErrTruncated},
+		{"no final frame", without(3), c, keys, bundle.ErrMalformed},
+		{"no trailer", without(4), c, keys, bundle.ErrTruncated},
+		{"dropped frame", without(1), c, keys, bundle.ErrMalformed},
+		{"reordered", swapped, c, keys, bundle.ErrMalformed},
+		{"spliced", []byte(lines[0] + strings.SplitAfter(string(spliced), "\n")[1] + lines[2] + lines[3] + lines[4]), c, keys, envelope.ErrDecrypt},
+		{"trailing data", append(bytes.Clone(data), lines[1]...), c, keys, bundle.ErrMalformed},
+		{"not a bundle", []byte(page1), c, keys, bundle.ErrMalformed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := bundle.Open(tt.data, tt.c, tt.keys); !errors.Is(err, tt.want) {
+				t.Errorf("Open() = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
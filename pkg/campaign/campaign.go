@@ -0,0 +1,282 @@
+// Package campaign groups links into campaigns, e.g. the dozens of links
+// of a product launch, so they are listed and measured together. A link
+// belongs to at most one campaign, recorded on its entry
+// (urlstore.URLEntry.Campaign).
+package campaign
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/datastore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/FlorinBalint/shortener/pkg/gcputil"
+	"github.com/FlorinBalint/shortener/pkg/urlstore"
+)
+
+// Errors returned by stores.
+var (
+	ErrNotFound      = errors.New("campaign not found")
+	ErrAlreadyExists = errors.New("campaign already exists")
+	ErrInvalid       = errors.New("invalid campaign")
+	ErrInvalidCursor = errors.New("invalid cursor")
+)
+
+// Campaign is a named group of links.
+type Campaign struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	// Owner is the ID of the API key that created the campaign, if any.
+	Owner     string    `json:"owner,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+var idRe = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,63}$`)
+
+// maxNameLength bounds names and descriptions.
+const maxNameLength = 256
+
+// Validate checks the client-chosen fields of c.
+func (c Campaign) Validate() error {
+	if !idRe.MatchString(c.ID) {
+		return fmt.Errorf("%w: id must match %s", ErrInvalid, idRe)
+	}
+	if strings.TrimSpace(c.Name) == "" {
+		return fmt.Errorf("%w: name is required", ErrInvalid)
+	}
+	if len(c.Name) > maxNameLength || len(c.Description) > 4*maxNameLength {
+		return fmt.Errorf("%w: name or description too long", ErrInvalid)
+	}
+	return nil
+}
+
+// ListOptions selects a page of campaigns, ordered by ID.
+type ListOptions struct {
+	// Prefix keeps only campaigns whose ID starts with it.
+	Prefix string
+	// Owner keeps only campaigns created by this API key.
+	Owner  string
+	Cursor string
+	Limit  int
+}
+
+// Page is one page of List results. NextCursor is empty after the last
+// page.
+type Page struct {
+	Campaigns  []Campaign
+	NextCursor string
+}
+
+// Store persists campaigns by ID.
+type Store interface {
+	// Get returns the campaign with id, or ErrNotFound.
+	Get(ctx context.Context, id string) (Campaign, error)
+	// Create stores a new campaign, or fails with ErrAlreadyExists.
+	Create(ctx context.Context, c Campaign) error
+	// Delete removes the campaign with id; deleting a missing one is not
+	// an error.
+	Delete(ctx context.Context, id string) error
+	// List returns a page of campaigns. Pages filtered by owner may hold
+	// fewer campaigns than the limit before the last one.
+	List(ctx context.Context, opts ListOptions) (Page, error)
+}
+
+// DSStore stores campaigns in Datastore, under kind "campaign" of the
+// client's namespace.
+type DSStore struct {
+	client *gcputil.DSClient
+}
+
+var _ Store = (*DSStore)(nil)
+
+func NewDSStore(client *gcputil.DSClient) *DSStore {
+	return &DSStore{client: client}
+}
+
+// Get implements Store.
+func (s *DSStore) Get(ctx context.Context, id string) (Campaign, error) {
+	c, err := gcputil.GetValue[Campaign](s.client, ctx, "campaign", id)
+	if errors.Is(err, datastore.ErrNoSuchEntity) {
+		return Campaign{}, ErrNotFound
+	}
+	return c, err
+}
+
+// Create implements Store.
+func (s *DSStore) Create(ctx context.Context, c Campaign) error {
+	err := gcputil.PutNewValue(s.client, ctx, "campaign", c.ID, c)
+	if status.Code(err) == codes.AlreadyExists {
+		return ErrAlreadyExists
+	}
+	return err
+}
+
+// Delete implements Store.
+func (s *DSStore) Delete(ctx context.Context, id string) error {
+	return s.client.Delete(ctx, "campaign", id)
+}
+
+// List implements Store.
+func (s *DSStore) List(ctx context.Context, opts ListOptions) (Page, error) {
+	values, next, err := gcputil.ListValues[Campaign](s.client, ctx, "campaign", gcputil.ListQuery{
+		Cursor:     opts.Cursor,
+		Limit:      limit(opts),
+		NamePrefix: opts.Prefix,
+	})
+	if errors.Is(err, gcputil.ErrInvalidCursor) {
+		return Page{}, ErrInvalidCursor
+	}
+	if err != nil {
+		return Page{}, err
+	}
+	page := Page{NextCursor: next}
+	for _, v := range values {
+		if opts.Owner == "" || v.Value.Owner == opts.Owner {
+			page.Campaigns = append(page.Campaigns, v.Value)
+		}
+	}
+	return page, nil
+}
+
+func limit(opts ListOptions) int {
+	if opts.Limit <= 0 {
+		return 100
+	}
+	return opts.Limit
+}
+
+// MemoryStore is an in-process Store, for tests and local development.
+type MemoryStore struct {
+	mu        sync.Mutex
+	campaigns map[string]Campaign
+}
+
+var _ Store = (*MemoryStore)(nil)
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{campaigns: make(map[string]Campaign)}
+}
+
+// Get implements Store.
+func (s *MemoryStore) Get(ctx context.Context, id string) (Campaign, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.campaigns[id]
+	if !ok {
+		return Campaign{}, ErrNotFound
+	}
+	return c, nil
+}
+
+// Create implements Store.
+func (s *MemoryStore) Create(ctx context.Context, c Campaign) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.campaigns[c.ID]; ok {
+		return ErrAlreadyExists
+	}
+	s.campaigns[c.ID] = c
+	return nil
+}
+
+// Delete implements Store.
+func (s *MemoryStore) Delete(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.campaigns, id)
+	return nil
+}
+
+// List implements Store. Cursors are the last ID of the previous page.
+func (s *MemoryStore) List(ctx context.Context, opts ListOptions) (Page, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ids := make([]string, 0, len(s.campaigns))
+	for id := range s.campaigns {
+		if strings.HasPrefix(id, opts.Prefix) && id > opts.Cursor {
+			ids = append(ids, id)
+		}
+	}
+	slices.Sort(ids)
+	var page Page
+	if n := limit(opts); len(ids) > n {
+		ids = ids[:n]
+		page.NextCursor = ids[n-1]
+	}
+	for _, id := range ids {
+		if c := s.campaigns[id]; opts.Owner == "" || c.Owner == opts.Owner {
+			page.Campaigns = append(page.Campaigns, c)
+		}
+	}
+	return page, nil
+}
+
+// Stats aggregates the links of a campaign.
+type Stats struct {
+	Links   int `json:"links"`
+	Live    int `json:"live"`
+	Expired int `json:"expired"`
+	Deleted int `json:"deleted"`
+	// TargetHosts counts the links by normalized target host.
+	TargetHosts  map[string]int `json:"target_hosts"`
+	FirstCreated time.Time      `json:"first_created,omitzero"`
+	LastCreated  time.Time      `json:"last_created,omitzero"`
+	// Truncated is set when the campaign has more links than were
+	// counted.
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+// Summarize computes the stats of the first maxLinks links of campaign id
+// in store, deleted and expired ones included.
+func Summarize(ctx context.Context, store urlstore.Client, id string, maxLinks int) (Stats, error) {
+	st := Stats{TargetHosts: make(map[string]int)}
+	opts := urlstore.ListOptions{Campaign: id, IncludeInactive: true, Limit: min(maxLinks, 500)}
+	now := time.Now()
+	for {
+		page, err := store.ListEntries(ctx, opts)
+		if err != nil {
+			return st, err
+		}
+		for _, ke := range page.Entries {
+			if st.Links == maxLinks {
+				st.Truncated = true
+				return st, nil
+			}
+			st.add(ke.Entry, now)
+		}
+		if page.NextCursor == "" {
+			return st, nil
+		}
+		opts.Cursor = page.NextCursor
+	}
+}
+
+func (st *Stats) add(e urlstore.URLEntry, now time.Time) {
+	st.Links++
+	switch {
+	case e.Deleted():
+		st.Deleted++
+	case e.Live(now):
+		st.Live++
+	default:
+		st.Expired++
+	}
+	if host := e.TargetHost(); host != "" {
+		st.TargetHosts[host]++
+	}
+	if st.FirstCreated.IsZero() || e.CreationTimestamp.Before(st.FirstCreated) {
+		st.FirstCreated = e.CreationTimestamp
+	}
+	if e.CreationTimestamp.After(st.LastCreated) {
+		st.LastCreated = e.CreationTimestamp
+	}
+}
@@ -0,0 +1,106 @@
+package campaign_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/FlorinBalint/shortener/pkg/campaign"
+	"github.com/FlorinBalint/shortener/pkg/urlstore"
+)
+
+func TestMemoryStore(t *testing.T) {
+	ctx := context.Background()
+	s := campaign.NewMemoryStore()
+	for _, id := range []string{"launch-b", "launch-a", "summer", "launch-c"} {
+		if err := s.Create(ctx, campaign.Campaign{ID: id, Name: id, Owner: "k1"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Create(ctx, campaign.Campaign{ID: "summer", Name: "again"}); !errors.Is(err, campaign.ErrAlreadyExists) {
+		t.Fatalf("duplicate: want ErrAlreadyExists, got %v", err)
+	}
+
+	var got []string
+	opts := campaign.ListOptions{Prefix: "launch-", Limit: 2}
+	for {
+		page, err := s.List(ctx, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, c := range page.Campaigns {
+			got = append(got, c.ID)
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		opts.Cursor = page.NextCursor
+	}
+	if want := []string{"launch-a", "launch-b", "launch-c"}; len(got) != len(want) || got[0] != want[0] || got[2] != want[2] {
+		t.Fatalf("want %v, got %v", want, got)
+	}
+
+	if err := s.Delete(ctx, "summer"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Get(ctx, "summer"); !errors.Is(err, campaign.ErrNotFound) {
+		t.Fatalf("deleted: want ErrNotFound, got %v", err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for _, c := range []campaign.Campaign{
+		{ID: "", Name: "x"},
+		{ID: "Launch", Name: "x"},
+		{ID: "-launch", Name: "x"},
+		{ID: "launch"},
+	} {
+		if err := c.Validate(); !errors.Is(err, campaign.ErrInvalid) {
+			t.Errorf("%+v: want ErrInvalid, got %v", c, err)
+		}
+	}
+	if err := (campaign.Campaign{ID: "launch-2026", Name: "Launch"}).Validate(); err != nil {
+		t.Errorf("valid campaign: %v", err)
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	ctx := context.Background()
+	store := urlstore.NewMemoryClient()
+	now := time.Now().UTC()
+	entries := map[urlstore.UrlKey]urlstore.URLEntry{
+		"a":     {URLTarget: "https://shop.example/a", Campaign: "launch", CreationTimestamp: now.Add(-3 * time.Hour)},
+		"b":     {URLTarget: "https://www.shop.example/b", Campaign: "launch", CreationTimestamp: now.Add(-2 * time.Hour)},
+		"c":     {URLTarget: "https://blog.example/c", Campaign: "launch", CreationTimestamp: now.Add(-time.Hour), ExpiresAt: now.Add(-time.Minute)},
+		"d":     {URLTarget: "https://blog.example/d", Campaign: "launch", CreationTimestamp: now, DeletedAt: now},
+		"other": {URLTarget: "https://shop.example/o", Campaign: "summer", CreationTimestamp: now},
+	}
+	for k, e := range entries {
+		if err := store.CreateEntry(ctx, k, e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	st, err := campaign.Summarize(ctx, store, "launch", 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Links != 4 || st.Live != 2 || st.Expired != 1 || st.Deleted != 1 || st.Truncated {
+		t.Fatalf("unexpected counts: %+v", st)
+	}
+	if !st.FirstCreated.Equal(entries["a"].CreationTimestamp) || !st.LastCreated.Equal(now) {
+		t.Errorf("unexpected creation range: %v - %v", st.FirstCreated, st.LastCreated)
+	}
+	if st.TargetHosts["blog.example"] != 2 {
+		t.Errorf("unexpected hosts: %v", st.TargetHosts)
+	}
+
+	st, err = campaign.Summarize(ctx, store, "launch", 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Links != 3 || !st.Truncated {
+		t.Fatalf("capped: want 3 links, truncated, got %+v", st)
+	}
+}
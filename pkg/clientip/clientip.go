@@ -0,0 +1,134 @@
+// Package clientip resolves the address of the client behind trusted
+// proxies, such as the load balancer, from Forwarded and X-Forwarded-For.
+//
+// Services resolve it once in Middleware; IP filters, audit logs and other
+// consumers read it back with FromRequest.
+package clientip
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+type ctxKey struct{}
+
+// NewContext returns a copy of ctx carrying the client address.
+func NewContext(ctx context.Context, addr netip.Addr) context.Context {
+	return context.WithValue(ctx, ctxKey{}, addr)
+}
+
+// FromContext returns the client address stored by NewContext.
+func FromContext(ctx context.Context) (netip.Addr, bool) {
+	addr, ok := ctx.Value(ctxKey{}).(netip.Addr)
+	return addr, ok
+}
+
+// FromRequest returns the client address resolved by Middleware, or the
+// TCP peer when the request did not pass through it.
+func FromRequest(r *http.Request) netip.Addr {
+	if addr, ok := FromContext(r.Context()); ok {
+		return addr
+	}
+	return Peer(r)
+}
+
+// Middleware resolves the client address of each request against the
+// trusted proxies and stores it in the request context.
+func Middleware(trusted []netip.Prefix) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, r.WithContext(NewContext(r.Context(), Resolve(r, trusted))))
+		})
+	}
+}
+
+// Resolve returns the address of the client that sent r. Forwarding headers
+// are only believed when the TCP peer is a trusted proxy: the hops they list
+// are then walked from the right, skipping trusted proxies, and the first
+// other address is the client. Forwarded (RFC 7239) takes precedence over
+// X-Forwarded-For when both are present.
+func Resolve(r *http.Request, trusted []netip.Prefix) netip.Addr {
+	peer := Peer(r)
+	if !peer.IsValid() || !contains(trusted, peer) {
+		return peer
+	}
+	hops := forwardedFor(r.Header.Values("Forwarded"))
+	if len(hops) == 0 {
+		hops = strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		a, ok := parseHop(hops[i])
+		if !ok {
+			// Unparseable hops were not written by a proxy we trust.
+			return peer
+		}
+		if !contains(trusted, a) {
+			return a
+		}
+		peer = a
+	}
+	return peer
+}
+
+// Peer returns the address of the TCP peer, or the zero Addr if RemoteAddr
+// does not hold one.
+func Peer(r *http.Request) netip.Addr {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	a, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.Addr{}
+	}
+	return a.Unmap()
+}
+
+// forwardedFor returns the for= parameters of Forwarded header values, in
+// order. Elements without one yield an empty hop, which stops the walk.
+func forwardedFor(values []string) []string {
+	var hops []string
+	for _, v := range values {
+		for _, elem := range strings.Split(v, ",") {
+			if strings.TrimSpace(elem) == "" {
+				continue
+			}
+			hop := ""
+			for _, pair := range strings.Split(elem, ";") {
+				name, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+				if ok && strings.EqualFold(name, "for") {
+					hop = strings.Trim(value, `"`)
+				}
+			}
+			hops = append(hops, hop)
+		}
+	}
+	return hops
+}
+
+// parseHop parses an address from X-Forwarded-For or a Forwarded for=
+// value, which may carry a port ("192.0.2.1:80", "[2001:db8::1]:80").
+// Obfuscated identifiers and "unknown" do not parse.
+func parseHop(s string) (netip.Addr, bool) {
+	s = strings.TrimSpace(s)
+	if a, err := netip.ParseAddr(strings.Trim(s, "[]")); err == nil {
+		return a.Unmap(), true
+	}
+	ap, err := netip.ParseAddrPort(s)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return ap.Addr().Unmap(), true
+}
+
+func contains(prefixes []netip.Prefix, addr netip.Addr) bool {
+	for _, p := range prefixes {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,70 @@
+package clientip
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+)
+
+var trusted = []netip.Prefix{
+	netip.MustParsePrefix("35.191.0.0/16"),
+	netip.MustParsePrefix("10.0.0.0/8"),
+	netip.MustParsePrefix("2001:db8:ffff::/48"),
+}
+
+func TestResolve(t *testing.T) {
+	tests := []struct {
+		name      string
+		remote    string
+		xff       string
+		forwarded string
+		want      string
+	}{
+		{"direct client ignores header", "203.0.113.9:1234", "1.2.3.4", "", "203.0.113.9"},
+		{"behind trusted proxy", "35.191.3.4:80", "198.51.100.7", "", "198.51.100.7"},
+		{"spoofed leftmost hop", "35.191.3.4:80", "6.6.6.6, 198.51.100.7", "", "198.51.100.7"},
+		{"chain of trusted proxies", "10.0.0.2:80", "198.51.100.7, 35.191.3.4", "", "198.51.100.7"},
+		{"only proxies", "10.0.0.2:80", "10.0.0.3", "", "10.0.0.3"},
+		{"garbage hop", "10.0.0.2:80", "not-an-ip", "", "10.0.0.2"},
+		{"no header", "10.0.0.2:80", "", "", "10.0.0.2"},
+		{"forwarded", "10.0.0.2:80", "", `for=198.51.100.7;proto=https`, "198.51.100.7"},
+		{"forwarded with port", "10.0.0.2:80", "", `for="198.51.100.7:4711"`, "198.51.100.7"},
+		{"forwarded ipv6 chain", "10.0.0.2:80", "", `for="[2001:db8::7]:4711", for="[2001:db8:ffff::1]"`, "2001:db8::7"},
+		{"forwarded wins", "10.0.0.2:80", "6.6.6.6", `For=198.51.100.7`, "198.51.100.7"},
+		{"forwarded obfuscated", "10.0.0.2:80", "", `for=_hidden, for=10.0.0.3`, "10.0.0.3"},
+		{"forwarded without for", "10.0.0.2:80", "", `proto=https`, "10.0.0.2"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = tt.remote
+		if tt.xff != "" {
+			r.Header.Set("X-Forwarded-For", tt.xff)
+		}
+		if tt.forwarded != "" {
+			r.Header.Set("Forwarded", tt.forwarded)
+		}
+		if got := Resolve(r, trusted); got.String() != tt.want {
+			t.Errorf("%s: want %s, got %s", tt.name, tt.want, got)
+		}
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	var got netip.Addr
+	h := Middleware(trusted)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = FromRequest(r)
+	}))
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.RemoteAddr = "35.191.0.1:443"
+	r.Header.Set("X-Forwarded-For", "198.51.100.7")
+	h.ServeHTTP(httptest.NewRecorder(), r)
+	if got.String() != "198.51.100.7" {
+		t.Fatalf("want the resolved client, got %s", got)
+	}
+
+	// Without the middleware, headers are not believed.
+	if got := FromRequest(r); got.String() != "35.191.0.1" {
+		t.Fatalf("want the peer, got %s", got)
+	}
+}
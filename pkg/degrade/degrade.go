@@ -0,0 +1,256 @@
+// Package degrade decides when a server drops its non-essential work, such
+// as usage bookkeeping and access log lines, so that what is left of its
+// capacity goes to the requests it exists for. A Switch watches those
+// requests: when, within a window, more than a budget of them fail with
+// 5xx or take longer than a latency bound, it degrades the server until a
+// hold period passes without such a window. An operator may also force
+// it either way.
+package degrade
+
+import (
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Modes of a Switch.
+const (
+	// ModeOff never degrades.
+	ModeOff = "off"
+	// ModeAuto degrades when requests exceed the budget.
+	ModeAuto = "auto"
+	// ModeOn always degrades, e.g. during an incident.
+	ModeOn = "on"
+)
+
+// Config tunes a Switch; zero values take the defaults.
+//
+// Requests slower than Latency (default 250ms) or failing with 5xx are
+// bad. A Window (default 10s) of at least MinRequests (default 50) in
+// which more than Budget (default 0.05) of them are bad degrades the
+// server for Hold (default 1m) from its end.
+type Config struct {
+	Mode        string
+	Latency     time.Duration
+	Budget      float64
+	Window      time.Duration
+	MinRequests int
+	Hold        time.Duration
+}
+
+// ConfigFromEnv reads <prefix> (off, auto or on), <prefix>_LATENCY,
+// <prefix>_BUDGET, <prefix>_WINDOW, <prefix>_MIN_REQUESTS and
+// <prefix>_HOLD. Malformed values are logged and ignored.
+func ConfigFromEnv(prefix string) Config {
+	cfg := Config{
+		Mode:        os.Getenv(prefix),
+		Latency:     envDuration(prefix + "_LATENCY"),
+		Budget:      envFraction(prefix + "_BUDGET"),
+		Window:      envDuration(prefix + "_WINDOW"),
+		MinRequests: envInt(prefix + "_MIN_REQUESTS"),
+		Hold:        envDuration(prefix + "_HOLD"),
+	}
+	switch cfg.Mode {
+	case "", ModeOff, ModeAuto, ModeOn:
+	default:
+		log.Printf("ignoring invalid %s=%q", prefix, cfg.Mode)
+		cfg.Mode = ""
+	}
+	return cfg
+}
+
+func envInt(k string) int {
+	v := os.Getenv(k)
+	if v == "" {
+		return 0
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		log.Printf("ignoring invalid %s=%q", k, v)
+		return 0
+	}
+	return n
+}
+
+func envFraction(k string) float64 {
+	v := os.Getenv(k)
+	if v == "" {
+		return 0
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil || f < 0 || f > 1 {
+		log.Printf("ignoring invalid %s=%q", k, v)
+		return 0
+	}
+	return f
+}
+
+func envDuration(k string) time.Duration {
+	v := os.Getenv(k)
+	if v == "" {
+		return 0
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		log.Printf("ignoring invalid %s=%q", k, v)
+		return 0
+	}
+	return d
+}
+
+func (c Config) withDefaults() Config {
+	if c.Latency <= 0 {
+		c.Latency = 250 * time.Millisecond
+	}
+	if c.Budget <= 0 {
+		c.Budget = 0.05
+	}
+	if c.Window <= 0 {
+		c.Window = 10 * time.Second
+	}
+	if c.MinRequests <= 0 {
+		c.MinRequests = 50
+	}
+	if c.Hold <= 0 {
+		c.Hold = time.Minute
+	}
+	return c
+}
+
+// Switch tells whether to drop non-essential work. The nil *Switch never
+// degrades.
+type Switch struct {
+	name string
+	cfg  Config
+	now  func() time.Time
+
+	// until is when the server stops being degraded, in Unix nanoseconds.
+	until atomic.Int64
+	// total and bad count the requests of the current window, which
+	// started at start, in Unix nanoseconds.
+	total atomic.Int64
+	bad   atomic.Int64
+	start atomic.Int64
+
+	// mu serializes the close of windows.
+	mu       sync.Mutex
+	degraded bool
+	trips    int64
+}
+
+// New returns a switch for cfg, named in logs, or nil if cfg is off.
+func New(name string, cfg Config) *Switch {
+	if cfg.Mode == "" || cfg.Mode == ModeOff {
+		return nil
+	}
+	cfg = cfg.withDefaults()
+	if cfg.Mode == ModeOn {
+		log.Printf("%s degraded: forced on", name)
+	} else {
+		log.Printf("%s degrades when more than %g of %v of requests are slower than %v or fail", name, cfg.Budget, cfg.Window, cfg.Latency)
+	}
+	s := &Switch{name: name, cfg: cfg, now: time.Now}
+	s.start.Store(s.now().UnixNano())
+	return s
+}
+
+// Degraded reports whether non-essential work should be dropped now.
+func (s *Switch) Degraded() bool {
+	if s == nil {
+		return false
+	}
+	return s.cfg.Mode == ModeOn || s.now().UnixNano() < s.until.Load()
+}
+
+// Observe records a request that took latency and failed, with a 5xx, or
+// not.
+func (s *Switch) Observe(latency time.Duration, failed bool) {
+	if s == nil || s.cfg.Mode == ModeOn {
+		return
+	}
+	s.total.Add(1)
+	if failed || latency > s.cfg.Latency {
+		s.bad.Add(1)
+	}
+	now := s.now().UnixNano()
+	if now-s.start.Load() < int64(s.cfg.Window) || !s.mu.TryLock() {
+		return
+	}
+	defer s.mu.Unlock()
+	if now-s.start.Load() >= int64(s.cfg.Window) {
+		s.closeWindow(now)
+	}
+}
+
+// closeWindow judges the window ending at now and starts the next one.
+// Requests observed meanwhile may land in either.
+func (s *Switch) closeWindow(now int64) {
+	total, bad := s.total.Swap(0), s.bad.Swap(0)
+	s.start.Store(now)
+	if total >= int64(s.cfg.MinRequests) && float64(bad) > s.cfg.Budget*float64(total) {
+		s.until.Store(now + int64(s.cfg.Hold))
+		if !s.degraded {
+			s.degraded = true
+			s.trips++
+			log.Printf("%s degraded: %d of %d requests slower than %v or failed", s.name, bad, total, s.cfg.Latency)
+		}
+		return
+	}
+	if s.degraded && now >= s.until.Load() {
+		s.degraded = false
+		log.Printf("%s recovered: %d of %d requests slower than %v or failed", s.name, bad, total, s.cfg.Latency)
+	}
+}
+
+// Track observes a request answered through the returned writer, once
+// done is called, like a middleware would.
+func (s *Switch) Track(w http.ResponseWriter) (tracked http.ResponseWriter, done func()) {
+	if s == nil {
+		return w, func() {}
+	}
+	start := time.Now()
+	rec := &recorder{ResponseWriter: w}
+	return rec, func() {
+		s.Observe(time.Since(start), rec.status >= http.StatusInternalServerError)
+	}
+}
+
+// Stats describes a switch, for status pages.
+type Stats struct {
+	Mode     string `json:"mode"`
+	Degraded bool   `json:"degraded"`
+	// Trips counts the times the switch degraded the server since start.
+	Trips int64 `json:"trips"`
+}
+
+// Stats returns the state of the switch.
+func (s *Switch) Stats() Stats {
+	if s == nil {
+		return Stats{Mode: ModeOff}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return Stats{Mode: s.cfg.Mode, Degraded: s.Degraded(), Trips: s.trips}
+}
+
+// recorder remembers the status of a response. Unwrap lets
+// http.ResponseController reach the writer below, to flush.
+type recorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *recorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *recorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
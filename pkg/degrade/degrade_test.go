@@ -0,0 +1,98 @@
+package degrade
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// fakeClock is a time source tests move by hand.
+type fakeClock struct{ t time.Time }
+
+func (c *fakeClock) now() time.Time { return c.t }
+
+func newTestSwitch(cfg Config) (*Switch, *fakeClock) {
+	clock := &fakeClock{t: time.Unix(1000, 0)}
+	s := New("test", cfg)
+	s.now = clock.now
+	s.start.Store(clock.t.UnixNano())
+	return s, clock
+}
+
+func TestSwitch_Auto(t *testing.T) {
+	s, clock := newTestSwitch(Config{Mode: ModeAuto, Latency: 100 * time.Millisecond, Budget: 0.1, Window: time.Second, MinRequests: 10, Hold: 5 * time.Second})
+	observe := func(ok, slow, failed int) {
+		for range ok {
+			s.Observe(10*time.Millisecond, false)
+		}
+		for range slow {
+			s.Observe(time.Second, false)
+		}
+		for range failed {
+			s.Observe(time.Millisecond, true)
+		}
+	}
+
+	// Within budget.
+	observe(18, 1, 1)
+	clock.t = clock.t.Add(time.Second)
+	observe(1, 0, 0)
+	if s.Degraded() {
+		t.Fatal("degraded within budget")
+	}
+
+	// Too few requests to judge.
+	observe(0, 5, 0)
+	clock.t = clock.t.Add(time.Second)
+	observe(1, 0, 0)
+	if s.Degraded() {
+		t.Fatal("degraded on too few requests")
+	}
+
+	// Over budget: degraded for Hold after the window.
+	observe(10, 2, 2)
+	clock.t = clock.t.Add(time.Second)
+	observe(1, 0, 0)
+	if !s.Degraded() {
+		t.Fatal("want degraded over budget")
+	}
+	clock.t = clock.t.Add(4 * time.Second)
+	if !s.Degraded() {
+		t.Fatal("want degraded during the hold")
+	}
+	clock.t = clock.t.Add(time.Second)
+	if s.Degraded() {
+		t.Fatal("want recovered after the hold")
+	}
+	if st := s.Stats(); st.Trips != 1 || st.Mode != ModeAuto {
+		t.Errorf("Stats() = %+v", st)
+	}
+}
+
+func TestSwitch_Forced(t *testing.T) {
+	if s := New("test", Config{Mode: ModeOff}); s != nil || s.Degraded() {
+		t.Fatal("want no switch when off")
+	}
+	s := New("test", Config{Mode: ModeOn})
+	if !s.Degraded() {
+		t.Fatal("want degraded when forced on")
+	}
+}
+
+func TestSwitch_Track(t *testing.T) {
+	s, clock := newTestSwitch(Config{Mode: ModeAuto, Window: time.Second, MinRequests: 2})
+	fail := func() {
+		w, done := s.Track(httptest.NewRecorder())
+		defer done()
+		http.Error(w, "boom", http.StatusInternalServerError)
+	}
+	for range 3 {
+		fail()
+	}
+	clock.t = clock.t.Add(time.Second)
+	fail()
+	if !s.Degraded() {
+		t.Fatal("want degraded after failing requests")
+	}
+}
@@ -0,0 +1,253 @@
+// Package discovery finds the pods behind a service, such as keygen or
+// memcache, from the DNS records of its headless Kubernetes service, and
+// follows them as pods come and go. A target names them in one of three
+// ways:
+//
+//	srv:_memcache._tcp.memcached.shortener.svc.cluster.local
+//	dns:shortener-keygen-headless.shortener.svc.cluster.local:8083
+//	10.0.0.7:11211,10.0.0.8:11211
+//
+// The first looks up SRV records, which carry the port of each pod, the
+// second A and AAAA records, with the port given, and the last is a static
+// list of host:port addresses. Records are resolved again once their TTL
+// runs out, as a single URL resolved once does not survive pod churn.
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Defaults.
+const (
+	// DefaultMinRefresh and DefaultMaxRefresh bound the TTLs addresses are
+	// kept for, so a 0 TTL does not resolve the name on every use and a
+	// day-long one still notices pods replaced.
+	DefaultMinRefresh = time.Second
+	DefaultMaxRefresh = 5 * time.Minute
+	// DefaultRefresh is how long addresses are kept when their TTL is
+	// unknown: that of the records of Kubernetes services.
+	DefaultRefresh = 5 * time.Second
+)
+
+// Config tunes a Watcher. The zero Config uses the defaults.
+type Config struct {
+	// Resolver looks the records up; nil uses NewResolver.
+	Resolver Resolver
+	// MinRefresh, MaxRefresh and Refresh: 0 means DefaultMinRefresh,
+	// DefaultMaxRefresh and DefaultRefresh.
+	MinRefresh time.Duration
+	MaxRefresh time.Duration
+	Refresh    time.Duration
+}
+
+// Resolver looks DNS records up, with the TTL of the answer, or 0 when
+// it cannot tell.
+type Resolver interface {
+	LookupHost(ctx context.Context, host string) (addrs []string, ttl time.Duration, err error)
+	LookupSRV(ctx context.Context, name string) (srvs []*net.SRV, ttl time.Duration, err error)
+}
+
+// Target is what a Watcher resolves.
+type Target struct {
+	// SRV is set for srv: targets, and Name is then the SRV name, or the
+	// host of dns: targets, whose Port it is.
+	SRV  bool
+	Name string
+	Port string
+	// Static lists the addresses of static targets.
+	Static []string
+}
+
+// ParseTarget parses a target, e.g. "srv:_memcache._tcp.memcached",
+// "dns:keygen-headless:8083" or "10.0.0.7:11211,10.0.0.8:11211".
+func ParseTarget(s string) (Target, error) {
+	s = strings.TrimSpace(s)
+	if name, ok := strings.CutPrefix(s, "srv:"); ok {
+		if name == "" {
+			return Target{}, fmt.Errorf("discovery: %q names no SRV records", s)
+		}
+		return Target{SRV: true, Name: name}, nil
+	}
+	if addr, ok := strings.CutPrefix(s, "dns:"); ok {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil || host == "" || port == "" {
+			return Target{}, fmt.Errorf("discovery: %q is not dns:host:port", s)
+		}
+		return Target{Name: host, Port: port}, nil
+	}
+	var t Target
+	for addr := range strings.SplitSeq(s, ",") {
+		addr = strings.TrimSpace(addr)
+		if host, port, err := net.SplitHostPort(addr); err != nil || host == "" || port == "" {
+			return Target{}, fmt.Errorf("discovery: %q is not srv:name, dns:host:port or a list of host:port", s)
+		}
+		t.Static = append(t.Static, addr)
+	}
+	return t, nil
+}
+
+// String formats t as ParseTarget takes it.
+func (t Target) String() string {
+	switch {
+	case t.Static != nil:
+		return strings.Join(t.Static, ",")
+	case t.SRV:
+		return "srv:" + t.Name
+	}
+	return "dns:" + net.JoinHostPort(t.Name, t.Port)
+}
+
+// Watcher keeps the addresses of a target, host:port each, sorted.
+type Watcher struct {
+	target   Target
+	resolver Resolver
+	cfg      Config
+
+	mu      sync.Mutex
+	addrs   []string
+	expires time.Time
+}
+
+// NewWatcher returns a watcher of target, which it does not resolve yet.
+func NewWatcher(target string, cfg Config) (*Watcher, error) {
+	t, err := ParseTarget(target)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Resolver == nil {
+		cfg.Resolver = NewResolver()
+	}
+	if cfg.MinRefresh <= 0 {
+		cfg.MinRefresh = DefaultMinRefresh
+	}
+	if cfg.MaxRefresh <= 0 {
+		cfg.MaxRefresh = DefaultMaxRefresh
+	}
+	if cfg.Refresh <= 0 {
+		cfg.Refresh = DefaultRefresh
+	}
+	return &Watcher{target: t, resolver: cfg.Resolver, cfg: cfg}, nil
+}
+
+// Target returns the target w resolves.
+func (w *Watcher) Target() Target {
+	return w.target
+}
+
+// Lookup returns the addresses of the target, resolving them when their
+// TTL ran out.
+func (w *Watcher) Lookup(ctx context.Context) ([]string, error) {
+	w.mu.Lock()
+	if w.addrs != nil && time.Now().Before(w.expires) {
+		addrs := slices.Clone(w.addrs)
+		w.mu.Unlock()
+		return addrs, nil
+	}
+	w.mu.Unlock()
+	return w.Refresh(ctx)
+}
+
+// Refresh resolves the addresses of the target now.
+func (w *Watcher) Refresh(ctx context.Context) ([]string, error) {
+	addrs, ttl, err := w.resolve(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("discovery: resolving %s: %w", w.target, err)
+	}
+	slices.Sort(addrs)
+	addrs = slices.Compact(addrs)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.addrs = addrs
+	w.expires = time.Now().Add(w.keep(ttl))
+	return slices.Clone(addrs), nil
+}
+
+// Expire has the next Lookup resolve the target again, e.g. once one of
+// its addresses failed.
+func (w *Watcher) Expire() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.expires = time.Time{}
+}
+
+// keep returns how long addresses with ttl are kept.
+func (w *Watcher) keep(ttl time.Duration) time.Duration {
+	if ttl <= 0 {
+		return w.cfg.Refresh
+	}
+	return min(max(ttl, w.cfg.MinRefresh), w.cfg.MaxRefresh)
+}
+
+func (w *Watcher) resolve(ctx context.Context) ([]string, time.Duration, error) {
+	t := w.target
+	if t.Static != nil {
+		return slices.Clone(t.Static), w.cfg.MaxRefresh, nil
+	}
+	if t.SRV {
+		srvs, ttl, err := w.resolver.LookupSRV(ctx, t.Name)
+		if err != nil {
+			return nil, 0, err
+		}
+		addrs := make([]string, len(srvs))
+		for i, srv := range srvs {
+			addrs[i] = net.JoinHostPort(strings.TrimSuffix(srv.Target, "."), fmt.Sprint(srv.Port))
+		}
+		return addrs, ttl, nil
+	}
+	if net.ParseIP(t.Name) != nil {
+		return []string{net.JoinHostPort(t.Name, t.Port)}, w.cfg.MaxRefresh, nil
+	}
+	hosts, ttl, err := w.resolver.LookupHost(ctx, t.Name)
+	if err != nil {
+		return nil, 0, err
+	}
+	addrs := make([]string, len(hosts))
+	for i, h := range hosts {
+		addrs[i] = net.JoinHostPort(h, t.Port)
+	}
+	return addrs, ttl, nil
+}
+
+// Watch resolves the target whenever its TTL runs out, calling changed
+// with the addresses when they differ from the last ones, until ctx is
+// done. Failures keep the last addresses and are retried every
+// MinRefresh.
+func (w *Watcher) Watch(ctx context.Context, changed func(addrs []string)) {
+	w.mu.Lock()
+	last := slices.Clone(w.addrs)
+	w.mu.Unlock()
+	for {
+		w.mu.Lock()
+		wait := time.Until(w.expires)
+		w.mu.Unlock()
+		if wait > 0 {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(wait):
+			}
+		}
+		addrs, err := w.Refresh(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			log.Printf("%v; keeping %d addresses", err, len(last))
+			w.mu.Lock()
+			w.expires = time.Now().Add(w.cfg.MinRefresh)
+			w.mu.Unlock()
+			continue
+		}
+		if !slices.Equal(addrs, last) {
+			last = addrs
+			changed(addrs)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package discovery
+
+import (
+	"context"
+	"errors"
+	"net"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParseTarget(t *testing.T) {
+	for _, tt := range []struct {
+		in   string
+		want Target
+	}{
+		{"srv:_memcache._tcp.memcached.shortener.svc.cluster.local", Target{SRV: true, Name: "_memcache._tcp.memcached.shortener.svc.cluster.local"}},
+		{"dns:keygen-headless:8083", Target{Name: "keygen-headless", Port: "8083"}},
+		{"dns:[::1]:11211", Target{Name: "::1", Port: "11211"}},
+		{"10.0.0.7:11211, 10.0.0.8:11211", Target{Static: []string{"10.0.0.7:11211", "10.0.0.8:11211"}}},
+	} {
+		got, err := ParseTarget(tt.in)
+		if err != nil {
+			t.Errorf("%q: %v", tt.in, err)
+			continue
+		}
+		if got.SRV != tt.want.SRV || got.Name != tt.want.Name || got.Port != tt.want.Port || !slices.Equal(got.Static, tt.want.Static) {
+			t.Errorf("%q: got %+v, want %+v", tt.in, got, tt.want)
+		}
+		if again, err := ParseTarget(got.String()); err != nil || again.String() != got.String() {
+			t.Errorf("%q: String %q does not parse back: %v", tt.in, got.String(), err)
+		}
+	}
+	for _, in := range []string{"", "srv:", "dns:keygen", "dns::8083", "memcached", "10.0.0.7:11211,", "http://keygen:8083"} {
+		if _, err := ParseTarget(in); err == nil {
+			t.Errorf("%q: want an error", in)
+		}
+	}
+}
+
+// fakeResolver answers with hosts and SRV records set by the test.
+type fakeResolver struct {
+	mu      sync.Mutex
+	hosts   []string
+	srvs    []*net.SRV
+	ttl     time.Duration
+	err     error
+	lookups int
+}
+
+func (r *fakeResolver) set(hosts []string, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.hosts, r.err = hosts, err
+}
+
+func (r *fakeResolver) LookupHost(ctx context.Context, host string) ([]string, time.Duration, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.lookups++
+	return slices.Clone(r.hosts), r.ttl, r.err
+}
+
+func (r *fakeResolver) LookupSRV(ctx context.Context, name string) ([]*net.SRV, time.Duration, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.lookups++
+	return r.srvs, r.ttl, r.err
+}
+
+func TestWatcher_Lookup(t *testing.T) {
+	r := &fakeResolver{hosts: []string{"10.0.0.2", "10.0.0.1", "10.0.0.2"}, ttl: time.Hour}
+	w, err := NewWatcher("dns:keygen-headless:8083", Config{Resolver: r})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	for range 2 {
+		addrs, err := w.Lookup(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := []string{"10.0.0.1:8083", "10.0.0.2:8083"}; !slices.Equal(addrs, want) {
+			t.Errorf("Lookup = %v, want %v", addrs, want)
+		}
+	}
+	if r.lookups != 1 {
+		t.Errorf("want the addresses kept for their TTL, got %d lookups", r.lookups)
+	}
+	w.Expire()
+	r.set([]string{"10.0.0.3"}, nil)
+	if addrs, _ := w.Lookup(ctx); !slices.Equal(addrs, []string{"10.0.0.3:8083"}) || r.lookups != 2 {
+		t.Errorf("after Expire: got %v after %d lookups", addrs, r.lookups)
+	}
+	w.Expire()
+	r.set(nil, errors.New("no such host"))
+	if _, err := w.Lookup(ctx); err == nil {
+		t.Error("want the failed resolution reported")
+	}
+
+	r.srvs = []*net.SRV{{Target: "memcached-1.memcached.shortener.svc.cluster.local.", Port: 11211}, {Target: "memcached-0.memcached.shortener.svc.cluster.local.", Port: 11211}}
+	r.err = nil
+	w, _ = NewWatcher("srv:_memcache._tcp.memcached.shortener.svc.cluster.local", Config{Resolver: r})
+	want := []string{"memcached-0.memcached.shortener.svc.cluster.local:11211", "memcached-1.memcached.shortener.svc.cluster.local:11211"}
+	if addrs, err := w.Lookup(ctx); err != nil || !slices.Equal(addrs, want) {
+		t.Errorf("SRV: got %v, %v, want %v", addrs, err, want)
+	}
+
+	lookups := r.lookups
+	w, _ = NewWatcher("10.0.0.7:11211", Config{Resolver: r})
+	if addrs, err := w.Lookup(ctx); err != nil || !slices.Equal(addrs, []string{"10.0.0.7:11211"}) || r.lookups != lookups {
+		t.Errorf("static: got %v, %v, want the list without lookups", addrs, err)
+	}
+}
+
+func TestWatcher_Keep(t *testing.T) {
+	w, _ := NewWatcher("dns:keygen:8083", Config{Resolver: &fakeResolver{}, MinRefresh: time.Second, MaxRefresh: time.Minute, Refresh: 5 * time.Second})
+	for ttl, want := range map[time.Duration]time.Duration{
+		0:                5 * time.Second,
+		time.Millisecond: time.Second,
+		30 * time.Second: 30 * time.Second,
+		24 * time.Hour:   time.Minute,
+	} {
+		if got := w.keep(ttl); got != want {
+			t.Errorf("keep(%v) = %v, want %v", ttl, got, want)
+		}
+	}
+}
+
+func TestWatcher_Watch(t *testing.T) {
+	r := &fakeResolver{hosts: []string{"10.0.0.1"}}
+	w, _ := NewWatcher("dns:memcached:11211", Config{Resolver: r, Refresh: time.Millisecond, MinRefresh: time.Millisecond})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	changes := make(chan []string, 10)
+	go w.Watch(ctx, func(addrs []string) { changes <- addrs })
+
+	next := func() []string {
+		select {
+		case addrs := <-changes:
+			return addrs
+		case <-time.After(5 * time.Second):
+			t.Fatal("want a change")
+		}
+		return nil
+	}
+	if got := next(); !slices.Equal(got, []string{"10.0.0.1:11211"}) {
+		t.Errorf("first = %v", got)
+	}
+	// Failures keep the last addresses, and unchanged ones are not
+	// reported.
+	r.set(nil, errors.New("timeout"))
+	time.Sleep(10 * time.Millisecond)
+	r.set([]string{"10.0.0.1"}, nil)
+	time.Sleep(10 * time.Millisecond)
+	r.set([]string{"10.0.0.2", "10.0.0.1"}, nil)
+	if got := next(); !slices.Equal(got, []string{"10.0.0.1:11211", "10.0.0.2:11211"}) {
+		t.Errorf("second = %v", got)
+	}
+}
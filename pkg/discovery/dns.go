@@ -0,0 +1,203 @@
+package discovery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// resolvConf lists the nameservers NewResolver asks.
+const resolvConf = "/etc/resolv.conf"
+
+// dnsTimeout bounds a query of one nameserver.
+const dnsTimeout = 2 * time.Second
+
+// errTruncated reports an answer too large for UDP.
+var errTruncated = errors.New("truncated answer")
+
+// dnsResolver asks the nameservers of resolv.conf itself, as the Go
+// resolver hides the TTLs of the answers. Names with a dot are looked up
+// as they are, as the full names of Kubernetes services are; names
+// without, names the nameservers do not know and answers it cannot read,
+// e.g. truncated ones, are left to the Go resolver, which completes them
+// with the search domains, with an unknown TTL.
+type dnsResolver struct {
+	servers []string
+	dialer  net.Dialer
+}
+
+// NewResolver returns a Resolver telling the TTLs of the records of the
+// nameservers in /etc/resolv.conf.
+func NewResolver() Resolver {
+	return &dnsResolver{servers: readNameservers(resolvConf)}
+}
+
+// readNameservers returns the host:port of the nameservers of a
+// resolv.conf file, none when it cannot be read.
+func readNameservers(path string) []string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	var servers []string
+	for line := range strings.Lines(string(data)) {
+		if f := strings.Fields(line); len(f) >= 2 && f[0] == "nameserver" {
+			servers = append(servers, net.JoinHostPort(f[1], "53"))
+		}
+	}
+	return servers
+}
+
+func (r *dnsResolver) LookupHost(ctx context.Context, host string) ([]string, time.Duration, error) {
+	if r.direct(host) {
+		var addrs []string
+		var ttl uint32
+		var err error
+		for _, qtype := range []dnsmessage.Type{dnsmessage.TypeA, dnsmessage.TypeAAAA} {
+			var answers []dnsmessage.Resource
+			if answers, err = r.query(ctx, host, qtype); err != nil {
+				break
+			}
+			for _, a := range answers {
+				switch body := a.Body.(type) {
+				case *dnsmessage.AResource:
+					addrs = append(addrs, net.IP(body.A[:]).String())
+				case *dnsmessage.AAAAResource:
+					addrs = append(addrs, net.IP(body.AAAA[:]).String())
+				default:
+					continue
+				}
+				ttl = minTTL(ttl, a.Header.TTL)
+			}
+		}
+		switch {
+		case err == nil && len(addrs) > 0:
+			return addrs, seconds(ttl), nil
+		case isNotFound(err) && strings.HasSuffix(host, "."):
+			return nil, 0, err
+		}
+	}
+	addrs, err := net.DefaultResolver.LookupHost(ctx, host)
+	return addrs, 0, err
+}
+
+func (r *dnsResolver) LookupSRV(ctx context.Context, name string) ([]*net.SRV, time.Duration, error) {
+	if r.direct(name) {
+		answers, err := r.query(ctx, name, dnsmessage.TypeSRV)
+		var srvs []*net.SRV
+		var ttl uint32
+		for _, a := range answers {
+			if body, ok := a.Body.(*dnsmessage.SRVResource); ok {
+				srvs = append(srvs, &net.SRV{Target: body.Target.String(), Port: body.Port, Priority: body.Priority, Weight: body.Weight})
+				ttl = minTTL(ttl, a.Header.TTL)
+			}
+		}
+		switch {
+		case err == nil && len(srvs) > 0:
+			return srvs, seconds(ttl), nil
+		case isNotFound(err) && strings.HasSuffix(name, "."):
+			return nil, 0, err
+		}
+	}
+	_, srvs, err := net.DefaultResolver.LookupSRV(ctx, "", "", name)
+	return srvs, 0, err
+}
+
+// direct reports whether name is looked up by r rather than by the Go
+// resolver.
+func (r *dnsResolver) direct(name string) bool {
+	return len(r.servers) > 0 && strings.Contains(strings.TrimSuffix(name, "."), ".")
+}
+
+// query asks the nameservers in turn for the records of name of qtype,
+// returning the answers of the first to answer.
+func (r *dnsResolver) query(ctx context.Context, name string, qtype dnsmessage.Type) ([]dnsmessage.Resource, error) {
+	n, err := dnsmessage.NewName(strings.TrimSuffix(name, ".") + ".")
+	if err != nil {
+		return nil, err
+	}
+	id := uint16(rand.Uint32())
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: id, RecursionDesired: true})
+	b.EnableCompression()
+	b.StartQuestions()
+	b.Question(dnsmessage.Question{Name: n, Type: qtype, Class: dnsmessage.ClassINET})
+	// Answers of up to 4KB fit in UDP, as SRV records of many pods need.
+	b.StartAdditionals()
+	var opt dnsmessage.ResourceHeader
+	opt.SetEDNS0(4096, dnsmessage.RCodeSuccess, false)
+	b.OPTResource(opt, dnsmessage.OPTResource{})
+	q, err := b.Finish()
+	if err != nil {
+		return nil, err
+	}
+	for _, server := range r.servers {
+		var msg *dnsmessage.Message
+		if msg, err = r.exchange(ctx, server, q, id); err != nil {
+			continue
+		}
+		switch msg.RCode {
+		case dnsmessage.RCodeSuccess:
+			return msg.Answers, nil
+		case dnsmessage.RCodeNameError:
+			return nil, &net.DNSError{Err: "no such host", Name: name, Server: server, IsNotFound: true}
+		}
+		err = fmt.Errorf("%s answered %v", server, msg.RCode)
+	}
+	return nil, &net.DNSError{Err: err.Error(), Name: name}
+}
+
+// exchange sends q to server and reads its answer.
+func (r *dnsResolver) exchange(ctx context.Context, server string, q []byte, id uint16) (*dnsmessage.Message, error) {
+	ctx, cancel := context.WithTimeout(ctx, dnsTimeout)
+	defer cancel()
+	conn, err := r.dialer.DialContext(ctx, "udp", server)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	deadline, _ := ctx.Deadline()
+	conn.SetDeadline(deadline)
+	if _, err := conn.Write(q); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 4096)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		var msg dnsmessage.Message
+		if err := msg.Unpack(buf[:n]); err != nil || msg.ID != id || !msg.Response {
+			// Not the answer: a late one to an earlier query.
+			continue
+		}
+		if msg.Truncated {
+			return nil, errTruncated
+		}
+		return &msg, nil
+	}
+}
+
+func isNotFound(err error) bool {
+	var dnsErr *net.DNSError
+	return errors.As(err, &dnsErr) && dnsErr.IsNotFound
+}
+
+// minTTL returns the smaller of the TTLs, ttl 0 being none yet.
+func minTTL(ttl, next uint32) uint32 {
+	if ttl == 0 {
+		return next
+	}
+	return min(ttl, next)
+}
+
+func seconds(ttl uint32) time.Duration {
+	return time.Duration(ttl) * time.Second
+}
@@ -0,0 +1,108 @@
+package discovery
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// nameserver answers A, AAAA and SRV queries from records, and NXDOMAIN
+// for names it has none of.
+func nameserver(t *testing.T, records map[string][]dnsmessage.Resource) string {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			var q dnsmessage.Message
+			if err := q.Unpack(buf[:n]); err != nil || len(q.Questions) != 1 {
+				continue
+			}
+			question := q.Questions[0]
+			resp := dnsmessage.Message{Header: dnsmessage.Header{ID: q.ID, Response: true}, Questions: q.Questions}
+			answers, ok := records[question.Name.String()]
+			if !ok {
+				resp.RCode = dnsmessage.RCodeNameError
+			}
+			for _, a := range answers {
+				if a.Header.Type == question.Type {
+					a.Header.Name, a.Header.Class = question.Name, dnsmessage.ClassINET
+					resp.Answers = append(resp.Answers, a)
+				}
+			}
+			out, err := resp.Pack()
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			conn.WriteTo(out, addr)
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+func TestDNSResolver(t *testing.T) {
+	server := nameserver(t, map[string][]dnsmessage.Resource{
+		"keygen.shortener.test.": {
+			{Header: dnsmessage.ResourceHeader{Type: dnsmessage.TypeA, TTL: 30}, Body: &dnsmessage.AResource{A: [4]byte{10, 0, 0, 1}}},
+			{Header: dnsmessage.ResourceHeader{Type: dnsmessage.TypeA, TTL: 10}, Body: &dnsmessage.AResource{A: [4]byte{10, 0, 0, 2}}},
+			{Header: dnsmessage.ResourceHeader{Type: dnsmessage.TypeAAAA, TTL: 20}, Body: &dnsmessage.AAAAResource{AAAA: [16]byte{15: 1}}},
+		},
+		"_memcache._tcp.memcached.shortener.test.": {
+			{Header: dnsmessage.ResourceHeader{Type: dnsmessage.TypeSRV, TTL: 5}, Body: &dnsmessage.SRVResource{Target: dnsmessage.MustNewName("memcached-0.memcached.shortener.test."), Port: 11211}},
+		},
+	})
+	r := &dnsResolver{servers: []string{server}}
+	ctx := context.Background()
+
+	addrs, ttl, err := r.LookupHost(ctx, "keygen.shortener.test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"10.0.0.1", "10.0.0.2", "::1"}; !slices.Equal(addrs, want) || ttl != 10*time.Second {
+		t.Errorf("LookupHost = %v, %v, want %v, 10s", addrs, ttl, want)
+	}
+	srvs, ttl, err := r.LookupSRV(ctx, "_memcache._tcp.memcached.shortener.test.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(srvs) != 1 || srvs[0].Target != "memcached-0.memcached.shortener.test." || srvs[0].Port != 11211 || ttl != 5*time.Second {
+		t.Errorf("LookupSRV = %+v, %v", srvs, ttl)
+	}
+
+	// Full names the nameserver does not know are not found; others are
+	// left to the Go resolver, which knows localhost without a TTL.
+	if _, _, err := r.LookupHost(ctx, "missing.shortener.test."); !isNotFound(err) {
+		t.Errorf("missing full name: want not found, got %v", err)
+	}
+	if addrs, ttl, err := r.LookupHost(ctx, "localhost"); err != nil || len(addrs) == 0 || ttl != 0 {
+		t.Errorf("localhost: got %v, %v, %v", addrs, ttl, err)
+	}
+}
+
+func TestReadNameservers(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "resolv.conf")
+	conf := "search shortener.svc.cluster.local svc.cluster.local\nnameserver 10.96.0.10\nnameserver fd00::a\noptions ndots:5\n"
+	if err := os.WriteFile(path, []byte(conf), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := readNameservers(path), []string{"10.96.0.10:53", "[fd00::a]:53"}; !slices.Equal(got, want) {
+		t.Errorf("readNameservers = %v, want %v", got, want)
+	}
+	if got := readNameservers(filepath.Join(t.TempDir(), "missing")); got != nil {
+		t.Errorf("missing file: got %v", got)
+	}
+}
@@ -0,0 +1,69 @@
+package discovery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/gomemcache/memcache"
+)
+
+// memorystorePolling is how often the Memorystore discovery endpoint is
+// asked for the nodes.
+const memorystorePolling = 5 * time.Second
+
+// ValidateMemcache checks the settings NewMemcache takes: at most one of
+// them, servers being a target.
+func ValidateMemcache(servers, discoveryEndpoint string) error {
+	if servers == "" {
+		return nil
+	}
+	if discoveryEndpoint != "" {
+		return errors.New("MEMCACHE_SERVERS and MEMCACHE_DISCOVERY_ENDPOINT are exclusive")
+	}
+	if _, err := ParseTarget(servers); err != nil {
+		return fmt.Errorf("MEMCACHE_SERVERS: %w", err)
+	}
+	return nil
+}
+
+// NewMemcache returns a memcache client of the nodes of servers, a
+// target, when set, e.g. "srv:_memcache._tcp.memcached.shortener.svc.cluster.local",
+// else of those the Memorystore discovery endpoint lists. Either way the
+// client follows the nodes as they change until its StopPolling is
+// called, as urlstore's cache does on Close.
+func NewMemcache(servers, discoveryEndpoint string) (*memcache.Client, error) {
+	if servers == "" {
+		return memcache.NewDiscoveryClient(discoveryEndpoint, memorystorePolling)
+	}
+	w, err := NewWatcher(servers, Config{})
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	lookup, stop := context.WithTimeout(ctx, 10*time.Second)
+	addrs, err := w.Lookup(lookup)
+	stop()
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	list := new(memcache.ServerList)
+	if err := list.SetServers(addrs...); err != nil {
+		cancel()
+		return nil, fmt.Errorf("discovery: memcache servers %v: %w", addrs, err)
+	}
+	if w.Target().Static == nil {
+		go w.Watch(ctx, func(addrs []string) {
+			log.Printf("memcache servers of %s: %v", servers, addrs)
+			if err := list.SetServers(addrs...); err != nil {
+				log.Printf("memcache servers of %s: %v", servers, err)
+			}
+		})
+	}
+	mc := memcache.NewFromSelector(list)
+	mc.StopPolling = func() { cancel() }
+	return mc, nil
+}
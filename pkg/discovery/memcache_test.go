@@ -0,0 +1,28 @@
+package discovery
+
+import (
+	"net"
+	"testing"
+
+	"github.com/google/gomemcache/memcache"
+)
+
+func TestNewMemcache(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln.Close()
+	// The node is gone: the client must try it, not find no servers.
+	mc, err := NewMemcache(ln.Addr().String(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mc.StopPolling()
+	if _, err := mc.Get("k"); err == nil || err == memcache.ErrNoServers {
+		t.Errorf("Get = %v, want a connection error", err)
+	}
+	if _, err := NewMemcache("memcached", ""); err == nil {
+		t.Error("want an invalid target refused")
+	}
+}
@@ -0,0 +1,102 @@
+// Package envelope encrypts small values with AES-256-GCM under a data
+// key that is kept wrapped (encrypted) by a Cloud KMS key, so the data key
+// never appears in config in the clear and KMS is called once at startup
+// rather than per value.
+package envelope
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+
+	"github.com/FlorinBalint/shortener/pkg/gcputil"
+)
+
+// ErrDecrypt is returned for values that were not sealed with the cipher's
+// data key, or were tampered with.
+var ErrDecrypt = errors.New("envelope: cannot decrypt value")
+
+// DataKeySize is the size of data keys, for AES-256.
+const DataKeySize = 32
+
+// Cipher seals and opens values with a data key.
+type Cipher struct {
+	aead cipher.AEAD
+}
+
+// NewCipher returns a cipher for a DataKeySize-byte data key.
+func NewCipher(dataKey []byte) (*Cipher, error) {
+	if len(dataKey) != DataKeySize {
+		return nil, fmt.Errorf("envelope: data key has %d bytes, want %d", len(dataKey), DataKeySize)
+	}
+	block, err := aes.NewCipher(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &Cipher{aead: aead}, nil
+}
+
+// FromKMS unwraps wrappedKey, a base64 data key encrypted with the Cloud
+// KMS key kmsKey, and returns a cipher for it.
+func FromKMS(ctx context.Context, kmsKey, wrappedKey string) (*Cipher, error) {
+	wrapped, err := base64.StdEncoding.DecodeString(wrappedKey)
+	if err != nil {
+		return nil, fmt.Errorf("envelope: wrapped data key is not base64: %w", err)
+	}
+	dataKey, err := gcputil.KMSDecrypt(ctx, kmsKey, wrapped)
+	if err != nil {
+		return nil, fmt.Errorf("envelope: unwrapping data key: %w", err)
+	}
+	return NewCipher(dataKey)
+}
+
+// FromKMSSecret is FromKMS with a wrappedKey that may reference a secret
+// (see gcputil.ResolveSecret). It returns nil when both are empty, as
+// configs do when encryption is off; what names the use of the key in
+// errors.
+func FromKMSSecret(ctx context.Context, what, kmsKey, wrappedKey string) (*Cipher, error) {
+	if kmsKey == "" && wrappedKey == "" {
+		return nil, nil
+	}
+	if kmsKey == "" || wrappedKey == "" {
+		return nil, fmt.Errorf("%s needs both a KMS key and a wrapped data key", what)
+	}
+	wrapped, err := gcputil.ResolveSecret(ctx, wrappedKey)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", what, err)
+	}
+	c, err := FromKMS(ctx, kmsKey, wrapped)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", what, err)
+	}
+	return c, nil
+}
+
+// Seal encrypts plaintext bound to aad, which must be given again to Open.
+// The result is the random nonce followed by the ciphertext.
+func (c *Cipher) Seal(plaintext, aad []byte) []byte {
+	nonce := make([]byte, c.aead.NonceSize(), c.aead.NonceSize()+len(plaintext)+c.aead.Overhead())
+	rand.Read(nonce)
+	return c.aead.Seal(nonce, nonce, plaintext, aad)
+}
+
+// Open decrypts a value returned by Seal with the same aad.
+func (c *Cipher) Open(sealed, aad []byte) ([]byte, error) {
+	n := c.aead.NonceSize()
+	if len(sealed) < n+c.aead.Overhead() {
+		return nil, ErrDecrypt
+	}
+	plaintext, err := c.aead.Open(nil, sealed[:n], sealed[n:], aad)
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	return plaintext, nil
+}
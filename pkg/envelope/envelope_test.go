@@ -0,0 +1,60 @@
+package envelope_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/FlorinBalint/shortener/pkg/envelope"
+)
+
+func TestSealOpen(t *testing.T) {
+	c, err := envelope.NewCipher(bytes.Repeat([]byte{7}, envelope.DataKeySize))
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret := []byte("https://bucket.example/obj?X-Goog-Signature=abc")
+	sealed := c.Seal(secret, []byte("promo"))
+	if bytes.Contains(sealed, secret) {
+		t.Fatal("sealed value contains the plaintext")
+	}
+	if again := c.Seal(secret, []byte("promo")); bytes.Equal(again, sealed) {
+		t.Fatal("sealing twice gave the same value")
+	}
+	got, err := c.Open(sealed, []byte("promo"))
+	if err != nil || !bytes.Equal(got, secret) {
+		t.Fatalf("Open = %q, %v", got, err)
+	}
+
+	other, _ := envelope.NewCipher(bytes.Repeat([]byte{8}, envelope.DataKeySize))
+	tampered := append([]byte(nil), sealed...)
+	tampered[len(tampered)-1] ^= 1
+	for name, open := range map[string]func() ([]byte, error){
+		"other aad": func() ([]byte, error) { return c.Open(sealed, []byte("other")) },
+		"other key": func() ([]byte, error) { return other.Open(sealed, []byte("promo")) },
+		"tampered":  func() ([]byte, error) { return c.Open(tampered, []byte("promo")) },
+		"truncated": func() ([]byte, error) { return c.Open(sealed[:5], []byte("promo")) },
+	} {
+		if _, err := open(); !errors.Is(err, envelope.ErrDecrypt) {
+			t.Errorf("%s: want ErrDecrypt, got %v", name, err)
+		}
+	}
+}
+
+func TestNewCipher_KeySize(t *testing.T) {
+	if _, err := envelope.NewCipher(make([]byte, 16)); err == nil {
+		t.Fatal("want an error for a 16-byte key")
+	}
+}
+
+func TestFromKMSSecret_Off(t *testing.T) {
+	c, err := envelope.FromKMSSecret(context.Background(), "target encryption", "", "")
+	if c != nil || err != nil {
+		t.Fatalf("want no cipher when off, got %v, %v", c, err)
+	}
+	if _, err := envelope.FromKMSSecret(context.Background(), "target encryption", "projects/p/keys/k", ""); err == nil || !strings.Contains(err.Error(), "target encryption") {
+		t.Fatalf("want an error naming the use of a half configured key, got %v", err)
+	}
+}
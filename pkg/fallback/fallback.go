@@ -0,0 +1,320 @@
+// Package fallback resolves the keys the store does not know with other
+// shorteners, so that links of a legacy system keep working while they
+// are migrated a few at a time rather than imported at once.
+//
+// A resolver asks a shortener over HTTP: either the shortener itself,
+// whose redirect gives the target, or an API answering JSON. Resolvers are
+// chained in order of preference, and their answers cached.
+package fallback
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// ErrNotFound is returned for keys no resolver knows.
+var ErrNotFound = errors.New("fallback: not found")
+
+// Resolver returns the target of a key, or ErrNotFound.
+type Resolver interface {
+	Resolve(ctx context.Context, key string) (string, error)
+}
+
+// keyPlaceholder marks where URL templates take the key.
+const keyPlaceholder = "{key}"
+
+// maxResponseBytes caps the JSON answers read.
+const maxResponseBytes = 64 << 10
+
+// Config lists the resolvers and tunes them; zero values take the
+// defaults.
+//
+// URLs are templates of the resolvers' URLs, tried in order, with {key}
+// for the key, e.g. "https://old.example/{key}" or
+// "https://old.example/api/v1/links?key={key}". Each lookup takes at most
+// Timeout (default 2s). Targets are cached for CacheTTL (default 5m), and
+// keys no resolver knows for MissTTL (default 1m), up to CacheSize
+// (default 10000) keys.
+type Config struct {
+	URLs      []string
+	Timeout   time.Duration
+	CacheTTL  time.Duration
+	MissTTL   time.Duration
+	CacheSize int
+}
+
+// ConfigFromEnv reads FALLBACK_URLS (comma-separated), FALLBACK_TIMEOUT,
+// FALLBACK_CACHE_TTL, FALLBACK_MISS_TTL and FALLBACK_CACHE_SIZE.
+// Malformed values are logged and ignored.
+func ConfigFromEnv() Config {
+	var cfg Config
+	for _, u := range strings.Split(os.Getenv("FALLBACK_URLS"), ",") {
+		if u = strings.TrimSpace(u); u != "" {
+			cfg.URLs = append(cfg.URLs, u)
+		}
+	}
+	cfg.Timeout = envDuration("FALLBACK_TIMEOUT")
+	cfg.CacheTTL = envDuration("FALLBACK_CACHE_TTL")
+	cfg.MissTTL = envDuration("FALLBACK_MISS_TTL")
+	if v := os.Getenv("FALLBACK_CACHE_SIZE"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			log.Printf("ignoring invalid FALLBACK_CACHE_SIZE=%q", v)
+		} else {
+			cfg.CacheSize = n
+		}
+	}
+	return cfg
+}
+
+func envDuration(k string) time.Duration {
+	v := os.Getenv(k)
+	if v == "" {
+		return 0
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		log.Printf("ignoring invalid %s=%q", k, v)
+		return 0
+	}
+	return d
+}
+
+func (c Config) withDefaults() Config {
+	if c.Timeout <= 0 {
+		c.Timeout = 2 * time.Second
+	}
+	if c.CacheTTL <= 0 {
+		c.CacheTTL = 5 * time.Minute
+	}
+	if c.MissTTL <= 0 {
+		c.MissTTL = time.Minute
+	}
+	if c.CacheSize <= 0 {
+		c.CacheSize = 10000
+	}
+	return c
+}
+
+// New returns the cached chain of the resolvers of cfg, or nil if it has
+// none. It fails for URL templates that are not http(s) URLs with {key}.
+func New(cfg Config) (Resolver, error) {
+	if len(cfg.URLs) == 0 {
+		return nil, nil
+	}
+	cfg = cfg.withDefaults()
+	client := &http.Client{
+		Timeout: cfg.Timeout,
+		// The redirect of a shortener is its answer, not to be followed.
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+	chain := make(Chain, 0, len(cfg.URLs))
+	for _, u := range cfg.URLs {
+		r, err := NewHTTP(u, client)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, r)
+	}
+	return WithCache(chain, cfg.CacheTTL, cfg.MissTTL, cfg.CacheSize), nil
+}
+
+// HTTP resolves keys with a shortener reached over HTTP. A redirect
+// answers the key with its Location; a JSON object with its "url",
+// "long_url", "url_target" or "target" field, whichever comes first; 404
+// and 410 with ErrNotFound.
+type HTTP struct {
+	template string
+	client   *http.Client
+}
+
+// NewHTTP returns a resolver for the URL template, which has {key} where
+// the key goes, in its path or query.
+func NewHTTP(template string, client *http.Client) (*HTTP, error) {
+	u, err := url.Parse(strings.ReplaceAll(template, keyPlaceholder, "k"))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("fallback: %q is not an http(s) URL", template)
+	}
+	if !strings.Contains(template, keyPlaceholder) {
+		return nil, fmt.Errorf("fallback: %q has no %s", template, keyPlaceholder)
+	}
+	return &HTTP{template: template, client: client}, nil
+}
+
+// url returns the URL looking key up, escaped for where it goes.
+func (h *HTTP) url(key string) string {
+	escaped := escapePath(key)
+	if q := strings.Index(h.template, "?"); q >= 0 && q < strings.Index(h.template, keyPlaceholder) {
+		escaped = url.QueryEscape(key)
+	}
+	return strings.ReplaceAll(h.template, keyPlaceholder, escaped)
+}
+
+// escapePath escapes the segments of key, keeping its slashes.
+func escapePath(key string) string {
+	segments := strings.Split(key, "/")
+	for i, s := range segments {
+		segments[i] = url.PathEscape(s)
+	}
+	return strings.Join(segments, "/")
+}
+
+// Resolve implements Resolver.
+func (h *HTTP) Resolve(ctx context.Context, key string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, h.url(key), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var target string
+	switch {
+	case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone:
+		return "", ErrNotFound
+	case resp.StatusCode >= 300 && resp.StatusCode < 400:
+		loc, err := resp.Location()
+		if err != nil {
+			return "", fmt.Errorf("fallback: %s: redirect without a location", req.URL.Host)
+		}
+		target = loc.String()
+	case resp.StatusCode == http.StatusOK:
+		var body struct {
+			URL       string `json:"url"`
+			LongURL   string `json:"long_url"`
+			URLTarget string `json:"url_target"`
+			Target    string `json:"target"`
+		}
+		if err := json.NewDecoder(io.LimitReader(resp.Body, maxResponseBytes)).Decode(&body); err != nil {
+			return "", fmt.Errorf("fallback: %s: %v", req.URL.Host, err)
+		}
+		target = firstNonEmpty(body.URL, body.LongURL, body.URLTarget, body.Target)
+	default:
+		return "", fmt.Errorf("fallback: %s answered %s", req.URL.Host, resp.Status)
+	}
+	// Only web targets are redirected to.
+	if !strings.HasPrefix(target, "https://") && !strings.HasPrefix(target, "http://") {
+		return "", fmt.Errorf("fallback: %s: %q is not an http(s) target", req.URL.Host, target)
+	}
+	return target, nil
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// Chain asks its resolvers in order, and answers with the first target
+// found. Failing resolvers are skipped; their error is returned if no
+// other resolver knows the key.
+type Chain []Resolver
+
+// Resolve implements Resolver.
+func (c Chain) Resolve(ctx context.Context, key string) (string, error) {
+	var errs []error
+	for _, r := range c {
+		target, err := r.Resolve(ctx, key)
+		if err == nil {
+			return target, nil
+		}
+		if !errors.Is(err, ErrNotFound) {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 {
+		return "", errors.Join(errs...)
+	}
+	return "", ErrNotFound
+}
+
+// Cached keeps the answers of a resolver, found or not, and collapses
+// concurrent lookups of a key into one. Errors are not cached.
+type Cached struct {
+	resolver Resolver
+	ttl      time.Duration
+	missTTL  time.Duration
+	size     int
+	now      func() time.Time
+
+	mu      sync.Mutex
+	answers map[string]answer
+	flight  singleflight.Group
+}
+
+type answer struct {
+	target  string
+	expires time.Time
+}
+
+// WithCache caches the targets r finds for ttl, and the keys it does not
+// know for missTTL, up to size keys; keys beyond it are not cached until
+// others expire.
+func WithCache(r Resolver, ttl, missTTL time.Duration, size int) *Cached {
+	return &Cached{resolver: r, ttl: ttl, missTTL: missTTL, size: size, now: time.Now, answers: make(map[string]answer)}
+}
+
+// Resolve implements Resolver.
+func (c *Cached) Resolve(ctx context.Context, key string) (string, error) {
+	c.mu.Lock()
+	a, ok := c.answers[key]
+	c.mu.Unlock()
+	if !ok || !c.now().Before(a.expires) {
+		v, err, _ := c.flight.Do(key, func() (any, error) {
+			target, err := c.resolver.Resolve(ctx, key)
+			switch {
+			case err == nil:
+				c.store(key, answer{target: target, expires: c.now().Add(c.ttl)})
+			case errors.Is(err, ErrNotFound):
+				c.store(key, answer{expires: c.now().Add(c.missTTL)})
+			}
+			return target, err
+		})
+		if err != nil {
+			return "", err
+		}
+		return v.(string), nil
+	}
+	if a.target == "" {
+		return "", ErrNotFound
+	}
+	return a.target, nil
+}
+
+// store caches a, making room by dropping expired answers when full.
+func (c *Cached) store(key string, a answer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.answers[key]; !ok && len(c.answers) >= c.size {
+		now := c.now()
+		for k, old := range c.answers {
+			if !now.Before(old.expires) {
+				delete(c.answers, k)
+			}
+		}
+		if len(c.answers) >= c.size {
+			return
+		}
+	}
+	c.answers[key] = a
+}
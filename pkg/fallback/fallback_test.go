@@ -0,0 +1,116 @@
+package fallback
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// legacy serves a shortener redirecting /old and /team/a b, and an API
+// answering JSON for ?key=api.
+func legacy(t *testing.T, hits *atomic.Int64) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		switch {
+		case r.URL.Path == "/old":
+			http.Redirect(w, r, "https://example.com/old", http.StatusMovedPermanently)
+		case r.URL.Path == "/team/a b":
+			http.Redirect(w, r, "https://example.com/team", http.StatusFound)
+		case r.URL.Path == "/api" && r.URL.Query().Get("key") == "api":
+			w.Write([]byte(`{"long_url":"https://example.com/api"}`))
+		case r.URL.Path == "/script":
+			http.Redirect(w, r, "javascript:alert(1)", http.StatusFound)
+		case r.URL.Path == "/broken":
+			http.Error(w, "down", http.StatusBadGateway)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestNew(t *testing.T) {
+	var hits atomic.Int64
+	srv := legacy(t, &hits)
+	r, err := New(Config{URLs: []string{srv.URL + "/api?key={key}", srv.URL + "/{key}"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	for key, want := range map[string]string{
+		"old":      "https://example.com/old",
+		"team/a b": "https://example.com/team",
+		"api":      "https://example.com/api",
+	} {
+		if got, err := r.Resolve(ctx, key); err != nil || got != want {
+			t.Errorf("Resolve(%q) = %q, %v, want %q", key, got, err, want)
+		}
+	}
+	if _, err := r.Resolve(ctx, "missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Resolve(missing) = %v, want ErrNotFound", err)
+	}
+	for _, key := range []string{"script", "broken"} {
+		if _, err := r.Resolve(ctx, key); err == nil || errors.Is(err, ErrNotFound) {
+			t.Errorf("Resolve(%q) = %v, want an error", key, err)
+		}
+	}
+
+	// Answers, found or not, are cached; errors are not.
+	before := hits.Load()
+	r.Resolve(ctx, "old")
+	r.Resolve(ctx, "missing")
+	if hits.Load() != before {
+		t.Errorf("want cached answers, got %d more lookups", hits.Load()-before)
+	}
+	r.Resolve(ctx, "broken")
+	if hits.Load() == before {
+		t.Error("want errors looked up again")
+	}
+
+	for _, cfg := range []Config{
+		{URLs: []string{"ftp://old.example/{key}"}},
+		{URLs: []string{"https://old.example/"}},
+	} {
+		if _, err := New(cfg); err == nil {
+			t.Errorf("New(%v): want an error", cfg.URLs)
+		}
+	}
+	if r, err := New(Config{}); r != nil || err != nil {
+		t.Errorf("New(no URLs) = %v, %v", r, err)
+	}
+}
+
+func TestCached_Expiry(t *testing.T) {
+	var calls int
+	now := time.Unix(1000, 0)
+	c := WithCache(resolverFunc(func(context.Context, string) (string, error) {
+		calls++
+		return "https://example.com", nil
+	}), time.Minute, time.Second, 1)
+	c.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	c.Resolve(ctx, "a")
+	c.Resolve(ctx, "a")
+	c.Resolve(ctx, "b") // beyond the size, not cached
+	c.Resolve(ctx, "b")
+	if calls != 3 {
+		t.Fatalf("want 3 lookups, got %d", calls)
+	}
+	now = now.Add(time.Minute)
+	c.Resolve(ctx, "b") // a expired, so b takes its place
+	c.Resolve(ctx, "b")
+	if calls != 4 {
+		t.Fatalf("want 4 lookups, got %d", calls)
+	}
+}
+
+type resolverFunc func(context.Context, string) (string, error)
+
+func (f resolverFunc) Resolve(ctx context.Context, key string) (string, error) { return f(ctx, key) }
@@ -4,10 +4,21 @@ import (
 	ctx "context"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"maps"
+	"reflect"
+	"slices"
 	"strings"
+	"sync"
+	"time"
 
 	"cloud.google.com/go/datastore"
+	"cloud.google.com/go/datastore/apiv1/datastorepb"
+	"google.golang.org/api/iterator"
 	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+
+	"github.com/FlorinBalint/shortener/pkg/servertiming"
 )
 
 // DSClient is a minimal key->JSON datastore client.
@@ -22,7 +33,7 @@ type DSClient struct {
 // For local emulators (localhost), auth is disabled automatically.
 // For the official API, endpoint may be left empty (default).
 func NewDSClient(ctx ctx.Context, projectID, endpoint, namespace string, extraOpts ...option.ClientOption) (*DSClient, error) {
-	opts := make([]option.ClientOption, 0, 2+len(extraOpts))
+	opts := make([]option.ClientOption, 0, 3+len(extraOpts))
 	if endpoint != "" {
 		opts = append(opts, option.WithEndpoint(endpoint))
 		// Emulator typically runs on localhost; disable auth in that case.
@@ -30,6 +41,8 @@ func NewDSClient(ctx ctx.Context, projectID, endpoint, namespace string, extraOp
 			opts = append(opts, option.WithoutAuthentication())
 		}
 	}
+	// Each RPC's time goes to the Server-Timing of the request making it.
+	opts = append(opts, option.WithGRPCDialOption(grpc.WithChainUnaryInterceptor(timeRPC)))
 	opts = append(opts, extraOpts...)
 	if projectID == "" {
 		projectID = datastore.DetectProjectID
@@ -42,13 +55,183 @@ func NewDSClient(ctx ctx.Context, projectID, endpoint, namespace string, extraOp
 	return &DSClient{client: c, namespace: namespace}, nil
 }
 
+// timeRPC adds the time of an RPC to the Datastore timing of its context.
+func timeRPC(ctx ctx.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	start := time.Now()
+	err := invoker(ctx, method, req, reply, cc, opts...)
+	servertiming.Since(ctx, servertiming.Datastore, start)
+	return err
+}
+
+// WithNamespace returns a client for namespace sharing c's connection.
+// Close only the original client.
+func (c *DSClient) WithNamespace(namespace string) *DSClient {
+	return &DSClient{client: c.client, namespace: namespace}
+}
+
 // Close closes the underlying client.
 func (c *DSClient) Close() error {
 	return c.client.Close()
 }
 
+// jsonBlob is the stored entity: the JSON payload as a single noindex
+// property, plus the indexed properties of the value (see Indexed).
 type jsonBlob struct {
-	Raw []byte `datastore:"raw,noindex"`
+	Raw     []byte
+	Indexed []datastore.Property
+}
+
+var _ datastore.PropertyLoadSaver = (*jsonBlob)(nil)
+
+// Load implements datastore.PropertyLoadSaver. Indexed properties are
+// derived from the JSON payload, so only the payload is loaded.
+func (b *jsonBlob) Load(props []datastore.Property) error {
+	for _, p := range props {
+		if p.Name != "raw" {
+			continue
+		}
+		raw, ok := p.Value.([]byte)
+		if !ok {
+			return fmt.Errorf("property raw has type %T, want []byte", p.Value)
+		}
+		b.Raw = raw
+	}
+	return nil
+}
+
+// Save implements datastore.PropertyLoadSaver.
+func (b *jsonBlob) Save() ([]datastore.Property, error) {
+	props := make([]datastore.Property, 0, 1+len(b.Indexed))
+	props = append(props, datastore.Property{Name: "raw", Value: b.Raw, NoIndex: true})
+	return append(props, b.Indexed...), nil
+}
+
+// Indexed is implemented by values that expose properties to store indexed
+// next to their JSON, so queries can filter and order on them. Entities
+// written before a property was introduced lack it and are skipped by
+// queries filtering or ordering on it.
+//
+// Fields are indexed more simply with a tag naming the property, e.g.
+//
+//	Owner string `json:"owner" index:"owner"`
+//
+// for fields of struct values holding strings, booleans, integers, floats
+// or times. IndexedProperties, for derived properties, takes precedence
+// over tags of the same name.
+type Indexed interface {
+	IndexedProperties() map[string]any
+}
+
+// newBlob marshals v and collects its indexed properties.
+func newBlob(v any) (*jsonBlob, error) {
+	j, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	props, err := taggedProperties(v)
+	if err != nil {
+		return nil, err
+	}
+	if iv, ok := v.(Indexed); ok {
+		if props == nil {
+			props = make(map[string]any)
+		}
+		maps.Copy(props, iv.IndexedProperties())
+	}
+	b := &jsonBlob{Raw: j}
+	for _, name := range slices.Sorted(maps.Keys(props)) {
+		b.Indexed = append(b.Indexed, datastore.Property{Name: name, Value: props[name]})
+	}
+	return b, nil
+}
+
+// indexedField is a struct field tagged with the property indexing it.
+type indexedField struct {
+	index []int
+	name  string
+}
+
+// indexedFields caches the tagged fields of struct types, or the error
+// of a tag on a field that cannot be indexed.
+var indexedFields sync.Map // reflect.Type -> indexedFieldsOf
+
+type indexedFieldsOf struct {
+	fields []indexedField
+	err    error
+}
+
+var timeType = reflect.TypeFor[time.Time]()
+
+// taggedProperties returns the properties of the tagged fields of v, nil
+// if it has none.
+func taggedProperties(v any) (map[string]any, error) {
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Pointer {
+		if rv.IsNil() {
+			return nil, nil
+		}
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return nil, nil
+	}
+	cached, ok := indexedFields.Load(rv.Type())
+	if !ok {
+		cached, _ = indexedFields.LoadOrStore(rv.Type(), tagsOf(rv.Type()))
+	}
+	of := cached.(indexedFieldsOf)
+	if of.err != nil || len(of.fields) == 0 {
+		return nil, of.err
+	}
+	props := make(map[string]any, len(of.fields))
+	for _, f := range of.fields {
+		// Fields promoted through a nil embedded pointer are left out.
+		if fv, err := rv.FieldByIndexErr(f.index); err == nil {
+			props[f.name] = propertyValue(fv)
+		}
+	}
+	return props, nil
+}
+
+// tagsOf finds the tagged fields of struct type t.
+func tagsOf(t reflect.Type) indexedFieldsOf {
+	var of indexedFieldsOf
+	for _, f := range reflect.VisibleFields(t) {
+		name, ok := f.Tag.Lookup("index")
+		if !ok {
+			continue
+		}
+		if name == "" || !f.IsExported() {
+			return indexedFieldsOf{err: fmt.Errorf("%s.%s: index tag on an unexported field or without a name", t, f.Name)}
+		}
+		switch f.Type.Kind() {
+		case reflect.String, reflect.Bool, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Float32, reflect.Float64:
+		default:
+			if f.Type != timeType {
+				return indexedFieldsOf{err: fmt.Errorf("%s.%s: cannot index %s", t, f.Name, f.Type)}
+			}
+		}
+		of.fields = append(of.fields, indexedField{index: f.Index, name: name})
+	}
+	return of
+}
+
+// propertyValue converts a field to the type Datastore stores it as.
+func propertyValue(v reflect.Value) any {
+	switch v.Kind() {
+	case reflect.String:
+		return v.String()
+	case reflect.Bool:
+		return v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int()
+	case reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return int64(v.Uint())
+	case reflect.Float32, reflect.Float64:
+		return v.Float()
+	}
+	return v.Interface()
 }
 
 func (c *DSClient) key(kind, name string) *datastore.Key {
@@ -91,24 +274,88 @@ func (c *DSClient) GetJSON(ctx ctx.Context, kind, name string, out any) ([]byte,
 	return e.Raw, nil
 }
 
-// PutValue stores a typed value as JSON (T -> JSON).
+// PutNewValue stores a typed value as JSON (T -> JSON), failing if the entity exists.
 func PutNewValue[T any](client *DSClient, ctx ctx.Context, kind, name string, v T) error {
-	j, err := json.Marshal(v)
+	b, err := newBlob(v)
 	if err != nil {
 		return err
 	}
 
 	_, err = client.client.Mutate(ctx,
-		datastore.NewInsert(client.key(kind, name), &jsonBlob{Raw: j}))
+		datastore.NewInsert(client.key(kind, name), b))
 	return err
 }
 
+// PutNewValues stores typed values by name under kind in one transaction,
+// failing without storing any if one of the entities exists; Datastore
+// takes at most 500 per transaction.
+func PutNewValues[T any](client *DSClient, ctx ctx.Context, kind string, values map[string]T) error {
+	muts := make([]*datastore.Mutation, 0, len(values))
+	for name, v := range values {
+		b, err := newBlob(v)
+		if err != nil {
+			return err
+		}
+		muts = append(muts, datastore.NewInsert(client.key(kind, name), b))
+	}
+	_, err := client.client.RunInTransaction(ctx, func(tx *datastore.Transaction) error {
+		_, err := tx.Mutate(muts...)
+		return err
+	})
+	return err
+}
+
+// readTimeKey carries the read time of ReadAt.
+type readTimeKey struct{}
+
+// ReadAt returns ctx under which GetValue and GetValues read entities as
+// they were at t, in a read-only transaction: stale reads may be served by
+// the nearest replica, sooner than strongly consistent ones. Firestore
+// takes read times up to an hour old. The zero t reads the latest values.
+func ReadAt(parent ctx.Context, t time.Time) ctx.Context {
+	return ctx.WithValue(parent, readTimeKey{}, t)
+}
+
+// snapshot returns the read-only transaction reading at the time ReadAt
+// set on ctx, or nil to read the latest values. It begins with its first
+// lookup and holds no locks, so it is left to expire rather than rolled
+// back, which would cost another round trip.
+func (c *DSClient) snapshot(ctx ctx.Context) (*datastore.Transaction, error) {
+	t, _ := ctx.Value(readTimeKey{}).(time.Time)
+	if t.IsZero() {
+		return nil, nil
+	}
+	return c.client.NewTransaction(ctx, datastore.ReadOnly, datastore.WithReadTime(t), datastore.BeginLater)
+}
+
+func (c *DSClient) get(ctx ctx.Context, key *datastore.Key, dst any) error {
+	tx, err := c.snapshot(ctx)
+	switch {
+	case err != nil:
+		return err
+	case tx == nil:
+		return c.client.Get(ctx, key, dst)
+	}
+	return tx.Get(key, dst)
+}
+
+func (c *DSClient) getMulti(ctx ctx.Context, keys []*datastore.Key, dst any) error {
+	tx, err := c.snapshot(ctx)
+	switch {
+	case err != nil:
+		return err
+	case tx == nil:
+		return c.client.GetMulti(ctx, keys, dst)
+	}
+	return tx.GetMulti(keys, dst)
+}
+
 // GetValue loads JSON and decodes it into the requested type (JSON -> T).
 // Returns zero T and error if entity is missing or JSON is invalid.
 func GetValue[T any](client *DSClient, ctx ctx.Context, kind, name string) (T, error) {
 	var out T
 	var e jsonBlob
-	if err := client.client.Get(ctx, client.key(kind, name), &e); err != nil {
+	if err := client.get(ctx, client.key(kind, name), &e); err != nil {
 		return out, err
 	}
 	if len(e.Raw) == 0 {
@@ -120,6 +367,233 @@ func GetValue[T any](client *DSClient, ctx ctx.Context, kind, name string) (T, e
 	return out, nil
 }
 
+// GetValues loads the typed values at names of kind in one batch, by
+// name. Missing entities are left out.
+func GetValues[T any](client *DSClient, ctx ctx.Context, kind string, names []string) (map[string]T, error) {
+	keys := make([]*datastore.Key, len(names))
+	for i, name := range names {
+		keys[i] = client.key(kind, name)
+	}
+	blobs := make([]jsonBlob, len(names))
+	err := client.getMulti(ctx, keys, blobs)
+	var errs datastore.MultiError
+	if err != nil && !errors.As(err, &errs) {
+		return nil, err
+	}
+	out := make(map[string]T, len(names))
+	for i, name := range names {
+		if errs != nil && errs[i] != nil {
+			if errors.Is(errs[i], datastore.ErrNoSuchEntity) {
+				continue
+			}
+			return nil, errs[i]
+		}
+		if len(blobs[i].Raw) == 0 {
+			return nil, errors.New("empty JSON payload")
+		}
+		var v T
+		if err := json.Unmarshal(blobs[i].Raw, &v); err != nil {
+			return nil, err
+		}
+		out[name] = v
+	}
+	return out, nil
+}
+
+// PutValues stores typed values by name under kind in one batch,
+// replacing existing entities; Datastore takes at most 500 per call.
+func PutValues[T any](client *DSClient, ctx ctx.Context, kind string, values map[string]T) error {
+	keys := make([]*datastore.Key, 0, len(values))
+	blobs := make([]*jsonBlob, 0, len(values))
+	for name, v := range values {
+		b, err := newBlob(v)
+		if err != nil {
+			return err
+		}
+		keys = append(keys, client.key(kind, name))
+		blobs = append(blobs, b)
+	}
+	_, err := client.client.PutMulti(ctx, keys, blobs)
+	return err
+}
+
+// UpdateValue transactionally loads the typed value at (kind, name), applies
+// update to it and stores the result. If update returns an error, nothing is
+// written and that error is returned. A missing entity yields
+// datastore.ErrNoSuchEntity.
+func UpdateValue[T any](client *DSClient, ctx ctx.Context, kind, name string, update func(*T) error) error {
+	return updateValue(client, ctx, kind, name, false, update)
+}
+
+// UpsertValue is like UpdateValue, but a missing entity is created from the
+// zero value of T.
+func UpsertValue[T any](client *DSClient, ctx ctx.Context, kind, name string, update func(*T) error) error {
+	return updateValue(client, ctx, kind, name, true, update)
+}
+
+func updateValue[T any](client *DSClient, ctx ctx.Context, kind, name string, create bool, update func(*T) error) error {
+	k := client.key(kind, name)
+	_, err := client.client.RunInTransaction(ctx, func(tx *datastore.Transaction) error {
+		var v T
+		var e jsonBlob
+		err := tx.Get(k, &e)
+		switch {
+		case err == nil:
+			if err := json.Unmarshal(e.Raw, &v); err != nil {
+				return err
+			}
+		case create && errors.Is(err, datastore.ErrNoSuchEntity):
+		default:
+			return err
+		}
+		if err := update(&v); err != nil {
+			return err
+		}
+		b, err := newBlob(v)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Put(k, b)
+		return err
+	})
+	return err
+}
+
+// ErrInvalidCursor is returned by ListValues for cursors it did not produce.
+var ErrInvalidCursor = errors.New("invalid datastore cursor")
+
+// Named pairs a typed value with the name of the entity it was loaded from.
+type Named[T any] struct {
+	Name  string
+	Value T
+}
+
+// Filter restricts a query on an indexed property, e.g. {"created", ">=", t}.
+type Filter struct {
+	Field string
+	Op    string
+	Value any
+}
+
+// ListQuery selects a page of entities for ListValues.
+//
+// Cursor is the opaque cursor of a previous page (empty for the first one).
+// Order names an indexed property, prefixed with "-" for descending order;
+// empty orders by key. Filters apply to indexed properties. NamePrefix keeps
+// only entities whose name starts with it and requires ordering by key.
+type ListQuery struct {
+	Cursor     string
+	Limit      int
+	Order      string
+	Filters    []Filter
+	NamePrefix string
+}
+
+// ListValues returns up to q.Limit typed values of kind and the cursor of
+// the next page, which is empty once the last page has been read.
+func ListValues[T any](client *DSClient, ctx ctx.Context, kind string, q ListQuery) ([]Named[T], string, error) {
+	dq := datastore.NewQuery(kind).Limit(q.Limit)
+	if client.namespace != "" {
+		dq = dq.Namespace(client.namespace)
+	}
+	if q.Order != "" {
+		if q.NamePrefix != "" {
+			return nil, "", errors.New("name prefix requires ordering by key")
+		}
+		dq = dq.Order(q.Order)
+	}
+	if q.NamePrefix != "" {
+		dq = dq.FilterField("__key__", ">=", client.key(kind, q.NamePrefix)).
+			FilterField("__key__", "<", client.key(kind, q.NamePrefix+"\U0010FFFF"))
+	}
+	for _, f := range q.Filters {
+		dq = dq.FilterField(f.Field, f.Op, f.Value)
+	}
+	if q.Cursor != "" {
+		c, err := datastore.DecodeCursor(q.Cursor)
+		if err != nil {
+			return nil, "", ErrInvalidCursor
+		}
+		dq = dq.Start(c)
+	}
+
+	out := make([]Named[T], 0, q.Limit)
+	it := client.client.Run(ctx, dq)
+	for {
+		var e jsonBlob
+		k, err := it.Next(&e)
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, "", err
+		}
+		var v T
+		if err := json.Unmarshal(e.Raw, &v); err != nil {
+			return nil, "", err
+		}
+		out = append(out, Named[T]{Name: k.Name, Value: v})
+	}
+	if len(out) < q.Limit {
+		return out, "", nil
+	}
+	next, err := it.Cursor()
+	if err != nil {
+		return nil, "", err
+	}
+	return out, next.String(), nil
+}
+
+// CountValues counts the entities of kind matching filters, with an
+// aggregation query, without reading them.
+func CountValues(client *DSClient, ctx ctx.Context, kind string, filters []Filter) (int64, error) {
+	dq := datastore.NewQuery(kind)
+	if client.namespace != "" {
+		dq = dq.Namespace(client.namespace)
+	}
+	for _, f := range filters {
+		dq = dq.FilterField(f.Field, f.Op, f.Value)
+	}
+	res, err := client.client.RunAggregationQuery(ctx, dq.NewAggregationQuery().WithCount("count"))
+	if err != nil {
+		return 0, err
+	}
+	v, ok := res["count"].(*datastorepb.Value)
+	if !ok {
+		return 0, fmt.Errorf("count of %s: unexpected result %v", kind, res["count"])
+	}
+	return v.GetIntegerValue(), nil
+}
+
+// DeleteValueIf transactionally deletes the typed value at (kind, name) if
+// cond holds for it, and reports whether it did. A missing entity is not
+// deleted.
+func DeleteValueIf[T any](client *DSClient, ctx ctx.Context, kind, name string, cond func(T) bool) (bool, error) {
+	k := client.key(kind, name)
+	var deleted bool
+	_, err := client.client.RunInTransaction(ctx, func(tx *datastore.Transaction) error {
+		deleted = false
+		var e jsonBlob
+		err := tx.Get(k, &e)
+		if errors.Is(err, datastore.ErrNoSuchEntity) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		var v T
+		if err := json.Unmarshal(e.Raw, &v); err != nil {
+			return err
+		}
+		if !cond(v) {
+			return nil
+		}
+		deleted = true
+		return tx.Delete(k)
+	})
+	return deleted, err
+}
+
 // Delete removes the entity at (kind, name).
 func (c *DSClient) Delete(ctx ctx.Context, kind, name string) error {
 	return c.client.Delete(ctx, c.key(kind, name))
@@ -0,0 +1,78 @@
+package gcputil
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/datastore"
+)
+
+type owned struct {
+	Owner string `json:"owner" index:"owner"`
+}
+
+type tagged struct {
+	*owned
+	Name    string    `json:"name"`
+	Created time.Time `json:"created" index:"created"`
+	Clicks  uint16    `json:"clicks" index:"clicks"`
+	Public  bool      `json:"public" index:"public"`
+	Host    string    `json:"host" index:"host"`
+}
+
+// IndexedProperties derives host, replacing the field of that name.
+func (t tagged) IndexedProperties() map[string]any {
+	return map[string]any{"host": "example.com"}
+}
+
+func TestNewBlob_IndexedProperties(t *testing.T) {
+	created := time.Date(2026, 5, 1, 10, 0, 0, 0, time.UTC)
+	v := tagged{owned: &owned{Owner: "ops"}, Name: "promo", Created: created, Clicks: 7, Public: true, Host: "ignored"}
+	b, err := newBlob(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []datastore.Property{
+		{Name: "clicks", Value: int64(7)},
+		{Name: "created", Value: created},
+		{Name: "host", Value: "example.com"},
+		{Name: "owner", Value: "ops"},
+		{Name: "public", Value: true},
+	}
+	if !reflect.DeepEqual(b.Indexed, want) {
+		t.Errorf("indexed = %+v, want %+v", b.Indexed, want)
+	}
+
+	// Through a nil embedded pointer, and a pointer to the value.
+	v.owned = nil
+	if b, err = newBlob(&v); err != nil {
+		t.Fatal(err)
+	}
+	if len(b.Indexed) != 4 || b.Indexed[3].Name != "public" {
+		t.Errorf("indexed = %+v, want owner left out", b.Indexed)
+	}
+
+	// Values without tags store their JSON only.
+	if b, err = newBlob(map[string]int{"a": 1}); err != nil || b.Indexed != nil {
+		t.Errorf("newBlob(map) = %+v, %v", b, err)
+	}
+}
+
+func TestNewBlob_InvalidTag(t *testing.T) {
+	for _, v := range []any{
+		struct {
+			Tags []string `index:"tags"`
+		}{},
+		struct {
+			Name string `index:""`
+		}{},
+		struct {
+			name string `index:"name"`
+		}{},
+	} {
+		if _, err := newBlob(v); err == nil {
+			t.Errorf("newBlob(%T): want an error", v)
+		}
+	}
+}
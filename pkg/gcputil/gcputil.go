@@ -33,6 +33,27 @@ func GCPZone(ctx context.Context) (string, error) {
 		return z, nil
 	}
 
+	s, err := metadataValue(ctx, "instance/zone")
+	if err != nil {
+		return "", err
+	}
+	if s == "" {
+		return "", ErrGCPZoneNotFound
+	}
+
+	// Response format: projects/<num>/zones/<zone>
+	if i := strings.LastIndexByte(s, '/'); i >= 0 && i+1 < len(s) {
+		s = s[i+1:]
+	}
+	if s == "" {
+		return "", ErrGCPZoneNotFound
+	}
+	return s, nil
+}
+
+// metadataValue returns the value at path of the metadata server, e.g.
+// "instance/zone", or ErrGCPMetadataUnavailable.
+func metadataValue(ctx context.Context, path string) (string, error) {
 	// Metadata host override per GCE conventions
 	base := "http://metadata.google.internal"
 	if h := strings.TrimSpace(os.Getenv("GCE_METADATA_HOST")); h != "" {
@@ -43,7 +64,7 @@ func GCPZone(ctx context.Context) (string, error) {
 		}
 	}
 
-	url := base + "/computeMetadata/v1/instance/zone"
+	url := base + "/computeMetadata/v1/" + path
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	if err != nil {
 		return "", ErrGCPMetadataUnavailable
@@ -64,17 +85,5 @@ func GCPZone(ctx context.Context) (string, error) {
 	if err != nil {
 		return "", ErrGCPMetadataUnavailable
 	}
-	s := strings.TrimSpace(string(body))
-	if s == "" {
-		return "", ErrGCPZoneNotFound
-	}
-
-	// Response format: projects/<num>/zones/<zone>
-	if i := strings.LastIndexByte(s, '/'); i >= 0 && i+1 < len(s) {
-		s = s[i+1:]
-	}
-	if s == "" {
-		return "", ErrGCPZoneNotFound
-	}
-	return s, nil
+	return strings.TrimSpace(string(body)), nil
 }
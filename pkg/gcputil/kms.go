@@ -0,0 +1,26 @@
+package gcputil
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+
+	cloudkms "google.golang.org/api/cloudkms/v1"
+)
+
+// KMSDecrypt decrypts ciphertext with the Cloud KMS key keyName
+// ("projects/*/locations/*/keyRings/*/cryptoKeys/*"), using the default
+// credentials.
+func KMSDecrypt(ctx context.Context, keyName string, ciphertext []byte) ([]byte, error) {
+	svc, err := cloudkms.NewService(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("kms client: %w", err)
+	}
+	resp, err := svc.Projects.Locations.KeyRings.CryptoKeys.Decrypt(keyName, &cloudkms.DecryptRequest{
+		Ciphertext: base64.StdEncoding.EncodeToString(ciphertext),
+	}).Context(ctx).Do()
+	if err != nil {
+		return nil, fmt.Errorf("kms decrypt with %s: %w", keyName, err)
+	}
+	return base64.StdEncoding.DecodeString(resp.Plaintext)
+}
@@ -0,0 +1,74 @@
+package gcputil
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Location is where a replica runs, to tell replicas apart in metrics and
+// logs, e.g. to compare zones. Fields that cannot be resolved, as off GCP,
+// are empty.
+type Location struct {
+	Zone    string `json:"zone,omitempty"`
+	Cluster string `json:"cluster,omitempty"`
+	Pod     string `json:"pod,omitempty"`
+}
+
+// ResolveLocation resolves the zone with GCPZone, the cluster from
+// CLUSTER_NAME or the cluster-name attribute GKE sets on its nodes, and
+// the pod as StatefulSetPod names it.
+func ResolveLocation(ctx context.Context) Location {
+	var l Location
+	zone, err := GCPZone(ctx)
+	if err == nil {
+		l.Zone = zone
+	}
+	l.Cluster = strings.TrimSpace(os.Getenv("CLUSTER_NAME"))
+	if l.Cluster == "" && !errors.Is(err, ErrGCPMetadataUnavailable) {
+		// The zone may have come from the environment off GCP: a metadata
+		// server that failed once is not asked again.
+		l.Cluster, _ = metadataValue(ctx, "instance/attributes/cluster-name")
+	}
+	l.Pod, _ = NewStatefulSetPod().PodName()
+	return l
+}
+
+// Attributes returns the metric attributes of the fields of l that are
+// set, named after the OpenTelemetry semantic conventions.
+func (l Location) Attributes() []attribute.KeyValue {
+	var attrs []attribute.KeyValue
+	if l.Zone != "" {
+		attrs = append(attrs, attribute.String("cloud.availability_zone", l.Zone))
+	}
+	if l.Cluster != "" {
+		attrs = append(attrs, attribute.String("k8s.cluster.name", l.Cluster))
+	}
+	if l.Pod != "" {
+		attrs = append(attrs, attribute.String("k8s.pod.name", l.Pod))
+	}
+	return attrs
+}
+
+// LogPrefix returns "zone=Z cluster=C pod=P " for the fields of l that
+// are set, or "" when none is.
+func (l Location) LogPrefix() string {
+	var b strings.Builder
+	for _, f := range []struct{ name, value string }{{"zone", l.Zone}, {"cluster", l.Cluster}, {"pod", l.Pod}} {
+		if f.value != "" {
+			b.WriteString(f.name + "=" + f.value + " ")
+		}
+	}
+	return b.String()
+}
+
+// SetLogPrefix starts the messages of the standard logger with the fields
+// of l, after the timestamp.
+func (l Location) SetLogPrefix() {
+	log.SetPrefix(l.LogPrefix())
+	log.SetFlags(log.Flags() | log.Lmsgprefix)
+}
@@ -0,0 +1,64 @@
+package gcputil
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+func TestResolveLocation(t *testing.T) {
+	metadata := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Metadata-Flavor") != "Google" {
+			http.Error(w, "missing Metadata-Flavor", http.StatusForbidden)
+			return
+		}
+		switch r.URL.Path {
+		case "/computeMetadata/v1/instance/zone":
+			w.Write([]byte("projects/123/zones/europe-west1-b"))
+		case "/computeMetadata/v1/instance/attributes/cluster-name":
+			w.Write([]byte("shortener-ew1\n"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer metadata.Close()
+	t.Setenv("GCE_METADATA_HOST", metadata.URL)
+	t.Setenv("GCP_ZONE", "")
+	t.Setenv("ZONE", "")
+	t.Setenv("CLUSTER_NAME", "")
+	t.Setenv("POD_NAME", "reader-7f9c-x2")
+
+	want := Location{Zone: "europe-west1-b", Cluster: "shortener-ew1", Pod: "reader-7f9c-x2"}
+	if got := ResolveLocation(context.Background()); got != want {
+		t.Fatalf("ResolveLocation = %+v, want %+v", got, want)
+	}
+
+	t.Setenv("CLUSTER_NAME", "override")
+	if got := ResolveLocation(context.Background()); got.Cluster != "override" {
+		t.Errorf("CLUSTER_NAME: got cluster %q", got.Cluster)
+	}
+
+	// Off GCP, only the pod is known.
+	metadata.Close()
+	t.Setenv("CLUSTER_NAME", "")
+	if got := ResolveLocation(context.Background()); got != (Location{Pod: "reader-7f9c-x2"}) {
+		t.Errorf("without metadata: got %+v", got)
+	}
+}
+
+func TestLocation_Fields(t *testing.T) {
+	l := Location{Zone: "us-central1-a", Pod: "writer-0"}
+	if got, want := l.LogPrefix(), "zone=us-central1-a pod=writer-0 "; got != want {
+		t.Errorf("LogPrefix = %q, want %q", got, want)
+	}
+	if got := (Location{}).LogPrefix(); got != "" {
+		t.Errorf("empty LogPrefix = %q", got)
+	}
+	want := attribute.NewSet(attribute.String("cloud.availability_zone", "us-central1-a"), attribute.String("k8s.pod.name", "writer-0"))
+	if got := attribute.NewSet(l.Attributes()...); !got.Equals(&want) {
+		t.Errorf("Attributes = %v, want %v", got.ToSlice(), want.ToSlice())
+	}
+}
@@ -0,0 +1,185 @@
+package gcputil
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	secretmanager "google.golang.org/api/secretmanager/v1"
+)
+
+// Schemes of secret references.
+const (
+	secretManagerScheme = "sm://"
+	kmsScheme           = "kms://"
+)
+
+// IsSecretRef reports whether v references a secret rather than holding it.
+func IsSecretRef(v string) bool {
+	return strings.HasPrefix(v, secretManagerScheme) || strings.HasPrefix(v, kmsScheme)
+}
+
+// ResolveSecret returns the value ref refers to:
+//
+//	sm://projects/P/secrets/S[/versions/V]  → the Secret Manager secret, latest version by default
+//	kms://projects/P/locations/L/keyRings/R/cryptoKeys/K#C → C (base64) decrypted with the KMS key
+//
+// Other values are returned as they are, so plain config keeps working.
+// Trailing newlines, which secret files often end with, are trimmed.
+func ResolveSecret(ctx context.Context, ref string) (string, error) {
+	var b []byte
+	var err error
+	switch {
+	case strings.HasPrefix(ref, secretManagerScheme):
+		b, err = accessSecretVersion(ctx, strings.TrimPrefix(ref, secretManagerScheme))
+	case strings.HasPrefix(ref, kmsScheme):
+		key, ciphertext, ok := strings.Cut(strings.TrimPrefix(ref, kmsScheme), "#")
+		if !ok {
+			return "", fmt.Errorf("kms secret reference needs #<base64 ciphertext> after the key name")
+		}
+		var raw []byte
+		if raw, err = base64.StdEncoding.DecodeString(ciphertext); err != nil {
+			return "", fmt.Errorf("kms secret ciphertext is not base64: %w", err)
+		}
+		b, err = KMSDecrypt(ctx, key, raw)
+	default:
+		return ref, nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(b), "\r\n"), nil
+}
+
+func accessSecretVersion(ctx context.Context, name string) ([]byte, error) {
+	if !strings.Contains(name, "/versions/") {
+		name += "/versions/latest"
+	}
+	svc, err := secretmanager.NewService(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("secret manager client: %w", err)
+	}
+	resp, err := svc.Projects.Secrets.Versions.Access(name).Context(ctx).Do()
+	if err != nil {
+		return nil, fmt.Errorf("accessing secret %s: %w", name, err)
+	}
+	if resp.Payload == nil {
+		return nil, fmt.Errorf("secret %s has no payload", name)
+	}
+	return base64.StdEncoding.DecodeString(resp.Payload.Data)
+}
+
+// Secret is a config value that may reference a secret, resolved at
+// startup and, for Secret Manager references, again every refresh
+// interval, so rotated secrets are picked up without a redeploy.
+type Secret struct {
+	ref      string
+	resolve  func(context.Context, string) (string, error)
+	onChange func(string) error
+
+	mu    sync.RWMutex
+	value string
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// StaticSecret returns a Secret holding value, never refreshed.
+func StaticSecret(value string) *Secret {
+	return &Secret{value: value}
+}
+
+// NewSecret resolves ref with ResolveSecret and, if it is a Secret Manager
+// reference and interval is positive, refreshes it in the background (a
+// KMS reference holds its ciphertext and cannot change). onChange, which may
+// be nil, validates and applies every new value; a refreshed value it
+// rejects is logged and the previous one kept, while at startup the error
+// is returned.
+func NewSecret(ctx context.Context, ref string, interval time.Duration, onChange func(string) error) (*Secret, error) {
+	return newSecret(ctx, ref, interval, onChange, ResolveSecret)
+}
+
+func newSecret(ctx context.Context, ref string, interval time.Duration, onChange func(string) error, resolve func(context.Context, string) (string, error)) (*Secret, error) {
+	s := &Secret{ref: ref, resolve: resolve, onChange: onChange}
+	v, err := resolve(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	if onChange != nil {
+		if err := onChange(v); err != nil {
+			return nil, err
+		}
+	}
+	s.value = v
+	if strings.HasPrefix(ref, secretManagerScheme) && interval > 0 {
+		s.stop = make(chan struct{})
+		s.done = make(chan struct{})
+		go s.refreshEvery(interval)
+	}
+	return s, nil
+}
+
+// Value returns the current value.
+func (s *Secret) Value() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.value
+}
+
+func (s *Secret) refreshEvery(interval time.Duration) {
+	defer close(s.done)
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-t.C:
+			s.refresh()
+		}
+	}
+}
+
+// refresh resolves the reference again, keeping the current value when
+// that fails.
+func (s *Secret) refresh() {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	v, err := s.resolve(ctx, s.ref)
+	if err != nil {
+		log.Printf("secret refresh of %s failed, keeping the current value: %v", s.name(), err)
+		return
+	}
+	if v == s.Value() {
+		return
+	}
+	if s.onChange != nil {
+		if err := s.onChange(v); err != nil {
+			log.Printf("secret refresh of %s rejected, keeping the current value: %v", s.name(), err)
+			return
+		}
+	}
+	s.mu.Lock()
+	s.value = v
+	s.mu.Unlock()
+	log.Printf("secret %s refreshed", s.name())
+}
+
+// name identifies the secret in logs, without a KMS ciphertext.
+func (s *Secret) name() string {
+	name, _, _ := strings.Cut(s.ref, "#")
+	return name
+}
+
+// Close stops refreshing.
+func (s *Secret) Close() {
+	if s.stop != nil {
+		close(s.stop)
+		<-s.done
+		s.stop = nil
+	}
+}
@@ -0,0 +1,96 @@
+package gcputil
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestResolveSecret_PlainValues(t *testing.T) {
+	for _, v := range []string{"", "root", "k1:secret,k2:other"} {
+		got, err := ResolveSecret(context.Background(), v)
+		if err != nil || got != v {
+			t.Errorf("ResolveSecret(%q) = %q, %v", v, got, err)
+		}
+	}
+	if _, err := ResolveSecret(context.Background(), "kms://projects/p/locations/l/keyRings/r/cryptoKeys/k"); err == nil {
+		t.Error("kms reference without ciphertext: want an error")
+	}
+}
+
+// fakeResolver serves the values set on it, or fails while err is set.
+type fakeResolver struct {
+	mu    sync.Mutex
+	value string
+	err   error
+}
+
+func (f *fakeResolver) set(v string, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.value, f.err = v, err
+}
+
+func (f *fakeResolver) resolve(context.Context, string) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.value, f.err
+}
+
+func TestSecret_Refresh(t *testing.T) {
+	f := &fakeResolver{value: "v1"}
+	var applied []string
+	onChange := func(v string) error {
+		if strings.HasPrefix(v, "bad") {
+			return errors.New("rejected")
+		}
+		applied = append(applied, v)
+		return nil
+	}
+	s, err := newSecret(context.Background(), "sm://projects/p/secrets/s", 0, onChange, f.resolve)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	f.set("v2", nil)
+	s.refresh()
+	if s.Value() != "v2" {
+		t.Fatalf("after refresh: got %q, want v2", s.Value())
+	}
+	f.set("", errors.New("unavailable"))
+	s.refresh()
+	f.set("bad value", nil)
+	s.refresh()
+	if s.Value() != "v2" {
+		t.Fatalf("after failed refreshes: got %q, want v2", s.Value())
+	}
+	if strings.Join(applied, ",") != "v1,v2" {
+		t.Fatalf("applied %v, want [v1 v2]", applied)
+	}
+
+	f.set("bad value", nil)
+	if _, err := newSecret(context.Background(), "sm://projects/p/secrets/s", 0, onChange, f.resolve); err == nil {
+		t.Fatal("rejected value at startup: want an error")
+	}
+}
+
+func TestSecret_RefreshesInBackground(t *testing.T) {
+	f := &fakeResolver{value: "v1"}
+	s, err := newSecret(context.Background(), "sm://projects/p/secrets/s", time.Millisecond, nil, f.resolve)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	f.set("v2", nil)
+	deadline := time.Now().Add(5 * time.Second)
+	for s.Value() != "v2" {
+		if time.Now().After(deadline) {
+			t.Fatal("secret not refreshed")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,84 @@
+package hmacsig
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSignVerify(t *testing.T) {
+	k, err := ParseKeyring("k2:new-secret, k1:old-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(1700000000, 0)
+	body := []byte(`{"url_target":"https://example.com"}`)
+	sig := k.Sign(body, now)
+	if !strings.Contains(sig, "kid=k2") {
+		t.Fatalf("want the primary key to sign, got %s", sig)
+	}
+
+	tests := []struct {
+		name   string
+		header string
+		body   []byte
+		at     time.Time
+		want   error
+	}{
+		{"valid", sig, body, now, nil},
+		{"clock skew within window", sig, body, now.Add(-time.Minute), nil},
+		{"replayed late", sig, body, now.Add(DefaultWindow + time.Second), ErrExpired},
+		{"tampered body", sig, []byte(`{}`), now, ErrMismatch},
+		{"missing", "", body, now, ErrMissing},
+		{"malformed", "t=abc,kid=k2,v1=00", body, now, ErrMalformed},
+		{"unknown key", strings.Replace(sig, "kid=k2", "kid=k9", 1), body, now, ErrUnknownKey},
+	}
+	for _, tt := range tests {
+		if err := k.Verify(tt.header, tt.body, tt.at, DefaultWindow); !errors.Is(err, tt.want) {
+			t.Errorf("%s: want %v, got %v", tt.name, tt.want, err)
+		}
+	}
+}
+
+func TestRotation(t *testing.T) {
+	old, _ := ParseKeyring("k1:old-secret")
+	rotated, _ := ParseKeyring("k2:new-secret,k1:old-secret")
+	now := time.Now()
+	if err := rotated.Verify(old.Sign([]byte("x"), now), []byte("x"), now, DefaultWindow); err != nil {
+		t.Fatalf("signature by the previous key: %v", err)
+	}
+}
+
+func TestParseKeyring_Invalid(t *testing.T) {
+	for _, spec := range []string{"", "nosecret", "k1:a,k1:b", ":secret"} {
+		if _, err := ParseKeyring(spec); err == nil {
+			t.Errorf("ParseKeyring(%q): want error", spec)
+		}
+	}
+}
+
+func TestRequire(t *testing.T) {
+	k, _ := ParseKeyring("k1:secret")
+	h := Require(k, 0)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		w.Write(b)
+	}))
+
+	req := httptest.NewRequest(http.MethodPost, "/write/v1", strings.NewReader("payload"))
+	k.SignRequest(req, []byte("payload"))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || rec.Body.String() != "payload" {
+		t.Fatalf("signed: want 200 with the body passed on, got %d %q", rec.Code, rec.Body)
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/write/v1", strings.NewReader("payload")))
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("unsigned: want 401, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,164 @@
+// Package hold places short-lived holds on custom aliases, so a UI can
+// check that an alias is free and keep it while the user finishes a form.
+//
+// A hold is identified by a random token: only a create presenting the
+// token may take a held alias until the hold expires. Expired holds are
+// simply replaced by the next one.
+package hold
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/datastore"
+
+	"github.com/FlorinBalint/shortener/pkg/gcputil"
+)
+
+// ErrHeld is returned when an alias is held under another token.
+var ErrHeld = errors.New("alias is held")
+
+// Hold is the persisted hold of an alias.
+type Hold struct {
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// live reports whether the hold is in force at now.
+func (h Hold) live(now time.Time) bool {
+	return h.Token != "" && now.Before(h.ExpiresAt)
+}
+
+// Store persists holds by alias.
+type Store interface {
+	// Get returns the hold of alias, or the zero Hold if there is none.
+	Get(ctx context.Context, alias string) (Hold, error)
+	// Update atomically applies update to the hold of alias, starting from
+	// the zero Hold if there is none. Nothing is written if update fails.
+	Update(ctx context.Context, alias string, update func(*Hold) error) error
+	// Delete removes the hold of alias, if any.
+	Delete(ctx context.Context, alias string) error
+}
+
+// DSStore stores holds in Datastore.
+type DSStore struct {
+	client *gcputil.DSClient
+}
+
+var _ Store = (*DSStore)(nil)
+
+// NewDSStore returns a store persisting holds under kind "alias_hold".
+func NewDSStore(client *gcputil.DSClient) *DSStore {
+	return &DSStore{client: client}
+}
+
+// Get implements Store.
+func (s *DSStore) Get(ctx context.Context, alias string) (Hold, error) {
+	h, err := gcputil.GetValue[Hold](s.client, ctx, "alias_hold", alias)
+	if errors.Is(err, datastore.ErrNoSuchEntity) {
+		return Hold{}, nil
+	}
+	return h, err
+}
+
+// Update implements Store.
+func (s *DSStore) Update(ctx context.Context, alias string, update func(*Hold) error) error {
+	return gcputil.UpsertValue(s.client, ctx, "alias_hold", alias, update)
+}
+
+// Delete implements Store.
+func (s *DSStore) Delete(ctx context.Context, alias string) error {
+	return s.client.Delete(ctx, "alias_hold", alias)
+}
+
+// MemoryStore is an in-process Store, for tests and local development.
+type MemoryStore struct {
+	mu    sync.Mutex
+	holds map[string]Hold
+}
+
+var _ Store = (*MemoryStore)(nil)
+
+// NewMemoryStore returns an empty in-memory store.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{holds: make(map[string]Hold)}
+}
+
+// Get implements Store.
+func (s *MemoryStore) Get(ctx context.Context, alias string) (Hold, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.holds[alias], nil
+}
+
+// Update implements Store.
+func (s *MemoryStore) Update(ctx context.Context, alias string, update func(*Hold) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	h := s.holds[alias]
+	if err := update(&h); err != nil {
+		return err
+	}
+	s.holds[alias] = h
+	return nil
+}
+
+// Delete implements Store.
+func (s *MemoryStore) Delete(ctx context.Context, alias string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.holds, alias)
+	return nil
+}
+
+// Holds places and checks holds.
+type Holds struct {
+	store Store
+	now   func() time.Time
+}
+
+// NewHolds returns holds kept in store.
+func NewHolds(store Store) *Holds {
+	return &Holds{store: store, now: time.Now}
+}
+
+// Place holds alias for ttl, failing with ErrHeld while it is held under
+// another token. Presenting the token of the hold in force extends it;
+// otherwise a new hold is placed, with a new token.
+func (h *Holds) Place(ctx context.Context, alias, token string, ttl time.Duration) (Hold, error) {
+	var placed Hold
+	err := h.store.Update(ctx, alias, func(cur *Hold) error {
+		now := h.now()
+		switch {
+		case !cur.live(now):
+			// Free, or the hold lapsed: a new hold, with a new token.
+			*cur = Hold{Token: rand.Text()}
+		case cur.Token != token:
+			return ErrHeld
+		}
+		cur.ExpiresAt = now.Add(ttl).UTC()
+		placed = *cur
+		return nil
+	})
+	return placed, err
+}
+
+// Check returns ErrHeld if alias is held under a token other than token.
+func (h *Holds) Check(ctx context.Context, alias, token string) error {
+	cur, err := h.store.Get(ctx, alias)
+	if err != nil {
+		return err
+	}
+	if cur.live(h.now()) && cur.Token != token {
+		return ErrHeld
+	}
+	return nil
+}
+
+// Release drops the hold of alias, once the alias has been taken.
+func (h *Holds) Release(ctx context.Context, alias string) error {
+	return h.store.Delete(ctx, alias)
+}
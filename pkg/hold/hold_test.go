@@ -0,0 +1,60 @@
+package hold
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestHolds(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	h := NewHolds(NewMemoryStore())
+	h.now = func() time.Time { return now }
+
+	first, err := h.Place(ctx, "spring", "", 5*time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Token == "" || !first.ExpiresAt.Equal(now.Add(5*time.Minute)) {
+		t.Fatalf("unexpected hold %+v", first)
+	}
+	if _, err := h.Place(ctx, "spring", "", 5*time.Minute); !errors.Is(err, ErrHeld) {
+		t.Fatalf("second hold: want ErrHeld, got %v", err)
+	}
+	if err := h.Check(ctx, "spring", ""); !errors.Is(err, ErrHeld) {
+		t.Fatalf("check without token: want ErrHeld, got %v", err)
+	}
+	if err := h.Check(ctx, "spring", first.Token); err != nil {
+		t.Fatalf("check with token: %v", err)
+	}
+	if err := h.Check(ctx, "summer", ""); err != nil {
+		t.Fatalf("check of unheld alias: %v", err)
+	}
+
+	now = now.Add(4 * time.Minute)
+	renewed, err := h.Place(ctx, "spring", first.Token, 5*time.Minute)
+	if err != nil {
+		t.Fatalf("renew: %v", err)
+	}
+	if renewed.Token != first.Token || !renewed.ExpiresAt.Equal(now.Add(5*time.Minute)) {
+		t.Fatalf("renew: got %+v", renewed)
+	}
+
+	now = now.Add(6 * time.Minute)
+	if err := h.Check(ctx, "spring", ""); err != nil {
+		t.Fatalf("check after expiry: %v", err)
+	}
+	next, err := h.Place(ctx, "spring", "", time.Minute)
+	if err != nil || next.Token == first.Token {
+		t.Fatalf("hold after expiry: got %+v, %v", next, err)
+	}
+
+	if err := h.Release(ctx, "spring"); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.Check(ctx, "spring", ""); err != nil {
+		t.Fatalf("check after release: %v", err)
+	}
+}
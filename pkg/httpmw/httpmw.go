@@ -0,0 +1,233 @@
+// Package httpmw holds the HTTP middlewares the services share and Chain,
+// which composes them, so the reader, the writer and keygen log, measure,
+// recover from panics and send security headers alike. Middlewares with packages of their own
+// (requestid, clientip, ipfilter, hmacsig, loadshed, tlsutil) compose the
+// same way.
+package httpmw
+
+import (
+	"log"
+	"net/http"
+	"net/netip"
+	"runtime/debug"
+	"strconv"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+
+	"github.com/FlorinBalint/shortener/pkg/clientip"
+	"github.com/FlorinBalint/shortener/pkg/requestid"
+	"github.com/FlorinBalint/shortener/pkg/servertiming"
+)
+
+// meterName is the instrumentation scope of the request metrics.
+const meterName = "github.com/FlorinBalint/shortener/pkg/httpmw"
+
+// Middleware wraps a handler.
+type Middleware func(http.Handler) http.Handler
+
+// Chain composes mws into one middleware, the first outermost. Nil
+// middlewares are skipped, so optional ones can be listed unconditionally.
+func Chain(mws ...Middleware) Middleware {
+	return func(next http.Handler) http.Handler {
+		for i := len(mws) - 1; i >= 0; i-- {
+			if mws[i] != nil {
+				next = mws[i](next)
+			}
+		}
+		return next
+	}
+}
+
+// When applies mw to the requests match selects, and passes the others
+// straight on. It returns nil for a nil mw.
+func When(match func(*http.Request) bool, mw Middleware) Middleware {
+	if mw == nil {
+		return nil
+	}
+	return func(next http.Handler) http.Handler {
+		wrapped := mw(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if match(r) {
+				wrapped.ServeHTTP(w, r)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// Options configures Common.
+//
+// Service names the server in its log lines. TrustedProxies may set
+// Forwarded and X-Forwarded-For (see clientip). AccessLog logs a line per
+// request, except while Quiet, when set, reports true, e.g. when the
+// server sheds non-essential work. MeterProvider, when set, records
+// request metrics (see Metrics) with Attributes, e.g. the replica's
+// location. ServerTiming selects the responses breaking their latency
+// down (see servertiming).
+type Options struct {
+	Service        string
+	TrustedProxies []netip.Prefix
+	AccessLog      bool
+	Quiet          func() bool
+	MeterProvider  metric.MeterProvider
+	Attributes     []attribute.KeyValue
+	ServerTiming   servertiming.Mode
+}
+
+// Common returns the middlewares every service runs first: the request
+// ID, the client IP, server timing, the access log and metrics when
+// enabled, which see the 500 of a panic, then panic recovery.
+func Common(o Options) (Middleware, error) {
+	if err := o.ServerTiming.Validate(); err != nil {
+		return nil, err
+	}
+	mws := []Middleware{identify(o.TrustedProxies), servertiming.Middleware(o.ServerTiming)}
+	if o.AccessLog {
+		log := AccessLog(o.Service)
+		if quiet := o.Quiet; quiet != nil {
+			log = When(func(*http.Request) bool { return !quiet() }, log)
+		}
+		mws = append(mws, log)
+	}
+	if o.MeterProvider != nil {
+		m, err := Metrics(o.MeterProvider, o.Attributes...)
+		if err != nil {
+			return nil, err
+		}
+		mws = append(mws, m)
+	}
+	return Chain(append(mws, Recover(o.Service))...), nil
+}
+
+// identify stores the request ID and client IP of each request in its
+// context, and echoes the ID, as requestid.Middleware then
+// clientip.Middleware do, but copying the request once.
+func identify(trusted []netip.Prefix) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			id := requestid.FromRequest(r)
+			w.Header()[requestid.Header] = []string{id}
+			ctx := clientip.NewContext(requestid.NewContext(r.Context(), id), clientip.Resolve(r, trusted))
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// Recover answers 500 to requests whose handler panics, unless it already
+// answered, and logs the panic with the request ID and stack. Panics with
+// http.ErrAbortHandler go on, so the server aborts the response quietly.
+func Recover(service string) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			rec := &recorder{ResponseWriter: w}
+			defer func() {
+				v := recover()
+				if v == nil {
+					return
+				}
+				if v == http.ErrAbortHandler {
+					panic(v)
+				}
+				log.Printf("%s: panic serving %s %s (request %s): %v\n%s", service, r.Method, r.URL.Path, requestid.FromContext(r.Context()), v, debug.Stack())
+				if rec.status == 0 {
+					http.Error(w, "internal error", http.StatusInternalServerError)
+				}
+			}()
+			next.ServeHTTP(rec, r)
+		})
+	}
+}
+
+// AccessLog logs a line per request: method, path, status, response
+// bytes, duration, client IP and request ID. Query strings are left out,
+// as they may carry tokens.
+func AccessLog(service string) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			rec := &recorder{ResponseWriter: w}
+			defer func() {
+				log.Printf("%s: %s %s %d %dB %s ip=%s request=%s", service, r.Method, r.URL.Path, rec.code(), rec.bytes,
+					time.Since(start).Round(time.Microsecond), clientip.FromRequest(r), requestid.FromContext(r.Context()))
+			}()
+			next.ServeHTTP(rec, r)
+		})
+	}
+}
+
+// Metrics returns a middleware recording http.server.request.duration, a
+// histogram of request latencies in seconds by method and status code,
+// with meters of provider. Every measurement also carries attrs.
+func Metrics(provider metric.MeterProvider, attrs ...attribute.KeyValue) (Middleware, error) {
+	duration, err := provider.Meter(meterName).Float64Histogram("http.server.request.duration",
+		metric.WithUnit("s"),
+		metric.WithDescription("Latency of HTTP requests."),
+		metric.WithExplicitBucketBoundaries(0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5))
+	if err != nil {
+		return nil, err
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			rec := &recorder{ResponseWriter: w}
+			defer func() {
+				duration.Record(r.Context(), time.Since(start).Seconds(), metric.WithAttributes(append([]attribute.KeyValue{
+					attribute.String("http.request.method", method(r.Method)),
+					attribute.String("http.response.status_code", strconv.Itoa(rec.code())),
+				}, attrs...)...))
+			}()
+			next.ServeHTTP(rec, r)
+		})
+	}, nil
+}
+
+// method returns the method of a request as recorded, folding unknown
+// ones so clients cannot grow the metrics.
+func method(m string) string {
+	switch m {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodOptions:
+		return m
+	default:
+		return "_OTHER"
+	}
+}
+
+// recorder remembers the status and size of a response. Unwrap lets
+// http.ResponseController reach the writer below, to flush.
+type recorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (r *recorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *recorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	n, err := r.ResponseWriter.Write(b)
+	r.bytes += n
+	return n, err
+}
+
+func (r *recorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// code returns the status sent, 200 when the handler wrote nothing.
+func (r *recorder) code() int {
+	if r.status == 0 {
+		return http.StatusOK
+	}
+	return r.status
+}
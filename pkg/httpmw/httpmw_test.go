@@ -0,0 +1,187 @@
+package httpmw
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.opentelemetry.io/otel/attribute"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+
+	"github.com/FlorinBalint/shortener/pkg/requestid"
+)
+
+// tag appends name to the X-Trace response header, to show the order
+// middlewares ran in.
+func tag(name string) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Add("X-Trace", name)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func serve(h http.Handler, method, path string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(method, path, nil))
+	return rec
+}
+
+func captureLog(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	prev := log.Writer()
+	log.SetOutput(&buf)
+	t.Cleanup(func() { log.SetOutput(prev) })
+	return &buf
+}
+
+func TestChain(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	h := Chain(tag("a"), nil, tag("b"), When(func(r *http.Request) bool { return r.Method == http.MethodPost }, tag("c")))(ok)
+
+	if got := serve(h, http.MethodGet, "/").Header().Values("X-Trace"); strings.Join(got, ",") != "a,b" {
+		t.Errorf("GET: want a,b, got %v", got)
+	}
+	if got := serve(h, http.MethodPost, "/").Header().Values("X-Trace"); strings.Join(got, ",") != "a,b,c" {
+		t.Errorf("POST: want a,b,c, got %v", got)
+	}
+	if When(func(*http.Request) bool { return true }, nil) != nil {
+		t.Errorf("When of nil: want nil")
+	}
+}
+
+func TestRecover(t *testing.T) {
+	logs := captureLog(t)
+	h := Chain(requestid.Middleware, Recover("test"))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/written" {
+			w.WriteHeader(http.StatusAccepted)
+		}
+		panic("boom")
+	}))
+
+	rec := serve(h, http.MethodGet, "/")
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("want 500, got %d", rec.Code)
+	}
+	id := rec.Header().Get(requestid.Header)
+	if out := logs.String(); !strings.Contains(out, "test: panic serving GET /") || !strings.Contains(out, id) || !strings.Contains(out, "boom") {
+		t.Errorf("want the panic logged with request %s, got %q", id, out)
+	}
+	if rec := serve(h, http.MethodGet, "/written"); rec.Code != http.StatusAccepted {
+		t.Errorf("want the status already sent kept, got %d", rec.Code)
+	}
+
+	abort := Recover("test")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic(http.ErrAbortHandler)
+	}))
+	defer func() {
+		if v := recover(); v != http.ErrAbortHandler {
+			t.Errorf("want http.ErrAbortHandler to go on, got %v", v)
+		}
+	}()
+	serve(abort, http.MethodGet, "/")
+}
+
+func TestAccessLog(t *testing.T) {
+	logs := captureLog(t)
+	common, err := Common(Options{Service: "test", AccessLog: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := common(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/panic" {
+			panic("boom")
+		}
+		http.Error(w, "gone", http.StatusGone)
+	}))
+
+	rec := serve(h, http.MethodGet, "/old?token=secret")
+	line := logs.String()
+	if !strings.Contains(line, "test: GET /old 410 5B") || !strings.Contains(line, "request="+rec.Header().Get(requestid.Header)) {
+		t.Errorf("unexpected access log %q", line)
+	}
+	if strings.Contains(line, "secret") {
+		t.Errorf("want the query left out, got %q", line)
+	}
+
+	logs.Reset()
+	serve(h, http.MethodGet, "/panic")
+	if !strings.Contains(logs.String(), "test: GET /panic 500") {
+		t.Errorf("want the 500 of a panic logged, got %q", logs.String())
+	}
+}
+
+func TestAccessLog_Quiet(t *testing.T) {
+	logs := captureLog(t)
+	quiet := true
+	common, err := Common(Options{Service: "test", AccessLog: true, Quiet: func() bool { return quiet }})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := common(http.NotFoundHandler())
+
+	serve(h, http.MethodGet, "/a")
+	if logs.Len() != 0 {
+		t.Errorf("want no access log while quiet, got %q", logs.String())
+	}
+	quiet = false
+	serve(h, http.MethodGet, "/b")
+	if !strings.Contains(logs.String(), "test: GET /b 404") {
+		t.Errorf("want the access log back, got %q", logs.String())
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	reader := sdkmetric.NewManualReader()
+	zone := attribute.String("cloud.availability_zone", "europe-west1-b")
+	m, err := Metrics(sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)), zone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := m(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			w.WriteHeader(http.StatusCreated)
+		}
+	}))
+	serve(h, http.MethodGet, "/a")
+	serve(h, http.MethodGet, "/b")
+	serve(h, http.MethodPost, "/c")
+	serve(h, "BREW", "/d")
+
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(context.Background(), &rm); err != nil {
+		t.Fatal(err)
+	}
+	got := make(map[string]uint64)
+	for _, sm := range rm.ScopeMetrics {
+		for _, md := range sm.Metrics {
+			if md.Name != "http.server.request.duration" {
+				continue
+			}
+			for _, dp := range md.Data.(metricdata.Histogram[float64]).DataPoints {
+				method, _ := dp.Attributes.Value("http.request.method")
+				status, _ := dp.Attributes.Value("http.response.status_code")
+				if v, _ := dp.Attributes.Value(zone.Key); v != zone.Value {
+					t.Errorf("want the zone on every measurement, got %v", dp.Attributes.ToSlice())
+				}
+				got[method.AsString()+" "+status.AsString()] = dp.Count
+			}
+		}
+	}
+	want := map[string]uint64{"GET 200": 2, "POST 201": 1, "_OTHER 200": 1}
+	if len(got) != len(want) {
+		t.Fatalf("want %v, got %v", want, got)
+	}
+	for k, n := range want {
+		if got[k] != n {
+			t.Errorf("%s: want %d, got %d", k, n, got[k])
+		}
+	}
+}
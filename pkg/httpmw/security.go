@@ -0,0 +1,82 @@
+package httpmw
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Security configures SecurityHeaders.
+//
+// HSTSMaxAge, when positive, sends Strict-Transport-Security, which makes
+// browsers use HTTPS for the host for that long, and its subdomains with
+// HSTSIncludeSubdomains; HSTSPreload asks to be listed in browsers (see
+// hstspreload.org). ReferrerPolicy, when set, is the Referrer-Policy of
+// every response.
+type Security struct {
+	HSTSMaxAge            time.Duration
+	HSTSIncludeSubdomains bool
+	HSTSPreload           bool
+	ReferrerPolicy        string
+}
+
+// minPreloadMaxAge is the shortest HSTS max-age preload lists accept.
+const minPreloadMaxAge = 365 * 24 * time.Hour
+
+// referrerPolicies are the Referrer-Policy tokens browsers know.
+var referrerPolicies = map[string]bool{
+	"no-referrer":                     true,
+	"no-referrer-when-downgrade":      true,
+	"origin":                          true,
+	"origin-when-cross-origin":        true,
+	"same-origin":                     true,
+	"strict-origin":                   true,
+	"strict-origin-when-cross-origin": true,
+	"unsafe-url":                      true,
+}
+
+// CheckReferrerPolicy fails unless policy is a Referrer-Policy token.
+func CheckReferrerPolicy(policy string) error {
+	if !referrerPolicies[policy] {
+		return fmt.Errorf("invalid referrer policy %q", policy)
+	}
+	return nil
+}
+
+// SecurityHeaders returns a middleware sending X-Content-Type-Options:
+// nosniff on every response, and the headers s enables.
+func SecurityHeaders(s Security) (Middleware, error) {
+	if s.ReferrerPolicy != "" {
+		if err := CheckReferrerPolicy(s.ReferrerPolicy); err != nil {
+			return nil, err
+		}
+	}
+	if s.HSTSPreload && (!s.HSTSIncludeSubdomains || s.HSTSMaxAge < minPreloadMaxAge) {
+		return nil, fmt.Errorf("HSTS preload needs includeSubDomains and a max-age of at least %v", minPreloadMaxAge)
+	}
+	// The values are built once and shared by every response.
+	set := http.Header{"X-Content-Type-Options": {"nosniff"}}
+	if s.HSTSMaxAge > 0 {
+		hsts := "max-age=" + strconv.FormatInt(int64(s.HSTSMaxAge/time.Second), 10)
+		if s.HSTSIncludeSubdomains {
+			hsts += "; includeSubDomains"
+		}
+		if s.HSTSPreload {
+			hsts += "; preload"
+		}
+		set["Strict-Transport-Security"] = []string{hsts}
+	}
+	if s.ReferrerPolicy != "" {
+		set["Referrer-Policy"] = []string{s.ReferrerPolicy}
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			h := w.Header()
+			for k, v := range set {
+				h[k] = v
+			}
+			next.ServeHTTP(w, r)
+		})
+	}, nil
+}
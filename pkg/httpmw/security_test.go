@@ -0,0 +1,49 @@
+package httpmw
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestSecurityHeaders(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	mw, err := SecurityHeaders(Security{
+		HSTSMaxAge:            2 * 365 * 24 * time.Hour,
+		HSTSIncludeSubdomains: true,
+		HSTSPreload:           true,
+		ReferrerPolicy:        "no-referrer",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := serve(mw(ok), http.MethodGet, "/").Header()
+	for k, want := range map[string]string{
+		"X-Content-Type-Options":    "nosniff",
+		"Strict-Transport-Security": "max-age=63072000; includeSubDomains; preload",
+		"Referrer-Policy":           "no-referrer",
+	} {
+		if got := h.Get(k); got != want {
+			t.Errorf("%s: want %q, got %q", k, want, got)
+		}
+	}
+
+	mw, err = SecurityHeaders(Security{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h = serve(mw(ok), http.MethodGet, "/").Header()
+	if h.Get("X-Content-Type-Options") != "nosniff" || h.Get("Strict-Transport-Security") != "" || h.Get("Referrer-Policy") != "" {
+		t.Errorf("want nosniff alone by default, got %v", h)
+	}
+
+	for _, s := range []Security{
+		{ReferrerPolicy: "never"},
+		{HSTSMaxAge: 365 * 24 * time.Hour, HSTSPreload: true},
+		{HSTSMaxAge: time.Hour, HSTSIncludeSubdomains: true, HSTSPreload: true},
+	} {
+		if _, err := SecurityHeaders(s); err == nil {
+			t.Errorf("%+v: want an error", s)
+		}
+	}
+}
@@ -0,0 +1,282 @@
+// Package hygiene scans the keyspace offline and reports what cleaning it
+// up would touch: links sharing a target, entries expired or deleted but
+// still stored, targets that are not valid http(s) URLs, aliases of
+// missing links, and storage statistics. It changes nothing; the report informs the janitor's and the
+// archiver's settings before automatic cleanup is enabled.
+package hygiene
+
+import (
+	"cmp"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/FlorinBalint/shortener/pkg/urlstore"
+)
+
+// Config configures a scan.
+//
+// BatchSize is the number of entries scanned per store page (default
+// 500). MaxFindings caps the findings of each kind listed in the report
+// (default 1000); all are counted. Largest is how many of the largest
+// entries are listed (default 20).
+type Config struct {
+	BatchSize   int
+	MaxFindings int
+	Largest     int
+}
+
+// Finding is an entry worth a look.
+type Finding struct {
+	Key    string `json:"key"`
+	Reason string `json:"reason"`
+}
+
+// Duplicate is a target shared by several active links.
+type Duplicate struct {
+	// Target is the normalized target, or its host for encrypted targets.
+	Target string   `json:"target"`
+	Keys   []string `json:"keys"`
+}
+
+// Sized is an entry with its stored size.
+type Sized struct {
+	Key   string `json:"key"`
+	Bytes int    `json:"bytes"`
+}
+
+// Stats describes what the store holds.
+type Stats struct {
+	Entries int `json:"entries"`
+	Active  int `json:"active"`
+	// Bytes is the size of the stored JSON, without Datastore's overhead.
+	Bytes           int            `json:"bytes"`
+	Encrypted       int            `json:"encrypted"`
+	WithExpiry      int            `json:"with_expiry"`
+	BurnAfterRead   int            `json:"burn_after_reading"`
+	WithPreview     int            `json:"with_preview"`
+	Wildcards       int            `json:"wildcards"`
+	Aliases         int            `json:"aliases"`
+	BySchemaVersion map[string]int `json:"by_schema_version"`
+	Oldest          time.Time      `json:"oldest,omitzero"`
+	Newest          time.Time      `json:"newest,omitzero"`
+	Largest         []Sized        `json:"largest"`
+}
+
+// Report is the outcome of a scan.
+type Report struct {
+	GeneratedAt time.Time `json:"generated_at"`
+	Stats       Stats     `json:"stats"`
+	// ExpiredCount and DeletedCount count the entries no longer served
+	// but still stored, which the janitor purges after its retention.
+	ExpiredCount int       `json:"expired_count"`
+	Expired      []Finding `json:"expired"`
+	DeletedCount int       `json:"deleted_count"`
+	Deleted      []Finding `json:"deleted"`
+	// Invalid lists the targets that are not valid, and the aliases whose
+	// link is missing or inactive.
+	InvalidCount int       `json:"invalid_target_count"`
+	Invalid      []Finding `json:"invalid_targets"`
+	// DuplicateCount counts the targets shared by several active links;
+	// Duplicates lists those shared by the most first.
+	DuplicateCount int         `json:"duplicate_target_count"`
+	Duplicates     []Duplicate `json:"duplicate_targets"`
+}
+
+// Scan reads the whole store, inactive entries included, and reports on
+// it as of now. Duplicates are found in memory, keeping every active key
+// by target, as are dangling aliases.
+func Scan(ctx context.Context, store urlstore.Client, cfg Config, now time.Time) (Report, error) {
+	cfg.BatchSize = cmp.Or(cfg.BatchSize, 500)
+	cfg.MaxFindings = cmp.Or(cfg.MaxFindings, 1000)
+	cfg.Largest = cmp.Or(cfg.Largest, 20)
+
+	r := Report{GeneratedAt: now, Stats: Stats{BySchemaVersion: make(map[string]int)}}
+	byTarget := make(map[string][]string)
+	targets := make(map[string]string)
+	active := make(map[string]bool)
+	aliases := make(map[string]string)
+	note := func(list *[]Finding, count *int, key, reason string) {
+		*count++
+		if len(*list) < cfg.MaxFindings {
+			*list = append(*list, Finding{Key: key, Reason: reason})
+		}
+	}
+	opts := urlstore.ListOptions{Limit: cfg.BatchSize, IncludeInactive: true}
+	for {
+		page, err := store.ListEntries(ctx, opts)
+		if err != nil {
+			return r, err
+		}
+		for _, ke := range page.Entries {
+			key, e := string(ke.Key), ke.Entry
+			r.Stats.count(key, e, cfg.Largest)
+			switch {
+			case e.Deleted():
+				note(&r.Deleted, &r.DeletedCount, key, "deleted "+e.DeletedAt.Format(time.RFC3339))
+				continue
+			case e.Expired(now):
+				note(&r.Expired, &r.ExpiredCount, key, "expired "+e.ExpiresAt.Format(time.RFC3339))
+				continue
+			}
+			r.Stats.Active++
+			active[key] = true
+			if e.AliasOf != "" {
+				aliases[key] = e.AliasOf
+				continue
+			}
+			if e.TargetIndex == nil {
+				if reason := invalidTarget(e.URLTarget); reason != "" {
+					note(&r.Invalid, &r.InvalidCount, key, reason)
+				}
+			}
+			hash := e.TargetHash()
+			byTarget[hash] = append(byTarget[hash], key)
+			if _, ok := targets[hash]; !ok {
+				targets[hash] = describeTarget(e)
+			}
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		opts.Cursor = page.NextCursor
+	}
+	for _, key := range slices.Sorted(maps.Keys(aliases)) {
+		if !active[aliases[key]] {
+			note(&r.Invalid, &r.InvalidCount, key, fmt.Sprintf("alias of missing link %q", aliases[key]))
+		}
+	}
+	for hash, keys := range byTarget {
+		if len(keys) > 1 {
+			slices.Sort(keys)
+			r.Duplicates = append(r.Duplicates, Duplicate{Target: targets[hash], Keys: keys})
+		}
+	}
+	r.DuplicateCount = len(r.Duplicates)
+	slices.SortFunc(r.Duplicates, func(a, b Duplicate) int {
+		return cmp.Or(cmp.Compare(len(b.Keys), len(a.Keys)), cmp.Compare(a.Target, b.Target))
+	})
+	if len(r.Duplicates) > cfg.MaxFindings {
+		r.Duplicates = r.Duplicates[:cfg.MaxFindings]
+	}
+	return r, nil
+}
+
+// count adds an entry to the statistics.
+func (s *Stats) count(key string, e urlstore.URLEntry, largest int) {
+	s.Entries++
+	size := 0
+	if b, err := json.Marshal(e); err == nil {
+		size = len(b)
+	}
+	s.Bytes += size
+	s.BySchemaVersion[strconv.Itoa(e.SchemaVersion)]++
+	if e.TargetIndex != nil {
+		s.Encrypted++
+	}
+	if !e.ExpiresAt.IsZero() {
+		s.WithExpiry++
+	}
+	if e.BurnAfterReading {
+		s.BurnAfterRead++
+	}
+	if e.Preview != nil {
+		s.WithPreview++
+	}
+	if urlstore.UrlKey(key).IsWildcard() {
+		s.Wildcards++
+	}
+	if e.AliasOf != "" {
+		s.Aliases++
+	}
+	if c := e.CreationTimestamp; !c.IsZero() {
+		if s.Oldest.IsZero() || c.Before(s.Oldest) {
+			s.Oldest = c
+		}
+		if c.After(s.Newest) {
+			s.Newest = c
+		}
+	}
+	i, _ := slices.BinarySearchFunc(s.Largest, size, func(x Sized, size int) int { return cmp.Compare(size, x.Bytes) })
+	if i < largest {
+		s.Largest = slices.Insert(s.Largest, i, Sized{Key: key, Bytes: size})
+		if len(s.Largest) > largest {
+			s.Largest = s.Largest[:largest]
+		}
+	}
+}
+
+// invalidTarget tells what makes target unfit to redirect to, or returns
+// "" if nothing does.
+func invalidTarget(target string) string {
+	if strings.TrimSpace(target) != target || strings.ContainsFunc(target, func(r rune) bool { return r < ' ' || r == 0x7f }) {
+		return "target has spaces or control characters"
+	}
+	u, err := url.Parse(target)
+	switch {
+	case err != nil:
+		return "target does not parse: " + err.Error()
+	case u.Scheme != "http" && u.Scheme != "https":
+		return fmt.Sprintf("target scheme %q is not http or https", u.Scheme)
+	case u.Hostname() == "":
+		return "target has no host"
+	}
+	return ""
+}
+
+// describeTarget names the target of e in the report: the normalized
+// target, or the host of an encrypted one.
+func describeTarget(e urlstore.URLEntry) string {
+	if e.TargetIndex != nil {
+		return e.TargetIndex.Host + " (encrypted)"
+	}
+	return urlstore.NormalizeTarget(e.URLTarget)
+}
+
+// WriteJSON writes the report as indented JSON.
+func (r Report) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+// WriteCSV writes the report as CSV rows of kind, key and detail: a stat
+// row per count, named in the key column, then the expired, deleted,
+// invalid_target and duplicate_target rows, the latter one per key with
+// the shared target as detail.
+func (r Report) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"kind", "key", "detail"})
+	stat := func(name string, v int) { cw.Write([]string{"stat", name, strconv.Itoa(v)}) }
+	stat("entries", r.Stats.Entries)
+	stat("active", r.Stats.Active)
+	stat("bytes", r.Stats.Bytes)
+	stat("expired", r.ExpiredCount)
+	stat("deleted", r.DeletedCount)
+	stat("invalid_targets", r.InvalidCount)
+	stat("duplicate_targets", r.DuplicateCount)
+	for _, f := range r.Expired {
+		cw.Write([]string{"expired", f.Key, f.Reason})
+	}
+	for _, f := range r.Deleted {
+		cw.Write([]string{"deleted", f.Key, f.Reason})
+	}
+	for _, f := range r.Invalid {
+		cw.Write([]string{"invalid_target", f.Key, f.Reason})
+	}
+	for _, d := range r.Duplicates {
+		for _, k := range d.Keys {
+			cw.Write([]string{"duplicate_target", k, d.Target})
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
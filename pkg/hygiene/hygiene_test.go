@@ -0,0 +1,168 @@
+package hygiene
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/FlorinBalint/shortener/pkg/urlstore"
+)
+
+func seed(t *testing.T, now time.Time) urlstore.Client {
+	t.Helper()
+	store := urlstore.NewMemoryClient()
+	ctx := context.Background()
+	entries := map[urlstore.UrlKey]urlstore.URLEntry{
+		"a":       {URLTarget: "https://example.com/page"},
+		"b":       {URLTarget: "https://Example.com/page"},
+		"c":       {URLTarget: "https://example.com/page"},
+		"d":       {URLTarget: "https://other.example"},
+		"e":       {URLTarget: "https://other.example"},
+		"f":       {URLTarget: "https://unique.example"},
+		"old":     {URLTarget: "https://example.com/page", ExpiresAt: now.Add(-time.Hour)},
+		"gone":    {URLTarget: "https://example.com/page", DeletedAt: now.Add(-time.Hour)},
+		"mail":    {URLTarget: "mailto:someone@example.com"},
+		"nohost":  {URLTarget: "https:///path"},
+		"spaced":  {URLTarget: " https://example.com"},
+		"later":   {URLTarget: "https://later.example", ExpiresAt: now.Add(time.Hour)},
+		"docs/*":  {URLTarget: "https://docs.example"},
+		"oneshot": {URLTarget: "https://once.example", BurnAfterReading: true},
+	}
+	for k, e := range entries {
+		if err := store.CreateEntry(ctx, k, e); err != nil {
+			t.Fatalf("CreateEntry(%q): %v", k, err)
+		}
+	}
+	return store
+}
+
+func keys(fs []Finding) []string {
+	var ks []string
+	for _, f := range fs {
+		ks = append(ks, f.Key)
+	}
+	slices.Sort(ks)
+	return ks
+}
+
+func TestScan(t *testing.T) {
+	now := time.Now()
+	r, err := Scan(context.Background(), seed(t, now), Config{BatchSize: 3}, now)
+	if err != nil {
+		t.Fatalf("Scan: %v", err)
+	}
+
+	if r.Stats.Entries != 14 || r.Stats.Active != 12 {
+		t.Errorf("want 14 entries, 12 active, got %d, %d", r.Stats.Entries, r.Stats.Active)
+	}
+	if r.Stats.WithExpiry != 2 || r.Stats.BurnAfterRead != 1 || r.Stats.Wildcards != 1 {
+		t.Errorf("unexpected stats: %+v", r.Stats)
+	}
+	if r.Stats.Bytes <= 0 || len(r.Stats.Largest) != 14 {
+		t.Errorf("want sizes for all entries, got %d bytes, %d largest", r.Stats.Bytes, len(r.Stats.Largest))
+	}
+	if !slices.IsSortedFunc(r.Stats.Largest, func(a, b Sized) int { return b.Bytes - a.Bytes }) {
+		t.Errorf("largest entries not sorted by size: %+v", r.Stats.Largest)
+	}
+
+	if got := keys(r.Expired); r.ExpiredCount != 1 || !slices.Equal(got, []string{"old"}) {
+		t.Errorf("want expired [old], got %d %v", r.ExpiredCount, got)
+	}
+	if got := keys(r.Deleted); r.DeletedCount != 1 || !slices.Equal(got, []string{"gone"}) {
+		t.Errorf("want deleted [gone], got %d %v", r.DeletedCount, got)
+	}
+	if got := keys(r.Invalid); r.InvalidCount != 3 || !slices.Equal(got, []string{"mail", "nohost", "spaced"}) {
+		t.Errorf("want invalid [mail nohost spaced], got %d %v", r.InvalidCount, got)
+	}
+
+	want := []Duplicate{
+		{Target: urlstore.NormalizeTarget("https://example.com/page"), Keys: []string{"a", "b", "c"}},
+		{Target: urlstore.NormalizeTarget("https://other.example"), Keys: []string{"d", "e"}},
+	}
+	if r.DuplicateCount != 2 || len(r.Duplicates) != 2 {
+		t.Fatalf("want 2 duplicate targets, got %d %+v", r.DuplicateCount, r.Duplicates)
+	}
+	for i, d := range want {
+		if r.Duplicates[i].Target != d.Target || !slices.Equal(r.Duplicates[i].Keys, d.Keys) {
+			t.Errorf("duplicate %d: want %+v, got %+v", i, d, r.Duplicates[i])
+		}
+	}
+}
+
+func TestScanAliases(t *testing.T) {
+	now := time.Now()
+	store := urlstore.NewMemoryClient()
+	for k, e := range map[urlstore.UrlKey]urlstore.URLEntry{
+		"home":    {URLTarget: "https://example.com"},
+		"h":       {AliasOf: "home"},
+		"hh":      {AliasOf: "h"},
+		"orphan":  {AliasOf: "nowhere"},
+		"stale":   {AliasOf: "old"},
+		"old":     {URLTarget: "https://example.com", ExpiresAt: now.Add(-time.Hour)},
+		"welcome": {URLTarget: "https://example.com"},
+	} {
+		if err := store.CreateEntry(context.Background(), k, e); err != nil {
+			t.Fatalf("CreateEntry(%q): %v", k, err)
+		}
+	}
+	r, err := Scan(context.Background(), store, Config{}, now)
+	if err != nil {
+		t.Fatalf("Scan: %v", err)
+	}
+	if r.Stats.Aliases != 4 {
+		t.Errorf("want 4 aliases, got %d", r.Stats.Aliases)
+	}
+	if got := keys(r.Invalid); r.InvalidCount != 2 || !slices.Equal(got, []string{"orphan", "stale"}) {
+		t.Errorf("want invalid [orphan stale], got %d %v", r.InvalidCount, got)
+	}
+	// Aliases share no target with their link.
+	if r.DuplicateCount != 1 || !slices.Equal(r.Duplicates[0].Keys, []string{"home", "welcome"}) {
+		t.Errorf("want [home welcome] duplicated, got %d %+v", r.DuplicateCount, r.Duplicates)
+	}
+}
+
+func TestScanCapsFindings(t *testing.T) {
+	now := time.Now()
+	r, err := Scan(context.Background(), seed(t, now), Config{MaxFindings: 1, Largest: 2}, now)
+	if err != nil {
+		t.Fatalf("Scan: %v", err)
+	}
+	if r.InvalidCount != 3 || len(r.Invalid) != 1 {
+		t.Errorf("want 3 invalid counted, 1 listed, got %d, %d", r.InvalidCount, len(r.Invalid))
+	}
+	if r.DuplicateCount != 2 || len(r.Duplicates) != 1 || len(r.Duplicates[0].Keys) != 3 {
+		t.Errorf("want the most shared of 2 duplicate targets, got %d %+v", r.DuplicateCount, r.Duplicates)
+	}
+	if len(r.Stats.Largest) != 2 {
+		t.Errorf("want 2 largest entries, got %d", len(r.Stats.Largest))
+	}
+}
+
+func TestWriteCSV(t *testing.T) {
+	now := time.Now()
+	r, err := Scan(context.Background(), seed(t, now), Config{}, now)
+	if err != nil {
+		t.Fatalf("Scan: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := r.WriteCSV(&buf); err != nil {
+		t.Fatalf("WriteCSV: %v", err)
+	}
+	rows, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatalf("reading CSV: %v", err)
+	}
+	if !slices.Equal(rows[0], []string{"kind", "key", "detail"}) {
+		t.Errorf("unexpected header %v", rows[0])
+	}
+	kinds := make(map[string]int)
+	for _, row := range rows[1:] {
+		kinds[row[0]]++
+	}
+	if kinds["stat"] != 7 || kinds["expired"] != 1 || kinds["deleted"] != 1 || kinds["invalid_target"] != 3 || kinds["duplicate_target"] != 5 {
+		t.Errorf("unexpected rows by kind: %v", kinds)
+	}
+}
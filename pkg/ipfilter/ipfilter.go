@@ -0,0 +1,98 @@
+// Package ipfilter restricts requests by client IP with CIDR allow and deny
+// lists.
+package ipfilter
+
+import (
+	"fmt"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"github.com/FlorinBalint/shortener/pkg/clientip"
+)
+
+// ParseCIDRs parses a comma-separated list of CIDRs or bare addresses.
+func ParseCIDRs(list string) ([]netip.Prefix, error) {
+	var out []netip.Prefix
+	for _, s := range strings.Split(list, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		p, err := ParsePrefix(s)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, p)
+	}
+	return out, nil
+}
+
+// ParsePrefix parses a CIDR, or a bare address as a single-address prefix.
+func ParsePrefix(s string) (netip.Prefix, error) {
+	if strings.Contains(s, "/") {
+		p, err := netip.ParsePrefix(s)
+		if err != nil {
+			return netip.Prefix{}, fmt.Errorf("invalid CIDR %q", s)
+		}
+		return p.Masked(), nil
+	}
+	a, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("invalid address %q", s)
+	}
+	return netip.PrefixFrom(a, a.BitLen()), nil
+}
+
+// Contains reports whether any prefix contains addr.
+func Contains(prefixes []netip.Prefix, addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, p := range prefixes {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// List is a pair of allow and deny lists. Deny wins; an empty allow list
+// allows every address not denied.
+type List struct {
+	Allow []netip.Prefix
+	Deny  []netip.Prefix
+}
+
+// Empty reports whether the list restricts nothing.
+func (l List) Empty() bool {
+	return len(l.Allow) == 0 && len(l.Deny) == 0
+}
+
+// Permits reports whether addr may pass. Invalid addresses only pass an
+// empty list.
+func (l List) Permits(addr netip.Addr) bool {
+	if l.Empty() {
+		return true
+	}
+	if !addr.IsValid() || Contains(l.Deny, addr) {
+		return false
+	}
+	return len(l.Allow) == 0 || Contains(l.Allow, addr)
+}
+
+// Middleware rejects requests whose client IP the list does not permit
+// with 403. The client IP is the one resolved by clientip.Middleware, which
+// must run first when the service sits behind proxies.
+func Middleware(l List) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if l.Empty() {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !l.Permits(clientip.FromRequest(r)) {
+				http.Error(w, "forbidden", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,73 @@
+package ipfilter
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+
+	"github.com/FlorinBalint/shortener/pkg/clientip"
+)
+
+func mustCIDRs(t *testing.T, s string) []netip.Prefix {
+	t.Helper()
+	p, err := ParseCIDRs(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestParseCIDRs(t *testing.T) {
+	got := mustCIDRs(t, "10.1.2.3/8, 192.168.0.1 ,2001:db8::/32")
+	want := []string{"10.0.0.0/8", "192.168.0.1/32", "2001:db8::/32"}
+	if len(got) != len(want) {
+		t.Fatalf("want %v, got %v", want, got)
+	}
+	for i := range want {
+		if got[i].String() != want[i] {
+			t.Errorf("prefix %d: want %s, got %s", i, want[i], got[i])
+		}
+	}
+	if _, err := ParseCIDRs("10.0.0.0/33"); err == nil {
+		t.Fatal("want error for invalid CIDR")
+	}
+}
+
+func TestPermits(t *testing.T) {
+	l := List{Allow: mustCIDRs(t, "10.0.0.0/8"), Deny: mustCIDRs(t, "10.6.0.0/16")}
+	for addr, want := range map[string]bool{
+		"10.1.1.1":        true,
+		"10.6.1.1":        false,
+		"8.8.8.8":         false,
+		"::ffff:10.1.1.1": true,
+	} {
+		if got := l.Permits(netip.MustParseAddr(addr)); got != want {
+			t.Errorf("Permits(%s): want %v, got %v", addr, want, got)
+		}
+	}
+	if !(List{}).Permits(netip.Addr{}) {
+		t.Error("an empty list permits everything")
+	}
+	denyOnly := List{Deny: mustCIDRs(t, "192.0.2.0/24")}
+	if !denyOnly.Permits(netip.MustParseAddr("8.8.8.8")) || denyOnly.Permits(netip.MustParseAddr("192.0.2.7")) {
+		t.Error("deny-only list: want everything but the denied range")
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	h := clientip.Middleware(mustCIDRs(t, "35.191.0.0/16"))(
+		Middleware(List{Allow: mustCIDRs(t, "198.51.100.0/24")})(
+			http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+
+	for xff, want := range map[string]int{"198.51.100.7": http.StatusOK, "203.0.113.1": http.StatusForbidden} {
+		r := httptest.NewRequest(http.MethodPost, "/write/v1", nil)
+		r.RemoteAddr = "35.191.0.1:443"
+		r.Header.Set("X-Forwarded-For", xff)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, r)
+		if rec.Code != want {
+			t.Errorf("client %s: want %d, got %d", xff, want, rec.Code)
+		}
+	}
+}
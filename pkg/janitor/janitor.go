@@ -0,0 +1,115 @@
+// Package janitor purges entries that stopped being served: expired or
+// soft-deleted entries are kept for a retention window (so they can be
+// inspected or restored) and then removed from the store and its cache.
+package janitor
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/FlorinBalint/shortener/pkg/urlstore"
+)
+
+// Config configures a Janitor.
+//
+// Retention is how long an entry stays in the store after it expired or was
+// soft-deleted. BatchSize is the number of entries scanned per store page
+// (default 500). With DryRun set, eligible entries are only counted.
+type Config struct {
+	Retention time.Duration
+	BatchSize int
+	DryRun    bool
+}
+
+// Result summarizes a sweep.
+type Result struct {
+	Scanned int
+	Purged  int
+	Failed  int
+}
+
+// Janitor sweeps a store for entries past their retention window.
+type Janitor struct {
+	store urlstore.Client
+	cfg   Config
+	now   func() time.Time
+}
+
+// New returns a Janitor sweeping store. Pass a cache-aside store so purged
+// entries are also evicted from the cache.
+func New(store urlstore.Client, cfg Config) *Janitor {
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = 500
+	}
+	return &Janitor{store: store, cfg: cfg, now: time.Now}
+}
+
+// Purgeable reports whether entry ended more than retention before now.
+func Purgeable(entry urlstore.URLEntry, retention time.Duration, now time.Time) bool {
+	cutoff := now.Add(-retention)
+	if entry.Deleted() && !entry.DeletedAt.After(cutoff) {
+		return true
+	}
+	return !entry.ExpiresAt.IsZero() && !entry.ExpiresAt.After(cutoff)
+}
+
+// Sweep scans the whole store once and purges eligible entries.
+// Individual delete failures are logged and counted; a failing scan aborts
+// the sweep and returns the partial result.
+func (j *Janitor) Sweep(ctx context.Context) (Result, error) {
+	var res Result
+	opts := urlstore.ListOptions{Limit: j.cfg.BatchSize, IncludeInactive: true}
+	for {
+		page, err := j.store.ListEntries(ctx, opts)
+		if err != nil {
+			return res, err
+		}
+		now := j.now()
+		for _, ke := range page.Entries {
+			res.Scanned++
+			if !Purgeable(ke.Entry, j.cfg.Retention, now) {
+				continue
+			}
+			if j.cfg.DryRun {
+				res.Purged++
+				continue
+			}
+			if err := j.store.DeleteEntry(ctx, ke.Key); err != nil {
+				log.Printf("janitor: purging %q failed: %v", ke.Key, err)
+				res.Failed++
+				continue
+			}
+			res.Purged++
+		}
+		if page.NextCursor == "" {
+			return res, nil
+		}
+		opts.Cursor = page.NextCursor
+	}
+}
+
+// Run sweeps immediately and then every interval until ctx is done.
+func (j *Janitor) Run(ctx context.Context, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		j.sweepAndLog(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+func (j *Janitor) sweepAndLog(ctx context.Context) {
+	start := j.now()
+	res, err := j.Sweep(ctx)
+	if err != nil {
+		log.Printf("janitor: sweep aborted after %d entries: %v", res.Scanned, err)
+		return
+	}
+	log.Printf("janitor: scanned %d, purged %d, failed %d in %v (dry run: %v)",
+		res.Scanned, res.Purged, res.Failed, j.now().Sub(start), j.cfg.DryRun)
+}
@@ -0,0 +1,95 @@
+package janitor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/FlorinBalint/shortener/pkg/urlstore"
+)
+
+func TestPurgeable(t *testing.T) {
+	now := time.Now()
+	day := 24 * time.Hour
+	tests := []struct {
+		name  string
+		entry urlstore.URLEntry
+		want  bool
+	}{
+		{"no expiry", urlstore.URLEntry{}, false},
+		{"active", urlstore.URLEntry{ExpiresAt: now.Add(day)}, false},
+		{"expired within retention", urlstore.URLEntry{ExpiresAt: now.Add(-day)}, false},
+		{"expired past retention", urlstore.URLEntry{ExpiresAt: now.Add(-8 * day)}, true},
+		{"deleted within retention", urlstore.URLEntry{DeletedAt: now.Add(-day)}, false},
+		{"deleted past retention", urlstore.URLEntry{DeletedAt: now.Add(-8 * day)}, true},
+	}
+	for _, tt := range tests {
+		if got := Purgeable(tt.entry, 7*day, now); got != tt.want {
+			t.Errorf("%s: want %v, got %v", tt.name, tt.want, got)
+		}
+	}
+}
+
+func seed(t *testing.T, store urlstore.Client) {
+	t.Helper()
+	now := time.Now()
+	ctx := context.Background()
+	for i := 0; i < 5; i++ {
+		store.CreateEntry(ctx, urlstore.UrlKey(fmt.Sprintf("live-%d", i)), urlstore.URLEntry{URLTarget: "https://a.example"})
+		store.CreateEntry(ctx, urlstore.UrlKey(fmt.Sprintf("old-%d", i)), urlstore.URLEntry{
+			URLTarget: "https://b.example",
+			ExpiresAt: now.Add(-48 * time.Hour),
+		})
+	}
+	store.CreateEntry(ctx, "recent", urlstore.URLEntry{URLTarget: "https://c.example", DeletedAt: now})
+}
+
+func TestSweep(t *testing.T) {
+	store := urlstore.NewMemoryClient()
+	seed(t, store)
+
+	res, err := New(store, Config{Retention: 24 * time.Hour, BatchSize: 3}).Sweep(context.Background())
+	if err != nil {
+		t.Fatalf("Sweep: %v", err)
+	}
+	if res.Scanned != 11 || res.Purged != 5 || res.Failed != 0 {
+		t.Fatalf("unexpected result: %+v", res)
+	}
+
+	page, _ := store.ListEntries(context.Background(), urlstore.ListOptions{IncludeInactive: true})
+	if len(page.Entries) != 6 {
+		t.Fatalf("want 6 entries left, got %d", len(page.Entries))
+	}
+	for _, ke := range page.Entries {
+		if ke.Key != "recent" && ke.Entry.URLTarget != "https://a.example" {
+			t.Fatalf("unexpected survivor %q", ke.Key)
+		}
+	}
+}
+
+func TestSweep_DryRunKeepsEntries(t *testing.T) {
+	store := urlstore.NewMemoryClient()
+	seed(t, store)
+
+	res, err := New(store, Config{Retention: 24 * time.Hour, DryRun: true}).Sweep(context.Background())
+	if err != nil {
+		t.Fatalf("Sweep: %v", err)
+	}
+	if res.Purged != 5 {
+		t.Fatalf("want 5 purgeable entries reported, got %d", res.Purged)
+	}
+	page, _ := store.ListEntries(context.Background(), urlstore.ListOptions{IncludeInactive: true})
+	if len(page.Entries) != 11 {
+		t.Fatalf("dry run deleted entries: %d left", len(page.Entries))
+	}
+}
+
+func TestSweep_AbortsOnScanError(t *testing.T) {
+	store := urlstore.WithFaults(urlstore.NewMemoryClient(), urlstore.FaultConfig{ErrorRate: 1})
+	_, err := New(store, Config{}).Sweep(context.Background())
+	if !errors.Is(err, urlstore.ErrInjectedFault) {
+		t.Fatalf("want the scan error, got %v", err)
+	}
+}
@@ -0,0 +1,50 @@
+// Package keycheck appends check characters to generated keys, so a
+// mistyped key, e.g. copied from print, can be told apart from one that
+// was never created.
+//
+// The check characters are the CRC-32 of the key in base62: one catches
+// all but 1 in 62 typos, two all but 1 in 3844.
+package keycheck
+
+import (
+	"hash/crc32"
+	"strings"
+
+	"github.com/FlorinBalint/shortener/pkg/kubeflake"
+)
+
+// MaxLength is the most check characters a key gets.
+const MaxLength = 2
+
+// Sum returns the n check characters of key.
+func Sum(key string, n int) string {
+	mod := uint64(1)
+	for range n {
+		mod *= 62
+	}
+	s := kubeflake.Base62Converter{}.Encode(uint64(crc32.ChecksumIEEE([]byte(key))) % mod)
+	return strings.Repeat("0", n-len(s)) + s
+}
+
+// Append returns key followed by its n check characters.
+func Append(key string, n int) string {
+	return key + Sum(key, n)
+}
+
+// Valid reports whether key ends in the n check characters of the rest.
+func Valid(key string, n int) bool {
+	if len(key) <= n {
+		return false
+	}
+	return Sum(key[:len(key)-n], n) == key[len(key)-n:]
+}
+
+// Plausible reports whether key could be a generated key with n check
+// characters: base62 and longer than them. Custom aliases often are not.
+func Plausible(key string, n int) bool {
+	if len(key) <= n {
+		return false
+	}
+	_, err := kubeflake.Base62Converter{}.Decode(key)
+	return err == nil
+}
@@ -0,0 +1,45 @@
+package keycheck
+
+import "testing"
+
+func TestAppendValid(t *testing.T) {
+	for n := 1; n <= MaxLength; n++ {
+		key := Append("1gXyZ42kQ", n)
+		if len(key) != 9+n {
+			t.Fatalf("n=%d: want %d characters, got %q", n, 9+n, key)
+		}
+		if !Valid(key, n) {
+			t.Fatalf("n=%d: %q not valid", n, key)
+		}
+		if Valid(key[:len(key)-1], n) {
+			t.Fatalf("n=%d: truncated %q valid", n, key[:len(key)-1])
+		}
+	}
+}
+
+func TestValid_CatchesTypos(t *testing.T) {
+	key := Append("1gXyZ42kQ", 2)
+	typos := []string{
+		"2" + key[1:],                           // substitution
+		key[:1] + key[2:3] + key[1:2] + key[3:], // transposition
+		"1gxyZ42kQ" + key[9:],                   // case
+	}
+	for _, typo := range typos {
+		if Valid(typo, 2) {
+			t.Errorf("typo %q of %q passes", typo, key)
+		}
+	}
+}
+
+func TestPlausible(t *testing.T) {
+	for key, want := range map[string]bool{
+		"1gXyZ42kQab": true,
+		"a":           false,
+		"spring-sale": false,
+		"team/docs":   false,
+	} {
+		if got := Plausible(key, 2); got != want {
+			t.Errorf("Plausible(%q) = %v, want %v", key, got, want)
+		}
+	}
+}
@@ -0,0 +1,228 @@
+// Package keylayout pins the layout of generated IDs: the bit lengths,
+// time unit and epoch keygen runs with. The first keygen to boot records
+// its layout; later ones must run with the same, since a different layout
+// changes what existing IDs mean and lets new IDs collide with them.
+package keylayout
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/datastore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/FlorinBalint/shortener/pkg/gcputil"
+	"github.com/FlorinBalint/shortener/pkg/kubeflake"
+)
+
+// Errors returned by stores and Check.
+var (
+	ErrNotFound      = errors.New("no layout recorded")
+	ErrAlreadyExists = errors.New("layout already recorded")
+	ErrMismatch      = errors.New("layout differs from the recorded one")
+)
+
+// Layout is what every keygen must agree on; cluster and machine IDs
+// differ per pod.
+type Layout struct {
+	Version      int           `json:"version"`
+	BitsTime     int           `json:"bits_time"`
+	BitsSequence int           `json:"bits_sequence"`
+	BitsCluster  int           `json:"bits_cluster"`
+	BitsMachine  int           `json:"bits_machine"`
+	TimeUnit     time.Duration `json:"time_unit"`
+	Epoch        time.Time     `json:"epoch"`
+}
+
+// Of returns the shared part of l.
+func Of(l kubeflake.Layout) Layout {
+	return Layout{
+		Version:      l.Version,
+		BitsTime:     l.BitsTime,
+		BitsSequence: l.BitsSequence,
+		BitsCluster:  l.BitsCluster,
+		BitsMachine:  l.BitsMachine,
+		TimeUnit:     l.TimeUnit,
+		Epoch:        l.Epoch.UTC(),
+	}
+}
+
+// diff describes how l differs from recorded, empty when it does not.
+func (l Layout) diff(recorded Layout) string {
+	var d []string
+	field := func(name string, got, want any) {
+		if got != want {
+			d = append(d, fmt.Sprintf("%s %v (recorded %v)", name, got, want))
+		}
+	}
+	field("layout.version", l.Version, recorded.Version)
+	field("bits.time", l.BitsTime, recorded.BitsTime)
+	field("bits.sequence", l.BitsSequence, recorded.BitsSequence)
+	field("bits.cluster", l.BitsCluster, recorded.BitsCluster)
+	field("bits.machine", l.BitsMachine, recorded.BitsMachine)
+	field("time unit", l.TimeUnit, recorded.TimeUnit)
+	if !l.Epoch.Equal(recorded.Epoch) {
+		d = append(d, fmt.Sprintf("epoch %v (recorded %v)", l.Epoch, recorded.Epoch))
+	}
+	return strings.Join(d, ", ")
+}
+
+// Record is a recorded layout.
+type Record struct {
+	Layout
+	RecordedAt time.Time `json:"recorded_at"`
+}
+
+// Store keeps the recorded layout.
+type Store interface {
+	// Get returns the recorded layout, or ErrNotFound.
+	Get(ctx context.Context) (Record, error)
+	// Create records a layout, or fails with ErrAlreadyExists.
+	Create(ctx context.Context, r Record) error
+	// Put records a layout, replacing any other.
+	Put(ctx context.Context, r Record) error
+}
+
+// Check records l if no layout is, and otherwise fails with ErrMismatch
+// when l differs from the recorded layout, unless force is set: then l
+// replaces it.
+func Check(ctx context.Context, s Store, l Layout, force bool) error {
+	rec, err := s.Get(ctx)
+	if errors.Is(err, ErrNotFound) {
+		err = s.Create(ctx, Record{Layout: l, RecordedAt: time.Now().UTC()})
+		if err == nil {
+			log.Printf("key layout: recorded %+v", l)
+			return nil
+		}
+		if !errors.Is(err, ErrAlreadyExists) {
+			return err
+		}
+		// Another keygen recorded its layout first.
+		rec, err = s.Get(ctx)
+	}
+	if err != nil {
+		return err
+	}
+	diff := l.diff(rec.Layout)
+	if diff == "" {
+		return nil
+	}
+	if !force {
+		return fmt.Errorf("%w: %s", ErrMismatch, diff)
+	}
+	log.Printf("key layout: forced to a different layout: %s", diff)
+	return s.Put(ctx, Record{Layout: l, RecordedAt: time.Now().UTC()})
+}
+
+// Compare fails with ErrMismatch when l differs from the recorded layout,
+// without recording anything; no layout recorded yet is no mismatch.
+func Compare(ctx context.Context, s Store, l Layout) error {
+	rec, err := s.Get(ctx)
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if diff := l.diff(rec.Layout); diff != "" {
+		return fmt.Errorf("%w: %s", ErrMismatch, diff)
+	}
+	return nil
+}
+
+// DSStore keeps the layout in Datastore, as entity "ids" of kind
+// "key_layout", or "namespace/<name>" for the generators of a key
+// namespace (see keyspace).
+type DSStore struct {
+	client *gcputil.DSClient
+	name   string
+}
+
+var _ Store = (*DSStore)(nil)
+
+func NewDSStore(client *gcputil.DSClient) *DSStore {
+	return &DSStore{client: client, name: name}
+}
+
+// NewNamespaceDSStore returns the store of the layout of namespace.
+func NewNamespaceDSStore(client *gcputil.DSClient, namespace string) *DSStore {
+	return &DSStore{client: client, name: "namespace/" + namespace}
+}
+
+const (
+	kind = "key_layout"
+	name = "ids"
+)
+
+// Get implements Store.
+func (s *DSStore) Get(ctx context.Context) (Record, error) {
+	r, err := gcputil.GetValue[Record](s.client, ctx, kind, s.name)
+	if errors.Is(err, datastore.ErrNoSuchEntity) {
+		return Record{}, ErrNotFound
+	}
+	return r, err
+}
+
+// Create implements Store.
+func (s *DSStore) Create(ctx context.Context, r Record) error {
+	err := gcputil.PutNewValue(s.client, ctx, kind, s.name, r)
+	if status.Code(err) == codes.AlreadyExists {
+		return ErrAlreadyExists
+	}
+	return err
+}
+
+// Put implements Store.
+func (s *DSStore) Put(ctx context.Context, r Record) error {
+	return gcputil.UpsertValue(s.client, ctx, kind, s.name, func(cur *Record) error {
+		*cur = r
+		return nil
+	})
+}
+
+// MemoryStore is an in-process Store, for tests.
+type MemoryStore struct {
+	mu  sync.Mutex
+	rec *Record
+}
+
+var _ Store = (*MemoryStore)(nil)
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{}
+}
+
+// Get implements Store.
+func (s *MemoryStore) Get(ctx context.Context) (Record, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.rec == nil {
+		return Record{}, ErrNotFound
+	}
+	return *s.rec, nil
+}
+
+// Create implements Store.
+func (s *MemoryStore) Create(ctx context.Context, r Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.rec != nil {
+		return ErrAlreadyExists
+	}
+	s.rec = &r
+	return nil
+}
+
+// Put implements Store.
+func (s *MemoryStore) Put(ctx context.Context, r Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rec = &r
+	return nil
+}
@@ -0,0 +1,63 @@
+package keylayout
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCheck(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryStore()
+	epoch := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	l := Layout{BitsTime: 39, BitsSequence: 11, BitsCluster: 7, BitsMachine: 6, TimeUnit: 10 * time.Millisecond, Epoch: epoch}
+
+	if err := Check(ctx, s, l, false); err != nil {
+		t.Fatalf("first boot: %v", err)
+	}
+	if err := Check(ctx, s, l, false); err != nil {
+		t.Fatalf("same layout: %v", err)
+	}
+
+	changed := l
+	changed.BitsCluster, changed.BitsTime = 8, 38
+	err := Check(ctx, s, changed, false)
+	if !errors.Is(err, ErrMismatch) {
+		t.Fatalf("changed layout: want ErrMismatch, got %v", err)
+	}
+	if rec, _ := s.Get(ctx); rec.Layout != l {
+		t.Fatalf("want the recorded layout kept, got %+v", rec.Layout)
+	}
+
+	if err := Check(ctx, s, changed, true); err != nil {
+		t.Fatalf("forced: %v", err)
+	}
+	if err := Check(ctx, s, l, false); !errors.Is(err, ErrMismatch) {
+		t.Fatalf("after forcing: want the old layout refused, got %v", err)
+	}
+}
+
+func TestCompare(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryStore()
+	l := Layout{BitsTime: 39, BitsSequence: 11, BitsCluster: 7, BitsMachine: 6, TimeUnit: 10 * time.Millisecond}
+
+	if err := Compare(ctx, s, l); err != nil {
+		t.Fatalf("nothing recorded: %v", err)
+	}
+	if _, err := s.Get(ctx); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("want nothing recorded by Compare, got %v", err)
+	}
+	if err := Check(ctx, s, l, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := Compare(ctx, s, l); err != nil {
+		t.Fatalf("same layout: %v", err)
+	}
+	changed := l
+	changed.BitsMachine = 5
+	if err := Compare(ctx, s, changed); !errors.Is(err, ErrMismatch) {
+		t.Fatalf("changed layout: want ErrMismatch, got %v", err)
+	}
+}
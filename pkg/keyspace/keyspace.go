@@ -0,0 +1,111 @@
+// Package keyspace configures the named generators keygen serves besides
+// the link keys of its flags, for other teams minting IDs of their own:
+// each namespace has its own epoch and bit layout, and its own sequence,
+// so its keys never take from the link keys' space. The file looks like:
+//
+//	{"namespaces": [
+//	  {"name": "uploads", "epoch": "2025-06-01T00:00:00Z"},
+//	  {"name": "invoices", "epoch": "2026-01-01T00:00:00Z",
+//	   "bits_sequence": 8, "layout_version": 1}
+//	]}
+//
+// Bit lengths left out are the keygen's own. Keys are unique within a
+// namespace only: two namespaces may mint the same key.
+package keyspace
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"time"
+
+	"github.com/FlorinBalint/shortener/pkg/kubeflake"
+)
+
+// Links names the namespace of link keys, configured by keygen's flags
+// and served on /generate/v1 too.
+const Links = "links"
+
+var validName = regexp.MustCompile(`^[a-z][a-z0-9-]{0,31}$`)
+
+// Namespace is a named generator.
+type Namespace struct {
+	Name  string    `json:"name"`
+	Epoch time.Time `json:"epoch"`
+
+	// BitsSequence, BitsCluster and BitsMachine override the keygen's.
+	BitsSequence int `json:"bits_sequence,omitempty"`
+	BitsCluster  int `json:"bits_cluster,omitempty"`
+	BitsMachine  int `json:"bits_machine,omitempty"`
+	// LayoutVersion is stamped on the keys; see kubeflake.Settings.Version.
+	LayoutVersion int `json:"layout_version,omitempty"`
+}
+
+func (n *Namespace) validate() error {
+	if !validName.MatchString(n.Name) {
+		return fmt.Errorf("name %q: want lowercase letters, digits and dashes, at most 32", n.Name)
+	}
+	if n.Name == Links {
+		return errors.New("links is configured by keygen's flags")
+	}
+	if n.Epoch.IsZero() {
+		return errors.New("epoch is required")
+	}
+	if n.BitsSequence < 0 || n.BitsCluster < 0 || n.BitsMachine < 0 || n.LayoutVersion < 0 {
+		return errors.New("bit lengths and layout version must not be negative")
+	}
+	return nil
+}
+
+// Settings returns the settings of the namespace's generator: base, the
+// keygen's, with the namespace's epoch, version and the bit lengths it
+// sets.
+func (n Namespace) Settings(base kubeflake.Settings) kubeflake.Settings {
+	s := base
+	s.EpochTime = n.Epoch
+	s.Version = n.LayoutVersion
+	s.PastLayouts = nil
+	if n.BitsSequence != 0 {
+		s.BitsSequence = n.BitsSequence
+	}
+	if n.BitsCluster != 0 {
+		s.BitsCluster = n.BitsCluster
+	}
+	if n.BitsMachine != 0 {
+		s.BitsMachine = n.BitsMachine
+	}
+	return s
+}
+
+// Parse parses and validates a namespaces file.
+func Parse(b []byte) ([]Namespace, error) {
+	var f struct {
+		Namespaces []Namespace `json:"namespaces"`
+	}
+	if err := json.Unmarshal(b, &f); err != nil {
+		return nil, fmt.Errorf("key namespaces: %w", err)
+	}
+	seen := make(map[string]bool, len(f.Namespaces))
+	for i := range f.Namespaces {
+		n := &f.Namespaces[i]
+		if err := n.validate(); err != nil {
+			return nil, fmt.Errorf("key namespace %d: %w", i, err)
+		}
+		if seen[n.Name] {
+			return nil, fmt.Errorf("key namespace %d: %s is configured twice", i, n.Name)
+		}
+		seen[n.Name] = true
+	}
+	return f.Namespaces, nil
+}
+
+// Load reads and parses the namespaces file at path.
+func Load(path string) ([]Namespace, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("key namespaces: %w", err)
+	}
+	return Parse(b)
+}
@@ -0,0 +1,74 @@
+package keyspace
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/FlorinBalint/shortener/pkg/kubeflake"
+)
+
+func TestParse(t *testing.T) {
+	ns, err := Parse([]byte(`{"namespaces": [
+		{"name": "uploads", "epoch": "2025-06-01T00:00:00Z"},
+		{"name": "invoices", "epoch": "2026-01-01T00:00:00Z", "bits_sequence": 8, "layout_version": 1}
+	]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ns) != 2 || ns[0].Name != "uploads" || ns[1].BitsSequence != 8 {
+		t.Fatalf("got %+v", ns)
+	}
+
+	for name, file := range map[string]string{
+		"bad name":  `{"namespaces": [{"name": "Uploads", "epoch": "2025-06-01T00:00:00Z"}]}`,
+		"links":     `{"namespaces": [{"name": "links", "epoch": "2025-06-01T00:00:00Z"}]}`,
+		"no epoch":  `{"namespaces": [{"name": "uploads"}]}`,
+		"negative":  `{"namespaces": [{"name": "uploads", "epoch": "2025-06-01T00:00:00Z", "bits_machine": -1}]}`,
+		"twice":     `{"namespaces": [{"name": "a", "epoch": "2025-06-01T00:00:00Z"}, {"name": "a", "epoch": "2025-07-01T00:00:00Z"}]}`,
+		"not json":  `namespaces: []`,
+		"bad epoch": `{"namespaces": [{"name": "uploads", "epoch": "June"}]}`,
+	} {
+		if _, err := Parse([]byte(file)); err == nil {
+			t.Errorf("%s: want an error", name)
+		}
+	}
+}
+
+func TestNamespace_Settings(t *testing.T) {
+	epoch := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	base := kubeflake.Settings{
+		BitsSequence: 11, BitsCluster: 7, BitsMachine: 6,
+		EpochTime:   time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC),
+		Version:     2,
+		PastLayouts: map[int]kubeflake.Layout{1: {}},
+		ClusterId:   kubeflake.FixedID(3).ID,
+		MachineId:   kubeflake.FixedID(5).ID,
+	}
+	s := Namespace{Name: "invoices", Epoch: epoch, BitsSequence: 8}.Settings(base)
+	if s.BitsSequence != 8 || s.BitsCluster != 7 || s.BitsMachine != 6 || !s.EpochTime.Equal(epoch) || s.Version != 0 || s.PastLayouts != nil {
+		t.Fatalf("got %+v", s)
+	}
+	kf, err := kubeflake.New(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l := kf.Layout(); l.ClusterID != 3 || l.MachineID != 5 {
+		t.Errorf("want the keygen's IDs, got %+v", l)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "namespaces.json")
+	if err := os.WriteFile(path, []byte(`{"namespaces": [{"name": "uploads", "epoch": "2025-06-01T00:00:00Z"}]}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if ns, err := Load(path); err != nil || len(ns) != 1 {
+		t.Fatalf("Load = %+v, %v", ns, err)
+	}
+	if _, err := Load(path + ".missing"); err == nil || !strings.Contains(err.Error(), "key namespaces") {
+		t.Errorf("missing file: got %v", err)
+	}
+}
@@ -1,11 +1,15 @@
 package kubeflake
 
-import "bytes"
+import (
+	"bytes"
+	"math"
+)
 
 const base62Chars = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
 
 var base62Bytes = []byte(base62Chars)
 
+// BaseConverter encodes IDs as keys and decodes them back.
 type BaseConverter interface {
 	Encode(n uint64) string
 	Decode(s string) (uint64, error)
@@ -13,9 +17,11 @@ type BaseConverter interface {
 
 var _ BaseConverter = (*Base62Converter)(nil)
 
+// Base62Converter encodes IDs with the digits, upper and lower case
+// letters, in that order.
 type Base62Converter struct{}
 
-// EncodeBase62 converts an uint64 to a base62-encoded string.
+// Encode converts an uint64 to a base62-encoded string.
 func (Base62Converter) Encode(n uint64) string {
 	if n == 0 {
 		return "0"
@@ -29,7 +35,8 @@ func (Base62Converter) Encode(n uint64) string {
 	return string(result)
 }
 
-// DecodeBase62 converts a base62-encoded string to an uint64
+// Decode converts a base62-encoded string to an uint64. Strings of more
+// than 64 bits fail with ErrInvalidBase rather than wrap around.
 func (Base62Converter) Decode(s string) (uint64, error) {
 	var result uint64
 	for i := 0; i < len(s); i++ {
@@ -38,6 +45,9 @@ func (Base62Converter) Decode(s string) (uint64, error) {
 		if index == -1 {
 			return 0, ErrInvalidBase
 		}
+		if result > (math.MaxUint64-uint64(index))/62 {
+			return 0, ErrInvalidBase
+		}
 		result = result*62 + uint64(index)
 	}
 	return result, nil
@@ -0,0 +1,53 @@
+package kubeflake
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func FuzzBase62Decode(f *testing.F) {
+	for _, s := range []string{"", "0", "00A", "zzzzzzzzzzz", "LygHa16AHYF", "LygHa16AHYG", "1000000000000", "a-b", "\xff"} {
+		f.Add(s)
+	}
+	enc := Base62Converter{}
+	f.Fuzz(func(t *testing.T, s string) {
+		n, err := enc.Decode(s)
+		if err != nil {
+			return
+		}
+		// Leading zeros aside, a decoded string is the encoding of its value.
+		want := strings.TrimLeft(s, "0")
+		if want == "" {
+			want = "0"
+		}
+		if got := enc.Encode(n); got != want {
+			t.Fatalf("Decode(%q) = %d, which encodes as %q", s, n, got)
+		}
+	})
+}
+
+func FuzzBase62RoundTrip(f *testing.F) {
+	for _, n := range []uint64{0, 1, 61, 62, math.MaxUint64} {
+		f.Add(n)
+	}
+	enc := Base62Converter{}
+	f.Fuzz(func(t *testing.T, n uint64) {
+		if got, err := enc.Decode(enc.Encode(n)); err != nil || got != n {
+			t.Fatalf("Decode(Encode(%d)) = %d, %v", n, got, err)
+		}
+	})
+}
+
+func TestBase62_DecodeOverflow(t *testing.T) {
+	enc := Base62Converter{}
+	max := enc.Encode(math.MaxUint64)
+	if n, err := enc.Decode(max); err != nil || n != math.MaxUint64 {
+		t.Fatalf("Decode(%q) = %d, %v; want MaxUint64", max, n, err)
+	}
+	for _, s := range []string{"LygHa16AHYG", "zzzzzzzzzzz", "1" + strings.Repeat("0", 11)} {
+		if _, err := enc.Decode(s); err != ErrInvalidBase {
+			t.Errorf("Decode(%q): want ErrInvalidBase, got %v", s, err)
+		}
+	}
+}
@@ -0,0 +1,3 @@
+module github.com/FlorinBalint/shortener/pkg/kubeflake
+
+go 1.25
@@ -1,10 +1,23 @@
+// Package kubeflake generates unique, roughly time-ordered 64-bit IDs,
+// Snowflake style, from a timestamp, a sequence number and the cluster and
+// machine IDs of the generator, and encodes them as short keys.
+//
+// It depends on the standard library only, and is its own module so that
+// it can be imported without the rest of the shortener:
+//
+//	go get github.com/FlorinBalint/shortener/pkg/kubeflake@latest
+//
+// Releases are tagged pkg/kubeflake/vX.Y.Z and follow semantic
+// versioning: the exported API only changes incompatibly with a new major
+// version.
 package kubeflake
 
 import (
+	"errors"
+	"math"
+	"strings"
 	"sync"
 	"time"
-
-	"errors"
 )
 
 const minTimeBits = 32
@@ -15,6 +28,7 @@ const maxClusterBits = 8
 const maxMachineBits = 16
 const minMachineBits = 3
 
+// IdParts names the parts of an ID.
 type IdParts string
 
 const (
@@ -27,6 +41,7 @@ const (
 	Sequence  IdParts = "sequence"
 	MachineID IdParts = "machine_id"
 	ClusterID IdParts = "cluster_id"
+	Version   IdParts = "layout_version"
 )
 
 var (
@@ -42,21 +57,26 @@ var (
 	ErrStartTimeAhead       = errors.New("start time is ahead")
 	ErrOverTimeLimit        = errors.New("over the time limit")
 	ErrInvalidBase          = errors.New("invalid base")
+	ErrInvalidVersion       = errors.New("invalid layout version")
+	ErrUnknownLayout        = errors.New("key minted under an unknown layout version")
+	ErrNoClusterID          = errors.New("no cluster id provider")
+	ErrNoMachineID          = errors.New("no machine id provider")
 )
 
 // Settings configures Kubeflake:
 //
 // BitsSequence is the bit length of a sequence number.
-// If BitsSequence is 0, the default bit length is used, which is 8.
-// If BitsSequence is 31 or more, an error is returned.
+// If BitsSequence is 0, the default bit length is used, which is 9.
+// If BitsSequence is less than 8 or more than 30, an error is returned.
 //
 // BitsMachine is the bit length of a machine ID.
-// If BitsMachine is 0, the default bit length is used, which is 16.
-// If BitsMachine is 17 or more, an error is returned.
+// If BitsMachine is 0, the default bit length is used, which is 13.
+// If BitsMachine is less than 3 or more than 16, an error is returned.
 //
 // BitsCluster is the bit length of a cluster ID.
-// If BitsCluster is 0, the default bit length is used, which is 8.
-// If BitsCluster is 9 or more (more than 256 clusters), an error is returned.
+// If BitsCluster is 0, the default bit length is used, which is 3.
+// If BitsCluster is less than 2 or more than 8 (more than 256 clusters),
+// an error is returned.
 //
 // TimeUnit is the time unit of Kubeflake.
 // If TimeUnit is 0, the default time unit is used, which is 10 msec.
@@ -69,8 +89,21 @@ var (
 // If StartTime is 0, the start time of the Kubeflake instance is set to "2025-01-01 00:00:00 +0000 UTC".
 // StartTime must be before the current time.
 //
-// MachineID returns the unique ID of a Kubeflake instance.
-// If MachineID returns an error, the instance will not be created.
+// Version is the layout version stamped on the keys. Keys of version 0
+// are unstamped, as before versions existed. A stamped key is the version
+// followed by the ID padded with zero digits to the length of the longest
+// unstamped key, so the two never collide: bump Version whenever the bit
+// lengths, time unit or epoch change.
+//
+// PastLayouts are the layouts keys were minted under before, by version
+// (0 for unstamped keys), so DecomposeKey can still parse them.
+//
+// ClusterId and MachineId return the IDs of a Kubeflake instance, unique
+// among the instances minting keys of the same keyspace; both are required
+// and must fit their bit lengths. If either returns an error, the instance
+// will not be created.
+//
+// Clock tells the time; nil uses the system clock.
 //
 // The bit length of time is calculated by 63 - BitsCluster - BitsMachine - BitsSequence.
 // If it is less than 32, an error is returned.
@@ -79,13 +112,17 @@ type Settings struct {
 	BitsCluster  int
 	BitsMachine  int
 
-	TimeUnit  time.Duration
-	Base      BaseConverter
-	EpochTime time.Time
-	ClusterId func() (int, error)
-	MachineId func() (int, error)
+	TimeUnit    time.Duration
+	Base        BaseConverter
+	EpochTime   time.Time
+	Version     int
+	PastLayouts map[int]Layout
+	ClusterId   func() (int, error)
+	MachineId   func() (int, error)
+	Clock       Clock
 }
 
+// Kubeflake generates IDs. It is safe for concurrent use.
 type Kubeflake struct {
 	mutex     *sync.Mutex
 	machineId int
@@ -104,38 +141,52 @@ type Kubeflake struct {
 	sequence uint64
 	base     BaseConverter
 	nowFunc  func() time.Time
+
+	version     int
+	pastLayouts map[int]Layout
 }
 
 // New returns a new Kubeflake configured with the given Settings.
 // New returns an error in the following cases:
-// - Settings.BitsSequence is less than 0 or greater than 30.
-// - Settings.BitsMachineID is less than 0 or greater than 30.
-// - Settings.BitsSequence + Settings.BitsMachineID is 32 or more.
+// - A bit length is out of its range, or leaves less than 32 bits of time.
 // - Settings.TimeUnit is less than 1 msec.
-// - Settings.StartTime is ahead of the current time.
-// - Settings.MachineID returns an error.
-// - Settings.ClusterId returns an error.
+// - Settings.EpochTime is ahead of the current time.
+// - Settings.ClusterId or Settings.MachineId is missing, returns an error
+// or returns an ID that does not fit its bit length.
 func New(settings Settings) (*Kubeflake, error) {
 	// Validate settings
-	if settings.BitsSequence < minSequenceBits || settings.BitsSequence > maxSequenceBits {
+	if settings.BitsSequence != 0 && (settings.BitsSequence < minSequenceBits || settings.BitsSequence > maxSequenceBits) {
 		return nil, ErrInvalidBitsSequence
 	}
-	if settings.BitsMachine < minMachineBits || settings.BitsMachine > maxMachineBits {
+	if settings.BitsMachine != 0 && (settings.BitsMachine < minMachineBits || settings.BitsMachine > maxMachineBits) {
 		return nil, ErrInvalidBitsMachineID
 	}
-	if settings.BitsCluster < minClusterBits || settings.BitsCluster > maxClusterBits {
+	if settings.BitsCluster != 0 && (settings.BitsCluster < minClusterBits || settings.BitsCluster > maxClusterBits) {
 		return nil, ErrInvalidBitsClusterID
 	}
 	if settings.TimeUnit < 0 || (settings.TimeUnit > 0 && settings.TimeUnit < time.Millisecond) {
 		return nil, ErrInvalidTimeUnit
 	}
-	if settings.EpochTime.After(time.Now()) {
+	clock := settings.Clock
+	if clock == nil {
+		clock = SystemClock{}
+	}
+	if settings.EpochTime.After(clock.Now()) {
 		return nil, ErrStartTimeAhead
 	}
+	if settings.Version < 0 {
+		return nil, ErrInvalidVersion
+	}
+	if settings.ClusterId == nil {
+		return nil, ErrNoClusterID
+	}
+	if settings.MachineId == nil {
+		return nil, ErrNoMachineID
+	}
 
 	k8sFlake := new(Kubeflake)
 	k8sFlake.mutex = new(sync.Mutex)
-	k8sFlake.nowFunc = time.Now
+	k8sFlake.nowFunc = clock.Now
 	if settings.BitsCluster == 0 {
 		k8sFlake.bitsCluster = defaultBitsCluster
 	} else {
@@ -174,12 +225,16 @@ func New(settings Settings) (*Kubeflake, error) {
 
 	if cluster, err := settings.ClusterId(); err != nil {
 		return nil, err
+	} else if cluster < 0 || cluster >= 1<<k8sFlake.bitsCluster {
+		return nil, ErrInvalidClusterID
 	} else {
 		k8sFlake.clusterId = cluster
 	}
 
 	if machine, err := settings.MachineId(); err != nil {
 		return nil, err
+	} else if machine < 0 || machine >= 1<<k8sFlake.bitsMachine {
+		return nil, ErrInvalidMachineID
 	} else {
 		k8sFlake.machineId = machine
 	}
@@ -189,6 +244,8 @@ func New(settings Settings) (*Kubeflake, error) {
 	} else {
 		k8sFlake.base = Base62Converter{}
 	}
+	k8sFlake.version = settings.Version
+	k8sFlake.pastLayouts = settings.PastLayouts
 
 	return k8sFlake, nil
 }
@@ -213,7 +270,7 @@ func (kf *Kubeflake) NextKey() (string, error) {
 	if err != nil {
 		return "", err
 	}
-	return kf.base.Encode(id), nil
+	return EncodeKey(kf.base, kf.version, id), nil
 }
 
 // NextID generates a next unique ID as uint64.
@@ -251,14 +308,17 @@ func (kf *Kubeflake) toID() (uint64, error) {
 	return res, nil
 }
 
+// ComposeKey returns the key of the ID made of the given parts.
 func (kf *Kubeflake) ComposeKey(t time.Time, sequence, machineID, clusterId int) (string, error) {
 	id, err := kf.Compose(t, sequence, machineID, clusterId)
 	if err != nil {
 		return "", err
 	}
-	return kf.base.Encode(id), nil
+	return EncodeKey(kf.base, kf.version, id), nil
 }
 
+// Compose returns the ID made of the given parts, or an error when one
+// does not fit the instance's layout.
 func (kf *Kubeflake) Compose(t time.Time, sequence, machineID, clusterId int) (uint64, error) {
 	internalTime := kf.toInternalTime(t.UTC())
 	if internalTime < kf.startTime {
@@ -287,38 +347,98 @@ func (kf *Kubeflake) Compose(t time.Time, sequence, machineID, clusterId int) (u
 		uint64(machineID), nil
 }
 
+// DecomposeKey splits a key into its parts under the layout of the
+// key's version, which it adds as the Version part.
 func (kf *Kubeflake) DecomposeKey(key string) (map[IdParts]uint64, error) {
-	id, err := kf.base.Decode(key)
+	version, id, err := ParseKey(kf.base, key)
 	if err != nil {
 		return nil, err
 	}
-	return kf.Decompose(id), nil
+	var parts map[IdParts]uint64
+	if version == kf.version {
+		parts = kf.Decompose(id)
+	} else if l, ok := kf.pastLayouts[version]; ok {
+		parts = l.Decompose(id)
+	} else {
+		return nil, ErrUnknownLayout
+	}
+	parts[Version] = uint64(version)
+	return parts, nil
 }
 
+// Decompose splits an ID minted under the instance's layout into its parts.
 func (kf *Kubeflake) Decompose(id uint64) map[IdParts]uint64 {
+	return kf.Layout().Decompose(id)
+}
+
+// Decompose splits an ID minted under the layout into its parts.
+func (l Layout) Decompose(id uint64) map[IdParts]uint64 {
 	return map[IdParts]uint64{
-		Timestamp: kf.timePart(id),
-		Sequence:  kf.sequencePart(id),
-		MachineID: kf.machinePart(id),
-		ClusterID: kf.clusterPart(id),
+		Timestamp: id >> (l.BitsSequence + l.BitsCluster + l.BitsMachine),
+		Sequence:  id >> (l.BitsMachine + l.BitsCluster) & (1<<l.BitsSequence - 1),
+		ClusterID: id >> l.BitsMachine & (1<<l.BitsCluster - 1),
+		MachineID: id & (1<<l.BitsMachine - 1),
 	}
 }
 
-func (kf *Kubeflake) timePart(id uint64) uint64 {
-	return uint64(id >> (kf.bitsSequence + kf.bitsCluster + kf.bitsMachine))
+// EncodeKey encodes id as a key stamped with the layout version; see
+// Settings.Version.
+func EncodeKey(base BaseConverter, version int, id uint64) string {
+	digits := base.Encode(id)
+	if version == 0 {
+		return digits
+	}
+	width := len(base.Encode(math.MaxUint64))
+	return base.Encode(uint64(version)) + strings.Repeat(base.Encode(0), width-len(digits)) + digits
 }
 
-func (kf *Kubeflake) sequencePart(id uint64) uint64 {
-	maskSequence := (1<<kf.bitsSequence - 1) << (kf.bitsMachine + kf.bitsCluster)
-	return (id & uint64(maskSequence)) >> (kf.bitsMachine + kf.bitsCluster)
+// ParseKey returns the layout version a key is stamped with and its ID.
+// Keys no longer than the longest unstamped key are of version 0.
+func ParseKey(base BaseConverter, key string) (version int, id uint64, err error) {
+	width := len(base.Encode(math.MaxUint64))
+	if len(key) > width {
+		v, err := base.Decode(key[:len(key)-width])
+		if err != nil {
+			return 0, 0, err
+		}
+		if v > math.MaxInt32 {
+			return 0, 0, ErrInvalidVersion
+		}
+		version, key = int(v), key[len(key)-width:]
+	}
+	id, err = base.Decode(key)
+	if err != nil {
+		return 0, 0, err
+	}
+	return version, id, nil
 }
 
-func (kf *Kubeflake) clusterPart(id uint64) uint64 {
-	maskCluster := (1<<kf.bitsCluster - 1) << kf.bitsMachine
-	return (id & uint64(maskCluster)) >> kf.bitsMachine
+// Layout describes the IDs a Kubeflake generates: the bit length of each
+// part, from the most significant (time, sequence, cluster, machine), and
+// the values fixed for the instance.
+type Layout struct {
+	Version      int           `json:"version"`
+	BitsTime     int           `json:"bits_time"`
+	BitsSequence int           `json:"bits_sequence"`
+	BitsCluster  int           `json:"bits_cluster"`
+	BitsMachine  int           `json:"bits_machine"`
+	TimeUnit     time.Duration `json:"time_unit"`
+	Epoch        time.Time     `json:"epoch"`
+	ClusterID    int           `json:"cluster_id"`
+	MachineID    int           `json:"machine_id"`
 }
 
-func (kf *Kubeflake) machinePart(id uint64) uint64 {
-	maskMachine := uint64(1<<kf.bitsMachine - 1)
-	return id & maskMachine
+// Layout returns the layout of the instance's IDs.
+func (kf *Kubeflake) Layout() Layout {
+	return Layout{
+		Version:      kf.version,
+		BitsTime:     kf.bitsTime,
+		BitsSequence: kf.bitsSequence,
+		BitsCluster:  kf.bitsCluster,
+		BitsMachine:  kf.bitsMachine,
+		TimeUnit:     time.Duration(kf.timeUnit),
+		Epoch:        time.Unix(0, int64(kf.startTime)*kf.timeUnit).UTC(),
+		ClusterID:    kf.clusterId,
+		MachineID:    kf.machineId,
+	}
 }
@@ -491,3 +491,86 @@ func TestBase62_EncodeDecode_RoundTrip(t *testing.T) {
 		}
 	}
 }
+
+func TestLayout(t *testing.T) {
+	s := validSettings()
+	s.EpochTime = time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	kf, err := New(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Layout{
+		BitsTime:     64 - defaultBitsSequence - defaultBitsCluster - defaultBitsMachine,
+		BitsSequence: defaultBitsSequence,
+		BitsCluster:  defaultBitsCluster,
+		BitsMachine:  defaultBitsMachine,
+		TimeUnit:     time.Millisecond,
+		Epoch:        s.EpochTime,
+		ClusterID:    2,
+		MachineID:    5,
+	}
+	if got := kf.Layout(); got != want {
+		t.Fatalf("Layout() = %+v, want %+v", got, want)
+	}
+}
+
+func TestDecomposeKey_PastLayouts(t *testing.T) {
+	old := validSettings()
+	oldKf, err := New(old)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tm := old.EpochTime.Add(time.Hour)
+	oldKey, err := oldKf.ComposeKey(tm, 7, 11, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := validSettings()
+	s.BitsSequence, s.BitsCluster, s.BitsMachine = 11, 7, 6
+	s.Version = 2
+	s.PastLayouts = map[int]Layout{0: oldKf.Layout()}
+	kf, err := New(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := kf.ComposeKey(tm, 7, 11, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(key) != 12 || key[0] != '2' {
+		t.Fatalf("want a 12 character key stamped with version 2, got %q", key)
+	}
+
+	for _, k := range []string{oldKey, key} {
+		parts, err := kf.DecomposeKey(k)
+		if err != nil {
+			t.Fatalf("DecomposeKey(%q): %v", k, err)
+		}
+		if parts[Sequence] != 7 || parts[MachineID] != 11 || parts[ClusterID] != 3 {
+			t.Fatalf("DecomposeKey(%q) = %v", k, parts)
+		}
+	}
+	if parts, _ := kf.DecomposeKey(oldKey); parts[Version] != 0 {
+		t.Fatalf("want the unstamped key at version 0, got %d", parts[Version])
+	}
+
+	// The old instance does not know version 2.
+	if _, err := oldKf.DecomposeKey(key); !errors.Is(err, ErrUnknownLayout) {
+		t.Fatalf("want ErrUnknownLayout, got %v", err)
+	}
+}
+
+func TestParseKey(t *testing.T) {
+	b := Base62Converter{}
+	for _, tc := range []struct {
+		version int
+		id      uint64
+	}{{0, 0}, {0, 1<<64 - 1}, {1, 0}, {5, 12345}, {61, 1<<64 - 1}, {62, 42}} {
+		key := EncodeKey(b, tc.version, tc.id)
+		v, id, err := ParseKey(b, key)
+		if err != nil || v != tc.version || id != tc.id {
+			t.Fatalf("ParseKey(%q) = %d, %d, %v; want %d, %d", key, v, id, err, tc.version, tc.id)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package kubeflake
+
+import "time"
+
+// Clock tells the time to a Kubeflake, so tests and simulations can
+// control it.
+type Clock interface {
+	Now() time.Time
+}
+
+// SystemClock is the Clock of the system.
+type SystemClock struct{}
+
+// Now implements Clock.
+func (SystemClock) Now() time.Time { return time.Now() }
+
+// IDProvider provides the cluster or the machine ID of a Kubeflake, e.g.
+// from the environment or an ID claim.
+type IDProvider interface {
+	ID() (int, error)
+}
+
+// FixedID is an IDProvider of a constant ID.
+type FixedID int
+
+// ID implements IDProvider.
+func (id FixedID) ID() (int, error) { return int(id), nil }
+
+// IDFunc adapts a function to an IDProvider.
+type IDFunc func() (int, error)
+
+// ID implements IDProvider.
+func (f IDFunc) ID() (int, error) { return f() }
+
+// Option configures a Kubeflake made by NewWithOptions.
+type Option func(*Settings)
+
+// NewWithOptions returns a new Kubeflake with the default Settings changed
+// by opts. The cluster and machine IDs are required:
+//
+//	kf, err := kubeflake.NewWithOptions(
+//		kubeflake.WithClusterID(kubeflake.FixedID(1)),
+//		kubeflake.WithMachineID(kubeflake.FixedID(42)),
+//	)
+func NewWithOptions(opts ...Option) (*Kubeflake, error) {
+	var s Settings
+	for _, opt := range opts {
+		opt(&s)
+	}
+	return New(s)
+}
+
+// WithBits sets the bit lengths of the sequence, cluster and machine
+// parts; see Settings.
+func WithBits(sequence, cluster, machine int) Option {
+	return func(s *Settings) {
+		s.BitsSequence, s.BitsCluster, s.BitsMachine = sequence, cluster, machine
+	}
+}
+
+// WithTimeUnit sets the resolution of the timestamp part.
+func WithTimeUnit(unit time.Duration) Option {
+	return func(s *Settings) { s.TimeUnit = unit }
+}
+
+// WithEpoch sets the time the timestamp part counts from.
+func WithEpoch(epoch time.Time) Option {
+	return func(s *Settings) { s.EpochTime = epoch }
+}
+
+// WithBase sets the encoding of keys.
+func WithBase(base BaseConverter) Option {
+	return func(s *Settings) { s.Base = base }
+}
+
+// WithVersion sets the layout version stamped on keys.
+func WithVersion(version int) Option {
+	return func(s *Settings) { s.Version = version }
+}
+
+// WithPastLayout adds the layout keys of version were minted under, so
+// DecomposeKey can still parse them.
+func WithPastLayout(version int, l Layout) Option {
+	return func(s *Settings) {
+		if s.PastLayouts == nil {
+			s.PastLayouts = make(map[int]Layout)
+		}
+		s.PastLayouts[version] = l
+	}
+}
+
+// WithClusterID sets the provider of the cluster ID.
+func WithClusterID(p IDProvider) Option {
+	return func(s *Settings) { s.ClusterId = p.ID }
+}
+
+// WithMachineID sets the provider of the machine ID.
+func WithMachineID(p IDProvider) Option {
+	return func(s *Settings) { s.MachineId = p.ID }
+}
+
+// WithClock sets the clock.
+func WithClock(c Clock) Option {
+	return func(s *Settings) { s.Clock = c }
+}
@@ -0,0 +1,85 @@
+package kubeflake
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestNewWithOptions(t *testing.T) {
+	epoch := time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC)
+	clk := newStepClock(epoch.Add(time.Hour), time.Millisecond)
+	kf, err := NewWithOptions(
+		WithBits(10, 4, 8),
+		WithTimeUnit(time.Millisecond),
+		WithEpoch(epoch),
+		WithVersion(2),
+		WithPastLayout(1, Layout{BitsTime: 39, BitsSequence: 9, BitsCluster: 3, BitsMachine: 13}),
+		WithClusterID(FixedID(5)),
+		WithMachineID(IDFunc(func() (int, error) { return 200, nil })),
+		WithClock(clk),
+	)
+	if err != nil {
+		t.Fatalf("NewWithOptions: %v", err)
+	}
+	l := kf.Layout()
+	if l.BitsSequence != 10 || l.BitsCluster != 4 || l.BitsMachine != 8 || l.Version != 2 || !l.Epoch.Equal(epoch) {
+		t.Fatalf("unexpected layout %+v", l)
+	}
+
+	key, err := kf.NextKey()
+	if err != nil {
+		t.Fatalf("NextKey: %v", err)
+	}
+	parts, err := kf.DecomposeKey(key)
+	if err != nil {
+		t.Fatalf("DecomposeKey: %v", err)
+	}
+	// The clock has stepped once in New and once in NextKey.
+	want := uint64((time.Hour + 2*time.Millisecond) / time.Millisecond)
+	if parts[Timestamp] != want || parts[ClusterID] != 5 || parts[MachineID] != 200 || parts[Version] != 2 {
+		t.Fatalf("unexpected parts %v, want timestamp %d", parts, want)
+	}
+	if _, ok := kf.pastLayouts[1]; !ok {
+		t.Fatalf("past layout not kept")
+	}
+}
+
+func TestNewWithOptions_Defaults(t *testing.T) {
+	kf, err := NewWithOptions(WithClusterID(FixedID(1)), WithMachineID(FixedID(1)))
+	if err != nil {
+		t.Fatalf("NewWithOptions: %v", err)
+	}
+	l := kf.Layout()
+	if l.BitsSequence != defaultBitsSequence || l.BitsCluster != defaultBitsCluster || l.BitsMachine != defaultBitsMachine ||
+		l.TimeUnit != defaultTimeUnit || !l.Epoch.Equal(defaultEpochTime) {
+		t.Fatalf("want the default layout, got %+v", l)
+	}
+}
+
+func TestNewWithOptions_Errors(t *testing.T) {
+	errProvider := errors.New("no zone")
+	tests := []struct {
+		name    string
+		opts    []Option
+		wantErr error
+	}{
+		{"no cluster id", []Option{WithMachineID(FixedID(1))}, ErrNoClusterID},
+		{"no machine id", []Option{WithClusterID(FixedID(1))}, ErrNoMachineID},
+		{"cluster id too large", []Option{WithClusterID(FixedID(1 << defaultBitsCluster)), WithMachineID(FixedID(1))}, ErrInvalidClusterID},
+		{"negative machine id", []Option{WithClusterID(FixedID(1)), WithMachineID(FixedID(-1))}, ErrInvalidMachineID},
+		{"provider error", []Option{WithClusterID(IDFunc(func() (int, error) { return 0, errProvider })), WithMachineID(FixedID(1))}, errProvider},
+		{"epoch ahead of the clock", []Option{
+			WithClusterID(FixedID(1)), WithMachineID(FixedID(1)),
+			WithEpoch(time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)),
+			WithClock(newStepClock(time.Date(2029, 1, 1, 0, 0, 0, 0, time.UTC), time.Second)),
+		}, ErrStartTimeAhead},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewWithOptions(tt.opts...); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("want %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,61 @@
+package legacysync
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestBitly_Changes(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v4/groups/Bg1/bitlinks" || r.Header.Get("Authorization") != "Bearer tok" {
+			http.Error(w, "unexpected request", http.StatusBadRequest)
+			return
+		}
+		if got := r.URL.Query().Get("created_after"); got != "1767225599" {
+			t.Errorf("want created_after a second before since, got %q", got)
+		}
+		if r.URL.Query().Get("search_after") == "" {
+			w.Write([]byte(`{"links":[
+				{"id":"bit.ly/new","long_url":"https://example.com/new","created_at":"2026-01-01T00:00:10+0000"},
+				{"id":"bit.ly/gone","long_url":"https://example.com/gone","created_at":"2026-01-01T00:00:05+0000","archived":true},
+				{"id":"go.example/other","long_url":"https://example.com/other","created_at":"2026-01-01T00:00:05+0000"}
+			],"pagination":{"next":"https://api-ssl.bitly.com/next","search_after":"c1"}}`))
+			return
+		}
+		w.Write([]byte(`{"links":[
+			{"id":"bit.ly/edge","long_url":"https://example.com/edge","created_at":"2026-01-01T00:00:00+0000"},
+			{"id":"bit.ly/early","long_url":"https://example.com/early","created_at":"2025-12-31T23:59:59+0000"}
+		],"pagination":{"next":"","search_after":""}}`))
+	}))
+	defer srv.Close()
+
+	b := &Bitly{BaseURL: srv.URL, Token: "tok", Group: "Bg1", Domain: "bit.ly"}
+	since := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	page, err := b.Changes(context.Background(), since, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Links) != 1 || page.Next != "c1" {
+		t.Fatalf("want the live link of the domain and a cursor, got %+v", page)
+	}
+	want := Link{ID: "bit.ly/new", Key: "new", Target: "https://example.com/new", ModifiedAt: since.Add(10 * time.Second)}
+	if l := page.Links[0]; l.ID != want.ID || l.Key != want.Key || l.Target != want.Target || !l.ModifiedAt.Equal(want.ModifiedAt) {
+		t.Fatalf("want %+v, got %+v", want, l)
+	}
+
+	page, err = b.Changes(context.Background(), since, page.Next)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Links) != 1 || page.Links[0].Key != "edge" || page.Next != "" {
+		t.Fatalf("want the links created since, and no cursor on the last page, got %+v", page)
+	}
+
+	b.Token = "wrong"
+	if _, err := b.Changes(context.Background(), since, ""); err == nil {
+		t.Fatal("want an error when the API refuses the request")
+	}
+}
@@ -0,0 +1,381 @@
+// Package legacysync keeps the store in step with a legacy shortener while
+// both serve links, during a migration: a Runner pulls the links created
+// or changed there since its last pass, creates the missing ones and
+// updates those it created before, and reports the keys both systems claim
+// for different targets rather than overwrite either.
+//
+// Links the sync creates record where they come from in their Metadata,
+// under "legacy_sync", which is how later passes tell them from links
+// created locally.
+package legacysync
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/datastore"
+
+	"github.com/FlorinBalint/shortener/pkg/gcputil"
+	"github.com/FlorinBalint/shortener/pkg/urlstore"
+)
+
+// Link is a link of the legacy shortener.
+type Link struct {
+	// ID identifies the link to the provider, e.g. "bit.ly/3xYz".
+	ID string
+	// Key is the key the link is served under, e.g. "3xYz".
+	Key    string
+	Target string
+	// ModifiedAt is when the link was created or last changed, as far as
+	// the provider tells.
+	ModifiedAt time.Time
+}
+
+// Page is a page of links.
+type Page struct {
+	Links []Link
+	// Next is the cursor of the next page, "" after the last one.
+	Next string
+}
+
+// Provider lists the links of a legacy shortener.
+type Provider interface {
+	// Name identifies the provider in checkpoints and link metadata.
+	Name() string
+	// Changes returns the page after cursor ("" for the first) of the
+	// links created or changed at or after since; the zero since lists
+	// every link.
+	Changes(ctx context.Context, since time.Time, cursor string) (Page, error)
+}
+
+// Checkpoint is the progress of the sync from a provider.
+type Checkpoint struct {
+	// Since is where the next pass starts: the latest change seen by the
+	// last completed pass.
+	Since time.Time `json:"since,omitzero"`
+	// Cursor is the provider cursor after the last page done, while a
+	// pass is under way.
+	Cursor string `json:"cursor,omitempty"`
+	// Latest is the latest change seen so far by the pass under way.
+	Latest time.Time `json:"latest,omitzero"`
+}
+
+// Checkpoints persists sync progress by provider name.
+type Checkpoints interface {
+	// Load returns the named checkpoint, or the zero Checkpoint if none.
+	Load(ctx context.Context, name string) (Checkpoint, error)
+	Save(ctx context.Context, name string, cp Checkpoint) error
+}
+
+// Config configures a Runner.
+//
+// With DryRun set, changes are only counted and no checkpoint is written.
+// Full ignores the saved checkpoint and goes over every link again, e.g.
+// to pick up the edits of providers that only report creations.
+type Config struct {
+	DryRun bool
+	Full   bool
+}
+
+// Conflict reasons.
+const (
+	// ConflictTaken is a key held by a local link the sync did not create.
+	ConflictTaken = "taken"
+	// ConflictEdited is a synced link whose target was changed locally
+	// since.
+	ConflictEdited = "edited"
+	// ConflictInactive is a key held by an expired or deleted link that
+	// was not purged yet.
+	ConflictInactive = "inactive"
+	// ConflictInvalid is a legacy link the store cannot serve, e.g. with
+	// a target that is not an http(s) URL.
+	ConflictInvalid = "invalid"
+)
+
+// Conflict is a legacy link that was not synced, for someone to settle.
+type Conflict struct {
+	Key    string `json:"key"`
+	Reason string `json:"reason"`
+	// Legacy is the target of the legacy link, Local that of the local
+	// link, if any.
+	Legacy string `json:"legacy_target"`
+	Local  string `json:"local_target,omitempty"`
+}
+
+// Result summarizes a run.
+type Result struct {
+	Scanned   int
+	Created   int
+	Updated   int
+	Unchanged int
+	Failed    int
+	Conflicts []Conflict
+}
+
+// origin is what synced links record in their Metadata.
+type origin struct {
+	Source string `json:"source"`
+	ID     string `json:"id,omitempty"`
+	// Target is the target last synced, to tell local edits apart.
+	Target string `json:"target"`
+}
+
+// metadataField is the Metadata field holding the origin.
+const metadataField = "legacy_sync"
+
+// Runner syncs a store from a provider.
+type Runner struct {
+	provider    Provider
+	store       urlstore.Client
+	checkpoints Checkpoints
+	cfg         Config
+	now         func() time.Time
+}
+
+// New returns a Runner syncing store from provider, with progress kept in
+// checkpoints.
+func New(provider Provider, store urlstore.Client, checkpoints Checkpoints, cfg Config) *Runner {
+	return &Runner{provider: provider, store: store, checkpoints: checkpoints, cfg: cfg, now: time.Now}
+}
+
+// Run pulls the links changed since the last pass and syncs them.
+// Individual failures are logged and counted; a failing page aborts the
+// run, which resumes after the last completed page when run again.
+func (r *Runner) Run(ctx context.Context) (Result, error) {
+	var res Result
+	name := r.provider.Name()
+	var cp Checkpoint
+	if !r.cfg.Full {
+		var err error
+		if cp, err = r.checkpoints.Load(ctx, name); err != nil {
+			return res, fmt.Errorf("loading checkpoint: %w", err)
+		}
+	}
+	for {
+		page, err := r.provider.Changes(ctx, cp.Since, cp.Cursor)
+		if err != nil {
+			return res, err
+		}
+		for _, l := range page.Links {
+			res.Scanned++
+			r.sync(ctx, l, &res)
+			if l.ModifiedAt.After(cp.Latest) {
+				cp.Latest = l.ModifiedAt
+			}
+		}
+		cp.Cursor = page.Next
+		if cp.Cursor == "" {
+			// The next pass starts at the latest change seen, which it sees
+			// again, rather than after it, which could skip changes made in
+			// the same instant.
+			if cp.Latest.After(cp.Since) {
+				cp.Since = cp.Latest
+			}
+			cp.Latest = time.Time{}
+		}
+		if !r.cfg.DryRun {
+			if err := r.checkpoints.Save(ctx, name, cp); err != nil {
+				return res, fmt.Errorf("saving checkpoint: %w", err)
+			}
+		}
+		if cp.Cursor == "" {
+			return res, nil
+		}
+	}
+}
+
+// sync brings one legacy link to the store.
+func (r *Runner) sync(ctx context.Context, l Link, res *Result) {
+	conflict := func(reason, local string) {
+		res.Conflicts = append(res.Conflicts, Conflict{Key: l.Key, Reason: reason, Legacy: l.Target, Local: local})
+	}
+	if l.Key == "" || !validTarget(l.Target) {
+		conflict(ConflictInvalid, "")
+		return
+	}
+	key := urlstore.UrlKey(l.Key)
+	entry, err := r.store.GetEntry(ctx, key)
+	switch {
+	case errors.Is(err, urlstore.ErrNotFound):
+		r.create(ctx, l, res, conflict)
+		return
+	case err != nil:
+		log.Printf("legacysync: looking %q up failed: %v", l.Key, err)
+		res.Failed++
+		return
+	}
+
+	o, synced := originOf(entry, r.provider.Name())
+	switch {
+	case !synced:
+		if entry.URLTarget == l.Target {
+			res.Unchanged++
+			return
+		}
+		conflict(ConflictTaken, entry.URLTarget)
+		return
+	case entry.URLTarget != o.Target:
+		if entry.URLTarget != l.Target {
+			conflict(ConflictEdited, entry.URLTarget)
+			return
+		}
+	case entry.URLTarget == l.Target:
+		res.Unchanged++
+		return
+	}
+	if r.cfg.DryRun {
+		res.Updated++
+		return
+	}
+	r.update(ctx, l, res, conflict)
+}
+
+// create stores a new link for l.
+func (r *Runner) create(ctx context.Context, l Link, res *Result, conflict func(reason, local string)) {
+	if r.cfg.DryRun {
+		res.Created++
+		return
+	}
+	metadata, err := withOrigin(nil, origin{Source: r.provider.Name(), ID: l.ID, Target: l.Target})
+	if err != nil {
+		log.Printf("legacysync: creating %q failed: %v", l.Key, err)
+		res.Failed++
+		return
+	}
+	err = r.store.CreateEntry(ctx, urlstore.UrlKey(l.Key), urlstore.URLEntry{
+		URLTarget:         l.Target,
+		CreationTimestamp: r.now().UTC(),
+		Version:           1,
+		Metadata:          metadata,
+	})
+	switch {
+	case err == nil:
+		res.Created++
+	case errors.Is(err, urlstore.ErrAlreadyExists):
+		// GetEntry does not return expired or deleted links.
+		conflict(ConflictInactive, "")
+	default:
+		log.Printf("legacysync: creating %q failed: %v", l.Key, err)
+		res.Failed++
+	}
+}
+
+// update points the link synced before from l at its new target, unless
+// it changed meanwhile.
+func (r *Runner) update(ctx context.Context, l Link, res *Result, conflict func(reason, local string)) {
+	var edited string
+	err := r.store.UpdateEntry(ctx, urlstore.UrlKey(l.Key), func(e *urlstore.URLEntry) error {
+		edited = ""
+		o, synced := originOf(*e, r.provider.Name())
+		if !synced || (e.URLTarget != o.Target && e.URLTarget != l.Target) {
+			edited = e.URLTarget
+			return errEdited
+		}
+		metadata, err := withOrigin(e.Metadata, origin{Source: r.provider.Name(), ID: l.ID, Target: l.Target})
+		if err != nil {
+			return err
+		}
+		e.URLTarget = l.Target
+		e.Metadata = metadata
+		e.UpdatedAt = r.now().UTC()
+		e.Version++
+		return nil
+	})
+	switch {
+	case err == nil:
+		res.Updated++
+	case errors.Is(err, errEdited):
+		conflict(ConflictEdited, edited)
+	case errors.Is(err, urlstore.ErrNotFound):
+		conflict(ConflictInactive, "")
+	default:
+		log.Printf("legacysync: updating %q failed: %v", l.Key, err)
+		res.Failed++
+	}
+}
+
+var errEdited = errors.New("edited locally")
+
+// validTarget reports whether the store can serve target.
+func validTarget(target string) bool {
+	return strings.HasPrefix(target, "https://") || strings.HasPrefix(target, "http://")
+}
+
+// originOf returns the origin recorded by entry if it was synced from the
+// named provider.
+func originOf(entry urlstore.URLEntry, source string) (origin, bool) {
+	var fields struct {
+		Origin *origin `json:"legacy_sync"`
+	}
+	if json.Unmarshal(entry.Metadata, &fields) != nil || fields.Origin == nil || fields.Origin.Source != source {
+		return origin{}, false
+	}
+	return *fields.Origin, true
+}
+
+// withOrigin returns metadata with its origin set to o, keeping its other
+// fields.
+func withOrigin(metadata json.RawMessage, o origin) (json.RawMessage, error) {
+	fields := map[string]json.RawMessage{}
+	if len(metadata) > 0 {
+		if err := json.Unmarshal(metadata, &fields); err != nil {
+			return nil, fmt.Errorf("metadata is not a JSON object: %w", err)
+		}
+	}
+	b, err := json.Marshal(o)
+	if err != nil {
+		return nil, err
+	}
+	fields[metadataField] = b
+	return json.Marshal(fields)
+}
+
+// checkpointKind is the Datastore kind of checkpoints.
+const checkpointKind = "legacysync_checkpoint"
+
+// DSCheckpoints keeps checkpoints in Datastore.
+type DSCheckpoints struct {
+	client *gcputil.DSClient
+}
+
+var _ Checkpoints = (*DSCheckpoints)(nil)
+
+// NewDSCheckpoints returns checkpoints stored through client.
+func NewDSCheckpoints(client *gcputil.DSClient) *DSCheckpoints {
+	return &DSCheckpoints{client: client}
+}
+
+// Load implements Checkpoints.
+func (c *DSCheckpoints) Load(ctx context.Context, name string) (Checkpoint, error) {
+	cp, err := gcputil.GetValue[Checkpoint](c.client, ctx, checkpointKind, name)
+	if errors.Is(err, datastore.ErrNoSuchEntity) {
+		return Checkpoint{}, nil
+	}
+	return cp, err
+}
+
+// Save implements Checkpoints.
+func (c *DSCheckpoints) Save(ctx context.Context, name string, cp Checkpoint) error {
+	return c.client.PutJSON(ctx, checkpointKind, name, cp)
+}
+
+// MemoryCheckpoints keeps checkpoints in memory, for tests.
+type MemoryCheckpoints map[string]Checkpoint
+
+var _ Checkpoints = MemoryCheckpoints(nil)
+
+// Load implements Checkpoints.
+func (m MemoryCheckpoints) Load(ctx context.Context, name string) (Checkpoint, error) {
+	return m[name], nil
+}
+
+// Save implements Checkpoints.
+func (m MemoryCheckpoints) Save(ctx context.Context, name string, cp Checkpoint) error {
+	m[name] = cp
+	return nil
+}
@@ -0,0 +1,160 @@
+package legacysync
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/FlorinBalint/shortener/pkg/urlstore"
+)
+
+// fakeProvider serves links in pages of two, filtered by since.
+type fakeProvider struct {
+	links []Link
+}
+
+func (p *fakeProvider) Name() string { return "fake" }
+
+func (p *fakeProvider) Changes(ctx context.Context, since time.Time, cursor string) (Page, error) {
+	var changed []Link
+	for _, l := range p.links {
+		if !l.ModifiedAt.Before(since) {
+			changed = append(changed, l)
+		}
+	}
+	start := 0
+	if cursor != "" {
+		start = slices.IndexFunc(changed, func(l Link) bool { return l.ID == cursor }) + 1
+	}
+	end := min(start+2, len(changed))
+	page := Page{Links: changed[start:end]}
+	if end < len(changed) {
+		page.Next = changed[end-1].ID
+	}
+	return page, nil
+}
+
+func TestRun(t *testing.T) {
+	ctx := context.Background()
+	t0 := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	link := func(key, target string, at time.Duration) Link {
+		return Link{ID: "old.example/" + key, Key: key, Target: target, ModifiedAt: t0.Add(at)}
+	}
+	store := urlstore.NewMemoryClient()
+	store.Seed("mine", urlstore.URLEntry{URLTarget: "https://example.com/mine"})
+	store.Seed("same", urlstore.URLEntry{URLTarget: "https://example.com/same"})
+	provider := &fakeProvider{links: []Link{
+		link("a", "https://example.com/a", 1),
+		link("b", "https://example.com/b", 2),
+		link("mine", "https://example.com/theirs", 3),
+		link("same", "https://example.com/same", 4),
+		link("bad", "javascript:alert(1)", 5),
+	}}
+	cps := MemoryCheckpoints{}
+
+	dry, err := New(provider, store, cps, Config{DryRun: true}).Run(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dry.Created != 2 || len(cps) != 0 {
+		t.Fatalf("dry run: want 2 to create and no checkpoint, got %+v, %v", dry, cps)
+	}
+
+	res, err := New(provider, store, cps, Config{}).Run(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Scanned != 5 || res.Created != 2 || res.Unchanged != 1 || res.Failed != 0 {
+		t.Fatalf("first pass: got %+v", res)
+	}
+	wantConflicts := []Conflict{
+		{Key: "mine", Reason: ConflictTaken, Legacy: "https://example.com/theirs", Local: "https://example.com/mine"},
+		{Key: "bad", Reason: ConflictInvalid, Legacy: "javascript:alert(1)"},
+	}
+	if !slices.Equal(res.Conflicts, wantConflicts) {
+		t.Fatalf("first pass: want conflicts %+v, got %+v", wantConflicts, res.Conflicts)
+	}
+	if cp := cps["fake"]; !cp.Since.Equal(t0.Add(5)) || cp.Cursor != "" {
+		t.Fatalf("first pass: want a completed checkpoint since the latest change, got %+v", cp)
+	}
+
+	// "a" changes upstream; "b" changes both upstream and locally.
+	provider.links[0] = link("a", "https://example.com/a2", 10)
+	provider.links[1] = link("b", "https://example.com/b2", 11)
+	if err := store.UpdateEntry(ctx, "b", func(e *urlstore.URLEntry) error {
+		e.URLTarget = "https://example.com/local"
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	res, err = New(provider, store, cps, Config{}).Run(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// "bad" changed last in the first pass, and is seen again.
+	if res.Scanned != 3 || res.Updated != 1 {
+		t.Fatalf("second pass: want only the changed links, one updated, got %+v", res)
+	}
+	wantConflicts = []Conflict{
+		{Key: "b", Reason: ConflictEdited, Legacy: "https://example.com/b2", Local: "https://example.com/local"},
+		wantConflicts[1],
+	}
+	if !slices.Equal(res.Conflicts, wantConflicts) {
+		t.Fatalf("second pass: want conflicts %+v, got %+v", wantConflicts, res.Conflicts)
+	}
+	a, err := store.GetEntry(ctx, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.URLTarget != "https://example.com/a2" || a.Version != 2 {
+		t.Fatalf("want a updated to a2 at version 2, got %+v", a)
+	}
+	if o, ok := originOf(a, "fake"); !ok || o.Target != "https://example.com/a2" || o.ID != "old.example/a" {
+		t.Fatalf("want the origin of a recorded, got %+v, %v", o, ok)
+	}
+
+	// A full pass goes over every link again.
+	res, err = New(provider, store, cps, Config{Full: true}).Run(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Scanned != 5 || res.Unchanged != 2 || res.Created+res.Updated != 0 {
+		t.Fatalf("full pass: got %+v", res)
+	}
+}
+
+func TestRun_Resume(t *testing.T) {
+	t0 := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	provider := &fakeProvider{links: []Link{
+		{ID: "1", Key: "a", Target: "https://example.com/a", ModifiedAt: t0.Add(3)},
+		{ID: "2", Key: "b", Target: "https://example.com/b", ModifiedAt: t0.Add(2)},
+		{ID: "3", Key: "c", Target: "https://example.com/c", ModifiedAt: t0.Add(1)},
+	}}
+	cps := MemoryCheckpoints{"fake": {Since: t0, Cursor: "2", Latest: t0.Add(3)}}
+	store := urlstore.NewMemoryClient()
+
+	res, err := New(provider, store, cps, Config{}).Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Scanned != 1 || res.Created != 1 {
+		t.Fatalf("want the pass resumed at its last page, got %+v", res)
+	}
+	if cp := cps["fake"]; !cp.Since.Equal(t0.Add(3)) || cp.Cursor != "" || !cp.Latest.IsZero() {
+		t.Fatalf("want the latest change of the whole pass kept, got %+v", cp)
+	}
+}
+
+func TestWithOrigin_KeepsMetadata(t *testing.T) {
+	metadata, err := withOrigin([]byte(`{"ticket":"OPS-1"}`), origin{Source: "fake", Target: "https://example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"legacy_sync":{"source":"fake","target":"https://example.com"},"ticket":"OPS-1"}`; string(metadata) != want {
+		t.Fatalf("want %s, got %s", want, metadata)
+	}
+	if _, err := withOrigin([]byte(`[1]`), origin{}); err == nil {
+		t.Fatal("want an error for metadata that is not an object")
+	}
+}
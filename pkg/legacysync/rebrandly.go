@@ -0,0 +1,89 @@
+package legacysync
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// rebrandlyPageSize is the most links the Rebrandly API lists at once.
+const rebrandlyPageSize = 25
+
+// Rebrandly lists the links of a Rebrandly account through its v1 API,
+// most recently changed first, down to the last pass.
+type Rebrandly struct {
+	// BaseURL is the API root, "https://api.rebrandly.com" if empty.
+	BaseURL string
+	// APIKey is an API key of the account.
+	APIKey string
+	// Domain restricts the sync to the links of a domain, e.g.
+	// "rebrand.ly"; empty syncs every domain, whose keys must then not
+	// collide.
+	Domain string
+	Client *http.Client
+}
+
+var _ Provider = (*Rebrandly)(nil)
+
+// Name implements Provider.
+func (r *Rebrandly) Name() string {
+	return "rebrandly"
+}
+
+type rebrandlyLink struct {
+	ID          string `json:"id"`
+	Slashtag    string `json:"slashtag"`
+	Destination string `json:"destination"`
+	UpdatedAt   string `json:"updatedAt"`
+	Domain      struct {
+		FullName string `json:"fullName"`
+	} `json:"domain"`
+}
+
+// Changes implements Provider. The cursor is the ID of the last link of
+// the previous page.
+func (r *Rebrandly) Changes(ctx context.Context, since time.Time, cursor string) (Page, error) {
+	q := url.Values{
+		"orderBy":  {"updatedAt"},
+		"orderDir": {"desc"},
+		"limit":    {strconv.Itoa(rebrandlyPageSize)},
+	}
+	if r.Domain != "" {
+		q.Set("domain.fullName", r.Domain)
+	}
+	if cursor != "" {
+		q.Set("last", cursor)
+	}
+	u := strings.TrimSuffix(cmp.Or(r.BaseURL, "https://api.rebrandly.com"), "/") + "/v1/links?" + q.Encode()
+	var body []rebrandlyLink
+	if err := getJSON(ctx, r.Client, u, "apikey", r.APIKey, &body); err != nil {
+		return Page{}, fmt.Errorf("rebrandly: %w", err)
+	}
+
+	var page Page
+	for _, l := range body {
+		updated, err := time.Parse(time.RFC3339, l.UpdatedAt)
+		if err != nil {
+			return Page{}, fmt.Errorf("rebrandly: %s: %w", l.ID, err)
+		}
+		if updated.Before(since) {
+			// Older links were synced by earlier passes.
+			return page, nil
+		}
+		page.Links = append(page.Links, Link{
+			ID:         l.ID,
+			Key:        l.Slashtag,
+			Target:     l.Destination,
+			ModifiedAt: updated,
+		})
+	}
+	if len(body) == rebrandlyPageSize {
+		page.Next = body[len(body)-1].ID
+	}
+	return page, nil
+}
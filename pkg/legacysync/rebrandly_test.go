@@ -0,0 +1,59 @@
+package legacysync
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRebrandly_Changes(t *testing.T) {
+	since := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	link := func(id string, at time.Duration) string {
+		return fmt.Sprintf(`{"id":%q,"slashtag":"k%s","destination":"https://example.com/%s","updatedAt":%q,"domain":{"fullName":"rebrand.ly"}}`,
+			id, id, id, since.Add(at).Format("2006-01-02T15:04:05.000Z"))
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if r.URL.Path != "/v1/links" || r.Header.Get("apikey") != "key" || q.Get("orderBy") != "updatedAt" || q.Get("orderDir") != "desc" {
+			http.Error(w, "unexpected request", http.StatusBadRequest)
+			return
+		}
+		if q.Get("domain.fullName") != "rebrand.ly" {
+			t.Errorf("want the links of the domain, got %q", q.Get("domain.fullName"))
+		}
+		var links []string
+		if q.Get("last") == "" {
+			for i := range rebrandlyPageSize {
+				links = append(links, link(fmt.Sprint(i), time.Duration(100-i)*time.Second))
+			}
+		} else {
+			links = []string{link("x", time.Second), link("y", 0), link("z", -time.Second)}
+		}
+		w.Write([]byte("[" + strings.Join(links, ",") + "]"))
+	}))
+	defer srv.Close()
+
+	r := &Rebrandly{BaseURL: srv.URL, APIKey: "key", Domain: "rebrand.ly"}
+	page, err := r.Changes(context.Background(), since, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Links) != rebrandlyPageSize || page.Next != "24" {
+		t.Fatalf("want a full page and the ID of its last link as cursor, got %d links, %q", len(page.Links), page.Next)
+	}
+	if l := page.Links[0]; l.ID != "0" || l.Key != "k0" || l.Target != "https://example.com/0" || !l.ModifiedAt.Equal(since.Add(100*time.Second)) {
+		t.Fatalf("unexpected link %+v", l)
+	}
+
+	page, err = r.Changes(context.Background(), since, page.Next)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Links) != 2 || page.Links[1].Key != "ky" || page.Next != "" {
+		t.Fatalf("want the links changed since and no cursor, got %+v", page)
+	}
+}
@@ -0,0 +1,200 @@
+// Package lifecycle runs a server through a Kubernetes rollout without
+// failed requests: it warms the server's dependencies before reporting
+// ready, and on SIGTERM reports not ready for a lame-duck period, so the
+// load balancer stops sending traffic, before draining the requests in
+// flight.
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// Config times the phases of Run.
+//
+// WarmupTimeout bounds the warm-up (default 30s). LameDuck is how long the
+// server keeps serving while reporting not ready, which should exceed the
+// readiness probe period times its failure threshold (default 10s).
+// DrainTimeout bounds the wait for requests in flight (default 15s).
+type Config struct {
+	WarmupTimeout time.Duration
+	LameDuck      time.Duration
+	DrainTimeout  time.Duration
+}
+
+// ConfigFromEnv reads WARMUP_TIMEOUT, LAME_DUCK_DELAY and DRAIN_TIMEOUT
+// (durations). Malformed values are logged and ignored.
+func ConfigFromEnv() Config {
+	return Config{
+		WarmupTimeout: envDuration("WARMUP_TIMEOUT"),
+		LameDuck:      envDuration("LAME_DUCK_DELAY"),
+		DrainTimeout:  envDuration("DRAIN_TIMEOUT"),
+	}
+}
+
+func envDuration(k string) time.Duration {
+	v := os.Getenv(k)
+	if v == "" {
+		return 0
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		log.Printf("ignoring invalid %s=%q", k, v)
+		return 0
+	}
+	return d
+}
+
+func (c Config) withDefaults() Config {
+	if c.WarmupTimeout <= 0 {
+		c.WarmupTimeout = 30 * time.Second
+	}
+	if c.LameDuck <= 0 {
+		c.LameDuck = 10 * time.Second
+	}
+	if c.DrainTimeout <= 0 {
+		c.DrainTimeout = 15 * time.Second
+	}
+	return c
+}
+
+// Readiness is the answer of a readiness probe. The zero Readiness is not
+// ready.
+type Readiness struct {
+	ready atomic.Bool
+
+	mu    sync.Mutex
+	needs []requirement
+}
+
+// requirement is a dependency the server is not ready without.
+type requirement struct {
+	name string
+	ok   func() bool
+}
+
+// Set marks the server ready or not.
+func (r *Readiness) Set(ready bool) {
+	r.ready.Store(ready)
+}
+
+// Require adds a dimension to readiness: the server is ready only while ok
+// reports true as well, e.g. while a dependency it cannot serve without is
+// available. Failed probes name the first requirement not met.
+func (r *Readiness) Require(name string, ok func() bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.needs = append(r.needs, requirement{name: name, ok: ok})
+}
+
+// unmet returns the name of the first requirement not met, or "".
+func (r *Readiness) unmet() string {
+	r.mu.Lock()
+	needs := r.needs
+	r.mu.Unlock()
+	for _, n := range needs {
+		if !n.ok() {
+			return n.name
+		}
+	}
+	return ""
+}
+
+// Ready reports whether the server is ready: set, and its requirements
+// met.
+func (r *Readiness) Ready() bool {
+	return r.ready.Load() && r.unmet() == ""
+}
+
+// ServeHTTP answers a readiness probe: 200 when ready, 503 otherwise.
+func (r *Readiness) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if !r.ready.Load() {
+		http.Error(w, "not ready", http.StatusServiceUnavailable)
+		return
+	}
+	if name := r.unmet(); name != "" {
+		http.Error(w, "not ready: "+name+" unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// Run serves srv until SIGTERM or SIGINT, then shuts it down gracefully.
+//
+// The server listens right away, so liveness probes pass, but ready is set
+// only once warm has returned; a failed warm-up is logged and the server
+// made ready regardless, as the first requests would warm it as well. On a
+// signal ready is cleared, the server keeps serving for cfg.LameDuck, then
+// stops accepting connections and waits for the requests in flight. A
+// second signal kills the process.
+func Run(srv *http.Server, ready *Readiness, warm func(context.Context) error, cfg Config) error {
+	addr := srv.Addr
+	if addr == "" {
+		addr = ":http"
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+	return serve(ctx, srv, ln, ready, warm, cfg.withDefaults())
+}
+
+// serve runs srv on ln until ctx is done.
+func serve(ctx context.Context, srv *http.Server, ln net.Listener, ready *Readiness, warm func(context.Context) error, cfg Config) error {
+	served := make(chan error, 1)
+	go func() { served <- srv.Serve(ln) }()
+
+	warmed := make(chan struct{})
+	go func() {
+		defer close(warmed)
+		start := time.Now()
+		wctx, cancel := context.WithTimeout(ctx, cfg.WarmupTimeout)
+		defer cancel()
+		if err := warm(wctx); err != nil {
+			log.Printf("warm-up failed after %v, serving cold: %v", time.Since(start).Round(time.Millisecond), err)
+		} else {
+			log.Printf("warm-up done in %v", time.Since(start).Round(time.Millisecond))
+		}
+		if ctx.Err() == nil {
+			ready.Set(true)
+		}
+	}()
+
+	select {
+	case err := <-served:
+		return err
+	case <-ctx.Done():
+	}
+	ready.Set(false)
+	log.Printf("lame duck: not ready, serving for another %v", cfg.LameDuck)
+	select {
+	case err := <-served:
+		return err
+	case <-time.After(cfg.LameDuck):
+	}
+
+	sctx, cancel := context.WithTimeout(context.Background(), cfg.DrainTimeout)
+	defer cancel()
+	log.Printf("draining requests in flight for up to %v", cfg.DrainTimeout)
+	err := srv.Shutdown(sctx)
+	<-warmed
+	if served := <-served; !errors.Is(served, http.ErrServerClosed) {
+		err = errors.Join(err, served)
+	}
+	return err
+}
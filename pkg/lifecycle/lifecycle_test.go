@@ -0,0 +1,103 @@
+package lifecycle
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestServe_WarmupAndLameDuck(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	base := "http://" + ln.Addr().String()
+
+	ready := new(Readiness)
+	slow := make(chan struct{})
+	mux := http.NewServeMux()
+	mux.Handle("/ready", ready)
+	mux.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		<-slow
+	})
+	srv := &http.Server{Handler: mux}
+
+	warming := make(chan struct{})
+	warm := func(ctx context.Context) error {
+		<-warming
+		return nil
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- serve(ctx, srv, ln, ready, warm, Config{WarmupTimeout: time.Minute, LameDuck: 50 * time.Millisecond, DrainTimeout: time.Minute})
+	}()
+
+	status := func(path string) int {
+		resp, err := http.Get(base + path)
+		if err != nil {
+			t.Fatalf("GET %s: %v", path, err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	if got := status("/ready"); got != http.StatusServiceUnavailable {
+		t.Fatalf("warming: want 503, got %d", got)
+	}
+	close(warming)
+	for !ready.Ready() {
+		time.Sleep(time.Millisecond)
+	}
+	if got := status("/ready"); got != http.StatusOK {
+		t.Fatalf("warm: want 200, got %d", got)
+	}
+
+	// A request in flight when the lame duck period starts completes.
+	inFlight := make(chan int, 1)
+	go func() { inFlight <- status("/slow") }()
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+	for ready.Ready() {
+		time.Sleep(time.Millisecond)
+	}
+	if got := status("/ready"); got != http.StatusServiceUnavailable {
+		t.Fatalf("lame duck: want 503, got %d", got)
+	}
+	close(slow)
+	if got := <-inFlight; got != http.StatusOK {
+		t.Errorf("in flight request: want 200, got %d", got)
+	}
+	if err := <-done; err != nil {
+		t.Errorf("serve: %v", err)
+	}
+	if _, err := http.Get(base + "/ready"); err == nil {
+		t.Error("still serving after shutdown")
+	}
+}
+
+func TestReadiness_Require(t *testing.T) {
+	ready := new(Readiness)
+	var up bool
+	ready.Require("keygen", func() bool { return up })
+	ready.Set(true)
+	status := func() (int, string) {
+		rec := httptest.NewRecorder()
+		ready.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/ready", nil))
+		return rec.Code, strings.TrimSpace(rec.Body.String())
+	}
+	if code, body := status(); ready.Ready() || code != http.StatusServiceUnavailable || body != "not ready: keygen unavailable" {
+		t.Fatalf("requirement unmet: want 503 naming it, got %d %q", code, body)
+	}
+	up = true
+	if code, _ := status(); !ready.Ready() || code != http.StatusOK {
+		t.Fatalf("requirement met: want 200, got %d", code)
+	}
+	ready.Set(false)
+	if code, body := status(); ready.Ready() || code != http.StatusServiceUnavailable || body != "not ready" {
+		t.Fatalf("unset: want 503, got %d %q", code, body)
+	}
+}
@@ -0,0 +1,291 @@
+// Package loadgen sends a mix of traffic modelled on the shortener's
+// against a deployed stack, to check its capacity before launches: link
+// creates, reads of a few hot links, chosen by a Zipf distribution as
+// popular links are, and reads of random keys that miss every cache. It
+// sends requests at a fixed rate whatever the latency, as users do, and
+// reports latency percentiles and error rates per kind of request.
+package loadgen
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Config is the traffic to send.
+type Config struct {
+	// WriterURL is the base URL the writer answers /write/ on, and
+	// ReaderURL the one short links are served on; empty uses WriterURL.
+	WriterURL string
+	ReaderURL string
+	// APIKey is sent as X-API-Key and Token as a bearer token on writes.
+	APIKey string
+	Token  string
+
+	// Rate is the requests sent per second, over Duration.
+	Rate     float64
+	Duration time.Duration
+	// Workers bounds the requests in flight; arrivals finding every worker
+	// busy are dropped and counted, as the load generator, not the
+	// service, is then the bottleneck.
+	Workers int
+	Mix     Mix
+	// HotKeys is how many hot links are created before the run, and
+	// HotSkew the exponent of their Zipf distribution, above 1: the
+	// larger, the more reads go to the hottest.
+	HotKeys int
+	HotSkew float64
+	// Target is the URL links redirect to; LinkTTL is how long they live,
+	// so the janitor removes them.
+	Target  string
+	LinkTTL time.Duration
+
+	// Client makes the requests; nil uses one with a 10s timeout. It must
+	// not follow redirects.
+	Client *http.Client
+}
+
+// Defaults.
+const (
+	DefaultWorkers = 64
+	DefaultHotKeys = 100
+	DefaultHotSkew = 1.2
+	DefaultLinkTTL = 24 * time.Hour
+)
+
+func (cfg *Config) setDefaults() error {
+	if cfg.WriterURL == "" {
+		return errors.New("loadgen: WriterURL is required")
+	}
+	if cfg.Rate <= 0 || cfg.Duration <= 0 {
+		return errors.New("loadgen: Rate and Duration must be positive")
+	}
+	if cfg.Mix.total() == 0 {
+		return errors.New("loadgen: Mix sends nothing")
+	}
+	if _, err := url.Parse(cfg.Target); err != nil || !strings.HasPrefix(cfg.Target, "http") {
+		return fmt.Errorf("loadgen: invalid Target %q", cfg.Target)
+	}
+	if cfg.ReaderURL == "" {
+		cfg.ReaderURL = cfg.WriterURL
+	}
+	cfg.WriterURL = strings.TrimSuffix(cfg.WriterURL, "/")
+	cfg.ReaderURL = strings.TrimSuffix(cfg.ReaderURL, "/")
+	if cfg.Workers <= 0 {
+		cfg.Workers = DefaultWorkers
+	}
+	if cfg.HotKeys <= 0 {
+		cfg.HotKeys = DefaultHotKeys
+	}
+	if cfg.HotSkew <= 1 {
+		cfg.HotSkew = DefaultHotSkew
+	}
+	if cfg.LinkTTL <= 0 {
+		cfg.LinkTTL = DefaultLinkTTL
+	}
+	if cfg.Client == nil {
+		cfg.Client = &http.Client{
+			Timeout:       10 * time.Second,
+			CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+			Transport:     &http.Transport{MaxIdleConnsPerHost: cfg.Workers},
+		}
+	}
+	return nil
+}
+
+// Run creates the hot links, unless the mix reads none, then sends the
+// traffic until Duration passes or ctx is done, and reports on it.
+func Run(ctx context.Context, cfg Config) (Report, error) {
+	if err := cfg.setDefaults(); err != nil {
+		return Report{}, err
+	}
+	l := &loader{cfg: cfg, stats: make(map[Op]*opRecorder)}
+	for _, op := range ops {
+		l.stats[op] = &opRecorder{failures: make(map[string]int)}
+	}
+	if cfg.Mix[Hot] > 0 {
+		if err := l.createHotKeys(ctx); err != nil {
+			return Report{}, err
+		}
+	}
+
+	arrivals := make(chan struct{}, cfg.Workers)
+	var wg sync.WaitGroup
+	for i := range cfg.Workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			l.work(ctx, arrivals, uint64(i))
+		}()
+	}
+	start := time.Now()
+	dropped := l.dispatch(ctx, arrivals)
+	close(arrivals)
+	wg.Wait()
+	return l.report(time.Since(start), dropped), nil
+}
+
+// loader is the state of a run.
+type loader struct {
+	cfg   Config
+	hot   []string
+	stats map[Op]*opRecorder
+}
+
+// createHotKeys creates the hot links, a few at a time.
+func (l *loader) createHotKeys(ctx context.Context) error {
+	l.hot = make([]string, l.cfg.HotKeys)
+	g := make(chan error, l.cfg.HotKeys)
+	sem := make(chan struct{}, min(l.cfg.Workers, 16))
+	for i := range l.hot {
+		sem <- struct{}{}
+		go func() {
+			defer func() { <-sem }()
+			key, status, err := l.create(ctx, "hot-"+strconv.Itoa(i))
+			if err == nil && key == "" {
+				err = fmt.Errorf("create: status %d", status)
+			}
+			l.hot[i] = key
+			g <- err
+		}()
+	}
+	var errs []error
+	for range l.hot {
+		if err := <-g; err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("loadgen: creating the hot links: %d failed, e.g. %w", len(errs), errs[0])
+	}
+	return nil
+}
+
+// dispatch schedules an arrival every 1/Rate seconds until the run ends,
+// returning the arrivals dropped.
+func (l *loader) dispatch(ctx context.Context, arrivals chan<- struct{}) int {
+	interval := time.Duration(float64(time.Second) / l.cfg.Rate)
+	start := time.Now()
+	end := start.Add(l.cfg.Duration)
+	dropped := 0
+	for next := start; next.Before(end); next = next.Add(interval) {
+		if d := time.Until(next); d > 0 {
+			select {
+			case <-ctx.Done():
+				return dropped
+			case <-time.After(d):
+			}
+		}
+		select {
+		case arrivals <- struct{}{}:
+		default:
+			dropped++
+		}
+	}
+	return dropped
+}
+
+// work sends a request of the mix per arrival.
+func (l *loader) work(ctx context.Context, arrivals <-chan struct{}, seed uint64) {
+	r := rand.New(rand.NewPCG(seed, uint64(time.Now().UnixNano())))
+	var zipf *rand.Zipf
+	if len(l.hot) > 0 {
+		zipf = rand.NewZipf(r, l.cfg.HotSkew, 1, uint64(len(l.hot)-1))
+	}
+	for range arrivals {
+		op := l.cfg.Mix.pick(r)
+		start := time.Now()
+		var outcome string
+		switch op {
+		case Write:
+			_, status, err := l.create(ctx, "")
+			outcome = failure(status, err, http.StatusOK, http.StatusAccepted)
+		case Hot:
+			status, err := l.read(ctx, l.hot[zipf.Uint64()])
+			outcome = failure(status, err, http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther, http.StatusTemporaryRedirect, http.StatusPermanentRedirect)
+		case Miss:
+			status, err := l.read(ctx, "loadgen-miss-"+strconv.FormatUint(r.Uint64(), 36))
+			outcome = failure(status, err, http.StatusNotFound)
+		}
+		l.stats[op].record(time.Since(start), outcome)
+	}
+}
+
+// failure names what went wrong with a request: its status when not one
+// of want, "error" when it got no answer, or "" when it succeeded.
+func failure(status int, err error, want ...int) string {
+	switch {
+	case err != nil:
+		return "error"
+	case slices.Contains(want, status):
+		return ""
+	}
+	return strconv.Itoa(status)
+}
+
+type createRequest struct {
+	URLTarget string    `json:"url_target"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// create creates a link to Target, with query q, and returns its key.
+func (l *loader) create(ctx context.Context, q string) (string, int, error) {
+	target := l.cfg.Target
+	if q != "" {
+		target += "?loadgen=" + q
+	}
+	body, err := json.Marshal(createRequest{URLTarget: target, ExpiresAt: time.Now().Add(l.cfg.LinkTTL).UTC()})
+	if err != nil {
+		return "", 0, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, l.cfg.WriterURL+"/write/v1", bytes.NewReader(body))
+	if err != nil {
+		return "", 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if l.cfg.APIKey != "" {
+		req.Header.Set("X-API-Key", l.cfg.APIKey)
+	}
+	if l.cfg.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+l.cfg.Token)
+	}
+	resp, err := l.cfg.Client.Do(req)
+	if err != nil {
+		return "", 0, err
+	}
+	defer resp.Body.Close()
+	var created struct {
+		URLKey string `json:"url_key"`
+	}
+	if resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusAccepted {
+		json.NewDecoder(resp.Body).Decode(&created)
+	}
+	io.Copy(io.Discard, resp.Body)
+	return created.URLKey, resp.StatusCode, nil
+}
+
+// read looks key up in the reader.
+func (l *loader) read(ctx context.Context, key string) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, l.cfg.ReaderURL+"/"+key, nil)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := l.cfg.Client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	return resp.StatusCode, nil
+}
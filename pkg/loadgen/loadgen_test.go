@@ -0,0 +1,141 @@
+package loadgen
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/FlorinBalint/shortener/pkg/reader"
+	"github.com/FlorinBalint/shortener/pkg/urlstore"
+	"github.com/FlorinBalint/shortener/pkg/writer"
+)
+
+// stack serves a writer and a reader sharing a store, as behind the load
+// balancer, with every write after the first failing returning 503s.
+func stack(t *testing.T, failingWrites int64) *httptest.Server {
+	store := urlstore.NewMemoryClient()
+	var n atomic.Int64
+	w := writer.NewHandlerWithStore(writer.Config{KeyGenerator: func(context.Context) (string, error) {
+		return "load" + strconv.FormatInt(n.Add(1), 36), nil
+	}}, store)
+	r := reader.NewHandlerWithStore(reader.Config{}, store)
+	var writes atomic.Int64
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if !strings.HasPrefix(req.URL.Path, "/write/") {
+			r.ServeHTTP(rw, req)
+			return
+		}
+		if failingWrites > 0 && writes.Add(1) > 5 && writes.Load() <= 5+failingWrites {
+			http.Error(rw, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		w.ServeHTTP(rw, req)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestRun(t *testing.T) {
+	srv := stack(t, 0)
+	report, err := Run(context.Background(), Config{
+		WriterURL: srv.URL,
+		Rate:      200,
+		Duration:  500 * time.Millisecond,
+		Mix:       Mix{Write: 1, Hot: 2, Miss: 1},
+		HotKeys:   5,
+		Target:    "https://example.com/load",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out strings.Builder
+	report.Print(&out)
+	total := 0
+	for _, st := range report.Ops {
+		total += st.Requests
+		if st.Errors > 0 {
+			t.Errorf("%s: want no errors, got %v", st.Op, st.Failures)
+		}
+		if st.P50 > st.P99 || st.P99 > st.Max {
+			t.Errorf("%s: percentiles out of order: %+v", st.Op, st)
+		}
+	}
+	if len(report.Ops) != 3 || total < 80 {
+		t.Errorf("want about 100 requests:\n%s", out.String())
+	}
+
+	var b strings.Builder
+	if err := report.WriteJSON(&b); err != nil {
+		t.Fatal(err)
+	}
+	var decoded struct {
+		Duration string    `json:"duration"`
+		Ops      []OpStats `json:"ops"`
+	}
+	if err := json.Unmarshal([]byte(b.String()), &decoded); err != nil || decoded.Duration == "" || len(decoded.Ops) != 3 {
+		t.Errorf("WriteJSON = %s (%v)", b.String(), err)
+	}
+}
+
+func TestRun_Errors(t *testing.T) {
+	// The five hot links are created, then ten writes fail.
+	srv := stack(t, 10)
+	report, err := Run(context.Background(), Config{
+		WriterURL: srv.URL,
+		Rate:      200,
+		Duration:  200 * time.Millisecond,
+		Mix:       Mix{Write: 1, Hot: 1},
+		HotKeys:   5,
+		Target:    "https://example.com/load",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.OK(0) {
+		t.Fatal("want failed writes")
+	}
+	for _, st := range report.Ops {
+		switch st.Op {
+		case Write:
+			if st.Failures["503"] != 10 || st.Errors != 10 {
+				t.Errorf("writes: want ten 503s, got %+v", st)
+			}
+		case Hot:
+			if st.Errors != 0 {
+				t.Errorf("hot reads: want no errors, got %+v", st)
+			}
+		}
+	}
+
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer down.Close()
+	if _, err := Run(context.Background(), Config{WriterURL: down.URL, Rate: 1, Duration: time.Second, Mix: Mix{Hot: 1}, HotKeys: 3, Target: "https://example.com/"}); err == nil {
+		t.Error("want the hot links failing to be created reported")
+	}
+	if _, err := Run(context.Background(), Config{WriterURL: srv.URL, Rate: 1, Duration: time.Second, Mix: Mix{Hot: 1}, Target: "not a url"}); err == nil {
+		t.Error("want an invalid target refused")
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	var d []time.Duration
+	for i := 1; i <= 100; i++ {
+		d = append(d, time.Duration(i)*time.Millisecond)
+	}
+	for p, want := range map[float64]time.Duration{0.5: 50 * time.Millisecond, 0.99: 99 * time.Millisecond, 1: 100 * time.Millisecond} {
+		if got := percentile(d, p); got != want {
+			t.Errorf("p%v = %v, want %v", p, got, want)
+		}
+	}
+	if got := percentile(d[:1], 0.99); got != time.Millisecond {
+		t.Errorf("one sample: p99 = %v", got)
+	}
+}
@@ -0,0 +1,79 @@
+package loadgen
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"strconv"
+	"strings"
+)
+
+// Op is a kind of request in the traffic mix.
+type Op string
+
+// Ops.
+const (
+	// Write creates a link with a generated key.
+	Write Op = "write"
+	// Hot reads one of the hot links, the popular ones chosen most often.
+	Hot Op = "hot"
+	// Miss reads a random key that does not exist.
+	Miss Op = "miss"
+)
+
+// ops lists the ops in report order.
+var ops = []Op{Write, Hot, Miss}
+
+// Mix weighs the ops of the traffic.
+type Mix map[Op]int
+
+// ParseMix parses "write=1,hot=8,miss=1"; ops left out are not sent.
+func ParseMix(s string) (Mix, error) {
+	m := make(Mix)
+	for part := range strings.SplitSeq(s, ",") {
+		name, weight, ok := strings.Cut(strings.TrimSpace(part), "=")
+		op := Op(name)
+		if !ok || (op != Write && op != Hot && op != Miss) {
+			return nil, fmt.Errorf("mix: %q is not write=N, hot=N or miss=N", part)
+		}
+		n, err := strconv.Atoi(weight)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("mix: weight of %s must be a number, 0 or more", op)
+		}
+		m[op] = n
+	}
+	if m.total() == 0 {
+		return nil, fmt.Errorf("mix: %q sends nothing", s)
+	}
+	return m, nil
+}
+
+func (m Mix) total() int {
+	t := 0
+	for _, w := range m {
+		t += w
+	}
+	return t
+}
+
+// pick draws an op by weight.
+func (m Mix) pick(r *rand.Rand) Op {
+	n := r.IntN(m.total())
+	for _, op := range ops {
+		if n < m[op] {
+			return op
+		}
+		n -= m[op]
+	}
+	panic("unreachable")
+}
+
+// String formats m as ParseMix takes it.
+func (m Mix) String() string {
+	var parts []string
+	for _, op := range ops {
+		if m[op] > 0 {
+			parts = append(parts, string(op)+"="+strconv.Itoa(m[op]))
+		}
+	}
+	return strings.Join(parts, ",")
+}
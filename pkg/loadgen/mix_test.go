@@ -0,0 +1,36 @@
+package loadgen
+
+import (
+	"math/rand/v2"
+	"testing"
+)
+
+func TestParseMix(t *testing.T) {
+	m, err := ParseMix("write=1, hot=8,miss=0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m[Write] != 1 || m[Hot] != 8 || m[Miss] != 0 {
+		t.Errorf("ParseMix = %v", m)
+	}
+	if got := m.String(); got != "write=1,hot=8" {
+		t.Errorf("String = %q", got)
+	}
+	for _, s := range []string{"", "read=1", "hot", "hot=-1", "hot=x", "hot=0,miss=0"} {
+		if _, err := ParseMix(s); err == nil {
+			t.Errorf("%q: want an error", s)
+		}
+	}
+}
+
+func TestMix_Pick(t *testing.T) {
+	m := Mix{Write: 1, Hot: 3}
+	r := rand.New(rand.NewPCG(1, 2))
+	n := map[Op]int{}
+	for range 4000 {
+		n[m.pick(r)]++
+	}
+	if n[Miss] != 0 || n[Hot] < 2700 || n[Hot] > 3300 {
+		t.Errorf("picked %v, want about 1000 writes and 3000 hot reads", n)
+	}
+}
@@ -0,0 +1,144 @@
+package loadgen
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// opRecorder records the requests of an op.
+type opRecorder struct {
+	mu        sync.Mutex
+	latencies []time.Duration
+	failures  map[string]int
+}
+
+func (r *opRecorder) record(took time.Duration, failure string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.latencies = append(r.latencies, took)
+	if failure != "" {
+		r.failures[failure]++
+	}
+}
+
+// OpStats sums up the requests of an op. Latencies are in milliseconds,
+// failed requests included.
+type OpStats struct {
+	Op        Op      `json:"op"`
+	Requests  int     `json:"requests"`
+	Errors    int     `json:"errors"`
+	ErrorRate float64 `json:"error_rate"`
+	// Failures counts the failed requests by status, or "error" for those
+	// without an answer.
+	Failures map[string]int `json:"failures,omitempty"`
+	P50      float64        `json:"p50_ms"`
+	P90      float64        `json:"p90_ms"`
+	P99      float64        `json:"p99_ms"`
+	Max      float64        `json:"max_ms"`
+}
+
+// Report sums up a run.
+type Report struct {
+	Duration time.Duration `json:"duration"`
+	// Rate is the rate asked for and Achieved the one sent.
+	Rate     float64 `json:"rate"`
+	Achieved float64 `json:"achieved_rate"`
+	// Dropped counts arrivals finding every worker busy.
+	Dropped int       `json:"dropped"`
+	Ops     []OpStats `json:"ops"`
+}
+
+func (l *loader) report(took time.Duration, dropped int) Report {
+	r := Report{Duration: took, Rate: l.cfg.Rate, Dropped: dropped}
+	total := 0
+	for _, op := range ops {
+		rec := l.stats[op]
+		if len(rec.latencies) == 0 {
+			continue
+		}
+		slices.Sort(rec.latencies)
+		st := OpStats{Op: op, Requests: len(rec.latencies), Failures: rec.failures}
+		for _, n := range rec.failures {
+			st.Errors += n
+		}
+		st.ErrorRate = float64(st.Errors) / float64(st.Requests)
+		st.P50 = millis(percentile(rec.latencies, 0.50))
+		st.P90 = millis(percentile(rec.latencies, 0.90))
+		st.P99 = millis(percentile(rec.latencies, 0.99))
+		st.Max = millis(rec.latencies[len(rec.latencies)-1])
+		r.Ops = append(r.Ops, st)
+		total += st.Requests
+	}
+	if took > 0 {
+		r.Achieved = float64(total) / took.Seconds()
+	}
+	return r
+}
+
+// percentile returns the p-th percentile of sorted, by the nearest rank.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	i := int(p*float64(len(sorted))+0.5) - 1
+	return sorted[max(0, min(i, len(sorted)-1))]
+}
+
+func millis(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+// OK reports whether no op failed more often than maxErrorRate, and no
+// arrival was dropped.
+func (r Report) OK(maxErrorRate float64) bool {
+	for _, st := range r.Ops {
+		if st.ErrorRate > maxErrorRate {
+			return false
+		}
+	}
+	return r.Dropped == 0
+}
+
+// Print writes the report as a table.
+func (r Report) Print(w io.Writer) {
+	fmt.Fprintf(w, "%v at %.1f/s (asked %.1f/s), %d dropped\n", r.Duration.Round(time.Millisecond), r.Achieved, r.Rate, r.Dropped)
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "op\trequests\terrors\tp50 ms\tp90 ms\tp99 ms\tmax ms\tfailures\t")
+	for _, st := range r.Ops {
+		fmt.Fprintf(tw, "%s\t%d\t%.2f%%\t%.1f\t%.1f\t%.1f\t%.1f\t%v\t\n",
+			st.Op, st.Requests, 100*st.ErrorRate, st.P50, st.P90, st.P99, st.Max, failures(st.Failures))
+	}
+	tw.Flush()
+}
+
+// failures formats failure counts as "429:3 error:1", by count.
+func failures(m map[string]int) string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.SortFunc(keys, func(a, b string) int { return m[b] - m[a] })
+	s := ""
+	for i, k := range keys {
+		if i > 0 {
+			s += " "
+		}
+		s += fmt.Sprintf("%s:%d", k, m[k])
+	}
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// WriteJSON writes the report as JSON.
+func (r Report) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(struct {
+		Report
+		Duration string `json:"duration"`
+	}{r, r.Duration.String()})
+}
@@ -0,0 +1,167 @@
+// Package loadshed bounds the requests a server works on at once. Requests
+// beyond the bound wait in a short queue; when the queue is full, or a
+// request waited too long, it is shed with 503 right away. Overload then
+// costs the excess requests a fast failure instead of costing every request
+// its latency, and the number of busy goroutines stays bounded.
+package loadshed
+
+import (
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Config bounds the requests of one limiter.
+//
+// MaxInFlight requests are served at once; 0 disables the limiter. Up to
+// MaxQueue more wait for a slot, for at most QueueTimeout (default 100ms).
+type Config struct {
+	MaxInFlight  int
+	MaxQueue     int
+	QueueTimeout time.Duration
+}
+
+// Enabled reports whether the config limits anything.
+func (c Config) Enabled() bool {
+	return c.MaxInFlight > 0
+}
+
+// ConfigFromEnv reads <prefix>_MAX_INFLIGHT, <prefix>_MAX_QUEUE (counts)
+// and <prefix>_QUEUE_TIMEOUT (a duration). Malformed values are logged and
+// ignored.
+func ConfigFromEnv(prefix string) Config {
+	return Config{
+		MaxInFlight:  envInt(prefix + "_MAX_INFLIGHT"),
+		MaxQueue:     envInt(prefix + "_MAX_QUEUE"),
+		QueueTimeout: envDuration(prefix + "_QUEUE_TIMEOUT"),
+	}
+}
+
+func envInt(k string) int {
+	v := os.Getenv(k)
+	if v == "" {
+		return 0
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		log.Printf("ignoring invalid %s=%q", k, v)
+		return 0
+	}
+	return n
+}
+
+func envDuration(k string) time.Duration {
+	v := os.Getenv(k)
+	if v == "" {
+		return 0
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		log.Printf("ignoring invalid %s=%q", k, v)
+		return 0
+	}
+	return d
+}
+
+// shedLogEvery rate-limits the log lines reporting shed requests.
+const shedLogEvery = 10 * time.Second
+
+// Limiter bounds the requests served at once. The nil *Limiter limits
+// nothing.
+type Limiter struct {
+	name    string
+	slots   chan struct{}
+	queue   int64
+	timeout time.Duration
+	waiting atomic.Int64
+
+	mu      sync.Mutex
+	shed    int64
+	lastLog time.Time
+}
+
+// New returns a limiter for cfg, named in logs, or nil if cfg is disabled.
+func New(name string, cfg Config) *Limiter {
+	if !cfg.Enabled() {
+		return nil
+	}
+	timeout := cfg.QueueTimeout
+	if timeout <= 0 {
+		timeout = 100 * time.Millisecond
+	}
+	log.Printf("load shedding %s: %d in flight, %d queued for up to %v", name, cfg.MaxInFlight, cfg.MaxQueue, timeout)
+	return &Limiter{
+		name:    name,
+		slots:   make(chan struct{}, cfg.MaxInFlight),
+		queue:   int64(cfg.MaxQueue),
+		timeout: timeout,
+	}
+}
+
+// Acquire waits for a slot to serve r. It returns the function releasing
+// the slot, or reports 503 to the client and returns false when the
+// request is shed.
+func (l *Limiter) Acquire(w http.ResponseWriter, r *http.Request) (release func(), ok bool) {
+	if l == nil {
+		return func() {}, true
+	}
+	select {
+	case l.slots <- struct{}{}:
+		return l.release, true
+	default:
+	}
+	if l.waiting.Add(1) > l.queue {
+		l.waiting.Add(-1)
+		l.reject(w)
+		return nil, false
+	}
+	defer l.waiting.Add(-1)
+	t := time.NewTimer(l.timeout)
+	defer t.Stop()
+	select {
+	case l.slots <- struct{}{}:
+		return l.release, true
+	case <-t.C:
+		l.reject(w)
+	case <-r.Context().Done():
+		// The client is gone; nobody reads the answer.
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	return nil, false
+}
+
+func (l *Limiter) release() {
+	<-l.slots
+}
+
+// Middleware limits the requests reaching next.
+func (l *Limiter) Middleware(next http.Handler) http.Handler {
+	if l == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		release, ok := l.Acquire(w, r)
+		if !ok {
+			return
+		}
+		defer release()
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (l *Limiter) reject(w http.ResponseWriter) {
+	w.Header().Set("Retry-After", "1")
+	http.Error(w, "overloaded, retry later", http.StatusServiceUnavailable)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.shed++
+	if now := time.Now(); now.Sub(l.lastLog) >= shedLogEvery {
+		log.Printf("load shedding %s: shed %d requests since the last report", l.name, l.shed)
+		l.shed, l.lastLog = 0, now
+	}
+}
@@ -0,0 +1,79 @@
+package loadshed
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLimiter(t *testing.T) {
+	l := New("test", Config{MaxInFlight: 1, MaxQueue: 1, QueueTimeout: time.Minute})
+	unblock := make(chan struct{})
+	started := make(chan struct{}, 2)
+	h := l.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-unblock
+	}))
+	serve := func() int {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		return rec.Code
+	}
+
+	var wg sync.WaitGroup
+	codes := make([]int, 2)
+	for i := range codes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			codes[i] = serve()
+		}()
+		if i == 0 {
+			<-started
+		}
+	}
+	// Wait until the second request is queued.
+	for l.waiting.Load() != 1 {
+		time.Sleep(time.Millisecond)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Retry-After") == "" {
+		t.Fatalf("over the queue: want 503 with Retry-After, got %d", rec.Code)
+	}
+
+	close(unblock)
+	wg.Wait()
+	for i, code := range codes {
+		if code != http.StatusOK {
+			t.Errorf("request %d: want 200, got %d", i, code)
+		}
+	}
+}
+
+func TestLimiter_QueueTimeout(t *testing.T) {
+	l := New("test", Config{MaxInFlight: 1, MaxQueue: 10, QueueTimeout: 10 * time.Millisecond})
+	release, ok := l.Acquire(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	if !ok {
+		t.Fatal("first request shed")
+	}
+	defer release()
+	rec := httptest.NewRecorder()
+	if _, ok := l.Acquire(rec, httptest.NewRequest(http.MethodGet, "/", nil)); ok || rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("queued past the timeout: want 503, got %d", rec.Code)
+	}
+}
+
+func TestLimiter_Disabled(t *testing.T) {
+	l := New("test", Config{})
+	if l != nil {
+		t.Fatal("want no limiter without MaxInFlight")
+	}
+	release, ok := l.Acquire(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	if !ok {
+		t.Fatal("nil limiter shed a request")
+	}
+	release()
+}
@@ -0,0 +1,306 @@
+// Package machineclaim keeps a registry of the (cluster, machine) ID pairs
+// live keygens run with. Two keygens with the same pair mint the same IDs,
+// so each claims its pair at startup and keeps the claim alive with
+// heartbeats; a claim whose heartbeat is older than the TTL is abandoned
+// and may be taken over. The claims double as an inventory of the ID
+// generators, live and stale.
+package machineclaim
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/FlorinBalint/shortener/pkg/gcputil"
+)
+
+// Errors returned by Acquire and Allocate.
+var (
+	ErrDuplicate = errors.New("cluster and machine IDs in use by another pod")
+	ErrExhausted = errors.New("no free machine ID in the cluster")
+)
+
+// Claim is a pair held by a pod.
+type Claim struct {
+	ClusterID int       `json:"cluster_id"`
+	MachineID int       `json:"machine_id"`
+	Pod       string    `json:"pod"`
+	Zone      string    `json:"zone,omitempty"`
+	Heartbeat time.Time `json:"heartbeat"`
+}
+
+// name is the entity name of the claim's pair.
+func (c Claim) name() string {
+	return strconv.Itoa(c.ClusterID) + "-" + strconv.Itoa(c.MachineID)
+}
+
+// Store keeps the claims.
+type Store interface {
+	// Update transactionally applies update to the claim on c's pair, the
+	// zero Claim when there is none. If update returns an error, nothing
+	// is written and that error is returned.
+	Update(ctx context.Context, c Claim, update func(cur *Claim) error) error
+	// List returns every claim.
+	List(ctx context.Context) ([]Claim, error)
+	// DeleteIf transactionally deletes the claim on c's pair if cond holds
+	// for it, and reports whether it did.
+	DeleteIf(ctx context.Context, c Claim, cond func(Claim) bool) (bool, error)
+}
+
+// Acquire claims c's pair for c.Pod. It fails with ErrDuplicate when
+// another pod heartbeat the pair less than ttl ago; a restarted pod keeps
+// its name and so reclaims its own pair at once.
+func Acquire(ctx context.Context, s Store, c Claim, ttl time.Duration) error {
+	return s.Update(ctx, c, func(cur *Claim) error {
+		now := time.Now().UTC()
+		if cur.Pod != "" && cur.Pod != c.Pod && now.Sub(cur.Heartbeat) < ttl {
+			log.Printf("machine claim: conflict: %s refused cluster %d, machine %d, held by %s",
+				c.Pod, c.ClusterID, c.MachineID, cur.Pod)
+			return fmt.Errorf("%w: cluster %d, machine %d held by %s (heartbeat %s ago)",
+				ErrDuplicate, c.ClusterID, c.MachineID, cur.Pod, now.Sub(cur.Heartbeat).Round(time.Second))
+		}
+		if cur.Pod != "" && cur.Pod != c.Pod {
+			log.Printf("machine claim: taking over cluster %d, machine %d from %s, silent since %v",
+				c.ClusterID, c.MachineID, cur.Pod, cur.Heartbeat)
+		}
+		*cur = c
+		cur.Heartbeat = now
+		return nil
+	})
+}
+
+// Allocate claims for c.Pod the lowest machine ID below limit in
+// c.ClusterID that no live pod holds, reclaiming abandoned ones, and
+// returns the claim. A pod already holding a live claim in the cluster
+// keeps its machine ID. It fails with ErrExhausted when all limit IDs are
+// held. Allocation replaces the StatefulSet ordinal as the machine ID, so
+// keygen can run as a plain Deployment.
+func Allocate(ctx context.Context, s Store, c Claim, limit int, ttl time.Duration) (Claim, error) {
+	claims, err := s.List(ctx)
+	if err != nil {
+		return Claim{}, err
+	}
+	now := time.Now().UTC()
+	held := make(map[int]bool)
+	for _, cur := range claims {
+		if cur.ClusterID != c.ClusterID || now.Sub(cur.Heartbeat) >= ttl {
+			continue
+		}
+		if cur.Pod == c.Pod && cur.MachineID < limit {
+			c.MachineID = cur.MachineID
+			return c, Acquire(ctx, s, c, ttl)
+		}
+		held[cur.MachineID] = true
+	}
+	for m := 0; m < limit; m++ {
+		if held[m] {
+			continue
+		}
+		c.MachineID = m
+		err := Acquire(ctx, s, c, ttl)
+		if errors.Is(err, ErrDuplicate) {
+			// Another pod allocated it since the listing.
+			continue
+		}
+		if err != nil {
+			return Claim{}, err
+		}
+		return c, nil
+	}
+	return Claim{}, fmt.Errorf("%w: all %d held", ErrExhausted, limit)
+}
+
+// Keep renews c's claim every interval until ctx is done, when it returns
+// nil. It returns ErrDuplicate if another pod took the pair over, as after
+// heartbeats failed for longer than ttl; other errors are logged and
+// retried at the next beat.
+func Keep(ctx context.Context, s Store, c Claim, interval, ttl time.Duration) error {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-t.C:
+		}
+		err := Acquire(ctx, s, c, ttl)
+		if errors.Is(err, ErrDuplicate) {
+			return err
+		}
+		if err != nil && ctx.Err() == nil {
+			log.Printf("machine claim: heartbeat failed: %v", err)
+		}
+	}
+}
+
+// Generator statuses in an inventory.
+const (
+	StatusLive  = "live"
+	StatusStale = "stale"
+	// StatusConflict marks the live claims of a pod holding more than one,
+	// as when its zone, and so its cluster ID, changed under it.
+	StatusConflict = "conflict"
+)
+
+// Generator is a claim in an inventory.
+type Generator struct {
+	Claim
+	Status string `json:"status"`
+}
+
+// Inventory orders claims by cluster and machine and tells live claims,
+// heartbeat within ttl of now, from stale ones.
+func Inventory(claims []Claim, ttl time.Duration, now time.Time) []Generator {
+	live := make(map[string]int)
+	for _, c := range claims {
+		if now.Sub(c.Heartbeat) < ttl {
+			live[c.Pod]++
+		}
+	}
+	gens := make([]Generator, 0, len(claims))
+	for _, c := range claims {
+		g := Generator{Claim: c, Status: StatusStale}
+		if now.Sub(c.Heartbeat) < ttl {
+			g.Status = StatusLive
+			if live[c.Pod] > 1 {
+				g.Status = StatusConflict
+			}
+		}
+		gens = append(gens, g)
+	}
+	sort.Slice(gens, func(i, j int) bool {
+		if gens[i].ClusterID != gens[j].ClusterID {
+			return gens[i].ClusterID < gens[j].ClusterID
+		}
+		return gens[i].MachineID < gens[j].MachineID
+	})
+	return gens
+}
+
+// Audit logs the conflicting and stale claims, for log-based alerts, and
+// deletes the claims silent for longer than retention, as those of pods
+// scaled away; 0 keeps them. It returns the inventory before deleting.
+func Audit(ctx context.Context, s Store, ttl, retention time.Duration) ([]Generator, error) {
+	claims, err := s.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	gens := Inventory(claims, ttl, now)
+	for _, g := range gens {
+		switch {
+		case g.Status == StatusConflict:
+			log.Printf("machine claim: conflict: %s holds cluster %d, machine %d among others",
+				g.Pod, g.ClusterID, g.MachineID)
+		case g.Status == StatusStale && retention > 0 && now.Sub(g.Heartbeat) > retention:
+			cutoff := now.Add(-retention)
+			deleted, err := s.DeleteIf(ctx, g.Claim, func(cur Claim) bool { return cur.Heartbeat.Before(cutoff) })
+			if err != nil {
+				return gens, err
+			}
+			if deleted {
+				log.Printf("machine claim: deleted cluster %d, machine %d of %s, silent since %v",
+					g.ClusterID, g.MachineID, g.Pod, g.Heartbeat)
+			}
+		case g.Status == StatusStale:
+			log.Printf("machine claim: stale: cluster %d, machine %d of %s, silent since %v",
+				g.ClusterID, g.MachineID, g.Pod, g.Heartbeat)
+		}
+	}
+	return gens, nil
+}
+
+// DSStore keeps claims in Datastore, as entities of kind "machine_claim"
+// named "<cluster>-<machine>".
+type DSStore struct {
+	client *gcputil.DSClient
+}
+
+var _ Store = (*DSStore)(nil)
+
+func NewDSStore(client *gcputil.DSClient) *DSStore {
+	return &DSStore{client: client}
+}
+
+const kind = "machine_claim"
+
+// Update implements Store.
+func (s *DSStore) Update(ctx context.Context, c Claim, update func(cur *Claim) error) error {
+	return gcputil.UpsertValue(s.client, ctx, kind, c.name(), update)
+}
+
+// List implements Store.
+func (s *DSStore) List(ctx context.Context) ([]Claim, error) {
+	var claims []Claim
+	q := gcputil.ListQuery{Limit: 500}
+	for {
+		values, next, err := gcputil.ListValues[Claim](s.client, ctx, kind, q)
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range values {
+			claims = append(claims, v.Value)
+		}
+		if next == "" {
+			return claims, nil
+		}
+		q.Cursor = next
+	}
+}
+
+// DeleteIf implements Store.
+func (s *DSStore) DeleteIf(ctx context.Context, c Claim, cond func(Claim) bool) (bool, error) {
+	return gcputil.DeleteValueIf(s.client, ctx, kind, c.name(), cond)
+}
+
+// MemoryStore is an in-process Store, for tests.
+type MemoryStore struct {
+	mu     sync.Mutex
+	claims map[string]Claim
+}
+
+var _ Store = (*MemoryStore)(nil)
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{claims: make(map[string]Claim)}
+}
+
+// Update implements Store.
+func (s *MemoryStore) Update(ctx context.Context, c Claim, update func(cur *Claim) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cur := s.claims[c.name()]
+	if err := update(&cur); err != nil {
+		return err
+	}
+	s.claims[c.name()] = cur
+	return nil
+}
+
+// List implements Store.
+func (s *MemoryStore) List(ctx context.Context) ([]Claim, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	claims := make([]Claim, 0, len(s.claims))
+	for _, c := range s.claims {
+		claims = append(claims, c)
+	}
+	return claims, nil
+}
+
+// DeleteIf implements Store.
+func (s *MemoryStore) DeleteIf(ctx context.Context, c Claim, cond func(Claim) bool) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cur, ok := s.claims[c.name()]
+	if !ok || !cond(cur) {
+		return false, nil
+	}
+	delete(s.claims, c.name())
+	return true, nil
+}
@@ -0,0 +1,149 @@
+package machineclaim
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAcquire(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryStore()
+	ttl := time.Minute
+	a := Claim{ClusterID: 3, MachineID: 1, Pod: "keygen-1"}
+
+	if err := Acquire(ctx, s, a, ttl); err != nil {
+		t.Fatalf("first claim: %v", err)
+	}
+	if err := Acquire(ctx, s, a, ttl); err != nil {
+		t.Fatalf("restarted pod: %v", err)
+	}
+
+	dup := a
+	dup.Pod = "keygen-7"
+	if err := Acquire(ctx, s, dup, ttl); !errors.Is(err, ErrDuplicate) {
+		t.Fatalf("live pair: want ErrDuplicate, got %v", err)
+	}
+
+	other := dup
+	other.MachineID = 7
+	if err := Acquire(ctx, s, other, ttl); err != nil {
+		t.Fatalf("other pair: %v", err)
+	}
+
+	// Once a's heartbeats stop for longer than the TTL, the pair is free.
+	s.claims[a.name()] = Claim{ClusterID: 3, MachineID: 1, Pod: "keygen-1", Heartbeat: time.Now().Add(-2 * ttl)}
+	if err := Acquire(ctx, s, dup, ttl); err != nil {
+		t.Fatalf("abandoned pair: %v", err)
+	}
+	if got := s.claims[a.name()].Pod; got != "keygen-7" {
+		t.Fatalf("want the pair taken over, held by %s", got)
+	}
+}
+
+func TestKeep(t *testing.T) {
+	s := NewMemoryStore()
+	a := Claim{ClusterID: 0, MachineID: 2, Pod: "keygen-2"}
+	if err := Acquire(context.Background(), s, a, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- Keep(ctx, s, a, time.Millisecond, time.Minute) }()
+
+	deadline := time.Now().Add(time.Second)
+	first := s.snapshot(a).Heartbeat
+	for !s.snapshot(a).Heartbeat.After(first) {
+		if time.Now().After(deadline) {
+			t.Fatal("heartbeat not renewed")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// Another pod took the pair over, as after a long network partition.
+	s.mu.Lock()
+	s.claims[a.name()] = Claim{ClusterID: 0, MachineID: 2, Pod: "keygen-9", Heartbeat: time.Now().Add(time.Hour)}
+	s.mu.Unlock()
+	select {
+	case err := <-done:
+		if !errors.Is(err, ErrDuplicate) {
+			t.Fatalf("want ErrDuplicate, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Keep did not notice the pair was lost")
+	}
+	cancel()
+}
+
+func (s *MemoryStore) snapshot(c Claim) Claim {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.claims[c.name()]
+}
+
+func TestAudit(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryStore()
+	now := time.Now().UTC()
+	for _, c := range []Claim{
+		{ClusterID: 2, MachineID: 0, Pod: "keygen-0", Heartbeat: now},
+		{ClusterID: 1, MachineID: 1, Pod: "keygen-1", Heartbeat: now.Add(-time.Hour)},
+		{ClusterID: 1, MachineID: 4, Pod: "keygen-4", Heartbeat: now.Add(-72 * time.Hour)},
+		{ClusterID: 3, MachineID: 0, Pod: "keygen-0", Heartbeat: now},
+	} {
+		s.claims[c.name()] = c
+	}
+
+	gens, err := Audit(ctx, s, time.Minute, 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, g := range gens {
+		got = append(got, g.name()+" "+g.Status)
+	}
+	want := []string{"1-1 stale", "1-4 stale", "2-0 conflict", "3-0 conflict"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Fatalf("want %v, got %v", want, got)
+	}
+	if _, ok := s.claims["1-4"]; ok {
+		t.Fatal("want the claim silent past retention deleted")
+	}
+	if _, ok := s.claims["1-1"]; !ok {
+		t.Fatal("want the recently stale claim kept")
+	}
+}
+
+func TestAllocate(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryStore()
+	ttl := time.Minute
+	alloc := func(pod string) (int, error) {
+		c, err := Allocate(ctx, s, Claim{ClusterID: 5, Pod: pod}, 3, ttl)
+		return c.MachineID, err
+	}
+
+	for i, pod := range []string{"keygen-a", "keygen-b", "keygen-c"} {
+		if m, err := alloc(pod); err != nil || m != i {
+			t.Fatalf("%s: want machine %d, got %d, %v", pod, i, m, err)
+		}
+	}
+	if m, err := alloc("keygen-b"); err != nil || m != 1 {
+		t.Fatalf("restarted pod: want its machine 1 kept, got %d, %v", m, err)
+	}
+	if _, err := alloc("keygen-d"); !errors.Is(err, ErrExhausted) {
+		t.Fatalf("full cluster: want ErrExhausted, got %v", err)
+	}
+	if err := Acquire(ctx, s, Claim{ClusterID: 6, MachineID: 0, Pod: "keygen-e"}, ttl); err != nil {
+		t.Fatal(err)
+	}
+
+	// keygen-a's lease expired: its machine ID is the lowest free one again.
+	s.claims["5-0"] = Claim{ClusterID: 5, MachineID: 0, Pod: "keygen-a", Heartbeat: time.Now().Add(-2 * ttl)}
+	if m, err := alloc("keygen-d"); err != nil || m != 0 {
+		t.Fatalf("want the expired lease reclaimed, got %d, %v", m, err)
+	}
+}
@@ -0,0 +1,184 @@
+// Package migrate rewrites stored entries to the current schema version,
+// running urlstore.Migrations over the whole store with checkpointing, so
+// an interrupted run resumes where it stopped.
+package migrate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+
+	"cloud.google.com/go/datastore"
+
+	"github.com/FlorinBalint/shortener/pkg/gcputil"
+	"github.com/FlorinBalint/shortener/pkg/urlstore"
+)
+
+// Checkpoint is the progress of a run.
+type Checkpoint struct {
+	// Cursor is the list cursor after the last page done.
+	Cursor string `json:"cursor,omitempty"`
+	// Done is set once the whole store has been migrated.
+	Done bool `json:"done,omitempty"`
+}
+
+// Checkpoints persists run progress by name.
+type Checkpoints interface {
+	// Load returns the named checkpoint, or the zero Checkpoint if none.
+	Load(ctx context.Context, name string) (Checkpoint, error)
+	Save(ctx context.Context, name string, cp Checkpoint) error
+}
+
+// Config configures a Runner.
+//
+// BatchSize is the number of entries scanned per store page (default 500).
+// With DryRun set, entries needing migration are only counted and no
+// checkpoint is written. Restart ignores the saved checkpoint.
+type Config struct {
+	BatchSize int
+	DryRun    bool
+	Restart   bool
+}
+
+// Result summarizes a run.
+type Result struct {
+	Scanned  int
+	Migrated int
+	// Skipped counts entries that cannot be rewritten: expired or deleted
+	// entries, which the janitor purges, and entries of a newer schema.
+	Skipped int
+	Failed  int
+}
+
+// Runner migrates a store.
+type Runner struct {
+	store       urlstore.Client
+	checkpoints Checkpoints
+	cfg         Config
+}
+
+// New returns a Runner migrating store, with progress kept in checkpoints.
+func New(store urlstore.Client, checkpoints Checkpoints, cfg Config) *Runner {
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = 500
+	}
+	return &Runner{store: store, checkpoints: checkpoints, cfg: cfg}
+}
+
+// checkpointName identifies runs to the current schema version, so a
+// completed run is not mistaken for one to a later version.
+func checkpointName() string {
+	return fmt.Sprintf("url_entry-v%d", urlstore.CurrentSchemaVersion)
+}
+
+// Run scans the store from the last checkpoint and rewrites every entry
+// below the current schema version. Individual failures are logged and
+// counted; a failing scan aborts the run, which resumes from the last
+// completed page when run again.
+func (r *Runner) Run(ctx context.Context) (Result, error) {
+	var res Result
+	name := checkpointName()
+	var cp Checkpoint
+	if !r.cfg.Restart {
+		var err error
+		if cp, err = r.checkpoints.Load(ctx, name); err != nil {
+			return res, fmt.Errorf("loading checkpoint: %w", err)
+		}
+		if cp.Done {
+			log.Printf("migrate: %s already completed", name)
+			return res, nil
+		}
+	}
+
+	opts := urlstore.ListOptions{Limit: r.cfg.BatchSize, IncludeInactive: true, Cursor: cp.Cursor}
+	for {
+		page, err := r.store.ListEntries(ctx, opts)
+		if err != nil {
+			return res, err
+		}
+		for _, ke := range page.Entries {
+			res.Scanned++
+			if ke.Entry.SchemaVersion >= urlstore.CurrentSchemaVersion {
+				if ke.Entry.SchemaVersion > urlstore.CurrentSchemaVersion {
+					res.Skipped++
+				}
+				continue
+			}
+			if r.cfg.DryRun {
+				res.Migrated++
+				continue
+			}
+			r.migrate(ctx, ke.Key, &res)
+		}
+		cp = Checkpoint{Cursor: page.NextCursor, Done: page.NextCursor == ""}
+		if !r.cfg.DryRun {
+			if err := r.checkpoints.Save(ctx, name, cp); err != nil {
+				return res, fmt.Errorf("saving checkpoint: %w", err)
+			}
+		}
+		if cp.Done {
+			return res, nil
+		}
+		opts.Cursor = page.NextCursor
+	}
+}
+
+// migrate rewrites one entry; the store upgrades it on the way.
+func (r *Runner) migrate(ctx context.Context, key urlstore.UrlKey, res *Result) {
+	err := r.store.UpdateEntry(ctx, key, func(*urlstore.URLEntry) error { return nil })
+	switch {
+	case err == nil:
+		res.Migrated++
+	case errors.Is(err, urlstore.ErrNotFound), errors.Is(err, urlstore.ErrNewerSchema):
+		res.Skipped++
+	default:
+		log.Printf("migrate: rewriting %q failed: %v", key, err)
+		res.Failed++
+	}
+}
+
+// checkpointKind is the Datastore kind of checkpoints.
+const checkpointKind = "migration_checkpoint"
+
+// DSCheckpoints keeps checkpoints in Datastore.
+type DSCheckpoints struct {
+	client *gcputil.DSClient
+}
+
+var _ Checkpoints = (*DSCheckpoints)(nil)
+
+// NewDSCheckpoints returns checkpoints stored through client.
+func NewDSCheckpoints(client *gcputil.DSClient) *DSCheckpoints {
+	return &DSCheckpoints{client: client}
+}
+
+// Load implements Checkpoints.
+func (c *DSCheckpoints) Load(ctx context.Context, name string) (Checkpoint, error) {
+	cp, err := gcputil.GetValue[Checkpoint](c.client, ctx, checkpointKind, name)
+	if errors.Is(err, datastore.ErrNoSuchEntity) {
+		return Checkpoint{}, nil
+	}
+	return cp, err
+}
+
+// Save implements Checkpoints.
+func (c *DSCheckpoints) Save(ctx context.Context, name string, cp Checkpoint) error {
+	return c.client.PutJSON(ctx, checkpointKind, name, cp)
+}
+
+// MemoryCheckpoints keeps checkpoints in memory, for tests.
+type MemoryCheckpoints map[string]Checkpoint
+
+var _ Checkpoints = MemoryCheckpoints(nil)
+
+// Load implements Checkpoints.
+func (m MemoryCheckpoints) Load(ctx context.Context, name string) (Checkpoint, error) {
+	return m[name], nil
+}
+
+// Save implements Checkpoints.
+func (m MemoryCheckpoints) Save(ctx context.Context, name string, cp Checkpoint) error {
+	m[name] = cp
+	return nil
+}
@@ -0,0 +1,81 @@
+package migrate
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/FlorinBalint/shortener/pkg/urlstore"
+)
+
+func seed(t *testing.T) *urlstore.MemoryClient {
+	t.Helper()
+	store := urlstore.NewMemoryClient()
+	store.Seed("legacy-a", urlstore.URLEntry{URLTarget: "https://example.com/a"})
+	store.Seed("legacy-b", urlstore.URLEntry{URLTarget: "https://example.com/b", Version: 4})
+	store.Seed("legacy-gone", urlstore.URLEntry{URLTarget: "https://example.com/g", DeletedAt: time.Now()})
+	store.Seed("future", urlstore.URLEntry{URLTarget: "https://example.com/f", SchemaVersion: urlstore.CurrentSchemaVersion + 1})
+	if err := store.CreateEntry(context.Background(), "current", urlstore.URLEntry{URLTarget: "https://example.com/c", Version: 1}); err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+func TestRun(t *testing.T) {
+	store := seed(t)
+	cps := MemoryCheckpoints{}
+
+	dry, err := New(store, cps, Config{DryRun: true}).Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dry.Migrated != 3 || len(cps) != 0 {
+		t.Fatalf("dry run: want 3 to migrate and no checkpoint, got %+v, %v", dry, cps)
+	}
+
+	res, err := New(store, cps, Config{BatchSize: 2}).Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Result{Scanned: 5, Migrated: 2, Skipped: 2}
+	if res != want {
+		t.Fatalf("want %+v, got %+v", want, res)
+	}
+	for key, version := range map[urlstore.UrlKey]int64{"legacy-a": 1, "legacy-b": 4} {
+		e, err := store.GetEntry(context.Background(), key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if e.SchemaVersion != urlstore.CurrentSchemaVersion || e.Version != version {
+			t.Errorf("%s: want schema %d and version %d, got %+v", key, urlstore.CurrentSchemaVersion, version, e)
+		}
+	}
+
+	// A completed run is not repeated.
+	if again, err := New(store, cps, Config{}).Run(context.Background()); err != nil || again.Scanned != 0 {
+		t.Fatalf("rerun: want nothing scanned, got %+v, %v", again, err)
+	}
+}
+
+func TestRun_ResumesFromCheckpoint(t *testing.T) {
+	store := seed(t)
+	first, err := store.ListEntries(context.Background(), urlstore.ListOptions{Limit: 2, IncludeInactive: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cps := MemoryCheckpoints{checkpointName(): {Cursor: first.NextCursor}}
+
+	res, err := New(store, cps, Config{BatchSize: 2}).Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The first page (current, future) was done by an earlier run.
+	if res.Scanned != 3 || !cps[checkpointName()].Done {
+		t.Fatalf("want the remaining 3 entries scanned and the run done, got %+v, %+v", res, cps)
+	}
+
+	res, err = New(store, cps, Config{Restart: true}).Run(context.Background())
+	if err != nil || res.Scanned != 5 || res.Migrated != 0 {
+		t.Fatalf("restart: want all 5 scanned and nothing left to migrate, got %+v, %v", res, err)
+	}
+}
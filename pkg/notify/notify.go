@@ -0,0 +1,209 @@
+// Package notify posts link events matching configured rules to a chat
+// webhook, such as a Slack or Google Chat incoming webhook, so that e.g. a
+// security team learns of links to flagged domains as they are created.
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultQueueSize = 256
+	postTimeout      = 5 * time.Second
+)
+
+// Actions of events.
+const (
+	Created = "created"
+	Deleted = "deleted"
+)
+
+// Event is a link created or deleted.
+type Event struct {
+	Action string
+	Key    string
+	Target string
+	// Owner is the API key owning the link, if any.
+	Owner string
+	// Tenant is the tenant of the link in multi-tenant mode.
+	Tenant string
+	// Custom is set for links created under a custom alias.
+	Custom bool
+	// By describes who acted, e.g. "apikey:k1".
+	By string
+}
+
+// Text renders e as a chat message.
+func (e Event) Text() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Link %s: %s", e.Action, e.Key)
+	if e.Target != "" {
+		fmt.Fprintf(&b, " -> %s", e.Target)
+	}
+	details := []string{"by " + e.By}
+	if e.Owner != "" {
+		details = append(details, "owner "+e.Owner)
+	}
+	if e.Tenant != "" {
+		details = append(details, "tenant "+e.Tenant)
+	}
+	fmt.Fprintf(&b, " (%s)", strings.Join(details, ", "))
+	return b.String()
+}
+
+// Rules select the events notified: an event matching any of them is.
+// Without rules every event is.
+type Rules struct {
+	// Custom matches links created under a custom alias.
+	Custom bool
+	// Owners match links owned by these API keys.
+	Owners []string
+	// Domains match targets on these hosts or their subdomains.
+	Domains []string
+}
+
+// ParseRules parses comma-separated rules: "custom", "owner:ID" and
+// "domain:example.com".
+func ParseRules(s string) (Rules, error) {
+	var rules Rules
+	for _, r := range strings.Split(s, ",") {
+		r = strings.TrimSpace(r)
+		kind, value, _ := strings.Cut(r, ":")
+		switch {
+		case r == "":
+		case r == "custom":
+			rules.Custom = true
+		case kind == "owner" && value != "":
+			rules.Owners = append(rules.Owners, value)
+		case kind == "domain" && value != "":
+			rules.Domains = append(rules.Domains, strings.ToLower(strings.TrimPrefix(value, ".")))
+		default:
+			return Rules{}, fmt.Errorf("invalid notify rule %q", r)
+		}
+	}
+	return rules, nil
+}
+
+func (r Rules) empty() bool {
+	return !r.Custom && len(r.Owners) == 0 && len(r.Domains) == 0
+}
+
+// Match reports whether e is to be notified.
+func (r Rules) Match(e Event) bool {
+	if r.empty() {
+		return true
+	}
+	if r.Custom && e.Custom {
+		return true
+	}
+	for _, o := range r.Owners {
+		if e.Owner == o {
+			return true
+		}
+	}
+	if u, err := url.Parse(e.Target); err == nil {
+		host := strings.ToLower(u.Hostname())
+		for _, d := range r.Domains {
+			if host == d || strings.HasSuffix(host, "."+d) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Notifier posts the events matching its rules in the background. Events
+// notified while the queue is full are dropped rather than delaying the
+// writes.
+type Notifier struct {
+	webhook string
+	rules   Rules
+	client  *http.Client
+	events  chan Event
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// New starts a worker posting the events matching rules to webhook.
+func New(webhook string, rules Rules) *Notifier {
+	ctx, cancel := context.WithCancel(context.Background())
+	n := &Notifier{
+		webhook: webhook,
+		rules:   rules,
+		client:  &http.Client{Timeout: postTimeout},
+		events:  make(chan Event, defaultQueueSize),
+		ctx:     ctx,
+		cancel:  cancel,
+	}
+	n.wg.Add(1)
+	go n.run()
+	return n
+}
+
+// Notify queues e if it matches the rules, and reports whether it did.
+func (n *Notifier) Notify(e Event) bool {
+	if !n.rules.Match(e) {
+		return false
+	}
+	select {
+	case n.events <- e:
+		return true
+	default:
+		log.Printf("notify: queue full, dropping %s of %q", e.Action, e.Key)
+		return false
+	}
+}
+
+// Close stops the worker, abandoning queued events.
+func (n *Notifier) Close() {
+	n.cancel()
+	n.wg.Wait()
+}
+
+func (n *Notifier) run() {
+	defer n.wg.Done()
+	for {
+		select {
+		case <-n.ctx.Done():
+			return
+		case e := <-n.events:
+			if err := n.post(e); err != nil {
+				log.Printf("notify: posting %s of %q: %v", e.Action, e.Key, err)
+			}
+		}
+	}
+}
+
+// post sends e as {"text": ...}, which Slack and Google Chat webhooks
+// both accept.
+func (n *Notifier) post(e Event) error {
+	body, err := json.Marshal(map[string]string{"text": e.Text()})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(n.ctx, http.MethodPost, n.webhook, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook status %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package notify
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseRules(t *testing.T) {
+	r, err := ParseRules("custom, owner:k1,domain:.Evil.example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !r.Custom || len(r.Owners) != 1 || r.Owners[0] != "k1" || len(r.Domains) != 1 || r.Domains[0] != "evil.example" {
+		t.Fatalf("ParseRules = %+v", r)
+	}
+	for _, bad := range []string{"owner:", "domain", "everything"} {
+		if _, err := ParseRules(bad); err == nil {
+			t.Errorf("ParseRules(%q): want an error", bad)
+		}
+	}
+}
+
+func TestRules_Match(t *testing.T) {
+	r := Rules{Custom: true, Owners: []string{"k1"}, Domains: []string{"evil.example"}}
+	tests := []struct {
+		e    Event
+		want bool
+	}{
+		{Event{Key: "promo", Custom: true, Target: "https://example.com"}, true},
+		{Event{Key: "abc", Owner: "k1", Target: "https://example.com"}, true},
+		{Event{Key: "abc", Target: "https://login.EVIL.example/x"}, true},
+		{Event{Key: "abc", Target: "https://notevil.example/x"}, false},
+		{Event{Key: "abc", Owner: "k2", Target: "https://example.com"}, false},
+	}
+	for _, tt := range tests {
+		if got := r.Match(tt.e); got != tt.want {
+			t.Errorf("Match(%+v) = %v, want %v", tt.e, got, tt.want)
+		}
+	}
+	if !(Rules{}).Match(Event{Key: "any"}) {
+		t.Error("empty rules should match every event")
+	}
+}
+
+func TestNotifier_Posts(t *testing.T) {
+	got := make(chan string, 1)
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var msg struct{ Text string }
+		json.NewDecoder(r.Body).Decode(&msg)
+		got <- msg.Text
+	}))
+	defer hook.Close()
+	n := New(hook.URL, Rules{Custom: true})
+	defer n.Close()
+
+	if n.Notify(Event{Action: Created, Key: "abc", By: "apikey:k1"}) {
+		t.Fatal("generated key notified")
+	}
+	if !n.Notify(Event{Action: Created, Key: "promo", Target: "https://example.com", Custom: true, By: "apikey:k1", Owner: "k1"}) {
+		t.Fatal("custom alias not notified")
+	}
+	select {
+	case text := <-got:
+		for _, want := range []string{"Link created: promo -> https://example.com", "by apikey:k1", "owner k1"} {
+			if !strings.Contains(text, want) {
+				t.Errorf("want %q in %q", want, text)
+			}
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no post")
+	}
+}
@@ -0,0 +1,200 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testIssuer struct {
+	srv    *httptest.Server
+	rsaKey *rsa.PrivateKey
+	ecKey  *ecdsa.PrivateKey
+	// fetches counts JWKS downloads.
+	fetches int
+}
+
+func newTestIssuer(t *testing.T) *testIssuer {
+	t.Helper()
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	iss := &testIssuer{rsaKey: rsaKey, ecKey: ecKey}
+	b64 := func(n *big.Int, size int) string {
+		return base64.RawURLEncoding.EncodeToString(n.FillBytes(make([]byte, size)))
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{"jwks_uri": iss.srv.URL + "/jwks"})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		iss.fetches++
+		json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{
+			{"kid": "rsa", "kty": "RSA", "n": b64(rsaKey.N, 256), "e": "AQAB"},
+			{"kid": "ec", "kty": "EC", "crv": "P-256", "x": b64(ecKey.X, 32), "y": b64(ecKey.Y, 32)},
+		}})
+	})
+	iss.srv = httptest.NewServer(mux)
+	t.Cleanup(iss.srv.Close)
+	return iss
+}
+
+func (iss *testIssuer) sign(t *testing.T, alg, kid string, c map[string]any) string {
+	t.Helper()
+	enc := func(v any) string {
+		b, _ := json.Marshal(v)
+		return base64.RawURLEncoding.EncodeToString(b)
+	}
+	signing := enc(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"}) + "." + enc(c)
+	digest := sha256.Sum256([]byte(signing))
+	var sig []byte
+	switch alg {
+	case "RS256":
+		s, err := rsa.SignPKCS1v15(rand.Reader, iss.rsaKey, crypto.SHA256, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		sig = s
+	case "ES256":
+		r, s, err := ecdsa.Sign(rand.Reader, iss.ecKey, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		sig = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	}
+	return signing + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func (iss *testIssuer) claims(overrides map[string]any) map[string]any {
+	c := map[string]any{
+		"iss":   iss.srv.URL,
+		"aud":   "shortener-admin",
+		"sub":   "1234",
+		"email": "ops@example.com",
+		"iat":   time.Now().Unix(),
+		"exp":   time.Now().Add(time.Hour).Unix(),
+	}
+	for k, v := range overrides {
+		c[k] = v
+	}
+	return c
+}
+
+func TestVerify(t *testing.T) {
+	iss := newTestIssuer(t)
+	v := NewVerifier(Config{Issuer: iss.srv.URL, Audience: "shortener-admin"})
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr bool
+	}{
+		{"rs256", iss.sign(t, "RS256", "rsa", iss.claims(nil)), false},
+		{"es256", iss.sign(t, "ES256", "ec", iss.claims(nil)), false},
+		{"audience list", iss.sign(t, "RS256", "rsa", iss.claims(map[string]any{"aud": []string{"other", "shortener-admin"}})), false},
+		{"wrong audience", iss.sign(t, "RS256", "rsa", iss.claims(map[string]any{"aud": "other"})), true},
+		{"wrong issuer", iss.sign(t, "RS256", "rsa", iss.claims(map[string]any{"iss": "https://evil.example"})), true},
+		{"expired", iss.sign(t, "RS256", "rsa", iss.claims(map[string]any{"exp": time.Now().Add(-time.Hour).Unix()})), true},
+		{"not yet valid", iss.sign(t, "RS256", "rsa", iss.claims(map[string]any{"nbf": time.Now().Add(time.Hour).Unix()})), true},
+		{"key mismatch", iss.sign(t, "ES256", "rsa", iss.claims(nil)), true},
+		{"unknown key", iss.sign(t, "RS256", "nope", iss.claims(nil)), true},
+		{"alg none", iss.sign(t, "none", "rsa", iss.claims(nil)), true},
+		{"malformed", "not-a-jwt", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id, err := v.Verify(context.Background(), tt.token)
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidToken) {
+					t.Fatalf("want ErrInvalidToken, got %v (%+v)", err, id)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if id.Email != "ops@example.com" || id.Subject != "1234" {
+				t.Fatalf("unexpected identity %+v", id)
+			}
+		})
+	}
+}
+
+func TestVerify_TamperedClaims(t *testing.T) {
+	iss := newTestIssuer(t)
+	v := NewVerifier(Config{Issuer: iss.srv.URL, Audience: "shortener-admin"})
+	good := iss.sign(t, "RS256", "rsa", iss.claims(nil))
+	other := iss.sign(t, "RS256", "rsa", iss.claims(map[string]any{"email": "root@example.com"}))
+
+	// Header and signature of one token with the claims of another.
+	g, o := strings.Split(good, "."), strings.Split(other, ".")
+	forged := g[0] + "." + o[1] + "." + g[2]
+	if _, err := v.Verify(context.Background(), forged); !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("want ErrInvalidToken, got %v", err)
+	}
+}
+
+func TestKeysAreCached(t *testing.T) {
+	iss := newTestIssuer(t)
+	v := NewVerifier(Config{Issuer: iss.srv.URL, Audience: "shortener-admin"})
+	now := time.Now()
+	v.now = func() time.Time { return now }
+
+	token := iss.sign(t, "RS256", "rsa", iss.claims(nil))
+	for i := 0; i < 3; i++ {
+		if _, err := v.Verify(context.Background(), token); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Unknown key IDs do not refetch more than once per minRefresh.
+	v.Verify(context.Background(), iss.sign(t, "RS256", "rotated", iss.claims(nil)))
+	if iss.fetches != 1 {
+		t.Fatalf("want 1 JWKS fetch, got %d", iss.fetches)
+	}
+
+	now = now.Add(keysTTL)
+	if _, err := v.Verify(context.Background(), token); err != nil {
+		t.Fatal(err)
+	}
+	if iss.fetches != 2 {
+		t.Fatalf("want a refetch after keysTTL, got %d fetches", iss.fetches)
+	}
+}
+
+func TestFromRequest_IAPHeader(t *testing.T) {
+	iss := newTestIssuer(t)
+	cfg := IAPConfig("shortener-admin")
+	cfg.Issuer, cfg.JWKSURL = iss.srv.URL, iss.srv.URL+"/jwks"
+	v := NewVerifier(cfg)
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	if _, err := v.FromRequest(r); !errors.Is(err, ErrMissingToken) {
+		t.Fatalf("want ErrMissingToken, got %v", err)
+	}
+	r.Header.Set(IAPHeader, iss.sign(t, "ES256", "ec", iss.claims(nil)))
+	id, err := v.FromRequest(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id.String() != "ops@example.com" {
+		t.Fatalf("want email identity, got %q", id)
+	}
+}
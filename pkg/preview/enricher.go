@@ -0,0 +1,109 @@
+package preview
+
+import (
+	"context"
+	"log"
+	"sync"
+
+	"golang.org/x/time/rate"
+
+	"github.com/FlorinBalint/shortener/pkg/urlstore"
+)
+
+const (
+	defaultRate      = 2
+	defaultQueueSize = 256
+)
+
+type job struct {
+	key    urlstore.UrlKey
+	target string
+}
+
+// Enricher fetches previews in the background and stores them on entries.
+// Fetches are rate limited; links enqueued while the queue is full are
+// skipped rather than delaying link creation.
+type Enricher struct {
+	store   urlstore.Client
+	fetcher *Fetcher
+	limiter *rate.Limiter
+	jobs    chan job
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewEnricher starts a background worker performing at most perSecond
+// fetches per second (default 2) with a queue of queueSize links (default 256).
+func NewEnricher(store urlstore.Client, fetcher *Fetcher, perSecond float64, queueSize int) *Enricher {
+	if perSecond <= 0 {
+		perSecond = defaultRate
+	}
+	if queueSize <= 0 {
+		queueSize = defaultQueueSize
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	e := &Enricher{
+		store:   store,
+		fetcher: fetcher,
+		limiter: rate.NewLimiter(rate.Limit(perSecond), 1),
+		jobs:    make(chan job, queueSize),
+		ctx:     ctx,
+		cancel:  cancel,
+	}
+	e.wg.Add(1)
+	go e.run()
+	return e
+}
+
+// Enqueue schedules a preview fetch for key and reports whether it was queued.
+func (e *Enricher) Enqueue(key urlstore.UrlKey, target string) bool {
+	select {
+	case e.jobs <- job{key: key, target: target}:
+		return true
+	default:
+		log.Printf("preview queue full, skipping %q", key)
+		return false
+	}
+}
+
+// Close stops the worker, abandoning queued fetches.
+func (e *Enricher) Close() {
+	e.cancel()
+	e.wg.Wait()
+}
+
+func (e *Enricher) run() {
+	defer e.wg.Done()
+	for {
+		select {
+		case <-e.ctx.Done():
+			return
+		case j := <-e.jobs:
+			if err := e.limiter.Wait(e.ctx); err != nil {
+				return
+			}
+			e.enrich(j)
+		}
+	}
+}
+
+func (e *Enricher) enrich(j job) {
+	p, err := e.fetcher.Fetch(e.ctx, j.target)
+	if err != nil {
+		log.Printf("preview fetch for %q failed: %v", j.key, err)
+		return
+	}
+	err = e.store.UpdateEntry(e.ctx, j.key, func(entry *urlstore.URLEntry) error {
+		if entry.URLTarget != j.target {
+			// the target changed since the fetch was queued; keep the entry as is
+			return nil
+		}
+		entry.Preview = &p
+		return nil
+	})
+	if err != nil {
+		log.Printf("storing preview for %q failed: %v", j.key, err)
+	}
+}
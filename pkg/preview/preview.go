@@ -0,0 +1,269 @@
+// Package preview scrapes title, description and favicon from link targets.
+// Fetches only reach public addresses, so user-supplied targets cannot be
+// used to probe the cluster network.
+package preview
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/net/html"
+
+	"github.com/FlorinBalint/shortener/pkg/urlstore"
+)
+
+const (
+	defaultTimeout   = 5 * time.Second
+	defaultMaxBytes  = 512 << 10
+	defaultUserAgent = "shortener-preview/1.0"
+	maxRedirects     = 3
+	maxTextLen       = 300
+)
+
+// Errors returned by Fetcher.
+var (
+	ErrForbiddenAddress = errors.New("target resolves to a non-public address")
+	ErrUnsupportedURL   = errors.New("only http and https targets can be previewed")
+	ErrNotHTML          = errors.New("target is not an HTML page")
+)
+
+// sharedAddressSpace is the carrier-grade NAT range (RFC 6598), not covered by netip.Addr.IsPrivate.
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+// Config configures preview fetching.
+//
+// Timeout bounds a single fetch (default 5s). MaxBytes caps how much of the
+// page is read (default 512KiB). UserAgent is sent with every request.
+type Config struct {
+	Timeout   time.Duration
+	MaxBytes  int64
+	UserAgent string
+}
+
+// Fetcher downloads target pages and extracts a LinkPreview from them.
+type Fetcher struct {
+	client    *http.Client
+	maxBytes  int64
+	userAgent string
+	now       func() time.Time
+}
+
+// NewFetcher returns a Fetcher that only connects to public addresses.
+func NewFetcher(cfg Config) *Fetcher {
+	return newFetcher(cfg, publicAddr)
+}
+
+// newFetcher lets tests relax the address check to reach httptest servers.
+func newFetcher(cfg Config, allowed func(netip.Addr) bool) *Fetcher {
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = defaultTimeout
+	}
+	if cfg.MaxBytes <= 0 {
+		cfg.MaxBytes = defaultMaxBytes
+	}
+	if cfg.UserAgent == "" {
+		cfg.UserAgent = defaultUserAgent
+	}
+
+	dialer := &net.Dialer{
+		Timeout: cfg.Timeout,
+		// Control runs after DNS resolution, for every connection (including
+		// redirects), so rebinding a hostname to a private IP does not help.
+		Control: func(network, address string, _ syscall.RawConn) error {
+			ap, err := netip.ParseAddrPort(address)
+			if err != nil || !allowed(ap.Addr()) {
+				return ErrForbiddenAddress
+			}
+			return nil
+		},
+	}
+	transport := &http.Transport{
+		// No proxy: a proxy would connect on our behalf and bypass Control.
+		Proxy:                 nil,
+		DialContext:           dialer.DialContext,
+		TLSHandshakeTimeout:   cfg.Timeout,
+		ResponseHeaderTimeout: cfg.Timeout,
+		MaxIdleConns:          10,
+		IdleConnTimeout:       30 * time.Second,
+	}
+	return &Fetcher{
+		client: &http.Client{
+			Transport: transport,
+			Timeout:   cfg.Timeout,
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				if len(via) >= maxRedirects {
+					return fmt.Errorf("stopped after %d redirects", maxRedirects)
+				}
+				return checkScheme(req.URL)
+			},
+		},
+		maxBytes:  cfg.MaxBytes,
+		userAgent: cfg.UserAgent,
+		now:       time.Now,
+	}
+}
+
+func publicAddr(a netip.Addr) bool {
+	a = a.Unmap()
+	return a.IsGlobalUnicast() && !a.IsPrivate() && !sharedAddressSpace.Contains(a)
+}
+
+func checkScheme(u *url.URL) error {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return ErrUnsupportedURL
+	}
+	return nil
+}
+
+// Fetch downloads target and extracts its preview.
+func (f *Fetcher) Fetch(ctx context.Context, target string) (urlstore.LinkPreview, error) {
+	u, err := url.Parse(target)
+	if err != nil {
+		return urlstore.LinkPreview{}, err
+	}
+	if err := checkScheme(u); err != nil {
+		return urlstore.LinkPreview{}, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return urlstore.LinkPreview{}, err
+	}
+	req.Header.Set("User-Agent", f.userAgent)
+	req.Header.Set("Accept", "text/html,application/xhtml+xml")
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return urlstore.LinkPreview{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return urlstore.LinkPreview{}, fmt.Errorf("preview fetch status %d", resp.StatusCode)
+	}
+	mt, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mt != "text/html" && mt != "application/xhtml+xml" {
+		return urlstore.LinkPreview{}, ErrNotHTML
+	}
+
+	p := parse(io.LimitReader(resp.Body, f.maxBytes), resp.Request.URL)
+	p.FetchedAt = f.now().UTC()
+	return p, nil
+}
+
+// parse extracts preview fields from the document head. base resolves
+// relative favicon references.
+func parse(r io.Reader, base *url.URL) urlstore.LinkPreview {
+	var (
+		p                  urlstore.LinkPreview
+		ogTitle, ogDesc    string
+		inTitle            bool
+		title, description strings.Builder
+	)
+	z := html.NewTokenizer(r)
+loop:
+	for {
+		tt := z.Next()
+		switch tt {
+		case html.ErrorToken:
+			break loop
+		case html.StartTagToken, html.SelfClosingTagToken:
+			tok := z.Token()
+			switch tok.Data {
+			case "title":
+				inTitle = tt == html.StartTagToken
+			case "meta":
+				name := strings.ToLower(attr(tok, "name"))
+				prop := strings.ToLower(attr(tok, "property"))
+				content := attr(tok, "content")
+				switch {
+				case name == "description" && description.Len() == 0:
+					description.WriteString(content)
+				case prop == "og:description":
+					ogDesc = content
+				case prop == "og:title":
+					ogTitle = content
+				}
+			case "link":
+				if p.FaviconURL == "" && isIconRel(attr(tok, "rel")) {
+					p.FaviconURL = resolve(base, attr(tok, "href"))
+				}
+			case "body":
+				break loop
+			}
+		case html.TextToken:
+			if inTitle {
+				title.Write(z.Text())
+			}
+		case html.EndTagToken:
+			if tn, _ := z.TagName(); string(tn) == "title" {
+				inTitle = false
+			} else if string(tn) == "head" {
+				break loop
+			}
+		}
+	}
+
+	p.Title = clean(title.String())
+	if p.Title == "" {
+		p.Title = clean(ogTitle)
+	}
+	p.Description = clean(description.String())
+	if p.Description == "" {
+		p.Description = clean(ogDesc)
+	}
+	if p.FaviconURL == "" && base != nil {
+		p.FaviconURL = resolve(base, "/favicon.ico")
+	}
+	return p
+}
+
+func attr(tok html.Token, key string) string {
+	for _, a := range tok.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+func isIconRel(rel string) bool {
+	for _, f := range strings.Fields(strings.ToLower(rel)) {
+		if f == "icon" {
+			return true
+		}
+	}
+	return false
+}
+
+func resolve(base *url.URL, ref string) string {
+	ref = strings.TrimSpace(ref)
+	if ref == "" || base == nil {
+		return ""
+	}
+	u, err := base.Parse(ref)
+	if err != nil || checkScheme(u) != nil {
+		return ""
+	}
+	return u.String()
+}
+
+// clean collapses whitespace and truncates s to maxTextLen runes.
+func clean(s string) string {
+	s = strings.Join(strings.Fields(s), " ")
+	if utf8.RuneCountInString(s) <= maxTextLen {
+		return s
+	}
+	return string([]rune(s)[:maxTextLen])
+}
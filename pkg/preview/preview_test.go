@@ -0,0 +1,145 @@
+package preview
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/FlorinBalint/shortener/pkg/urlstore"
+)
+
+const page = `<!doctype html>
+<html><head>
+  <title>
+    Example   Domain
+  </title>
+  <meta name="description" content="An example page.">
+  <meta property="og:title" content="Ignored OG title">
+  <link rel="shortcut icon" href="/static/icon.png">
+</head><body><title>not this</title></body></html>`
+
+func allowAll(netip.Addr) bool { return true }
+
+func TestParse(t *testing.T) {
+	base, _ := url.Parse("https://example.com/some/page")
+	p := parse(strings.NewReader(page), base)
+	if p.Title != "Example Domain" {
+		t.Fatalf("title: got %q", p.Title)
+	}
+	if p.Description != "An example page." {
+		t.Fatalf("description: got %q", p.Description)
+	}
+	if p.FaviconURL != "https://example.com/static/icon.png" {
+		t.Fatalf("favicon: got %q", p.FaviconURL)
+	}
+}
+
+func TestParse_FallbacksAndLimits(t *testing.T) {
+	base, _ := url.Parse("https://example.com/x")
+	long := strings.Repeat("a", 2*maxTextLen)
+	doc := `<head><meta property="og:title" content="` + long + `">` +
+		`<meta property="og:description" content="OG description"></head>`
+	p := parse(strings.NewReader(doc), base)
+	if len(p.Title) != maxTextLen {
+		t.Fatalf("title length: want %d, got %d", maxTextLen, len(p.Title))
+	}
+	if p.Description != "OG description" {
+		t.Fatalf("description: got %q", p.Description)
+	}
+	if p.FaviconURL != "https://example.com/favicon.ico" {
+		t.Fatalf("favicon: got %q", p.FaviconURL)
+	}
+}
+
+func TestPublicAddr(t *testing.T) {
+	for addr, want := range map[string]bool{
+		"93.184.216.34":    true,
+		"2606:2800::1":     true,
+		"127.0.0.1":        false,
+		"10.1.2.3":         false,
+		"192.168.0.1":      false,
+		"169.254.169.254":  false,
+		"100.64.0.1":       false,
+		"::1":              false,
+		"fd00::1":          false,
+		"::ffff:127.0.0.1": false,
+		"0.0.0.0":          false,
+	} {
+		if got := publicAddr(netip.MustParseAddr(addr)); got != want {
+			t.Errorf("publicAddr(%s): want %v, got %v", addr, want, got)
+		}
+	}
+}
+
+func TestFetch_RejectsPrivateAddresses(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("request reached a loopback server")
+	}))
+	defer srv.Close()
+
+	_, err := NewFetcher(Config{}).Fetch(context.Background(), srv.URL)
+	if !errors.Is(err, ErrForbiddenAddress) {
+		t.Fatalf("want ErrForbiddenAddress, got %v", err)
+	}
+}
+
+func TestFetch_RejectsNonHTTP(t *testing.T) {
+	_, err := NewFetcher(Config{}).Fetch(context.Background(), "file:///etc/passwd")
+	if !errors.Is(err, ErrUnsupportedURL) {
+		t.Fatalf("want ErrUnsupportedURL, got %v", err)
+	}
+}
+
+func TestFetch_RejectsNonHTML(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/pdf")
+	}))
+	defer srv.Close()
+
+	_, err := newFetcher(Config{}, allowAll).Fetch(context.Background(), srv.URL)
+	if !errors.Is(err, ErrNotHTML) {
+		t.Fatalf("want ErrNotHTML, got %v", err)
+	}
+}
+
+func TestEnricher_StoresPreview(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(page))
+	}))
+	defer srv.Close()
+
+	store := urlstore.NewMemoryClient()
+	ctx := context.Background()
+	if err := store.CreateEntry(ctx, "k", urlstore.URLEntry{URLTarget: srv.URL}); err != nil {
+		t.Fatal(err)
+	}
+
+	e := NewEnricher(store, newFetcher(Config{}, allowAll), 100, 1)
+	defer e.Close()
+	if !e.Enqueue("k", srv.URL) {
+		t.Fatalf("Enqueue rejected the first job")
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		entry, err := store.GetEntry(ctx, "k")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if entry.Preview != nil {
+			if entry.Preview.Title != "Example Domain" {
+				t.Fatalf("title: got %q", entry.Preview.Title)
+			}
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("preview was not stored")
+}
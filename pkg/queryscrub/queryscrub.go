@@ -0,0 +1,115 @@
+// Package queryscrub removes sensitive parameters from query strings, so
+// that the query of a short URL, forwarded to the link's target, carries
+// no credentials meant for us to a third party.
+//
+// Parameters are matched by name, ignoring case, against patterns: a
+// name, or a prefix ending in "*" matching every name it starts.
+package queryscrub
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// Default are the parameters scrubbed unless configured otherwise: those
+// commonly carrying credentials, signatures and OAuth state.
+var Default = []string{
+	"access_token", "api_key", "apikey", "auth", "code", "id_token", "key",
+	"password", "refresh_token", "secret", "session", "sig", "signature",
+	"state", "token",
+}
+
+// MaxPatterns caps the patterns of a link, matched on every redirect.
+const MaxPatterns = 32
+
+// maxPatternLen bounds a pattern.
+const maxPatternLen = 64
+
+// Normalize validates patterns and returns them in canonical form,
+// lowercase.
+func Normalize(patterns []string) ([]string, error) {
+	if len(patterns) > MaxPatterns {
+		return nil, fmt.Errorf("more than %d patterns", MaxPatterns)
+	}
+	var out []string
+	for _, p := range patterns {
+		p = strings.ToLower(strings.TrimSpace(p))
+		if err := check(p); err != nil {
+			return nil, err
+		}
+		out = append(out, p)
+	}
+	return out, nil
+}
+
+// check fails unless pattern is a parameter name or a prefix ending in
+// "*", of printable characters other than those delimiting queries.
+func check(pattern string) error {
+	name, _ := strings.CutSuffix(pattern, "*")
+	switch {
+	case name == "":
+		return fmt.Errorf("pattern %q matches every parameter", pattern)
+	case len(pattern) > maxPatternLen:
+		return fmt.Errorf("pattern %q is longer than %d", pattern, maxPatternLen)
+	case strings.ContainsAny(name, "*&;=#?%+"):
+		return fmt.Errorf("pattern %q may only end in *, and cannot contain &, ;, =, #, ?, %% or +", pattern)
+	}
+	for _, c := range []byte(name) {
+		if c < 0x21 || c > 0x7e {
+			return fmt.Errorf("pattern %q must be printable ASCII without spaces", pattern)
+		}
+	}
+	return nil
+}
+
+// Match reports whether the parameter name matches one of patterns.
+func Match(name string, patterns ...[]string) bool {
+	for _, list := range patterns {
+		for _, p := range list {
+			if prefix, ok := strings.CutSuffix(p, "*"); ok {
+				if len(name) >= len(prefix) && strings.EqualFold(name[:len(prefix)], prefix) {
+					return true
+				}
+			} else if strings.EqualFold(name, p) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Scrub returns rawQuery without its parameters matching patterns, and
+// without empty ones; the others are kept as sent, in order. Some servers
+// also split parameters at semicolons, so a parameter is dropped when any
+// of its semicolon-separated parts matches.
+func Scrub(rawQuery string, patterns ...[]string) string {
+	if rawQuery == "" {
+		return ""
+	}
+	var b strings.Builder
+	for _, param := range strings.Split(rawQuery, "&") {
+		if param == "" || matches(param, patterns) {
+			continue
+		}
+		if b.Len() > 0 {
+			b.WriteByte('&')
+		}
+		b.WriteString(param)
+	}
+	return b.String()
+}
+
+// matches reports whether a part of param matches patterns.
+func matches(param string, patterns [][]string) bool {
+	for _, part := range strings.Split(param, ";") {
+		name, _, _ := strings.Cut(part, "=")
+		if unescaped, err := url.QueryUnescape(name); err == nil {
+			name = unescaped
+		}
+		if Match(name, patterns...) {
+			return true
+		}
+	}
+	return false
+}
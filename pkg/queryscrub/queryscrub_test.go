@@ -0,0 +1,61 @@
+package queryscrub
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestNormalize(t *testing.T) {
+	got, err := Normalize([]string{" Token ", "UTM_*", "x-sig"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"token", "utm_*", "x-sig"}; !slices.Equal(got, want) {
+		t.Fatalf("Normalize = %q, want %q", got, want)
+	}
+	for _, bad := range []string{"", "*", "to*ken", "a=b", "a&b", "a;b", "a b", "%74oken", "tök"} {
+		if _, err := Normalize([]string{bad}); err == nil {
+			t.Errorf("Normalize(%q): want an error", bad)
+		}
+	}
+	if _, err := Normalize(slices.Repeat([]string{"a"}, MaxPatterns+1)); err == nil {
+		t.Error("want too many patterns refused")
+	}
+	if _, err := Normalize(Default); err != nil {
+		t.Errorf("Default: %v", err)
+	}
+}
+
+func TestScrub(t *testing.T) {
+	link := []string{"utm_*", "ref"}
+	tests := []struct {
+		query, want string
+	}{
+		{"", ""},
+		{"a=1&b=2", "a=1&b=2"},
+		{"token=s3cret&q=go", "q=go"},
+		{"q=go&TOKEN=s3cret&Api_Key=x", "q=go"},
+		{"%74oken=s3cret&q=go", "q=go"},
+		{"q=go&utm_source=mail&utm_medium=x&ref=abc&page=2", "q=go&page=2"},
+		{"q=go;token=s3cret&page=2", "page=2"},
+		{"token&q=a%20b&&", "q=a%20b"},
+		{"tokens=1&keyword=go", "tokens=1&keyword=go"},
+	}
+	for _, tt := range tests {
+		if got := Scrub(tt.query, Default, link); got != tt.want {
+			t.Errorf("Scrub(%q) = %q, want %q", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestMatch(t *testing.T) {
+	if !Match("Session", []string{"x"}, Default) {
+		t.Error("want a match in the second list")
+	}
+	if Match("utm", []string{"utm_*"}) {
+		t.Error("want a prefix to match only the names it starts")
+	}
+	if Match("token") {
+		t.Error("want no match without patterns")
+	}
+}
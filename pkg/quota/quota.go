@@ -0,0 +1,229 @@
+// Package quota enforces soft quotas per API key on link creation: a cap on
+// the links a key owns and on the links it creates per UTC day.
+//
+// Quotas are soft: usage counters are maintained on create and delete and
+// are not reconciled with entries that expire or are purged.
+package quota
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/datastore"
+
+	"github.com/FlorinBalint/shortener/pkg/gcputil"
+)
+
+// ErrExceeded is returned by Reserve when a limit is reached.
+var ErrExceeded = errors.New("quota exceeded")
+
+// Limits caps the usage of an API key. Zero means unlimited.
+type Limits struct {
+	MaxLinks       int64 `json:"max_links"`
+	MaxDailyWrites int64 `json:"max_daily_writes"`
+}
+
+// Usage counts what an API key consumed.
+type Usage struct {
+	Links       int64 `json:"links"`
+	DailyWrites int64 `json:"daily_writes"`
+	// Day is the UTC date (YYYY-MM-DD) DailyWrites refers to.
+	Day string `json:"day,omitempty"`
+}
+
+// Record is the persisted quota state of an API key.
+// Limits is nil while the key uses the default limits.
+type Record struct {
+	Limits *Limits `json:"limits,omitempty"`
+	Usage  Usage   `json:"usage"`
+}
+
+// Store persists quota records by API key ID.
+type Store interface {
+	// Get returns the record of id, or the zero Record if there is none.
+	Get(ctx context.Context, id string) (Record, error)
+	// Update atomically applies update to the record of id, starting from
+	// the zero Record if there is none. Nothing is written if update fails.
+	Update(ctx context.Context, id string, update func(*Record) error) error
+}
+
+// DSStore stores quota records in Datastore.
+type DSStore struct {
+	client *gcputil.DSClient
+}
+
+var _ Store = (*DSStore)(nil)
+
+// NewDSStore returns a store persisting records under kind "api_quota".
+func NewDSStore(client *gcputil.DSClient) *DSStore {
+	return &DSStore{client: client}
+}
+
+// Get implements Store.
+func (s *DSStore) Get(ctx context.Context, id string) (Record, error) {
+	rec, err := gcputil.GetValue[Record](s.client, ctx, "api_quota", id)
+	if errors.Is(err, datastore.ErrNoSuchEntity) {
+		return Record{}, nil
+	}
+	return rec, err
+}
+
+// Update implements Store.
+func (s *DSStore) Update(ctx context.Context, id string, update func(*Record) error) error {
+	return gcputil.UpsertValue(s.client, ctx, "api_quota", id, update)
+}
+
+// MemoryStore is an in-process Store, for tests and local development.
+type MemoryStore struct {
+	mu      sync.Mutex
+	records map[string]Record
+}
+
+var _ Store = (*MemoryStore)(nil)
+
+// NewMemoryStore returns an empty in-memory store.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{records: make(map[string]Record)}
+}
+
+// Get implements Store.
+func (s *MemoryStore) Get(ctx context.Context, id string) (Record, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.records[id], nil
+}
+
+// Update implements Store.
+func (s *MemoryStore) Update(ctx context.Context, id string, update func(*Record) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rec := s.records[id]
+	if rec.Limits != nil {
+		l := *rec.Limits
+		rec.Limits = &l
+	}
+	if err := update(&rec); err != nil {
+		return err
+	}
+	s.records[id] = rec
+	return nil
+}
+
+// Status is the quota state of an API key: its effective limits and usage.
+type Status struct {
+	Limits Limits `json:"limits"`
+	// Default is set when the key uses the default limits.
+	Default bool  `json:"default"`
+	Usage   Usage `json:"usage"`
+}
+
+// Exceeded reports whether one more link creation would exceed a limit.
+func (s Status) Exceeded() bool {
+	return s.ExceededBy(1)
+}
+
+// ExceededBy reports whether n more link creations would exceed a limit.
+func (s Status) ExceededBy(n int64) bool {
+	return (s.Limits.MaxLinks > 0 && s.Usage.Links+n > s.Limits.MaxLinks) ||
+		(s.Limits.MaxDailyWrites > 0 && s.Usage.DailyWrites+n > s.Limits.MaxDailyWrites)
+}
+
+// Enforcer checks and accounts link creations against quotas.
+type Enforcer struct {
+	store    Store
+	defaults Limits
+	now      func() time.Time
+}
+
+// NewEnforcer returns an enforcer applying defaults to keys without limits
+// of their own.
+func NewEnforcer(store Store, defaults Limits) *Enforcer {
+	return &Enforcer{store: store, defaults: defaults, now: time.Now}
+}
+
+func (e *Enforcer) status(rec Record) Status {
+	if rec.Limits == nil {
+		return Status{Limits: e.defaults, Default: true, Usage: rec.Usage}
+	}
+	return Status{Limits: *rec.Limits, Usage: rec.Usage}
+}
+
+// rollover resets the daily counter when the day changed.
+func (e *Enforcer) rollover(u *Usage) {
+	if today := e.now().UTC().Format(time.DateOnly); u.Day != today {
+		u.Day = today
+		u.DailyWrites = 0
+	}
+}
+
+// Reserve accounts one link creation by id. It fails with ErrExceeded,
+// recording nothing, when the creation would exceed a limit. The returned
+// status is valid in both cases.
+func (e *Enforcer) Reserve(ctx context.Context, id string) (Status, error) {
+	return e.ReserveN(ctx, id, 1)
+}
+
+// ReserveN is Reserve for n links created together, all or none.
+func (e *Enforcer) ReserveN(ctx context.Context, id string, n int64) (Status, error) {
+	var st Status
+	err := e.store.Update(ctx, id, func(rec *Record) error {
+		e.rollover(&rec.Usage)
+		st = e.status(*rec)
+		if st.ExceededBy(n) {
+			return ErrExceeded
+		}
+		rec.Usage.Links += n
+		rec.Usage.DailyWrites += n
+		st.Usage = rec.Usage
+		return nil
+	})
+	return st, err
+}
+
+// Cancel undoes a Reserve whose link could not be created.
+func (e *Enforcer) Cancel(ctx context.Context, id string) error {
+	return e.CancelN(ctx, id, 1)
+}
+
+// CancelN undoes a ReserveN of n links.
+func (e *Enforcer) CancelN(ctx context.Context, id string, n int64) error {
+	return e.store.Update(ctx, id, func(rec *Record) error {
+		rec.Usage.Links = max(rec.Usage.Links-n, 0)
+		if rec.Usage.Day == e.now().UTC().Format(time.DateOnly) {
+			rec.Usage.DailyWrites = max(rec.Usage.DailyWrites-n, 0)
+		}
+		return nil
+	})
+}
+
+// Release accounts the deletion of a link owned by id.
+func (e *Enforcer) Release(ctx context.Context, id string) error {
+	return e.store.Update(ctx, id, func(rec *Record) error {
+		rec.Usage.Links = max(rec.Usage.Links-1, 0)
+		return nil
+	})
+}
+
+// Status returns the quota state of id.
+func (e *Enforcer) Status(ctx context.Context, id string) (Status, error) {
+	rec, err := e.store.Get(ctx, id)
+	if err != nil {
+		return Status{}, err
+	}
+	e.rollover(&rec.Usage)
+	return e.status(rec), nil
+}
+
+// SetLimits overrides the limits of id; nil restores the defaults.
+func (e *Enforcer) SetLimits(ctx context.Context, id string, limits *Limits) (Status, error) {
+	var st Status
+	err := e.store.Update(ctx, id, func(rec *Record) error {
+		rec.Limits = limits
+		e.rollover(&rec.Usage)
+		st = e.status(*rec)
+		return nil
+	})
+	return st, err
+}
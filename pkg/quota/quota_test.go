@@ -0,0 +1,119 @@
+package quota
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func newTestEnforcer(defaults Limits) (*Enforcer, *time.Time) {
+	now := time.Date(2025, 3, 1, 23, 0, 0, 0, time.UTC)
+	e := NewEnforcer(NewMemoryStore(), defaults)
+	e.now = func() time.Time { return now }
+	return e, &now
+}
+
+func TestReserve_MaxLinks(t *testing.T) {
+	ctx := context.Background()
+	e, _ := newTestEnforcer(Limits{MaxLinks: 2})
+	for i := 0; i < 2; i++ {
+		if _, err := e.Reserve(ctx, "k"); err != nil {
+			t.Fatalf("reserve %d: %v", i, err)
+		}
+	}
+	st, err := e.Reserve(ctx, "k")
+	if !errors.Is(err, ErrExceeded) {
+		t.Fatalf("want ErrExceeded, got %v", err)
+	}
+	if st.Usage.Links != 2 {
+		t.Fatalf("rejected reserve must not count: links = %d", st.Usage.Links)
+	}
+
+	if err := e.Release(ctx, "k"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.Reserve(ctx, "k"); err != nil {
+		t.Fatalf("after release: %v", err)
+	}
+}
+
+func TestReserveN(t *testing.T) {
+	ctx := context.Background()
+	e, _ := newTestEnforcer(Limits{MaxLinks: 3})
+	if _, err := e.ReserveN(ctx, "k", 2); err != nil {
+		t.Fatalf("reserve 2: %v", err)
+	}
+	st, err := e.ReserveN(ctx, "k", 2)
+	if !errors.Is(err, ErrExceeded) {
+		t.Fatalf("want ErrExceeded, got %v", err)
+	}
+	if st.Usage.Links != 2 {
+		t.Fatalf("rejected reserve must not count: links = %d", st.Usage.Links)
+	}
+	if err := e.CancelN(ctx, "k", 2); err != nil {
+		t.Fatal(err)
+	}
+	if st, err := e.ReserveN(ctx, "k", 3); err != nil || st.Usage.Links != 3 || st.Usage.DailyWrites != 3 {
+		t.Fatalf("after cancel: %+v, %v", st.Usage, err)
+	}
+}
+
+func TestReserve_DailyWritesRollOver(t *testing.T) {
+	ctx := context.Background()
+	e, now := newTestEnforcer(Limits{MaxDailyWrites: 1})
+	if _, err := e.Reserve(ctx, "k"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.Reserve(ctx, "k"); !errors.Is(err, ErrExceeded) {
+		t.Fatalf("want ErrExceeded, got %v", err)
+	}
+
+	*now = now.Add(2 * time.Hour)
+	st, err := e.Reserve(ctx, "k")
+	if err != nil {
+		t.Fatalf("next day: %v", err)
+	}
+	if st.Usage.DailyWrites != 1 || st.Usage.Links != 2 {
+		t.Fatalf("unexpected usage: %+v", st.Usage)
+	}
+}
+
+func TestCancel(t *testing.T) {
+	ctx := context.Background()
+	e, _ := newTestEnforcer(Limits{})
+	if _, err := e.Reserve(ctx, "k"); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Cancel(ctx, "k"); err != nil {
+		t.Fatal(err)
+	}
+	st, _ := e.Status(ctx, "k")
+	if st.Usage.Links != 0 || st.Usage.DailyWrites != 0 {
+		t.Fatalf("want no usage after cancel, got %+v", st.Usage)
+	}
+}
+
+func TestSetLimits(t *testing.T) {
+	ctx := context.Background()
+	e, _ := newTestEnforcer(Limits{MaxLinks: 1})
+	if _, err := e.Reserve(ctx, "k"); err != nil {
+		t.Fatal(err)
+	}
+
+	st, err := e.SetLimits(ctx, "k", &Limits{MaxLinks: 5})
+	if err != nil || st.Default || st.Limits.MaxLinks != 5 {
+		t.Fatalf("override: %+v, %v", st, err)
+	}
+	if _, err := e.Reserve(ctx, "k"); err != nil {
+		t.Fatalf("reserve under override: %v", err)
+	}
+
+	st, _ = e.SetLimits(ctx, "k", nil)
+	if !st.Default || st.Limits.MaxLinks != 1 {
+		t.Fatalf("reset: want defaults, got %+v", st)
+	}
+	if _, err := e.Reserve(ctx, "k"); !errors.Is(err, ErrExceeded) {
+		t.Fatalf("want ErrExceeded after reset, got %v", err)
+	}
+}
@@ -0,0 +1,51 @@
+package reader
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/FlorinBalint/shortener/pkg/keycheck"
+)
+
+// trailingPunctuation is what chat apps and mail clients tend to glue to a
+// pasted link. Keys never contain any of it.
+const trailingPunctuation = `.,;:!)]}>'"`
+
+// canonicalKey returns the key a mangled key was most likely meant to be:
+// without trailing punctuation and, with case-insensitive keys, lowercase.
+func (h *Handler) canonicalKey(key string) string {
+	if h.trimPunctuation {
+		key = strings.TrimRight(key, trailingPunctuation)
+	}
+	if h.foldCase {
+		key = strings.ToLower(key)
+	}
+	return key
+}
+
+// canonicalURL is the short URL of key, keeping the request's query.
+func canonicalURL(key, rawQuery string) string {
+	u := "/" + key
+	if rawQuery != "" {
+		u += "?" + rawQuery
+	}
+	return u
+}
+
+// notFound answers a request for an unknown key of path, which the
+// fallback resolvers may know. Keys shaped like generated ones whose check
+// characters do not match were most likely mistyped, which the client is
+// told rather than that the link is gone. Unknown keys are looked up first
+// all the same: keys generated before check characters were turned on,
+// and custom aliases, have none.
+func (h *Handler) notFound(ctx context.Context, w http.ResponseWriter, r *http.Request, path, key string) {
+	if h.fallback != nil && h.redirectFallback(ctx, w, r, path) {
+		return
+	}
+	if h.keyChecksum > 0 && keycheck.Plausible(key, h.keyChecksum) && !keycheck.Valid(key, h.keyChecksum) {
+		http.Error(w, "no such link: "+key+" looks mistyped, check it for typos", http.StatusNotFound)
+		return
+	}
+	http.NotFound(w, r)
+}
@@ -0,0 +1,26 @@
+package reader
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/FlorinBalint/shortener/pkg/fallback"
+)
+
+// redirectFallback redirects r to the target the fallback resolvers know
+// for path, the whole path as legacy shorteners know it, and reports
+// whether it did. Their failures are logged and answered as not found.
+func (h *Handler) redirectFallback(ctx context.Context, w http.ResponseWriter, r *http.Request, path string) bool {
+	target, err := h.fallback.Resolve(ctx, path)
+	if errors.Is(err, fallback.ErrNotFound) {
+		return false
+	}
+	if err != nil {
+		log.Printf("fallback: resolving %q: %v", path, err)
+		return false
+	}
+	h.redirectToTarget(w, r, target, h.redirectPolicy)
+	return true
+}
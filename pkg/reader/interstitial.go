@@ -0,0 +1,114 @@
+package reader
+
+import (
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/FlorinBalint/shortener/pkg/queryscrub"
+	"github.com/FlorinBalint/shortener/pkg/urlstore"
+)
+
+// continueParam marks the click-through from an interstitial page.
+const continueParam = "continue"
+
+// defaultInterstitial is the page of links naming the "default" template,
+// or one the reader does not have.
+const defaultInterstitial = `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta name="robots" content="noindex">
+<title>You are leaving this site</title>
+</head>
+<body>
+<h1>You are leaving this site</h1>
+<p>This link leads to {{.TargetHost}}, a site we do not operate and whose content we are not responsible for.</p>
+<p><a href="{{.ContinueURL}}">Continue to {{.Target}}</a></p>
+</body>
+</html>
+`
+
+// interstitialPage holds the variables of interstitial templates.
+type interstitialPage struct {
+	Key         string
+	Target      string
+	TargetHost  string
+	ContinueURL string
+}
+
+// interstitialTemplates parses the built-in "default" template and the
+// *.html files of InterstitialTemplates, each named after its file
+// without the extension; a file named default.html replaces the built-in
+// page.
+func (cfg Config) interstitialTemplates() (*template.Template, error) {
+	t := template.Must(template.New("default").Parse(defaultInterstitial))
+	if cfg.InterstitialTemplates == "" {
+		return t, nil
+	}
+	files, err := filepath.Glob(filepath.Join(cfg.InterstitialTemplates, "*.html"))
+	if err != nil {
+		return nil, fmt.Errorf("interstitial templates: %w", err)
+	}
+	for _, f := range files {
+		b, err := os.ReadFile(f)
+		if err != nil {
+			return nil, fmt.Errorf("interstitial templates: %w", err)
+		}
+		if _, err := t.New(strings.TrimSuffix(filepath.Base(f), ".html")).Parse(string(b)); err != nil {
+			return nil, fmt.Errorf("interstitial templates: %w", err)
+		}
+	}
+	return t, nil
+}
+
+// continuing reports whether r is the click-through of the interstitial
+// page of entry, followed from this host.
+func continuing(r *http.Request, entry urlstore.URLEntry) bool {
+	if entry.Interstitial == "" || !r.URL.Query().Has(continueParam) {
+		return false
+	}
+	ref, err := url.Parse(r.Referer())
+	return err == nil && ref.Host == r.Host
+}
+
+// serveInterstitial shows the interstitial page of the entry under key,
+// linking to target through the click-through.
+func (h *Handler) serveInterstitial(w http.ResponseWriter, r *http.Request, key string, entry urlstore.URLEntry, target string) {
+	t := h.interstitials.Lookup(entry.Interstitial)
+	if t == nil {
+		log.Printf("interstitial: no template %q for key %q, showing the default page", entry.Interstitial, key)
+		t = h.interstitials.Lookup("default")
+	}
+	host := target
+	if u, err := url.Parse(target); err == nil && u.Host != "" {
+		host = u.Hostname()
+	}
+	// Links forwarding their query keep it through the click-through.
+	query := continueParam + "=1"
+	if entry.ForwardQuery {
+		if q := queryscrub.Scrub(r.URL.RawQuery, []string{continueParam}); q != "" {
+			query = q + "&" + query
+		}
+	}
+	page := interstitialPage{
+		Key:         key,
+		Target:      target,
+		TargetHost:  host,
+		ContinueURL: (&url.URL{Path: r.URL.Path, RawQuery: query}).String(),
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	// The click-through is told by its referrer, which must survive
+	// stricter policies; it goes nowhere else.
+	w.Header().Set("Referrer-Policy", "same-origin")
+	if err := t.Execute(w, page); err != nil {
+		log.Printf("interstitial: rendering %q for key %q: %v", t.Name(), key, err)
+	}
+}
@@ -0,0 +1,1252 @@
+// Package reader implements the redirect service: it resolves short keys
+// against the URL store and redirects clients to their targets.
+package reader
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/gomemcache/memcache"
+	"go.opentelemetry.io/otel/metric"
+	"golang.org/x/sync/errgroup"
+
+	"github.com/FlorinBalint/shortener/pkg/archive"
+	"github.com/FlorinBalint/shortener/pkg/clientip"
+	"github.com/FlorinBalint/shortener/pkg/degrade"
+	"github.com/FlorinBalint/shortener/pkg/discovery"
+	"github.com/FlorinBalint/shortener/pkg/fallback"
+	"github.com/FlorinBalint/shortener/pkg/gcputil"
+	"github.com/FlorinBalint/shortener/pkg/hmacsig"
+	"github.com/FlorinBalint/shortener/pkg/httpmw"
+	"github.com/FlorinBalint/shortener/pkg/ipfilter"
+	"github.com/FlorinBalint/shortener/pkg/lifecycle"
+	"github.com/FlorinBalint/shortener/pkg/loadshed"
+	"github.com/FlorinBalint/shortener/pkg/queryscrub"
+	"github.com/FlorinBalint/shortener/pkg/referer"
+	"github.com/FlorinBalint/shortener/pkg/rewrite"
+	"github.com/FlorinBalint/shortener/pkg/scandetect"
+	"github.com/FlorinBalint/shortener/pkg/servertiming"
+	"github.com/FlorinBalint/shortener/pkg/tenant"
+	"github.com/FlorinBalint/shortener/pkg/urlstore"
+	"github.com/FlorinBalint/shortener/pkg/viewtoken"
+)
+
+type Config struct {
+	ProjectID   string
+	DSNamespace string
+	DSEndpoint  string
+	BindAddr    string
+	// Memcache discovery (from ConfigMap env). MemcacheServers, a
+	// pkg/discovery target such as
+	// "srv:_memcache._tcp.memcached.shortener.svc.cluster.local", lists
+	// self-run memcached nodes instead of Memorystore's.
+	MemcacheDiscoveryEndpoint string
+	MemcacheServers           string
+	// Faults injected into the Datastore layer (non-production only).
+	StoreFaults urlstore.FaultConfig
+	// SlowStoreThreshold logs Datastore operations taking longer, with
+	// their request ID (see urlstore.WithLogging); 0 logs none.
+	SlowStoreThreshold time.Duration
+	// MicroCacheTTL keeps lookups, found or not, in process for a short
+	// time (sub-second) to absorb bursts on single keys; 0 disables it.
+	MicroCacheTTL time.Duration
+	// MicroCacheMissTTL keeps misses for that long instead; 0 keeps none,
+	// so links are served as soon as they are created.
+	MicroCacheMissTTL time.Duration
+	// MicroCacheMaxStale serves links up to that long past the TTL while
+	// they are refreshed in the background; 0 refreshes them first.
+	MicroCacheMaxStale time.Duration
+	// ReadStaleness lets redirects and previews read links from Datastore
+	// as they were up to that long ago, which the nearest replica serves
+	// sooner than a strongly consistent read (see
+	// urlstore.WithReadStaleness): changed and deleted links may be served
+	// as they were for up to twice that long, as memcache keeps what stale
+	// reads fill it with for the staleness at most, while misses are read
+	// again strongly so new links are found at once. 0 reads strongly.
+	ReadStaleness time.Duration
+	// MeterProvider, when set, records metrics of the store's operations
+	// and cache hits (see urlstore.WithMetrics), and of requests (see
+	// httpmw.Metrics), labelled with Location, which the binaries resolve
+	// with gcputil.ResolveLocation.
+	MeterProvider metric.MeterProvider `statusz:"-"`
+	Location      gcputil.Location
+	// TrustedProxies may set Forwarded and X-Forwarded-For, e.g. the load
+	// balancer ranges.
+	TrustedProxies []netip.Prefix
+	// AccessLog logs a line per request.
+	AccessLog bool
+	// ServerTiming adds a Server-Timing header breaking the latency of
+	// responses down into cache and Datastore time: "header" for requests
+	// with an X-Debug-Timing header, "always" for every one.
+	ServerTiming servertiming.Mode
+	// Security sets the HSTS and Referrer-Policy headers of every
+	// response (see httpmw.SecurityHeaders).
+	Security httpmw.Security
+	// RedirectReferrerPolicy is the Referrer-Policy of redirects to
+	// targets instead, e.g. "no-referrer" so targets never learn the page
+	// a link was followed from. RedirectPage redirects with a page
+	// refreshing to the target rather than a 302, for browsers ignoring
+	// the policy of redirects; the page applies the policy too,
+	// "no-referrer" when unset.
+	RedirectReferrerPolicy string
+	RedirectPage           bool
+	// ScrubQueryParams are the query parameters (see pkg/queryscrub)
+	// removed from the queries links forward to their targets (see
+	// urlstore.URLEntry.ForwardQuery), besides those of the link and the
+	// reader's own, continue and token.
+	ScrubQueryParams []string
+	// TargetKMSKey and TargetWrappedKey (base64) decrypt link targets
+	// encrypted at rest by the writer, with a data key wrapped by the Cloud
+	// KMS key.
+	TargetKMSKey     string
+	TargetWrappedKey string `statusz:"redact"`
+	// MultiTenant serves each tenant's links on the tenant's domains, from
+	// its own Datastore namespace and cache key space.
+	MultiTenant bool
+	// CaseInsensitiveKeys and TrimKeyPunctuation retry unknown keys
+	// lowercased (as the writer stores aliases then) and without trailing
+	// punctuation, and send clients to the canonical short URL.
+	CaseInsensitiveKeys bool
+	TrimKeyPunctuation  bool
+	// CanonicalSlashes sends paths with a trailing or repeated slash,
+	// e.g. /promo/, to their canonical spelling, /promo, with a 301
+	// before redirecting, so clients, caches and logs see one URL per
+	// link. Otherwise both spellings are served alike.
+	CanonicalSlashes bool
+	// KeyChecksum is the number of check characters the writer appends to
+	// generated keys (see pkg/keycheck). Unknown keys failing the check
+	// are reported as likely typos.
+	KeyChecksum int
+	// RewriteRulesFile holds rules rewriting targets at redirect time (see
+	// pkg/rewrite); it is reloaded when it changes.
+	RewriteRulesFile string
+	// InterstitialTemplates is a directory of *.html templates of the
+	// pages links naming one show before redirecting (see
+	// urlstore.URLEntry.Interstitial); a built-in "default" page is always
+	// available.
+	InterstitialTemplates string
+	// ArchiveBucket is the Cloud Storage bucket the janitor archives cold
+	// links to (see pkg/archive). When set, the reader records the day
+	// each link is used, and restores archived links on access.
+	ArchiveBucket string
+	// ShadowProjectID and ShadowNamespace name a second Datastore mirroring
+	// the store's reads and writes, to gain confidence before migrating to
+	// it (see urlstore.WithShadow). ShadowReadRate is the fraction of reads
+	// compared.
+	ShadowProjectID string
+	ShadowNamespace string
+	ShadowReadRate  float64
+	// RedirectLimit and PreviewLimit bound the redirects and previews
+	// served at once, shedding the excess with 503.
+	RedirectLimit loadshed.Config
+	PreviewLimit  loadshed.Config
+	// Fallback resolves the keys the store does not know with other
+	// shorteners, e.g. a legacy one being migrated (see pkg/fallback).
+	Fallback fallback.Config
+	// Degrade drops usage tracking and access log lines while redirects
+	// are slow or failing, or when forced on (see pkg/degrade).
+	Degrade degrade.Config
+	// WarmupKeys is how many of the newest links Warm loads into the
+	// caches before the server reports ready.
+	WarmupKeys int
+	// Lifecycle times the warm-up and the lame-duck shutdown.
+	Lifecycle lifecycle.Config
+	// StatuszAddr serves the status page (see pkg/statusz), apart from
+	// the traffic; "off" disables it.
+	StatuszAddr string
+	// PreviewTokenKeys ("id:secret,...", first key primary, or a secret
+	// reference) make previews require a token minted by the writer with
+	// the same keys (see pkg/viewtoken); empty leaves previews open.
+	// PreviewTokenRate bounds the previews a token opens per minute, per
+	// replica (0 = unbounded).
+	PreviewTokenKeys string `statusz:"redact"`
+	PreviewTokenRate int
+	// ScanDetect reports, and optionally bans, clients walking the
+	// keyspace (see pkg/scandetect).
+	ScanDetect scandetect.Config
+	// KeyRedirectRate caps the redirects of each link per second, per
+	// replica, answering 429 beyond it, so that our domain cannot be used
+	// to flood a target; links may set a lower cap of their own (see
+	// urlstore.URLEntry.MaxRedirectsPerSecond). 0 leaves links uncapped.
+	KeyRedirectRate int
+}
+
+func getenvDefault(k, def string) string {
+	if v := os.Getenv(k); v != "" {
+		return v
+	}
+	return def
+}
+
+// LoadConfigFromEnv loads config from environment variables, with defaults.
+func LoadConfigFromEnv() Config {
+	microCacheTTL := getenvDuration("MICRO_CACHE_TTL", 0)
+	return Config{
+		ProjectID:                 getenvDefault("GCP_PROJECT", ""),
+		DSNamespace:               getenvDefault("DS_NAMESPACE", ""),
+		DSEndpoint:                getenvDefault("DS_ENDPOINT", ""),
+		BindAddr:                  getenvDefault("BIND_ADDR", ":8080"), // reader defaults to 8080
+		MemcacheDiscoveryEndpoint: os.Getenv("MEMCACHE_DISCOVERY_ENDPOINT"),
+		MemcacheServers:           os.Getenv("MEMCACHE_SERVERS"),
+		StoreFaults:               urlstore.FaultConfigFromEnv(),
+		SlowStoreThreshold:        getenvDuration("STORE_SLOW_THRESHOLD", urlstore.DefaultSlowThreshold),
+		MicroCacheTTL:             microCacheTTL,
+		MicroCacheMissTTL:         getenvDuration("MICRO_CACHE_MISS_TTL", microCacheTTL),
+		MicroCacheMaxStale:        getenvDuration("MICRO_CACHE_MAX_STALE", 0),
+		ReadStaleness:             getenvDuration("STORE_READ_STALENESS", 0),
+		TrustedProxies:            getenvCIDRs("TRUSTED_PROXIES"),
+		AccessLog:                 getenvBool("ACCESS_LOG"),
+		ServerTiming:              servertiming.Mode(os.Getenv("SERVER_TIMING")),
+		Security: httpmw.Security{
+			HSTSMaxAge:            getenvDuration("HSTS_MAX_AGE", 0),
+			HSTSIncludeSubdomains: getenvBool("HSTS_INCLUDE_SUBDOMAINS"),
+			HSTSPreload:           getenvBool("HSTS_PRELOAD"),
+			ReferrerPolicy:        getenvDefault("REFERRER_POLICY", "strict-origin-when-cross-origin"),
+		},
+		RedirectReferrerPolicy: os.Getenv("REDIRECT_REFERRER_POLICY"),
+		RedirectPage:           getenvBool("REDIRECT_PAGE"),
+		ScrubQueryParams:       getenvList("SCRUB_QUERY_PARAMS", queryscrub.Default),
+		MultiTenant:            getenvBool("MULTI_TENANT"),
+		CaseInsensitiveKeys:    getenvBool("KEY_CASE_INSENSITIVE"),
+		TrimKeyPunctuation:     getenvBool("KEY_TRIM_PUNCTUATION"),
+		CanonicalSlashes:       getenvBool("CANONICAL_SLASHES"),
+		KeyChecksum:            getenvInt("KEY_CHECKSUM", 0),
+		RewriteRulesFile:       os.Getenv("REWRITE_RULES_FILE"),
+		InterstitialTemplates:  os.Getenv("INTERSTITIAL_TEMPLATES"),
+		ArchiveBucket:          os.Getenv("ARCHIVE_BUCKET"),
+		ShadowProjectID:        os.Getenv("SHADOW_GCP_PROJECT"),
+		ShadowNamespace:        os.Getenv("SHADOW_DS_NAMESPACE"),
+		ShadowReadRate:         getenvFloat("SHADOW_READ_RATE", 1),
+		RedirectLimit:          loadshed.ConfigFromEnv("REDIRECT"),
+		PreviewLimit:           loadshed.ConfigFromEnv("PREVIEW"),
+		Degrade:                degrade.ConfigFromEnv("DEGRADE"),
+		Fallback:               fallback.ConfigFromEnv(),
+		WarmupKeys:             getenvInt("WARMUP_KEYS", 100),
+		Lifecycle:              lifecycle.ConfigFromEnv(),
+		StatuszAddr:            getenvDefault("STATUSZ_ADDR", ":9090"),
+		TargetKMSKey:           os.Getenv("TARGET_KMS_KEY"),
+		TargetWrappedKey:       os.Getenv("TARGET_WRAPPED_KEY"),
+		PreviewTokenKeys:       os.Getenv("PREVIEW_TOKEN_KEYS"),
+		PreviewTokenRate:       getenvInt("PREVIEW_TOKEN_RATE", 60),
+		ScanDetect:             scandetect.ConfigFromEnv(),
+		KeyRedirectRate:        getenvInt("KEY_REDIRECT_RATE", 0),
+	}
+}
+
+// Dependencies names the endpoints the reader talks to, for the status
+// page.
+func (cfg Config) Dependencies() map[string]string {
+	deps := map[string]string{"datastore": datastoreEndpoint(cfg.DSEndpoint, cfg.ProjectID, cfg.DSNamespace)}
+	if cfg.MemcacheDiscoveryEndpoint != "" {
+		deps["memcache_discovery"] = cfg.MemcacheDiscoveryEndpoint
+	}
+	if cfg.MemcacheServers != "" {
+		deps["memcache"] = cfg.MemcacheServers
+	}
+	if cfg.ShadowProjectID != "" || cfg.ShadowNamespace != "" {
+		deps["shadow_datastore"] = datastoreEndpoint(cfg.DSEndpoint, cmp.Or(cfg.ShadowProjectID, cfg.ProjectID), cfg.ShadowNamespace)
+	}
+	if cfg.TargetKMSKey != "" {
+		deps["kms"] = cfg.TargetKMSKey
+	}
+	for i, u := range cfg.Fallback.URLs {
+		deps[fmt.Sprintf("fallback_%d", i+1)] = u
+	}
+	return deps
+}
+
+// datastoreEndpoint describes a Datastore connection.
+func datastoreEndpoint(endpoint, project, namespace string) string {
+	return fmt.Sprintf("%s project=%q namespace=%q", cmp.Or(endpoint, "datastore.googleapis.com"), project, namespace)
+}
+
+// microCached wraps store with the micro-cache, if enabled.
+func (cfg Config) microCached(store urlstore.Client) urlstore.Client {
+	if cfg.MicroCacheTTL <= 0 {
+		return store
+	}
+	c := urlstore.WithMicroCache(store, cfg.MicroCacheTTL)
+	c.SetMissTTL(cfg.MicroCacheMissTTL)
+	c.SetMaxStale(cfg.MicroCacheMaxStale)
+	return c
+}
+
+// staleReads lets the store reads of requests be as stale as
+// ReadStaleness; nil when reads are strong.
+func (cfg Config) staleReads() httpmw.Middleware {
+	if cfg.ReadStaleness <= 0 {
+		return nil
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, r.WithContext(urlstore.WithReadStaleness(r.Context(), cfg.ReadStaleness)))
+		})
+	}
+}
+
+// storeSetup is the part of cfg wrapping the reader's stores.
+func (cfg Config) storeSetup() urlstore.Setup {
+	return urlstore.Setup{
+		ProjectID:        cfg.ProjectID,
+		DSNamespace:      cfg.DSNamespace,
+		DSEndpoint:       cfg.DSEndpoint,
+		SlowThreshold:    cfg.SlowStoreThreshold,
+		MeterProvider:    cfg.MeterProvider,
+		Location:         cfg.Location,
+		TargetKMSKey:     cfg.TargetKMSKey,
+		TargetWrappedKey: cfg.TargetWrappedKey,
+		ShadowProjectID:  cfg.ShadowProjectID,
+		ShadowNamespace:  cfg.ShadowNamespace,
+		ShadowReadRate:   cfg.ShadowReadRate,
+	}
+}
+
+func getenvDuration(k string, def time.Duration) time.Duration {
+	v := os.Getenv(k)
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		log.Printf("ignoring invalid %s: %q", k, v)
+		return def
+	}
+	return d
+}
+
+func getenvFloat(k string, def float64) float64 {
+	v := os.Getenv(k)
+	if v == "" {
+		return def
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		log.Printf("ignoring invalid %s: %v", k, err)
+		return def
+	}
+	return f
+}
+
+func getenvInt(k string, def int) int {
+	v := os.Getenv(k)
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		log.Printf("ignoring invalid %s: %q", k, v)
+		return def
+	}
+	return n
+}
+
+func getenvBool(k string) bool {
+	v := os.Getenv(k)
+	if v == "" {
+		return false
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		log.Printf("ignoring invalid %s: %q", k, v)
+		return false
+	}
+	return b
+}
+
+// getenvList parses a comma-separated list, def when unset.
+func getenvList(k string, def []string) []string {
+	v := os.Getenv(k)
+	if v == "" {
+		return def
+	}
+	return strings.Split(v, ",")
+}
+
+func getenvCIDRs(k string) []netip.Prefix {
+	p, err := ipfilter.ParseCIDRs(os.Getenv(k))
+	if err != nil {
+		log.Printf("ignoring invalid %s: %v", k, err)
+		return nil
+	}
+	return p
+}
+
+// Handler serves redirects, with its dependencies.
+type Handler struct {
+	store urlstore.Client
+	// entries serves full entry reads (e.g. previews), bypassing the
+	// redirect cache which only holds targets.
+	entries urlstore.Client
+
+	// serve routes requests once their client IP has been resolved.
+	serve http.Handler
+
+	// foldCase and trimPunctuation canonicalize unknown keys, and
+	// canonicalSlashes paths with empty segments.
+	foldCase         bool
+	trimPunctuation  bool
+	canonicalSlashes bool
+	// keyChecksum is the number of check characters of generated keys.
+	keyChecksum int
+	// redirectPolicy is the Referrer-Policy header of redirects to
+	// targets, nil to keep that of every response; redirectPage sends
+	// them with a page.
+	redirectPolicy []string
+	redirectPage   bool
+	// scrubParams are the parameters removed from forwarded queries.
+	scrubParams []string
+	// rewrite rewrites targets on redirect; nil when there are no rules.
+	rewrite *rewrite.File
+	// interstitials holds the templates of the pages shown before
+	// redirecting, by name.
+	interstitials *template.Template
+	// fallback resolves unknown keys; nil when off, and for tenants.
+	fallback fallback.Resolver
+	// archive holds the links archived from restoreTo, the store they are
+	// restored to, and usage records the links served; all nil when
+	// archiving is off, and for tenants.
+	archive   archive.Store
+	restoreTo urlstore.Client
+	usage     *archive.Tracker
+	// redirectLimit and previewLimit shed excess requests; nil when off.
+	redirectLimit *loadshed.Limiter
+	previewLimit  *loadshed.Limiter
+	// degrade watches redirects, and tells when to drop usage tracking
+	// and access log lines; nil when off.
+	degrade *degrade.Switch
+	// previewTokens verifies the tokens previews require, and
+	// previewThrottle bounds their use; nil when previews are open.
+	previewTokens   *hmacsig.Keyring
+	previewThrottle *viewtoken.Throttle
+	// scans watches for key enumeration; nil when off.
+	scans *scandetect.Detector
+	// throttle caps the redirects of links per second, at most
+	// keyRedirectRate unless the link caps them lower.
+	throttle        *keyThrottle
+	keyRedirectRate int
+	// ready answers readiness probes; warmupKeys is the number of links
+	// Warm loads.
+	ready      *lifecycle.Readiness
+	warmupKeys int
+
+	// tenants and scopes are set in multi-tenant mode, where requests are
+	// served by the copy of the handler bound to their host's tenant.
+	tenants *tenant.Registry
+	scopes  *tenant.Scopes[*Handler]
+	scoped  bool
+	// tenantID is the tenant of a scoped handler.
+	tenantID string
+
+	// caches are the cache layers of the default store by name, for
+	// CacheStats.
+	caches map[string]cacheLayer
+
+	// cleanup for dependencies (store, datastore client)
+	closeFn func() error
+}
+
+// cacheLayer is a cache of the store reporting its stats.
+type cacheLayer interface {
+	CacheStats() urlstore.CacheStats
+}
+
+// NewHandler constructs the handler with dependencies (Datastore client, store, optional Memcache via discovery).
+func NewHandler(ctx context.Context, cfg Config) (*Handler, error) {
+	setup := cfg.storeSetup()
+	targets, err := setup.TargetCipher(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.PreviewTokenKeys, err = gcputil.ResolveSecret(ctx, cfg.PreviewTokenKeys); err != nil {
+		return nil, fmt.Errorf("preview token keys: %w", err)
+	}
+	if _, err := cfg.previewKeyring(); err != nil {
+		return nil, err
+	}
+	if _, err := cfg.interstitialTemplates(); err != nil {
+		return nil, err
+	}
+	if _, err := cfg.scrubParams(); err != nil {
+		return nil, err
+	}
+	if _, err := fallback.New(cfg.Fallback); err != nil {
+		return nil, err
+	}
+	if _, err := cfg.middleware(nil); err != nil {
+		return nil, err
+	}
+	if err := discovery.ValidateMemcache(cfg.MemcacheServers, cfg.MemcacheDiscoveryEndpoint); err != nil {
+		return nil, err
+	}
+	var rules *rewrite.File
+	if cfg.RewriteRulesFile != "" {
+		if rules, err = rewrite.OpenFile(cfg.RewriteRulesFile, 30*time.Second); err != nil {
+			return nil, err
+		}
+		log.Printf("target rewriting enabled: %d rules", len(rules.Rules().Rules))
+	}
+	dsClient, err := gcputil.NewDSClient(ctx, cfg.ProjectID, cfg.DSEndpoint, cfg.DSNamespace)
+	if err != nil {
+		if rules != nil {
+			rules.Close()
+		}
+		return nil, fmt.Errorf("datastore: %w", err)
+	}
+	var base urlstore.Client = setup.Logged(urlstore.NewClient(dsClient))
+	if cfg.StoreFaults.Enabled() {
+		log.Printf("store fault injection enabled: %+v", cfg.StoreFaults)
+		base = urlstore.WithFaults(base, cfg.StoreFaults)
+	}
+	if base, err = setup.Shadowed(ctx, base); err != nil {
+		dsClient.Close()
+		if rules != nil {
+			rules.Close()
+		}
+		return nil, err
+	}
+
+	var store urlstore.Client = base
+	caches := make(map[string]cacheLayer)
+
+	// If memcache servers or a discovery endpoint are provided, create a memcache client and wrap with cache-aside.
+	var mc *memcache.Client
+	if memcached := cmp.Or(cfg.MemcacheServers, cfg.MemcacheDiscoveryEndpoint); memcached != "" {
+		mc, err = discovery.NewMemcache(cfg.MemcacheServers, cfg.MemcacheDiscoveryEndpoint)
+		if err != nil {
+			log.Printf("memcache disabled (init failed for %s): %v", memcached, err)
+			mc = nil
+		} else {
+			log.Printf("memcache enabled: %s", memcached)
+			cached := urlstore.WithCacheAside(base, mc)
+			caches["memcache"] = cached
+			store = cached
+		}
+	} else {
+		log.Printf("memcache not configured; using Datastore only")
+	}
+	// Targets stay encrypted in memcache and are decrypted in process.
+	store = urlstore.Encrypted(store, targets)
+	if cfg.MicroCacheTTL > 0 {
+		log.Printf("micro-cache enabled: ttl %v, misses %v, max stale %v", cfg.MicroCacheTTL, cfg.MicroCacheMissTTL, cfg.MicroCacheMaxStale)
+	}
+	if cfg.ReadStaleness > 0 {
+		log.Printf("stale reads enabled: links read as of %v ago", min(cfg.ReadStaleness, urlstore.MaxReadStaleness))
+	}
+	store = cfg.microCached(store)
+	if micro, ok := store.(*urlstore.MicroCachedClient); ok {
+		caches["micro"] = micro
+	}
+	store = setup.Metered(store)
+
+	h := NewHandlerWithStore(cfg, store)
+	h.caches = caches
+	h.entries = setup.Metered(urlstore.Encrypted(base, targets))
+	h.rewrite = rules
+	stopTracking := func() {}
+	if cfg.ArchiveBucket != "" {
+		if h.archive, err = archive.NewGCSStore(ctx, cfg.ArchiveBucket, cfg.DSNamespace); err != nil {
+			dsClient.Close()
+			if rules != nil {
+				rules.Close()
+			}
+			return nil, fmt.Errorf("archive: %w", err)
+		}
+		log.Printf("archive: restoring links from gs://%s", cfg.ArchiveBucket)
+		h.restoreTo = base
+		h.usage = archive.NewTracker(archive.NewDSUsageStore(dsClient))
+		trackCtx, cancel := context.WithCancel(context.Background())
+		tracked := make(chan struct{})
+		go func() {
+			defer close(tracked)
+			h.usage.Run(trackCtx, time.Minute)
+		}()
+		stopTracking = func() {
+			cancel()
+			<-tracked
+		}
+	}
+	if cfg.MultiTenant {
+		// Tenant stores share the connection and the cache, which the
+		// default store closes.
+		h.enableTenancy(tenant.NewRegistry(tenant.NewDSStore(dsClient)), func(t tenant.Tenant) (urlstore.Client, urlstore.Client) {
+			var base urlstore.Client = setup.Logged(urlstore.NewClient(dsClient.WithNamespace(t.Namespace)))
+			if cfg.StoreFaults.Enabled() {
+				base = urlstore.WithFaults(base, cfg.StoreFaults)
+			}
+			store := base
+			if mc != nil {
+				store = urlstore.WithCacheAside(base, urlstore.WithKeyPrefix(mc, t.CacheKeyPrefix()))
+			}
+			store = setup.Metered(cfg.microCached(urlstore.Encrypted(store, targets)))
+			return store, setup.Metered(urlstore.Encrypted(base, targets))
+		})
+	}
+	h.closeFn = func() error {
+		stopTracking()
+		if rules != nil {
+			rules.Close()
+		}
+		var cerr error
+		if h.store != nil {
+			if err := h.store.Close(); err != nil {
+				cerr = errors.Join(cerr, err)
+			}
+		}
+		if err := dsClient.Close(); err != nil {
+			cerr = errors.Join(cerr, err)
+		}
+		return cerr
+	}
+	return h, nil
+}
+
+// CacheStats returns the stats of the cache layers of the default store
+// by name, "micro" and "memcache" when enabled, for the status page. The
+// stores of tenants have micro-caches of their own, left out.
+func (h *Handler) CacheStats() map[string]urlstore.CacheStats {
+	stats := make(map[string]urlstore.CacheStats, len(h.caches))
+	for name, c := range h.caches {
+		stats[name] = c.CacheStats()
+	}
+	return stats
+}
+
+// DegradeStats returns the state of the degradation switch, for the
+// status page.
+func (h *Handler) DegradeStats() degrade.Stats {
+	return h.degrade.Stats()
+}
+
+// previewKeyring parses PreviewTokenKeys; it returns nil when previews
+// need no token.
+func (cfg Config) previewKeyring() (*hmacsig.Keyring, error) {
+	if cfg.PreviewTokenKeys == "" {
+		return nil, nil
+	}
+	k, err := hmacsig.ParseKeyring(cfg.PreviewTokenKeys)
+	if err != nil {
+		return nil, fmt.Errorf("preview token keys: %w", err)
+	}
+	return k, nil
+}
+
+// middleware returns the middlewares run before routing, which log no
+// access while degraded.
+func (cfg Config) middleware(degraded *degrade.Switch) (httpmw.Middleware, error) {
+	var quiet func() bool
+	if degraded != nil {
+		quiet = degraded.Degraded
+	}
+	common, err := httpmw.Common(httpmw.Options{
+		Service:        "reader",
+		TrustedProxies: cfg.TrustedProxies,
+		AccessLog:      cfg.AccessLog,
+		Quiet:          quiet,
+		ServerTiming:   cfg.ServerTiming,
+		MeterProvider:  cfg.MeterProvider,
+		Attributes:     cfg.Location.Attributes(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	security, err := httpmw.SecurityHeaders(cfg.Security)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.RedirectReferrerPolicy != "" {
+		if err := httpmw.CheckReferrerPolicy(cfg.RedirectReferrerPolicy); err != nil {
+			return nil, fmt.Errorf("redirects: %w", err)
+		}
+	}
+	return httpmw.Chain(common, security), nil
+}
+
+// redirectPolicy returns the Referrer-Policy header value of redirects to
+// targets, nil to keep that of every response.
+func (cfg Config) redirectPolicy() []string {
+	switch {
+	case cfg.RedirectReferrerPolicy != "":
+		return []string{cfg.RedirectReferrerPolicy}
+	case cfg.RedirectPage:
+		return []string{"no-referrer"}
+	}
+	return nil
+}
+
+// readerParams are the query parameters of the reader itself, never
+// forwarded: the click-through of interstitials and preview tokens.
+var readerParams = []string{continueParam, "token"}
+
+// scrubParams returns the parameters scrubbed from forwarded queries.
+func (cfg Config) scrubParams() ([]string, error) {
+	params, err := queryscrub.Normalize(cfg.ScrubQueryParams)
+	if err != nil {
+		return nil, fmt.Errorf("scrubbed query parameters: %w", err)
+	}
+	return append(params, readerParams...), nil
+}
+
+// referrerPolicy returns the Referrer-Policy header value of the redirect
+// to the target of entry.
+func (h *Handler) referrerPolicy(entry urlstore.URLEntry) []string {
+	if entry.ReferrerPolicy != "" {
+		return []string{entry.ReferrerPolicy}
+	}
+	return h.redirectPolicy
+}
+
+// NewHandlerWithStore constructs a handler on top of an existing store.
+// The caller keeps ownership of the store; Close does not close it.
+// In multi-tenant mode, tenants and their links are kept in memory. It
+// panics if the preview token keys, the security headers, the redirect
+// Referrer-Policy, the interstitial templates, the scrubbed query
+// parameters or the fallback config are invalid, or if the request
+// metrics cannot be set up; NewHandler reports those as errors.
+func NewHandlerWithStore(cfg Config, store urlstore.Client) *Handler {
+	previewTokens, err := cfg.previewKeyring()
+	if err != nil {
+		panic(err)
+	}
+	degraded := degrade.New("redirects", cfg.Degrade)
+	common, err := cfg.middleware(degraded)
+	if err != nil {
+		panic(err)
+	}
+	interstitials, err := cfg.interstitialTemplates()
+	if err != nil {
+		panic(err)
+	}
+	scrubParams, err := cfg.scrubParams()
+	if err != nil {
+		panic(err)
+	}
+	fallbacks, err := fallback.New(cfg.Fallback)
+	if err != nil {
+		panic(err)
+	}
+	h := &Handler{
+		store:            store,
+		entries:          store,
+		foldCase:         cfg.CaseInsensitiveKeys,
+		trimPunctuation:  cfg.TrimKeyPunctuation,
+		canonicalSlashes: cfg.CanonicalSlashes,
+		keyChecksum:      cfg.KeyChecksum,
+		redirectPolicy:   cfg.redirectPolicy(),
+		redirectPage:     cfg.RedirectPage,
+		scrubParams:      scrubParams,
+		fallback:         fallbacks,
+		redirectLimit:    loadshed.New("redirects", cfg.RedirectLimit),
+		previewLimit:     loadshed.New("previews", cfg.PreviewLimit),
+		degrade:          degraded,
+		ready:            new(lifecycle.Readiness),
+		warmupKeys:       cfg.WarmupKeys,
+		previewTokens:    previewTokens,
+		interstitials:    interstitials,
+		scans:            scandetect.New(cfg.ScanDetect),
+		throttle:         newKeyThrottle(),
+		keyRedirectRate:  cfg.KeyRedirectRate,
+	}
+	if previewTokens != nil {
+		h.previewThrottle = viewtoken.NewThrottle(cfg.PreviewTokenRate)
+	}
+	h.serve = httpmw.Chain(common, cfg.staleReads())(http.HandlerFunc(h.route))
+	if cfg.MultiTenant {
+		byNamespace := make(map[string]urlstore.Client)
+		var mu sync.Mutex
+		h.enableTenancy(tenant.NewRegistry(tenant.NewMemoryStore()), func(t tenant.Tenant) (urlstore.Client, urlstore.Client) {
+			mu.Lock()
+			defer mu.Unlock()
+			s, ok := byNamespace[t.Namespace]
+			if !ok {
+				s = urlstore.NewMemoryClient()
+				byNamespace[t.Namespace] = s
+			}
+			return s, s
+		})
+	}
+	return h
+}
+
+// enableTenancy serves every tenant with a copy of h bound to the stores
+// open returns for it: the redirect store and the uncached entries.
+func (h *Handler) enableTenancy(tenants *tenant.Registry, open func(tenant.Tenant) (store, entries urlstore.Client)) {
+	h.tenants = tenants
+	h.scopes = tenant.NewScopes(func(t tenant.Tenant) *Handler {
+		scoped := *h
+		scoped.store, scoped.entries = open(t)
+		scoped.scoped = true
+		scoped.tenantID = t.ID
+		scoped.archive, scoped.restoreTo, scoped.usage = nil, nil, nil
+		scoped.fallback = nil
+		return &scoped
+	})
+}
+
+// scope returns the handler of the tenant served on the request's host. It
+// reports an error to the client and returns false for unknown hosts.
+func (h *Handler) scope(w http.ResponseWriter, r *http.Request) (*Handler, bool) {
+	t, err := h.tenants.ForDomain(r.Context(), r.Host)
+	switch {
+	case errors.Is(err, tenant.ErrNotFound):
+		http.NotFound(w, r)
+		return nil, false
+	case err != nil:
+		log.Printf("Error resolving tenant of %q: %v", r.Host, err)
+		http.Error(w, "failed to resolve tenant", http.StatusServiceUnavailable)
+		return nil, false
+	}
+	return h.scopes.Get(t), true
+}
+
+// Named handler for /health
+func (h *Handler) handleHealth(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+}
+
+// Readiness returns the readiness reported on /ready. It is false until
+// set, e.g. by lifecycle.Run once Warm returns.
+func (h *Handler) Readiness() *lifecycle.Readiness {
+	return h.ready
+}
+
+// Warm readies the dependencies before the handler takes traffic: a lookup
+// opens the store connections, memcache included, while the newest links
+// are listed, then loaded through the caches warmupConcurrency at a time.
+// Tenant stores are left to warm on use.
+func (h *Handler) Warm(ctx context.Context) error {
+	g, gctx := errgroup.WithContext(ctx)
+	g.Go(func() error {
+		_, err := h.store.GetEntry(gctx, urlstore.UrlKey("health"))
+		if err != nil && !errors.Is(err, urlstore.ErrNotFound) {
+			return fmt.Errorf("store: %w", err)
+		}
+		return nil
+	})
+	var page urlstore.ListPage
+	if h.warmupKeys > 0 {
+		g.Go(func() error {
+			var err error
+			if page, err = h.entries.ListEntries(gctx, urlstore.ListOptions{Limit: h.warmupKeys, Order: urlstore.OrderByCreatedDesc}); err != nil {
+				return fmt.Errorf("listing links: %w", err)
+			}
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return err
+	}
+
+	g, gctx = errgroup.WithContext(ctx)
+	g.SetLimit(warmupConcurrency)
+	for _, e := range page.Entries {
+		g.Go(func() error {
+			if _, err := h.store.GetEntry(gctx, e.Key); err != nil && !errors.Is(err, urlstore.ErrNotFound) {
+				return fmt.Errorf("loading %q: %w", e.Key, err)
+			}
+			return nil
+		})
+	}
+	return g.Wait()
+}
+
+// warmupConcurrency bounds the links Warm loads at once.
+const warmupConcurrency = 8
+
+// Implement http.Handler: run the common middlewares, then route.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.serve.ServeHTTP(w, r)
+}
+
+// route dispatches to named handlers and path-based keys.
+func (h *Handler) route(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.URL.Path == "/health" && r.Method == http.MethodGet:
+		h.handleHealth(w, r)
+	case r.URL.Path == "/ready" && r.Method == http.MethodGet:
+		h.ready.ServeHTTP(w, r)
+	case h.tenants != nil && !h.scoped:
+		if scoped, ok := h.scope(w, r); ok {
+			scoped.route(w, r)
+		}
+	case strings.HasPrefix(r.URL.Path, "/preview/") && r.Method == http.MethodGet:
+		release, ok := h.previewLimit.Acquire(w, r)
+		if !ok {
+			return
+		}
+		defer release()
+		// As links, previews are served with a trailing slash.
+		key := strings.TrimRight(strings.TrimPrefix(r.URL.Path, "/preview/"), "/")
+		if !h.checkScan(w, r, key) {
+			return
+		}
+		h.handlePreview(w, r, key)
+	default:
+		// Support path-based keys: GET /{key}, GET /{prefix}/{rest...}
+		if r.Method == http.MethodGet {
+			path, redirect := requestPath(r.URL, h.canonicalSlashes)
+			if key := extractKeyFromPath(path); key != "" {
+				if redirect != "" {
+					http.Redirect(w, r, canonicalURL(strings.TrimPrefix(redirect, "/"), r.URL.RawQuery), http.StatusMovedPermanently)
+					return
+				}
+				if !h.checkScan(w, r, key) {
+					return
+				}
+				release, ok := h.redirectLimit.Acquire(w, r)
+				if !ok {
+					return
+				}
+				defer release()
+				w, done := h.degrade.Track(w)
+				defer done()
+				h.redirectByPath(w, r, path)
+				return
+			}
+		}
+		http.NotFound(w, r)
+	}
+}
+
+// checkScan records the lookup of key for scan detection, and rejects
+// clients banned for scanning.
+func (h *Handler) checkScan(w http.ResponseWriter, r *http.Request, key string) bool {
+	if h.scans == nil {
+		return true
+	}
+	addr, now := clientip.FromRequest(r), time.Now()
+	if h.scans.Banned(addr, now) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return false
+	}
+	h.scans.Observe(addr, key, now)
+	return true
+}
+
+type previewResponse struct {
+	URLKey    string                `json:"url_key"`
+	URLTarget string                `json:"url_target"`
+	Preview   *urlstore.LinkPreview `json:"preview,omitempty"`
+}
+
+// Named handler for /preview/{key}: describes the target without redirecting.
+func (h *Handler) handlePreview(w http.ResponseWriter, r *http.Request, key string) {
+	if key == "" || len(key) > urlstore.MaxKeyLength {
+		http.NotFound(w, r)
+		return
+	}
+	if !h.checkViewToken(w, r, key) {
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	entry, err := h.entries.GetEntry(ctx, urlstore.UrlKey(key))
+	if errors.Is(err, urlstore.ErrNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		log.Printf("Error reading entry for key %q: %v", key, err)
+		http.Error(w, "failed to read entry", http.StatusInternalServerError)
+		return
+	}
+	if _, entry, err = urlstore.ResolveAlias(ctx, h.entries, urlstore.UrlKey(key), entry); err != nil {
+		h.aliasFailed(w, r, key, err)
+		return
+	}
+	if !h.permitted(r, entry) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	if entry.BurnAfterReading {
+		// Previews would give the one-shot target away.
+		http.NotFound(w, r)
+		return
+	}
+
+	resp := previewResponse{URLKey: key, URLTarget: entry.TargetAt(time.Now())}
+	if resp.URLTarget == entry.URLTarget {
+		// The scraped preview describes the default target only.
+		resp.Preview = entry.Preview
+	}
+	body, err := json.Marshal(resp)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	// Clients polling for changes revalidate with If-None-Match or
+	// If-Modified-Since, which ServeContent answers with 304.
+	sum := sha256.Sum256(body)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", `"`+hex.EncodeToString(sum[:12])+`"`)
+	http.ServeContent(w, r, "", entry.ModifiedAt(), bytes.NewReader(body))
+}
+
+// checkViewToken reports a preview request without a valid token for key
+// to the client, when previews need one. Tokens come in the token query
+// parameter or as a bearer token.
+func (h *Handler) checkViewToken(w http.ResponseWriter, r *http.Request, key string) bool {
+	if h.previewTokens == nil {
+		return true
+	}
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		token, _ = strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	}
+	now := time.Now()
+	c, err := viewtoken.Verify(h.previewTokens, token, now)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return false
+	}
+	if c.Tenant != h.tenantID || !c.Allows(key) {
+		http.Error(w, "token does not grant this key", http.StatusForbidden)
+		return false
+	}
+	if ok, wait := h.previewThrottle.Allow(c.ID, now); !ok {
+		w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+		http.Error(w, "too many previews for this token", http.StatusTooManyRequests)
+		return false
+	}
+	return true
+}
+
+// extractKeyFromPath returns the first segment of a request path, the key
+// a link that serves no other way is looked up by, or "" for the health
+// and readiness paths.
+func extractKeyFromPath(p string) string {
+	trim := strings.Trim(p, "/")
+	if trim == "" || trim == "health" || trim == "ready" {
+		return ""
+	}
+	// first segment is the key
+	key, _, _ := strings.Cut(trim, "/")
+	return key
+}
+
+// redirectByPath redirects to the target of the entry serving path (see
+// resolvePath).
+func (h *Handler) redirectByPath(w http.ResponseWriter, r *http.Request, path string) {
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	key, entry, rest, err := h.resolvePath(ctx, path)
+	if errors.Is(err, urlstore.ErrNotFound) && h.archive != nil {
+		key, entry, rest, err = h.restorePath(ctx, path, key)
+	}
+	if errors.Is(err, urlstore.ErrNotFound) {
+		h.redirectToCanonical(ctx, w, r, path, key)
+		return
+	}
+	if err != nil {
+		log.Printf("Error reading entry for key %q: %v", key, err)
+		http.Error(w, "failed to read entry", http.StatusInternalServerError)
+		return
+	}
+	if entry.AliasOf != "" {
+		// The alias is served as its canonical link, which its use
+		// counts for too.
+		alias := key
+		if h.usage != nil && !h.degrade.Degraded() {
+			h.usage.Touch(urlstore.UrlKey(alias))
+		}
+		var canonical urlstore.UrlKey
+		canonical, entry, err = urlstore.ResolveAlias(ctx, h.store, urlstore.UrlKey(alias), entry)
+		if err != nil {
+			h.aliasFailed(w, r, alias, err)
+			return
+		}
+		key = string(canonical)
+	}
+	if h.usage != nil && !h.degrade.Degraded() {
+		h.usage.Touch(urlstore.UrlKey(key))
+	}
+	if !h.permitted(r, entry) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	continued := continuing(r, entry)
+	if !continued && !referer.Allows(entry.AllowedReferers, r.Referer()) {
+		// Hotlinked from a site the link is not meant for.
+		http.Error(w, "this link cannot be followed from here", http.StatusForbidden)
+		return
+	}
+	if entry.Interstitial != "" && !continued {
+		// The click-through comes back with the continue parameter;
+		// one-shot links are only burnt then.
+		h.serveInterstitial(w, r, key, entry, h.target(r, key, entry, rest))
+		return
+	}
+	if limit := h.redirectRate(entry); limit > 0 {
+		if ok, wait := h.throttle.allow(h.tenantID, key, limit, time.Now()); !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+			http.Error(w, "too many redirects through this link, try again later", http.StatusTooManyRequests)
+			return
+		}
+	}
+	if entry.BurnAfterReading {
+		// Only the request that invalidates the entry is redirected.
+		entry, err = urlstore.Burn(ctx, h.entries, urlstore.UrlKey(key))
+		if errors.Is(err, urlstore.ErrNotFound) {
+			http.NotFound(w, r)
+			return
+		}
+		if err != nil {
+			log.Printf("Error burning entry for key %q: %v", key, err)
+			http.Error(w, "failed to read entry", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Cache-Control", "no-store")
+	}
+
+	h.redirectToTarget(w, r, h.target(r, key, entry, rest), h.referrerPolicy(entry))
+}
+
+// redirect answers code to target as http.Redirect does, but sends
+// absolute targets, those of links, as they are without parsing them nor
+// a body, so the redirects of the hot path allocate as little as may be.
+func redirect(w http.ResponseWriter, r *http.Request, target string, code int) {
+	if !strings.Contains(target, "://") {
+		http.Redirect(w, r, target, code)
+		return
+	}
+	w.Header()["Location"] = []string{target}
+	w.WriteHeader(code)
+}
+
+// target is where the entry under key redirects r, given the path
+// segments left for a wildcard.
+func (h *Handler) target(r *http.Request, key string, entry urlstore.URLEntry, rest []string) string {
+	target := entry.TargetAt(time.Now())
+	if urlstore.UrlKey(key).IsWildcard() {
+		target = expandTarget(target, rest)
+	}
+	if h.rewrite != nil {
+		target = h.rewrite.Rules().Apply(target)
+	}
+	if entry.ForwardQuery {
+		target = appendQuery(target, queryscrub.Scrub(r.URL.RawQuery, h.scrubParams, entry.ScrubParams))
+	}
+	return target
+}
+
+// appendQuery appends query to that of target, before its fragment.
+func appendQuery(target, query string) string {
+	if query == "" {
+		return target
+	}
+	target, fragment, hasFragment := strings.Cut(target, "#")
+	switch {
+	case !strings.Contains(target, "?"):
+		target += "?" + query
+	case strings.HasSuffix(target, "?") || strings.HasSuffix(target, "&"):
+		target += query
+	default:
+		target += "&" + query
+	}
+	if hasFragment {
+		target += "#" + fragment
+	}
+	return target
+}
+
+// restorePath restores the link serving path from the archive, the one
+// under the whole path or else under missing, the key resolvePath did not
+// find, and returns it like resolvePath. Wildcard links are only restored
+// when requested by their key. Archive failures are logged and reported
+// as not found.
+func (h *Handler) restorePath(ctx context.Context, path, missing string) (string, urlstore.URLEntry, []string, error) {
+	keys := []string{missing}
+	if path != missing {
+		keys = []string{path, missing}
+	}
+	for _, key := range keys {
+		_, err := archive.Restore(ctx, h.archive, h.restoreTo, urlstore.UrlKey(key))
+		if errors.Is(err, archive.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			log.Printf("archive: restoring %q: %v", key, err)
+			break
+		}
+		// Read it back through the store, which decrypts the target.
+		entry, err := h.entries.GetEntry(ctx, urlstore.UrlKey(key))
+		return key, entry, nil, err
+	}
+	return missing, urlstore.URLEntry{}, nil, urlstore.ErrNotFound
+}
+
+// redirectToCanonical handles an unknown key of path: if its canonical
+// form exists, the client is sent to that short URL for good (301), which
+// then redirects as usual. Otherwise the key is not found.
+func (h *Handler) redirectToCanonical(ctx context.Context, w http.ResponseWriter, r *http.Request, path, key string) {
+	canonical := h.canonicalKey(key)
+	if canonical == key || canonical == "" || len(canonical) > urlstore.MaxKeyLength {
+		h.notFound(ctx, w, r, path, key)
+		return
+	}
+	_, err := h.store.GetEntry(ctx, urlstore.UrlKey(canonical))
+	if errors.Is(err, urlstore.ErrNotFound) {
+		h.notFound(ctx, w, r, path, canonical)
+		return
+	}
+	if err != nil {
+		log.Printf("Error reading entry for key %q: %v", canonical, err)
+		http.Error(w, "failed to read entry", http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, canonicalURL(canonical, r.URL.RawQuery), http.StatusMovedPermanently)
+}
+
+// aliasFailed reports an alias whose canonical link could not be read.
+// Missing canonical links and broken chains, which the writer refuses to
+// create, answer 404.
+func (h *Handler) aliasFailed(w http.ResponseWriter, r *http.Request, alias string, err error) {
+	switch {
+	case errors.Is(err, urlstore.ErrNotFound):
+		http.NotFound(w, r)
+	case errors.Is(err, urlstore.ErrAliasChain):
+		log.Printf("alias: %q: %v", alias, err)
+		http.NotFound(w, r)
+	default:
+		log.Printf("Error resolving alias %q: %v", alias, err)
+		http.Error(w, "failed to read entry", http.StatusInternalServerError)
+	}
+}
+
+// permitted reports whether the client may resolve entry, given its
+// optional IP restriction. Unparseable ranges deny rather than allow.
+func (h *Handler) permitted(r *http.Request, entry urlstore.URLEntry) bool {
+	if len(entry.AllowedCIDRs) == 0 {
+		return true
+	}
+	allow, err := ipfilter.ParseCIDRs(strings.Join(entry.AllowedCIDRs, ","))
+	if err != nil {
+		log.Printf("invalid allowed_cidrs %q: %v", entry.AllowedCIDRs, err)
+		return false
+	}
+	return ipfilter.List{Allow: allow}.Permits(clientip.FromRequest(r))
+}
+
+// Close releases handler resources (store, datastore client).
+func (h *Handler) Close() error {
+	if h.closeFn != nil {
+		return h.closeFn()
+	}
+	return nil
+}
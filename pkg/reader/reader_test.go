@@ -0,0 +1,1023 @@
+package reader
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/FlorinBalint/shortener/pkg/archive"
+	"github.com/FlorinBalint/shortener/pkg/degrade"
+	"github.com/FlorinBalint/shortener/pkg/fallback"
+	"github.com/FlorinBalint/shortener/pkg/hmacsig"
+	"github.com/FlorinBalint/shortener/pkg/httpmw"
+	"github.com/FlorinBalint/shortener/pkg/keycheck"
+	"github.com/FlorinBalint/shortener/pkg/rewrite"
+	"github.com/FlorinBalint/shortener/pkg/scandetect"
+	"github.com/FlorinBalint/shortener/pkg/tenant"
+	"github.com/FlorinBalint/shortener/pkg/urlstore"
+	"github.com/FlorinBalint/shortener/pkg/viewtoken"
+)
+
+func TestRedirect_AllowedCIDRs(t *testing.T) {
+	store := urlstore.NewMemoryClient()
+	err := store.CreateEntry(context.Background(), "internal", urlstore.URLEntry{
+		URLTarget:    "https://intranet.example.com",
+		AllowedCIDRs: []string{"10.0.0.0/8"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := NewHandlerWithStore(Config{
+		TrustedProxies: []netip.Prefix{netip.MustParsePrefix("192.0.2.0/24")}, // httptest's RemoteAddr
+	}, store)
+
+	tests := []struct {
+		name, path, xff string
+		want            int
+	}{
+		{"allowed", "/internal", "10.1.2.3", http.StatusFound},
+		{"spoofed hop before client", "/internal", "10.1.2.3, 198.51.100.7", http.StatusForbidden},
+		{"outside", "/internal", "198.51.100.7", http.StatusForbidden},
+		{"preview", "/preview/internal", "198.51.100.7", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+		req.Header.Set("X-Forwarded-For", tt.xff)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != tt.want {
+			t.Errorf("%s: want %d, got %d", tt.name, tt.want, rec.Code)
+		}
+	}
+}
+
+func TestRedirect_AllowedReferers(t *testing.T) {
+	store := urlstore.NewMemoryClient()
+	err := store.CreateEntry(context.Background(), "spring", urlstore.URLEntry{
+		URLTarget:       "https://shop.example.com/spring",
+		AllowedReferers: []string{"news.example.com", "none"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := NewHandlerWithStore(Config{}, store)
+
+	for ref, want := range map[string]int{
+		"https://news.example.com/issue-12": http.StatusFound,
+		"":                                  http.StatusFound,
+		"https://forum.example.net/t/123":   http.StatusForbidden,
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/spring", nil)
+		if ref != "" {
+			req.Header.Set("Referer", ref)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != want {
+			t.Errorf("referer %q: want %d, got %d", ref, want, rec.Code)
+		}
+	}
+}
+
+func TestRedirect_Interstitial(t *testing.T) {
+	dir := t.TempDir()
+	page := `<p>{{.TargetHost}} is not ours.</p><a href="{{.ContinueURL}}">continue</a>`
+	if err := os.WriteFile(filepath.Join(dir, "finance.html"), []byte(page), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	store := urlstore.NewMemoryClient()
+	ctx := context.Background()
+	for key, e := range map[string]urlstore.URLEntry{
+		"fund":    {URLTarget: "https://broker.example.com/fund", Interstitial: "finance", AllowedReferers: []string{"news.example.com"}},
+		"once":    {URLTarget: "https://broker.example.com/once", Interstitial: "finance", BurnAfterReading: true},
+		"unknown": {URLTarget: "https://broker.example.com/x", Interstitial: "missing"},
+	} {
+		if err := store.CreateEntry(ctx, urlstore.UrlKey(key), e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	h := NewHandlerWithStore(Config{InterstitialTemplates: dir}, store)
+	get := func(path, ref string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if ref != "" {
+			req.Header.Set("Referer", ref)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := get("/fund", "https://news.example.com/today")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("interstitial: want 200, got %d", rec.Code)
+	}
+	body := rec.Body.String()
+	if !strings.Contains(body, "broker.example.com is not ours.") || !strings.Contains(body, `href="/fund?continue=1"`) {
+		t.Fatalf("want the finance page, got %s", body)
+	}
+	// The click-through comes from the page, not an allowed referer.
+	if rec := get("/fund?continue=1", "http://example.com/fund"); rec.Code != http.StatusFound || rec.Header().Get("Location") != "https://broker.example.com/fund" {
+		t.Fatalf("click-through: want 302 to the target, got %d %q", rec.Code, rec.Header().Get("Location"))
+	}
+	if rec := get("/fund?continue=1", "https://forum.example.net/"); rec.Code != http.StatusForbidden {
+		t.Fatalf("click-through from elsewhere: want 403, got %d", rec.Code)
+	}
+
+	if rec := get("/once", ""); rec.Code != http.StatusOK {
+		t.Fatalf("one-shot interstitial: want 200, got %d", rec.Code)
+	}
+	if rec := get("/once?continue=1", "http://example.com/once"); rec.Code != http.StatusFound {
+		t.Fatalf("one-shot click-through: want 302, got %d", rec.Code)
+	}
+	if rec := get("/once?continue=1", "http://example.com/once"); rec.Code != http.StatusNotFound {
+		t.Fatalf("burnt: want 404, got %d", rec.Code)
+	}
+
+	if rec := get("/unknown", ""); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "You are leaving this site") {
+		t.Fatalf("unknown template: want the default page, got %d %s", rec.Code, rec.Body)
+	}
+}
+
+func TestRedirect_RestoresArchived(t *testing.T) {
+	ctx := context.Background()
+	store := urlstore.NewMemoryClient()
+	bucket := archive.NewMemoryStore()
+	if err := bucket.Put(ctx, "cold", urlstore.URLEntry{URLTarget: "https://example.com/cold"}); err != nil {
+		t.Fatal(err)
+	}
+	usage := archive.NewMemoryUsageStore()
+	h := NewHandlerWithStore(Config{}, store)
+	h.archive, h.restoreTo, h.usage = bucket, store, archive.NewTracker(usage)
+
+	for range 2 {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/cold", nil))
+		if rec.Code != http.StatusFound || rec.Header().Get("Location") != "https://example.com/cold" {
+			t.Fatalf("archived link: want 302 to its target, got %d %q", rec.Code, rec.Header().Get("Location"))
+		}
+	}
+	if _, err := store.GetEntry(ctx, "cold"); err != nil {
+		t.Fatalf("want the link restored, got %v", err)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/never", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("unknown key: want 404, got %d", rec.Code)
+	}
+
+	if err := h.usage.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := usage.Get(ctx, []urlstore.UrlKey{"cold", "never"}); len(got) != 1 || got["cold"].Day.IsZero() {
+		t.Fatalf("want the use of cold recorded, got %+v", got)
+	}
+}
+
+func TestRedirect_TenantByHost(t *testing.T) {
+	ctx := context.Background()
+	h := NewHandlerWithStore(Config{MultiTenant: true}, urlstore.NewMemoryClient())
+	for id, target := range map[string]string{"acme": "https://acme.example", "globex": "https://globex.example"} {
+		tn, err := h.tenants.Put(ctx, tenant.Tenant{ID: id, Domains: []string{"go." + id + ".example"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := h.scopes.Get(tn).store.CreateEntry(ctx, "promo", urlstore.URLEntry{URLTarget: target}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		host     string
+		want     int
+		location string
+	}{
+		{"go.acme.example", http.StatusFound, "https://acme.example"},
+		{"GO.globex.example:443", http.StatusFound, "https://globex.example"},
+		{"unknown.example", http.StatusNotFound, ""},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/promo", nil)
+		req.Host = tt.host
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != tt.want || rec.Header().Get("Location") != tt.location {
+			t.Errorf("%s: got %d to %q, want %d to %q", tt.host, rec.Code, rec.Header().Get("Location"), tt.want, tt.location)
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/health", nil)
+	req.Host = "unknown.example"
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Errorf("health on unknown host: want 200, got %d", rec.Code)
+	}
+}
+
+func TestRedirect_CanonicalKeys(t *testing.T) {
+	store := urlstore.NewMemoryClient()
+	for key, target := range map[string]string{"promo": "https://example.com/promo", "AbC9": "https://example.com/generated"} {
+		if err := store.CreateEntry(context.Background(), urlstore.UrlKey(key), urlstore.URLEntry{URLTarget: target}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	h := NewHandlerWithStore(Config{CaseInsensitiveKeys: true, TrimKeyPunctuation: true}, store)
+	plain := NewHandlerWithStore(Config{}, store)
+
+	tests := []struct {
+		h        *Handler
+		path     string
+		want     int
+		location string
+	}{
+		{h, "/promo", http.StatusFound, "https://example.com/promo"},
+		{h, "/AbC9", http.StatusFound, "https://example.com/generated"},
+		{h, "/Promo", http.StatusMovedPermanently, "/promo"},
+		{h, "/promo).", http.StatusMovedPermanently, "/promo"},
+		{h, "/PROMO,?utm_source=chat", http.StatusMovedPermanently, "/promo?utm_source=chat"},
+		{h, "/abc9", http.StatusNotFound, ""},
+		{h, "/missing.", http.StatusNotFound, ""},
+		{plain, "/Promo", http.StatusNotFound, ""},
+		{plain, "/promo.", http.StatusNotFound, ""},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		tt.h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+		if rec.Code != tt.want || rec.Header().Get("Location") != tt.location {
+			t.Errorf("%s: got %d to %q, want %d to %q", tt.path, rec.Code, rec.Header().Get("Location"), tt.want, tt.location)
+		}
+	}
+}
+
+func TestRedirect_TrailingSlash(t *testing.T) {
+	store := urlstore.NewMemoryClient()
+	for key, target := range map[string]string{"promo": "https://example.com/promo", "docs/*": "https://example.com/docs/{rest}"} {
+		if err := store.CreateEntry(context.Background(), urlstore.UrlKey(key), urlstore.URLEntry{URLTarget: target}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	h := NewHandlerWithStore(Config{CanonicalSlashes: true}, store)
+	plain := NewHandlerWithStore(Config{}, store)
+
+	tests := []struct {
+		h        *Handler
+		path     string
+		want     int
+		location string
+	}{
+		{plain, "/promo", http.StatusFound, "https://example.com/promo"},
+		{plain, "/promo/", http.StatusFound, "https://example.com/promo"},
+		{plain, "/docs/guide/", http.StatusFound, "https://example.com/docs/guide"},
+		{h, "/promo", http.StatusFound, "https://example.com/promo"},
+		{h, "/promo/?utm_source=chat", http.StatusMovedPermanently, "/promo?utm_source=chat"},
+		{h, "//docs//guide/", http.StatusMovedPermanently, "/docs/guide"},
+		{h, "/docs/guide", http.StatusFound, "https://example.com/docs/guide"},
+		{h, "/preview/promo/", http.StatusOK, ""},
+		{plain, "/preview/promo/", http.StatusOK, ""},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		tt.h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+		if rec.Code != tt.want || rec.Header().Get("Location") != tt.location {
+			t.Errorf("%s: got %d to %q, want %d to %q", tt.path, rec.Code, rec.Header().Get("Location"), tt.want, tt.location)
+		}
+	}
+}
+
+func TestRedirect_Schedule(t *testing.T) {
+	now := time.Now()
+	store := urlstore.NewMemoryClient()
+	entries := map[string]urlstore.URLEntry{
+		"live": {URLTarget: "https://example.com/recording", Schedule: []urlstore.ScheduledTarget{
+			{URLTarget: "https://example.com/live", From: now.Add(-time.Hour), Until: now.Add(time.Hour)},
+		}},
+		"ended": {URLTarget: "https://example.com/recording", Schedule: []urlstore.ScheduledTarget{
+			{URLTarget: "https://example.com/live", Until: now.Add(-time.Hour)},
+		}},
+	}
+	for key, e := range entries {
+		if err := store.CreateEntry(context.Background(), urlstore.UrlKey(key), e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	h := NewHandlerWithStore(Config{}, store)
+
+	for path, want := range map[string]string{"/live": "https://example.com/live", "/ended": "https://example.com/recording"} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != http.StatusFound || rec.Header().Get("Location") != want {
+			t.Errorf("%s: got %d to %q, want %q", path, rec.Code, rec.Header().Get("Location"), want)
+		}
+	}
+}
+
+func TestRedirect_Degraded(t *testing.T) {
+	ctx := context.Background()
+	store := urlstore.NewMemoryClient()
+	if err := store.CreateEntry(ctx, "promo", urlstore.URLEntry{URLTarget: "https://example.com/promo"}); err != nil {
+		t.Fatal(err)
+	}
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	usage := archive.NewMemoryUsageStore()
+	h := NewHandlerWithStore(Config{AccessLog: true, Degrade: degrade.Config{Mode: degrade.ModeOn}}, store)
+	h.usage = archive.NewTracker(usage)
+
+	logs.Reset()
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/promo", nil))
+	if rec.Code != http.StatusFound {
+		t.Fatalf("want the redirect served while degraded, got %d", rec.Code)
+	}
+	if err := h.usage.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := usage.Get(ctx, []urlstore.UrlKey{"promo"}); len(got) != 0 {
+		t.Errorf("want no usage tracked while degraded, got %+v", got)
+	}
+	if strings.Contains(logs.String(), "GET /promo") {
+		t.Errorf("want no access log while degraded, got %q", logs.String())
+	}
+	if st := h.DegradeStats(); !st.Degraded {
+		t.Errorf("DegradeStats() = %+v, want degraded", st)
+	}
+}
+
+func TestRedirect_Fallback(t *testing.T) {
+	legacy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/old", "/promo", "/team/docs":
+			http.Redirect(w, r, "https://legacy.example.com"+r.URL.Path, http.StatusMovedPermanently)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer legacy.Close()
+	store := urlstore.NewMemoryClient()
+	if err := store.CreateEntry(context.Background(), "promo", urlstore.URLEntry{URLTarget: "https://example.com/promo"}); err != nil {
+		t.Fatal(err)
+	}
+	h := NewHandlerWithStore(Config{Fallback: fallback.Config{URLs: []string{legacy.URL + "/{key}"}}}, store)
+
+	tests := []struct {
+		path     string
+		want     int
+		location string
+	}{
+		{"/promo", http.StatusFound, "https://example.com/promo"},
+		{"/old", http.StatusFound, "https://legacy.example.com/old"},
+		{"/team/docs", http.StatusFound, "https://legacy.example.com/team/docs"},
+		{"/unknown", http.StatusNotFound, ""},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+		if rec.Code != tt.want || rec.Header().Get("Location") != tt.location {
+			t.Errorf("%s: got %d to %q, want %d to %q", tt.path, rec.Code, rec.Header().Get("Location"), tt.want, tt.location)
+		}
+	}
+}
+
+func TestRedirect_KeyChecksum(t *testing.T) {
+	store := urlstore.NewMemoryClient()
+	key := keycheck.Append("1gXyZ42kQ", 1)
+	for _, k := range []string{key, "1gXyZ42kQ"} {
+		if err := store.CreateEntry(context.Background(), urlstore.UrlKey(k), urlstore.URLEntry{URLTarget: "https://example.com/" + k}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	h := NewHandlerWithStore(Config{KeyChecksum: 1}, store)
+	mistyped := "1gXyZ42kq" + key[9:]
+
+	tests := []struct {
+		path     string
+		want     int
+		mistyped bool
+	}{
+		{"/" + key, http.StatusFound, false},
+		// Keys from before check characters were on still redirect.
+		{"/1gXyZ42kQ", http.StatusFound, false},
+		{"/" + mistyped, http.StatusNotFound, true},
+		{"/" + keycheck.Append("9zzzzzzzz", 1), http.StatusNotFound, false},
+		{"/spring-sale", http.StatusNotFound, false},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+		if rec.Code != tt.want {
+			t.Errorf("%s: want %d, got %d", tt.path, tt.want, rec.Code)
+		}
+		if got := strings.Contains(rec.Body.String(), "mistyped"); got != tt.mistyped {
+			t.Errorf("%s: reported mistyped %v, want %v: %s", tt.path, got, tt.mistyped, rec.Body)
+		}
+	}
+}
+
+func TestRedirect_RewritesTargets(t *testing.T) {
+	store := urlstore.NewMemoryClient()
+	if err := store.CreateEntry(context.Background(), "docs", urlstore.URLEntry{URLTarget: "http://docs.old.example/v1/guide"}); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "rules.json")
+	rules := `{"rules": [{"host": "docs.old.example", "set_scheme": "https", "set_host": "docs.example.com"}]}`
+	if err := os.WriteFile(path, []byte(rules), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	h := NewHandlerWithStore(Config{}, store)
+	var err error
+	if h.rewrite, err = rewrite.OpenFile(path, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/docs", nil))
+	if rec.Code != http.StatusFound || rec.Header().Get("Location") != "https://docs.example.com/v1/guide" {
+		t.Fatalf("got %d to %q", rec.Code, rec.Header().Get("Location"))
+	}
+	if e, _ := store.GetEntry(context.Background(), "docs"); e.URLTarget != "http://docs.old.example/v1/guide" {
+		t.Fatalf("stored target changed to %q", e.URLTarget)
+	}
+}
+
+func FuzzExtractKeyFromPath(f *testing.F) {
+	for _, p := range []string{"/promo", "//promo//x", "/health", "health/", "/", "", "/a/b/c"} {
+		f.Add(p)
+	}
+	f.Fuzz(func(t *testing.T, p string) {
+		key := extractKeyFromPath(p)
+		if strings.Contains(key, "/") {
+			t.Fatalf("%q: key %q has a slash", p, key)
+		}
+		if key != "" && !strings.HasPrefix(strings.TrimLeft(p, "/"), key) {
+			t.Fatalf("%q: key %q is not its first segment", p, key)
+		}
+	})
+}
+
+func TestRedirect_Wildcards(t *testing.T) {
+	store := urlstore.NewMemoryClient()
+	for key, target := range map[string]string{
+		"docs/*":     "https://docs.example.com/{rest}",
+		"docs/api/*": "https://api.example.com/reference/{rest}?from=short",
+		"docs/faq":   "https://example.com/faq",
+		"promo":      "https://example.com/promo",
+	} {
+		if err := store.CreateEntry(context.Background(), urlstore.UrlKey(key), urlstore.URLEntry{URLTarget: target}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	h := NewHandlerWithStore(Config{}, store)
+
+	tests := []struct {
+		path     string
+		want     int
+		location string
+	}{
+		{"/docs/guide/intro", http.StatusFound, "https://docs.example.com/guide/intro"},
+		{"/docs/api/v2/users", http.StatusFound, "https://api.example.com/reference/v2/users?from=short"},
+		{"/docs/faq", http.StatusFound, "https://example.com/faq"},
+		{"/docs", http.StatusFound, "https://docs.example.com/"},
+		{"/docs/a%20b", http.StatusFound, "https://docs.example.com/a%20b"},
+		{"/docs/../admin", http.StatusNotFound, ""},
+		{"//docs//guide///intro/", http.StatusFound, "https://docs.example.com/guide/intro"},
+		{"/docs%2Fguide", http.StatusMovedPermanently, "/docs/guide"},
+		{"/docs/a%2Fb?x=1", http.StatusMovedPermanently, "/docs/a/b?x=1"},
+		{"/pr%6Fmo", http.StatusMovedPermanently, "/promo"},
+		{"/docs/a%5cb", http.StatusFound, "https://docs.example.com/a%5Cb"},
+		{"/promo/anything", http.StatusFound, "https://example.com/promo"},
+		{"/other/path", http.StatusNotFound, ""},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+		if rec.Code != tt.want || rec.Header().Get("Location") != tt.location {
+			t.Errorf("%s: got %d to %q, want %d to %q", tt.path, rec.Code, rec.Header().Get("Location"), tt.want, tt.location)
+		}
+	}
+}
+
+func TestRedirect_Aliases(t *testing.T) {
+	ctx := context.Background()
+	store := urlstore.NewMemoryClient()
+	for key, e := range map[urlstore.UrlKey]urlstore.URLEntry{
+		"launch":     {URLTarget: "https://example.com/launch", Preview: &urlstore.LinkPreview{Title: "Launch"}},
+		"launch-old": {AliasOf: "launch"},
+		"launch-v0":  {AliasOf: "launch-old"},
+		"docs/*":     {URLTarget: "https://docs.example.com/{rest}"},
+		"manual/*":   {AliasOf: "docs/*"},
+		"orphan":     {AliasOf: "gone"},
+		"loop":       {AliasOf: "loop"},
+		"private":    {URLTarget: "https://intranet.example.com", AllowedCIDRs: []string{"10.0.0.0/8"}},
+		"private-2":  {AliasOf: "private"},
+	} {
+		if err := store.CreateEntry(ctx, key, e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	usage := archive.NewMemoryUsageStore()
+	h := NewHandlerWithStore(Config{}, store)
+	h.usage = archive.NewTracker(usage)
+
+	tests := []struct {
+		path     string
+		want     int
+		location string
+	}{
+		{"/launch-old", http.StatusFound, "https://example.com/launch"},
+		{"/launch-v0", http.StatusFound, "https://example.com/launch"},
+		{"/manual/setup", http.StatusFound, "https://docs.example.com/setup"},
+		{"/orphan", http.StatusNotFound, ""},
+		{"/loop", http.StatusNotFound, ""},
+		{"/private-2", http.StatusForbidden, ""},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+		if rec.Code != tt.want || rec.Header().Get("Location") != tt.location {
+			t.Errorf("%s: got %d to %q, want %d to %q", tt.path, rec.Code, rec.Header().Get("Location"), tt.want, tt.location)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/preview/launch-old", nil))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"title":"Launch"`) {
+		t.Fatalf("preview of an alias: want the canonical link's, got %d %s", rec.Code, rec.Body)
+	}
+
+	// Uses of the aliases count for the canonical link as well.
+	if err := h.usage.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	got, _ := usage.Get(ctx, []urlstore.UrlKey{"launch", "launch-old", "launch-v0"})
+	if len(got) != 3 {
+		t.Fatalf("want the uses of launch and its aliases recorded, got %+v", got)
+	}
+}
+
+// lookupCounter counts the store calls resolving a path.
+type lookupCounter struct {
+	urlstore.Client
+	gets, batches int
+}
+
+func (c *lookupCounter) GetEntry(ctx context.Context, key urlstore.UrlKey) (urlstore.URLEntry, error) {
+	c.gets++
+	return c.Client.GetEntry(ctx, key)
+}
+
+func (c *lookupCounter) GetEntries(ctx context.Context, keys []urlstore.UrlKey) (map[urlstore.UrlKey]urlstore.URLEntry, error) {
+	c.batches++
+	return c.Client.GetEntries(ctx, keys)
+}
+
+func TestRedirect_PathLookupsBatched(t *testing.T) {
+	store := &lookupCounter{Client: urlstore.NewMemoryClient()}
+	if err := store.CreateEntry(context.Background(), "docs/*", urlstore.URLEntry{URLTarget: "https://docs.example.com/{rest}"}); err != nil {
+		t.Fatal(err)
+	}
+	h := NewHandlerWithStore(Config{}, store)
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/docs/api/v2/users", nil))
+	if rec.Code != http.StatusFound || rec.Header().Get("Location") != "https://docs.example.com/api/v2/users" {
+		t.Fatalf("got %d to %q", rec.Code, rec.Header().Get("Location"))
+	}
+	if store.gets != 0 || store.batches != 1 {
+		t.Fatalf("want one batched lookup, got %d gets and %d batches", store.gets, store.batches)
+	}
+}
+
+func TestRedirect_ReferrerPolicy(t *testing.T) {
+	store := urlstore.NewMemoryClient()
+	ctx := context.Background()
+	for key, e := range map[string]urlstore.URLEntry{
+		"promo": {URLTarget: "https://example.com/landing?a=1&b=2"},
+		"fund":  {URLTarget: "https://broker.example.com/fund", Interstitial: "default"},
+	} {
+		if err := store.CreateEntry(ctx, urlstore.UrlKey(key), e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cfg := Config{
+		Security:               httpmw.Security{ReferrerPolicy: "strict-origin-when-cross-origin"},
+		RedirectReferrerPolicy: "no-referrer",
+	}
+	get := func(h *Handler, path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec
+	}
+
+	h := NewHandlerWithStore(cfg, store)
+	for path, want := range map[string]string{
+		"/promo":  "no-referrer",
+		"/fund":   "same-origin",
+		"/health": "strict-origin-when-cross-origin",
+	} {
+		rec := get(h, path)
+		if got := rec.Header().Get("Referrer-Policy"); got != want {
+			t.Errorf("%s: want Referrer-Policy %q, got %q", path, want, got)
+		}
+		if rec.Header().Get("X-Content-Type-Options") != "nosniff" {
+			t.Errorf("%s: want nosniff", path)
+		}
+	}
+
+	cfg.RedirectReferrerPolicy = ""
+	cfg.RedirectPage = true
+	rec := get(NewHandlerWithStore(cfg, store), "/promo")
+	body := rec.Body.String()
+	if rec.Code != http.StatusOK || rec.Header().Get("Referrer-Policy") != "no-referrer" {
+		t.Fatalf("page: want 200 with no-referrer, got %d %q", rec.Code, rec.Header().Get("Referrer-Policy"))
+	}
+	for _, want := range []string{
+		`<meta name="referrer" content="no-referrer">`,
+		`<meta http-equiv="refresh" content="0; url=https://example.com/landing?a=1&amp;b=2">`,
+		`location.replace("https://example.com/landing?a=1\u0026b=2")`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("page: want %s in %s", want, body)
+		}
+	}
+
+	cfg.RedirectReferrerPolicy = "never"
+	if _, err := cfg.middleware(nil); err == nil {
+		t.Error("want an invalid redirect referrer policy refused")
+	}
+}
+
+func TestRedirect_ForwardQuery(t *testing.T) {
+	store := urlstore.NewMemoryClient()
+	ctx := context.Background()
+	for key, e := range map[string]urlstore.URLEntry{
+		"plain":  {URLTarget: "https://example.com/landing"},
+		"promo":  {URLTarget: "https://example.com/landing?a=1#top", ForwardQuery: true, ScrubParams: []string{"utm_*"}},
+		"strict": {URLTarget: "https://example.com/", ReferrerPolicy: "origin"},
+		"fund":   {URLTarget: "https://broker.example.com/fund", Interstitial: "default", ForwardQuery: true},
+	} {
+		if err := store.CreateEntry(ctx, urlstore.UrlKey(key), e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	h := NewHandlerWithStore(Config{ScrubQueryParams: []string{"session"}, RedirectReferrerPolicy: "no-referrer"}, store)
+	get := func(path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("Referer", "http://example.com/")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	for path, want := range map[string]string{
+		"/plain?q=go": "https://example.com/landing",
+		"/promo":      "https://example.com/landing?a=1#top",
+		"/promo?q=go&Session=s3cret&utm_source=mail&token=t&page=2": "https://example.com/landing?a=1&q=go&page=2#top",
+		"/promo?session=s3cret":                "https://example.com/landing?a=1#top",
+		"/fund?q=go&continue=1&session=s3cret": "https://broker.example.com/fund?q=go",
+	} {
+		if got := get(path).Header().Get("Location"); got != want {
+			t.Errorf("%s: want Location %q, got %q", path, want, got)
+		}
+	}
+
+	rec := get("/fund?q=go&session=s3cret")
+	if want := `href="/fund?q=go&amp;session=s3cret&amp;continue=1"`; !strings.Contains(rec.Body.String(), want) {
+		t.Errorf("interstitial: want the query kept through the click-through, %s in %s", want, rec.Body.String())
+	}
+
+	for path, want := range map[string]string{
+		"/strict": "origin",
+		"/plain":  "no-referrer",
+	} {
+		if got := get(path).Header().Get("Referrer-Policy"); got != want {
+			t.Errorf("%s: want Referrer-Policy %q, got %q", path, want, got)
+		}
+	}
+
+	if _, err := (Config{ScrubQueryParams: []string{"*"}}).scrubParams(); err == nil {
+		t.Error("want an invalid scrubbed parameter refused")
+	}
+}
+
+func TestAppendQuery(t *testing.T) {
+	tests := []struct {
+		target, query, want string
+	}{
+		{"https://example.com/", "", "https://example.com/"},
+		{"https://example.com/", "q=go", "https://example.com/?q=go"},
+		{"https://example.com/?", "q=go", "https://example.com/?q=go"},
+		{"https://example.com/?a=1", "q=go", "https://example.com/?a=1&q=go"},
+		{"https://example.com/?a=1&", "q=go", "https://example.com/?a=1&q=go"},
+		{"https://example.com/#x?y", "q=go", "https://example.com/?q=go#x?y"},
+	}
+	for _, tt := range tests {
+		if got := appendQuery(tt.target, tt.query); got != tt.want {
+			t.Errorf("appendQuery(%q, %q) = %q, want %q", tt.target, tt.query, got, tt.want)
+		}
+	}
+}
+
+// stalenessRecorder records the staleness its lookups are asked for.
+type stalenessRecorder struct {
+	urlstore.Client
+	staleness []time.Duration
+}
+
+func (c *stalenessRecorder) GetEntry(ctx context.Context, key urlstore.UrlKey) (urlstore.URLEntry, error) {
+	c.staleness = append(c.staleness, urlstore.ReadStaleness(ctx))
+	return c.Client.GetEntry(ctx, key)
+}
+
+func TestRedirect_ReadStaleness(t *testing.T) {
+	for _, d := range []time.Duration{0, 15 * time.Second} {
+		store := &stalenessRecorder{Client: urlstore.NewMemoryClient()}
+		if err := store.CreateEntry(context.Background(), "promo", urlstore.URLEntry{URLTarget: "https://example.com"}); err != nil {
+			t.Fatal(err)
+		}
+		h := NewHandlerWithStore(Config{ReadStaleness: d}, store)
+
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/promo", nil))
+		if rec.Code != http.StatusFound {
+			t.Fatalf("%v: want 302, got %d", d, rec.Code)
+		}
+		if len(store.staleness) == 0 || store.staleness[0] != d {
+			t.Errorf("want lookups %v stale, got %v", d, store.staleness)
+		}
+	}
+}
+
+// discardWriter is a ResponseWriter dropping the response, so benchmarks
+// count the allocations of the handler alone.
+type discardWriter struct {
+	header http.Header
+}
+
+func (w *discardWriter) Header() http.Header         { return w.header }
+func (w *discardWriter) Write(b []byte) (int, error) { return len(b), nil }
+func (w *discardWriter) WriteHeader(int)             {}
+
+// BenchmarkRedirect serves a link from the micro-cache, the hot path.
+func BenchmarkRedirect(b *testing.B) {
+	store := urlstore.NewMemoryClient()
+	if err := store.CreateEntry(context.Background(), "promo", urlstore.URLEntry{URLTarget: "https://example.com/landing"}); err != nil {
+		b.Fatal(err)
+	}
+	h := NewHandlerWithStore(Config{}, urlstore.WithMicroCache(store, time.Hour))
+	r := httptest.NewRequest(http.MethodGet, "/promo", nil)
+	w := &discardWriter{header: make(http.Header)}
+	b.ReportAllocs()
+	for b.Loop() {
+		clear(w.header)
+		h.ServeHTTP(w, r)
+	}
+}
+
+func TestRedirect_BurnAfterReading(t *testing.T) {
+	store := urlstore.NewMemoryClient()
+	err := store.CreateEntry(context.Background(), "secret", urlstore.URLEntry{
+		URLTarget:        "https://vault.example.com/s/abc",
+		BurnAfterReading: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := NewHandlerWithStore(Config{}, store)
+
+	get := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec
+	}
+	if rec := get("/preview/secret"); rec.Code != http.StatusNotFound {
+		t.Fatalf("preview: want 404, got %d", rec.Code)
+	}
+	rec := get("/secret")
+	if rec.Code != http.StatusFound || rec.Header().Get("Location") != "https://vault.example.com/s/abc" {
+		t.Fatalf("first read: got %d to %q", rec.Code, rec.Header().Get("Location"))
+	}
+	if rec.Header().Get("Cache-Control") != "no-store" {
+		t.Fatalf("first read: want Cache-Control no-store, got %q", rec.Header().Get("Cache-Control"))
+	}
+	if rec := get("/secret"); rec.Code != http.StatusNotFound {
+		t.Fatalf("second read: want 404, got %d", rec.Code)
+	}
+}
+
+func TestRedirect_ThrottlesKeys(t *testing.T) {
+	store := urlstore.NewMemoryClient()
+	ctx := context.Background()
+	for key, e := range map[string]urlstore.URLEntry{
+		"promo":   {URLTarget: "https://example.com/"},
+		"fragile": {URLTarget: "https://small.example.com/", MaxRedirectsPerSecond: 1},
+		"popular": {URLTarget: "https://example.com/", MaxRedirectsPerSecond: 10},
+	} {
+		if err := store.CreateEntry(ctx, urlstore.UrlKey(key), e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	h := NewHandlerWithStore(Config{KeyRedirectRate: 3}, store)
+	redirected := func(key string, n int) (got int) {
+		for range n {
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/"+key, nil))
+			switch rec.Code {
+			case http.StatusFound:
+				got++
+			case http.StatusTooManyRequests:
+				if rec.Header().Get("Retry-After") == "" {
+					t.Errorf("%s: want Retry-After on 429", key)
+				}
+			default:
+				t.Fatalf("%s: want 302 or 429, got %d", key, rec.Code)
+			}
+		}
+		return got
+	}
+	// Each link gets the lower of its cap and the reader's. The counts
+	// reset each second, which the test may straddle.
+	for key, want := range map[string]int{"fragile": 1, "promo": 3, "popular": 3} {
+		if got := redirected(key, 10); got != want && got != 2*want {
+			t.Errorf("%s: want %d redirects, got %d", key, want, got)
+		}
+	}
+}
+
+func TestKeyThrottle(t *testing.T) {
+	th := newKeyThrottle()
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	for i := range 2 {
+		if ok, _ := th.allow("", "promo", 2, now); !ok {
+			t.Fatalf("redirect %d: want allowed", i)
+		}
+	}
+	if ok, wait := th.allow("", "promo", 2, now.Add(300*time.Millisecond)); ok || wait != 700*time.Millisecond {
+		t.Errorf("over the cap: want refused for 700ms, got %v, %v", ok, wait)
+	}
+	if ok, _ := th.allow("acme", "promo", 2, now); !ok {
+		t.Error("want the same key of another tenant counted apart")
+	}
+	if ok, _ := th.allow("", "promo", 2, now.Add(time.Second)); !ok {
+		t.Error("want the cap reset the next second")
+	}
+}
+
+func TestReady_AfterWarm(t *testing.T) {
+	store := urlstore.NewMemoryClient()
+	if err := store.CreateEntry(context.Background(), "promo", urlstore.URLEntry{URLTarget: "https://example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	h := NewHandlerWithStore(Config{WarmupKeys: 10}, store)
+	ready := func() int {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/ready", nil))
+		return rec.Code
+	}
+	if got := ready(); got != http.StatusServiceUnavailable {
+		t.Fatalf("before warm-up: want 503, got %d", got)
+	}
+	if err := h.Warm(context.Background()); err != nil {
+		t.Fatalf("Warm: %v", err)
+	}
+	h.Readiness().Set(true)
+	if got := ready(); got != http.StatusOK {
+		t.Fatalf("ready: want 200, got %d", got)
+	}
+	h.Readiness().Set(false)
+	if got := ready(); got != http.StatusServiceUnavailable {
+		t.Fatalf("lame duck: want 503, got %d", got)
+	}
+	// Requests are still served while the load balancer catches up.
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/promo", nil))
+	if rec.Code != http.StatusFound {
+		t.Fatalf("redirect in lame duck: want 302, got %d", rec.Code)
+	}
+}
+
+func TestPreview_Tokens(t *testing.T) {
+	store := urlstore.NewMemoryClient()
+	for _, key := range []string{"team-a", "other"} {
+		if err := store.CreateEntry(context.Background(), urlstore.UrlKey(key), urlstore.URLEntry{URLTarget: "https://example.com/" + key}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	h := NewHandlerWithStore(Config{PreviewTokenKeys: "k1:secret", PreviewTokenRate: 2}, store)
+	keys, err := hmacsig.ParseKeyring("k1:secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, _, err := viewtoken.Mint(keys, viewtoken.Claims{Prefix: "team-"}, 0, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	get := func(path, bearer string) int {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if bearer != "" {
+			req.Header.Set("Authorization", "Bearer "+bearer)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	tests := []struct {
+		name, path, bearer string
+		want               int
+	}{
+		{"no token", "/preview/team-a", "", http.StatusUnauthorized},
+		{"invalid token", "/preview/team-a?token=forged", "", http.StatusUnauthorized},
+		{"query token", "/preview/team-a?token=" + token, "", http.StatusOK},
+		{"bearer token", "/preview/team-a", token, http.StatusOK},
+		{"outside the prefix", "/preview/other?token=" + token, "", http.StatusForbidden},
+		{"throttled", "/preview/team-a?token=" + token, "", http.StatusTooManyRequests},
+		{"redirects need no token", "/other", "", http.StatusFound},
+	}
+	for _, tt := range tests {
+		if got := get(tt.path, tt.bearer); got != tt.want {
+			t.Errorf("%s: want %d, got %d", tt.name, tt.want, got)
+		}
+	}
+}
+
+func TestPreview_Conditional(t *testing.T) {
+	ctx := context.Background()
+	store := urlstore.NewMemoryClient()
+	created := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	if err := store.CreateEntry(ctx, "promo", urlstore.URLEntry{URLTarget: "https://example.com/a", CreationTimestamp: created}); err != nil {
+		t.Fatal(err)
+	}
+	h := NewHandlerWithStore(Config{}, store)
+	get := func(header, value string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/preview/promo", nil)
+		if header != "" {
+			req.Header.Set(header, value)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := get("", "")
+	tag := rec.Header().Get("ETag")
+	if rec.Code != http.StatusOK || tag == "" {
+		t.Fatalf("want 200 with an ETag, got %d %q", rec.Code, tag)
+	}
+	if got := rec.Header().Get("Last-Modified"); got != created.Format(http.TimeFormat) {
+		t.Fatalf("want Last-Modified at creation, got %q", got)
+	}
+	if rec := get("If-None-Match", tag); rec.Code != http.StatusNotModified {
+		t.Fatalf("same ETag: want 304, got %d", rec.Code)
+	}
+	if rec := get("If-Modified-Since", created.Format(http.TimeFormat)); rec.Code != http.StatusNotModified {
+		t.Fatalf("not modified since: want 304, got %d", rec.Code)
+	}
+
+	updated := created.Add(time.Hour)
+	err := store.UpdateEntry(ctx, "promo", func(e *urlstore.URLEntry) error {
+		e.URLTarget, e.UpdatedAt = "https://example.com/b", updated
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec := get("If-None-Match", tag); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "example.com/b") {
+		t.Fatalf("changed target: want 200 with it, got %d %s", rec.Code, rec.Body)
+	}
+	if rec := get("If-Modified-Since", created.Format(http.TimeFormat)); rec.Code != http.StatusOK {
+		t.Fatalf("modified since: want 200, got %d", rec.Code)
+	}
+}
+
+func TestRedirect_BansKeyScans(t *testing.T) {
+	store := urlstore.NewMemoryClient()
+	if err := store.CreateEntry(context.Background(), "Zz9", urlstore.URLEntry{URLTarget: "https://example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	h := NewHandlerWithStore(Config{ScanDetect: scandetect.Config{Mode: scandetect.ModeBan, Threshold: 3}}, store)
+	get := func(path string) int {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec.Code
+	}
+	for _, key := range []string{"Zz0", "Zz1", "Zz2", "Zz3"} {
+		if got := get("/" + key); got != http.StatusNotFound {
+			t.Fatalf("%s: want 404, got %d", key, got)
+		}
+	}
+	if got := get("/Zz9"); got != http.StatusForbidden {
+		t.Fatalf("after a scan: want 403, got %d", got)
+	}
+	if got := get("/preview/Zz9"); got != http.StatusForbidden {
+		t.Fatalf("preview after a scan: want 403, got %d", got)
+	}
+}
@@ -0,0 +1,47 @@
+package reader
+
+import (
+	"html/template"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// redirectPage refreshes to a link's target, for RedirectPage: unlike a
+// 302, it is the referrer of the request to the target, and its meta
+// referrer policy strips it in browsers ignoring the header of redirects.
+var redirectPage = template.Must(template.New("redirect").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="referrer" content="{{.Policy}}">
+<meta name="robots" content="noindex">
+<meta http-equiv="refresh" content="0; url={{.Target}}">
+<title>Redirecting</title>
+</head>
+<body>
+<p><a href="{{.Target}}">Continue to {{.Target}}</a></p>
+<script>location.replace({{.Target}})</script>
+</body>
+</html>
+`))
+
+// redirectToTarget sends the client to target, that of a link, with the
+// referrer policy, nil to keep that of every response, and with a page
+// when RedirectPage is set.
+func (h *Handler) redirectToTarget(w http.ResponseWriter, r *http.Request, target string, policy []string) {
+	if policy != nil {
+		w.Header()["Referrer-Policy"] = policy
+	}
+	// Only web targets are refreshed to; the page would run others.
+	if !h.redirectPage || !(strings.HasPrefix(target, "https://") || strings.HasPrefix(target, "http://")) {
+		redirect(w, r, target, http.StatusFound) // 302
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	err := redirectPage.Execute(w, struct{ Policy, Target string }{policy[0], target})
+	if err != nil {
+		log.Printf("redirect page for %q: %v", target, err)
+	}
+}
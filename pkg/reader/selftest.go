@@ -0,0 +1,86 @@
+package reader
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/FlorinBalint/shortener/pkg/archive"
+	"github.com/FlorinBalint/shortener/pkg/gcputil"
+	"github.com/FlorinBalint/shortener/pkg/rewrite"
+	"github.com/FlorinBalint/shortener/pkg/selftest"
+)
+
+// SelfTest checks that a reader can run with cfg: that the settings are
+// valid and the secrets resolve, then that each dependency configured
+// answers, with read access to Datastore and memcache.
+func SelfTest(ctx context.Context, cfg Config) selftest.Report {
+	checks := []selftest.Check{
+		{Name: "config", Fatal: true, Run: func(ctx context.Context) error {
+			var err error
+			if cfg.PreviewTokenKeys, err = gcputil.ResolveSecret(ctx, cfg.PreviewTokenKeys); err != nil {
+				return fmt.Errorf("preview token keys: %w", err)
+			}
+			if _, err := cfg.previewKeyring(); err != nil {
+				return err
+			}
+			if _, err := cfg.interstitialTemplates(); err != nil {
+				return err
+			}
+			if _, err := cfg.scrubParams(); err != nil {
+				return err
+			}
+			if cfg.RewriteRulesFile != "" {
+				rules, err := rewrite.OpenFile(cfg.RewriteRulesFile, time.Hour)
+				if err != nil {
+					return err
+				}
+				rules.Close()
+			}
+			return nil
+		}},
+		{Name: "datastore", Run: func(ctx context.Context) error {
+			return checkDatastore(ctx, cfg.ProjectID, cfg.DSEndpoint, cfg.DSNamespace)
+		}},
+	}
+	if cfg.MemcacheServers != "" || cfg.MemcacheDiscoveryEndpoint != "" {
+		checks = append(checks, selftest.Check{Name: "memcache", Run: selftest.Memcache(cfg.MemcacheServers, cfg.MemcacheDiscoveryEndpoint, false)})
+	}
+	if cfg.ShadowProjectID != "" || cfg.ShadowNamespace != "" {
+		checks = append(checks, selftest.Check{Name: "shadow_datastore", Run: func(ctx context.Context) error {
+			return checkDatastore(ctx, cmp.Or(cfg.ShadowProjectID, cfg.ProjectID), cfg.DSEndpoint, cfg.ShadowNamespace)
+		}})
+	}
+	if cfg.TargetKMSKey != "" || cfg.TargetWrappedKey != "" {
+		checks = append(checks, selftest.Check{Name: "kms", Run: func(ctx context.Context) error {
+			_, err := cfg.storeSetup().TargetCipher(ctx)
+			return err
+		}})
+	}
+	if cfg.ArchiveBucket != "" {
+		checks = append(checks, selftest.Check{Name: "archive", Run: func(ctx context.Context) error {
+			s, err := archive.NewGCSStore(ctx, cfg.ArchiveBucket, cfg.DSNamespace)
+			if err != nil {
+				return err
+			}
+			_, err = s.Get(ctx, "selftest_probe")
+			if errors.Is(err, archive.ErrNotFound) {
+				return nil
+			}
+			return err
+		}})
+	}
+	return selftest.Run(ctx, "reader", 0, checks...)
+}
+
+// checkDatastore looks a probe entity up; readers need no write access.
+func checkDatastore(ctx context.Context, project, endpoint, namespace string) error {
+	client, err := gcputil.NewDSClient(ctx, project, endpoint, namespace)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	return selftest.DatastoreRead(client)(ctx)
+}
@@ -0,0 +1,22 @@
+package reader
+
+import (
+	"context"
+	"testing"
+)
+
+func TestSelfTest_InvalidConfig(t *testing.T) {
+	cfg := Config{RewriteRulesFile: t.TempDir() + "/missing.yaml"}
+	r := SelfTest(context.Background(), cfg)
+	if r.OK() {
+		t.Fatal("want the self-test failed")
+	}
+	if res := r.Results[0]; res.Name != "config" || res.Err == nil {
+		t.Fatalf("want the config check failed, got %+v", res)
+	}
+	for _, res := range r.Results[1:] {
+		if !res.Skipped {
+			t.Errorf("want %s skipped after an invalid config, got %+v", res.Name, res)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package reader
+
+import (
+	"sync"
+	"time"
+
+	"github.com/FlorinBalint/shortener/pkg/urlstore"
+)
+
+// keyThrottle caps the redirects of each link per second, in process: a
+// link served by several replicas gets its cap on each.
+type keyThrottle struct {
+	mu     sync.Mutex
+	window time.Time
+	counts map[throttled]int
+}
+
+// throttled is a link of a tenant.
+type throttled struct {
+	tenant, key string
+}
+
+func newKeyThrottle() *keyThrottle {
+	return &keyThrottle{counts: make(map[throttled]int)}
+}
+
+// allow accounts a redirect of the tenant's key at now under limit
+// redirects a second. When the link is over its limit it returns false
+// and the time until the limit resets.
+func (t *keyThrottle) allow(tenant, key string, limit int, now time.Time) (bool, time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if window := now.Truncate(time.Second); !window.Equal(t.window) {
+		t.window = window
+		clear(t.counts)
+	}
+	k := throttled{tenant: tenant, key: key}
+	if t.counts[k] >= limit {
+		return false, t.window.Add(time.Second).Sub(now)
+	}
+	t.counts[k]++
+	return true, 0
+}
+
+// redirectRate is the cap on the redirects of entry per second: the
+// lower of the link's and the reader's, 0 when neither caps it.
+func (h *Handler) redirectRate(entry urlstore.URLEntry) int {
+	limit := entry.MaxRedirectsPerSecond
+	if h.keyRedirectRate > 0 && (limit == 0 || h.keyRedirectRate < limit) {
+		limit = h.keyRedirectRate
+	}
+	return limit
+}
@@ -0,0 +1,154 @@
+package reader
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/FlorinBalint/shortener/pkg/urlstore"
+)
+
+// maxWildcardDepth bounds the path prefixes looked up for wildcard entries.
+const maxWildcardDepth = 8
+
+// restPlaceholder is replaced in wildcard targets by the rest of the path.
+const restPlaceholder = "{rest}"
+
+// requestPath returns the path of u for key lookups: decoded, encoded
+// slashes included, with empty segments dropped, so "//a//b/" and
+// "/a%2Fb" are both "a/b". When u escapes slashes or characters that need
+// no escaping, e.g. "/a%2Fb" or "/%61", or, with slashes, has empty
+// segments, e.g. "/a/", it also returns the canonical spelling of the
+// path, which such requests are redirected to rather than served, so the
+// segments of a wildcard's rest are those of the URL the client ends on.
+func requestPath(u *url.URL, slashes bool) (path, redirect string) {
+	path = collapse(u.Path)
+	sent := u.EscapedPath()
+	c := sent
+	if strings.Contains(sent, "%") {
+		c = unescapeNeedless(sent)
+	}
+	if c != sent || slashes && len(c) > 1 && (strings.HasSuffix(c, "/") || strings.Contains(c, "//")) {
+		redirect = "/" + collapse(c)
+	}
+	return path, redirect
+}
+
+// collapse drops the empty segments of path and its outer slashes, e.g.
+// "//a//b/" is "a/b". Paths without repeated slashes, the usual ones, are
+// sliced rather than copied.
+func collapse(path string) string {
+	path = strings.Trim(path, "/")
+	if !strings.Contains(path, "//") {
+		return path
+	}
+	return strings.Join(nonEmpty(strings.Split(path, "/")), "/")
+}
+
+// unescapeNeedless decodes the escapes of slashes and of the characters
+// URLs never need to escape (RFC 3986 unreserved) in an escaped path.
+func unescapeNeedless(escaped string) string {
+	var b strings.Builder
+	for i := 0; i < len(escaped); i++ {
+		if escaped[i] == '%' && i+2 < len(escaped) {
+			if c, err := strconv.ParseUint(escaped[i+1:i+3], 16, 8); err == nil && (c == '/' || unreserved(byte(c))) {
+				b.WriteByte(byte(c))
+				i += 2
+				continue
+			}
+		}
+		b.WriteByte(escaped[i])
+	}
+	return b.String()
+}
+
+// unreserved reports whether c may always appear unescaped in a URL.
+func unreserved(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c == '-' || c == '.' || c == '_' || c == '~'
+}
+
+// nonEmpty returns segs without the empty ones.
+func nonEmpty(segs []string) []string {
+	return slices.DeleteFunc(segs, func(s string) bool { return s == "" })
+}
+
+// resolvePath finds the entry serving path, a request path without its
+// slashes: the exact key, else the wildcard entry ("docs/*") with the
+// longest prefix of the path, else the first segment of the path. It
+// returns the entry's key, the first segment when none serves the path,
+// and the path segments left for the wildcard. A single-segment path,
+// the common case, is looked up alone first; the candidates of the others
+// are looked up in one batch.
+func (h *Handler) resolvePath(ctx context.Context, path string) (string, urlstore.URLEntry, []string, error) {
+	if !strings.Contains(path, "/") {
+		if len(path) > urlstore.MaxKeyLength {
+			return path, urlstore.URLEntry{}, nil, urlstore.ErrNotFound
+		}
+		entry, err := h.store.GetEntry(ctx, urlstore.UrlKey(path))
+		if !errors.Is(err, urlstore.ErrNotFound) {
+			return path, entry, nil, err
+		}
+	}
+	candidates := pathCandidates(path)
+	keys := make([]urlstore.UrlKey, len(candidates))
+	for i, c := range candidates {
+		keys[i] = urlstore.UrlKey(c.key)
+	}
+	entries, err := h.store.GetEntries(ctx, keys)
+	if err != nil {
+		return path, urlstore.URLEntry{}, nil, err
+	}
+	for _, c := range candidates {
+		if entry, ok := entries[urlstore.UrlKey(c.key)]; ok {
+			return c.key, entry, c.rest, nil
+		}
+	}
+	first, _, _ := strings.Cut(path, "/")
+	return first, urlstore.URLEntry{}, nil, urlstore.ErrNotFound
+}
+
+// candidate is a key that may serve a path, with the path segments left
+// for it when it is a wildcard.
+type candidate struct {
+	key  string
+	rest []string
+}
+
+// pathCandidates lists the keys that may serve path, in the order they
+// take precedence, leaving out the exact key of a single-segment path and
+// keys longer than any stored.
+func pathCandidates(path string) []candidate {
+	segs := strings.Split(path, "/")
+	var cs []candidate
+	add := func(c candidate) {
+		if len(c.key) <= urlstore.MaxKeyLength {
+			cs = append(cs, c)
+		}
+	}
+	if len(segs) > 1 {
+		add(candidate{key: path})
+	}
+	// Dot segments would climb out of the wildcard's target.
+	if !slices.ContainsFunc(segs, func(s string) bool { return s == "." || s == ".." }) {
+		for i := min(len(segs), maxWildcardDepth); i > 0; i-- {
+			add(candidate{key: strings.Join(segs[:i], "/") + "/*", rest: segs[i:]})
+		}
+	}
+	if len(segs) > 1 {
+		add(candidate{key: segs[0]})
+	}
+	return cs
+}
+
+// expandTarget fills the rest of the path into a wildcard target,
+// escaping each segment.
+func expandTarget(target string, rest []string) string {
+	escaped := make([]string, len(rest))
+	for i, s := range rest {
+		escaped[i] = url.PathEscape(s)
+	}
+	return strings.ReplaceAll(target, restPlaceholder, strings.Join(escaped, "/"))
+}
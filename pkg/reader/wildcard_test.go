@@ -0,0 +1,142 @@
+package reader
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/FlorinBalint/shortener/pkg/urlstore"
+)
+
+func TestRequestPath(t *testing.T) {
+	tests := []struct {
+		raw      string
+		slashes  bool
+		want     string
+		redirect string
+	}{
+		{"/promo", false, "promo", ""},
+		{"//docs//guide/", false, "docs/guide", ""},
+		{"/docs/a%20b", false, "docs/a b", ""},
+		{"/docs/a%5cb", false, "docs/a\\b", ""},
+		{"/promo).", false, "promo).", ""},
+		{"/", false, "", ""},
+		{"/docs%2Fguide", false, "docs/guide", "/docs/guide"},
+		{"/docs/a%2fb", false, "docs/a/b", "/docs/a/b"},
+		{"/pr%6Fmo", false, "promo", "/promo"},
+		{"/a%2F%2Fb%2F/%20", false, "a/b/ ", "/a/b/%20"},
+		{"/a%252Fb", false, "a%2Fb", ""},
+		{"/promo/", true, "promo", "/promo"},
+		{"//docs//guide", true, "docs/guide", "/docs/guide"},
+		{"/docs/a%20b/", true, "docs/a b", "/docs/a%20b"},
+		{"/docs%2Fguide/", true, "docs/guide", "/docs/guide"},
+		{"/promo", true, "promo", ""},
+		{"/", true, "", ""},
+	}
+	for _, tt := range tests {
+		u, err := url.ParseRequestURI(tt.raw)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, redirect := requestPath(u, tt.slashes); got != tt.want || redirect != tt.redirect {
+			t.Errorf("%s slashes=%v: want %q, %q, got %q, %q", tt.raw, tt.slashes, tt.want, tt.redirect, got, redirect)
+		}
+	}
+}
+
+func TestRequestPath_Allocs(t *testing.T) {
+	for _, raw := range []string{"/promo", "/docs/guide/intro"} {
+		u, err := url.ParseRequestURI(raw)
+		if err != nil {
+			t.Fatal(err)
+		}
+		n := testing.AllocsPerRun(100, func() {
+			path, _ := requestPath(u, true)
+			extractKeyFromPath(path)
+		})
+		if n != 0 {
+			t.Errorf("%s: want no allocations, got %v", raw, n)
+		}
+	}
+}
+
+// FuzzRequestPath checks that request paths have no empty segments, and
+// that the canonical spelling of a path means the same path and is not
+// redirected again, with or without slash redirects.
+func FuzzRequestPath(f *testing.F) {
+	for _, s := range []string{"/promo", "//a//b/", "/a%2Fb", "/a%20b/%2e%2e", "/%", "/docs/../x", "/a%252F", "/promo/"} {
+		f.Add(s, false)
+		f.Add(s, true)
+	}
+	f.Fuzz(func(t *testing.T, raw string, slashes bool) {
+		u, err := url.ParseRequestURI(raw)
+		if err != nil {
+			return
+		}
+		path, redirect := requestPath(u, slashes)
+		if strings.HasPrefix(path, "/") || strings.HasSuffix(path, "/") || strings.Contains(path, "//") {
+			t.Fatalf("%q: path %q has empty segments", raw, path)
+		}
+		if redirect != "" {
+			target, err := url.ParseRequestURI(redirect)
+			if err != nil {
+				t.Fatalf("%q: redirect %q does not parse: %v", raw, redirect, err)
+			}
+			if again, next := requestPath(target, slashes); again != path || next != "" {
+				t.Fatalf("%q: redirect %q is %q, %q", raw, redirect, again, next)
+			}
+		}
+		for _, c := range pathCandidates(path) {
+			if len(c.key) > urlstore.MaxKeyLength {
+				t.Fatalf("%q: candidate %q is too long", raw, c.key)
+			}
+		}
+	})
+}
+
+// boundedStore fails, as Datastore does, for keys too long to be stored.
+type boundedStore struct {
+	urlstore.Client
+}
+
+var errKeyTooLong = errors.New("key too long")
+
+func (s boundedStore) GetEntry(ctx context.Context, key urlstore.UrlKey) (urlstore.URLEntry, error) {
+	if len(key) > urlstore.MaxKeyLength {
+		return urlstore.URLEntry{}, errKeyTooLong
+	}
+	return s.Client.GetEntry(ctx, key)
+}
+
+func (s boundedStore) GetEntries(ctx context.Context, keys []urlstore.UrlKey) (map[urlstore.UrlKey]urlstore.URLEntry, error) {
+	for _, k := range keys {
+		if len(k) > urlstore.MaxKeyLength {
+			return nil, errKeyTooLong
+		}
+	}
+	return s.Client.GetEntries(ctx, keys)
+}
+
+func TestRedirect_LongPaths(t *testing.T) {
+	store := urlstore.NewMemoryClient()
+	store.CreateEntry(context.Background(), "docs/*", urlstore.URLEntry{URLTarget: "https://docs.example.com/{rest}"})
+	h := NewHandlerWithStore(Config{TrimKeyPunctuation: true}, boundedStore{store})
+
+	long := strings.Repeat("a", 2000)
+	for _, path := range []string{"/" + long, "/" + long + ".", "/" + long + "/x", "/preview/" + long} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != http.StatusNotFound {
+			t.Errorf("%.20s...: want 404, got %d", path, rec.Code)
+		}
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/docs/"+long, nil))
+	if rec.Code != http.StatusFound || rec.Header().Get("Location") != "https://docs.example.com/"+long {
+		t.Errorf("wildcard with a long rest: want 302, got %d %s", rec.Code, rec.Header().Get("Location"))
+	}
+}
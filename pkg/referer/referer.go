@@ -0,0 +1,101 @@
+// Package referer matches the Referer of requests against the sites a link
+// may be followed from, to keep links meant for a site or a newsletter
+// from being hotlinked elsewhere.
+//
+// A pattern is a host, "example.com", matching that host and its "www."
+// form; "*.example.com", matching its subdomains; or None, matching
+// requests without a Referer, as sent by mail clients, apps and browsers
+// with strict referrer policies.
+package referer
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// None is the pattern matching requests without a Referer.
+const None = "none"
+
+// MaxPatterns caps the patterns of a link, checked on every redirect.
+const MaxPatterns = 32
+
+// Normalize validates patterns and returns them in canonical form:
+// lowercase, without "www.".
+func Normalize(patterns []string) ([]string, error) {
+	if len(patterns) > MaxPatterns {
+		return nil, fmt.Errorf("allowed_referers has more than %d entries", MaxPatterns)
+	}
+	var out []string
+	for _, p := range patterns {
+		p = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(p)), ".")
+		if p == None {
+			out = append(out, p)
+			continue
+		}
+		host, wildcard := strings.CutPrefix(p, "*.")
+		if !validHost(host) {
+			return nil, fmt.Errorf("allowed_referers: invalid pattern %q", p)
+		}
+		if !wildcard {
+			host = strings.TrimPrefix(host, "www.")
+		}
+		if wildcard {
+			host = "*." + host
+		}
+		out = append(out, host)
+	}
+	return out, nil
+}
+
+// validHost reports whether host is a domain name of at least two labels.
+func validHost(host string) bool {
+	labels := strings.Split(host, ".")
+	if len(labels) < 2 {
+		return false
+	}
+	for _, l := range labels {
+		if l == "" || len(l) > 63 || l[0] == '-' || l[len(l)-1] == '-' {
+			return false
+		}
+		for _, c := range l {
+			if !('a' <= c && c <= 'z' || '0' <= c && c <= '9' || c == '-') {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Allows reports whether a request with the Referer header ref may follow
+// a link restricted to patterns, as normalized by Normalize. No patterns
+// allow every request.
+func Allows(patterns []string, ref string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	host := ""
+	if ref != "" {
+		u, err := url.Parse(ref)
+		if err != nil || u.Hostname() == "" {
+			return false
+		}
+		host = strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+	}
+	for _, p := range patterns {
+		switch {
+		case p == None:
+			if host == "" {
+				return true
+			}
+		case host == "":
+		case strings.HasPrefix(p, "*."):
+			if strings.HasSuffix(host, p[1:]) {
+				return true
+			}
+		case host == p || host == "www."+p:
+			return true
+		}
+	}
+	return false
+}
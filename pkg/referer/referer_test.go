@@ -0,0 +1,52 @@
+package referer
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestNormalize(t *testing.T) {
+	got, err := Normalize([]string{" WWW.Example.com ", "*.News.example.org", "None"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"example.com", "*.news.example.org", "none"}; !slices.Equal(got, want) {
+		t.Fatalf("Normalize = %q, want %q", got, want)
+	}
+	for _, bad := range []string{"localhost", "https://example.com", "example.com/path", "*.com.", "ex ample.com", "*"} {
+		if _, err := Normalize([]string{bad}); err == nil {
+			t.Errorf("Normalize(%q): want an error", bad)
+		}
+	}
+	if _, err := Normalize(make([]string, MaxPatterns+1)); err == nil {
+		t.Error("want too many patterns refused")
+	}
+}
+
+func TestAllows(t *testing.T) {
+	patterns := []string{"example.com", "*.mail.example.org"}
+	tests := []struct {
+		ref  string
+		want bool
+	}{
+		{"https://example.com/newsletter", true},
+		{"https://WWW.example.com/", true},
+		{"https://shop.example.com/", false},
+		{"https://inbox.mail.example.org/", true},
+		{"https://mail.example.org/", false},
+		{"https://evil.example.net/?example.com", false},
+		{"", false},
+		{"not a url", false},
+	}
+	for _, tt := range tests {
+		if got := Allows(patterns, tt.ref); got != tt.want {
+			t.Errorf("Allows(%q) = %v, want %v", tt.ref, got, tt.want)
+		}
+	}
+	if !Allows(append(patterns, None), "") {
+		t.Error("none should allow requests without a Referer")
+	}
+	if !Allows(nil, "https://anywhere.example/") {
+		t.Error("no patterns should allow every request")
+	}
+}
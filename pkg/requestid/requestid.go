@@ -0,0 +1,82 @@
+// Package requestid tags each request with an ID, so log lines written
+// while serving it, down to the store, can be told apart and matched.
+//
+// The ID is taken from X-Request-Id when the client or a proxy sent a
+// sensible one, else from the trace of X-Cloud-Trace-Context set by the
+// Google load balancer, else generated. It is echoed in the X-Request-Id
+// response header.
+package requestid
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"strings"
+)
+
+// Header carries request IDs.
+const Header = "X-Request-Id"
+
+// maxLength bounds the IDs accepted from requests.
+const maxLength = 128
+
+type ctxKey struct{}
+
+// NewContext returns a copy of ctx carrying the request ID.
+func NewContext(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, ctxKey{}, id)
+}
+
+// FromContext returns the request ID stored by NewContext, or "".
+func FromContext(ctx context.Context) string {
+	id, _ := ctx.Value(ctxKey{}).(string)
+	return id
+}
+
+// Middleware stores the ID of each request in its context and echoes it
+// in the response.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := FromRequest(r)
+		w.Header().Set(Header, id)
+		next.ServeHTTP(w, r.WithContext(NewContext(r.Context(), id)))
+	})
+}
+
+// FromRequest returns the ID of r: the one it carries, or a new one.
+func FromRequest(r *http.Request) string {
+	if id := r.Header.Get(Header); valid(id) {
+		return id
+	}
+	// TRACE_ID/SPAN_ID;o=OPTIONS
+	trace, _, _ := strings.Cut(r.Header.Get("X-Cloud-Trace-Context"), "/")
+	if valid(trace) {
+		return trace
+	}
+	return New()
+}
+
+// New returns a random ID.
+func New() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// valid reports whether id can be logged as is: short, and made of
+// letters, digits and a few separators.
+func valid(id string) bool {
+	if id == "" || len(id) > maxLength {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		case c == '-' || c == '_' || c == '.' || c == ':':
+		default:
+			return false
+		}
+	}
+	return true
+}
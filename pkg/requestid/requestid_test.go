@@ -0,0 +1,44 @@
+package requestid
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMiddleware(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers map[string]string
+		want    string
+	}{
+		{"request id", map[string]string{Header: "abc-123"}, "abc-123"},
+		{"cloud trace", map[string]string{"X-Cloud-Trace-Context": "105445aa7843bc8bf206b12000100000/1;o=1"}, "105445aa7843bc8bf206b12000100000"},
+		{"request id first", map[string]string{Header: "abc-123", "X-Cloud-Trace-Context": "105445aa/1"}, "abc-123"},
+		{"unsafe id replaced", map[string]string{Header: "abc\n123"}, ""},
+		{"none", nil, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var seen string
+			h := Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				seen = FromContext(r.Context())
+			}))
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			if tt.want != "" && seen != tt.want {
+				t.Errorf("want ID %q, got %q", tt.want, seen)
+			}
+			if len(seen) == 0 || !valid(seen) {
+				t.Errorf("want a valid ID, got %q", seen)
+			}
+			if got := rec.Header().Get(Header); got != seen {
+				t.Errorf("response header %q, want %q", got, seen)
+			}
+		})
+	}
+}
@@ -0,0 +1,226 @@
+// Package rewrite rewrites redirect targets on the way out, by rules read
+// from a JSON file: e.g. forcing https, moving links to a new domain during
+// a migration or appending a path. Stored entries are left untouched, so
+// dropping a rule undoes it.
+//
+// A rules file looks like:
+//
+//	{"rules": [
+//	  {"name": "https", "scheme": "http", "set_scheme": "https"},
+//	  {"host": "docs.old.example", "path_prefix": "/v1/",
+//	   "set_host": "docs.example.com", "replace_path_prefix": "/archive/v1/"}
+//	]}
+//
+// Every rule matching a target applies, in order, each seeing the target as
+// rewritten by the rules before it.
+package rewrite
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Rule matches targets by scheme, host and path prefix, all optional, and
+// rewrites the parts it sets.
+type Rule struct {
+	Name string `json:"name,omitempty"`
+
+	// Scheme matches the target's scheme. Host matches its host name,
+	// ignoring case and port; "*.example.com" matches subdomains.
+	// PathPrefix matches the start of its path.
+	Scheme     string `json:"scheme,omitempty"`
+	Host       string `json:"host,omitempty"`
+	PathPrefix string `json:"path_prefix,omitempty"`
+
+	// SetScheme and SetHost (with an optional port) replace those parts.
+	// ReplacePathPrefix replaces the matched PathPrefix, and AppendPath is
+	// added to the end of the path.
+	SetScheme         string  `json:"set_scheme,omitempty"`
+	SetHost           string  `json:"set_host,omitempty"`
+	ReplacePathPrefix *string `json:"replace_path_prefix,omitempty"`
+	AppendPath        string  `json:"append_path,omitempty"`
+}
+
+func (r *Rule) validate() error {
+	if r.SetScheme == "" && r.SetHost == "" && r.ReplacePathPrefix == nil && r.AppendPath == "" {
+		return errors.New("sets nothing")
+	}
+	if r.SetScheme != "" && r.SetScheme != "http" && r.SetScheme != "https" {
+		return fmt.Errorf("set_scheme must be http or https, got %q", r.SetScheme)
+	}
+	if r.PathPrefix != "" && !strings.HasPrefix(r.PathPrefix, "/") {
+		return fmt.Errorf("path_prefix must start with /, got %q", r.PathPrefix)
+	}
+	if r.ReplacePathPrefix != nil && r.PathPrefix == "" {
+		return errors.New("replace_path_prefix needs a path_prefix")
+	}
+	r.Scheme = strings.ToLower(r.Scheme)
+	r.Host = strings.ToLower(r.Host)
+	return nil
+}
+
+func (r *Rule) matches(u *url.URL) bool {
+	if r.Scheme != "" && strings.ToLower(u.Scheme) != r.Scheme {
+		return false
+	}
+	if r.Host != "" {
+		host := strings.ToLower(u.Hostname())
+		if parent, ok := strings.CutPrefix(r.Host, "*."); ok {
+			if !strings.HasSuffix(host, "."+parent) {
+				return false
+			}
+		} else if host != r.Host {
+			return false
+		}
+	}
+	return strings.HasPrefix(u.Path, r.PathPrefix)
+}
+
+func (r *Rule) apply(u *url.URL) {
+	if r.SetScheme != "" {
+		u.Scheme = r.SetScheme
+	}
+	if r.SetHost != "" {
+		u.Host = r.SetHost
+	}
+	if r.ReplacePathPrefix != nil {
+		u.Path = *r.ReplacePathPrefix + strings.TrimPrefix(u.Path, r.PathPrefix)
+		u.RawPath = ""
+	}
+	if r.AppendPath != "" {
+		u.Path = strings.TrimSuffix(u.Path, "/") + "/" + strings.TrimPrefix(r.AppendPath, "/")
+		u.RawPath = ""
+	}
+}
+
+// Rules is an ordered set of rules. The nil *Rules rewrites nothing.
+type Rules struct {
+	Rules []Rule `json:"rules"`
+}
+
+// Parse parses and validates a rules file.
+func Parse(b []byte) (*Rules, error) {
+	var rs Rules
+	if err := json.Unmarshal(b, &rs); err != nil {
+		return nil, fmt.Errorf("rewrite rules: %w", err)
+	}
+	for i := range rs.Rules {
+		if err := rs.Rules[i].validate(); err != nil {
+			return nil, fmt.Errorf("rewrite rule %d %q: %w", i, rs.Rules[i].Name, err)
+		}
+	}
+	return &rs, nil
+}
+
+// Apply returns target rewritten by the rules matching it. Targets that do
+// not parse as absolute URLs are returned as they are.
+func (rs *Rules) Apply(target string) string {
+	if rs == nil || len(rs.Rules) == 0 {
+		return target
+	}
+	u, err := url.Parse(target)
+	if err != nil || !u.IsAbs() {
+		return target
+	}
+	changed := false
+	for i := range rs.Rules {
+		if rs.Rules[i].matches(u) {
+			rs.Rules[i].apply(u)
+			changed = true
+		}
+	}
+	if !changed {
+		return target
+	}
+	return u.String()
+}
+
+// File holds the rules of a file, reloaded when it changes so a migration's
+// rules can be edited (e.g. in a mounted ConfigMap) without a restart.
+type File struct {
+	path  string
+	rules atomic.Pointer[Rules]
+	mod   time.Time
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// OpenFile loads the rules at path and, if interval is positive, checks
+// the file for changes that often. A changed file that fails to load is
+// logged and the previous rules kept.
+func OpenFile(path string, interval time.Duration) (*File, error) {
+	f := &File{path: path}
+	if err := f.load(); err != nil {
+		return nil, err
+	}
+	if interval > 0 {
+		f.stop = make(chan struct{})
+		f.done = make(chan struct{})
+		go f.reloadEvery(interval)
+	}
+	return f, nil
+}
+
+// Rules returns the current rules.
+func (f *File) Rules() *Rules {
+	return f.rules.Load()
+}
+
+func (f *File) load() error {
+	st, err := os.Stat(f.path)
+	if err != nil {
+		return fmt.Errorf("rewrite rules: %w", err)
+	}
+	b, err := os.ReadFile(f.path)
+	if err != nil {
+		return fmt.Errorf("rewrite rules: %w", err)
+	}
+	rs, err := Parse(b)
+	if err != nil {
+		return err
+	}
+	f.rules.Store(rs)
+	f.mod = st.ModTime()
+	return nil
+}
+
+func (f *File) reloadEvery(interval time.Duration) {
+	defer close(f.done)
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-f.stop:
+			return
+		case <-t.C:
+			st, err := os.Stat(f.path)
+			if err != nil || st.ModTime().Equal(f.mod) {
+				continue
+			}
+			if err := f.load(); err != nil {
+				log.Printf("rewrite rules reload failed, keeping the current rules: %v", err)
+				// Retry only once the file changes again.
+				f.mod = st.ModTime()
+				continue
+			}
+			log.Printf("rewrite rules reloaded: %d rules", len(f.Rules().Rules))
+		}
+	}
+}
+
+// Close stops reloading.
+func (f *File) Close() {
+	if f.stop != nil {
+		close(f.stop)
+		<-f.done
+		f.stop = nil
+	}
+}
@@ -0,0 +1,94 @@
+package rewrite
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const testRules = `{"rules": [
+  {"name": "https", "scheme": "http", "set_scheme": "https"},
+  {"host": "docs.old.example", "path_prefix": "/v1/", "set_host": "docs.example.com", "replace_path_prefix": "/archive/v1/"},
+  {"host": "*.shop.example", "append_path": "/ref/short"}
+]}`
+
+func TestApply(t *testing.T) {
+	rs, err := Parse([]byte(testRules))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct{ in, want string }{
+		{"http://example.com/a?b=c", "https://example.com/a?b=c"},
+		{"https://example.com/a", "https://example.com/a"},
+		{"http://DOCS.old.example:8080/v1/guide#top", "https://docs.example.com/archive/v1/guide#top"},
+		{"https://docs.old.example/v2/guide", "https://docs.old.example/v2/guide"},
+		{"https://eu.shop.example/cart/", "https://eu.shop.example/cart/ref/short"},
+		{"https://shop.example/cart", "https://shop.example/cart"},
+		{"not a url", "not a url"},
+		{"/relative", "/relative"},
+	}
+	for _, tt := range tests {
+		if got := rs.Apply(tt.in); got != tt.want {
+			t.Errorf("Apply(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+	if got := (*Rules)(nil).Apply("http://example.com"); got != "http://example.com" {
+		t.Errorf("nil rules rewrote to %q", got)
+	}
+}
+
+func TestParse_Invalid(t *testing.T) {
+	for _, in := range []string{
+		`{"rules": [{"host": "example.com"}]}`,
+		`{"rules": [{"set_scheme": "ftp"}]}`,
+		`{"rules": [{"path_prefix": "v1", "append_path": "x"}]}`,
+		`{"rules": [{"replace_path_prefix": "/x"}]}`,
+		`{"rules": `,
+	} {
+		if _, err := Parse([]byte(in)); err == nil {
+			t.Errorf("Parse(%s): want an error", in)
+		}
+	}
+}
+
+func TestFile_Reloads(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules.json")
+	if err := os.WriteFile(path, []byte(`{"rules": [{"set_scheme": "https"}]}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := OpenFile(path, 10*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if got := f.Rules().Apply("http://example.com"); got != "https://example.com" {
+		t.Fatalf("initial rules: got %q", got)
+	}
+
+	write := func(content string, mod time.Time) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, mod, mod); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitFor := func(want string) {
+		t.Helper()
+		for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+			if f.Rules().Apply("http://example.com") == want {
+				return
+			}
+		}
+		t.Fatalf("rules not reloaded to produce %q", want)
+	}
+
+	write(`{"rules": [{"set_host": "example.org"}]}`, time.Now().Add(time.Minute))
+	waitFor("http://example.org")
+	// A broken file keeps the current rules.
+	write(`{"rules": [{}]}`, time.Now().Add(2*time.Minute))
+	time.Sleep(50 * time.Millisecond)
+	waitFor("http://example.org")
+}
@@ -0,0 +1,239 @@
+// Package scandetect spots clients enumerating short keys. Generated keys
+// are base62-encoded kubeflake IDs, which grow with time, so a scraper
+// walking the keyspace looks up many IDs close to each other, while people
+// following links hit IDs scattered over months. A client making more than
+// Threshold lookups near one of its recent lookups within Window is
+// reported, and banned for a while when banning is on.
+package scandetect
+
+import (
+	"log"
+	"net/netip"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/FlorinBalint/shortener/pkg/kubeflake"
+)
+
+// Modes of a Detector.
+const (
+	// ModeOff disables detection.
+	ModeOff = "off"
+	// ModeLog reports scanning clients.
+	ModeLog = "log"
+	// ModeBan reports scanning clients and rejects their lookups for
+	// BanDuration.
+	ModeBan = "ban"
+)
+
+// Config tunes detection; zero values take the defaults.
+//
+// MaxGap is how far apart two IDs may be to count as near (default 2^32,
+// the IDs minted in one kubeflake time unit with the default layout).
+// Threshold near lookups within Window (default 20 in 1m) flag a client.
+// Recent bounds the lookups remembered per client (default 64).
+type Config struct {
+	Mode        string
+	Window      time.Duration
+	Threshold   int
+	MaxGap      uint64
+	Recent      int
+	BanDuration time.Duration
+}
+
+// ConfigFromEnv reads SCAN_DETECT (off, log or ban), SCAN_WINDOW,
+// SCAN_THRESHOLD, SCAN_MAX_GAP and SCAN_BAN_DURATION. Malformed values are
+// logged and ignored.
+func ConfigFromEnv() Config {
+	cfg := Config{
+		Mode:        os.Getenv("SCAN_DETECT"),
+		Window:      envDuration("SCAN_WINDOW"),
+		Threshold:   int(envUint("SCAN_THRESHOLD")),
+		MaxGap:      envUint("SCAN_MAX_GAP"),
+		BanDuration: envDuration("SCAN_BAN_DURATION"),
+	}
+	switch cfg.Mode {
+	case "", ModeOff, ModeLog, ModeBan:
+	default:
+		log.Printf("ignoring invalid SCAN_DETECT=%q", cfg.Mode)
+		cfg.Mode = ""
+	}
+	return cfg
+}
+
+func envUint(k string) uint64 {
+	v := os.Getenv(k)
+	if v == "" {
+		return 0
+	}
+	n, err := strconv.ParseUint(v, 10, 64)
+	if err != nil {
+		log.Printf("ignoring invalid %s=%q", k, v)
+		return 0
+	}
+	return n
+}
+
+func envDuration(k string) time.Duration {
+	v := os.Getenv(k)
+	if v == "" {
+		return 0
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		log.Printf("ignoring invalid %s=%q", k, v)
+		return 0
+	}
+	return d
+}
+
+func (c Config) withDefaults() Config {
+	if c.Window <= 0 {
+		c.Window = time.Minute
+	}
+	if c.Threshold <= 0 {
+		c.Threshold = 20
+	}
+	if c.MaxGap == 0 {
+		c.MaxGap = 1 << 32
+	}
+	if c.Recent <= 0 {
+		c.Recent = 64
+	}
+	if c.BanDuration <= 0 {
+		c.BanDuration = 15 * time.Minute
+	}
+	return c
+}
+
+// maxClients bounds the clients tracked; idle ones are dropped first.
+const maxClients = 100000
+
+type lookup struct {
+	id uint64
+	at time.Time
+}
+
+// client is what a Detector remembers of one client.
+type client struct {
+	recent []lookup
+	// near counts the near lookups of the window started at since.
+	near        int
+	since       time.Time
+	reported    bool
+	bannedUntil time.Time
+	lastSeen    time.Time
+}
+
+// Detector tracks the lookups of clients. A nil Detector detects nothing.
+type Detector struct {
+	cfg    Config
+	decode kubeflake.BaseConverter
+
+	mu      sync.Mutex
+	clients map[netip.Addr]*client
+}
+
+// New returns the detector of cfg, or nil when its mode is off.
+func New(cfg Config) *Detector {
+	if cfg.Mode == "" || cfg.Mode == ModeOff {
+		return nil
+	}
+	return &Detector{cfg: cfg.withDefaults(), decode: kubeflake.Base62Converter{}, clients: make(map[netip.Addr]*client)}
+}
+
+// Banned reports whether addr is banned at now.
+func (d *Detector) Banned(addr netip.Addr, now time.Time) bool {
+	if d == nil || d.cfg.Mode != ModeBan {
+		return false
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	c, ok := d.clients[addr]
+	return ok && now.Before(c.bannedUntil)
+}
+
+// Observe records a lookup of key by addr at now. Keys that are not
+// base62, e.g. most custom aliases, are ignored. A layout version
+// stamped on the key is dropped: keys of one version are sequential.
+func (d *Detector) Observe(addr netip.Addr, key string, now time.Time) {
+	if d == nil || !addr.IsValid() || key == "" || len(key) > 13 {
+		return
+	}
+	_, id, err := kubeflake.ParseKey(d.decode, key)
+	if err != nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	c, ok := d.clients[addr]
+	if !ok {
+		if len(d.clients) >= maxClients {
+			d.evict(now)
+		}
+		c = &client{since: now}
+		d.clients[addr] = c
+	}
+	c.lastSeen = now
+
+	recent := c.recent[:0]
+	isNear := false
+	for _, l := range c.recent {
+		if now.Sub(l.at) > d.cfg.Window {
+			continue
+		}
+		recent = append(recent, l)
+		if gap(l.id, id) <= d.cfg.MaxGap {
+			isNear = true
+		}
+	}
+	if len(recent) == d.cfg.Recent {
+		recent = append(recent[:0], recent[1:]...)
+	}
+	c.recent = append(recent, lookup{id: id, at: now})
+
+	if now.Sub(c.since) > d.cfg.Window {
+		c.since, c.near, c.reported = now, 0, false
+	}
+	if !isNear {
+		return
+	}
+	c.near++
+	if c.near < d.cfg.Threshold || c.reported {
+		return
+	}
+	c.reported = true
+	if d.cfg.Mode == ModeBan {
+		c.bannedUntil = now.Add(d.cfg.BanDuration)
+		log.Printf("scan: client %s looked up %d near-consecutive keys within %v (latest %q); banned for %v", addr, c.near, d.cfg.Window, key, d.cfg.BanDuration)
+		return
+	}
+	log.Printf("scan: client %s looked up %d near-consecutive keys within %v (latest %q)", addr, c.near, d.cfg.Window, key)
+}
+
+// evict drops the clients idle for a window and not banned, or, failing
+// that, every client not banned.
+func (d *Detector) evict(now time.Time) {
+	for addr, c := range d.clients {
+		if now.Sub(c.lastSeen) > d.cfg.Window && !now.Before(c.bannedUntil) {
+			delete(d.clients, addr)
+		}
+	}
+	if len(d.clients) < maxClients {
+		return
+	}
+	for addr, c := range d.clients {
+		if !now.Before(c.bannedUntil) {
+			delete(d.clients, addr)
+		}
+	}
+}
+
+func gap(a, b uint64) uint64 {
+	if a > b {
+		return a - b
+	}
+	return b - a
+}
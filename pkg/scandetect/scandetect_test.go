@@ -0,0 +1,71 @@
+package scandetect
+
+import (
+	"math/rand/v2"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/FlorinBalint/shortener/pkg/kubeflake"
+)
+
+func TestDetector_BansSequentialScans(t *testing.T) {
+	d := New(Config{Mode: ModeBan, Threshold: 5, Window: time.Minute, BanDuration: time.Hour})
+	scanner := netip.MustParseAddr("198.51.100.7")
+	visitor := netip.MustParseAddr("203.0.113.9")
+	enc := kubeflake.Base62Converter{}
+	now := time.Now()
+	base := uint64(1) << 50
+
+	// The first lookup has nothing near it; the next 5 are near.
+	for i := range 6 {
+		if d.Banned(scanner, now) {
+			t.Fatalf("banned after %d lookups", i)
+		}
+		d.Observe(scanner, enc.Encode(base+uint64(i)), now)
+		// Links spread over months are far apart.
+		d.Observe(visitor, enc.Encode(base+uint64(i+1)<<40), now)
+		// Aliases that are not base62 are ignored.
+		d.Observe(visitor, "spring-sale", now)
+	}
+	if !d.Banned(scanner, now) {
+		t.Fatal("scanner not banned")
+	}
+	if d.Banned(visitor, now) {
+		t.Fatal("visitor banned")
+	}
+	if d.Banned(scanner, now.Add(2*time.Hour)) {
+		t.Fatal("ban did not expire")
+	}
+}
+
+func TestDetector_SlowScansPass(t *testing.T) {
+	d := New(Config{Mode: ModeBan, Threshold: 3, Window: time.Minute})
+	addr := netip.MustParseAddr("198.51.100.7")
+	enc := kubeflake.Base62Converter{}
+	now := time.Now()
+	for i := range 10 {
+		now = now.Add(2 * time.Minute)
+		d.Observe(addr, enc.Encode(1<<50+uint64(i)), now)
+	}
+	if d.Banned(addr, now) {
+		t.Fatal("lookups a window apart banned")
+	}
+}
+
+func TestDetector_LogModeNeverBans(t *testing.T) {
+	d := New(Config{Mode: ModeLog, Threshold: 2})
+	addr := netip.MustParseAddr("198.51.100.7")
+	enc := kubeflake.Base62Converter{}
+	now := time.Now()
+	start := rand.Uint64N(1 << 60)
+	for i := range 10 {
+		d.Observe(addr, enc.Encode(start+uint64(i)), now)
+	}
+	if d.Banned(addr, now) {
+		t.Fatal("banned in log mode")
+	}
+	if New(Config{Mode: ModeOff}) != nil {
+		t.Fatal("want no detector when off")
+	}
+}
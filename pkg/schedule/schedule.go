@@ -0,0 +1,345 @@
+// Package schedule runs operations at a later time: a Task names what to
+// run (its kind, dispatched to a Handler) and when. Tasks are kept in a
+// Store, so they survive restarts, and run at least once by whichever
+// replica polls the Queue first after they are due; failed runs are
+// retried with exponential backoff.
+package schedule
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"slices"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/datastore"
+
+	"github.com/FlorinBalint/shortener/pkg/gcputil"
+)
+
+// Task is an operation to run at a time.
+type Task struct {
+	// Kind selects the handler running the task.
+	Kind string `json:"kind"`
+	// ID tells tasks of a kind apart: scheduling a task replaces the one of
+	// the same kind and ID.
+	ID string `json:"id"`
+	// At is when the task is due, indexed so that due tasks are queried
+	// by time.
+	At time.Time `json:"at" index:"at"`
+	// Payload is the handler's input, e.g. the key of a link.
+	Payload json.RawMessage `json:"payload,omitempty"`
+	// Attempts counts the runs started, the current one included.
+	Attempts int `json:"attempts,omitempty"`
+}
+
+// name is the task's name in stores.
+func (t Task) name() string {
+	return taskName(t.Kind, t.ID)
+}
+
+func taskName(kind, id string) string {
+	return kind + "/" + id
+}
+
+// Handler runs a task. Errors have the task retried.
+type Handler func(ctx context.Context, t Task) error
+
+// Scheduler schedules tasks.
+type Scheduler interface {
+	// Schedule runs t at t.At, or as soon as possible if that is past,
+	// replacing the task of the same kind and ID.
+	Schedule(ctx context.Context, t Task) error
+	// Cancel removes the task of kind and id, if any.
+	Cancel(ctx context.Context, kind, id string) error
+}
+
+// Store persists tasks by kind and ID.
+type Store interface {
+	// Put stores t, replacing the task of the same kind and ID.
+	Put(ctx context.Context, t Task) error
+	// Delete removes the task of kind and id, if any.
+	Delete(ctx context.Context, kind, id string) error
+	// Claim leases up to limit tasks due at now, earliest first: they are
+	// returned with At moved to until and Attempts counting the run, so
+	// no other claim returns them before until.
+	Claim(ctx context.Context, now, until time.Time, limit int) ([]Task, error)
+	// Finish removes t once run, unless it was rescheduled meanwhile.
+	Finish(ctx context.Context, t Task) error
+}
+
+// DSStore stores tasks in Datastore.
+type DSStore struct {
+	client *gcputil.DSClient
+}
+
+var _ Store = (*DSStore)(nil)
+
+// NewDSStore returns a store persisting tasks under kind "scheduled_task".
+func NewDSStore(client *gcputil.DSClient) *DSStore {
+	return &DSStore{client: client}
+}
+
+// errNotDue skips a task claimed or rescheduled by someone else.
+var errNotDue = errors.New("task not due")
+
+// Put implements Store.
+func (s *DSStore) Put(ctx context.Context, t Task) error {
+	return gcputil.UpsertValue(s.client, ctx, "scheduled_task", t.name(), func(cur *Task) error {
+		*cur = t
+		return nil
+	})
+}
+
+// Delete implements Store.
+func (s *DSStore) Delete(ctx context.Context, kind, id string) error {
+	return s.client.Delete(ctx, "scheduled_task", taskName(kind, id))
+}
+
+// Claim implements Store. Each task is leased in a transaction of its own.
+func (s *DSStore) Claim(ctx context.Context, now, until time.Time, limit int) ([]Task, error) {
+	due, _, err := gcputil.ListValues[Task](s.client, ctx, "scheduled_task", gcputil.ListQuery{
+		Limit:   limit,
+		Order:   "at",
+		Filters: []gcputil.Filter{{Field: "at", Op: "<=", Value: now}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var claimed []Task
+	for _, d := range due {
+		var t Task
+		err := gcputil.UpdateValue(s.client, ctx, "scheduled_task", d.Name, func(cur *Task) error {
+			if cur.At.After(now) {
+				return errNotDue
+			}
+			cur.At = until
+			cur.Attempts++
+			t = *cur
+			return nil
+		})
+		if errors.Is(err, errNotDue) || errors.Is(err, datastore.ErrNoSuchEntity) {
+			continue
+		}
+		if err != nil {
+			return claimed, err
+		}
+		claimed = append(claimed, t)
+	}
+	return claimed, nil
+}
+
+// Finish implements Store.
+func (s *DSStore) Finish(ctx context.Context, t Task) error {
+	_, err := gcputil.DeleteValueIf(s.client, ctx, "scheduled_task", t.name(), func(cur Task) bool {
+		return sameRun(cur, t)
+	})
+	return err
+}
+
+// sameRun reports whether stored is still the claimed task, as Datastore
+// keeps times to the microsecond.
+func sameRun(stored, claimed Task) bool {
+	return stored.Attempts == claimed.Attempts && stored.At.Truncate(time.Microsecond).Equal(claimed.At.Truncate(time.Microsecond))
+}
+
+// MemoryStore is an in-process Store, for tests and local development.
+type MemoryStore struct {
+	mu    sync.Mutex
+	tasks map[string]Task
+}
+
+var _ Store = (*MemoryStore)(nil)
+
+// NewMemoryStore returns an empty in-memory store.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{tasks: make(map[string]Task)}
+}
+
+// Put implements Store.
+func (s *MemoryStore) Put(ctx context.Context, t Task) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tasks[t.name()] = t
+	return nil
+}
+
+// Delete implements Store.
+func (s *MemoryStore) Delete(ctx context.Context, kind, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.tasks, taskName(kind, id))
+	return nil
+}
+
+// Claim implements Store.
+func (s *MemoryStore) Claim(ctx context.Context, now, until time.Time, limit int) ([]Task, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var due []Task
+	for _, t := range s.tasks {
+		if !t.At.After(now) {
+			due = append(due, t)
+		}
+	}
+	slices.SortFunc(due, func(a, b Task) int { return a.At.Compare(b.At) })
+	if len(due) > limit {
+		due = due[:limit]
+	}
+	for i := range due {
+		due[i].At = until
+		due[i].Attempts++
+		s.tasks[due[i].name()] = due[i]
+	}
+	return due, nil
+}
+
+// Finish implements Store.
+func (s *MemoryStore) Finish(ctx context.Context, t Task) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if cur, ok := s.tasks[t.name()]; ok && sameRun(cur, t) {
+		delete(s.tasks, t.name())
+	}
+	return nil
+}
+
+// Config configures a Queue.
+//
+// Lease is how long a claimed task is left to its run before it is claimed
+// again, e.g. because the replica running it died (default 1m). Tasks
+// failing MaxAttempts times are dropped (default 10). BatchSize is the
+// number of tasks claimed per poll (default 100).
+type Config struct {
+	Lease       time.Duration
+	MaxAttempts int
+	BatchSize   int
+}
+
+// Backoff of failed runs.
+const (
+	minRetryDelay = 10 * time.Second
+	maxRetryDelay = time.Hour
+)
+
+// Queue is a Scheduler running the tasks kept in a store with the
+// handlers of their kinds.
+type Queue struct {
+	store Store
+	cfg   Config
+	now   func() time.Time
+
+	mu       sync.RWMutex
+	handlers map[string]Handler
+}
+
+var _ Scheduler = (*Queue)(nil)
+
+// NewQueue returns a queue of the tasks in store.
+func NewQueue(store Store, cfg Config) *Queue {
+	if cfg.Lease <= 0 {
+		cfg.Lease = time.Minute
+	}
+	if cfg.MaxAttempts <= 0 {
+		cfg.MaxAttempts = 10
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = 100
+	}
+	return &Queue{store: store, cfg: cfg, now: time.Now, handlers: make(map[string]Handler)}
+}
+
+// Handle runs the tasks of kind with h.
+func (q *Queue) Handle(kind string, h Handler) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.handlers[kind] = h
+}
+
+func (q *Queue) handler(kind string) Handler {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	return q.handlers[kind]
+}
+
+// Schedule implements Scheduler.
+func (q *Queue) Schedule(ctx context.Context, t Task) error {
+	t.Attempts = 0
+	return q.store.Put(ctx, t)
+}
+
+// Cancel implements Scheduler.
+func (q *Queue) Cancel(ctx context.Context, kind, id string) error {
+	return q.store.Delete(ctx, kind, id)
+}
+
+// RunDue runs the tasks due now, up to BatchSize of them, and returns how
+// many it ran. Failed tasks are rescheduled with backoff. Tasks of kinds
+// without a handler are left to the replicas handling them.
+func (q *Queue) RunDue(ctx context.Context) (int, error) {
+	now := q.now()
+	tasks, err := q.store.Claim(ctx, now, now.Add(q.cfg.Lease), q.cfg.BatchSize)
+	for _, t := range tasks {
+		q.run(ctx, t)
+	}
+	return len(tasks), err
+}
+
+func (q *Queue) run(ctx context.Context, t Task) {
+	h := q.handler(t.Kind)
+	if h == nil {
+		log.Printf("schedule: no handler for task %s, leaving it for %v", t.name(), q.cfg.Lease)
+		return
+	}
+	rctx, cancel := context.WithTimeout(ctx, q.cfg.Lease)
+	err := h(rctx, t)
+	cancel()
+	switch {
+	case err == nil:
+	case t.Attempts >= q.cfg.MaxAttempts:
+		log.Printf("schedule: dropping task %s after %d attempts: %v", t.name(), t.Attempts, err)
+	default:
+		log.Printf("schedule: task %s failed (attempt %d): %v", t.name(), t.Attempts, err)
+		retry := t
+		retry.At = q.now().Add(retryDelay(t.Attempts))
+		if err := q.store.Put(ctx, retry); err != nil {
+			log.Printf("schedule: rescheduling task %s: %v", t.name(), err)
+		}
+		return
+	}
+	if err := q.store.Finish(ctx, t); err != nil {
+		log.Printf("schedule: finishing task %s: %v", t.name(), err)
+	}
+}
+
+// retryDelay is the backoff after a task failed attempts times.
+func retryDelay(attempts int) time.Duration {
+	d := minRetryDelay
+	for i := 1; i < attempts && d < maxRetryDelay; i++ {
+		d *= 2
+	}
+	return min(d, maxRetryDelay)
+}
+
+// Run runs due tasks every interval until ctx is done. Full batches are
+// followed by the next one right away.
+func (q *Queue) Run(ctx context.Context, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		n, err := q.RunDue(ctx)
+		if err != nil {
+			log.Printf("schedule: claiming due tasks: %v", err)
+		}
+		if err == nil && n == q.cfg.BatchSize {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
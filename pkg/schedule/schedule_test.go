@@ -0,0 +1,135 @@
+package schedule
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+type clock struct{ t time.Time }
+
+func (c *clock) now() time.Time { return c.t }
+
+func newTestQueue(cfg Config) (*Queue, *MemoryStore, *clock) {
+	store := NewMemoryStore()
+	q := NewQueue(store, cfg)
+	c := &clock{t: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)}
+	q.now = c.now
+	return q, store, c
+}
+
+func TestQueue_RunsDueTasks(t *testing.T) {
+	q, store, c := newTestQueue(Config{})
+	ctx := context.Background()
+	var ran []string
+	q.Handle("link.delete", func(ctx context.Context, t Task) error {
+		var key string
+		if err := json.Unmarshal(t.Payload, &key); err != nil {
+			return err
+		}
+		ran = append(ran, key)
+		return nil
+	})
+	for i, key := range []string{"later", "soon"} {
+		err := q.Schedule(ctx, Task{
+			Kind:    "link.delete",
+			ID:      key,
+			At:      c.t.Add(time.Duration(2-i) * time.Hour),
+			Payload: json.RawMessage(`"` + key + `"`),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if n, err := q.RunDue(ctx); n != 0 || err != nil {
+		t.Fatalf("before due: ran %d, %v", n, err)
+	}
+	c.t = c.t.Add(3 * time.Hour)
+	if n, err := q.RunDue(ctx); n != 2 || err != nil {
+		t.Fatalf("due: ran %d, %v", n, err)
+	}
+	if len(ran) != 2 || ran[0] != "soon" || ran[1] != "later" {
+		t.Fatalf("want soon then later, got %v", ran)
+	}
+	if len(store.tasks) != 0 {
+		t.Fatalf("want finished tasks removed, got %v", store.tasks)
+	}
+}
+
+func TestQueue_RetriesThenDrops(t *testing.T) {
+	q, store, c := newTestQueue(Config{MaxAttempts: 3})
+	ctx := context.Background()
+	runs := 0
+	q.Handle("webhook", func(ctx context.Context, t Task) error {
+		runs++
+		return errors.New("endpoint down")
+	})
+	if err := q.Schedule(ctx, Task{Kind: "webhook", ID: "1", At: c.t}); err != nil {
+		t.Fatal(err)
+	}
+
+	q.RunDue(ctx)
+	if got := store.tasks["webhook/1"]; got.At != c.t.Add(minRetryDelay) || got.Attempts != 1 {
+		t.Fatalf("want a retry after %v, got %+v", minRetryDelay, got)
+	}
+	c.t = c.t.Add(minRetryDelay)
+	q.RunDue(ctx)
+	if got := store.tasks["webhook/1"]; got.At != c.t.Add(2*minRetryDelay) {
+		t.Fatalf("want backoff doubled, got %+v", got)
+	}
+	c.t = c.t.Add(time.Hour)
+	q.RunDue(ctx)
+	if runs != 3 || len(store.tasks) != 0 {
+		t.Fatalf("want the task dropped after 3 runs, got %d runs and %v", runs, store.tasks)
+	}
+}
+
+func TestQueue_RescheduledWhileRunning(t *testing.T) {
+	q, store, c := newTestQueue(Config{})
+	ctx := context.Background()
+	q.Handle("switch", func(ctx context.Context, t Task) error {
+		return q.Schedule(ctx, Task{Kind: "switch", ID: t.ID, At: c.t.Add(time.Hour)})
+	})
+	if err := q.Schedule(ctx, Task{Kind: "switch", ID: "promo", At: c.t}); err != nil {
+		t.Fatal(err)
+	}
+	q.RunDue(ctx)
+	if got, ok := store.tasks["switch/promo"]; !ok || got.At != c.t.Add(time.Hour) {
+		t.Fatalf("want the new schedule kept, got %+v", got)
+	}
+}
+
+func TestQueue_LeasesTasksWithoutHandler(t *testing.T) {
+	q, store, c := newTestQueue(Config{Lease: time.Minute})
+	ctx := context.Background()
+	if err := q.Schedule(ctx, Task{Kind: "unknown", ID: "x", At: c.t}); err != nil {
+		t.Fatal(err)
+	}
+	q.RunDue(ctx)
+	if n, _ := q.RunDue(ctx); n != 0 {
+		t.Fatalf("want the task leased, claimed %d", n)
+	}
+	if got := store.tasks["unknown/x"]; got.At != c.t.Add(time.Minute) {
+		t.Fatalf("want the task claimable after the lease, got %+v", got)
+	}
+}
+
+func TestQueue_Cancel(t *testing.T) {
+	q, _, c := newTestQueue(Config{})
+	ctx := context.Background()
+	q.Handle("k", func(ctx context.Context, t Task) error {
+		return errors.New("canceled task ran")
+	})
+	if err := q.Schedule(ctx, Task{Kind: "k", ID: "1", At: c.t}); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Cancel(ctx, "k", "1"); err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := q.RunDue(ctx); n != 0 {
+		t.Fatalf("canceled task claimed")
+	}
+}
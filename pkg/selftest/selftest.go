@@ -0,0 +1,292 @@
+// Package selftest checks at startup that a service can run as
+// configured: that its configuration is valid and that it reaches its
+// dependencies with the permissions it needs. Services run it with
+// -check-config, e.g. as an init container or before a rollout, so a
+// misconfigured deployment fails there instead of on its first request.
+// Reports print as text, JSON or JUnit; the smoke tests of pkg/smoke
+// report the same way.
+package selftest
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"cloud.google.com/go/datastore"
+	"github.com/google/gomemcache/memcache"
+
+	"github.com/FlorinBalint/shortener/pkg/discovery"
+	"github.com/FlorinBalint/shortener/pkg/gcputil"
+)
+
+// DefaultTimeout bounds each check.
+const DefaultTimeout = 10 * time.Second
+
+// Check is one step of a self-test.
+type Check struct {
+	Name string
+	Run  func(ctx context.Context) error
+	// Fatal skips the checks after this one when it fails, as those
+	// depending on a valid configuration.
+	Fatal bool
+}
+
+// Result is the outcome of a check. Skipped checks have neither an error
+// nor a duration.
+type Result struct {
+	Name    string
+	Err     error
+	Took    time.Duration
+	Skipped bool
+}
+
+// Report is the outcome of a self-test.
+type Report struct {
+	Service string
+	// Suite names what ran in the verdict line and the JUnit suite,
+	// "self-test" when empty; e.g. "smoke test".
+	Suite   string
+	Results []Result
+}
+
+func (r Report) suite() string {
+	if r.Suite == "" {
+		return "self-test"
+	}
+	return r.Suite
+}
+
+// Run runs checks in order, each within timeout (DefaultTimeout if 0).
+func Run(ctx context.Context, service string, timeout time.Duration, checks ...Check) Report {
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	r := Report{Service: service}
+	skip := false
+	for _, c := range checks {
+		if skip {
+			r.Results = append(r.Results, Result{Name: c.Name, Skipped: true})
+			continue
+		}
+		cctx, cancel := context.WithTimeout(ctx, timeout)
+		start := time.Now()
+		err := c.Run(cctx)
+		cancel()
+		r.Results = append(r.Results, Result{Name: c.Name, Err: err, Took: time.Since(start)})
+		skip = err != nil && c.Fatal
+	}
+	return r
+}
+
+// OK reports whether every check ran and passed.
+func (r Report) OK() bool {
+	for _, res := range r.Results {
+		if res.Err != nil || res.Skipped {
+			return false
+		}
+	}
+	return true
+}
+
+// Print writes the report, a line per check.
+func (r Report) Print(w io.Writer) {
+	for _, res := range r.Results {
+		switch {
+		case res.Skipped:
+			fmt.Fprintf(w, "SKIP %s\n", res.Name)
+		case res.Err != nil:
+			fmt.Fprintf(w, "FAIL %s: %v\n", res.Name, res.Err)
+		default:
+			fmt.Fprintf(w, "ok   %s (%v)\n", res.Name, res.Took.Round(time.Millisecond))
+		}
+	}
+	verdict := "passed"
+	if !r.OK() {
+		verdict = "FAILED"
+	}
+	fmt.Fprintf(w, "%s %s %s\n", r.Service, r.suite(), verdict)
+}
+
+type jsonResult struct {
+	Name   string  `json:"name"`
+	Status string  `json:"status"`
+	Error  string  `json:"error,omitempty"`
+	Took   float64 `json:"took_seconds,omitempty"`
+}
+
+// status is "ok", "fail" or "skip".
+func (res Result) status() string {
+	switch {
+	case res.Skipped:
+		return "skip"
+	case res.Err != nil:
+		return "fail"
+	}
+	return "ok"
+}
+
+// WriteJSON writes the report as JSON, for pipelines:
+// {"service", "suite", "ok", "results": [{"name", "status", "error", "took_seconds"}]},
+// status being ok, fail or skip.
+func (r Report) WriteJSON(w io.Writer) error {
+	out := struct {
+		Service string       `json:"service"`
+		Suite   string       `json:"suite"`
+		OK      bool         `json:"ok"`
+		Results []jsonResult `json:"results"`
+	}{Service: r.Service, Suite: r.suite(), OK: r.OK(), Results: make([]jsonResult, len(r.Results))}
+	for i, res := range r.Results {
+		out.Results[i] = jsonResult{Name: res.Name, Status: res.status(), Took: res.Took.Seconds()}
+		if res.Err != nil {
+			out.Results[i].Error = res.Err.Error()
+		}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(out)
+}
+
+type junitSuite struct {
+	XMLName  xml.Name    `xml:"testsuite"`
+	Name     string      `xml:"name,attr"`
+	Tests    int         `xml:"tests,attr"`
+	Failures int         `xml:"failures,attr"`
+	Skipped  int         `xml:"skipped,attr"`
+	Time     string      `xml:"time,attr"`
+	Cases    []junitCase `xml:"testcase"`
+}
+
+type junitCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	Skipped   *struct{}     `xml:"skipped,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+}
+
+// WriteJUnit writes the report as a JUnit XML test suite, a test case per
+// check, for CI systems that render test results.
+func (r Report) WriteJUnit(w io.Writer) error {
+	seconds := func(d time.Duration) string { return strconv.FormatFloat(d.Seconds(), 'f', 3, 64) }
+	suite := junitSuite{Name: r.Service + " " + r.suite(), Tests: len(r.Results)}
+	var total time.Duration
+	for _, res := range r.Results {
+		c := junitCase{Name: res.Name, ClassName: r.Service, Time: seconds(res.Took)}
+		switch {
+		case res.Skipped:
+			suite.Skipped++
+			c.Skipped = &struct{}{}
+		case res.Err != nil:
+			suite.Failures++
+			c.Failure = &junitFailure{Message: res.Err.Error()}
+		}
+		total += res.Took
+		suite.Cases = append(suite.Cases, c)
+	}
+	suite.Time = seconds(total)
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(suite); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// probeKind is the Datastore kind of the probe entities; nothing else
+// uses it.
+const probeKind = "selftest_probe"
+
+// probeName names this process's probe entity and cache item.
+func probeName() string {
+	host, _ := os.Hostname()
+	return host + "-" + strconv.FormatInt(time.Now().UnixNano(), 36)
+}
+
+// DatastoreRead checks that client may read, looking up a probe entity
+// that need not exist.
+func DatastoreRead(client *gcputil.DSClient) func(context.Context) error {
+	return func(ctx context.Context) error {
+		_, err := gcputil.GetValue[time.Time](client, ctx, probeKind, probeName())
+		if err != nil && !errors.Is(err, datastore.ErrNoSuchEntity) {
+			return fmt.Errorf("read: %w", err)
+		}
+		return nil
+	}
+}
+
+// DatastoreWrite checks that client may create, read and delete
+// entities, with a probe entity it removes again.
+func DatastoreWrite(client *gcputil.DSClient) func(context.Context) error {
+	return func(ctx context.Context) error {
+		name, now := probeName(), time.Now().UTC()
+		if err := gcputil.PutNewValue(client, ctx, probeKind, name, now); err != nil {
+			return fmt.Errorf("write: %w", err)
+		}
+		if _, err := gcputil.GetValue[time.Time](client, ctx, probeKind, name); err != nil {
+			return fmt.Errorf("read: %w", err)
+		}
+		if err := client.Delete(ctx, probeKind, name); err != nil {
+			return fmt.Errorf("delete: %w", err)
+		}
+		return nil
+	}
+}
+
+// Memcache checks that the memcache nodes of servers, or those found
+// through the discovery endpoint (see discovery.NewMemcache), answer,
+// writing a short-lived probe item if write is set.
+func Memcache(servers, endpoint string, write bool) func(context.Context) error {
+	return func(ctx context.Context) error {
+		mc, err := discovery.NewMemcache(servers, endpoint)
+		if err != nil {
+			return fmt.Errorf("discovery: %w", err)
+		}
+		if mc.StopPolling != nil {
+			defer mc.StopPolling()
+		}
+		key := "selftest:" + probeName()
+		if write {
+			if err := mc.Set(&memcache.Item{Key: key, Value: []byte("ok"), Expiration: 10}); err != nil {
+				return fmt.Errorf("set: %w", err)
+			}
+		}
+		if _, err := mc.Get(key); err != nil && !(errors.Is(err, memcache.ErrCacheMiss) && !write) {
+			return fmt.Errorf("get: %w", err)
+		}
+		return nil
+	}
+}
+
+// HTTPGet checks that url answers a GET with a 2xx status.
+func HTTPGet(client *http.Client, url string) func(context.Context) error {
+	return func(ctx context.Context) error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode/100 != 2 {
+			return fmt.Errorf("GET %s: %s", url, resp.Status)
+		}
+		return nil
+	}
+}
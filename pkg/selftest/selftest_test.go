@@ -0,0 +1,111 @@
+package selftest
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRun(t *testing.T) {
+	ran := 0
+	pass := func(context.Context) error { ran++; return nil }
+	fail := func(context.Context) error { ran++; return errors.New("boom") }
+
+	r := Run(context.Background(), "svc", 0,
+		Check{Name: "a", Run: pass},
+		Check{Name: "b", Run: fail},
+		Check{Name: "c", Run: pass, Fatal: true},
+		Check{Name: "d", Run: fail, Fatal: true},
+		Check{Name: "e", Run: pass},
+	)
+	if ran != 4 {
+		t.Fatalf("want 4 checks run, the one after a fatal failure skipped, got %d", ran)
+	}
+	if r.OK() {
+		t.Fatal("want the report failed")
+	}
+	if res := r.Results[4]; !res.Skipped {
+		t.Fatalf("want e skipped, got %+v", res)
+	}
+
+	var out strings.Builder
+	r.Print(&out)
+	for _, want := range []string{"ok   a", "FAIL b: boom", "FAIL d: boom", "SKIP e", "svc self-test FAILED"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("report lacks %q:\n%s", want, out.String())
+		}
+	}
+
+	if r := Run(context.Background(), "svc", 0, Check{Name: "a", Run: pass}); !r.OK() {
+		t.Fatalf("want passed, got %+v", r)
+	}
+}
+
+func TestReport_Write(t *testing.T) {
+	r := Report{Service: "svc", Suite: "smoke test", Results: []Result{
+		{Name: "a", Took: 1500 * time.Millisecond},
+		{Name: "b", Err: errors.New(`want "x" & <y>`)},
+		{Name: "c", Skipped: true},
+	}}
+
+	var out strings.Builder
+	r.Print(&out)
+	if !strings.Contains(out.String(), "svc smoke test FAILED") {
+		t.Errorf("verdict lacks the suite:\n%s", out.String())
+	}
+
+	out.Reset()
+	if err := r.WriteJSON(&out); err != nil {
+		t.Fatal(err)
+	}
+	var j struct {
+		OK      bool `json:"ok"`
+		Results []struct {
+			Name, Status, Error string
+		} `json:"results"`
+	}
+	if err := json.Unmarshal([]byte(out.String()), &j); err != nil {
+		t.Fatal(err)
+	}
+	if j.OK || len(j.Results) != 3 || j.Results[0].Status != "ok" || j.Results[1].Status != "fail" || j.Results[1].Error != `want "x" & <y>` || j.Results[2].Status != "skip" {
+		t.Errorf("unexpected JSON report: %s", out.String())
+	}
+
+	out.Reset()
+	if err := r.WriteJUnit(&out); err != nil {
+		t.Fatal(err)
+	}
+	var suite junitSuite
+	if err := xml.Unmarshal([]byte(out.String()), &suite); err != nil {
+		t.Fatalf("%v:\n%s", err, out.String())
+	}
+	if suite.Name != "svc smoke test" || suite.Tests != 3 || suite.Failures != 1 || suite.Skipped != 1 || suite.Time != "1.500" {
+		t.Errorf("unexpected suite: %+v", suite)
+	}
+	if c := suite.Cases[1]; c.Failure == nil || c.Failure.Message != `want "x" & <y>` {
+		t.Errorf("unexpected failure: %+v", c)
+	}
+}
+
+func TestHTTPGet(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/health" {
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	ctx := context.Background()
+	if err := HTTPGet(srv.Client(), srv.URL+"/health")(ctx); err != nil {
+		t.Fatalf("healthy: %v", err)
+	}
+	if err := HTTPGet(srv.Client(), srv.URL+"/missing")(ctx); err == nil {
+		t.Fatal("want a 404 to fail")
+	}
+}
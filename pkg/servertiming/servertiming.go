@@ -0,0 +1,172 @@
+// Package servertiming breaks the latency of requests down into the time
+// spent in caches, the Datastore and keygen, in the Server-Timing response
+// header (https://www.w3.org/TR/server-timing/), so clients and operators
+// can tell whose side the time goes:
+//
+//	Server-Timing: cache;dur=0.4, datastore;dur=12.1, total;dur=13.9
+//
+// Durations are in milliseconds, summed over the calls of each kind, which
+// may overlap when made at once. The stores and clients record their time
+// in the Timings the middleware puts in the request context; without one
+// recording costs nothing.
+package servertiming
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Header is the response header carrying the timings.
+const Header = "Server-Timing"
+
+// RequestHeader asks for the timings in ModeHeader.
+const RequestHeader = "X-Debug-Timing"
+
+// Names of the timings.
+const (
+	Cache     = "cache"
+	Datastore = "datastore"
+	Keygen    = "keygen"
+	Total     = "total"
+)
+
+// Mode is when responses carry the header.
+type Mode string
+
+// Modes.
+const (
+	// ModeOff never adds the header.
+	ModeOff Mode = ""
+	// ModeHeader adds it to the responses of requests with a
+	// RequestHeader, e.g. from curl -H 'X-Debug-Timing: 1'.
+	ModeHeader Mode = "header"
+	// ModeAlways adds it to every response, e.g. for browsers' developer
+	// tools.
+	ModeAlways Mode = "always"
+)
+
+// Validate reports an unknown mode.
+func (m Mode) Validate() error {
+	switch m {
+	case ModeOff, ModeHeader, ModeAlways:
+		return nil
+	}
+	return fmt.Errorf("server timing mode %q: want header or always", string(m))
+}
+
+// Timings accumulate the time spent per kind while serving a request.
+// They are safe for concurrent use.
+type Timings struct {
+	mu    sync.Mutex
+	names []string
+	durs  map[string]time.Duration
+}
+
+// New returns empty timings.
+func New() *Timings {
+	return &Timings{durs: make(map[string]time.Duration)}
+}
+
+// Add adds d to the time spent in name. The nil *Timings records nothing.
+func (t *Timings) Add(name string, d time.Duration) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, ok := t.durs[name]; !ok {
+		t.names = append(t.names, name)
+	}
+	t.durs[name] += d
+}
+
+// String formats the timings as a Server-Timing value, in the order they
+// were first recorded.
+func (t *Timings) String() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	parts := make([]string, len(t.names))
+	for i, name := range t.names {
+		parts[i] = name + ";dur=" + strconv.FormatFloat(float64(t.durs[name])/float64(time.Millisecond), 'f', 1, 64)
+	}
+	return strings.Join(parts, ", ")
+}
+
+type ctxKey struct{}
+
+// NewContext returns a copy of ctx carrying t.
+func NewContext(ctx context.Context, t *Timings) context.Context {
+	return context.WithValue(ctx, ctxKey{}, t)
+}
+
+// FromContext returns the timings of ctx, or nil.
+func FromContext(ctx context.Context) *Timings {
+	t, _ := ctx.Value(ctxKey{}).(*Timings)
+	return t
+}
+
+// Since adds the time since start to name in the timings of ctx, if any.
+func Since(ctx context.Context, name string, start time.Time) {
+	FromContext(ctx).Add(name, time.Since(start))
+}
+
+// Middleware records the timings of the requests mode selects and sends
+// them, with the total time so far, as the response header is written.
+// ModeOff returns next itself.
+func Middleware(mode Mode) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if mode == ModeOff {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if mode == ModeHeader && r.Header.Get(RequestHeader) == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			t := New()
+			tw := &timingWriter{ResponseWriter: w, timings: t, start: time.Now()}
+			next.ServeHTTP(tw, r.WithContext(NewContext(r.Context(), t)))
+			// An empty response is written after the handler returns.
+			tw.setHeader()
+		})
+	}
+}
+
+// timingWriter sets the header before the response's. Unwrap lets
+// http.ResponseController reach the writer below, to flush.
+type timingWriter struct {
+	http.ResponseWriter
+	timings *Timings
+	start   time.Time
+	wrote   bool
+}
+
+func (w *timingWriter) setHeader() {
+	if w.wrote {
+		return
+	}
+	w.wrote = true
+	w.timings.Add(Total, time.Since(w.start))
+	w.Header().Set(Header, w.timings.String())
+}
+
+func (w *timingWriter) WriteHeader(status int) {
+	if status >= 200 {
+		w.setHeader()
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *timingWriter) Write(b []byte) (int, error) {
+	w.setHeader()
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *timingWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
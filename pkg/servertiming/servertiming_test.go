@@ -0,0 +1,70 @@
+package servertiming
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestTimings(t *testing.T) {
+	var none *Timings
+	none.Add(Cache, time.Second) // Must not panic.
+
+	tm := New()
+	tm.Add(Datastore, 12*time.Millisecond)
+	tm.Add(Cache, 400*time.Microsecond)
+	tm.Add(Datastore, 100*time.Microsecond)
+	if got, want := tm.String(), "datastore;dur=12.1, cache;dur=0.4"; got != want {
+		t.Errorf("String = %q, want %q", got, want)
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		FromContext(r.Context()).Add(Cache, time.Millisecond)
+		if r.URL.Path == "/empty" {
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	})
+	pattern := regexp.MustCompile(`^cache;dur=1\.0, total;dur=\d+\.\d$`)
+	for _, tc := range []struct {
+		mode   Mode
+		path   string
+		debug  bool
+		header bool
+	}{
+		{mode: ModeOff, path: "/", debug: true},
+		{mode: ModeHeader, path: "/"},
+		{mode: ModeHeader, path: "/", debug: true, header: true},
+		{mode: ModeAlways, path: "/", header: true},
+		{mode: ModeAlways, path: "/empty", header: true},
+	} {
+		req := httptest.NewRequest(http.MethodGet, tc.path, nil)
+		if tc.debug {
+			req.Header.Set(RequestHeader, "1")
+		}
+		rec := httptest.NewRecorder()
+		Middleware(tc.mode)(handler).ServeHTTP(rec, req)
+		got := rec.Header().Get(Header)
+		if tc.header != (got != "") {
+			t.Errorf("%q %s debug=%v: got header %q", tc.mode, tc.path, tc.debug, got)
+		}
+		if tc.header && !pattern.MatchString(got) {
+			t.Errorf("%q %s: header %q does not match %s", tc.mode, tc.path, got, pattern)
+		}
+	}
+}
+
+func TestMode_Validate(t *testing.T) {
+	for _, m := range []Mode{ModeOff, ModeHeader, ModeAlways} {
+		if err := m.Validate(); err != nil {
+			t.Errorf("%q: %v", m, err)
+		}
+	}
+	if err := Mode("on").Validate(); err == nil {
+		t.Error(`"on": want an error`)
+	}
+}
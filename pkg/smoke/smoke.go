@@ -0,0 +1,316 @@
+// Package smoke checks a deployed shortener end to end, as a pipeline
+// does after a rollout: it creates a link through the writer, follows it
+// through the reader, reads it back, deletes it and checks that the
+// reader stops serving it. The steps are selftest checks, so the outcome
+// is a selftest.Report, printable as text, JSON or JUnit.
+package smoke
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/FlorinBalint/shortener/pkg/selftest"
+)
+
+// DefaultWait bounds how long a step waits for a change to reach the
+// reader, through the write queue and its caches.
+const DefaultWait = 30 * time.Second
+
+// pollInterval spaces the reader lookups of a step waiting for a change.
+const pollInterval = 500 * time.Millisecond
+
+// Config is the stack to check.
+type Config struct {
+	// WriterURL is the base URL the writer answers /write/ on, and
+	// ReaderURL the one short links are served on; behind the load
+	// balancer both are the same.
+	WriterURL string
+	ReaderURL string
+	// APIKey is sent as X-API-Key and Token as a bearer token, either
+	// authorizing creates and deletes as the writer's AUTH_MODE requires.
+	APIKey string
+	Token  string
+	// Target is the URL the link redirects to; the run's ID is added as
+	// the smoke query parameter, so runs never share a link.
+	Target string
+	// Campaign, when set, names a campaign the link joins, whose stats
+	// must count it.
+	Campaign string
+	// Wait is how long a change may take to reach the reader; 0 means
+	// DefaultWait.
+	Wait time.Duration
+	// Client makes the requests; nil uses a client with a 10s timeout.
+	// It must not follow redirects.
+	Client *http.Client
+}
+
+// Checks returns the steps of the scenario, in order. They share the
+// link they create: a failed create skips the rest.
+func Checks(cfg Config) []selftest.Check {
+	if cfg.ReaderURL == "" {
+		cfg.ReaderURL = cfg.WriterURL
+	}
+	cfg.WriterURL = strings.TrimSuffix(cfg.WriterURL, "/")
+	cfg.ReaderURL = strings.TrimSuffix(cfg.ReaderURL, "/")
+	if cfg.Wait <= 0 {
+		cfg.Wait = DefaultWait
+	}
+	if cfg.Client == nil {
+		cfg.Client = &http.Client{
+			Timeout:       10 * time.Second,
+			CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+		}
+	}
+	r := &run{cfg: cfg}
+	checks := []selftest.Check{
+		{Name: "create", Run: r.create, Fatal: true},
+		{Name: "resolve", Run: r.resolve},
+		{Name: "read", Run: r.read},
+	}
+	if cfg.Campaign != "" {
+		checks = append(checks, selftest.Check{Name: "campaign_stats", Run: r.campaignStats})
+	}
+	return append(checks,
+		selftest.Check{Name: "delete", Run: r.delete},
+		selftest.Check{Name: "gone", Run: r.gone},
+	)
+}
+
+// Run runs the scenario, each step within stepTimeout.
+func Run(ctx context.Context, cfg Config, stepTimeout time.Duration) selftest.Report {
+	report := selftest.Run(ctx, "shortener", stepTimeout, Checks(cfg)...)
+	report.Suite = "smoke test"
+	return report
+}
+
+// run is the state the steps share.
+type run struct {
+	cfg    Config
+	target string
+	key    string
+	etag   string
+	// links is the campaign's link count before the create.
+	links int
+}
+
+type createRequest struct {
+	URLTarget string    `json:"url_target"`
+	ExpiresAt time.Time `json:"expires_at"`
+	Campaign  string    `json:"campaign,omitempty"`
+}
+
+type createResponse struct {
+	URLKey string `json:"url_key"`
+}
+
+type campaignStats struct {
+	Stats struct {
+		Links int `json:"links"`
+	} `json:"stats"`
+}
+
+func (r *run) create(ctx context.Context) error {
+	u, err := url.Parse(r.cfg.Target)
+	if err != nil || u.Host == "" {
+		return fmt.Errorf("invalid target %q", r.cfg.Target)
+	}
+	q := u.Query()
+	q.Set("smoke", strconv.FormatInt(time.Now().UnixNano(), 36))
+	u.RawQuery = q.Encode()
+	r.target = u.String()
+	if r.cfg.Campaign != "" {
+		var st campaignStats
+		if err := r.getJSON(ctx, r.campaignStatsURL(), &st); err != nil {
+			return fmt.Errorf("campaign stats: %w", err)
+		}
+		r.links = st.Stats.Links
+	}
+	// The link expires on its own should the delete never run.
+	body, err := json.Marshal(createRequest{URLTarget: r.target, ExpiresAt: time.Now().Add(time.Hour).UTC(), Campaign: r.cfg.Campaign})
+	if err != nil {
+		return err
+	}
+	resp, err := r.do(ctx, http.MethodPost, r.cfg.WriterURL+"/write/v1", bytes.NewReader(body), nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusAccepted {
+		return statusError(resp)
+	}
+	var created createResponse
+	if err := json.NewDecoder(resp.Body).Decode(&created); err != nil {
+		return fmt.Errorf("decoding the create response: %w", err)
+	}
+	if created.URLKey == "" {
+		return errors.New("the create response has no url_key")
+	}
+	r.key = created.URLKey
+	return nil
+}
+
+// resolve waits for the reader to redirect the link to its target.
+func (r *run) resolve(ctx context.Context) error {
+	return r.poll(ctx, func() (bool, error) {
+		resp, err := r.do(ctx, http.MethodGet, r.cfg.ReaderURL+"/"+r.key, nil, nil)
+		if err != nil {
+			return false, err
+		}
+		defer resp.Body.Close()
+		io.Copy(io.Discard, resp.Body)
+		switch resp.StatusCode {
+		case http.StatusNotFound:
+			return false, fmt.Errorf("GET /%s: still 404", r.key)
+		case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther, http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+			if loc := resp.Header.Get("Location"); loc != r.target {
+				return true, fmt.Errorf("GET /%s redirects to %q, want %q", r.key, loc, r.target)
+			}
+			return true, nil
+		}
+		return true, fmt.Errorf("GET /%s: %s, want a redirect", r.key, resp.Status)
+	})
+}
+
+// read reads the link back from the writer, keeping its ETag.
+func (r *run) read(ctx context.Context) error {
+	var link struct {
+		URLTarget string `json:"url_target"`
+	}
+	resp, err := r.do(ctx, http.MethodGet, r.linkURL(), nil, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return statusError(resp)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&link); err != nil {
+		return fmt.Errorf("decoding the link: %w", err)
+	}
+	if link.URLTarget != r.target {
+		return fmt.Errorf("the link targets %q, want %q", link.URLTarget, r.target)
+	}
+	r.etag = resp.Header.Get("ETag")
+	return nil
+}
+
+// campaignStats waits for the campaign's stats to count the link.
+func (r *run) campaignStats(ctx context.Context) error {
+	return r.poll(ctx, func() (bool, error) {
+		var st campaignStats
+		if err := r.getJSON(ctx, r.campaignStatsURL(), &st); err != nil {
+			return true, err
+		}
+		if st.Stats.Links != r.links+1 {
+			return false, fmt.Errorf("the campaign counts %d links, want %d", st.Stats.Links, r.links+1)
+		}
+		return true, nil
+	})
+}
+
+func (r *run) delete(ctx context.Context) error {
+	if r.etag == "" {
+		// The read failed: delete the link as it is now.
+		if err := r.read(ctx); err != nil {
+			return fmt.Errorf("reading the link to delete: %w", err)
+		}
+	}
+	resp, err := r.do(ctx, http.MethodDelete, r.linkURL(), nil, http.Header{"If-Match": {r.etag}})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return statusError(resp)
+	}
+	return nil
+}
+
+// gone waits for the reader to stop serving the link.
+func (r *run) gone(ctx context.Context) error {
+	return r.poll(ctx, func() (bool, error) {
+		resp, err := r.do(ctx, http.MethodGet, r.cfg.ReaderURL+"/"+r.key, nil, nil)
+		if err != nil {
+			return false, err
+		}
+		defer resp.Body.Close()
+		io.Copy(io.Discard, resp.Body)
+		if resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone {
+			return true, nil
+		}
+		return false, fmt.Errorf("GET /%s: still %s after the delete", r.key, resp.Status)
+	})
+}
+
+// poll calls try until it is done or Wait passes, returning its last
+// error.
+func (r *run) poll(ctx context.Context, try func() (done bool, err error)) error {
+	deadline := time.Now().Add(r.cfg.Wait)
+	for {
+		done, err := try()
+		if done || time.Now().After(deadline) {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return errors.Join(err, ctx.Err())
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+func (r *run) linkURL() string {
+	return r.cfg.WriterURL + "/write/v1/links/" + r.key
+}
+
+func (r *run) campaignStatsURL() string {
+	return r.cfg.WriterURL + "/write/v1/campaigns/" + url.PathEscape(r.cfg.Campaign) + "/stats"
+}
+
+// do sends a request with the configured credentials.
+func (r *run) do(ctx context.Context, method, u string, body io.Reader, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, u, body)
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if r.cfg.APIKey != "" {
+		req.Header.Set("X-API-Key", r.cfg.APIKey)
+	}
+	if r.cfg.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+r.cfg.Token)
+	}
+	return r.cfg.Client.Do(req)
+}
+
+func (r *run) getJSON(ctx context.Context, u string, v any) error {
+	resp, err := r.do(ctx, http.MethodGet, u, nil, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return statusError(resp)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// statusError describes an unexpected answer, with the start of its body.
+func statusError(resp *http.Response) error {
+	b, _ := io.ReadAll(io.LimitReader(resp.Body, 200))
+	return fmt.Errorf("%s %s: %s: %s", resp.Request.Method, resp.Request.URL.Path, resp.Status, strings.TrimSpace(string(b)))
+}
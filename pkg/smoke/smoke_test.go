@@ -0,0 +1,106 @@
+package smoke
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/FlorinBalint/shortener/pkg/reader"
+	"github.com/FlorinBalint/shortener/pkg/urlstore"
+	"github.com/FlorinBalint/shortener/pkg/writer"
+)
+
+// stack serves a writer and a reader sharing a store, as behind the load
+// balancer.
+func stack(t *testing.T, breakRedirects bool) *httptest.Server {
+	store := urlstore.NewMemoryClient()
+	var n int
+	w := writer.NewHandlerWithStore(writer.Config{AdminToken: "root", KeyGenerator: func(context.Context) (string, error) {
+		n++
+		return "smoke" + string(rune('a'+n)), nil
+	}}, store)
+	r := reader.NewHandlerWithStore(reader.Config{}, store)
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		switch {
+		case strings.HasPrefix(req.URL.Path, "/write/"):
+			w.ServeHTTP(rw, req)
+		case breakRedirects:
+			http.Redirect(rw, req, "https://elsewhere.example/", http.StatusFound)
+		default:
+			r.ServeHTTP(rw, req)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// post posts body to the writer of srv with header, and decodes the
+// response into v, if not nil.
+func post(t *testing.T, srv *httptest.Server, path string, header http.Header, body string, v any) {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodPost, srv.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header = header
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		t.Fatalf("POST %s: %s", path, resp.Status)
+	}
+	if v != nil {
+		if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestRun(t *testing.T) {
+	srv := stack(t, false)
+	// The run's API key owns the campaign, as only owners attach links.
+	var key struct {
+		Secret string `json:"secret"`
+	}
+	post(t, srv, "/write/v1/admin/keys", http.Header{"Authorization": {"Bearer root"}}, `{"name":"smoke"}`, &key)
+	post(t, srv, "/write/v1/campaigns", http.Header{"X-Api-Key": {key.Secret}}, `{"id":"smoke","name":"Smoke tests"}`, nil)
+	report := Run(context.Background(), Config{WriterURL: srv.URL, APIKey: key.Secret, Target: "https://example.com/smoke", Campaign: "smoke", Wait: time.Second}, 0)
+	if !report.OK() {
+		var out strings.Builder
+		report.Print(&out)
+		t.Fatalf("want the smoke test passed:\n%s", out.String())
+	}
+	var names []string
+	for _, res := range report.Results {
+		names = append(names, res.Name)
+	}
+	if got := strings.Join(names, ","); got != "create,resolve,read,campaign_stats,delete,gone" {
+		t.Errorf("steps = %s", got)
+	}
+}
+
+func TestRun_Failures(t *testing.T) {
+	srv := stack(t, true)
+	report := Run(context.Background(), Config{WriterURL: srv.URL, Token: "root", Target: "https://example.com/smoke", Wait: time.Second}, 0)
+	if report.OK() {
+		t.Fatal("want the smoke test failed")
+	}
+	failed := map[string]bool{}
+	for _, res := range report.Results {
+		failed[res.Name] = res.Err != nil
+	}
+	if !failed["resolve"] || !failed["gone"] || failed["create"] || failed["delete"] {
+		t.Errorf("want resolve and gone failed, got %v", report.Results)
+	}
+
+	report = Run(context.Background(), Config{WriterURL: srv.URL, Target: "not a url"}, 0)
+	if res := report.Results; res[0].Err == nil || !res[1].Skipped {
+		t.Errorf("bad target: want create failed and the rest skipped, got %+v", res)
+	}
+}
@@ -0,0 +1,350 @@
+// Package sqlitestore is a urlstore.Client keeping links in a SQLite
+// database file, with modernc.org/sqlite (pure Go, no cgo), for the
+// all-in-one binary and integration tests: no external datastore is
+// needed. It is kept out of urlstore so the services do not link SQLite.
+//
+// Entries are stored as JSON, with their creation time alongside to order
+// listings by. The database runs in WAL mode, so reads do not wait for
+// writes, and write transactions take the write lock as they begin, so
+// concurrent updates wait for one another instead of failing.
+package sqlitestore
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	_ "modernc.org/sqlite" // registers the "sqlite" driver
+
+	"github.com/FlorinBalint/shortener/pkg/urlstore"
+)
+
+// schema creates the table of entries, keyed by their key, and the index
+// listings by creation time scan.
+const schema = `
+CREATE TABLE IF NOT EXISTS url_entries (
+	url_key TEXT PRIMARY KEY,
+	created INTEGER NOT NULL,
+	entry   TEXT NOT NULL
+) WITHOUT ROWID;
+CREATE INDEX IF NOT EXISTS url_entries_created ON url_entries (created, url_key);
+`
+
+// maxBatch bounds the keys of a statement, below SQLite's variable limit.
+const maxBatch = 500
+
+// Client is a urlstore.Client backed by a SQLite database.
+type Client struct {
+	db *sql.DB
+}
+
+var _ urlstore.Client = (*Client)(nil)
+
+// Open opens the database at path, creating it and its schema if needed.
+func Open(ctx context.Context, path string) (*Client, error) {
+	if path == "" || strings.ContainsAny(path, "?#") {
+		return nil, fmt.Errorf("sqlite: invalid database path %q", path)
+	}
+	db, err := sql.Open("sqlite", "file:"+path+
+		"?_pragma=journal_mode(WAL)&_pragma=synchronous(NORMAL)&_pragma=busy_timeout(5000)&_txlock=immediate")
+	if err != nil {
+		return nil, fmt.Errorf("sqlite: %w", err)
+	}
+	if _, err := db.ExecContext(ctx, schema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("sqlite: creating schema: %w", err)
+	}
+	return &Client{db: db}, nil
+}
+
+// Close implements urlstore.Client.
+func (c *Client) Close() error {
+	return c.db.Close()
+}
+
+// created is the creation time of e as stored, in microseconds.
+func created(e urlstore.URLEntry) int64 {
+	return e.CreationTimestamp.UnixMicro()
+}
+
+func decode(raw string) (urlstore.URLEntry, error) {
+	var e urlstore.URLEntry
+	if err := json.Unmarshal([]byte(raw), &e); err != nil {
+		return urlstore.URLEntry{}, fmt.Errorf("sqlite: decoding entry: %w", err)
+	}
+	return e, nil
+}
+
+// execer runs statements in or out of a transaction.
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// insert stores entry under key, failing with urlstore.ErrAlreadyExists
+// if the key is taken.
+func insert(ctx context.Context, db execer, key urlstore.UrlKey, entry urlstore.URLEntry) error {
+	entry.SchemaVersion = urlstore.CurrentSchemaVersion
+	raw, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	res, err := db.ExecContext(ctx,
+		`INSERT INTO url_entries (url_key, created, entry) VALUES (?, ?, ?) ON CONFLICT (url_key) DO NOTHING`,
+		string(key), created(entry), string(raw))
+	if err != nil {
+		return fmt.Errorf("sqlite: %w", err)
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return fmt.Errorf("sqlite: %w", err)
+	} else if n == 0 {
+		return urlstore.ErrAlreadyExists
+	}
+	return nil
+}
+
+// CreateEntry implements urlstore.Client.
+func (c *Client) CreateEntry(ctx context.Context, key urlstore.UrlKey, entry urlstore.URLEntry) error {
+	return insert(ctx, c.db, key, entry)
+}
+
+// CreateEntries implements urlstore.Client.
+func (c *Client) CreateEntries(ctx context.Context, entries []urlstore.KeyedEntry) error {
+	tx, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("sqlite: %w", err)
+	}
+	defer tx.Rollback()
+	for _, ke := range entries {
+		if err := insert(ctx, tx, ke.Key, ke.Entry); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("sqlite: %w", err)
+	}
+	return nil
+}
+
+// GetEntry implements urlstore.Client.
+func (c *Client) GetEntry(ctx context.Context, urlKey urlstore.UrlKey) (urlstore.URLEntry, error) {
+	var raw string
+	err := c.db.QueryRowContext(ctx, `SELECT entry FROM url_entries WHERE url_key = ?`, string(urlKey)).Scan(&raw)
+	if errors.Is(err, sql.ErrNoRows) {
+		return urlstore.URLEntry{}, urlstore.ErrNotFound
+	}
+	if err != nil {
+		return urlstore.URLEntry{}, fmt.Errorf("sqlite: %w", err)
+	}
+	e, err := decode(raw)
+	if err != nil {
+		return urlstore.URLEntry{}, err
+	}
+	if !e.Live(time.Now()) {
+		return urlstore.URLEntry{}, urlstore.ErrNotFound
+	}
+	return e, nil
+}
+
+// GetEntries implements urlstore.Client.
+func (c *Client) GetEntries(ctx context.Context, urlKeys []urlstore.UrlKey) (map[urlstore.UrlKey]urlstore.URLEntry, error) {
+	entries := make(map[urlstore.UrlKey]urlstore.URLEntry, len(urlKeys))
+	now := time.Now()
+	for len(urlKeys) > 0 {
+		batch := urlKeys[:min(len(urlKeys), maxBatch)]
+		urlKeys = urlKeys[len(batch):]
+		args := make([]any, len(batch))
+		for i, k := range batch {
+			args[i] = string(k)
+		}
+		rows, err := c.db.QueryContext(ctx,
+			`SELECT url_key, entry FROM url_entries WHERE url_key IN (?`+strings.Repeat(", ?", len(batch)-1)+`)`, args...)
+		if err != nil {
+			return nil, fmt.Errorf("sqlite: %w", err)
+		}
+		for rows.Next() {
+			var key, raw string
+			if err := rows.Scan(&key, &raw); err != nil {
+				rows.Close()
+				return nil, fmt.Errorf("sqlite: %w", err)
+			}
+			e, err := decode(raw)
+			if err != nil {
+				rows.Close()
+				return nil, err
+			}
+			if e.Live(now) {
+				entries[urlstore.UrlKey(key)] = e
+			}
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, fmt.Errorf("sqlite: %w", err)
+		}
+	}
+	return entries, nil
+}
+
+// UpdateEntry implements urlstore.Client. The transaction holds the write
+// lock from its start, so update runs once.
+func (c *Client) UpdateEntry(ctx context.Context, urlKey urlstore.UrlKey, update func(*urlstore.URLEntry) error) error {
+	tx, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("sqlite: %w", err)
+	}
+	defer tx.Rollback()
+	var raw string
+	err = tx.QueryRowContext(ctx, `SELECT entry FROM url_entries WHERE url_key = ?`, string(urlKey)).Scan(&raw)
+	if errors.Is(err, sql.ErrNoRows) {
+		return urlstore.ErrNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("sqlite: %w", err)
+	}
+	e, err := decode(raw)
+	if err != nil {
+		return err
+	}
+	if !e.Live(time.Now()) {
+		return urlstore.ErrNotFound
+	}
+	if e.SchemaVersion > urlstore.CurrentSchemaVersion {
+		return fmt.Errorf("%w: %d > %d", urlstore.ErrNewerSchema, e.SchemaVersion, urlstore.CurrentSchemaVersion)
+	}
+	urlstore.Upgrade(&e)
+	if err := update(&e); err != nil {
+		return err
+	}
+	e.SchemaVersion = urlstore.CurrentSchemaVersion
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE url_entries SET created = ?, entry = ? WHERE url_key = ?`,
+		created(e), string(b), string(urlKey)); err != nil {
+		return fmt.Errorf("sqlite: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("sqlite: %w", err)
+	}
+	return nil
+}
+
+// DeleteEntry implements urlstore.Client.
+func (c *Client) DeleteEntry(ctx context.Context, urlKey urlstore.UrlKey) error {
+	if _, err := c.db.ExecContext(ctx, `DELETE FROM url_entries WHERE url_key = ?`, string(urlKey)); err != nil {
+		return fmt.Errorf("sqlite: %w", err)
+	}
+	return nil
+}
+
+// cursor is the position of the last entry of a page.
+type cursor struct {
+	Key     string `json:"k"`
+	Created int64  `json:"c"`
+}
+
+// listQueries are the listing statements by order: from the start, and
+// after a cursor, given as its creation time, again, and key. Ties on
+// creation time are broken by key, as in urlstore.MemoryClient.
+var listQueries = map[urlstore.ListOrder][2]string{
+	urlstore.OrderByKey: {
+		`SELECT url_key, created, entry FROM url_entries ORDER BY url_key LIMIT ?`,
+		`SELECT url_key, created, entry FROM url_entries WHERE url_key > ?3 ORDER BY url_key LIMIT ?4`,
+	},
+	urlstore.OrderByCreatedAsc: {
+		`SELECT url_key, created, entry FROM url_entries ORDER BY created, url_key LIMIT ?`,
+		`SELECT url_key, created, entry FROM url_entries WHERE created > ?1 OR (created = ?2 AND url_key > ?3) ORDER BY created, url_key LIMIT ?4`,
+	},
+	urlstore.OrderByCreatedDesc: {
+		`SELECT url_key, created, entry FROM url_entries ORDER BY created DESC, url_key LIMIT ?`,
+		`SELECT url_key, created, entry FROM url_entries WHERE created < ?1 OR (created = ?2 AND url_key > ?3) ORDER BY created DESC, url_key LIMIT ?4`,
+	},
+}
+
+// ListEntries implements urlstore.Client. The cursor encodes the position
+// of the last entry scanned, so entries written between pages do not shift
+// them.
+func (c *Client) ListEntries(ctx context.Context, opts urlstore.ListOptions) (urlstore.ListPage, error) {
+	queries, ok := listQueries[opts.Order]
+	if !ok {
+		return urlstore.ListPage{}, fmt.Errorf("unknown list order %d", opts.Order)
+	}
+	limit := opts.PageSize()
+	// One more row tells whether there is a next page.
+	query, args := queries[0], []any{limit + 1}
+	if opts.Cursor != "" {
+		var after cursor
+		raw, err := base64.RawURLEncoding.DecodeString(opts.Cursor)
+		if err != nil || json.Unmarshal(raw, &after) != nil {
+			return urlstore.ListPage{}, urlstore.ErrInvalidCursor
+		}
+		query, args = queries[1], []any{after.Created, after.Created, after.Key, limit + 1}
+	}
+	rows, err := c.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return urlstore.ListPage{}, fmt.Errorf("sqlite: %w", err)
+	}
+	defer rows.Close()
+
+	now := time.Now()
+	var page urlstore.ListPage
+	var last cursor
+	for n := 0; rows.Next(); n++ {
+		if n == limit {
+			raw, err := json.Marshal(last)
+			if err != nil {
+				return urlstore.ListPage{}, err
+			}
+			page.NextCursor = base64.RawURLEncoding.EncodeToString(raw)
+			break
+		}
+		var raw string
+		if err := rows.Scan(&last.Key, &last.Created, &raw); err != nil {
+			return urlstore.ListPage{}, fmt.Errorf("sqlite: %w", err)
+		}
+		e, err := decode(raw)
+		if err != nil {
+			return urlstore.ListPage{}, err
+		}
+		if opts.Matches(urlstore.UrlKey(last.Key), e, now) {
+			page.Entries = append(page.Entries, urlstore.KeyedEntry{Key: urlstore.UrlKey(last.Key), Entry: e})
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return urlstore.ListPage{}, fmt.Errorf("sqlite: %w", err)
+	}
+	return page, nil
+}
+
+// CountEntries implements urlstore.Client. It decodes every entry, which
+// the small databases it is meant for afford.
+func (c *Client) CountEntries(ctx context.Context) (urlstore.StatusCounts, error) {
+	rows, err := c.db.QueryContext(ctx, `SELECT entry FROM url_entries`)
+	if err != nil {
+		return urlstore.StatusCounts{}, fmt.Errorf("sqlite: %w", err)
+	}
+	defer rows.Close()
+	now := time.Now()
+	var counts urlstore.StatusCounts
+	for rows.Next() {
+		var raw string
+		if err := rows.Scan(&raw); err != nil {
+			return urlstore.StatusCounts{}, fmt.Errorf("sqlite: %w", err)
+		}
+		e, err := decode(raw)
+		if err != nil {
+			return urlstore.StatusCounts{}, err
+		}
+		counts.Add(e.Status(now), 1)
+	}
+	if err := rows.Err(); err != nil {
+		return urlstore.StatusCounts{}, fmt.Errorf("sqlite: %w", err)
+	}
+	return counts, nil
+}
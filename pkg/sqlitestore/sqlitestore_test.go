@@ -0,0 +1,56 @@
+package sqlitestore
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/FlorinBalint/shortener/pkg/urlstore"
+	"github.com/FlorinBalint/shortener/pkg/urlstore/urlstoretest"
+)
+
+func open(t *testing.T, path string) *Client {
+	t.Helper()
+	c, err := Open(context.Background(), path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func TestClient_Contract(t *testing.T) {
+	urlstoretest.Run(t, func(t *testing.T) urlstore.Client {
+		return open(t, filepath.Join(t.TempDir(), "links.db"))
+	})
+}
+
+func TestClient_Reopen(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "links.db")
+	created := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	c := open(t, path)
+	if err := c.CreateEntry(ctx, "promo", urlstore.URLEntry{URLTarget: "https://example.com", CreationTimestamp: created}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	e, err := open(t, path).GetEntry(ctx, "promo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.URLTarget != "https://example.com" || !e.CreationTimestamp.Equal(created) || e.SchemaVersion != urlstore.CurrentSchemaVersion {
+		t.Errorf("want the entry kept across opens, got %+v", e)
+	}
+}
+
+func TestOpen_InvalidPath(t *testing.T) {
+	for _, path := range []string{"", "links.db?mode=ro"} {
+		if _, err := Open(context.Background(), path); err == nil {
+			t.Errorf("Open(%q): want an error", path)
+		}
+	}
+}
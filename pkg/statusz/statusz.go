@@ -0,0 +1,189 @@
+// Package statusz serves a JSON page describing a running server: its
+// build, start time, effective configuration (secrets redacted) and the
+// endpoints of its dependencies, so what a pod actually runs is one
+// request away.
+//
+// The page is meant for operators, not clients: serve it on a port the
+// load balancer does not route to.
+package statusz
+
+import (
+	"encoding"
+	"encoding/json"
+	"log"
+	"net/http"
+	"reflect"
+	"runtime"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+// Build identifies the running binary. Version and Commit are usually
+// injected at build time:
+//
+//	go build -ldflags "-X main.version=1.2.3 -X main.commit=abc123"
+type Build struct {
+	Version string `json:"version"`
+	Commit  string `json:"commit"`
+	Go      string `json:"go"`
+}
+
+// NewBuild returns the build with version and commit, falling back on the
+// VCS revision Go stamps into binaries built from a checkout.
+func NewBuild(version, commit string) Build {
+	b := Build{Version: version, Commit: commit, Go: runtime.Version()}
+	if b.Commit == "" || b.Commit == "none" {
+		if info, ok := debug.ReadBuildInfo(); ok {
+			for _, s := range info.Settings {
+				if s.Key == "vcs.revision" {
+					b.Commit = s.Value
+				}
+			}
+		}
+	}
+	return b
+}
+
+// Page is the status page of a server.
+type Page struct {
+	service string
+	build   Build
+	started time.Time
+
+	mu       sync.Mutex
+	sections map[string]any
+}
+
+// New returns the page of service, started now.
+func New(service string, build Build) *Page {
+	return &Page{
+		service:  service,
+		build:    build,
+		started:  time.Now(),
+		sections: make(map[string]any),
+	}
+}
+
+// Set adds a section to the page, e.g. "config" or "dependencies". v is
+// rendered as JSON on every request; pass configs through Redact.
+func (p *Page) Set(section string, v any) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.sections[section] = v
+}
+
+// SetFunc adds a section to the page rendered from what f returns on every
+// request, for values that change while the server runs, e.g. counters.
+func (p *Page) SetFunc(section string, f func() any) {
+	p.Set(section, sectionFunc(f))
+}
+
+// sectionFunc is a section set with SetFunc.
+type sectionFunc func() any
+
+func (f sectionFunc) MarshalJSON() ([]byte, error) {
+	return json.Marshal(f())
+}
+
+type page struct {
+	Service   string         `json:"service"`
+	Build     Build          `json:"build"`
+	StartedAt time.Time      `json:"started_at"`
+	Uptime    string         `json:"uptime"`
+	Sections  map[string]any `json:"sections,omitempty"`
+}
+
+// ServeHTTP serves the page as JSON.
+func (p *Page) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	p.mu.Lock()
+	b, err := json.MarshalIndent(page{
+		Service:   p.service,
+		Build:     p.build,
+		StartedAt: p.started.UTC(),
+		Uptime:    time.Since(p.started).Round(time.Second).String(),
+		Sections:  p.sections,
+	}, "", "  ")
+	p.mu.Unlock()
+	if err != nil {
+		http.Error(w, "failed to render status", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write(b)
+}
+
+// Serve serves the page on /statusz at addr in the background, unless
+// addr is empty or "off". Failures are logged: the page is not worth
+// failing the server for.
+func Serve(addr string, p *Page) {
+	if addr == "" || addr == "off" {
+		return
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/statusz", p)
+	go func() {
+		log.Printf("status page on %s/statusz", addr)
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			log.Printf("status page stopped: %v", err)
+		}
+	}()
+}
+
+// Redacted replaces the values of fields tagged `statusz:"redact"`.
+const Redacted = "[redacted]"
+
+// Redact returns cfg, a struct, as a map from field names to values for
+// rendering: nested structs become maps, durations strings, fields tagged
+// `statusz:"redact"` read Redacted when set and those tagged `statusz:"-"`
+// are left out, e.g. functions, which do not render.
+func Redact(cfg any) any {
+	return redact(reflect.ValueOf(cfg))
+}
+
+var (
+	durationType      = reflect.TypeFor[time.Duration]()
+	textMarshalerType = reflect.TypeFor[encoding.TextMarshaler]()
+)
+
+func redact(v reflect.Value) any {
+	if v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+	switch {
+	case v.Type() == durationType:
+		return time.Duration(v.Int()).String()
+	case v.Kind() == reflect.Struct && !v.Type().Implements(textMarshalerType):
+		m := make(map[string]any, v.NumField())
+		for i := range v.NumField() {
+			f := v.Type().Field(i)
+			if !f.IsExported() {
+				continue
+			}
+			fv := v.Field(i)
+			switch f.Tag.Get("statusz") {
+			case "-":
+				continue
+			case "redact":
+				if fv.IsZero() {
+					m[f.Name] = fv.Interface()
+				} else {
+					m[f.Name] = Redacted
+				}
+				continue
+			}
+			m[f.Name] = redact(fv)
+		}
+		return m
+	default:
+		return v.Interface()
+	}
+}
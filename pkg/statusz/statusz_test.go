@@ -0,0 +1,101 @@
+package statusz
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+type innerConfig struct {
+	Timeout time.Duration
+}
+
+type testConfig struct {
+	Project string
+	Token   string `statusz:"redact"`
+	Unset   string `statusz:"redact"`
+	Inner   innerConfig
+	Proxies []netip.Prefix
+	Hook    func() `statusz:"-"`
+	secret  string
+}
+
+func TestPage(t *testing.T) {
+	p := New("reader", Build{Version: "1.2.3", Commit: "abc123", Go: "go1.25"})
+	p.Set("config", Redact(testConfig{
+		Project: "prod",
+		Token:   "hunter2",
+		Inner:   innerConfig{Timeout: 5 * time.Second},
+		Proxies: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")},
+		Hook:    func() {},
+		secret:  "hidden",
+	}))
+	p.Set("dependencies", map[string]string{"keygen": "http://keygen:8083"})
+
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/statusz", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("want 200, got %d", rec.Code)
+	}
+	var got struct {
+		Service  string `json:"service"`
+		Build    Build  `json:"build"`
+		Sections struct {
+			Config       map[string]any    `json:"config"`
+			Dependencies map[string]string `json:"dependencies"`
+		} `json:"sections"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatalf("decoding %s: %v", rec.Body, err)
+	}
+	if got.Service != "reader" || got.Build.Commit != "abc123" {
+		t.Errorf("service %q build %+v", got.Service, got.Build)
+	}
+	cfg := got.Sections.Config
+	if cfg["Project"] != "prod" || cfg["Token"] != Redacted || cfg["Unset"] != "" {
+		t.Errorf("config = %v", cfg)
+	}
+	if _, ok := cfg["secret"]; ok {
+		t.Error("unexported field rendered")
+	}
+	if _, ok := cfg["Hook"]; ok {
+		t.Error("field tagged - rendered")
+	}
+	if inner, _ := cfg["Inner"].(map[string]any); inner["Timeout"] != "5s" {
+		t.Errorf("Inner = %v, want Timeout 5s", cfg["Inner"])
+	}
+	if proxies, _ := cfg["Proxies"].([]any); len(proxies) != 1 || proxies[0] != "10.0.0.0/8" {
+		t.Errorf("Proxies = %v", cfg["Proxies"])
+	}
+	if got.Sections.Dependencies["keygen"] != "http://keygen:8083" {
+		t.Errorf("dependencies = %v", got.Sections.Dependencies)
+	}
+}
+
+func TestPage_SetFunc(t *testing.T) {
+	p := New("reader", Build{})
+	n := 0
+	p.SetFunc("counter", func() any {
+		n++
+		return map[string]int{"calls": n}
+	})
+
+	for want := 1; want <= 2; want++ {
+		rec := httptest.NewRecorder()
+		p.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/statusz", nil))
+		var got struct {
+			Sections struct {
+				Counter map[string]int `json:"counter"`
+			} `json:"sections"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+			t.Fatalf("decoding %s: %v", rec.Body, err)
+		}
+		if got.Sections.Counter["calls"] != want {
+			t.Errorf("want the section rendered anew, calls %d, got %v", want, got.Sections.Counter)
+		}
+	}
+}
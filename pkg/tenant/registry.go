@@ -0,0 +1,172 @@
+package tenant
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// defaultReloadInterval bounds how long a replica serves tenant changes
+// made through another replica from its stale copy.
+const defaultReloadInterval = time.Minute
+
+// Registry looks tenants up by ID and domain.
+//
+// Each replica keeps every tenant in process and reloads them all once its
+// copy is older than a minute, so tenant changes reach other replicas
+// within that time. Tenant counts are expected to stay small.
+type Registry struct {
+	store Store
+
+	mu       sync.Mutex
+	byID     map[string]Tenant
+	byDomain map[string]Tenant
+	loadedAt time.Time
+	ttl      time.Duration
+	now      func() time.Time
+}
+
+// NewRegistry returns a registry over store.
+func NewRegistry(store Store) *Registry {
+	return &Registry{
+		store: store,
+		ttl:   defaultReloadInterval,
+		now:   time.Now,
+	}
+}
+
+// load refreshes the replica's copy when it is stale. A failed reload is
+// logged and the stale copy kept; it only fails when nothing was loaded yet.
+func (r *Registry) load(ctx context.Context) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.byID != nil && r.now().Sub(r.loadedAt) < r.ttl {
+		return nil
+	}
+	tenants, err := r.store.List(ctx)
+	if err != nil {
+		if r.byID != nil {
+			log.Printf("tenant: reload failed, serving stale tenants: %v", err)
+			return nil
+		}
+		return err
+	}
+	r.byID = make(map[string]Tenant, len(tenants))
+	r.byDomain = make(map[string]Tenant)
+	for _, t := range tenants {
+		r.byID[t.ID] = t
+		for _, d := range t.Domains {
+			r.byDomain[d] = t
+		}
+	}
+	r.loadedAt = r.now()
+	return nil
+}
+
+// invalidate makes the next lookup reload all tenants.
+func (r *Registry) invalidate() {
+	r.mu.Lock()
+	r.byID = nil
+	r.mu.Unlock()
+}
+
+// Get returns the tenant with id, or ErrNotFound.
+func (r *Registry) Get(ctx context.Context, id string) (Tenant, error) {
+	if err := r.load(ctx); err != nil {
+		return Tenant{}, err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	t, ok := r.byID[id]
+	if !ok {
+		return Tenant{}, ErrNotFound
+	}
+	return t, nil
+}
+
+// ForDomain returns the tenant served on host, which may carry a port, or
+// ErrNotFound.
+func (r *Registry) ForDomain(ctx context.Context, host string) (Tenant, error) {
+	if err := r.load(ctx); err != nil {
+		return Tenant{}, err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	t, ok := r.byDomain[canonicalHost(host)]
+	if !ok {
+		return Tenant{}, ErrNotFound
+	}
+	return t, nil
+}
+
+// List returns all tenants, read from the store.
+func (r *Registry) List(ctx context.Context) ([]Tenant, error) {
+	return r.store.List(ctx)
+}
+
+// Put creates or replaces a tenant and returns it as stored. The namespace
+// of an existing tenant cannot change, since its data lives there; domains
+// cannot be shared between tenants.
+func (r *Registry) Put(ctx context.Context, t Tenant) (Tenant, error) {
+	if err := t.normalize(); err != nil {
+		return Tenant{}, err
+	}
+	old, err := r.store.Get(ctx, t.ID)
+	switch err {
+	case nil:
+		if t.Namespace == "" {
+			t.Namespace = old.Namespace
+		}
+		if t.Namespace != old.Namespace {
+			return Tenant{}, fmt.Errorf("%w: namespace of tenant %s is %s and cannot change", ErrInvalid, t.ID, old.Namespace)
+		}
+		t.CreatedAt = old.CreatedAt
+	case ErrNotFound:
+		if t.Namespace == "" {
+			t.Namespace = "tenant-" + t.ID
+		}
+		t.CreatedAt = r.now().UTC()
+	default:
+		return Tenant{}, err
+	}
+	all, err := r.store.List(ctx)
+	if err != nil {
+		return Tenant{}, err
+	}
+	for _, other := range all {
+		if other.ID == t.ID {
+			continue
+		}
+		if other.Namespace == t.Namespace {
+			return Tenant{}, fmt.Errorf("%w: namespace %s is used by tenant %s", ErrInvalid, t.Namespace, other.ID)
+		}
+		for _, d := range other.Domains {
+			for _, mine := range t.Domains {
+				if d == mine {
+					return Tenant{}, fmt.Errorf("%w: domain %s is served for tenant %s", ErrInvalid, d, other.ID)
+				}
+			}
+		}
+	}
+	t.Version = old.Version + 1
+	if err := r.store.Put(ctx, t); err != nil {
+		return Tenant{}, err
+	}
+	r.invalidate()
+	return t, nil
+}
+
+// Delete removes the tenant with id. Its links stay in its namespace, so
+// recreating the tenant with the same namespace brings them back.
+func (r *Registry) Delete(ctx context.Context, id string) error {
+	if _, err := r.store.Get(ctx, id); err != nil {
+		return err
+	}
+	if err := r.store.Delete(ctx, id); err != nil {
+		return err
+	}
+	r.invalidate()
+	return nil
+}
@@ -0,0 +1,96 @@
+package tenant_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/FlorinBalint/shortener/pkg/tenant"
+)
+
+func TestPut(t *testing.T) {
+	ctx := context.Background()
+	r := tenant.NewRegistry(tenant.NewMemoryStore())
+
+	acme, err := r.Put(ctx, tenant.Tenant{ID: "acme", Domains: []string{"Go.Acme.Example."}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if acme.Namespace != "tenant-acme" || acme.Version != 1 || acme.CreatedAt.IsZero() {
+		t.Fatalf("unexpected tenant: %+v", acme)
+	}
+	if acme.Domains[0] != "go.acme.example" {
+		t.Errorf("domain not canonical: %q", acme.Domains[0])
+	}
+
+	updated, err := r.Put(ctx, tenant.Tenant{ID: "acme", Name: "Acme"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.Namespace != "tenant-acme" || updated.Version != 2 || !updated.CreatedAt.Equal(acme.CreatedAt) {
+		t.Fatalf("unexpected update: %+v", updated)
+	}
+
+	invalid := []tenant.Tenant{
+		{ID: "Bad ID"},
+		{ID: "acme", Namespace: "elsewhere"},
+		{ID: "other", Namespace: "tenant-acme"},
+		{ID: "other", Domains: []string{"https://x.example/"}},
+	}
+	for _, tt := range invalid {
+		if _, err := r.Put(ctx, tt); !errors.Is(err, tenant.ErrInvalid) {
+			t.Errorf("Put(%+v): want ErrInvalid, got %v", tt, err)
+		}
+	}
+}
+
+func TestForDomain(t *testing.T) {
+	ctx := context.Background()
+	r := tenant.NewRegistry(tenant.NewMemoryStore())
+	if _, err := r.Put(ctx, tenant.Tenant{ID: "acme", Domains: []string{"go.acme.example"}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Put(ctx, tenant.Tenant{ID: "other", Domains: []string{"go.acme.example"}}); !errors.Is(err, tenant.ErrInvalid) {
+		t.Fatalf("shared domain: want ErrInvalid, got %v", err)
+	}
+
+	for _, host := range []string{"go.acme.example", "GO.acme.example:8080"} {
+		got, err := r.ForDomain(ctx, host)
+		if err != nil || got.ID != "acme" {
+			t.Errorf("ForDomain(%q) = %+v, %v", host, got, err)
+		}
+	}
+	if _, err := r.ForDomain(ctx, "unknown.example"); !errors.Is(err, tenant.ErrNotFound) {
+		t.Errorf("unknown host: want ErrNotFound, got %v", err)
+	}
+
+	if err := r.Delete(ctx, "acme"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.ForDomain(ctx, "go.acme.example"); !errors.Is(err, tenant.ErrNotFound) {
+		t.Errorf("deleted tenant: want ErrNotFound, got %v", err)
+	}
+	if err := r.Delete(ctx, "acme"); !errors.Is(err, tenant.ErrNotFound) {
+		t.Errorf("second delete: want ErrNotFound, got %v", err)
+	}
+}
+
+func TestScopesRebuildOnChange(t *testing.T) {
+	ctx := context.Background()
+	r := tenant.NewRegistry(tenant.NewMemoryStore())
+	builds := 0
+	scopes := tenant.NewScopes(func(t tenant.Tenant) string {
+		builds++
+		return t.Name
+	})
+
+	acme, _ := r.Put(ctx, tenant.Tenant{ID: "acme", Name: "v1"})
+	scopes.Get(acme)
+	if got := scopes.Get(acme); got != "v1" || builds != 1 {
+		t.Fatalf("Get = %q after %d builds, want v1 after 1", got, builds)
+	}
+	acme, _ = r.Put(ctx, tenant.Tenant{ID: "acme", Name: "v2"})
+	if got := scopes.Get(acme); got != "v2" || builds != 2 {
+		t.Fatalf("Get = %q after %d builds, want v2 after 2", got, builds)
+	}
+}
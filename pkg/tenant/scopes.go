@@ -0,0 +1,36 @@
+package tenant
+
+import "sync"
+
+// Scopes holds a value per tenant, such as handlers bound to the tenant's
+// stores, built on first use and rebuilt once the tenant changes.
+type Scopes[T any] struct {
+	build func(Tenant) T
+
+	mu     sync.Mutex
+	scopes map[string]scope[T]
+}
+
+type scope[T any] struct {
+	tenant Tenant
+	value  T
+}
+
+// NewScopes returns Scopes building values with build.
+func NewScopes[T any](build func(Tenant) T) *Scopes[T] {
+	return &Scopes[T]{build: build, scopes: make(map[string]scope[T])}
+}
+
+// Get returns the value of t.
+func (s *Scopes[T]) Get(t Tenant) T {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sc, ok := s.scopes[t.ID]
+	// A recreated tenant starts over at version 1.
+	if ok && sc.tenant.Version == t.Version && sc.tenant.CreatedAt.Equal(t.CreatedAt) {
+		return sc.value
+	}
+	sc = scope[T]{tenant: t, value: s.build(t)}
+	s.scopes[t.ID] = sc
+	return sc.value
+}
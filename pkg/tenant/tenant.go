@@ -0,0 +1,206 @@
+// Package tenant manages the tenants of a shared deployment. Each tenant
+// keeps its links in its own Datastore namespace and memcache key space,
+// is served on its own domains and has its own quota defaults and reserved
+// aliases.
+package tenant
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/datastore"
+
+	"github.com/FlorinBalint/shortener/pkg/gcputil"
+	"github.com/FlorinBalint/shortener/pkg/quota"
+)
+
+// Errors returned by stores and the Registry.
+var (
+	ErrNotFound = errors.New("tenant not found")
+	ErrInvalid  = errors.New("invalid tenant")
+)
+
+// Tenant is the stored configuration of a tenant.
+type Tenant struct {
+	ID   string `json:"id"`
+	Name string `json:"name,omitempty"`
+	// Namespace is the Datastore namespace of the tenant's data, by
+	// default "tenant-<id>". It is fixed when the tenant is created.
+	Namespace string `json:"namespace"`
+	// Domains the reader serves the tenant's links on.
+	Domains []string `json:"domains,omitempty"`
+	// Quota is the default quota of the tenant's API keys; zero limits
+	// fall back to the writer's defaults.
+	Quota quota.Limits `json:"quota,omitzero"`
+	// ReservedAliases cannot be created in the tenant, on top of the
+	// aliases reserved everywhere.
+	ReservedAliases []string `json:"reserved_aliases,omitempty"`
+	// Version is bumped on every change, so per-tenant state is rebuilt.
+	Version   int64     `json:"version"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+var idRe = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,31}$`)
+
+// CacheKeyPrefix prefixes the tenant's memcache keys. The colons keep them
+// apart from untenanted URL keys, which cannot contain one.
+func (t Tenant) CacheKeyPrefix() string {
+	return "t:" + t.ID + ":"
+}
+
+// Reserved reports whether alias is reserved in the tenant.
+func (t Tenant) Reserved(alias string) bool {
+	for _, r := range t.ReservedAliases {
+		if strings.EqualFold(r, alias) {
+			return true
+		}
+	}
+	return false
+}
+
+// canonicalHost lowercases host and strips its port and trailing dot.
+func canonicalHost(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(host)), ".")
+}
+
+// normalize validates t and puts its domains in canonical form.
+func (t *Tenant) normalize() error {
+	if !idRe.MatchString(t.ID) {
+		return fmt.Errorf("%w: id must match %s", ErrInvalid, idRe)
+	}
+	for i, d := range t.Domains {
+		if strings.ContainsAny(d, "/:") {
+			return fmt.Errorf("%w: domain %q", ErrInvalid, d)
+		}
+		if t.Domains[i] = canonicalHost(d); t.Domains[i] == "" || strings.Contains(t.Domains[i], " ") {
+			return fmt.Errorf("%w: domain %q", ErrInvalid, d)
+		}
+	}
+	if t.Quota.MaxLinks < 0 || t.Quota.MaxDailyWrites < 0 {
+		return fmt.Errorf("%w: quota limits cannot be negative", ErrInvalid)
+	}
+	return nil
+}
+
+// Store persists tenants by ID.
+type Store interface {
+	// Get returns the tenant with id, or ErrNotFound.
+	Get(ctx context.Context, id string) (Tenant, error)
+	// Put creates or replaces a tenant.
+	Put(ctx context.Context, t Tenant) error
+	// Delete removes the tenant with id; deleting a missing one is not an error.
+	Delete(ctx context.Context, id string) error
+	// List returns all tenants ordered by ID.
+	List(ctx context.Context) ([]Tenant, error)
+}
+
+// DSStore stores tenants in Datastore, under kind "tenant" of the client's
+// own namespace.
+type DSStore struct {
+	client *gcputil.DSClient
+}
+
+var _ Store = (*DSStore)(nil)
+
+func NewDSStore(client *gcputil.DSClient) *DSStore {
+	return &DSStore{client: client}
+}
+
+// Get implements Store.
+func (s *DSStore) Get(ctx context.Context, id string) (Tenant, error) {
+	t, err := gcputil.GetValue[Tenant](s.client, ctx, "tenant", id)
+	if errors.Is(err, datastore.ErrNoSuchEntity) {
+		return Tenant{}, ErrNotFound
+	}
+	return t, err
+}
+
+// Put implements Store.
+func (s *DSStore) Put(ctx context.Context, t Tenant) error {
+	return s.client.PutJSON(ctx, "tenant", t.ID, t)
+}
+
+// Delete implements Store.
+func (s *DSStore) Delete(ctx context.Context, id string) error {
+	return s.client.Delete(ctx, "tenant", id)
+}
+
+// List implements Store. Tenant counts are expected to stay small.
+func (s *DSStore) List(ctx context.Context) ([]Tenant, error) {
+	var out []Tenant
+	q := gcputil.ListQuery{Limit: 500}
+	for {
+		values, next, err := gcputil.ListValues[Tenant](s.client, ctx, "tenant", q)
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range values {
+			out = append(out, v.Value)
+		}
+		if next == "" {
+			return out, nil
+		}
+		q.Cursor = next
+	}
+}
+
+// MemoryStore is an in-process Store, for tests and local development.
+type MemoryStore struct {
+	mu      sync.Mutex
+	tenants map[string]Tenant
+}
+
+var _ Store = (*MemoryStore)(nil)
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{tenants: make(map[string]Tenant)}
+}
+
+// Get implements Store.
+func (s *MemoryStore) Get(ctx context.Context, id string) (Tenant, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.tenants[id]
+	if !ok {
+		return Tenant{}, ErrNotFound
+	}
+	return t, nil
+}
+
+// Put implements Store.
+func (s *MemoryStore) Put(ctx context.Context, t Tenant) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tenants[t.ID] = t
+	return nil
+}
+
+// Delete implements Store.
+func (s *MemoryStore) Delete(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.tenants, id)
+	return nil
+}
+
+// List implements Store.
+func (s *MemoryStore) List(ctx context.Context) ([]Tenant, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]Tenant, 0, len(s.tenants))
+	for _, t := range s.tenants {
+		out = append(out, t)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out, nil
+}
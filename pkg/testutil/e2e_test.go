@@ -0,0 +1,158 @@
+package testutil_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/FlorinBalint/shortener/pkg/reader"
+	"github.com/FlorinBalint/shortener/pkg/testutil"
+	"github.com/FlorinBalint/shortener/pkg/writer"
+)
+
+type stack struct {
+	harness *testutil.Harness
+	writer  *httptest.Server
+	reader  *httptest.Server
+	client  *http.Client
+}
+
+func newStack(t *testing.T) *stack {
+	h := testutil.New(t)
+	w := httptest.NewServer(writer.NewHandlerWithStore(writer.Config{KeygenBase: h.Keygen.URL}, h.Store))
+	t.Cleanup(w.Close)
+	r := httptest.NewServer(reader.NewHandlerWithStore(reader.Config{}, h.CachedStore()))
+	t.Cleanup(r.Close)
+	return &stack{
+		harness: h,
+		writer:  w,
+		reader:  r,
+		client: &http.Client{
+			CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+		},
+	}
+}
+
+func (s *stack) write(t *testing.T, body map[string]string) (int, map[string]string) {
+	t.Helper()
+	b, _ := json.Marshal(body)
+	resp, err := s.client.Post(s.writer.URL+"/write/v1", "application/json", bytes.NewReader(b))
+	if err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	defer resp.Body.Close()
+	out := map[string]string{}
+	if resp.StatusCode == http.StatusOK {
+		if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+			t.Fatalf("decode write response: %v", err)
+		}
+	} else {
+		io.Copy(io.Discard, resp.Body)
+	}
+	return resp.StatusCode, out
+}
+
+func (s *stack) resolve(t *testing.T, key string) (int, string) {
+	t.Helper()
+	resp, err := s.client.Get(s.reader.URL + "/" + key)
+	if err != nil {
+		t.Fatalf("resolve: %v", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	return resp.StatusCode, resp.Header.Get("Location")
+}
+
+func TestE2E_GeneratedKeyRedirects(t *testing.T) {
+	s := newStack(t)
+	target := "https://example.com/landing"
+
+	code, out := s.write(t, map[string]string{"url_target": target})
+	if code != http.StatusOK {
+		t.Fatalf("write status: want 200, got %d", code)
+	}
+	if out["url_key"] == "" {
+		t.Fatalf("write returned empty url_key")
+	}
+
+	code, loc := s.resolve(t, out["url_key"])
+	if code != http.StatusFound || loc != target {
+		t.Fatalf("resolve: want 302 %q, got %d %q", target, code, loc)
+	}
+}
+
+func TestE2E_CustomAliasRedirects(t *testing.T) {
+	s := newStack(t)
+	target := "https://example.com/docs"
+
+	code, out := s.write(t, map[string]string{"url_target": target, "url_key": " /docs"})
+	if code != http.StatusOK {
+		t.Fatalf("write status: want 200, got %d", code)
+	}
+	if out["url_key"] != "docs" {
+		t.Fatalf("url_key: want normalized %q, got %q", "docs", out["url_key"])
+	}
+
+	code, loc := s.resolve(t, "docs")
+	if code != http.StatusFound || loc != target {
+		t.Fatalf("resolve: want 302 %q, got %d %q", target, code, loc)
+	}
+}
+
+func TestE2E_CustomAliasConflict(t *testing.T) {
+	s := newStack(t)
+
+	if code, _ := s.write(t, map[string]string{"url_target": "https://a.example", "url_key": "promo"}); code != http.StatusOK {
+		t.Fatalf("first write: want 200, got %d", code)
+	}
+	if code, _ := s.write(t, map[string]string{"url_target": "https://b.example", "url_key": "promo"}); code != http.StatusConflict {
+		t.Fatalf("second write: want 409, got %d", code)
+	}
+	if code, loc := s.resolve(t, "promo"); loc != "https://a.example" {
+		t.Fatalf("resolve: want original target, got %d %q", code, loc)
+	}
+}
+
+func TestE2E_RejectsInvalidWrites(t *testing.T) {
+	s := newStack(t)
+
+	tests := []struct {
+		name string
+		body map[string]string
+	}{
+		{"missing target", map[string]string{"url_key": "abc"}},
+		{"reserved alias", map[string]string{"url_target": "https://a.example", "url_key": "health"}},
+		{"reserved prefix", map[string]string{"url_target": "https://a.example", "url_key": "static/app.js"}},
+		{"invalid characters", map[string]string{"url_target": "https://a.example", "url_key": "a b"}},
+	}
+	for _, tt := range tests {
+		if code, _ := s.write(t, tt.body); code != http.StatusBadRequest {
+			t.Fatalf("%s: want 400, got %d", tt.name, code)
+		}
+	}
+}
+
+func TestE2E_UnknownKeyNotFound(t *testing.T) {
+	s := newStack(t)
+	if code, _ := s.resolve(t, "does-not-exist"); code != http.StatusNotFound {
+		t.Fatalf("want 404, got %d", code)
+	}
+}
+
+func TestE2E_RepeatedReadsServedFromCache(t *testing.T) {
+	s := newStack(t)
+	_, out := s.write(t, map[string]string{"url_target": "https://example.com/hot"})
+
+	for i := 0; i < 3; i++ {
+		if code, _ := s.resolve(t, out["url_key"]); code != http.StatusFound {
+			t.Fatalf("resolve %d: want 302, got %d", i, code)
+		}
+	}
+	if s.harness.Cache.Misses != 1 || s.harness.Cache.Hits != 2 {
+		t.Fatalf("cache: want 1 miss and 2 hits, got %d misses and %d hits",
+			s.harness.Cache.Misses, s.harness.Cache.Hits)
+	}
+}
@@ -0,0 +1,110 @@
+package testutil
+
+import (
+	"sync"
+	"time"
+
+	"github.com/google/gomemcache/memcache"
+
+	"github.com/FlorinBalint/shortener/pkg/urlstore"
+)
+
+// maxRelativeExpiration mirrors memcached: larger expirations are unix timestamps.
+const maxRelativeExpiration = 30 * 24 * 60 * 60
+
+type fakeItem struct {
+	value     []byte
+	expiresAt time.Time
+}
+
+// FakeCache is an in-memory substitute for memcached.
+// It honors item expirations using Now.
+type FakeCache struct {
+	mu    sync.Mutex
+	items map[string]fakeItem
+
+	// Now returns the current time; tests may replace it to control expiry.
+	Now func() time.Time
+
+	// Hits and Misses count Get outcomes.
+	Hits   int
+	Misses int
+}
+
+var _ urlstore.Cache = (*FakeCache)(nil)
+
+func NewFakeCache() *FakeCache {
+	return &FakeCache{
+		items: make(map[string]fakeItem),
+		Now:   time.Now,
+	}
+}
+
+// Get implements urlstore.Cache.
+func (c *FakeCache) Get(key string) (*memcache.Item, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	it, ok := c.items[key]
+	if ok && !it.expiresAt.IsZero() && !c.Now().Before(it.expiresAt) {
+		delete(c.items, key)
+		ok = false
+	}
+	if !ok {
+		c.Misses++
+		return nil, memcache.ErrCacheMiss
+	}
+	c.Hits++
+	return &memcache.Item{Key: key, Value: append([]byte(nil), it.value...)}, nil
+}
+
+// GetMulti implements urlstore.Cache. Each key counts as a Get.
+func (c *FakeCache) GetMulti(keys []string) (map[string]*memcache.Item, error) {
+	items := make(map[string]*memcache.Item, len(keys))
+	for _, key := range keys {
+		if it, err := c.Get(key); err == nil {
+			items[key] = it
+		}
+	}
+	return items, nil
+}
+
+// Set implements urlstore.Cache.
+func (c *FakeCache) Set(item *memcache.Item) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.set(item)
+	return nil
+}
+
+// Add stores item only if its key is not already present, like memcached's add.
+func (c *FakeCache) Add(item *memcache.Item) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if it, ok := c.items[item.Key]; ok && (it.expiresAt.IsZero() || c.Now().Before(it.expiresAt)) {
+		return memcache.ErrNotStored
+	}
+	c.set(item)
+	return nil
+}
+
+func (c *FakeCache) set(item *memcache.Item) {
+	it := fakeItem{value: append([]byte(nil), item.Value...)}
+	switch {
+	case item.Expiration > maxRelativeExpiration:
+		it.expiresAt = time.Unix(int64(item.Expiration), 0)
+	case item.Expiration > 0:
+		it.expiresAt = c.Now().Add(time.Duration(item.Expiration) * time.Second)
+	}
+	c.items[item.Key] = it
+}
+
+// Delete implements urlstore.Cache.
+func (c *FakeCache) Delete(key string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.items[key]; !ok {
+		return memcache.ErrCacheMiss
+	}
+	delete(c.items, key)
+	return nil
+}
@@ -0,0 +1,99 @@
+// Package testutil wires in-memory (or emulator-backed) dependencies for
+// integration and end-to-end tests of the shortener services.
+package testutil
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/FlorinBalint/shortener/pkg/gcputil"
+	"github.com/FlorinBalint/shortener/pkg/kubeflake"
+	"github.com/FlorinBalint/shortener/pkg/urlstore"
+)
+
+// Harness bundles the dependencies shared by the reader and the writer.
+type Harness struct {
+	// Store is the backing store, shared by every service under test.
+	Store urlstore.Client
+	// Cache is an in-memory memcached substitute.
+	Cache *FakeCache
+	// Keygen serves GET /generate/v1 backed by a real Kubeflake.
+	Keygen *httptest.Server
+}
+
+// New builds a Harness whose resources are released when the test ends.
+func New(t testing.TB) *Harness {
+	t.Helper()
+	return &Harness{
+		Store:  NewStore(t),
+		Cache:  NewFakeCache(),
+		Keygen: NewKeygenServer(t),
+	}
+}
+
+// CachedStore returns Store wrapped with a cache-aside layer on Cache.
+func (h *Harness) CachedStore() urlstore.Client {
+	return urlstore.WithCacheAside(h.Store, h.Cache)
+}
+
+// NewStore returns a store for tests. When DATASTORE_EMULATOR_HOST is set,
+// it connects to the emulator using a namespace unique to the test;
+// otherwise it returns an in-memory store.
+func NewStore(t testing.TB) urlstore.Client {
+	t.Helper()
+	if os.Getenv("DATASTORE_EMULATOR_HOST") == "" {
+		return urlstore.NewMemoryClient()
+	}
+
+	namespace := fmt.Sprintf("test-%d", time.Now().UnixNano())
+	ds, err := gcputil.NewDSClient(context.Background(), "shortener-test", "", namespace)
+	if err != nil {
+		t.Fatalf("datastore emulator: %v", err)
+	}
+	store := urlstore.NewClient(ds)
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+// NewKeygen returns a Kubeflake with a fixed cluster and machine ID.
+func NewKeygen(t testing.TB) *kubeflake.Kubeflake {
+	t.Helper()
+	kf, err := kubeflake.New(kubeflake.Settings{
+		BitsCluster:  7,
+		BitsMachine:  6,
+		BitsSequence: 11,
+		ClusterId:    func() (int, error) { return 1, nil },
+		MachineId:    func() (int, error) { return 1, nil },
+	})
+	if err != nil {
+		t.Fatalf("kubeflake: %v", err)
+	}
+	return kf
+}
+
+// NewKeygenServer starts an httptest server mimicking the keygen service.
+func NewKeygenServer(t testing.TB) *httptest.Server {
+	t.Helper()
+	kf := NewKeygen(t)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	mux.HandleFunc("/generate/v1", func(w http.ResponseWriter, r *http.Request) {
+		key, err := kf.NextKey()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write([]byte(key))
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
@@ -0,0 +1,136 @@
+package tlsutil
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type pki struct {
+	dir    string
+	caCert *x509.Certificate
+	caKey  *ecdsa.PrivateKey
+}
+
+func newPKI(t *testing.T) *pki {
+	t.Helper()
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	p := &pki{dir: t.TempDir(), caCert: cert, caKey: key}
+	p.write(t, "ca.pem", "CERTIFICATE", der)
+	return p
+}
+
+func (p *pki) write(t *testing.T, name, typ string, der []byte) string {
+	t.Helper()
+	path := filepath.Join(p.dir, name)
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// issue returns files for a leaf certificate signed by the CA.
+func (p *pki) issue(t *testing.T, name string, usage x509.ExtKeyUsage) Files {
+	t.Helper()
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{"keygen.test"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, p.caCert, &key.PublicKey, p.caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+	return Files{
+		CertFile: p.write(t, name+".pem", "CERTIFICATE", der),
+		KeyFile:  p.write(t, name+"-key.pem", "EC PRIVATE KEY", keyDER),
+		CAFile:   filepath.Join(p.dir, "ca.pem"),
+	}
+}
+
+func TestMutualTLS(t *testing.T) {
+	p := newPKI(t)
+	serverCfg, err := ServerConfig(p.issue(t, "server", x509.ExtKeyUsageServerAuth))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {})
+	mux.Handle("/generate/v1", RequireClientCert(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("key"))
+	})))
+	srv := httptest.NewUnstartedServer(mux)
+	srv.TLS = serverCfg
+	srv.StartTLS()
+	defer srv.Close()
+
+	clientCfg, err := ClientConfig(p.issue(t, "client", x509.ExtKeyUsageClientAuth), "keygen.test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	withCert := &http.Client{Transport: &http.Transport{TLSClientConfig: clientCfg}}
+	resp, err := withCert.Get(srv.URL + "/generate/v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("with client cert: want 200, got %d", resp.StatusCode)
+	}
+
+	// A client trusting the server but presenting no certificate can probe
+	// health but not generate keys.
+	noCert := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+		RootCAs:    clientCfg.RootCAs,
+		ServerName: "keygen.test",
+	}}}
+	for path, want := range map[string]int{"/health": http.StatusOK, "/generate/v1": http.StatusForbidden} {
+		resp, err := noCert.Get(srv.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != want {
+			t.Fatalf("%s without client cert: want %d, got %d", path, want, resp.StatusCode)
+		}
+	}
+}
+
+func TestIncompleteFiles(t *testing.T) {
+	if _, err := ClientConfig(Files{CertFile: "cert.pem"}, ""); err == nil {
+		t.Fatal("want error for missing key and CA")
+	}
+}
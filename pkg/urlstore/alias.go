@@ -0,0 +1,34 @@
+package urlstore
+
+import (
+	"context"
+	"errors"
+)
+
+// MaxAliasDepth bounds the aliases followed to reach a canonical entry.
+const MaxAliasDepth = 4
+
+// ErrAliasChain is returned for aliases that loop, or chain through more
+// than MaxAliasDepth aliases.
+var ErrAliasChain = errors.New("alias chain loops or is too long")
+
+// ResolveAlias follows entry, stored under key, through the entries it is
+// an alias of, and returns the canonical entry with its key; entries that
+// are no alias are returned as they are. An alias of a missing or expired
+// entry fails with ErrNotFound.
+func ResolveAlias(ctx context.Context, c Client, key UrlKey, entry URLEntry) (UrlKey, URLEntry, error) {
+	seen := map[UrlKey]bool{key: true}
+	for depth := 0; entry.AliasOf != ""; depth++ {
+		next := UrlKey(entry.AliasOf)
+		if depth == MaxAliasDepth || seen[next] {
+			return key, URLEntry{}, ErrAliasChain
+		}
+		seen[next] = true
+		e, err := c.GetEntry(ctx, next)
+		if err != nil {
+			return next, URLEntry{}, err
+		}
+		key, entry = next, e
+	}
+	return key, entry, nil
+}
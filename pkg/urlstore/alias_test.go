@@ -0,0 +1,66 @@
+package urlstore_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/FlorinBalint/shortener/pkg/urlstore"
+)
+
+func TestResolveAlias(t *testing.T) {
+	ctx := context.Background()
+	c := urlstore.NewMemoryClient()
+	entries := map[urlstore.UrlKey]urlstore.URLEntry{
+		"target":   {URLTarget: "https://example.com"},
+		"a1":       {AliasOf: "target"},
+		"a2":       {AliasOf: "a1"},
+		"loop-1":   {AliasOf: "loop-2"},
+		"loop-2":   {AliasOf: "loop-1"},
+		"dangling": {AliasOf: "missing"},
+	}
+	for k, e := range entries {
+		if err := c.CreateEntry(ctx, k, e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Chains of MaxAliasDepth aliases and one more.
+	var chain []urlstore.UrlKey
+	prev := urlstore.UrlKey("target")
+	for i := range urlstore.MaxAliasDepth + 1 {
+		k := urlstore.UrlKey(fmt.Sprintf("deep-%d", i))
+		if err := c.CreateEntry(ctx, k, urlstore.URLEntry{AliasOf: string(prev)}); err != nil {
+			t.Fatal(err)
+		}
+		chain, prev = append(chain, k), k
+	}
+
+	tests := []struct {
+		key     urlstore.UrlKey
+		want    urlstore.UrlKey
+		wantErr error
+	}{
+		{"target", "target", nil},
+		{"a1", "target", nil},
+		{"a2", "target", nil},
+		{"loop-1", "", urlstore.ErrAliasChain},
+		{"dangling", "", urlstore.ErrNotFound},
+		{chain[urlstore.MaxAliasDepth-1], "target", nil},
+		{chain[urlstore.MaxAliasDepth], "", urlstore.ErrAliasChain},
+	}
+	for _, tt := range tests {
+		e, err := c.GetEntry(ctx, tt.key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		key, e, err := urlstore.ResolveAlias(ctx, c, tt.key, e)
+		if !errors.Is(err, tt.wantErr) {
+			t.Errorf("%s: want error %v, got %v", tt.key, tt.wantErr, err)
+			continue
+		}
+		if err == nil && (key != tt.want || e.URLTarget != "https://example.com") {
+			t.Errorf("%s: want %s, got %s %+v", tt.key, tt.want, key, e)
+		}
+	}
+}
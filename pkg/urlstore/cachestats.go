@@ -0,0 +1,63 @@
+package urlstore
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// CacheStats describes a cache layer since the process started, for the
+// status page: tuning its TTLs takes knowing how each layer does.
+//
+// NegativeHits are hits on cached misses (ErrNotFound) and StaleHits on
+// entries past their TTL, served while refreshed; both are among Hits.
+// Entries and NegativeEntries are the entries held in process, misses
+// included, expired ones until they are dropped; Evictions counts those
+// dropped to make room and Rejected the results not cached as the cache
+// was full. Errors counts failed lookups in the cache and MeanLatencyMs
+// their mean duration, for remote caches.
+type CacheStats struct {
+	Hits            uint64  `json:"hits"`
+	Misses          uint64  `json:"misses"`
+	HitRate         float64 `json:"hit_rate"`
+	NegativeHits    uint64  `json:"negative_hits,omitempty"`
+	StaleHits       uint64  `json:"stale_hits,omitempty"`
+	Entries         int     `json:"entries,omitempty"`
+	NegativeEntries int     `json:"negative_entries,omitempty"`
+	Evictions       uint64  `json:"evictions,omitempty"`
+	Rejected        uint64  `json:"rejected,omitempty"`
+	Errors          uint64  `json:"errors,omitempty"`
+	MeanLatencyMs   float64 `json:"mean_latency_ms,omitempty"`
+}
+
+// cacheCounters counts the lookups of a cache layer.
+type cacheCounters struct {
+	hits, misses, errors atomic.Uint64
+	// calls and nanos time the calls to a remote cache.
+	calls, nanos atomic.Uint64
+}
+
+func (c *cacheCounters) lookup(hit bool) {
+	if hit {
+		c.hits.Add(1)
+	} else {
+		c.misses.Add(1)
+	}
+}
+
+// timed records a call to a remote cache started at start.
+func (c *cacheCounters) timed(start time.Time) {
+	c.calls.Add(1)
+	c.nanos.Add(uint64(time.Since(start)))
+}
+
+// stats returns the counts, with the hit rate and mean latency.
+func (c *cacheCounters) stats() CacheStats {
+	s := CacheStats{Hits: c.hits.Load(), Misses: c.misses.Load(), Errors: c.errors.Load()}
+	if n := s.Hits + s.Misses; n > 0 {
+		s.HitRate = float64(s.Hits) / float64(n)
+	}
+	if calls := c.calls.Load(); calls > 0 {
+		s.MeanLatencyMs = float64(c.nanos.Load()) / float64(calls) / float64(time.Millisecond)
+	}
+	return s
+}
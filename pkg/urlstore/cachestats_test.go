@@ -0,0 +1,96 @@
+package urlstore
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/gomemcache/memcache"
+)
+
+// mapCache is a Cache in a map, failing every call with err when set.
+type mapCache struct {
+	items map[string]*memcache.Item
+	err   error
+}
+
+func (c *mapCache) Get(key string) (*memcache.Item, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+	it, ok := c.items[key]
+	if !ok {
+		return nil, memcache.ErrCacheMiss
+	}
+	return it, nil
+}
+
+func (c *mapCache) GetMulti(keys []string) (map[string]*memcache.Item, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+	out := make(map[string]*memcache.Item)
+	for _, k := range keys {
+		if it, ok := c.items[k]; ok {
+			out[k] = it
+		}
+	}
+	return out, nil
+}
+
+func (c *mapCache) Set(item *memcache.Item) error {
+	c.items[item.Key] = item
+	return nil
+}
+
+func (c *mapCache) Delete(key string) error {
+	delete(c.items, key)
+	return nil
+}
+
+func TestMicroCachedClient_CacheStats(t *testing.T) {
+	ctx := context.Background()
+	under := NewMemoryClient()
+	if err := under.CreateEntry(ctx, "hot", URLEntry{URLTarget: "https://example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	c := WithMicroCache(under, time.Second)
+	c.SetMaxStale(time.Second)
+	now := time.Now()
+	c.now = func() time.Time { return now }
+
+	for range 2 {
+		c.GetEntry(ctx, "hot")
+		c.GetEntry(ctx, "missing")
+	}
+	now = now.Add(1500 * time.Millisecond)
+	c.GetEntry(ctx, "hot")
+
+	got := c.CacheStats()
+	want := CacheStats{Hits: 3, Misses: 2, HitRate: 0.6, NegativeHits: 1, StaleHits: 1, Entries: 2, NegativeEntries: 1}
+	if got != want {
+		t.Errorf("want %+v, got %+v", want, got)
+	}
+}
+
+func TestCachedClient_CacheStats(t *testing.T) {
+	ctx := context.Background()
+	cache := &mapCache{items: make(map[string]*memcache.Item)}
+	c := WithCacheAside(NewMemoryClient(), cache)
+	if err := c.underlying.CreateEntry(ctx, "hot", URLEntry{URLTarget: "https://example.com"}); err != nil {
+		t.Fatal(err)
+	}
+
+	for range 2 {
+		c.GetEntry(ctx, "hot")
+	}
+	c.GetEntries(ctx, []UrlKey{"hot", "missing"})
+	cache.err = errors.New("connection refused")
+	c.GetEntry(ctx, "hot")
+
+	got := c.CacheStats()
+	if got.Hits != 2 || got.Misses != 2 || got.HitRate != 0.5 || got.Errors != 1 {
+		t.Errorf("want 2 hits, 2 misses and 1 error, got %+v", got)
+	}
+}
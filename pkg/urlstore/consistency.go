@@ -0,0 +1,30 @@
+package urlstore
+
+import (
+	"context"
+	"time"
+)
+
+// MaxReadStaleness is the most stale a read may be asked to be, as
+// Firestore serves read times up to an hour old.
+const MaxReadStaleness = time.Hour
+
+// readStalenessKey carries the staleness of WithReadStaleness.
+type readStalenessKey struct{}
+
+// WithReadStaleness returns ctx under which GetEntry and GetEntries may
+// return entries as they were up to d ago, capped at MaxReadStaleness, to
+// trade freshness for latency and cost where the store supports it: the
+// DSClient then reads at a time in the past, which the nearest replica
+// can serve. Other stores, and d <= 0, read strongly. Writes, including
+// those of UpdateEntry, always see the latest entries.
+func WithReadStaleness(ctx context.Context, d time.Duration) context.Context {
+	return context.WithValue(ctx, readStalenessKey{}, min(d, MaxReadStaleness))
+}
+
+// ReadStaleness returns the staleness reads under ctx accept; 0 asks for
+// strongly consistent reads.
+func ReadStaleness(ctx context.Context) time.Duration {
+	d, _ := ctx.Value(readStalenessKey{}).(time.Duration)
+	return max(d, 0)
+}
@@ -0,0 +1,26 @@
+package urlstore
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestReadStaleness(t *testing.T) {
+	ctx := context.Background()
+	for _, tt := range []struct {
+		d, want time.Duration
+	}{
+		{15 * time.Second, 15 * time.Second},
+		{0, 0},
+		{-time.Second, 0},
+		{2 * time.Hour, MaxReadStaleness},
+	} {
+		if got := ReadStaleness(WithReadStaleness(ctx, tt.d)); got != tt.want {
+			t.Errorf("%v: want %v, got %v", tt.d, tt.want, got)
+		}
+	}
+	if got := ReadStaleness(ctx); got != 0 {
+		t.Errorf("want strong reads by default, got %v", got)
+	}
+}
@@ -0,0 +1,57 @@
+//go:build datastore
+
+// Run with a Datastore emulator:
+//
+//	gcloud beta emulators datastore start --no-store-on-disk &
+//	$(gcloud beta emulators datastore env-init)
+//	go test -tags datastore ./pkg/urlstore/...
+package urlstore_test
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/FlorinBalint/shortener/pkg/testutil"
+	"github.com/FlorinBalint/shortener/pkg/urlstore"
+	"github.com/FlorinBalint/shortener/pkg/urlstore/urlstoretest"
+)
+
+func requireEmulator(t *testing.T) {
+	t.Helper()
+	if os.Getenv("DATASTORE_EMULATOR_HOST") == "" {
+		t.Skip("DATASTORE_EMULATOR_HOST is not set")
+	}
+}
+
+func TestDSClient_Contract(t *testing.T) {
+	requireEmulator(t)
+	urlstoretest.Run(t, func(t *testing.T) urlstore.Client {
+		return testutil.NewStore(t)
+	})
+}
+
+func TestCachedDSClient_Contract(t *testing.T) {
+	requireEmulator(t)
+	urlstoretest.Run(t, func(t *testing.T) urlstore.Client {
+		return urlstore.WithCacheAside(testutil.NewStore(t), testutil.NewFakeCache())
+	})
+}
+
+func TestDSClient_StaleReads(t *testing.T) {
+	requireEmulator(t)
+	store := testutil.NewStore(t)
+	ctx := urlstore.WithReadStaleness(context.Background(), 10*time.Second)
+	if err := store.CreateEntry(ctx, "fresh", urlstore.URLEntry{URLTarget: "https://example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	// The link is newer than the read time; misses are read again strongly.
+	if e, err := store.GetEntry(ctx, "fresh"); err != nil || e.URLTarget != "https://example.com" {
+		t.Fatalf("GetEntry: %+v, %v", e, err)
+	}
+	found, err := store.GetEntries(ctx, []urlstore.UrlKey{"fresh", "missing"})
+	if err != nil || len(found) != 1 || found["fresh"].URLTarget != "https://example.com" {
+		t.Fatalf("GetEntries: %v, %v", found, err)
+	}
+}
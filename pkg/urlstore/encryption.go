@@ -0,0 +1,182 @@
+package urlstore
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// sealedPrefix marks encrypted targets. Targets stored before encryption
+// was enabled lack it and are served as they are.
+const sealedPrefix = "enc:v1:"
+
+// TargetCipher encrypts targets; *envelope.Cipher satisfies it.
+type TargetCipher interface {
+	Seal(plaintext, aad []byte) []byte
+	Open(sealed, aad []byte) ([]byte, error)
+}
+
+// EncryptedClient encrypts entry targets before they reach the underlying
+// client, and decrypts them on the way back.
+type EncryptedClient struct {
+	underlying Client
+	cipher     TargetCipher
+}
+
+var _ Client = (*EncryptedClient)(nil)
+
+// WithEncryption wraps underlying so that targets are stored encrypted with
+// cipher, bound to their key. Wrap the cache-aside layer to keep cached
+// targets encrypted as well. Listings by target and target host keep
+// working through the entry's TargetIndex.
+func WithEncryption(underlying Client, cipher TargetCipher) *EncryptedClient {
+	return &EncryptedClient{underlying: underlying, cipher: cipher}
+}
+
+// Close implements Client.
+func (c *EncryptedClient) Close() error {
+	return c.underlying.Close()
+}
+
+// CreateEntry implements Client.
+func (c *EncryptedClient) CreateEntry(ctx context.Context, key UrlKey, entry URLEntry) error {
+	c.seal(key, &entry)
+	return c.underlying.CreateEntry(ctx, key, entry)
+}
+
+// CreateEntries implements Client.
+func (c *EncryptedClient) CreateEntries(ctx context.Context, entries []KeyedEntry) error {
+	sealed := make([]KeyedEntry, len(entries))
+	for i, ke := range entries {
+		c.seal(ke.Key, &ke.Entry)
+		sealed[i] = ke
+	}
+	return c.underlying.CreateEntries(ctx, sealed)
+}
+
+// GetEntry implements Client.
+func (c *EncryptedClient) GetEntry(ctx context.Context, key UrlKey) (URLEntry, error) {
+	entry, err := c.underlying.GetEntry(ctx, key)
+	if err != nil {
+		return URLEntry{}, err
+	}
+	if err := c.open(key, &entry); err != nil {
+		return URLEntry{}, err
+	}
+	return entry, nil
+}
+
+// GetEntries implements Client.
+func (c *EncryptedClient) GetEntries(ctx context.Context, keys []UrlKey) (map[UrlKey]URLEntry, error) {
+	entries, err := c.underlying.GetEntries(ctx, keys)
+	if err != nil {
+		return nil, err
+	}
+	for key, entry := range entries {
+		if err := c.open(key, &entry); err != nil {
+			return nil, err
+		}
+		entries[key] = entry
+	}
+	return entries, nil
+}
+
+// UpdateEntry implements Client. update sees the decrypted entry.
+func (c *EncryptedClient) UpdateEntry(ctx context.Context, key UrlKey, update func(*URLEntry) error) error {
+	return c.underlying.UpdateEntry(ctx, key, func(e *URLEntry) error {
+		if err := c.open(key, e); err != nil {
+			return err
+		}
+		if err := update(e); err != nil {
+			return err
+		}
+		c.seal(key, e)
+		return nil
+	})
+}
+
+// DeleteEntry implements Client.
+func (c *EncryptedClient) DeleteEntry(ctx context.Context, key UrlKey) error {
+	return c.underlying.DeleteEntry(ctx, key)
+}
+
+// ListEntries implements Client.
+func (c *EncryptedClient) ListEntries(ctx context.Context, opts ListOptions) (ListPage, error) {
+	page, err := c.underlying.ListEntries(ctx, opts)
+	if err != nil {
+		return ListPage{}, err
+	}
+	for i := range page.Entries {
+		if err := c.open(page.Entries[i].Key, &page.Entries[i].Entry); err != nil {
+			return ListPage{}, err
+		}
+	}
+	return page, nil
+}
+
+// CountEntries implements Client.
+func (c *EncryptedClient) CountEntries(ctx context.Context) (StatusCounts, error) {
+	return c.underlying.CountEntries(ctx)
+}
+
+// seal encrypts e's targets, scheduled ones included, and indexes the
+// default one for listings. Aliases have no target of their own.
+func (c *EncryptedClient) seal(key UrlKey, e *URLEntry) {
+	if e.AliasOf != "" {
+		e.TargetIndex = nil
+		return
+	}
+	e.TargetIndex = &TargetIndex{Host: e.TargetHost(), Hash: targetHash(e.URLTarget)}
+	e.URLTarget = c.sealTarget(key, e.URLTarget)
+	if e.Schedule != nil {
+		// The caller keeps its slice, with the plain targets.
+		e.Schedule = slices.Clone(e.Schedule)
+		for i := range e.Schedule {
+			e.Schedule[i].URLTarget = c.sealTarget(key, e.Schedule[i].URLTarget)
+		}
+	}
+}
+
+func (c *EncryptedClient) sealTarget(key UrlKey, target string) string {
+	sealed := c.cipher.Seal([]byte(target), []byte(key))
+	return sealedPrefix + base64.RawURLEncoding.EncodeToString(sealed)
+}
+
+// open decrypts e's targets, if encrypted. Cached entries carry the target
+// alone, without the index.
+func (c *EncryptedClient) open(key UrlKey, e *URLEntry) error {
+	e.TargetIndex = nil
+	var err error
+	if e.URLTarget, err = c.openTarget(key, e.URLTarget); err != nil {
+		return err
+	}
+	if e.Schedule == nil {
+		return nil
+	}
+	// The underlying client may share the slice with its own copy.
+	e.Schedule = slices.Clone(e.Schedule)
+	for i := range e.Schedule {
+		if e.Schedule[i].URLTarget, err = c.openTarget(key, e.Schedule[i].URLTarget); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *EncryptedClient) openTarget(key UrlKey, target string) (string, error) {
+	encoded, ok := strings.CutPrefix(target, sealedPrefix)
+	if !ok {
+		return target, nil
+	}
+	sealed, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", fmt.Errorf("decrypting target of %q: %w", key, err)
+	}
+	opened, err := c.cipher.Open(sealed, []byte(key))
+	if err != nil {
+		return "", fmt.Errorf("decrypting target of %q: %w", key, err)
+	}
+	return string(opened), nil
+}
@@ -0,0 +1,123 @@
+package urlstore_test
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/FlorinBalint/shortener/pkg/envelope"
+	"github.com/FlorinBalint/shortener/pkg/testutil"
+	"github.com/FlorinBalint/shortener/pkg/urlstore"
+	"github.com/FlorinBalint/shortener/pkg/urlstore/urlstoretest"
+)
+
+func testCipher(t *testing.T) *envelope.Cipher {
+	t.Helper()
+	c, err := envelope.NewCipher(bytes.Repeat([]byte{1}, envelope.DataKeySize))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestEncryptedClient_Contract(t *testing.T) {
+	urlstoretest.Run(t, func(t *testing.T) urlstore.Client {
+		return urlstore.WithEncryption(urlstore.NewMemoryClient(), testCipher(t))
+	})
+}
+
+func TestEncryptedCachedClient_Contract(t *testing.T) {
+	urlstoretest.Run(t, func(t *testing.T) urlstore.Client {
+		return urlstore.WithEncryption(urlstore.WithCacheAside(urlstore.NewMemoryClient(), testutil.NewFakeCache()), testCipher(t))
+	})
+}
+
+func TestEncryptedClient_StoresCiphertext(t *testing.T) {
+	ctx := context.Background()
+	const target = "https://bucket.example/report.pdf?X-Goog-Signature=s3cr3t"
+	mem := urlstore.NewMemoryClient()
+	cache := testutil.NewFakeCache()
+	c := urlstore.WithEncryption(urlstore.WithCacheAside(mem, cache), testCipher(t))
+
+	if err := c.CreateEntry(ctx, "report", urlstore.URLEntry{URLTarget: target}); err != nil {
+		t.Fatal(err)
+	}
+	stored, err := mem.GetEntry(ctx, "report")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(stored.URLTarget, "s3cr3t") || stored.TargetIndex == nil || stored.TargetIndex.Host != "bucket.example" {
+		t.Fatalf("stored entry is not sealed: %+v", stored)
+	}
+	item, err := cache.Get("v1:report")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(item.Value), "s3cr3t") {
+		t.Fatalf("cached target in the clear: %q", item.Value)
+	}
+
+	got, err := c.GetEntry(ctx, "report")
+	if err != nil || got.URLTarget != target || got.TargetIndex != nil {
+		t.Fatalf("GetEntry = %+v, %v", got, err)
+	}
+
+	// A sealed target moved to another key does not open.
+	mem.Seed("moved", stored)
+	if _, err := c.GetEntry(ctx, "moved"); err == nil {
+		t.Fatal("want an error for a target sealed for another key")
+	}
+}
+
+func TestEncryptedClient_ReadsPlaintextEntries(t *testing.T) {
+	ctx := context.Background()
+	mem := urlstore.NewMemoryClient()
+	if err := mem.CreateEntry(ctx, "old", urlstore.URLEntry{URLTarget: "https://example.com/old"}); err != nil {
+		t.Fatal(err)
+	}
+	c := urlstore.WithEncryption(mem, testCipher(t))
+	got, err := c.GetEntry(ctx, "old")
+	if err != nil || got.URLTarget != "https://example.com/old" {
+		t.Fatalf("GetEntry = %+v, %v", got, err)
+	}
+
+	// Rewriting the entry encrypts it.
+	if err := c.UpdateEntry(ctx, "old", func(*urlstore.URLEntry) error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	stored, _ := mem.GetEntry(ctx, "old")
+	if stored.TargetIndex == nil || strings.Contains(stored.URLTarget, "example.com") {
+		t.Fatalf("rewritten entry is not sealed: %+v", stored)
+	}
+}
+
+func TestEncryptedClient_SealsSchedule(t *testing.T) {
+	ctx := context.Background()
+	mem := urlstore.NewMemoryClient()
+	c := urlstore.WithEncryption(mem, testCipher(t))
+	schedule := []urlstore.ScheduledTarget{{URLTarget: "https://stream.example/live?token=s3cr3t", Until: time.Now().Add(time.Hour)}}
+
+	if err := c.CreateEntry(ctx, "event", urlstore.URLEntry{URLTarget: "https://example.com/recording", Schedule: schedule}); err != nil {
+		t.Fatal(err)
+	}
+	if schedule[0].URLTarget != "https://stream.example/live?token=s3cr3t" {
+		t.Fatalf("caller's schedule was sealed in place: %+v", schedule)
+	}
+	stored, err := mem.GetEntry(ctx, "event")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(stored.Schedule[0].URLTarget, "s3cr3t") {
+		t.Fatalf("stored schedule is not sealed: %+v", stored.Schedule)
+	}
+
+	got, err := c.GetEntry(ctx, "event")
+	if err != nil || got.TargetAt(time.Now()) != schedule[0].URLTarget {
+		t.Fatalf("GetEntry = %+v, %v", got, err)
+	}
+	if again, _ := mem.GetEntry(ctx, "event"); again.Schedule[0].URLTarget != stored.Schedule[0].URLTarget {
+		t.Fatalf("stored schedule was opened in place: %+v", again.Schedule)
+	}
+}
@@ -0,0 +1,204 @@
+package urlstore
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math/rand/v2"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrInjectedFault is returned by operations failed on purpose by FaultyClient.
+var ErrInjectedFault = errors.New("injected store fault")
+
+// maxHang bounds injected timeouts for operations whose context has no deadline.
+const maxHang = time.Minute
+
+// FaultConfig configures the faults injected by WithFaults.
+//
+// Latency is added to every operation, plus a random extra of up to Jitter.
+// ErrorRate is the fraction of operations (0..1) failing with ErrInjectedFault.
+// TimeoutRate is the fraction of operations (0..1) that hang until their
+// context is done, as a brownout would.
+type FaultConfig struct {
+	Latency     time.Duration
+	Jitter      time.Duration
+	ErrorRate   float64
+	TimeoutRate float64
+}
+
+// Enabled reports whether the config injects any fault.
+func (fc FaultConfig) Enabled() bool {
+	return fc.Latency > 0 || fc.Jitter > 0 || fc.ErrorRate > 0 || fc.TimeoutRate > 0
+}
+
+// FaultConfigFromEnv reads STORE_FAULT_LATENCY, STORE_FAULT_JITTER (durations),
+// STORE_FAULT_ERROR_RATE and STORE_FAULT_TIMEOUT_RATE (fractions).
+// Faults are never enabled when ENVIRONMENT is "prod" or "production".
+// Malformed values are logged and ignored.
+func FaultConfigFromEnv() FaultConfig {
+	var fc FaultConfig
+	fc.Latency = envDuration("STORE_FAULT_LATENCY")
+	fc.Jitter = envDuration("STORE_FAULT_JITTER")
+	fc.ErrorRate = envRate("STORE_FAULT_ERROR_RATE")
+	fc.TimeoutRate = envRate("STORE_FAULT_TIMEOUT_RATE")
+	if !fc.Enabled() {
+		return fc
+	}
+	switch strings.ToLower(os.Getenv("ENVIRONMENT")) {
+	case "prod", "production":
+		log.Printf("store fault injection requested but ignored in production")
+		return FaultConfig{}
+	}
+	return fc
+}
+
+func envDuration(k string) time.Duration {
+	v := os.Getenv(k)
+	if v == "" {
+		return 0
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		log.Printf("ignoring invalid %s=%q", k, v)
+		return 0
+	}
+	return d
+}
+
+func envRate(k string) float64 {
+	v := os.Getenv(k)
+	if v == "" {
+		return 0
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil || f < 0 || f > 1 {
+		log.Printf("ignoring invalid %s=%q", k, v)
+		return 0
+	}
+	return f
+}
+
+// FaultyClient decorates a Client with injected latency, errors and timeouts.
+type FaultyClient struct {
+	underlying Client
+	cfg        FaultConfig
+	// random returns a float in [0, 1); replaced in tests.
+	random func() float64
+}
+
+var _ Client = (*FaultyClient)(nil)
+
+// WithFaults wraps c so its operations suffer the faults described by cfg.
+// It is meant for rehearsing Datastore brownouts outside production.
+func WithFaults(c Client, cfg FaultConfig) *FaultyClient {
+	return &FaultyClient{
+		underlying: c,
+		cfg:        cfg,
+		random:     rand.Float64,
+	}
+}
+
+// inject applies the configured faults before an operation runs.
+func (c *FaultyClient) inject(ctx context.Context) error {
+	delay := c.cfg.Latency
+	if c.cfg.Jitter > 0 {
+		delay += time.Duration(c.random() * float64(c.cfg.Jitter))
+	}
+	if delay > 0 {
+		t := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+
+	if c.cfg.TimeoutRate > 0 && c.random() < c.cfg.TimeoutRate {
+		t := time.NewTimer(maxHang)
+		defer t.Stop()
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-t.C:
+			return context.DeadlineExceeded
+		}
+	}
+	if c.cfg.ErrorRate > 0 && c.random() < c.cfg.ErrorRate {
+		return ErrInjectedFault
+	}
+	return nil
+}
+
+// Close implements Client. It is never faulted.
+func (c *FaultyClient) Close() error {
+	return c.underlying.Close()
+}
+
+// CreateEntry implements Client.
+func (c *FaultyClient) CreateEntry(ctx context.Context, key UrlKey, entry URLEntry) error {
+	if err := c.inject(ctx); err != nil {
+		return err
+	}
+	return c.underlying.CreateEntry(ctx, key, entry)
+}
+
+// CreateEntries implements Client.
+func (c *FaultyClient) CreateEntries(ctx context.Context, entries []KeyedEntry) error {
+	if err := c.inject(ctx); err != nil {
+		return err
+	}
+	return c.underlying.CreateEntries(ctx, entries)
+}
+
+// GetEntry implements Client.
+func (c *FaultyClient) GetEntry(ctx context.Context, urlKey UrlKey) (URLEntry, error) {
+	if err := c.inject(ctx); err != nil {
+		return URLEntry{}, err
+	}
+	return c.underlying.GetEntry(ctx, urlKey)
+}
+
+// GetEntries implements Client.
+func (c *FaultyClient) GetEntries(ctx context.Context, urlKeys []UrlKey) (map[UrlKey]URLEntry, error) {
+	if err := c.inject(ctx); err != nil {
+		return nil, err
+	}
+	return c.underlying.GetEntries(ctx, urlKeys)
+}
+
+// UpdateEntry implements Client.
+func (c *FaultyClient) UpdateEntry(ctx context.Context, urlKey UrlKey, update func(*URLEntry) error) error {
+	if err := c.inject(ctx); err != nil {
+		return err
+	}
+	return c.underlying.UpdateEntry(ctx, urlKey, update)
+}
+
+// DeleteEntry implements Client.
+func (c *FaultyClient) DeleteEntry(ctx context.Context, urlKey UrlKey) error {
+	if err := c.inject(ctx); err != nil {
+		return err
+	}
+	return c.underlying.DeleteEntry(ctx, urlKey)
+}
+
+// ListEntries implements Client.
+func (c *FaultyClient) ListEntries(ctx context.Context, opts ListOptions) (ListPage, error) {
+	if err := c.inject(ctx); err != nil {
+		return ListPage{}, err
+	}
+	return c.underlying.ListEntries(ctx, opts)
+}
+
+// CountEntries implements Client.
+func (c *FaultyClient) CountEntries(ctx context.Context) (StatusCounts, error) {
+	if err := c.inject(ctx); err != nil {
+		return StatusCounts{}, err
+	}
+	return c.underlying.CountEntries(ctx)
+}
@@ -0,0 +1,78 @@
+package urlstore
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestWithFaults_NoFaultsPassesThrough(t *testing.T) {
+	c := WithFaults(NewMemoryClient(), FaultConfig{})
+	ctx := context.Background()
+	if err := c.CreateEntry(ctx, "k", URLEntry{URLTarget: "https://example.com"}); err != nil {
+		t.Fatalf("CreateEntry: %v", err)
+	}
+	if _, err := c.GetEntry(ctx, "k"); err != nil {
+		t.Fatalf("GetEntry: %v", err)
+	}
+}
+
+func TestWithFaults_ErrorRate(t *testing.T) {
+	c := WithFaults(NewMemoryClient(), FaultConfig{ErrorRate: 0.5})
+	rolls := []float64{0.4, 0.6}
+	c.random = func() float64 {
+		r := rolls[0]
+		rolls = rolls[1:]
+		return r
+	}
+
+	if _, err := c.GetEntry(context.Background(), "k"); !errors.Is(err, ErrInjectedFault) {
+		t.Fatalf("roll below rate: want ErrInjectedFault, got %v", err)
+	}
+	if _, err := c.GetEntry(context.Background(), "k"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("roll above rate: want underlying ErrNotFound, got %v", err)
+	}
+}
+
+func TestWithFaults_TimeoutHonorsContext(t *testing.T) {
+	c := WithFaults(NewMemoryClient(), FaultConfig{TimeoutRate: 1})
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	err := c.CreateEntry(ctx, "k", URLEntry{URLTarget: "https://example.com"})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("want DeadlineExceeded, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("timeout fault ignored the context deadline: took %v", elapsed)
+	}
+}
+
+func TestWithFaults_Latency(t *testing.T) {
+	c := WithFaults(NewMemoryClient(), FaultConfig{Latency: 30 * time.Millisecond})
+	start := time.Now()
+	c.GetEntry(context.Background(), "k")
+	if elapsed := time.Since(start); elapsed < 30*time.Millisecond {
+		t.Fatalf("want at least 30ms of latency, got %v", elapsed)
+	}
+}
+
+func TestFaultConfigFromEnv(t *testing.T) {
+	t.Setenv("STORE_FAULT_LATENCY", "50ms")
+	t.Setenv("STORE_FAULT_ERROR_RATE", "0.1")
+	t.Setenv("STORE_FAULT_TIMEOUT_RATE", "2") // invalid, ignored
+
+	t.Setenv("ENVIRONMENT", "staging")
+	got := FaultConfigFromEnv()
+	want := FaultConfig{Latency: 50 * time.Millisecond, ErrorRate: 0.1}
+	if got != want {
+		t.Fatalf("staging: want %+v, got %+v", want, got)
+	}
+
+	t.Setenv("ENVIRONMENT", "production")
+	if got := FaultConfigFromEnv(); got.Enabled() {
+		t.Fatalf("production: want faults disabled, got %+v", got)
+	}
+}
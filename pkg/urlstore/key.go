@@ -0,0 +1,127 @@
+package urlstore
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// MaxKeyLength bounds whole keys, wildcards included: the writer refuses
+// longer ones and the reader does not look them up.
+const MaxKeyLength = 128
+
+// The errors name the key url_key, as the write API does, since they are
+// returned to its clients as they are.
+var (
+	// Allow path-like slugs: letters, digits, underscore, dash, and slash. 1..128 chars.
+	keyPathRe = regexp.MustCompile(`^[A-Za-z0-9/_-]{1,128}$`)
+
+	// Reserved exact keys (case-insensitive)
+	reservedExact = map[string]struct{}{
+		"health":      {},
+		"ready":       {},
+		"write":       {},
+		"preview":     {},
+		"index.html":  {},
+		"favicon.ico": {},
+		"robots.txt":  {},
+		"sitemap.xml": {},
+	}
+
+	// Reserved prefixes (case-insensitive); blocks "static/*"
+	reservedPrefixes = []string{
+		"write/",
+		"health/",
+		"ready/",
+		"preview/",
+		"static/",
+		".well-known/",
+	}
+
+	errKeyReserved = errors.New("url_key is reserved")
+)
+
+// NormalizeKey returns key as clients may send it, e.g. " /foo/bar", as
+// it is stored: trimmed, without its leading slash.
+func NormalizeKey(key string) string {
+	key = strings.TrimSpace(key)
+	// Accept clients sending "/foo/bar" by stripping a single leading slash.
+	return strings.TrimPrefix(key, "/")
+}
+
+// ParseUrlKey normalizes and validates a key sent by a client.
+func ParseUrlKey(s string) (UrlKey, error) {
+	k := UrlKey(NormalizeKey(s))
+	if err := k.Validate(); err != nil {
+		return "", err
+	}
+	return k, nil
+}
+
+// IsWildcard reports whether k, e.g. "docs/*", serves every path under its
+// prefix.
+func (k UrlKey) IsWildcard() bool {
+	return strings.HasSuffix(string(k), "/*")
+}
+
+// ReservedBy returns the reserved key, e.g. "health", or prefix, e.g.
+// "static/", that k hits, compared case-insensitively; "" if none.
+func (k UrlKey) ReservedBy() string {
+	ls := strings.ToLower(string(k))
+	for _, p := range reservedPrefixes {
+		if strings.HasPrefix(ls, p) {
+			return p
+		}
+	}
+	if _, ok := reservedExact[ls]; ok {
+		return ls
+	}
+	return ""
+}
+
+// Validate reports why k cannot be created: it must be a path of up to
+// MaxKeyLength safe characters the reader serves as is, outside the paths
+// the services reserve.
+func (k UrlKey) Validate() error {
+	s := string(k)
+	if s == "" {
+		return fmt.Errorf("url_key cannot be empty")
+	}
+	if len(s) > MaxKeyLength {
+		return fmt.Errorf("url_key is longer than %d characters", MaxKeyLength)
+	}
+	// The reader drops empty segments, so such a key could not be reached.
+	if strings.HasPrefix(s, "/") || strings.HasSuffix(s, "/") || strings.Contains(s, "//") {
+		return fmt.Errorf("url_key cannot have empty path segments")
+	}
+
+	// Wildcard keys ("docs/*") serve every path under their prefix.
+	if prefix, ok := strings.CutSuffix(s, "/*"); ok && prefix != "" {
+		if k.ReservedBy() != "" {
+			return errKeyReserved
+		}
+		return UrlKey(prefix).Validate()
+	}
+	if k.ReservedBy() != "" {
+		return errKeyReserved
+	}
+
+	// Disallow path traversal segments
+	if strings.Contains(s, "/./") || strings.Contains(s, "/../") || strings.HasPrefix(s, "../") || strings.HasSuffix(s, "/..") {
+		return fmt.Errorf("url_key contains invalid path segments")
+	}
+
+	// The reader decodes paths before lookup, so "a%2Fb" could only be
+	// reached as "a/b".
+	if strings.Contains(s, "%") {
+		return fmt.Errorf("url_key cannot contain percent-encodings; send the characters themselves")
+	}
+
+	// Only allow safe characters
+	if !keyPathRe.MatchString(s) {
+		return fmt.Errorf("url_key must match %s", keyPathRe.String())
+	}
+
+	return nil
+}
@@ -0,0 +1,84 @@
+package urlstore
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestUrlKey_Validate(t *testing.T) {
+	for _, k := range []UrlKey{"promo", "sale/spring-2024", "docs/*", "a_b/C-9", UrlKey(strings.Repeat("a", 128)), UrlKey(strings.Repeat("a", 126) + "/*")} {
+		if err := k.Validate(); err != nil {
+			t.Errorf("%q: want valid, got %v", k, err)
+		}
+	}
+	for _, k := range []UrlKey{
+		"", "/promo", "promo/", "a//b", "docs//*", "//*", "/*", "*",
+		UrlKey(strings.Repeat("a", 129)), UrlKey(strings.Repeat("a", 127) + "/*"),
+		"a%2Fb", "a.b", "../a", "a/../b", "a b", "ünï",
+		"write", "Write/x", "static/*", "HEALTH",
+	} {
+		if err := k.Validate(); err == nil {
+			t.Errorf("%q: want invalid", k)
+		}
+	}
+	if err := UrlKey("a%2Fb").Validate(); err == nil || !strings.Contains(err.Error(), "percent-encodings") {
+		t.Errorf("a%%2Fb: want percent-encodings refused, got %v", err)
+	}
+}
+
+func TestUrlKey_ReservedBy(t *testing.T) {
+	for k, want := range map[UrlKey]string{
+		"HEALTH":    "health",
+		"Write/x":   "write/",
+		"static/*":  "static/",
+		"preview/*": "preview/",
+		"ready/*":   "ready/",
+		"promo":     "",
+		"healthy":   "",
+		"docs/*":    "",
+	} {
+		if got := k.ReservedBy(); got != want {
+			t.Errorf("%q: want %q, got %q", k, want, got)
+		}
+	}
+}
+
+func TestParseUrlKey(t *testing.T) {
+	if k, err := ParseUrlKey(" /docs/* "); err != nil || k != "docs/*" || !k.IsWildcard() {
+		t.Errorf(`ParseUrlKey(" /docs/* ") = %q, %v`, k, err)
+	}
+	if k, err := ParseUrlKey("//promo"); err == nil {
+		t.Errorf(`ParseUrlKey("//promo") = %q, want an error`, k)
+	}
+	if UrlKey("docs").IsWildcard() || UrlKey("*").IsWildcard() {
+		t.Error("want only keys ending in /* to be wildcards")
+	}
+}
+
+// FuzzParseUrlKey checks that every key accepted is served by the reader
+// as is: short, without empty segments, and spelled the same in a URL
+// path.
+func FuzzParseUrlKey(f *testing.F) {
+	for _, s := range []string{"promo", "/promo", " //a ", "docs/*", "a//b", "a/", "a%2Fb", "a/../b", "static/x", "Ready", strings.Repeat("ab/", 50)} {
+		f.Add(s)
+	}
+	f.Fuzz(func(t *testing.T, s string) {
+		k, err := ParseUrlKey(s)
+		if err != nil {
+			return
+		}
+		if len(k) > MaxKeyLength {
+			t.Fatalf("%q: accepted %d characters", k, len(k))
+		}
+		if NormalizeKey(string(k)) != string(k) {
+			t.Fatalf("%q: not normalized", k)
+		}
+		prefix, _ := strings.CutSuffix(string(k), "/*")
+		for _, seg := range strings.Split(prefix, "/") {
+			if seg == "" || seg == "." || seg == ".." || strings.Contains(seg, "*") || url.PathEscape(seg) != seg {
+				t.Fatalf("%q: accepted segment %q", k, seg)
+			}
+		}
+	})
+}
@@ -0,0 +1,136 @@
+package urlstore
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/FlorinBalint/shortener/pkg/requestid"
+)
+
+// DefaultSlowThreshold is how long an operation takes before LoggedClient
+// logs it, unless set otherwise.
+const DefaultSlowThreshold = 500 * time.Millisecond
+
+// LoggedClient logs the operations of the Client it wraps that take longer
+// than a threshold, with their key and the ID of the request they serve
+// (see pkg/requestid), as "slow store:" lines. Slow calls then show up
+// before they breach timeouts.
+type LoggedClient struct {
+	underlying Client
+	logger     *log.Logger
+	threshold  time.Duration
+}
+
+var _ Client = (*LoggedClient)(nil)
+
+// WithLogging wraps underlying so its slow operations are logged to logger
+// (the standard logger if nil). Operations are slow after
+// DefaultSlowThreshold, see SetThreshold.
+func WithLogging(underlying Client, logger *log.Logger) *LoggedClient {
+	if logger == nil {
+		logger = log.Default()
+	}
+	return &LoggedClient{underlying: underlying, logger: logger, threshold: DefaultSlowThreshold}
+}
+
+// SetThreshold logs operations taking longer than d.
+func (c *LoggedClient) SetThreshold(d time.Duration) {
+	c.threshold = d
+}
+
+// Close implements Client.
+func (c *LoggedClient) Close() error {
+	return c.underlying.Close()
+}
+
+// CreateEntry implements Client.
+func (c *LoggedClient) CreateEntry(ctx context.Context, key UrlKey, entry URLEntry) error {
+	start := time.Now()
+	err := c.underlying.CreateEntry(ctx, key, entry)
+	c.logSlow(ctx, "create", key, start, err)
+	return err
+}
+
+// CreateEntries implements Client. Its lines name the keys joined by
+// commas.
+func (c *LoggedClient) CreateEntries(ctx context.Context, entries []KeyedEntry) error {
+	start := time.Now()
+	err := c.underlying.CreateEntries(ctx, entries)
+	c.logSlow(ctx, "create_multi", joinKeys(entryKeys(entries)), start, err)
+	return err
+}
+
+// GetEntry implements Client.
+func (c *LoggedClient) GetEntry(ctx context.Context, urlKey UrlKey) (URLEntry, error) {
+	start := time.Now()
+	entry, err := c.underlying.GetEntry(ctx, urlKey)
+	c.logSlow(ctx, "get", urlKey, start, err)
+	return entry, err
+}
+
+// GetEntries implements Client. Its lines name the keys joined by commas.
+func (c *LoggedClient) GetEntries(ctx context.Context, urlKeys []UrlKey) (map[UrlKey]URLEntry, error) {
+	start := time.Now()
+	entries, err := c.underlying.GetEntries(ctx, urlKeys)
+	c.logSlow(ctx, "get_multi", joinKeys(urlKeys), start, err)
+	return entries, err
+}
+
+// UpdateEntry implements Client.
+func (c *LoggedClient) UpdateEntry(ctx context.Context, urlKey UrlKey, update func(*URLEntry) error) error {
+	start := time.Now()
+	err := c.underlying.UpdateEntry(ctx, urlKey, update)
+	c.logSlow(ctx, "update", urlKey, start, err)
+	return err
+}
+
+// DeleteEntry implements Client.
+func (c *LoggedClient) DeleteEntry(ctx context.Context, urlKey UrlKey) error {
+	start := time.Now()
+	err := c.underlying.DeleteEntry(ctx, urlKey)
+	c.logSlow(ctx, "delete", urlKey, start, err)
+	return err
+}
+
+// ListEntries implements Client. Its lines name the prefix listed.
+func (c *LoggedClient) ListEntries(ctx context.Context, opts ListOptions) (ListPage, error) {
+	start := time.Now()
+	page, err := c.underlying.ListEntries(ctx, opts)
+	c.logSlow(ctx, "list", UrlKey(opts.Prefix), start, err)
+	return page, err
+}
+
+// CountEntries implements Client.
+func (c *LoggedClient) CountEntries(ctx context.Context) (StatusCounts, error) {
+	start := time.Now()
+	counts, err := c.underlying.CountEntries(ctx)
+	c.logSlow(ctx, "count", "", start, err)
+	return counts, err
+}
+
+func (c *LoggedClient) logSlow(ctx context.Context, op string, key UrlKey, start time.Time, err error) {
+	took := time.Since(start)
+	if took <= c.threshold {
+		return
+	}
+	line := fmt.Sprintf("slow store: %s %q took %v", op, key, took.Round(time.Millisecond))
+	if id := requestid.FromContext(ctx); id != "" {
+		line += " request=" + id
+	}
+	if err != nil {
+		line += fmt.Sprintf(" err=%q", err)
+	}
+	c.logger.Print(line)
+}
+
+// joinKeys names the keys of a batch in log lines.
+func joinKeys(keys []UrlKey) UrlKey {
+	names := make([]string, len(keys))
+	for i, k := range keys {
+		names[i] = string(k)
+	}
+	return UrlKey(strings.Join(names, ","))
+}
@@ -0,0 +1,46 @@
+package urlstore
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/FlorinBalint/shortener/pkg/requestid"
+)
+
+// slowClient delays the lookups of the wrapped client.
+type slowClient struct {
+	Client
+	delay time.Duration
+}
+
+func (c slowClient) GetEntry(ctx context.Context, key UrlKey) (URLEntry, error) {
+	time.Sleep(c.delay)
+	return c.Client.GetEntry(ctx, key)
+}
+
+func TestWithLogging(t *testing.T) {
+	var buf bytes.Buffer
+	under := NewMemoryClient()
+	c := WithLogging(slowClient{Client: under, delay: 20 * time.Millisecond}, log.New(&buf, "", 0))
+	c.SetThreshold(10 * time.Millisecond)
+	ctx := requestid.NewContext(context.Background(), "req-1")
+
+	if err := c.CreateEntry(ctx, "fast", URLEntry{URLTarget: "https://example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	if buf.Len() != 0 {
+		t.Fatalf("fast create logged: %s", buf.String())
+	}
+
+	c.GetEntry(ctx, "missing")
+	line := buf.String()
+	for _, want := range []string{"slow store: get", `"missing"`, "request=req-1", `err="url entry not found"`} {
+		if !strings.Contains(line, want) {
+			t.Errorf("want %q in %q", want, line)
+		}
+	}
+}
@@ -0,0 +1,295 @@
+package urlstore
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryClient is an in-process Client backed by a map.
+// It is intended for tests, local development and, persisted with Save
+// and Load, small single-process deployments.
+type MemoryClient struct {
+	mu      sync.RWMutex
+	entries map[UrlKey]URLEntry
+}
+
+var _ Client = (*MemoryClient)(nil)
+
+// NewMemoryClient returns an empty in-memory store.
+func NewMemoryClient() *MemoryClient {
+	return &MemoryClient{
+		entries: make(map[UrlKey]URLEntry),
+	}
+}
+
+// Close implements Client.
+func (c *MemoryClient) Close() error {
+	return nil
+}
+
+// CreateEntry implements Client.
+func (c *MemoryClient) CreateEntry(ctx context.Context, key UrlKey, entry URLEntry) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.entries[key]; ok {
+		return ErrAlreadyExists
+	}
+	entry.SchemaVersion = CurrentSchemaVersion
+	c.entries[key] = entry
+	return nil
+}
+
+// CreateEntries implements Client.
+func (c *MemoryClient) CreateEntries(ctx context.Context, entries []KeyedEntry) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	seen := make(map[UrlKey]bool, len(entries))
+	for _, ke := range entries {
+		if _, ok := c.entries[ke.Key]; ok || seen[ke.Key] {
+			return ErrAlreadyExists
+		}
+		seen[ke.Key] = true
+	}
+	for _, ke := range entries {
+		ke.Entry.SchemaVersion = CurrentSchemaVersion
+		c.entries[ke.Key] = ke.Entry
+	}
+	return nil
+}
+
+// Seed stores entry under key as is, without stamping its schema version,
+// as older code would have written it.
+func (c *MemoryClient) Seed(key UrlKey, entry URLEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[key] = entry
+}
+
+// GetEntry implements Client.
+func (c *MemoryClient) GetEntry(ctx context.Context, urlKey UrlKey) (URLEntry, error) {
+	if err := ctx.Err(); err != nil {
+		return URLEntry{}, err
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	entry, ok := c.entries[urlKey]
+	if !ok || !entry.Live(time.Now()) {
+		return URLEntry{}, ErrNotFound
+	}
+	return entry, nil
+}
+
+// GetEntries implements Client.
+func (c *MemoryClient) GetEntries(ctx context.Context, urlKeys []UrlKey) (map[UrlKey]URLEntry, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	now := time.Now()
+	entries := make(map[UrlKey]URLEntry, len(urlKeys))
+	for _, k := range urlKeys {
+		if entry, ok := c.entries[k]; ok && entry.Live(now) {
+			entries[k] = entry
+		}
+	}
+	return entries, nil
+}
+
+// UpdateEntry implements Client.
+func (c *MemoryClient) UpdateEntry(ctx context.Context, urlKey UrlKey, update func(*URLEntry) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[urlKey]
+	if !ok || !entry.Live(time.Now()) {
+		return ErrNotFound
+	}
+	if err := upgradeForWrite(&entry); err != nil {
+		return err
+	}
+	if err := update(&entry); err != nil {
+		return err
+	}
+	entry.SchemaVersion = CurrentSchemaVersion
+	c.entries[urlKey] = entry
+	return nil
+}
+
+// DeleteEntry implements Client.
+func (c *MemoryClient) DeleteEntry(ctx context.Context, urlKey UrlKey) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, urlKey)
+	return nil
+}
+
+// memCursor is the position of the last entry of a page.
+type memCursor struct {
+	Key     UrlKey    `json:"k"`
+	Created time.Time `json:"c"`
+}
+
+// ListEntries implements Client. The cursor encodes the position of the
+// last entry scanned, so entries written between pages do not shift them.
+func (c *MemoryClient) ListEntries(ctx context.Context, opts ListOptions) (ListPage, error) {
+	if err := ctx.Err(); err != nil {
+		return ListPage{}, err
+	}
+	var after memCursor
+	if opts.Cursor != "" {
+		raw, err := base64.RawURLEncoding.DecodeString(opts.Cursor)
+		if err != nil || json.Unmarshal(raw, &after) != nil {
+			return ListPage{}, ErrInvalidCursor
+		}
+	}
+	less, err := memLess(opts.Order)
+	if err != nil {
+		return ListPage{}, err
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	scan := make([]memCursor, 0, len(c.entries))
+	for k, e := range c.entries {
+		pos := memCursor{Key: k, Created: e.CreationTimestamp}
+		if opts.Cursor == "" || less(after, pos) {
+			scan = append(scan, pos)
+		}
+	}
+	sort.Slice(scan, func(i, j int) bool { return less(scan[i], scan[j]) })
+
+	limit := opts.PageSize()
+	now := time.Now()
+	var page ListPage
+	for i, pos := range scan {
+		if i == limit {
+			raw, err := json.Marshal(scan[i-1])
+			if err != nil {
+				return ListPage{}, err
+			}
+			page.NextCursor = base64.RawURLEncoding.EncodeToString(raw)
+			break
+		}
+		entry := c.entries[pos.Key]
+		if opts.Matches(pos.Key, entry, now) {
+			page.Entries = append(page.Entries, KeyedEntry{Key: pos.Key, Entry: entry})
+		}
+	}
+	return page, nil
+}
+
+// CountEntries implements Client.
+func (c *MemoryClient) CountEntries(ctx context.Context) (StatusCounts, error) {
+	if err := ctx.Err(); err != nil {
+		return StatusCounts{}, err
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	now := time.Now()
+	var counts StatusCounts
+	for _, e := range c.entries {
+		counts.Add(e.Status(now), 1)
+	}
+	return counts, nil
+}
+
+// memLess returns the strict ordering of list positions for order.
+// Ties on creation time are broken by key.
+func memLess(order ListOrder) (func(a, b memCursor) bool, error) {
+	switch order {
+	case OrderByKey:
+		return func(a, b memCursor) bool { return a.Key < b.Key }, nil
+	case OrderByCreatedAsc:
+		return func(a, b memCursor) bool {
+			if !a.Created.Equal(b.Created) {
+				return a.Created.Before(b.Created)
+			}
+			return a.Key < b.Key
+		}, nil
+	case OrderByCreatedDesc:
+		return func(a, b memCursor) bool {
+			if !a.Created.Equal(b.Created) {
+				return a.Created.After(b.Created)
+			}
+			return a.Key < b.Key
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown list order %d", order)
+	}
+}
+
+// WithCacheAside wraps the store with a cache-aside layer.
+func (c *MemoryClient) WithCacheAside(cache Cache) *CachedClient {
+	return WithCacheAside(c, cache)
+}
+
+// savedEntry is a line of Save: an entry and its key.
+type savedEntry struct {
+	Key   UrlKey   `json:"key"`
+	Entry URLEntry `json:"entry"`
+}
+
+// Save writes every entry, soft-deleted ones included, to w as JSON
+// lines, in key order, for Load to read back.
+func (c *MemoryClient) Save(w io.Writer) error {
+	c.mu.RLock()
+	saved := make([]savedEntry, 0, len(c.entries))
+	for k, e := range c.entries {
+		saved = append(saved, savedEntry{Key: k, Entry: e})
+	}
+	c.mu.RUnlock()
+	sort.Slice(saved, func(i, j int) bool { return saved[i].Key < saved[j].Key })
+	enc := json.NewEncoder(w)
+	for _, se := range saved {
+		if err := enc.Encode(se); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Load stores the entries Save wrote to r as they were saved, replacing
+// those under the same keys. Nothing is stored unless all of r reads.
+func (c *MemoryClient) Load(r io.Reader) error {
+	dec := json.NewDecoder(r)
+	var loaded []savedEntry
+	for {
+		var se savedEntry
+		err := dec.Decode(&se)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("entry %d: %w", len(loaded)+1, err)
+		}
+		if se.Key == "" {
+			return fmt.Errorf("entry %d: no key", len(loaded)+1)
+		}
+		loaded = append(loaded, se)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, se := range loaded {
+		c.entries[se.Key] = se.Entry
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package urlstore_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/FlorinBalint/shortener/pkg/testutil"
+	"github.com/FlorinBalint/shortener/pkg/urlstore"
+	"github.com/FlorinBalint/shortener/pkg/urlstore/urlstoretest"
+)
+
+func TestMemoryClient_Contract(t *testing.T) {
+	urlstoretest.Run(t, func(t *testing.T) urlstore.Client {
+		return urlstore.NewMemoryClient()
+	})
+}
+
+func TestCachedClient_Contract(t *testing.T) {
+	urlstoretest.Run(t, func(t *testing.T) urlstore.Client {
+		return urlstore.WithCacheAside(urlstore.NewMemoryClient(), testutil.NewFakeCache())
+	})
+}
+
+func TestMemoryClient_SaveLoad(t *testing.T) {
+	ctx := context.Background()
+	src := urlstore.NewMemoryClient()
+	created := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err := src.CreateEntry(ctx, "promo", urlstore.URLEntry{URLTarget: "https://example.com", CreationTimestamp: created, Version: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := src.CreateEntry(ctx, "gone", urlstore.URLEntry{URLTarget: "https://example.org", DeletedAt: created}); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := src.Save(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	dst := urlstore.NewMemoryClient()
+	if err := dst.Load(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	e, err := dst.GetEntry(ctx, "promo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.URLTarget != "https://example.com" || !e.CreationTimestamp.Equal(created) || e.Version != 1 || e.SchemaVersion != urlstore.CurrentSchemaVersion {
+		t.Errorf("want the entry loaded as saved, got %+v", e)
+	}
+	page, err := dst.ListEntries(ctx, urlstore.ListOptions{IncludeInactive: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Entries) != 2 {
+		t.Errorf("want the soft-deleted entry kept, got %d entries", len(page.Entries))
+	}
+
+	bad := urlstore.NewMemoryClient()
+	if err := bad.Load(strings.NewReader(buf.String() + "{\"key\":")); err == nil {
+		t.Fatal("want a truncated file refused")
+	}
+	if _, err := bad.GetEntry(ctx, "promo"); !errors.Is(err, urlstore.ErrNotFound) {
+		t.Errorf("want nothing loaded from a bad file, got %v", err)
+	}
+}
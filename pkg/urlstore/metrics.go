@@ -0,0 +1,169 @@
+package urlstore
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// meterName is the instrumentation scope of the store's metrics.
+const meterName = "github.com/FlorinBalint/shortener/pkg/urlstore"
+
+// MeteredClient records metrics of the operations of the Client it wraps:
+//
+//   - urlstore.operation.duration, a histogram of latencies in seconds by
+//     operation and outcome (ok, not_found, already_exists or error);
+//   - urlstore.operation.errors, a count of failed operations by
+//     operation, ErrNotFound and ErrAlreadyExists excepted;
+//   - urlstore.cache.lookups, a count of GetEntry lookups answered by the
+//     caches below it (memcache or micro) by result (hit or miss).
+type MeteredClient struct {
+	underlying Client
+	duration   metric.Float64Histogram
+	errors     metric.Int64Counter
+	lookups    metric.Int64Counter
+	// attrs are added to every measurement.
+	attrs []attribute.KeyValue
+}
+
+var _ Client = (*MeteredClient)(nil)
+
+// WithMetrics wraps underlying so its operations are recorded by meters of
+// provider, with attrs, e.g. the replica's location. It goes above the
+// caches to see their hits and misses.
+func WithMetrics(underlying Client, provider metric.MeterProvider, attrs ...attribute.KeyValue) (*MeteredClient, error) {
+	m := provider.Meter(meterName)
+	c := &MeteredClient{underlying: underlying, attrs: attrs}
+	var err error
+	if c.duration, err = m.Float64Histogram("urlstore.operation.duration",
+		metric.WithUnit("s"),
+		metric.WithDescription("Latency of URL store operations."),
+		metric.WithExplicitBucketBoundaries(0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5)); err != nil {
+		return nil, err
+	}
+	if c.errors, err = m.Int64Counter("urlstore.operation.errors",
+		metric.WithDescription("URL store operations that failed.")); err != nil {
+		return nil, err
+	}
+	if c.lookups, err = m.Int64Counter("urlstore.cache.lookups",
+		metric.WithDescription("URL store lookups answered by a cache, by result.")); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Close implements Client.
+func (c *MeteredClient) Close() error {
+	return c.underlying.Close()
+}
+
+// CreateEntry implements Client.
+func (c *MeteredClient) CreateEntry(ctx context.Context, key UrlKey, entry URLEntry) error {
+	start := time.Now()
+	err := c.underlying.CreateEntry(ctx, key, entry)
+	c.record(ctx, "create", start, err)
+	return err
+}
+
+// CreateEntries implements Client.
+func (c *MeteredClient) CreateEntries(ctx context.Context, entries []KeyedEntry) error {
+	start := time.Now()
+	err := c.underlying.CreateEntries(ctx, entries)
+	c.record(ctx, "create_multi", start, err)
+	return err
+}
+
+// GetEntry implements Client.
+func (c *MeteredClient) GetEntry(ctx context.Context, urlKey UrlKey) (URLEntry, error) {
+	start := time.Now()
+	entry, err := c.underlying.GetEntry(context.WithValue(ctx, cacheObserverKey{}, c.observeCache), urlKey)
+	c.record(ctx, "get", start, err)
+	return entry, err
+}
+
+// GetEntries implements Client.
+func (c *MeteredClient) GetEntries(ctx context.Context, urlKeys []UrlKey) (map[UrlKey]URLEntry, error) {
+	start := time.Now()
+	entries, err := c.underlying.GetEntries(context.WithValue(ctx, cacheObserverKey{}, c.observeCache), urlKeys)
+	c.record(ctx, "get_multi", start, err)
+	return entries, err
+}
+
+// UpdateEntry implements Client.
+func (c *MeteredClient) UpdateEntry(ctx context.Context, urlKey UrlKey, update func(*URLEntry) error) error {
+	start := time.Now()
+	err := c.underlying.UpdateEntry(ctx, urlKey, update)
+	c.record(ctx, "update", start, err)
+	return err
+}
+
+// DeleteEntry implements Client.
+func (c *MeteredClient) DeleteEntry(ctx context.Context, urlKey UrlKey) error {
+	start := time.Now()
+	err := c.underlying.DeleteEntry(ctx, urlKey)
+	c.record(ctx, "delete", start, err)
+	return err
+}
+
+// ListEntries implements Client.
+func (c *MeteredClient) ListEntries(ctx context.Context, opts ListOptions) (ListPage, error) {
+	start := time.Now()
+	page, err := c.underlying.ListEntries(ctx, opts)
+	c.record(ctx, "list", start, err)
+	return page, err
+}
+
+// CountEntries implements Client.
+func (c *MeteredClient) CountEntries(ctx context.Context) (StatusCounts, error) {
+	start := time.Now()
+	counts, err := c.underlying.CountEntries(ctx)
+	c.record(ctx, "count", start, err)
+	return counts, err
+}
+
+func (c *MeteredClient) record(ctx context.Context, op string, start time.Time, err error) {
+	outcome := "ok"
+	switch {
+	case err == nil:
+	case errors.Is(err, ErrNotFound):
+		outcome = "not_found"
+	case errors.Is(err, ErrAlreadyExists):
+		outcome = "already_exists"
+	default:
+		outcome = "error"
+		c.errors.Add(ctx, 1, c.with(attribute.String("operation", op)))
+	}
+	c.duration.Record(ctx, time.Since(start).Seconds(), c.with(
+		attribute.String("operation", op),
+		attribute.String("outcome", outcome)))
+}
+
+// with returns the attributes of a measurement: kv and c's.
+func (c *MeteredClient) with(kv ...attribute.KeyValue) metric.MeasurementOption {
+	return metric.WithAttributes(append(kv, c.attrs...)...)
+}
+
+func (c *MeteredClient) observeCache(ctx context.Context, cache string, hit bool) {
+	result := "miss"
+	if hit {
+		result = "hit"
+	}
+	c.lookups.Add(ctx, 1, c.with(
+		attribute.String("cache", cache),
+		attribute.String("result", result)))
+}
+
+// cacheObserverKey carries to the caches below a MeteredClient the
+// function counting their hits and misses.
+type cacheObserverKey struct{}
+
+// observeCache reports a lookup in cache to the MeteredClient above, if
+// any.
+func observeCache(ctx context.Context, cache string, hit bool) {
+	if observe, ok := ctx.Value(cacheObserverKey{}).(func(context.Context, string, bool)); ok {
+		observe(ctx, cache, hit)
+	}
+}
@@ -0,0 +1,102 @@
+package urlstore_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"go.opentelemetry.io/otel/attribute"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+
+	"github.com/FlorinBalint/shortener/pkg/testutil"
+	"github.com/FlorinBalint/shortener/pkg/urlstore"
+	"github.com/FlorinBalint/shortener/pkg/urlstore/urlstoretest"
+)
+
+func TestMeteredClient_Contract(t *testing.T) {
+	urlstoretest.Run(t, func(t *testing.T) urlstore.Client {
+		c, err := urlstore.WithMetrics(urlstore.NewMemoryClient(), sdkmetric.NewMeterProvider())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	})
+}
+
+func TestWithMetrics(t *testing.T) {
+	ctx := context.Background()
+	reader := sdkmetric.NewManualReader()
+	cached := urlstore.WithCacheAside(urlstore.NewMemoryClient(), testutil.NewFakeCache())
+	zone := attribute.String("cloud.availability_zone", "europe-west1-b")
+	c, err := urlstore.WithMetrics(cached, sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)), zone)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.CreateEntry(ctx, "promo", urlstore.URLEntry{URLTarget: "https://example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.CreateEntry(ctx, "promo", urlstore.URLEntry{URLTarget: "https://example.com"}); !errors.Is(err, urlstore.ErrAlreadyExists) {
+		t.Fatalf("want ErrAlreadyExists, got %v", err)
+	}
+	for range 2 {
+		if _, err := c.GetEntry(ctx, "promo"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := c.GetEntry(ctx, "missing"); !errors.Is(err, urlstore.ErrNotFound) {
+		t.Fatalf("want ErrNotFound, got %v", err)
+	}
+	fail := errors.New("update failed")
+	if err := c.UpdateEntry(ctx, "promo", func(*urlstore.URLEntry) error { return fail }); !errors.Is(err, fail) {
+		t.Fatalf("want the update's error, got %v", err)
+	}
+
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(ctx, &rm); err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]map[attribute.Distinct]int64{}
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			got[m.Name] = map[attribute.Distinct]int64{}
+			switch data := m.Data.(type) {
+			case metricdata.Histogram[float64]:
+				for _, dp := range data.DataPoints {
+					got[m.Name][dp.Attributes.Equivalent()] = int64(dp.Count)
+				}
+			case metricdata.Sum[int64]:
+				for _, dp := range data.DataPoints {
+					got[m.Name][dp.Attributes.Equivalent()] = dp.Value
+				}
+			}
+		}
+	}
+
+	count := func(name string, kv ...attribute.KeyValue) int64 {
+		set := attribute.NewSet(append(kv, zone)...)
+		return got[name][set.Equivalent()]
+	}
+	op := func(op, outcome string) []attribute.KeyValue {
+		return []attribute.KeyValue{attribute.String("operation", op), attribute.String("outcome", outcome)}
+	}
+	for _, tt := range []struct {
+		name string
+		kv   []attribute.KeyValue
+		want int64
+	}{
+		{"urlstore.operation.duration", op("create", "ok"), 1},
+		{"urlstore.operation.duration", op("create", "already_exists"), 1},
+		{"urlstore.operation.duration", op("get", "ok"), 2},
+		{"urlstore.operation.duration", op("get", "not_found"), 1},
+		{"urlstore.operation.duration", op("update", "error"), 1},
+		{"urlstore.operation.errors", []attribute.KeyValue{attribute.String("operation", "update")}, 1},
+		{"urlstore.cache.lookups", []attribute.KeyValue{attribute.String("cache", "memcache"), attribute.String("result", "hit")}, 2},
+		{"urlstore.cache.lookups", []attribute.KeyValue{attribute.String("cache", "memcache"), attribute.String("result", "miss")}, 1},
+	} {
+		if n := count(tt.name, tt.kv...); n != tt.want {
+			t.Errorf("%s %v: want %d, got %d", tt.name, tt.kv, tt.want, n)
+		}
+	}
+}
@@ -0,0 +1,311 @@
+package urlstore
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// refreshTimeout bounds the background lookups refreshing stale entries.
+const refreshTimeout = 5 * time.Second
+
+// maxMicroCacheEntries bounds the micro-cache, so a scan of random keys
+// cannot grow it without limit; lookups beyond it are not cached.
+const maxMicroCacheEntries = 100_000
+
+// MicroCachedClient keeps the results of recent lookups, found or not, in
+// process for a short TTL, and collapses concurrent lookups of the same key
+// into one. A burst of requests for a single key then costs the layers
+// below one lookup per TTL and replica. With a max staleness, entries past
+// their TTL are still served while a lookup refreshes them in the
+// background, so hot links never wait on the layers below.
+type MicroCachedClient struct {
+	underlying Client
+	ttl        time.Duration
+	missTTL    time.Duration
+	maxStale   time.Duration
+	now        func() time.Time
+
+	mu      sync.Mutex
+	entries map[UrlKey]microEntry
+	flight  singleflight.Group
+
+	counters                cacheCounters
+	negativeHits, staleHits atomic.Uint64
+	evictions, rejected     atomic.Uint64
+}
+
+type microEntry struct {
+	entry    URLEntry
+	notFound bool
+	expires  time.Time
+	// staleUntil is when the entry may no longer be served while it is
+	// refreshed; not after expires for misses and for entries expiring.
+	staleUntil time.Time
+}
+
+var _ Client = (*MicroCachedClient)(nil)
+
+// WithMicroCache wraps underlying with an in-process cache of GetEntry
+// results, including ErrNotFound, living for ttl (meant to be sub-second).
+// Replicas may serve a changed or deleted link for up to ttl.
+func WithMicroCache(underlying Client, ttl time.Duration) *MicroCachedClient {
+	return &MicroCachedClient{
+		underlying: underlying,
+		ttl:        ttl,
+		missTTL:    ttl,
+		now:        time.Now,
+		entries:    make(map[UrlKey]microEntry),
+	}
+}
+
+// SetMissTTL keeps ErrNotFound results for ttl rather than the TTL of
+// entries. 0 does not keep them, so a link created through another replica
+// is served as soon as the store has it, at the cost of a lookup per
+// request for missing keys.
+func (c *MicroCachedClient) SetMissTTL(ttl time.Duration) {
+	c.missTTL = ttl
+}
+
+// SetMaxStale serves entries up to d past their TTL while they are looked
+// up again in the background; 0, the default, looks them up at once.
+// Misses are never served stale, nor entries past their own expiry.
+func (c *MicroCachedClient) SetMaxStale(d time.Duration) {
+	c.maxStale = d
+}
+
+// Close implements Client.
+func (c *MicroCachedClient) Close() error {
+	return c.underlying.Close()
+}
+
+// CreateEntry implements Client.
+func (c *MicroCachedClient) CreateEntry(ctx context.Context, key UrlKey, entry URLEntry) error {
+	defer c.forget(key)
+	return c.underlying.CreateEntry(ctx, key, entry)
+}
+
+// CreateEntries implements Client.
+func (c *MicroCachedClient) CreateEntries(ctx context.Context, entries []KeyedEntry) error {
+	defer func() {
+		for _, ke := range entries {
+			c.forget(ke.Key)
+		}
+	}()
+	return c.underlying.CreateEntries(ctx, entries)
+}
+
+// GetEntry implements Client. Errors other than ErrNotFound are not cached.
+func (c *MicroCachedClient) GetEntry(ctx context.Context, urlKey UrlKey) (URLEntry, error) {
+	now := c.now()
+	c.mu.Lock()
+	me, ok := c.entries[urlKey]
+	c.mu.Unlock()
+	if ok && now.Before(me.expires) {
+		c.hit(ctx, me, false)
+		if me.notFound {
+			return URLEntry{}, ErrNotFound
+		}
+		return me.entry, nil
+	}
+	if ok && now.Before(me.staleUntil) {
+		c.hit(ctx, me, true)
+		c.refresh(ctx, urlKey)
+		return me.entry, nil
+	}
+
+	c.miss(ctx)
+	v, err, _ := c.flight.Do(string(urlKey), c.lookup(ctx, urlKey))
+	if err != nil {
+		return URLEntry{}, err
+	}
+	return v.(URLEntry), nil
+}
+
+// GetEntries implements Client. Keys cached, fresh or stale, are served
+// from the cache; the rest are looked up below in one batch, which is not
+// collapsed with concurrent lookups.
+func (c *MicroCachedClient) GetEntries(ctx context.Context, urlKeys []UrlKey) (map[UrlKey]URLEntry, error) {
+	now := c.now()
+	entries := make(map[UrlKey]URLEntry, len(urlKeys))
+	var missed, stale []UrlKey
+	c.mu.Lock()
+	for _, k := range urlKeys {
+		me, ok := c.entries[k]
+		switch {
+		case ok && now.Before(me.expires):
+			c.hit(ctx, me, false)
+			if !me.notFound {
+				entries[k] = me.entry
+			}
+		case ok && now.Before(me.staleUntil):
+			c.hit(ctx, me, true)
+			entries[k] = me.entry
+			stale = append(stale, k)
+		default:
+			c.miss(ctx)
+			missed = append(missed, k)
+		}
+	}
+	c.mu.Unlock()
+	for _, k := range stale {
+		c.refresh(ctx, k)
+	}
+	if len(missed) == 0 {
+		return entries, nil
+	}
+
+	found, err := c.underlying.GetEntries(ctx, missed)
+	if err != nil {
+		return nil, err
+	}
+	for _, k := range missed {
+		entry, ok := found[k]
+		if !ok {
+			c.remember(k, microEntry{notFound: true})
+			continue
+		}
+		c.remember(k, microEntry{entry: entry})
+		entries[k] = entry
+	}
+	return entries, nil
+}
+
+// lookup returns the function looking urlKey up below and caching the
+// result, for the flight group.
+func (c *MicroCachedClient) lookup(ctx context.Context, urlKey UrlKey) func() (any, error) {
+	return func() (any, error) {
+		entry, err := c.underlying.GetEntry(ctx, urlKey)
+		switch {
+		case err == nil:
+			c.remember(urlKey, microEntry{entry: entry})
+		case errors.Is(err, ErrNotFound):
+			c.remember(urlKey, microEntry{notFound: true})
+		}
+		return entry, err
+	}
+}
+
+// refresh looks urlKey up in the background, unless a lookup is already
+// under way. On errors the stale entry is kept until staleUntil.
+func (c *MicroCachedClient) refresh(ctx context.Context, urlKey UrlKey) {
+	// The request serving the stale entry does not wait for the refresh.
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), refreshTimeout)
+	ch := c.flight.DoChan(string(urlKey), c.lookup(ctx, urlKey))
+	go func() {
+		<-ch
+		cancel()
+	}()
+}
+
+// UpdateEntry implements Client.
+func (c *MicroCachedClient) UpdateEntry(ctx context.Context, urlKey UrlKey, update func(*URLEntry) error) error {
+	defer c.forget(urlKey)
+	return c.underlying.UpdateEntry(ctx, urlKey, update)
+}
+
+// DeleteEntry implements Client.
+func (c *MicroCachedClient) DeleteEntry(ctx context.Context, urlKey UrlKey) error {
+	defer c.forget(urlKey)
+	return c.underlying.DeleteEntry(ctx, urlKey)
+}
+
+// ListEntries implements Client. Listing always reads the underlying store.
+func (c *MicroCachedClient) ListEntries(ctx context.Context, opts ListOptions) (ListPage, error) {
+	return c.underlying.ListEntries(ctx, opts)
+}
+
+// CountEntries implements Client. Counting always reads the underlying
+// store.
+func (c *MicroCachedClient) CountEntries(ctx context.Context) (StatusCounts, error) {
+	return c.underlying.CountEntries(ctx)
+}
+
+// remember caches me for the TTL, or the miss TTL, or until the entry
+// expires if sooner. Burn-after-reading entries are not kept.
+func (c *MicroCachedClient) remember(key UrlKey, me microEntry) {
+	ttl := c.ttl
+	if me.notFound {
+		ttl = c.missTTL
+	}
+	if me.entry.BurnAfterReading || ttl <= 0 {
+		return
+	}
+	now := c.now()
+	me.expires = now.Add(ttl)
+	me.staleUntil = me.expires
+	if !me.notFound {
+		me.staleUntil = me.expires.Add(c.maxStale)
+	}
+	if !me.entry.ExpiresAt.IsZero() {
+		if me.entry.ExpiresAt.Before(me.expires) {
+			me.expires = me.entry.ExpiresAt
+		}
+		if me.entry.ExpiresAt.Before(me.staleUntil) {
+			me.staleUntil = me.entry.ExpiresAt
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.entries) >= maxMicroCacheEntries {
+		for k, e := range c.entries {
+			if !now.Before(e.staleUntil) {
+				delete(c.entries, k)
+				c.evictions.Add(1)
+			}
+		}
+		if len(c.entries) >= maxMicroCacheEntries {
+			c.rejected.Add(1)
+			return
+		}
+	}
+	c.entries[key] = me
+}
+
+func (c *MicroCachedClient) forget(key UrlKey) {
+	c.mu.Lock()
+	delete(c.entries, key)
+	c.mu.Unlock()
+}
+
+// hit counts a lookup answered with me, stale or not.
+func (c *MicroCachedClient) hit(ctx context.Context, me microEntry, stale bool) {
+	observeCache(ctx, "micro", true)
+	c.counters.lookup(true)
+	if me.notFound {
+		c.negativeHits.Add(1)
+	}
+	if stale {
+		c.staleHits.Add(1)
+	}
+}
+
+// miss counts a lookup the cache could not answer.
+func (c *MicroCachedClient) miss(ctx context.Context) {
+	observeCache(ctx, "micro", false)
+	c.counters.lookup(false)
+}
+
+// CacheStats returns the lookups of the cache since it was created, and
+// the entries it holds.
+func (c *MicroCachedClient) CacheStats() CacheStats {
+	s := c.counters.stats()
+	s.NegativeHits = c.negativeHits.Load()
+	s.StaleHits = c.staleHits.Load()
+	s.Evictions = c.evictions.Load()
+	s.Rejected = c.rejected.Load()
+	c.mu.Lock()
+	s.Entries = len(c.entries)
+	for _, me := range c.entries {
+		if me.notFound {
+			s.NegativeEntries++
+		}
+	}
+	c.mu.Unlock()
+	return s
+}
@@ -0,0 +1,238 @@
+package urlstore
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countingClient counts the lookups reaching the wrapped client.
+type countingClient struct {
+	Client
+	gets    atomic.Int32
+	batches atomic.Int32
+}
+
+func (c *countingClient) GetEntry(ctx context.Context, key UrlKey) (URLEntry, error) {
+	c.gets.Add(1)
+	return c.Client.GetEntry(ctx, key)
+}
+
+func (c *countingClient) GetEntries(ctx context.Context, keys []UrlKey) (map[UrlKey]URLEntry, error) {
+	c.batches.Add(1)
+	return c.Client.GetEntries(ctx, keys)
+}
+
+func TestWithMicroCache(t *testing.T) {
+	ctx := context.Background()
+	under := &countingClient{Client: NewMemoryClient()}
+	if err := under.CreateEntry(ctx, "hot", URLEntry{URLTarget: "https://example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	c := WithMicroCache(under, 500*time.Millisecond)
+	now := time.Now()
+	c.now = func() time.Time { return now }
+
+	for range 3 {
+		if e, err := c.GetEntry(ctx, "hot"); err != nil || e.URLTarget != "https://example.com" {
+			t.Fatalf("GetEntry(hot): %+v, %v", e, err)
+		}
+		if _, err := c.GetEntry(ctx, "missing"); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("GetEntry(missing): want ErrNotFound, got %v", err)
+		}
+	}
+	if got := under.gets.Load(); got != 2 {
+		t.Fatalf("want 2 underlying lookups, got %d", got)
+	}
+
+	now = now.Add(500 * time.Millisecond)
+	if _, err := c.GetEntry(ctx, "hot"); err != nil {
+		t.Fatal(err)
+	}
+	if got := under.gets.Load(); got != 3 {
+		t.Fatalf("after TTL: want 3 underlying lookups, got %d", got)
+	}
+
+	// Writes through the cache are visible immediately.
+	if err := c.CreateEntry(ctx, "missing", URLEntry{URLTarget: "https://example.org"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.GetEntry(ctx, "missing"); err != nil {
+		t.Fatalf("after create: %v", err)
+	}
+}
+
+func TestWithMicroCache_HitsDoNotAllocate(t *testing.T) {
+	ctx := context.Background()
+	under := NewMemoryClient()
+	if err := under.CreateEntry(ctx, "hot", URLEntry{URLTarget: "https://example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	c := WithMicroCache(under, time.Hour)
+	c.GetEntry(ctx, "hot")
+	c.GetEntry(ctx, "missing")
+
+	for _, key := range []UrlKey{"hot", "missing"} {
+		if n := testing.AllocsPerRun(100, func() { c.GetEntry(ctx, key) }); n != 0 {
+			t.Errorf("GetEntry(%s): want no allocations on a hit, got %v", key, n)
+		}
+	}
+}
+
+func TestWithMicroCache_BoundedByEntryExpiry(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	under := &countingClient{Client: NewMemoryClient()}
+	if err := under.CreateEntry(ctx, "k", URLEntry{URLTarget: "https://example.com", ExpiresAt: now.Add(100 * time.Millisecond)}); err != nil {
+		t.Fatal(err)
+	}
+	c := WithMicroCache(under, time.Second)
+	c.now = func() time.Time { return now }
+	if _, err := c.GetEntry(ctx, "k"); err != nil {
+		t.Fatal(err)
+	}
+	now = now.Add(100 * time.Millisecond)
+	c.GetEntry(ctx, "k")
+	if got := under.gets.Load(); got != 2 {
+		t.Fatalf("want a new lookup once the entry expired, got %d lookups", got)
+	}
+}
+
+// blockingClient holds lookups until release is closed.
+type blockingClient struct {
+	countingClient
+	release chan struct{}
+}
+
+func (c *blockingClient) GetEntry(ctx context.Context, key UrlKey) (URLEntry, error) {
+	<-c.release
+	return c.countingClient.GetEntry(ctx, key)
+}
+
+func TestWithMicroCache_CollapsesConcurrentMisses(t *testing.T) {
+	under := &blockingClient{countingClient: countingClient{Client: NewMemoryClient()}, release: make(chan struct{})}
+	c := WithMicroCache(under, time.Second)
+
+	var wg sync.WaitGroup
+	for range 50 {
+		wg.Go(func() { c.GetEntry(context.Background(), "k") })
+	}
+	time.Sleep(20 * time.Millisecond)
+	close(under.release)
+	wg.Wait()
+	if got := under.gets.Load(); got != 1 {
+		t.Fatalf("want 1 underlying lookup, got %d", got)
+	}
+}
+
+func TestWithMicroCache_MissTTL(t *testing.T) {
+	ctx := context.Background()
+	under := &countingClient{Client: NewMemoryClient()}
+	c := WithMicroCache(under, time.Second)
+	c.SetMissTTL(0)
+
+	if _, err := c.GetEntry(ctx, "new"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("before create: want ErrNotFound, got %v", err)
+	}
+	// Created through another replica, bypassing this cache.
+	if err := under.CreateEntry(ctx, "new", URLEntry{URLTarget: "https://example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.GetEntry(ctx, "new"); err != nil {
+		t.Fatalf("after create: %v", err)
+	}
+	if _, err := c.GetEntry(ctx, "new"); err != nil {
+		t.Fatal(err)
+	}
+	if got := under.gets.Load(); got != 2 {
+		t.Fatalf("want 2 underlying lookups, got %d", got)
+	}
+}
+
+func TestWithMicroCache_GetEntries(t *testing.T) {
+	ctx := context.Background()
+	under := &countingClient{Client: NewMemoryClient()}
+	for _, k := range []UrlKey{"docs/*", "hot"} {
+		if err := under.CreateEntry(ctx, k, URLEntry{URLTarget: "https://example.com/" + string(k)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c := WithMicroCache(under, time.Second)
+	if _, err := c.GetEntry(ctx, "hot"); err != nil {
+		t.Fatal(err)
+	}
+
+	keys := []UrlKey{"docs/a/*", "docs/*", "hot", "docs"}
+	for range 3 {
+		got, err := c.GetEntries(ctx, keys)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != 2 || got["docs/*"].URLTarget != "https://example.com/docs/*" || got["hot"].URLTarget != "https://example.com/hot" {
+			t.Fatalf("GetEntries: %+v", got)
+		}
+	}
+	// The cached key stays out of the batch, and misses are cached too.
+	if b, g := under.batches.Load(), under.gets.Load(); b != 1 || g != 1 {
+		t.Fatalf("want 1 batch and 1 get below, got %d and %d", b, g)
+	}
+}
+
+func TestWithMicroCache_MaxStale(t *testing.T) {
+	ctx := context.Background()
+	under := &blockingClient{countingClient: countingClient{Client: NewMemoryClient()}, release: make(chan struct{})}
+	if err := under.CreateEntry(ctx, "hot", URLEntry{URLTarget: "https://example.com/v1"}); err != nil {
+		t.Fatal(err)
+	}
+	c := WithMicroCache(under, time.Second)
+	c.SetMaxStale(time.Second)
+	start := time.Now()
+	var elapsed atomic.Int64
+	c.now = func() time.Time { return start.Add(time.Duration(elapsed.Load())) }
+
+	close(under.release)
+	if _, err := c.GetEntry(ctx, "hot"); err != nil {
+		t.Fatal(err)
+	}
+	under.release = make(chan struct{})
+	if err := under.UpdateEntry(ctx, "hot", func(e *URLEntry) error { e.URLTarget = "https://example.com/v2"; return nil }); err != nil {
+		t.Fatal(err)
+	}
+
+	// Past the TTL the stale entry is served without waiting for the
+	// refresh, which the underlying client holds.
+	elapsed.Store(int64(1500 * time.Millisecond))
+	for range 3 {
+		if e, err := c.GetEntry(ctx, "hot"); err != nil || e.URLTarget != "https://example.com/v1" {
+			t.Fatalf("stale: %+v, %v", e, err)
+		}
+	}
+	close(under.release)
+	deadline := time.Now().Add(time.Second)
+	for {
+		e, err := c.GetEntry(ctx, "hot")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if e.URLTarget == "https://example.com/v2" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("entry not refreshed")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if got := under.gets.Load(); got != 2 {
+		t.Fatalf("want 2 underlying lookups, one refresh, got %d", got)
+	}
+
+	// Past the max staleness, lookups wait again.
+	elapsed.Store(int64(5 * time.Second))
+	c.GetEntry(ctx, "hot")
+	if got := under.gets.Load(); got != 3 {
+		t.Fatalf("want a blocking lookup past the max staleness, got %d lookups", got)
+	}
+}
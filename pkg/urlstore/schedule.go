@@ -0,0 +1,77 @@
+package urlstore
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// MaxScheduledTargets bounds the windows of an entry's schedule.
+const MaxScheduledTargets = 32
+
+// ScheduledTarget is a target an entry redirects to during a window of
+// time, e.g. a livestream during an event, instead of its URLTarget.
+type ScheduledTarget struct {
+	URLTarget string `json:"url_target"`
+	// From and Until bound the window, From included and Until not; a zero
+	// bound leaves that side open.
+	From  time.Time `json:"from,omitzero"`
+	Until time.Time `json:"until,omitzero"`
+}
+
+// contains reports whether the window includes now.
+func (s ScheduledTarget) contains(now time.Time) bool {
+	return (s.From.IsZero() || !now.Before(s.From)) && (s.Until.IsZero() || now.Before(s.Until))
+}
+
+// ValidateSchedule checks that the windows of a schedule have a target,
+// end after they start and do not overlap, and sorts them by start.
+func ValidateSchedule(schedule []ScheduledTarget) error {
+	if len(schedule) > MaxScheduledTargets {
+		return fmt.Errorf("schedule has more than %d targets", MaxScheduledTargets)
+	}
+	for _, s := range schedule {
+		if s.URLTarget == "" {
+			return errors.New("scheduled url_target cannot be empty")
+		}
+		if !s.From.IsZero() && !s.Until.IsZero() && !s.Until.After(s.From) {
+			return fmt.Errorf("scheduled target %q ends before it starts", s.URLTarget)
+		}
+	}
+	slices.SortFunc(schedule, func(a, b ScheduledTarget) int { return a.From.Compare(b.From) })
+	for i := 1; i < len(schedule); i++ {
+		prev, next := schedule[i-1], schedule[i]
+		// Only the first window may be open at the start, and sorted
+		// windows overlap when one starts before the previous ends.
+		if next.From.IsZero() || prev.Until.IsZero() || next.From.Before(prev.Until) {
+			return fmt.Errorf("scheduled targets %q and %q overlap", prev.URLTarget, next.URLTarget)
+		}
+	}
+	return nil
+}
+
+// TargetAt returns the target the entry redirects to at now: that of the
+// scheduled window including now, or else URLTarget.
+func (e URLEntry) TargetAt(now time.Time) string {
+	for _, s := range e.Schedule {
+		if s.contains(now) {
+			return s.URLTarget
+		}
+	}
+	return e.URLTarget
+}
+
+// NextSwitch returns when, after now, the target of the entry next changes
+// according to its schedule, or the zero time if it never does.
+func (e URLEntry) NextSwitch(now time.Time) time.Time {
+	var next time.Time
+	for _, s := range e.Schedule {
+		for _, t := range []time.Time{s.From, s.Until} {
+			if t.After(now) && (next.IsZero() || t.Before(next)) {
+				next = t
+			}
+		}
+	}
+	return next
+}
@@ -0,0 +1,110 @@
+package urlstore
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/gomemcache/memcache"
+)
+
+func TestValidateSchedule(t *testing.T) {
+	at := func(h int) time.Time { return time.Date(2026, 5, 1, h, 0, 0, 0, time.UTC) }
+	tests := []struct {
+		name     string
+		schedule []ScheduledTarget
+		wantErr  bool
+	}{
+		{"empty", nil, false},
+		{"live then recording", []ScheduledTarget{
+			{URLTarget: "https://example.com/recording", From: at(12)},
+			{URLTarget: "https://example.com/live", From: at(10), Until: at(12)},
+		}, false},
+		{"open start first", []ScheduledTarget{
+			{URLTarget: "https://example.com/a", Until: at(10)},
+			{URLTarget: "https://example.com/b", From: at(10)},
+		}, false},
+		{"no target", []ScheduledTarget{{From: at(10)}}, true},
+		{"ends before start", []ScheduledTarget{{URLTarget: "https://example.com/a", From: at(12), Until: at(10)}}, true},
+		{"empty window", []ScheduledTarget{{URLTarget: "https://example.com/a", From: at(10), Until: at(10)}}, true},
+		{"overlap", []ScheduledTarget{
+			{URLTarget: "https://example.com/a", From: at(10), Until: at(12)},
+			{URLTarget: "https://example.com/b", From: at(11)},
+		}, true},
+		{"two open starts", []ScheduledTarget{
+			{URLTarget: "https://example.com/a", Until: at(10)},
+			{URLTarget: "https://example.com/b", Until: at(12)},
+		}, true},
+		{"open end before another", []ScheduledTarget{
+			{URLTarget: "https://example.com/a", From: at(10)},
+			{URLTarget: "https://example.com/b", From: at(12)},
+		}, true},
+		{"too many", make([]ScheduledTarget, MaxScheduledTargets+1), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateSchedule(tt.schedule)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ValidateSchedule() = %v, want error %v", err, tt.wantErr)
+			}
+			if err == nil && len(tt.schedule) > 1 && tt.schedule[1].From.Before(tt.schedule[0].From) {
+				t.Errorf("schedule not sorted: %+v", tt.schedule)
+			}
+		})
+	}
+}
+
+func TestURLEntry_TargetAt(t *testing.T) {
+	start := time.Date(2026, 5, 1, 10, 0, 0, 0, time.UTC)
+	end := start.Add(2 * time.Hour)
+	e := URLEntry{
+		URLTarget: "https://example.com/event",
+		Schedule: []ScheduledTarget{
+			{URLTarget: "https://example.com/live", From: start, Until: end},
+			{URLTarget: "https://example.com/recording", From: end},
+		},
+	}
+	tests := []struct {
+		now        time.Time
+		want       string
+		wantSwitch time.Time
+	}{
+		{start.Add(-time.Minute), "https://example.com/event", start},
+		{start, "https://example.com/live", end},
+		{end.Add(-time.Second), "https://example.com/live", end},
+		{end, "https://example.com/recording", time.Time{}},
+	}
+	for _, tt := range tests {
+		if got := e.TargetAt(tt.now); got != tt.want {
+			t.Errorf("TargetAt(%v) = %q, want %q", tt.now, got, tt.want)
+		}
+		if got := e.NextSwitch(tt.now); !got.Equal(tt.wantSwitch) {
+			t.Errorf("NextSwitch(%v) = %v, want %v", tt.now, got, tt.wantSwitch)
+		}
+	}
+}
+
+func TestCachedClient_ScheduledTarget(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	cache := &mapCache{items: make(map[string]*memcache.Item)}
+	c := WithCacheAside(NewMemoryClient(), cache)
+	err := c.CreateEntry(ctx, "event", URLEntry{
+		URLTarget: "https://example.com/recording",
+		Schedule:  []ScheduledTarget{{URLTarget: "https://example.com/live", Until: now.Add(time.Hour)}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	item, ok := cache.items[cacheKey("event")]
+	if !ok {
+		t.Fatal("want the scheduled entry cached")
+	}
+	if string(item.Value) != "https://example.com/live" {
+		t.Errorf("cached target = %q, want the scheduled one", item.Value)
+	}
+	// The copy expires when the live window ends.
+	if item.Expiration <= 0 || item.Expiration > int32(time.Hour/time.Second) {
+		t.Errorf("cache expiration = %d, want at most an hour", item.Expiration)
+	}
+}
@@ -0,0 +1,73 @@
+package urlstore
+
+import (
+	"errors"
+	"fmt"
+)
+
+// CurrentSchemaVersion is the schema version of entries written by this
+// code. Stores stamp it on every write, after upgrading older entries.
+const CurrentSchemaVersion = 3
+
+// ErrNewerSchema is returned when updating an entry written by newer code,
+// which this code would lose fields of.
+var ErrNewerSchema = errors.New("entry has a newer schema version")
+
+// Migration upgrades an entry from schema version From to From+1.
+type Migration struct {
+	From        int
+	Description string
+	Apply       func(*URLEntry)
+}
+
+// Migrations upgrade entries to CurrentSchemaVersion, in order. Add one,
+// and bump CurrentSchemaVersion, whenever stored entries need more than
+// Go's zero values for a new field; then run cmd/migrate to rewrite them.
+var Migrations = []Migration{
+	{
+		From: 0,
+		Description: "version entries written before edits were versioned; rewriting " +
+			"also stores the indexed created, target_host and target_hash properties",
+		Apply: func(e *URLEntry) {
+			if e.Version == 0 {
+				e.Version = 1
+			}
+		},
+	},
+	{
+		From:        1,
+		Description: "store the indexed state and expires properties, which status listings and counts query",
+		Apply:       func(*URLEntry) {},
+	},
+	{
+		From:        2,
+		Description: "store the indexed owner property, which listings scoped to their caller query",
+		Apply:       func(*URLEntry) {},
+	},
+}
+
+// Upgrade applies the migrations from e's schema version to the current
+// one. It reports whether e changed, and leaves entries written by newer
+// code untouched.
+func Upgrade(e *URLEntry) bool {
+	if e.SchemaVersion >= CurrentSchemaVersion {
+		return false
+	}
+	for _, m := range Migrations {
+		if m.From == e.SchemaVersion {
+			m.Apply(e)
+			e.SchemaVersion++
+		}
+	}
+	return true
+}
+
+// upgradeForWrite upgrades e before it is written back, refusing entries
+// written by newer code.
+func upgradeForWrite(e *URLEntry) error {
+	if e.SchemaVersion > CurrentSchemaVersion {
+		return fmt.Errorf("%w: %d > %d", ErrNewerSchema, e.SchemaVersion, CurrentSchemaVersion)
+	}
+	Upgrade(e)
+	return nil
+}
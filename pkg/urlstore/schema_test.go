@@ -0,0 +1,30 @@
+package urlstore
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestMemoryClient_UpgradesOnUpdate(t *testing.T) {
+	c := NewMemoryClient()
+	c.Seed("legacy", URLEntry{URLTarget: "https://example.com"})
+	c.Seed("future", URLEntry{URLTarget: "https://example.com", SchemaVersion: CurrentSchemaVersion + 1})
+
+	err := c.UpdateEntry(context.Background(), "legacy", func(e *URLEntry) error {
+		if e.SchemaVersion != CurrentSchemaVersion || e.Version != 1 {
+			t.Errorf("want the entry upgraded before update, got %+v", e)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = c.UpdateEntry(context.Background(), "future", func(e *URLEntry) error {
+		t.Error("update called for an entry of a newer schema")
+		return nil
+	})
+	if !errors.Is(err, ErrNewerSchema) {
+		t.Fatalf("want ErrNewerSchema, got %v", err)
+	}
+}
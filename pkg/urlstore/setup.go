@@ -0,0 +1,97 @@
+package urlstore
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"go.opentelemetry.io/otel/metric"
+
+	"github.com/FlorinBalint/shortener/pkg/envelope"
+	"github.com/FlorinBalint/shortener/pkg/gcputil"
+)
+
+// Setup is the part of the reader's and writer's config that wraps their
+// stores, so both wrap them the same way.
+type Setup struct {
+	// ProjectID, DSNamespace and DSEndpoint locate the primary Datastore.
+	ProjectID   string
+	DSNamespace string
+	DSEndpoint  string
+	// SlowThreshold logs operations taking longer; 0 disables the log.
+	SlowThreshold time.Duration
+	// MeterProvider records metrics of the operations, tagged with the
+	// attributes of Location; nil disables them.
+	MeterProvider metric.MeterProvider
+	Location      gcputil.Location
+	// TargetKMSKey and TargetWrappedKey encrypt targets, see
+	// envelope.FromKMSSecret.
+	TargetKMSKey     string
+	TargetWrappedKey string
+	// ShadowProjectID and ShadowNamespace name the Datastore mirroring the
+	// primary one, ShadowReadRate the fraction of reads compared.
+	ShadowProjectID string
+	ShadowNamespace string
+	ShadowReadRate  float64
+}
+
+// Logged logs the slow operations of store, unless the threshold is 0.
+func (s Setup) Logged(store Client) Client {
+	if s.SlowThreshold <= 0 {
+		return store
+	}
+	c := WithLogging(store, nil)
+	c.SetThreshold(s.SlowThreshold)
+	return c
+}
+
+// Metered records metrics of the operations of store, when a meter
+// provider is set.
+func (s Setup) Metered(store Client) Client {
+	if s.MeterProvider == nil {
+		return store
+	}
+	m, err := WithMetrics(store, s.MeterProvider, s.Location.Attributes()...)
+	if err != nil {
+		log.Printf("store metrics disabled: %v", err)
+		return store
+	}
+	return m
+}
+
+// TargetCipher unwraps the target encryption key; it returns nil when
+// encryption is off.
+func (s Setup) TargetCipher(ctx context.Context) (*envelope.Cipher, error) {
+	return envelope.FromKMSSecret(ctx, "target encryption", s.TargetKMSKey, s.TargetWrappedKey)
+}
+
+// Encrypted wraps store to encrypt and decrypt targets with c, unless c
+// is nil.
+func Encrypted(store Client, c *envelope.Cipher) Client {
+	if c == nil {
+		return store
+	}
+	return WithEncryption(store, c)
+}
+
+// Shadowed mirrors base to the shadow Datastore, if one is configured.
+func (s Setup) Shadowed(ctx context.Context, base Client) (Client, error) {
+	if s.ShadowProjectID == "" && s.ShadowNamespace == "" {
+		return base, nil
+	}
+	project := cmp.Or(s.ShadowProjectID, s.ProjectID)
+	if project == s.ProjectID && s.ShadowNamespace == s.DSNamespace {
+		return nil, fmt.Errorf("the shadow store must differ from the primary one")
+	}
+	ds, err := gcputil.NewDSClient(ctx, project, s.DSEndpoint, s.ShadowNamespace)
+	if err != nil {
+		return nil, fmt.Errorf("shadow datastore: %w", err)
+	}
+	log.Printf("store shadowed to project %q namespace %q, comparing %v of reads", project, s.ShadowNamespace, s.ShadowReadRate)
+	return WithShadow(base, NewClient(ds), ShadowConfig{
+		ReadRate:     s.ShadowReadRate,
+		MirrorWrites: true,
+	}), nil
+}
@@ -0,0 +1,60 @@
+package urlstore_test
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+
+	"github.com/FlorinBalint/shortener/pkg/envelope"
+	"github.com/FlorinBalint/shortener/pkg/urlstore"
+)
+
+func TestSetup_Off(t *testing.T) {
+	var setup urlstore.Setup
+	store := urlstore.NewMemoryClient()
+	if got := setup.Logged(store); got != store {
+		t.Errorf("Logged: want the store as is, got %T", got)
+	}
+	if got := setup.Metered(store); got != store {
+		t.Errorf("Metered: want the store as is, got %T", got)
+	}
+	if got := urlstore.Encrypted(store, nil); got != store {
+		t.Errorf("Encrypted: want the store as is, got %T", got)
+	}
+	if c, err := setup.TargetCipher(context.Background()); c != nil || err != nil {
+		t.Errorf("TargetCipher: want no cipher, got %v, %v", c, err)
+	}
+	if got, err := setup.Shadowed(context.Background(), store); got != store || err != nil {
+		t.Errorf("Shadowed: want the store as is, got %T, %v", got, err)
+	}
+}
+
+func TestSetup_On(t *testing.T) {
+	setup := urlstore.Setup{
+		ProjectID:     "p",
+		SlowThreshold: time.Second,
+		MeterProvider: sdkmetric.NewMeterProvider(),
+		// The shadow must be another store.
+		ShadowProjectID: "p",
+	}
+	store := urlstore.NewMemoryClient()
+	if _, ok := setup.Logged(store).(*urlstore.LoggedClient); !ok {
+		t.Error("Logged: want a LoggedClient")
+	}
+	if _, ok := setup.Metered(store).(*urlstore.MeteredClient); !ok {
+		t.Error("Metered: want a MeteredClient")
+	}
+	c, err := envelope.NewCipher(bytes.Repeat([]byte{7}, envelope.DataKeySize))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := urlstore.Encrypted(store, c).(*urlstore.EncryptedClient); !ok {
+		t.Error("Encrypted: want an EncryptedClient")
+	}
+	if _, err := setup.Shadowed(context.Background(), store); err == nil {
+		t.Error("Shadowed: want an error for a shadow that is the primary store")
+	}
+}
@@ -0,0 +1,336 @@
+package urlstore
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"math/rand/v2"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ShadowConfig configures the mirroring done by WithShadow.
+//
+// ReadRate is the fraction of reads (0..1) repeated on the secondary and
+// compared. MirrorWrites applies every successful write to the secondary
+// as well, in order. Timeout bounds each mirrored operation (default 5s).
+// ReportEvery is the period of the summary log line (default a minute).
+type ShadowConfig struct {
+	ReadRate     float64
+	MirrorWrites bool
+	Timeout      time.Duration
+	ReportEvery  time.Duration
+}
+
+// Bounds on the work queued for the secondary, so a slow secondary cannot
+// hold up or exhaust the primary path. Work beyond them is dropped and
+// counted.
+const (
+	maxShadowReads  = 64
+	maxShadowWrites = 10_000
+)
+
+// ShadowStats counts the mirrored operations and their outcomes.
+//
+// Mismatches are reads where the stores disagree on the entry or on its
+// existence; Errors are mirrored operations failing on the secondary
+// alone. Dropped operations were never mirrored, so after a dropped write
+// the stores may diverge until the entry is written again.
+type ShadowStats struct {
+	Reads          int64 `json:"reads"`
+	ReadMismatches int64 `json:"read_mismatches"`
+	ReadErrors     int64 `json:"read_errors"`
+	ReadsDropped   int64 `json:"reads_dropped"`
+	Writes         int64 `json:"writes"`
+	WriteErrors    int64 `json:"write_errors"`
+	WritesDropped  int64 `json:"writes_dropped"`
+}
+
+type shadowCounters struct {
+	reads, readMismatches, readErrors, readsDropped atomic.Int64
+	writes, writeErrors, writesDropped              atomic.Int64
+}
+
+// ShadowClient serves from a primary client and mirrors traffic to a
+// secondary one in the background, reporting where they diverge.
+type ShadowClient struct {
+	primary   Client
+	secondary Client
+	cfg       ShadowConfig
+	// random returns a float in [0, 1); replaced in tests.
+	random func() float64
+
+	readSlots chan struct{}
+	writes    chan func()
+	reads     sync.WaitGroup
+	done      chan struct{}
+	stop      chan struct{}
+	closeOnce sync.Once
+
+	stats shadowCounters
+}
+
+var _ Client = (*ShadowClient)(nil)
+
+// WithShadow wraps primary, which keeps serving every call, so that reads
+// are repeated on secondary and compared, and writes applied to it, as cfg
+// selects. It is meant for migrating to another store: divergences are
+// logged as "shadow:" lines and summed up every cfg.ReportEvery. Wrap the
+// stores beneath any cache, so that the entries compared are complete.
+// Close closes both clients.
+func WithShadow(primary, secondary Client, cfg ShadowConfig) *ShadowClient {
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 5 * time.Second
+	}
+	if cfg.ReportEvery <= 0 {
+		cfg.ReportEvery = time.Minute
+	}
+	c := &ShadowClient{
+		primary:   primary,
+		secondary: secondary,
+		cfg:       cfg,
+		random:    rand.Float64,
+		readSlots: make(chan struct{}, maxShadowReads),
+		writes:    make(chan func(), maxShadowWrites),
+		done:      make(chan struct{}),
+		stop:      make(chan struct{}),
+	}
+	go c.run()
+	return c
+}
+
+// run applies the mirrored writes in the order of the primary's, and
+// reports the counters, until Close.
+func (c *ShadowClient) run() {
+	defer close(c.done)
+	t := time.NewTicker(c.cfg.ReportEvery)
+	defer t.Stop()
+	var last ShadowStats
+	for {
+		select {
+		case write := <-c.writes:
+			write()
+		case <-t.C:
+			if st := c.Stats(); st != last {
+				log.Printf("shadow: %+v", st)
+				last = st
+			}
+		case <-c.stop:
+			for {
+				select {
+				case write := <-c.writes:
+					write()
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// Stats returns the counters so far.
+func (c *ShadowClient) Stats() ShadowStats {
+	return ShadowStats{
+		Reads:          c.stats.reads.Load(),
+		ReadMismatches: c.stats.readMismatches.Load(),
+		ReadErrors:     c.stats.readErrors.Load(),
+		ReadsDropped:   c.stats.readsDropped.Load(),
+		Writes:         c.stats.writes.Load(),
+		WriteErrors:    c.stats.writeErrors.Load(),
+		WritesDropped:  c.stats.writesDropped.Load(),
+	}
+}
+
+// Close implements Client. Queued writes are applied to the secondary
+// first.
+func (c *ShadowClient) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.stop)
+		<-c.done
+		c.reads.Wait()
+	})
+	return errors.Join(c.primary.Close(), c.secondary.Close())
+}
+
+// CreateEntry implements Client.
+func (c *ShadowClient) CreateEntry(ctx context.Context, key UrlKey, entry URLEntry) error {
+	if err := c.primary.CreateEntry(ctx, key, entry); err != nil {
+		return err
+	}
+	c.mirrorWrite("create", key, func(ctx context.Context) error {
+		return c.secondary.CreateEntry(ctx, key, entry)
+	})
+	return nil
+}
+
+// CreateEntries implements Client.
+func (c *ShadowClient) CreateEntries(ctx context.Context, entries []KeyedEntry) error {
+	if err := c.primary.CreateEntries(ctx, entries); err != nil {
+		return err
+	}
+	c.mirrorWrite("create_multi", joinKeys(entryKeys(entries)), func(ctx context.Context) error {
+		return c.secondary.CreateEntries(ctx, entries)
+	})
+	return nil
+}
+
+// GetEntry implements Client.
+func (c *ShadowClient) GetEntry(ctx context.Context, urlKey UrlKey) (URLEntry, error) {
+	entry, err := c.primary.GetEntry(ctx, urlKey)
+	if err == nil || errors.Is(err, ErrNotFound) {
+		c.mirrorRead(urlKey, entry, err)
+	}
+	return entry, err
+}
+
+// GetEntries implements Client. Each key is compared as a GetEntry.
+func (c *ShadowClient) GetEntries(ctx context.Context, urlKeys []UrlKey) (map[UrlKey]URLEntry, error) {
+	entries, err := c.primary.GetEntries(ctx, urlKeys)
+	if err != nil {
+		return nil, err
+	}
+	for _, k := range urlKeys {
+		if entry, ok := entries[k]; ok {
+			c.mirrorRead(k, entry, nil)
+		} else {
+			c.mirrorRead(k, URLEntry{}, ErrNotFound)
+		}
+	}
+	return entries, nil
+}
+
+// UpdateEntry implements Client. The secondary gets the entry as the
+// primary stored it, rather than update, which may not be repeatable.
+func (c *ShadowClient) UpdateEntry(ctx context.Context, urlKey UrlKey, update func(*URLEntry) error) error {
+	var updated URLEntry
+	err := c.primary.UpdateEntry(ctx, urlKey, func(e *URLEntry) error {
+		if err := update(e); err != nil {
+			return err
+		}
+		updated = *e
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	c.mirrorWrite("update", urlKey, func(ctx context.Context) error {
+		return c.replace(ctx, urlKey, updated)
+	})
+	return nil
+}
+
+// replace stores entry in the secondary, whatever it holds under key.
+func (c *ShadowClient) replace(ctx context.Context, key UrlKey, entry URLEntry) error {
+	err := c.secondary.UpdateEntry(ctx, key, func(e *URLEntry) error {
+		*e = entry
+		return nil
+	})
+	if !errors.Is(err, ErrNotFound) {
+		return err
+	}
+	// Missing, or inactive and so not updatable.
+	if err := c.secondary.DeleteEntry(ctx, key); err != nil {
+		return err
+	}
+	return c.secondary.CreateEntry(ctx, key, entry)
+}
+
+// DeleteEntry implements Client.
+func (c *ShadowClient) DeleteEntry(ctx context.Context, urlKey UrlKey) error {
+	if err := c.primary.DeleteEntry(ctx, urlKey); err != nil {
+		return err
+	}
+	c.mirrorWrite("delete", urlKey, func(ctx context.Context) error {
+		return c.secondary.DeleteEntry(ctx, urlKey)
+	})
+	return nil
+}
+
+// ListEntries implements Client. Listings are served by the primary alone;
+// their cursors are not portable between stores.
+func (c *ShadowClient) ListEntries(ctx context.Context, opts ListOptions) (ListPage, error) {
+	return c.primary.ListEntries(ctx, opts)
+}
+
+// CountEntries implements Client. Counts are those of the primary.
+func (c *ShadowClient) CountEntries(ctx context.Context) (StatusCounts, error) {
+	return c.primary.CountEntries(ctx)
+}
+
+func (c *ShadowClient) mirrorWrite(op string, key UrlKey, write func(context.Context) error) {
+	if !c.cfg.MirrorWrites {
+		return
+	}
+	apply := func() {
+		ctx, cancel := context.WithTimeout(context.Background(), c.cfg.Timeout)
+		defer cancel()
+		c.stats.writes.Add(1)
+		if err := write(ctx); err != nil {
+			c.stats.writeErrors.Add(1)
+			log.Printf("shadow: %s %q failed on the secondary: %v", op, key, err)
+		}
+	}
+	select {
+	case <-c.stop:
+		// Closing: the secondary is no longer written.
+		c.stats.writesDropped.Add(1)
+	case c.writes <- apply:
+	default:
+		c.stats.writesDropped.Add(1)
+		log.Printf("shadow: %s %q dropped, the secondary is behind", op, key)
+	}
+}
+
+func (c *ShadowClient) mirrorRead(key UrlKey, want URLEntry, wantErr error) {
+	if c.cfg.ReadRate <= 0 || c.random() >= c.cfg.ReadRate {
+		return
+	}
+	select {
+	case <-c.stop:
+		return
+	case c.readSlots <- struct{}{}:
+	default:
+		c.stats.readsDropped.Add(1)
+		return
+	}
+	c.reads.Add(1)
+	go func() {
+		defer func() {
+			<-c.readSlots
+			c.reads.Done()
+		}()
+		ctx, cancel := context.WithTimeout(context.Background(), c.cfg.Timeout)
+		defer cancel()
+		got, err := c.secondary.GetEntry(ctx, key)
+		c.stats.reads.Add(1)
+		switch {
+		case err != nil && !errors.Is(err, ErrNotFound):
+			c.stats.readErrors.Add(1)
+			log.Printf("shadow: get %q failed on the secondary: %v", key, err)
+		case (err == nil) != (wantErr == nil):
+			c.stats.readMismatches.Add(1)
+			log.Printf("shadow: get %q diverges: primary %v, secondary %v", key, found(wantErr), found(err))
+		case err == nil && !sameEntry(want, got):
+			c.stats.readMismatches.Add(1)
+			log.Printf("shadow: get %q diverges: entries differ", key)
+		}
+	}()
+}
+
+func found(err error) string {
+	if err != nil {
+		return "not found"
+	}
+	return "found"
+}
+
+// sameEntry compares entries as stored, ignoring the schema version either
+// store stamps on its own.
+func sameEntry(a, b URLEntry) bool {
+	a.SchemaVersion, b.SchemaVersion = 0, 0
+	ja, errA := json.Marshal(a)
+	jb, errB := json.Marshal(b)
+	return errA == nil && errB == nil && string(ja) == string(jb)
+}
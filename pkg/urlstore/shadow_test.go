@@ -0,0 +1,89 @@
+package urlstore_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/FlorinBalint/shortener/pkg/urlstore"
+	"github.com/FlorinBalint/shortener/pkg/urlstore/urlstoretest"
+)
+
+func TestShadowClient_Contract(t *testing.T) {
+	urlstoretest.Run(t, func(t *testing.T) urlstore.Client {
+		c := urlstore.WithShadow(urlstore.NewMemoryClient(), urlstore.NewMemoryClient(), urlstore.ShadowConfig{ReadRate: 1, MirrorWrites: true})
+		t.Cleanup(func() { c.Close() })
+		return c
+	})
+}
+
+func TestShadowClient_MirrorsWrites(t *testing.T) {
+	ctx := context.Background()
+	primary, secondary := urlstore.NewMemoryClient(), urlstore.NewMemoryClient()
+	c := urlstore.WithShadow(primary, secondary, urlstore.ShadowConfig{MirrorWrites: true})
+	for _, key := range []urlstore.UrlKey{"kept", "edited", "gone"} {
+		if err := c.CreateEntry(ctx, key, urlstore.URLEntry{URLTarget: "https://example.com/" + string(key)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := c.UpdateEntry(ctx, "edited", func(e *urlstore.URLEntry) error {
+		e.URLTarget = "https://example.com/v2"
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.DeleteEntry(ctx, "gone"); err != nil {
+		t.Fatal(err)
+	}
+	// An update failing on the primary is not mirrored.
+	failed := errors.New("rejected")
+	if err := c.UpdateEntry(ctx, "kept", func(*urlstore.URLEntry) error { return failed }); !errors.Is(err, failed) {
+		t.Fatalf("want the update error, got %v", err)
+	}
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[urlstore.UrlKey]string{"kept": "https://example.com/kept", "edited": "https://example.com/v2"}
+	for key, target := range want {
+		e, err := secondary.GetEntry(ctx, key)
+		if err != nil || e.URLTarget != target {
+			t.Errorf("secondary %s: got %q, %v, want %q", key, e.URLTarget, err, target)
+		}
+	}
+	if _, err := secondary.GetEntry(ctx, "gone"); !errors.Is(err, urlstore.ErrNotFound) {
+		t.Errorf("secondary gone: want ErrNotFound, got %v", err)
+	}
+	if st := c.Stats(); st.Writes != 5 || st.WriteErrors != 0 || st.Reads != 0 {
+		t.Errorf("unexpected stats %+v", st)
+	}
+}
+
+func TestShadowClient_ReportsDivergentReads(t *testing.T) {
+	ctx := context.Background()
+	primary, secondary := urlstore.NewMemoryClient(), urlstore.NewMemoryClient()
+	for key, target := range map[urlstore.UrlKey]string{"same": "https://example.com/a", "differs": "https://example.com/old", "missing": "https://example.com/m"} {
+		if err := primary.CreateEntry(ctx, key, urlstore.URLEntry{URLTarget: target}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	secondary.Seed("same", urlstore.URLEntry{URLTarget: "https://example.com/a"})
+	secondary.Seed("differs", urlstore.URLEntry{URLTarget: "https://example.com/new"})
+	secondary.Seed("extra", urlstore.URLEntry{URLTarget: "https://example.com/x"})
+
+	c := urlstore.WithShadow(primary, secondary, urlstore.ShadowConfig{ReadRate: 1})
+	for _, key := range []urlstore.UrlKey{"same", "differs", "missing", "extra"} {
+		// The secondary never changes what is served.
+		e, err := c.GetEntry(ctx, key)
+		if p, perr := primary.GetEntry(ctx, key); e.URLTarget != p.URLTarget || (err == nil) != (perr == nil) {
+			t.Fatalf("%s: served %q, %v; primary has %q, %v", key, e.URLTarget, err, p.URLTarget, perr)
+		}
+	}
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if st := c.Stats(); st.Reads != 4 || st.ReadMismatches != 3 || st.ReadErrors != 0 {
+		t.Errorf("unexpected stats %+v", st)
+	}
+}
@@ -0,0 +1,101 @@
+package urlstore
+
+import (
+	"fmt"
+	"time"
+)
+
+// Status is the state of an entry that listings filter and count by.
+type Status string
+
+// Statuses, which partition the stored entries.
+const (
+	// StatusActive entries are served and not flagged.
+	StatusActive Status = "active"
+	// StatusExpired entries are past their ExpiresAt, not deleted.
+	StatusExpired Status = "expired"
+	// StatusFlagged entries are served but flagged for review.
+	StatusFlagged Status = "flagged"
+	// StatusDeleted entries are soft-deleted.
+	StatusDeleted Status = "deleted"
+)
+
+// Statuses lists every status.
+var Statuses = []Status{StatusActive, StatusExpired, StatusFlagged, StatusDeleted}
+
+// ParseStatus parses a status name.
+func ParseStatus(s string) (Status, error) {
+	for _, st := range Statuses {
+		if string(st) == s {
+			return st, nil
+		}
+	}
+	return "", fmt.Errorf("status must be one of active, expired, flagged, deleted")
+}
+
+// Status returns the status of the entry at now. A deleted entry is
+// deleted whatever its expiry, and an expired one expired whatever its
+// flag.
+func (e URLEntry) Status(now time.Time) Status {
+	switch {
+	case e.Deleted():
+		return StatusDeleted
+	case e.Expired(now):
+		return StatusExpired
+	case e.Flagged():
+		return StatusFlagged
+	}
+	return StatusActive
+}
+
+// Flagged reports whether the entry was flagged for review.
+func (e URLEntry) Flagged() bool {
+	return e.Flag != ""
+}
+
+// state is the part of the status that does not depend on time, indexed
+// as "state": "deleted", "flagged" or "live". Expiry is indexed apart, as
+// "expires".
+func (e URLEntry) state() string {
+	switch {
+	case e.Deleted():
+		return "deleted"
+	case e.Flagged():
+		return "flagged"
+	}
+	return "live"
+}
+
+// neverExpires is the indexed expiry of entries without one, so that
+// they sort after every expiry.
+var neverExpires = time.Date(9999, 12, 31, 0, 0, 0, 0, time.UTC)
+
+// indexedExpiry returns the expiry indexed as "expires".
+func (e URLEntry) indexedExpiry() time.Time {
+	if e.ExpiresAt.IsZero() {
+		return neverExpires
+	}
+	return e.ExpiresAt
+}
+
+// StatusCounts counts entries by status.
+type StatusCounts struct {
+	Active  int64 `json:"active"`
+	Expired int64 `json:"expired"`
+	Flagged int64 `json:"flagged"`
+	Deleted int64 `json:"deleted"`
+}
+
+// Add counts n more entries in status s.
+func (c *StatusCounts) Add(s Status, n int64) {
+	switch s {
+	case StatusActive:
+		c.Active += n
+	case StatusExpired:
+		c.Expired += n
+	case StatusFlagged:
+		c.Flagged += n
+	case StatusDeleted:
+		c.Deleted += n
+	}
+}
@@ -0,0 +1,39 @@
+package urlstore
+
+import (
+	"testing"
+	"time"
+)
+
+func TestURLEntry_Status(t *testing.T) {
+	now := time.Now()
+	past := now.Add(-time.Minute)
+	tests := []struct {
+		name string
+		e    URLEntry
+		want Status
+	}{
+		{"active", URLEntry{}, StatusActive},
+		{"not yet expired", URLEntry{ExpiresAt: now.Add(time.Minute)}, StatusActive},
+		{"flagged", URLEntry{Flag: "spam"}, StatusFlagged},
+		{"expired", URLEntry{ExpiresAt: past}, StatusExpired},
+		{"expired though flagged", URLEntry{ExpiresAt: past, Flag: "spam"}, StatusExpired},
+		{"deleted though expired", URLEntry{ExpiresAt: past, DeletedAt: past}, StatusDeleted},
+	}
+	for _, tt := range tests {
+		if got := tt.e.Status(now); got != tt.want {
+			t.Errorf("%s: Status() = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestParseStatus(t *testing.T) {
+	for _, st := range Statuses {
+		if got, err := ParseStatus(string(st)); err != nil || got != st {
+			t.Errorf("ParseStatus(%q) = %q, %v", st, got, err)
+		}
+	}
+	if _, err := ParseStatus("archived"); err == nil {
+		t.Error("ParseStatus(archived): want an error")
+	}
+}
@@ -3,20 +3,110 @@ package urlstore
 import (
 	"context"
 	"log"
+	"strings"
+	"time"
 
 	"github.com/google/gomemcache/memcache"
+
+	"github.com/FlorinBalint/shortener/pkg/servertiming"
 )
 
+// Cache is the subset of the memcache client used by CachedClient.
+// *memcache.Client satisfies it; tests can substitute an in-memory fake.
+type Cache interface {
+	Get(key string) (*memcache.Item, error)
+	GetMulti(keys []string) (map[string]*memcache.Item, error)
+	Set(item *memcache.Item) error
+	Delete(key string) error
+}
+
+var _ Cache = (*memcache.Client)(nil)
+
+// WithKeyPrefix returns cache with every key prefixed, so that stores
+// sharing a memcache cluster, such as those of different tenants, keep
+// their entries apart.
+func WithKeyPrefix(cache Cache, prefix string) Cache {
+	return prefixedCache{cache: cache, prefix: prefix}
+}
+
+type prefixedCache struct {
+	cache  Cache
+	prefix string
+}
+
+func (c prefixedCache) Get(key string) (*memcache.Item, error) {
+	return c.cache.Get(c.prefix + key)
+}
+
+func (c prefixedCache) GetMulti(keys []string) (map[string]*memcache.Item, error) {
+	prefixed := make([]string, len(keys))
+	for i, key := range keys {
+		prefixed[i] = c.prefix + key
+	}
+	items, err := c.cache.GetMulti(prefixed)
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[string]*memcache.Item, len(items))
+	for key, it := range items {
+		out[strings.TrimPrefix(key, c.prefix)] = it
+	}
+	return out, nil
+}
+
+func (c prefixedCache) Set(item *memcache.Item) error {
+	prefixed := *item
+	prefixed.Key = c.prefix + item.Key
+	return c.cache.Set(&prefixed)
+}
+
+func (c prefixedCache) Delete(key string) error {
+	return c.cache.Delete(c.prefix + key)
+}
+
+// cacheVersion starts the memcache keys of CachedClient with the format of
+// the values it caches, now the bare target: bump it with any change to
+// that format, so replicas of different releases sharing memcache during a
+// rolling upgrade never read each other's values.
+const cacheVersion = "v1:"
+
+// pastCacheVersions are the versions replicas of the previous release may
+// still read: updates and deletes invalidate those copies too, lest such
+// replicas serve them stale until they are replaced. "" is the unversioned
+// keys of the releases before cacheVersion. Drop a version once no replica
+// reads it.
+var pastCacheVersions = []string{""}
+
+// cacheKey returns the memcache key of key.
+func cacheKey(key UrlKey) string {
+	return cacheVersion + string(key)
+}
+
+// maxRelativeExpiration is the largest expiration memcached interprets as
+// relative seconds; larger values are treated as unix timestamps.
+const maxRelativeExpiration = 30 * 24 * time.Hour
+
 type CachedClient struct {
 	underlying Client
-	cache      *memcache.Client
+	cache      Cache
+	counters   cacheCounters
 }
 
 var _ Client = (*CachedClient)(nil)
 
+// WithCacheAside wraps underlying with a cache-aside layer backed by cache.
+func WithCacheAside(underlying Client, cache Cache) *CachedClient {
+	return &CachedClient{
+		underlying: underlying,
+		cache:      cache,
+	}
+}
+
 // Close implements Client.
 func (c *CachedClient) Close() error {
-	c.cache.StopPolling()
+	if mc, ok := c.cache.(*memcache.Client); ok && mc.StopPolling != nil {
+		mc.StopPolling()
+	}
 	return c.underlying.Close()
 }
 
@@ -26,45 +116,193 @@ func (c *CachedClient) CreateEntry(ctx context.Context, key UrlKey, entry URLEnt
 	if err != nil {
 		return err
 	}
-	err = c.cache.Set(&memcache.Item{
-		Key:   string(key),
-		Value: []byte(entry.URLTarget),
-	})
-	if err != nil {
-		// cache set failed, but we have the value, so just log and continue
-		log.Printf("memcache set failed: %v", err) // --- IGNORE ---
+	c.setCached(key, entry, 0)
+	return nil
+}
+
+// CreateEntries implements Client.
+func (c *CachedClient) CreateEntries(ctx context.Context, entries []KeyedEntry) error {
+	if err := c.underlying.CreateEntries(ctx, entries); err != nil {
+		return err
+	}
+	for _, ke := range entries {
+		c.setCached(ke.Key, ke.Entry, 0)
 	}
 	return nil
 }
 
-// GetEntry implements Client.
+// GetEntry implements Client. Entries read stale (see WithReadStaleness)
+// are cached for no longer than the staleness, as the invalidation of an
+// edit may have come before them.
 func (c *CachedClient) GetEntry(ctx context.Context, urlKey UrlKey) (URLEntry, error) {
-	item, err := c.cache.Get(string(urlKey))
+	start := time.Now()
+	item, err := c.cache.Get(cacheKey(urlKey))
+	c.counters.timed(start)
+	servertiming.Since(ctx, servertiming.Cache, start)
 	if err == nil {
+		c.observe(ctx, true)
 		return URLEntry{URLTarget: string(item.Value)}, nil
 	}
 	if err == memcache.ErrCacheMiss {
+		c.observe(ctx, false)
 		entry, err := c.underlying.GetEntry(ctx, urlKey)
 		if err != nil {
 			return URLEntry{}, err
 		}
-		err = c.cache.Set(&memcache.Item{
-			Key:   string(urlKey),
-			Value: []byte(entry.URLTarget),
-		})
-		if err != nil {
-			// cache set failed, but we have the value, so just log and continue
-			log.Printf("memcache set failed: %v", err) // --- IGNORE ---
-		}
+		c.setCached(urlKey, entry, ReadStaleness(ctx))
 		return entry, nil
 	} else {
+		c.counters.errors.Add(1)
 		return URLEntry{}, err
 	}
 }
 
-func newCachedClient(underlying Client, cache *memcache.Client) *CachedClient {
-	return &CachedClient{
-		underlying: underlying,
-		cache:      cache,
+// GetEntries implements Client. The keys missing from the cache are
+// looked up below in one batch and cached, like GetEntry caches them.
+func (c *CachedClient) GetEntries(ctx context.Context, urlKeys []UrlKey) (map[UrlKey]URLEntry, error) {
+	names := make([]string, len(urlKeys))
+	for i, k := range urlKeys {
+		names[i] = cacheKey(k)
+	}
+	start := time.Now()
+	items, err := c.cache.GetMulti(names)
+	c.counters.timed(start)
+	servertiming.Since(ctx, servertiming.Cache, start)
+	if err != nil {
+		c.counters.errors.Add(1)
+		return nil, err
+	}
+	entries := make(map[UrlKey]URLEntry, len(urlKeys))
+	var missed []UrlKey
+	for _, k := range urlKeys {
+		if it, ok := items[cacheKey(k)]; ok {
+			c.observe(ctx, true)
+			entries[k] = URLEntry{URLTarget: string(it.Value)}
+			continue
+		}
+		c.observe(ctx, false)
+		missed = append(missed, k)
+	}
+	if len(missed) == 0 {
+		return entries, nil
+	}
+	found, err := c.underlying.GetEntries(ctx, missed)
+	if err != nil {
+		return nil, err
+	}
+	for k, entry := range found {
+		c.setCached(k, entry, ReadStaleness(ctx))
+		entries[k] = entry
+	}
+	return entries, nil
+}
+
+// UpdateEntry implements Client. The cached copy is invalidated rather than
+// rewritten, so a concurrent reader repopulates it from the store.
+func (c *CachedClient) UpdateEntry(ctx context.Context, urlKey UrlKey, update func(*URLEntry) error) error {
+	if err := c.underlying.UpdateEntry(ctx, urlKey, update); err != nil {
+		return err
+	}
+	c.invalidate(urlKey)
+	return nil
+}
+
+// DeleteEntry implements Client.
+func (c *CachedClient) DeleteEntry(ctx context.Context, urlKey UrlKey) error {
+	if err := c.underlying.DeleteEntry(ctx, urlKey); err != nil {
+		return err
+	}
+	c.invalidate(urlKey)
+	return nil
+}
+
+// ListEntries implements Client. Listing always reads the underlying store.
+func (c *CachedClient) ListEntries(ctx context.Context, opts ListOptions) (ListPage, error) {
+	return c.underlying.ListEntries(ctx, opts)
+}
+
+// CountEntries implements Client. Counting always reads the underlying
+// store.
+func (c *CachedClient) CountEntries(ctx context.Context) (StatusCounts, error) {
+	return c.underlying.CountEntries(ctx)
+}
+
+// CacheStats returns the lookups in memcache since the client was
+// created, and their mean latency.
+func (c *CachedClient) CacheStats() CacheStats {
+	return c.counters.stats()
+}
+
+func (c *CachedClient) observe(ctx context.Context, hit bool) {
+	observeCache(ctx, "memcache", hit)
+	c.counters.lookup(hit)
+}
+
+func (c *CachedClient) invalidate(key UrlKey) {
+	for _, version := range append([]string{cacheVersion}, pastCacheVersions...) {
+		if err := c.cache.Delete(version + string(key)); err != nil && err != memcache.ErrCacheMiss {
+			// the store is authoritative; a stale cache entry expires on its own
+			log.Printf("memcache delete failed: %v", err)
+		}
+	}
+}
+
+// setCached stores the target entry serves now in the cache, bounding its
+// lifetime by the entry expiry, the next switch of its schedule and the
+// staleness of the read it comes from, if stale is positive.
+func (c *CachedClient) setCached(key UrlKey, entry URLEntry, stale time.Duration) {
+	now := time.Now()
+	expiration, ok := cacheExpiration(entry, now)
+	if !ok {
+		return
+	}
+	if stale > 0 {
+		// A stale read may return an entry as it was before an edit whose
+		// invalidation already passed, so the copy must expire on its own;
+		// absolute expirations are all later than the limit.
+		limit := int32(stale / time.Second)
+		if limit <= 0 {
+			return
+		}
+		if expiration == 0 || expiration > limit {
+			expiration = limit
+		}
+	}
+	err := c.cache.Set(&memcache.Item{
+		Key:        cacheKey(key),
+		Value:      []byte(entry.TargetAt(now)),
+		Expiration: expiration,
+	})
+	if err != nil {
+		// cache set failed, but we have the value, so just log and continue
+		log.Printf("memcache set failed: %v", err)
+	}
+}
+
+// cacheExpiration returns the memcache expiration for entry and whether it
+// should be cached at all (expired and deleted entries are not, nor entries
+// that need more than their target to be served). Scheduled entries are
+// cached until their target next switches.
+func cacheExpiration(entry URLEntry, now time.Time) (int32, bool) {
+	if entry.Deleted() || !entry.TargetOnly() {
+		return 0, false
+	}
+	end := entry.ExpiresAt
+	if next := entry.NextSwitch(now); !next.IsZero() && (end.IsZero() || next.Before(end)) {
+		end = next
+	}
+	if end.IsZero() {
+		return 0, true
+	}
+	ttl := end.Sub(now)
+	if ttl > maxRelativeExpiration {
+		return int32(end.Unix()), true
+	}
+	// Round down so the cached copy never outlives the entry; an expiration
+	// of 0 would mean "never", so sub-second lifetimes are not cached.
+	seconds := int32(ttl / time.Second)
+	if seconds <= 0 {
+		return 0, false
 	}
+	return seconds, true
 }
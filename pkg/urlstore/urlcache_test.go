@@ -0,0 +1,93 @@
+package urlstore
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/gomemcache/memcache"
+)
+
+func TestCachedClient_VersionedKeys(t *testing.T) {
+	ctx := context.Background()
+	cache := &mapCache{items: make(map[string]*memcache.Item)}
+	c := WithCacheAside(NewMemoryClient(), cache)
+	if err := c.CreateEntry(ctx, "promo", URLEntry{URLTarget: "https://example.com/new"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := cache.items[cacheVersion+"promo"]; !ok {
+		t.Fatalf("want the entry cached under %q, got %v", cacheVersion+"promo", cache.items)
+	}
+
+	// A replica of an older release caches another format under its own
+	// key, which this one never reads.
+	cache.items["promo"] = &memcache.Item{Key: "promo", Value: []byte("not a target")}
+	e, err := c.GetEntry(ctx, "promo")
+	if err != nil || e.URLTarget != "https://example.com/new" {
+		t.Fatalf("GetEntry = %+v, %v", e, err)
+	}
+	es, err := c.GetEntries(ctx, []UrlKey{"promo"})
+	if err != nil || es["promo"].URLTarget != "https://example.com/new" {
+		t.Fatalf("GetEntries = %+v, %v", es, err)
+	}
+
+	// Edits invalidate the copies of past versions too.
+	if err := c.UpdateEntry(ctx, "promo", func(e *URLEntry) error {
+		e.URLTarget = "https://example.com/newer"
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(cache.items) != 0 {
+		t.Errorf("want every version invalidated, got %v", cache.items)
+	}
+}
+
+func TestCachedClient_StaleReadsExpire(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryClient()
+	if err := store.CreateEntry(ctx, "promo", URLEntry{URLTarget: "https://example.com/"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.CreateEntry(ctx, "soon", URLEntry{URLTarget: "https://example.com/", ExpiresAt: time.Now().Add(10 * time.Second)}); err != nil {
+		t.Fatal(err)
+	}
+	cache := &mapCache{items: make(map[string]*memcache.Item)}
+	c := WithCacheAside(store, cache)
+
+	// Strong reads cache links without expiry for good, as edits
+	// invalidate them.
+	if _, err := c.GetEntry(ctx, "promo"); err != nil {
+		t.Fatal(err)
+	}
+	if got := cache.items[cacheKey("promo")].Expiration; got != 0 {
+		t.Errorf("strong read: want no expiration, got %d", got)
+	}
+
+	// Stale ones cache them for the staleness at most.
+	stale := WithReadStaleness(ctx, time.Minute)
+	for _, tc := range []struct {
+		key  UrlKey
+		want int32
+	}{
+		{"promo", 60},
+		{"soon", 9},
+	} {
+		delete(cache.items, cacheKey(tc.key))
+		if _, err := c.GetEntries(stale, []UrlKey{tc.key}); err != nil {
+			t.Fatal(err)
+		}
+		if got := cache.items[cacheKey(tc.key)].Expiration; got != tc.want {
+			t.Errorf("stale read of %s: want expiration %d, got %d", tc.key, tc.want, got)
+		}
+	}
+
+	// Too short a staleness to express in seconds caches nothing.
+	delete(cache.items, cacheKey("promo"))
+	if _, err := c.GetEntry(WithReadStaleness(ctx, time.Millisecond), "promo"); err != nil {
+		t.Fatal(err)
+	}
+	if it, ok := cache.items[cacheKey("promo")]; ok {
+		t.Errorf("sub-second staleness: want nothing cached, got %+v", it)
+	}
+}
@@ -2,17 +2,170 @@ package urlstore
 
 import (
 	ctx "context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net/url"
+	"strings"
 	"time"
 
+	"cloud.google.com/go/datastore"
+	"golang.org/x/sync/errgroup"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
 	"github.com/FlorinBalint/shortener/pkg/gcputil"
-	"github.com/google/gomemcache/memcache"
+)
+
+// Errors returned by every Client implementation.
+var (
+	ErrNotFound      = errors.New("url entry not found")
+	ErrAlreadyExists = errors.New("url entry already exists")
+	ErrInvalidCursor = errors.New("invalid list cursor")
 )
 
 // Client is the interface for URL storage.
 type Client interface {
 	Close() error
+	// CreateEntry stores a new entry and fails with ErrAlreadyExists if the key is taken.
+	// Entries are stored with CurrentSchemaVersion.
 	CreateEntry(ctx ctx.Context, key UrlKey, entry URLEntry) error
+	// CreateEntries stores several new entries atomically: if any key is
+	// taken it fails with ErrAlreadyExists and stores none.
+	CreateEntries(ctx ctx.Context, entries []KeyedEntry) error
+	// GetEntry returns the entry stored under urlKey, or ErrNotFound if it is
+	// missing or expired.
 	GetEntry(ctx ctx.Context, urlKey UrlKey) (URLEntry, error)
+	// GetEntries looks several keys up at once and returns the entries
+	// found, by key; missing and expired keys are left out.
+	GetEntries(ctx ctx.Context, urlKeys []UrlKey) (map[UrlKey]URLEntry, error)
+	// UpdateEntry atomically applies update to the entry stored under urlKey.
+	// It returns ErrNotFound if the entry is missing or expired, and the error
+	// returned by update (without writing anything) if update fails.
+	// update may be called more than once if the write is retried. The entry
+	// is upgraded to CurrentSchemaVersion before update sees it, and entries
+	// of a newer schema fail with ErrNewerSchema.
+	UpdateEntry(ctx ctx.Context, urlKey UrlKey, update func(*URLEntry) error) error
+	// DeleteEntry removes the entry stored under urlKey.
+	// Deleting a missing key is not an error.
+	DeleteEntry(ctx ctx.Context, urlKey UrlKey) error
+	// ListEntries returns a page of entries in the order selected by opts.
+	// Entries are returned as stored, at their own schema version.
+	ListEntries(ctx ctx.Context, opts ListOptions) (ListPage, error)
+	// CountEntries counts the stored entries by status.
+	CountEntries(ctx ctx.Context) (StatusCounts, error)
+}
+
+// defaultListLimit is used when ListOptions.Limit is not positive.
+const defaultListLimit = 100
+
+// ListOrder selects the order of ListEntries results.
+type ListOrder int
+
+const (
+	// OrderByKey lists entries by ascending key.
+	OrderByKey ListOrder = iota
+	// OrderByCreatedAsc lists the oldest entries first.
+	OrderByCreatedAsc
+	// OrderByCreatedDesc lists the newest entries first.
+	OrderByCreatedDesc
+)
+
+// ListOptions selects a page of entries.
+//
+// Cursor is the opaque NextCursor of a previous page listed with the same
+// options; empty starts from the beginning. Limit caps the entries scanned
+// for the page (default 100). Expired and soft-deleted entries, as well as
+// entries outside Prefix or the creation range, may be skipped after the
+// scan, so a page may hold fewer than Limit entries and still have a next
+// page.
+type ListOptions struct {
+	Cursor          string
+	Limit           int
+	IncludeInactive bool
+	Order           ListOrder
+	// Prefix keeps only keys starting with it.
+	Prefix string
+	// TargetHost keeps only entries whose target host, normalized with
+	// NormalizeHost, equals it.
+	TargetHost string
+	// Target keeps only entries whose target equals it once both are
+	// normalized with NormalizeTarget.
+	Target string
+	// CreatedAfter and CreatedBefore bound the creation time to the
+	// half-open range [CreatedAfter, CreatedBefore). Zero means unbounded.
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+	// Campaign keeps only entries attached to the campaign with this ID.
+	Campaign string
+	// Status keeps only entries in this status, expired and deleted ones
+	// included without IncludeInactive.
+	Status Status
+	// Owner keeps only entries owned by it, see URLEntry.Owner.
+	Owner string
+}
+
+// PageSize returns the entries to scan for a page: Limit, or the default.
+func (o ListOptions) PageSize() int {
+	if o.Limit <= 0 {
+		return defaultListLimit
+	}
+	return o.Limit
+}
+
+// Matches reports whether the entry passes the filters of o, liveness
+// included, for Client implementations to skip the others of a page.
+func (o ListOptions) Matches(key UrlKey, e URLEntry, now time.Time) bool {
+	if !strings.HasPrefix(string(key), o.Prefix) {
+		return false
+	}
+	if o.TargetHost != "" && e.TargetHost() != o.TargetHost {
+		return false
+	}
+	if o.Target != "" && e.TargetHash() != targetHash(o.Target) {
+		return false
+	}
+	if o.Campaign != "" && e.Campaign != o.Campaign {
+		return false
+	}
+	if o.Owner != "" && e.Owner != o.Owner {
+		return false
+	}
+	if !o.CreatedAfter.IsZero() && e.CreationTimestamp.Before(o.CreatedAfter) {
+		return false
+	}
+	if !o.CreatedBefore.IsZero() && !e.CreationTimestamp.Before(o.CreatedBefore) {
+		return false
+	}
+	if o.Status != "" {
+		return e.Status(now) == o.Status
+	}
+	return o.IncludeInactive || e.Live(now)
+}
+
+// ListPage is one page of ListEntries results.
+// NextCursor is empty when there are no more entries.
+type ListPage struct {
+	Entries    []KeyedEntry
+	NextCursor string
+}
+
+// KeyedEntry is an entry together with its key.
+type KeyedEntry struct {
+	Key   UrlKey
+	Entry URLEntry
+}
+
+// entryKeys returns the keys of entries, in order.
+func entryKeys(entries []KeyedEntry) []UrlKey {
+	keys := make([]UrlKey, len(entries))
+	for i, ke := range entries {
+		keys[i] = ke.Key
+	}
+	return keys
 }
 
 // DSClient is a minimal key->JSON datastore client.
@@ -34,15 +187,229 @@ func (c *DSClient) Close() error {
 }
 
 func (c *DSClient) CreateEntry(ctx ctx.Context, key UrlKey, entry URLEntry) error {
-	return gcputil.PutNewValue(c.client, ctx, "url_entry", string(key), entry)
+	entry.SchemaVersion = CurrentSchemaVersion
+	return mapDSError(gcputil.PutNewValue(c.client, ctx, "url_entry", string(key), entry))
 }
 
+// CreateEntries implements Client, in one Datastore transaction.
+func (c *DSClient) CreateEntries(ctx ctx.Context, entries []KeyedEntry) error {
+	values := make(map[string]URLEntry, len(entries))
+	for _, ke := range entries {
+		ke.Entry.SchemaVersion = CurrentSchemaVersion
+		values[string(ke.Key)] = ke.Entry
+	}
+	if len(values) < len(entries) {
+		// A key given twice is taken by its first entry.
+		return ErrAlreadyExists
+	}
+	return mapDSError(gcputil.PutNewValues(c.client, ctx, "url_entry", values))
+}
+
+// GetEntry implements Client. Under WithReadStaleness it reads stale, and
+// confirms misses with a strong read, so new links are found at once.
 func (c *DSClient) GetEntry(ctx ctx.Context, urlKey UrlKey) (URLEntry, error) {
-	return gcputil.GetValue[URLEntry](c.client, ctx, "url_entry", string(urlKey))
+	entry, err := c.getEntry(readAt(ctx), urlKey)
+	if errors.Is(err, ErrNotFound) && ReadStaleness(ctx) > 0 {
+		return c.getEntry(ctx, urlKey)
+	}
+	return entry, err
+}
+
+func (c *DSClient) getEntry(ctx ctx.Context, urlKey UrlKey) (URLEntry, error) {
+	entry, err := gcputil.GetValue[URLEntry](c.client, ctx, "url_entry", string(urlKey))
+	if err != nil {
+		return URLEntry{}, mapDSError(err)
+	}
+	if !entry.Live(time.Now()) {
+		return URLEntry{}, ErrNotFound
+	}
+	return entry, nil
+}
+
+// GetEntries implements Client, in one Datastore lookup. Under
+// WithReadStaleness it reads stale like GetEntry, and looks the keys
+// missed up again strongly, in a second lookup.
+func (c *DSClient) GetEntries(ctx ctx.Context, urlKeys []UrlKey) (map[UrlKey]URLEntry, error) {
+	entries, err := c.getEntries(readAt(ctx), urlKeys)
+	if err != nil || ReadStaleness(ctx) <= 0 || len(entries) == len(urlKeys) {
+		return entries, err
+	}
+	var missed []UrlKey
+	for _, k := range urlKeys {
+		if _, ok := entries[k]; !ok {
+			missed = append(missed, k)
+		}
+	}
+	found, err := c.getEntries(ctx, missed)
+	if err != nil {
+		return nil, err
+	}
+	maps.Copy(entries, found)
+	return entries, nil
+}
+
+func (c *DSClient) getEntries(ctx ctx.Context, urlKeys []UrlKey) (map[UrlKey]URLEntry, error) {
+	names := make([]string, len(urlKeys))
+	for i, k := range urlKeys {
+		names[i] = string(k)
+	}
+	values, err := gcputil.GetValues[URLEntry](c.client, ctx, "url_entry", names)
+	if err != nil {
+		return nil, mapDSError(err)
+	}
+	now := time.Now()
+	entries := make(map[UrlKey]URLEntry, len(values))
+	for name, entry := range values {
+		if entry.Live(now) {
+			entries[UrlKey(name)] = entry
+		}
+	}
+	return entries, nil
+}
+
+// readAt returns ctx reading at the time the staleness of ctx allows, if
+// any (see WithReadStaleness).
+func readAt(ctx ctx.Context) ctx.Context {
+	if d := ReadStaleness(ctx); d > 0 {
+		return gcputil.ReadAt(ctx, time.Now().Add(-d))
+	}
+	return ctx
+}
+
+func (c *DSClient) UpdateEntry(ctx ctx.Context, urlKey UrlKey, update func(*URLEntry) error) error {
+	err := gcputil.UpdateValue(c.client, ctx, "url_entry", string(urlKey), func(e *URLEntry) error {
+		if !e.Live(time.Now()) {
+			return ErrNotFound
+		}
+		if err := upgradeForWrite(e); err != nil {
+			return err
+		}
+		if err := update(e); err != nil {
+			return err
+		}
+		e.SchemaVersion = CurrentSchemaVersion
+		return nil
+	})
+	return mapDSError(err)
+}
+
+func (c *DSClient) DeleteEntry(ctx ctx.Context, urlKey UrlKey) error {
+	return mapDSError(c.client.Delete(ctx, "url_entry", string(urlKey)))
+}
+
+// ListEntries implements Client. The query is narrowed to the target,
+// target host, campaign, owner and status, bar its expiry, then to the prefix
+// when ordering by key or to the creation range when ordering by
+// creation; the other filters are applied to the scanned page. Searching
+// by host within a creation range or order needs the composite indexes in
+// deployments/index.yaml.
+func (c *DSClient) ListEntries(ctx ctx.Context, opts ListOptions) (ListPage, error) {
+	q := gcputil.ListQuery{Cursor: opts.Cursor, Limit: opts.PageSize()}
+	if opts.TargetHost != "" {
+		q.Filters = append(q.Filters, gcputil.Filter{Field: "target_host", Op: "=", Value: opts.TargetHost})
+	}
+	if opts.Target != "" {
+		q.Filters = append(q.Filters, gcputil.Filter{Field: "target_hash", Op: "=", Value: targetHash(opts.Target)})
+	}
+	if opts.Campaign != "" {
+		q.Filters = append(q.Filters, gcputil.Filter{Field: "campaign", Op: "=", Value: opts.Campaign})
+	}
+	if opts.Owner != "" {
+		q.Filters = append(q.Filters, gcputil.Filter{Field: "owner", Op: "=", Value: opts.Owner})
+	}
+	switch opts.Status {
+	case StatusActive:
+		q.Filters = append(q.Filters, gcputil.Filter{Field: "state", Op: "=", Value: "live"})
+	case StatusFlagged, StatusDeleted:
+		q.Filters = append(q.Filters, gcputil.Filter{Field: "state", Op: "=", Value: string(opts.Status)})
+	}
+	switch opts.Order {
+	case OrderByKey:
+		if len(q.Filters) == 0 {
+			q.NamePrefix = opts.Prefix
+		}
+	case OrderByCreatedAsc, OrderByCreatedDesc:
+		q.Order = "created"
+		if opts.Order == OrderByCreatedDesc {
+			q.Order = "-created"
+		}
+		if !opts.CreatedAfter.IsZero() {
+			q.Filters = append(q.Filters, gcputil.Filter{Field: "created", Op: ">=", Value: opts.CreatedAfter})
+		}
+		if !opts.CreatedBefore.IsZero() {
+			q.Filters = append(q.Filters, gcputil.Filter{Field: "created", Op: "<", Value: opts.CreatedBefore})
+		}
+	default:
+		return ListPage{}, fmt.Errorf("unknown list order %d", opts.Order)
+	}
+
+	values, next, err := gcputil.ListValues[URLEntry](c.client, ctx, "url_entry", q)
+	if err != nil {
+		return ListPage{}, mapDSError(err)
+	}
+	now := time.Now()
+	page := ListPage{NextCursor: next}
+	for _, v := range values {
+		if opts.Matches(UrlKey(v.Name), v.Value, now) {
+			page.Entries = append(page.Entries, KeyedEntry{Key: UrlKey(v.Name), Entry: v.Value})
+		}
+	}
+	return page, nil
+}
+
+// CountEntries implements Client, with aggregation queries on the indexed
+// state and expiry, which need the composite indexes in
+// deployments/index.yaml. Entries stored before those were indexed are
+// not counted until rewritten.
+func (c *DSClient) CountEntries(ctx ctx.Context) (StatusCounts, error) {
+	now := time.Now()
+	queries := []struct {
+		status  Status
+		filters []gcputil.Filter
+	}{
+		{StatusActive, []gcputil.Filter{{Field: "state", Op: "=", Value: "live"}, {Field: "expires", Op: ">", Value: now}}},
+		{StatusExpired, []gcputil.Filter{{Field: "state", Op: "=", Value: "live"}, {Field: "expires", Op: "<=", Value: now}}},
+		{StatusFlagged, []gcputil.Filter{{Field: "state", Op: "=", Value: "flagged"}, {Field: "expires", Op: ">", Value: now}}},
+		{StatusExpired, []gcputil.Filter{{Field: "state", Op: "=", Value: "flagged"}, {Field: "expires", Op: "<=", Value: now}}},
+		{StatusDeleted, []gcputil.Filter{{Field: "state", Op: "=", Value: "deleted"}}},
+	}
+	counts := make([]int64, len(queries))
+	var g errgroup.Group
+	for i, q := range queries {
+		g.Go(func() error {
+			var err error
+			counts[i], err = gcputil.CountValues(c.client, ctx, "url_entry", q.filters)
+			return err
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return StatusCounts{}, mapDSError(err)
+	}
+	var sc StatusCounts
+	for i, q := range queries {
+		sc.Add(q.status, counts[i])
+	}
+	return sc, nil
 }
 
-func (c *DSClient) WithCacheAside(cache *memcache.Client) *CachedClient {
-	return newCachedClient(c, cache)
+func (c *DSClient) WithCacheAside(cache Cache) *CachedClient {
+	return WithCacheAside(c, cache)
+}
+
+// mapDSError translates Datastore errors into the package errors.
+func mapDSError(err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, datastore.ErrNoSuchEntity):
+		return ErrNotFound
+	case status.Code(err) == codes.AlreadyExists:
+		return ErrAlreadyExists
+	case errors.Is(err, gcputil.ErrInvalidCursor):
+		return ErrInvalidCursor
+	default:
+		return err
+	}
 }
 
 type UrlKey string
@@ -50,4 +417,218 @@ type UrlKey string
 type URLEntry struct {
 	URLTarget         string    `json:"url_target"`
 	CreationTimestamp time.Time `json:"create_timestamp"`
+	// ExpiresAt is the moment after which the entry is no longer served.
+	// The zero value means the entry never expires.
+	ExpiresAt time.Time `json:"expires_at,omitzero"`
+	// Schedule lists targets served instead of URLTarget during windows
+	// of time, evaluated at redirect time (see TargetAt).
+	Schedule []ScheduledTarget `json:"schedule,omitempty"`
+	// Version counts edits made through the writer API, starting at 1.
+	// It is exposed as the entry's ETag.
+	Version int64 `json:"version,omitempty"`
+	// UpdatedAt is when the entry was last edited through the writer API;
+	// zero if it never was.
+	UpdatedAt time.Time `json:"updated_at,omitzero"`
+	// DeletedAt marks a soft-deleted entry, kept until purged by the janitor.
+	DeletedAt time.Time `json:"deleted_at,omitzero"`
+	// AllowedCIDRs restricts redirects to clients in these ranges; empty
+	// allows everyone.
+	AllowedCIDRs []string `json:"allowed_cidrs,omitempty"`
+	// AllowedReferers restricts redirects to requests coming from these
+	// sites (see pkg/referer); empty allows every referer.
+	AllowedReferers []string `json:"allowed_referers,omitempty"`
+	// Interstitial names the template of a page the reader shows instead
+	// of redirecting, which the visitor must click through, e.g. for a
+	// legal disclaimer; "default" is the reader's built-in page. Empty
+	// redirects at once.
+	Interstitial string `json:"interstitial,omitempty"`
+	// ReferrerPolicy is the Referrer-Policy of the redirect to the target,
+	// instead of the reader's.
+	ReferrerPolicy string `json:"referrer_policy,omitempty"`
+	// ForwardQuery appends the query of the short URL to the target, less
+	// the parameters the reader scrubs and those matching ScrubParams (see
+	// pkg/queryscrub).
+	ForwardQuery bool     `json:"forward_query,omitempty"`
+	ScrubParams  []string `json:"scrub_params,omitempty"`
+	// MaxRedirectsPerSecond caps the redirects through the link, per
+	// reader replica, e.g. at the request of a target's owner; 0 leaves
+	// it to the reader's cap.
+	MaxRedirectsPerSecond int `json:"max_redirects_per_second,omitempty"`
+	// Owner is the ID of the API key that created the entry, if any.
+	Owner string `json:"owner,omitempty"`
+	// Preview holds metadata scraped from the target page, if any.
+	Preview *LinkPreview `json:"preview,omitempty"`
+	// SchemaVersion is the version of the stored representation, see
+	// Migrations. Entries written before it existed are version 0.
+	SchemaVersion int `json:"schema_version,omitempty"`
+	// TargetIndex is set on stored entries whose URLTarget is encrypted,
+	// see WithEncryption.
+	TargetIndex *TargetIndex `json:"target_index,omitempty"`
+	// BurnAfterReading entries serve a single redirect, see Burn. They are
+	// never cached.
+	BurnAfterReading bool `json:"burn_after_reading,omitempty"`
+	// Campaign is the ID of the campaign the entry belongs to, if any (see
+	// pkg/campaign).
+	Campaign string `json:"campaign,omitempty"`
+	// Flag is why the entry was flagged for review, e.g. reported as
+	// phishing; empty if it is not. Flagged entries are still served.
+	Flag string `json:"flag,omitempty"`
+	// Notes is free text for the people managing the link.
+	Notes string `json:"notes,omitempty"`
+	// Metadata is a JSON object kept for integrating systems, e.g. ticket
+	// or CMS IDs. It is stored and returned as given, never interpreted.
+	Metadata json.RawMessage `json:"metadata,omitempty"`
+	// AliasOf makes the entry an alias of the entry stored under this key,
+	// its canonical entry, which serves it (see ResolveAlias). URLTarget
+	// is then empty.
+	AliasOf string `json:"alias_of,omitempty"`
+}
+
+// TargetIndex holds what listings need from an encrypted target, computed
+// before encryption: its normalized host and the digest of the normalized
+// target. Neither reveals the path or query, where credentials live.
+type TargetIndex struct {
+	Host string `json:"host,omitempty"`
+	Hash string `json:"hash"`
+}
+
+// LinkPreview describes the page an entry points to.
+type LinkPreview struct {
+	Title       string    `json:"title,omitempty"`
+	Description string    `json:"description,omitempty"`
+	FaviconURL  string    `json:"favicon_url,omitempty"`
+	FetchedAt   time.Time `json:"fetched_at"`
+}
+
+// Expired reports whether the entry has an expiry at or before now.
+func (e URLEntry) Expired(now time.Time) bool {
+	return !e.ExpiresAt.IsZero() && !now.Before(e.ExpiresAt)
+}
+
+// ModifiedAt is when what the entry describes last changed: its creation,
+// edit or preview scrape, whichever is latest.
+func (e URLEntry) ModifiedAt() time.Time {
+	t := e.CreationTimestamp
+	if e.UpdatedAt.After(t) {
+		t = e.UpdatedAt
+	}
+	if e.Preview != nil && e.Preview.FetchedAt.After(t) {
+		t = e.Preview.FetchedAt
+	}
+	return t
+}
+
+// Deleted reports whether the entry was soft-deleted.
+func (e URLEntry) Deleted() bool {
+	return !e.DeletedAt.IsZero()
+}
+
+// IndexedProperties implements gcputil.Indexed, so listings can be
+// ordered and filtered by creation time, searched by target host,
+// campaign or owner, and filtered and counted by status. Entries stored before a
+// property was added lack it until rewritten.
+func (e URLEntry) IndexedProperties() map[string]any {
+	return map[string]any{
+		"created":     e.CreationTimestamp,
+		"target_host": e.TargetHost(),
+		"target_hash": e.TargetHash(),
+		"campaign":    e.Campaign,
+		"owner":       e.Owner,
+		"state":       e.state(),
+		"expires":     e.indexedExpiry(),
+	}
+}
+
+// TargetHash returns the digest of the entry's normalized target, the same
+// for entries with equal targets, encrypted or not.
+func (e URLEntry) TargetHash() string {
+	if e.TargetIndex != nil {
+		return e.TargetIndex.Hash
+	}
+	return targetHash(e.URLTarget)
+}
+
+// targetHash indexes the normalized target for exact lookups. A digest is
+// indexed rather than the target itself, which may exceed the size limit
+// of indexed strings.
+func targetHash(target string) string {
+	sum := sha256.Sum256([]byte(NormalizeTarget(target)))
+	return hex.EncodeToString(sum[:])
+}
+
+// NormalizeTarget returns the canonical form of a target URL used for
+// exact matching: lowercase scheme and host, no trailing dot on the host,
+// no default port, no fragment and "/" for an empty path. Targets that do
+// not parse are only trimmed.
+func NormalizeTarget(target string) string {
+	target = strings.TrimSpace(target)
+	u, err := url.Parse(target)
+	if err != nil || u.Host == "" {
+		return target
+	}
+	u.Scheme = strings.ToLower(u.Scheme)
+	host := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+	if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+	if port := u.Port(); port != "" && !(u.Scheme == "http" && port == "80") && !(u.Scheme == "https" && port == "443") {
+		host += ":" + port
+	}
+	u.Host = host
+	u.Fragment = ""
+	u.RawFragment = ""
+	if u.Path == "" {
+		u.Path = "/"
+	}
+	return u.String()
+}
+
+// TargetHost returns the normalized host of the entry's target, or "" if
+// the target has no host.
+func (e URLEntry) TargetHost() string {
+	if e.TargetIndex != nil {
+		return e.TargetIndex.Host
+	}
+	u, err := url.Parse(e.URLTarget)
+	if err != nil {
+		return ""
+	}
+	return NormalizeHost(u.Hostname())
+}
+
+// NormalizeHost lowercases host and strips a trailing dot and a leading
+// "www." label, so equivalent spellings of a domain compare equal.
+func NormalizeHost(host string) string {
+	host = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(host)), ".")
+	return strings.TrimPrefix(host, "www.")
+}
+
+// TargetOnly reports whether serving the entry needs nothing but its
+// target, so that the redirect cache, which only holds targets, may keep it.
+func (e URLEntry) TargetOnly() bool {
+	return len(e.AllowedCIDRs) == 0 && len(e.AllowedReferers) == 0 && e.Interstitial == "" && !e.BurnAfterReading && e.AliasOf == "" &&
+		e.ReferrerPolicy == "" && !e.ForwardQuery && e.MaxRedirectsPerSecond == 0
+}
+
+// Burn atomically invalidates the entry stored under urlKey, soft-deleting
+// it, and returns it as it was. Of concurrent callers only one gets the
+// entry; the others, like every later caller, get ErrNotFound. Pass a
+// client without caches, whose reads could be stale.
+func Burn(ctx ctx.Context, c Client, urlKey UrlKey) (URLEntry, error) {
+	var burned URLEntry
+	err := c.UpdateEntry(ctx, urlKey, func(e *URLEntry) error {
+		burned = *e
+		e.DeletedAt = time.Now().UTC()
+		e.Version++
+		return nil
+	})
+	if err != nil {
+		return URLEntry{}, err
+	}
+	return burned, nil
+}
+
+// Live reports whether the entry should be served at now.
+func (e URLEntry) Live(now time.Time) bool {
+	return !e.Expired(now) && !e.Deleted()
 }
@@ -0,0 +1,571 @@
+// Package urlstoretest provides a conformance suite that every
+// urlstore.Client implementation is expected to pass.
+package urlstoretest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/FlorinBalint/shortener/pkg/urlstore"
+)
+
+// NewClientFunc returns a fresh, empty client for a single behavior.
+type NewClientFunc func(t *testing.T) urlstore.Client
+
+type behavior struct {
+	name string
+	// slow behaviors wait for real time to pass and are skipped in -short mode.
+	slow bool
+	run  func(t *testing.T, c urlstore.Client)
+}
+
+var behaviors = []behavior{
+	{name: "CreateThenGet", run: testCreateThenGet},
+	{name: "CreateConflict", run: testCreateConflict},
+	{name: "GetNotFound", run: testGetNotFound},
+	{name: "CreateEntries", run: testCreateEntries},
+	{name: "CreateEntriesAllOrNothing", run: testCreateEntriesAllOrNothing},
+	{name: "GetEntries", run: testGetEntries},
+	{name: "Update", run: testUpdate},
+	{name: "UpdateMissing", run: testUpdateMissing},
+	{name: "UpdateAborted", run: testUpdateAborted},
+	{name: "SchemaVersionStamped", run: testSchemaVersionStamped},
+	{name: "Delete", run: testDelete},
+	{name: "DeleteMissing", run: testDeleteMissing},
+	{name: "CreateAfterDelete", run: testCreateAfterDelete},
+	{name: "ExpiredNotFound", run: testExpiredNotFound},
+	{name: "NotYetExpired", run: testNotYetExpired},
+	{name: "ExpiresWhileStored", slow: true, run: testExpiresWhileStored},
+	{name: "SoftDeletedNotFound", run: testSoftDeletedNotFound},
+	{name: "RestrictionsSurviveReads", run: testRestrictionsSurviveReads},
+	{name: "BurnOnce", run: testBurnOnce},
+	{name: "AliasResolves", run: testAliasResolves},
+	{name: "ListPaginates", run: testListPaginates},
+	{name: "ListSkipsInactive", run: testListSkipsInactive},
+	{name: "ListInvalidCursor", run: testListInvalidCursor},
+	{name: "ListOrderedByCreation", run: testListOrderedByCreation},
+	{name: "ListCreatedRange", run: testListCreatedRange},
+	{name: "ListPrefix", run: testListPrefix},
+	{name: "ListTargetHost", run: testListTargetHost},
+	{name: "ListTarget", run: testListTarget},
+	{name: "ListCampaign", run: testListCampaign},
+	{name: "ListStatus", run: testListStatus},
+	{name: "CountByStatus", run: testCountByStatus},
+}
+
+// Run executes the conformance suite against clients built by newClient.
+func Run(t *testing.T, newClient NewClientFunc) {
+	for _, b := range behaviors {
+		t.Run(b.name, func(t *testing.T) {
+			if b.slow && testing.Short() {
+				t.Skip("skipping time-based behavior in short mode")
+			}
+			c := newClient(t)
+			b.run(t, c)
+		})
+	}
+}
+
+func entry(target string) urlstore.URLEntry {
+	return urlstore.URLEntry{
+		URLTarget:         target,
+		CreationTimestamp: time.Now().UTC(),
+	}
+}
+
+func mustCreate(t *testing.T, c urlstore.Client, key urlstore.UrlKey, e urlstore.URLEntry) {
+	t.Helper()
+	if err := c.CreateEntry(context.Background(), key, e); err != nil {
+		t.Fatalf("CreateEntry(%q): %v", key, err)
+	}
+}
+
+func wantTarget(t *testing.T, c urlstore.Client, key urlstore.UrlKey, target string) {
+	t.Helper()
+	got, err := c.GetEntry(context.Background(), key)
+	if err != nil {
+		t.Fatalf("GetEntry(%q): %v", key, err)
+	}
+	if got.URLTarget != target {
+		t.Fatalf("GetEntry(%q): want target %q, got %q", key, target, got.URLTarget)
+	}
+}
+
+func wantNotFound(t *testing.T, c urlstore.Client, key urlstore.UrlKey) {
+	t.Helper()
+	_, err := c.GetEntry(context.Background(), key)
+	if !errors.Is(err, urlstore.ErrNotFound) {
+		t.Fatalf("GetEntry(%q): want ErrNotFound, got %v", key, err)
+	}
+}
+
+func testCreateThenGet(t *testing.T, c urlstore.Client) {
+	mustCreate(t, c, "roundtrip", entry("https://example.com/a"))
+	wantTarget(t, c, "roundtrip", "https://example.com/a")
+}
+
+func testCreateConflict(t *testing.T, c urlstore.Client) {
+	mustCreate(t, c, "taken", entry("https://example.com/first"))
+	err := c.CreateEntry(context.Background(), "taken", entry("https://example.com/second"))
+	if !errors.Is(err, urlstore.ErrAlreadyExists) {
+		t.Fatalf("CreateEntry on existing key: want ErrAlreadyExists, got %v", err)
+	}
+	wantTarget(t, c, "taken", "https://example.com/first")
+}
+
+func testCreateEntries(t *testing.T, c urlstore.Client) {
+	err := c.CreateEntries(context.Background(), []urlstore.KeyedEntry{
+		{Key: "promo", Entry: entry("https://example.com/sale")},
+		{Key: "promo-2024", Entry: entry("https://example.com/sale")},
+	})
+	if err != nil {
+		t.Fatalf("CreateEntries: %v", err)
+	}
+	wantTarget(t, c, "promo", "https://example.com/sale")
+	wantTarget(t, c, "promo-2024", "https://example.com/sale")
+}
+
+func testCreateEntriesAllOrNothing(t *testing.T, c urlstore.Client) {
+	mustCreate(t, c, "taken", entry("https://example.com/first"))
+	err := c.CreateEntries(context.Background(), []urlstore.KeyedEntry{
+		{Key: "free", Entry: entry("https://example.com/second")},
+		{Key: "taken", Entry: entry("https://example.com/second")},
+	})
+	if !errors.Is(err, urlstore.ErrAlreadyExists) {
+		t.Fatalf("CreateEntries with a taken key: want ErrAlreadyExists, got %v", err)
+	}
+	wantNotFound(t, c, "free")
+	wantTarget(t, c, "taken", "https://example.com/first")
+}
+
+func testGetNotFound(t *testing.T, c urlstore.Client) {
+	wantNotFound(t, c, "missing")
+}
+
+func testGetEntries(t *testing.T, c urlstore.Client) {
+	mustCreate(t, c, "a", entry("https://example.com/a"))
+	mustCreate(t, c, "a/b/*", entry("https://example.com/b/{rest}"))
+	old := entry("https://example.com/old")
+	old.ExpiresAt = time.Now().Add(-time.Minute)
+	mustCreate(t, c, "old", old)
+	// Read one key first, so cached and uncached keys meet in the batch.
+	wantTarget(t, c, "a", "https://example.com/a")
+
+	for range 2 {
+		got, err := c.GetEntries(context.Background(), []urlstore.UrlKey{"a/b/c", "a/b/*", "missing", "old", "a"})
+		if err != nil {
+			t.Fatalf("GetEntries: %v", err)
+		}
+		if len(got) != 2 || got["a"].URLTarget != "https://example.com/a" || got["a/b/*"].URLTarget != "https://example.com/b/{rest}" {
+			t.Fatalf("GetEntries: want a and a/b/*, got %+v", got)
+		}
+	}
+}
+
+func testUpdate(t *testing.T, c urlstore.Client) {
+	mustCreate(t, c, "edited", entry("https://example.com/before"))
+	wantTarget(t, c, "edited", "https://example.com/before")
+	err := c.UpdateEntry(context.Background(), "edited", func(e *urlstore.URLEntry) error {
+		e.URLTarget = "https://example.com/after"
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("UpdateEntry: %v", err)
+	}
+	wantTarget(t, c, "edited", "https://example.com/after")
+}
+
+func testUpdateMissing(t *testing.T, c urlstore.Client) {
+	err := c.UpdateEntry(context.Background(), "missing", func(e *urlstore.URLEntry) error {
+		t.Fatalf("update called for a missing entry")
+		return nil
+	})
+	if !errors.Is(err, urlstore.ErrNotFound) {
+		t.Fatalf("UpdateEntry on missing key: want ErrNotFound, got %v", err)
+	}
+}
+
+func testSchemaVersionStamped(t *testing.T, c urlstore.Client) {
+	mustCreate(t, c, "stamped", entry("https://example.com/stamped"))
+	err := c.UpdateEntry(context.Background(), "stamped", func(e *urlstore.URLEntry) error {
+		if e.SchemaVersion != urlstore.CurrentSchemaVersion {
+			t.Errorf("update: want schema version %d, got %d", urlstore.CurrentSchemaVersion, e.SchemaVersion)
+		}
+		*e = urlstore.URLEntry{URLTarget: e.URLTarget}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("UpdateEntry: %v", err)
+	}
+	page, err := c.ListEntries(context.Background(), urlstore.ListOptions{})
+	if err != nil {
+		t.Fatalf("ListEntries: %v", err)
+	}
+	if len(page.Entries) != 1 || page.Entries[0].Entry.SchemaVersion != urlstore.CurrentSchemaVersion {
+		t.Fatalf("want the rewritten entry at schema version %d, got %+v", urlstore.CurrentSchemaVersion, page.Entries)
+	}
+}
+
+func testUpdateAborted(t *testing.T, c urlstore.Client) {
+	mustCreate(t, c, "kept", entry("https://example.com/kept"))
+	errAbort := errors.New("abort")
+	err := c.UpdateEntry(context.Background(), "kept", func(e *urlstore.URLEntry) error {
+		e.URLTarget = "https://example.com/discarded"
+		return errAbort
+	})
+	if !errors.Is(err, errAbort) {
+		t.Fatalf("UpdateEntry: want the update error, got %v", err)
+	}
+	wantTarget(t, c, "kept", "https://example.com/kept")
+}
+
+func testDelete(t *testing.T, c urlstore.Client) {
+	mustCreate(t, c, "doomed", entry("https://example.com/d"))
+	wantTarget(t, c, "doomed", "https://example.com/d")
+	if err := c.DeleteEntry(context.Background(), "doomed"); err != nil {
+		t.Fatalf("DeleteEntry: %v", err)
+	}
+	wantNotFound(t, c, "doomed")
+}
+
+func testDeleteMissing(t *testing.T, c urlstore.Client) {
+	if err := c.DeleteEntry(context.Background(), "never-created"); err != nil {
+		t.Fatalf("DeleteEntry on missing key: want nil, got %v", err)
+	}
+}
+
+func testCreateAfterDelete(t *testing.T, c urlstore.Client) {
+	mustCreate(t, c, "reused", entry("https://example.com/old"))
+	if err := c.DeleteEntry(context.Background(), "reused"); err != nil {
+		t.Fatalf("DeleteEntry: %v", err)
+	}
+	mustCreate(t, c, "reused", entry("https://example.com/new"))
+	wantTarget(t, c, "reused", "https://example.com/new")
+}
+
+func testExpiredNotFound(t *testing.T, c urlstore.Client) {
+	e := entry("https://example.com/old")
+	e.ExpiresAt = time.Now().Add(-time.Minute)
+	mustCreate(t, c, "expired", e)
+	wantNotFound(t, c, "expired")
+}
+
+func testNotYetExpired(t *testing.T, c urlstore.Client) {
+	e := entry("https://example.com/live")
+	e.ExpiresAt = time.Now().Add(time.Hour)
+	mustCreate(t, c, "live", e)
+	wantTarget(t, c, "live", "https://example.com/live")
+}
+
+func testExpiresWhileStored(t *testing.T, c urlstore.Client) {
+	e := entry("https://example.com/brief")
+	e.ExpiresAt = time.Now().Add(1500 * time.Millisecond)
+	mustCreate(t, c, "brief", e)
+	wantTarget(t, c, "brief", "https://example.com/brief")
+	time.Sleep(time.Until(e.ExpiresAt) + 100*time.Millisecond)
+	wantNotFound(t, c, "brief")
+}
+
+func testSoftDeletedNotFound(t *testing.T, c urlstore.Client) {
+	e := entry("https://example.com/gone")
+	e.DeletedAt = time.Now()
+	mustCreate(t, c, "soft-deleted", e)
+	wantNotFound(t, c, "soft-deleted")
+}
+
+func testRestrictionsSurviveReads(t *testing.T, c urlstore.Client) {
+	e := entry("https://intranet.example.com")
+	e.AllowedCIDRs = []string{"10.0.0.0/8"}
+	mustCreate(t, c, "restricted", e)
+	for i := 0; i < 2; i++ {
+		got, err := c.GetEntry(context.Background(), "restricted")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got.AllowedCIDRs) != 1 || got.AllowedCIDRs[0] != "10.0.0.0/8" {
+			t.Fatalf("read %d: want the restriction, got %+v", i, got)
+		}
+	}
+}
+
+func testBurnOnce(t *testing.T, c urlstore.Client) {
+	ctx := context.Background()
+	e := entry("https://example.com/secret")
+	e.BurnAfterReading = true
+	mustCreate(t, c, "one-shot", e)
+	for i := 0; i < 2; i++ {
+		got, err := c.GetEntry(ctx, "one-shot")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !got.BurnAfterReading {
+			t.Fatalf("read %d: want the burn flag, got %+v", i, got)
+		}
+	}
+
+	const callers = 8
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var burned []urlstore.URLEntry
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			got, err := urlstore.Burn(ctx, c, "one-shot")
+			if errors.Is(err, urlstore.ErrNotFound) {
+				return
+			}
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			mu.Lock()
+			burned = append(burned, got)
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	if len(burned) != 1 || burned[0].URLTarget != "https://example.com/secret" {
+		t.Fatalf("want exactly one caller to get the entry, got %+v", burned)
+	}
+	wantNotFound(t, c, "one-shot")
+}
+
+func testAliasResolves(t *testing.T, c urlstore.Client) {
+	ctx := context.Background()
+	mustCreate(t, c, "canonical", entry("https://example.com/c"))
+	mustCreate(t, c, "old-name", urlstore.URLEntry{AliasOf: "canonical", CreationTimestamp: time.Now()})
+	// Read twice, so cached copies are read back too.
+	for range 2 {
+		e, err := c.GetEntry(ctx, "old-name")
+		if err != nil || e.AliasOf != "canonical" {
+			t.Fatalf("GetEntry(old-name): want an alias of canonical, got %+v, %v", e, err)
+		}
+		key, e, err := urlstore.ResolveAlias(ctx, c, "old-name", e)
+		if err != nil || key != "canonical" || e.URLTarget != "https://example.com/c" {
+			t.Fatalf("ResolveAlias: want canonical, got %q %+v, %v", key, e, err)
+		}
+	}
+}
+
+func listAll(t *testing.T, c urlstore.Client, opts urlstore.ListOptions) map[urlstore.UrlKey]int {
+	t.Helper()
+	seen := map[urlstore.UrlKey]int{}
+	for pages := 0; ; pages++ {
+		if pages > 100 {
+			t.Fatalf("ListEntries did not terminate")
+		}
+		page, err := c.ListEntries(context.Background(), opts)
+		if err != nil {
+			t.Fatalf("ListEntries: %v", err)
+		}
+		for _, ke := range page.Entries {
+			seen[ke.Key]++
+		}
+		if page.NextCursor == "" {
+			return seen
+		}
+		opts.Cursor = page.NextCursor
+	}
+}
+
+func testListPaginates(t *testing.T, c urlstore.Client) {
+	const n = 7
+	for i := 0; i < n; i++ {
+		mustCreate(t, c, urlstore.UrlKey(fmt.Sprintf("page-%d", i)), entry("https://example.com"))
+	}
+	seen := listAll(t, c, urlstore.ListOptions{Limit: 3})
+	if len(seen) != n {
+		t.Fatalf("want %d distinct keys, got %d: %v", n, len(seen), seen)
+	}
+	for k, count := range seen {
+		if count != 1 {
+			t.Fatalf("key %q listed %d times", k, count)
+		}
+	}
+}
+
+func testListSkipsInactive(t *testing.T, c urlstore.Client) {
+	mustCreate(t, c, "active", entry("https://example.com/a"))
+	expired := entry("https://example.com/e")
+	expired.ExpiresAt = time.Now().Add(-time.Hour)
+	mustCreate(t, c, "expired", expired)
+	deleted := entry("https://example.com/d")
+	deleted.DeletedAt = time.Now()
+	mustCreate(t, c, "deleted", deleted)
+
+	if seen := listAll(t, c, urlstore.ListOptions{}); len(seen) != 1 || seen["active"] != 1 {
+		t.Fatalf("default listing: want only the active entry, got %v", seen)
+	}
+	if seen := listAll(t, c, urlstore.ListOptions{IncludeInactive: true}); len(seen) != 3 {
+		t.Fatalf("IncludeInactive listing: want 3 entries, got %v", seen)
+	}
+}
+
+// createStatuses stores an entry in every status, under its name, and a
+// second active one.
+func createStatuses(t *testing.T, c urlstore.Client) {
+	t.Helper()
+	mustCreate(t, c, "active", entry("https://example.com/a"))
+	mustCreate(t, c, "active-2", entry("https://example.com/a2"))
+	expired := entry("https://example.com/e")
+	expired.ExpiresAt = time.Now().Add(-time.Hour)
+	expired.Flag = "phishing"
+	mustCreate(t, c, "expired", expired)
+	flagged := entry("https://example.com/f")
+	flagged.Flag = "phishing"
+	mustCreate(t, c, "flagged", flagged)
+	deleted := entry("https://example.com/d")
+	deleted.DeletedAt = time.Now()
+	mustCreate(t, c, "deleted", deleted)
+}
+
+func testListStatus(t *testing.T, c urlstore.Client) {
+	createStatuses(t, c)
+	for _, order := range []urlstore.ListOrder{urlstore.OrderByKey, urlstore.OrderByCreatedDesc} {
+		for _, st := range []urlstore.Status{urlstore.StatusExpired, urlstore.StatusFlagged, urlstore.StatusDeleted} {
+			if seen := listAll(t, c, urlstore.ListOptions{Status: st, Order: order}); len(seen) != 1 || seen[urlstore.UrlKey(st)] != 1 {
+				t.Errorf("order %d, status %s: want only %q, got %v", order, st, st, seen)
+			}
+		}
+		if seen := listAll(t, c, urlstore.ListOptions{Status: urlstore.StatusActive, Order: order}); len(seen) != 2 || seen["flagged"] != 0 {
+			t.Errorf("order %d, status active: want the unflagged live entries, got %v", order, seen)
+		}
+	}
+}
+
+func testCountByStatus(t *testing.T, c urlstore.Client) {
+	createStatuses(t, c)
+	counts, err := c.CountEntries(context.Background())
+	if err != nil {
+		t.Fatalf("CountEntries: %v", err)
+	}
+	want := urlstore.StatusCounts{Active: 2, Expired: 1, Flagged: 1, Deleted: 1}
+	if counts != want {
+		t.Fatalf("CountEntries = %+v, want %+v", counts, want)
+	}
+}
+
+func testListInvalidCursor(t *testing.T, c urlstore.Client) {
+	_, err := c.ListEntries(context.Background(), urlstore.ListOptions{Cursor: "!not-a-cursor!"})
+	if !errors.Is(err, urlstore.ErrInvalidCursor) {
+		t.Fatalf("want ErrInvalidCursor, got %v", err)
+	}
+}
+
+// listKeys pages through a listing and returns the keys in listing order.
+func listKeys(t *testing.T, c urlstore.Client, opts urlstore.ListOptions) []urlstore.UrlKey {
+	t.Helper()
+	var keys []urlstore.UrlKey
+	for pages := 0; ; pages++ {
+		if pages > 100 {
+			t.Fatalf("ListEntries did not terminate")
+		}
+		page, err := c.ListEntries(context.Background(), opts)
+		if err != nil {
+			t.Fatalf("ListEntries: %v", err)
+		}
+		for _, ke := range page.Entries {
+			keys = append(keys, ke.Key)
+		}
+		if page.NextCursor == "" {
+			return keys
+		}
+		opts.Cursor = page.NextCursor
+	}
+}
+
+// createAged stores entries whose creation times increase with their index.
+func createAged(t *testing.T, c urlstore.Client, base time.Time, keys ...urlstore.UrlKey) {
+	t.Helper()
+	for i, k := range keys {
+		e := entry("https://example.com/" + string(k))
+		e.CreationTimestamp = base.Add(time.Duration(i) * time.Minute)
+		mustCreate(t, c, k, e)
+	}
+}
+
+func wantKeys(t *testing.T, got []urlstore.UrlKey, want ...urlstore.UrlKey) {
+	t.Helper()
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("want keys %v, got %v", want, got)
+	}
+}
+
+func testListOrderedByCreation(t *testing.T, c urlstore.Client) {
+	base := time.Now().UTC().Add(-time.Hour).Truncate(time.Second)
+	createAged(t, c, base, "c", "a", "d", "b")
+
+	wantKeys(t, listKeys(t, c, urlstore.ListOptions{Limit: 3}), "a", "b", "c", "d")
+	wantKeys(t, listKeys(t, c, urlstore.ListOptions{Limit: 3, Order: urlstore.OrderByCreatedAsc}), "c", "a", "d", "b")
+	wantKeys(t, listKeys(t, c, urlstore.ListOptions{Limit: 3, Order: urlstore.OrderByCreatedDesc}), "b", "d", "a", "c")
+}
+
+func testListCreatedRange(t *testing.T, c urlstore.Client) {
+	base := time.Now().UTC().Add(-time.Hour).Truncate(time.Second)
+	createAged(t, c, base, "r0", "r1", "r2", "r3")
+
+	opts := urlstore.ListOptions{
+		Limit:         2,
+		CreatedAfter:  base.Add(time.Minute),
+		CreatedBefore: base.Add(3 * time.Minute),
+	}
+	wantKeys(t, listKeys(t, c, opts), "r1", "r2")
+	opts.Order = urlstore.OrderByCreatedDesc
+	wantKeys(t, listKeys(t, c, opts), "r2", "r1")
+}
+
+func testListPrefix(t *testing.T, c urlstore.Client) {
+	base := time.Now().UTC().Add(-time.Hour).Truncate(time.Second)
+	createAged(t, c, base, "team/b", "other", "team/a", "teamx")
+
+	wantKeys(t, listKeys(t, c, urlstore.ListOptions{Limit: 1, Prefix: "team/"}), "team/a", "team/b")
+	wantKeys(t, listKeys(t, c, urlstore.ListOptions{Limit: 1, Prefix: "team/", Order: urlstore.OrderByCreatedDesc}), "team/a", "team/b")
+}
+
+func testListTargetHost(t *testing.T, c urlstore.Client) {
+	base := time.Now().UTC().Add(-time.Hour).Truncate(time.Second)
+	for i, target := range []string{
+		"https://www.Example.com/a",
+		"https://other.org/b",
+		"http://example.com./c?q=1",
+		"https://sub.example.com/d",
+	} {
+		e := entry(target)
+		e.CreationTimestamp = base.Add(time.Duration(i) * time.Minute)
+		mustCreate(t, c, urlstore.UrlKey(fmt.Sprintf("host-%d", i)), e)
+	}
+
+	wantKeys(t, listKeys(t, c, urlstore.ListOptions{TargetHost: "example.com"}), "host-0", "host-2")
+	wantKeys(t, listKeys(t, c, urlstore.ListOptions{TargetHost: "example.com", Order: urlstore.OrderByCreatedDesc}), "host-2", "host-0")
+}
+
+func testListTarget(t *testing.T, c urlstore.Client) {
+	for key, target := range map[urlstore.UrlKey]string{
+		"same-0": "https://example.com/page?id=1",
+		"same-1": "HTTPS://Example.COM:443/page?id=1#top",
+		"path":   "https://example.com/Page?id=1",
+		"query":  "https://example.com/page?id=2",
+	} {
+		mustCreate(t, c, key, entry(target))
+	}
+
+	wantKeys(t, listKeys(t, c, urlstore.ListOptions{Target: "https://example.com./page?id=1"}), "same-0", "same-1")
+}
+
+func testListCampaign(t *testing.T, c urlstore.Client) {
+	base := time.Now().UTC().Add(-time.Hour).Truncate(time.Second)
+	for i, campaign := range []string{"spring", "", "spring", "autumn"} {
+		e := entry(fmt.Sprintf("https://example.com/%d", i))
+		e.CreationTimestamp = base.Add(time.Duration(i) * time.Minute)
+		e.Campaign = campaign
+		mustCreate(t, c, urlstore.UrlKey(fmt.Sprintf("camp-%d", i)), e)
+	}
+
+	wantKeys(t, listKeys(t, c, urlstore.ListOptions{Limit: 1, Campaign: "spring"}), "camp-0", "camp-2")
+	wantKeys(t, listKeys(t, c, urlstore.ListOptions{Campaign: "spring", Order: urlstore.OrderByCreatedDesc}), "camp-2", "camp-0")
+}
@@ -0,0 +1,143 @@
+// Package viewtoken mints and verifies the short-lived tokens that open the
+// reader's informational endpoints, e.g. /preview/{key}, to clients when
+// they are exposed publicly. Without them anyone could walk the keyspace,
+// which sequential kubeflake keys make easy.
+//
+// A token is
+//
+//	<base64url claims>.<base64url signature>
+//
+// where the signature is an hmacsig signature of the claims, so tokens are
+// signed and rotated with the same "id:secret,..." keyrings as requests.
+package viewtoken
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/FlorinBalint/shortener/pkg/hmacsig"
+)
+
+const (
+	// DefaultTTL is the lifetime of tokens minted without one.
+	DefaultTTL = 5 * time.Minute
+	// MaxTTL bounds the lifetime of tokens.
+	MaxTTL = time.Hour
+)
+
+// Errors returned by Verify.
+var (
+	ErrMissing = errors.New("missing token")
+	ErrInvalid = errors.New("invalid token")
+	ErrExpired = errors.New("token expired")
+)
+
+// Claims are what a token grants.
+type Claims struct {
+	// ID identifies the token, e.g. for throttling; Mint sets it.
+	ID string `json:"jti"`
+	// Subject is who the token was minted for, for logs.
+	Subject string `json:"sub,omitempty"`
+	// Tenant is the tenant whose links the token opens, if any.
+	Tenant string `json:"tenant,omitempty"`
+	// Prefix restricts the token to keys starting with it; empty allows
+	// every key.
+	Prefix    string    `json:"prefix,omitempty"`
+	ExpiresAt time.Time `json:"exp"`
+}
+
+// Allows reports whether the claims open key.
+func (c Claims) Allows(key string) bool {
+	return strings.HasPrefix(key, c.Prefix)
+}
+
+// Mint returns a token for c, valid for ttl from now (DefaultTTL when
+// zero, at most MaxTTL), and the claims it carries.
+func Mint(k *hmacsig.Keyring, c Claims, ttl time.Duration, now time.Time) (string, Claims, error) {
+	if ttl <= 0 {
+		ttl = DefaultTTL
+	}
+	c.ExpiresAt = now.Add(min(ttl, MaxTTL)).UTC().Truncate(time.Second)
+	c.ID = rand.Text()
+	payload, err := json.Marshal(c)
+	if err != nil {
+		return "", Claims{}, err
+	}
+	sig := k.Sign(payload, now)
+	return base64.RawURLEncoding.EncodeToString(payload) + "." + base64.RawURLEncoding.EncodeToString([]byte(sig)), c, nil
+}
+
+// Verify checks token at now and returns its claims.
+func Verify(k *hmacsig.Keyring, token string, now time.Time) (Claims, error) {
+	if token == "" {
+		return Claims{}, ErrMissing
+	}
+	encPayload, encSig, ok := strings.Cut(token, ".")
+	if !ok {
+		return Claims{}, ErrInvalid
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encPayload)
+	if err != nil {
+		return Claims{}, ErrInvalid
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(encSig)
+	if err != nil {
+		return Claims{}, ErrInvalid
+	}
+	// Tokens are minted at most MaxTTL before they expire, so older
+	// signatures are expired whatever the claims say.
+	switch err := k.Verify(string(sig), payload, now, MaxTTL); {
+	case errors.Is(err, hmacsig.ErrExpired):
+		return Claims{}, ErrExpired
+	case err != nil:
+		return Claims{}, ErrInvalid
+	}
+	var c Claims
+	if err := json.Unmarshal(payload, &c); err != nil || c.ID == "" {
+		return Claims{}, ErrInvalid
+	}
+	if !now.Before(c.ExpiresAt) {
+		return Claims{}, ErrExpired
+	}
+	return c, nil
+}
+
+// Throttle bounds the requests each token makes per minute, in process:
+// a token spread over replicas gets the limit on each.
+type Throttle struct {
+	perMinute int
+
+	mu     sync.Mutex
+	window time.Time
+	counts map[string]int
+}
+
+// NewThrottle allows each token perMinute requests a minute; 0 allows
+// any number.
+func NewThrottle(perMinute int) *Throttle {
+	return &Throttle{perMinute: perMinute, counts: make(map[string]int)}
+}
+
+// Allow accounts a request made with token id at now. When the token is
+// over its limit it returns false and the time until the limit resets.
+func (t *Throttle) Allow(id string, now time.Time) (bool, time.Duration) {
+	if t.perMinute <= 0 {
+		return true, 0
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if window := now.Truncate(time.Minute); !window.Equal(t.window) {
+		t.window = window
+		clear(t.counts)
+	}
+	if t.counts[id] >= t.perMinute {
+		return false, t.window.Add(time.Minute).Sub(now)
+	}
+	t.counts[id]++
+	return true, 0
+}
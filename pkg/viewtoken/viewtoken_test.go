@@ -0,0 +1,84 @@
+package viewtoken
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/FlorinBalint/shortener/pkg/hmacsig"
+)
+
+func TestMintVerify(t *testing.T) {
+	old, err := hmacsig.ParseKeyring("k1:first")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rotated, err := hmacsig.ParseKeyring("k2:second,k1:first")
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	token, minted, err := Mint(old, Claims{Subject: "apikey:k", Prefix: "team/"}, 0, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !minted.ExpiresAt.After(now.Add(DefaultTTL-time.Second)) || minted.ID == "" {
+		t.Fatalf("unexpected claims: %+v", minted)
+	}
+
+	// Tokens of the old key verify after a rotation.
+	c, err := Verify(rotated, token, now.Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.ID != minted.ID || !c.Allows("team/a") || c.Allows("other") {
+		t.Fatalf("unexpected claims: %+v", c)
+	}
+
+	if _, err := Verify(old, token, now.Add(DefaultTTL)); !errors.Is(err, ErrExpired) {
+		t.Errorf("after expiry: want ErrExpired, got %v", err)
+	}
+	payload, sig, _ := strings.Cut(token, ".")
+	forged := strings.TrimSuffix(payload, "=") + "x." + sig
+	for _, bad := range []string{"garbage", "a.b", forged} {
+		if _, err := Verify(old, bad, now); !errors.Is(err, ErrInvalid) {
+			t.Errorf("%q: want ErrInvalid, got %v", bad, err)
+		}
+	}
+	if _, err := Verify(old, "", now); !errors.Is(err, ErrMissing) {
+		t.Errorf("empty: want ErrMissing, got %v", err)
+	}
+	other, _ := hmacsig.ParseKeyring("k1:other")
+	if _, err := Verify(other, token, now); !errors.Is(err, ErrInvalid) {
+		t.Errorf("wrong key: want ErrInvalid, got %v", err)
+	}
+
+	// Lifetimes are capped.
+	_, long, err := Mint(old, Claims{}, 24*time.Hour, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if long.ExpiresAt.After(now.Add(MaxTTL)) {
+		t.Errorf("want the lifetime capped to %v, expires %v", MaxTTL, long.ExpiresAt)
+	}
+}
+
+func TestThrottle(t *testing.T) {
+	th := NewThrottle(2)
+	now := time.Date(2026, 1, 1, 10, 0, 30, 0, time.UTC)
+	for i := range 2 {
+		if ok, _ := th.Allow("a", now); !ok {
+			t.Fatalf("request %d: want allowed", i)
+		}
+	}
+	if ok, wait := th.Allow("a", now); ok || wait != 30*time.Second {
+		t.Fatalf("over the limit: want denied for 30s, got %v, %v", ok, wait)
+	}
+	if ok, _ := th.Allow("b", now); !ok {
+		t.Fatal("other token: want allowed")
+	}
+	if ok, _ := th.Allow("a", now.Add(30*time.Second)); !ok {
+		t.Fatal("next minute: want allowed")
+	}
+}
@@ -0,0 +1,256 @@
+// Package writequeue defers link creations to a durable queue, so clients
+// are answered without waiting for the store: the writer publishes each
+// validated create, and a consumer applies it, redelivering the ones that
+// fail until they succeed.
+//
+// Writes are applied at least once and in no particular order; handlers
+// must treat a redelivered write as applied.
+package writequeue
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"sync"
+	"time"
+
+	pubsub "google.golang.org/api/pubsub/v1"
+
+	"github.com/FlorinBalint/shortener/pkg/urlstore"
+)
+
+// ErrFull is returned by Publish when the queue takes no more writes.
+var ErrFull = errors.New("write queue is full")
+
+// Write is a link creation waiting to be applied.
+type Write struct {
+	Key string `json:"key"`
+	// Tenant is the ID of the tenant owning the link, if any.
+	Tenant string            `json:"tenant,omitempty"`
+	Entry  urlstore.URLEntry `json:"entry"`
+	// Generated is set when Key was generated rather than chosen.
+	Generated bool `json:"generated,omitempty"`
+	// Aliases are custom keys created along with Key, all or none.
+	Aliases []string `json:"aliases,omitempty"`
+}
+
+// Handler applies a write. A failed write is delivered again later.
+type Handler func(ctx context.Context, w Write) error
+
+// Queue is a durable queue of writes.
+type Queue interface {
+	// Publish queues w, returning once it is durably stored.
+	Publish(ctx context.Context, w Write) error
+	// Receive calls h for queued writes until ctx is done.
+	Receive(ctx context.Context, h Handler) error
+}
+
+// PubSubQueue queues writes on a Pub/Sub topic and receives them from a
+// subscription to it. Failed writes are redelivered as the subscription's
+// retry policy says, and may go to its dead-letter topic.
+type PubSubQueue struct {
+	svc          *pubsub.Service
+	topic        string
+	subscription string
+}
+
+var _ Queue = (*PubSubQueue)(nil)
+
+// NewPubSubQueue returns the queue publishing to topic
+// ("projects/P/topics/T") and receiving from subscription
+// ("projects/P/subscriptions/S"), using the default credentials.
+func NewPubSubQueue(ctx context.Context, topic, subscription string) (*PubSubQueue, error) {
+	if topic == "" || subscription == "" {
+		return nil, fmt.Errorf("write queue needs a topic and a subscription")
+	}
+	svc, err := pubsub.NewService(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("pubsub client: %w", err)
+	}
+	return &PubSubQueue{svc: svc, topic: topic, subscription: subscription}, nil
+}
+
+// CheckPermissions verifies that the credentials may publish to the topic
+// and consume the subscription.
+func (q *PubSubQueue) CheckPermissions(ctx context.Context) error {
+	const publish, consume = "pubsub.topics.publish", "pubsub.subscriptions.consume"
+	tp, err := q.svc.Projects.Topics.TestIamPermissions(q.topic,
+		&pubsub.TestIamPermissionsRequest{Permissions: []string{publish}}).Context(ctx).Do()
+	if err != nil {
+		return fmt.Errorf("topic %s: %w", q.topic, err)
+	}
+	if !slices.Contains(tp.Permissions, publish) {
+		return fmt.Errorf("topic %s: missing %s", q.topic, publish)
+	}
+	sp, err := q.svc.Projects.Subscriptions.TestIamPermissions(q.subscription,
+		&pubsub.TestIamPermissionsRequest{Permissions: []string{consume}}).Context(ctx).Do()
+	if err != nil {
+		return fmt.Errorf("subscription %s: %w", q.subscription, err)
+	}
+	if !slices.Contains(sp.Permissions, consume) {
+		return fmt.Errorf("subscription %s: missing %s", q.subscription, consume)
+	}
+	return nil
+}
+
+// Publish implements Queue.
+func (q *PubSubQueue) Publish(ctx context.Context, w Write) error {
+	b, err := json.Marshal(w)
+	if err != nil {
+		return err
+	}
+	_, err = q.svc.Projects.Topics.Publish(q.topic, &pubsub.PublishRequest{
+		Messages: []*pubsub.PubsubMessage{{Data: base64.StdEncoding.EncodeToString(b)}},
+	}).Context(ctx).Do()
+	if err != nil {
+		return fmt.Errorf("publish to %s: %w", q.topic, err)
+	}
+	return nil
+}
+
+// pullBatch is the most messages pulled at once.
+const pullBatch = 10
+
+// Receive implements Queue. Messages that are not writes are logged and
+// acknowledged, so they are not redelivered forever.
+func (q *PubSubQueue) Receive(ctx context.Context, h Handler) error {
+	subs := q.svc.Projects.Subscriptions
+	for ctx.Err() == nil {
+		resp, err := subs.Pull(q.subscription, &pubsub.PullRequest{MaxMessages: pullBatch}).Context(ctx).Do()
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("write queue: pull from %s: %v", q.subscription, err)
+				sleep(ctx, time.Second)
+			}
+			continue
+		}
+		var acks, nacks []string
+		for _, m := range resp.ReceivedMessages {
+			w, err := decode(m.Message)
+			if err != nil {
+				log.Printf("write queue: dropping malformed message %s: %v", m.Message.MessageId, err)
+				acks = append(acks, m.AckId)
+				continue
+			}
+			if err := h(ctx, w); err != nil {
+				log.Printf("write queue: applying %q (attempt %d): %v", w.Key, m.DeliveryAttempt, err)
+				nacks = append(nacks, m.AckId)
+				continue
+			}
+			acks = append(acks, m.AckId)
+		}
+		// Settle with a context of its own: writes applied while shutting
+		// down are still acknowledged.
+		sctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 10*time.Second)
+		if len(acks) > 0 {
+			if _, err := subs.Acknowledge(q.subscription, &pubsub.AcknowledgeRequest{AckIds: acks}).Context(sctx).Do(); err != nil {
+				log.Printf("write queue: acknowledge %d messages: %v", len(acks), err)
+			}
+		}
+		if len(nacks) > 0 {
+			// A zero deadline has the messages redelivered now, backing off
+			// as the retry policy says.
+			if _, err := subs.ModifyAckDeadline(q.subscription, &pubsub.ModifyAckDeadlineRequest{AckIds: nacks}).Context(sctx).Do(); err != nil {
+				log.Printf("write queue: release %d messages: %v", len(nacks), err)
+			}
+		}
+		cancel()
+	}
+	return ctx.Err()
+}
+
+func decode(m *pubsub.PubsubMessage) (Write, error) {
+	var w Write
+	if m == nil {
+		return w, errors.New("no message")
+	}
+	b, err := base64.StdEncoding.DecodeString(m.Data)
+	if err != nil {
+		return w, err
+	}
+	err = json.Unmarshal(b, &w)
+	return w, err
+}
+
+// Backoff of MemoryQueue redeliveries.
+const (
+	minRetryDelay = 100 * time.Millisecond
+	maxRetryDelay = time.Minute
+)
+
+type delivery struct {
+	w        Write
+	attempts int
+}
+
+// MemoryQueue is an in-process Queue, for tests and local development. It
+// is not durable: queued writes are lost with the process. Failed writes
+// are redelivered with exponential backoff.
+type MemoryQueue struct {
+	ch      chan delivery
+	pending sync.WaitGroup
+}
+
+var _ Queue = (*MemoryQueue)(nil)
+
+// NewMemoryQueue returns a queue holding up to size writes.
+func NewMemoryQueue(size int) *MemoryQueue {
+	return &MemoryQueue{ch: make(chan delivery, size)}
+}
+
+// Publish implements Queue.
+func (q *MemoryQueue) Publish(ctx context.Context, w Write) error {
+	q.pending.Add(1)
+	select {
+	case q.ch <- delivery{w: w}:
+		return nil
+	default:
+		q.pending.Done()
+		return ErrFull
+	}
+}
+
+// Receive implements Queue.
+func (q *MemoryQueue) Receive(ctx context.Context, h Handler) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case d := <-q.ch:
+			if err := h(ctx, d.w); err != nil {
+				d.attempts++
+				log.Printf("write queue: applying %q (attempt %d): %v", d.w.Key, d.attempts, err)
+				time.AfterFunc(retryDelay(d.attempts), func() { q.ch <- d })
+				continue
+			}
+			q.pending.Done()
+		}
+	}
+}
+
+// retryDelay is the backoff after a write failed attempts times.
+func retryDelay(attempts int) time.Duration {
+	d := minRetryDelay
+	for i := 1; i < attempts && d < maxRetryDelay; i++ {
+		d *= 2
+	}
+	return min(d, maxRetryDelay)
+}
+
+// Wait blocks until every write published so far is applied.
+func (q *MemoryQueue) Wait() {
+	q.pending.Wait()
+}
+
+func sleep(ctx context.Context, d time.Duration) {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+	case <-t.C:
+	}
+}
@@ -0,0 +1,82 @@
+package writequeue
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	pubsub "google.golang.org/api/pubsub/v1"
+
+	"github.com/FlorinBalint/shortener/pkg/urlstore"
+)
+
+func TestMemoryQueue_RetriesFailedWrites(t *testing.T) {
+	q := NewMemoryQueue(2)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var mu sync.Mutex
+	attempts := make(map[string]int)
+	go q.Receive(ctx, func(ctx context.Context, w Write) error {
+		mu.Lock()
+		defer mu.Unlock()
+		attempts[w.Key]++
+		if w.Key == "flaky" && attempts[w.Key] < 3 {
+			return errors.New("datastore unavailable")
+		}
+		return nil
+	})
+
+	for _, key := range []string{"flaky", "fine"} {
+		if err := q.Publish(ctx, Write{Key: key}); err != nil {
+			t.Fatalf("publish %s: %v", key, err)
+		}
+	}
+	q.Wait()
+	mu.Lock()
+	defer mu.Unlock()
+	if attempts["flaky"] != 3 || attempts["fine"] != 1 {
+		t.Fatalf("want 3 attempts for flaky and 1 for fine, got %v", attempts)
+	}
+}
+
+func TestMemoryQueue_Full(t *testing.T) {
+	q := NewMemoryQueue(1)
+	if err := q.Publish(context.Background(), Write{Key: "a"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Publish(context.Background(), Write{Key: "b"}); !errors.Is(err, ErrFull) {
+		t.Fatalf("want ErrFull, got %v", err)
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	for attempts, want := range map[int]time.Duration{1: 100 * time.Millisecond, 2: 200 * time.Millisecond, 4: 800 * time.Millisecond, 100: time.Minute} {
+		if got := retryDelay(attempts); got != want {
+			t.Errorf("retryDelay(%d) = %v, want %v", attempts, got, want)
+		}
+	}
+}
+
+func TestDecode(t *testing.T) {
+	data := `{"key":"promo","tenant":"acme","entry":{"url_target":"https://example.com","create_timestamp":"2025-01-02T03:04:05Z"},"generated":true}`
+	w, err := decode(&pubsub.PubsubMessage{Data: base64.StdEncoding.EncodeToString([]byte(data))})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Write{
+		Key:       "promo",
+		Tenant:    "acme",
+		Entry:     urlstore.URLEntry{URLTarget: "https://example.com", CreationTimestamp: time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)},
+		Generated: true,
+	}
+	if w.Key != want.Key || w.Tenant != want.Tenant || w.Generated != want.Generated || w.Entry.URLTarget != want.Entry.URLTarget || !w.Entry.CreationTimestamp.Equal(want.Entry.CreationTimestamp) {
+		t.Fatalf("got %+v, want %+v", w, want)
+	}
+	if _, err := decode(&pubsub.PubsubMessage{Data: "not base64!"}); err == nil {
+		t.Fatal("want an error for malformed data")
+	}
+}
@@ -0,0 +1,142 @@
+package writer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/FlorinBalint/shortener/pkg/apikey"
+	"github.com/FlorinBalint/shortener/pkg/tenant"
+)
+
+const adminKeysPath = "/write/v1/admin/keys"
+
+// issuedKey is returned when a secret is created; it is never shown again.
+type issuedKey struct {
+	apikey.Key
+	Secret string `json:"secret"`
+}
+
+// Named handler for /write/v1/admin/keys (admin only):
+//
+//	GET  /write/v1/admin/keys                → list keys
+//	POST /write/v1/admin/keys                → issue a key ({"name":"...","tenant":"..."})
+//	POST /write/v1/admin/keys/{id}/rotate    → replace the key's secret
+//	POST /write/v1/admin/keys/{id}/freeze    → reject the key's writes
+//	POST /write/v1/admin/keys/{id}/unfreeze  → accept them again
+func (h *Handler) handleAdminKeys(w http.ResponseWriter, r *http.Request, rest string) {
+	admin, ok := h.requireAdmin(w, r)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	if rest == "" {
+		switch r.Method {
+		case http.MethodGet:
+			keys, err := h.keys.List(ctx)
+			if err != nil {
+				http.Error(w, "failed to list API keys", http.StatusInternalServerError)
+				return
+			}
+			writeJSON(w, http.StatusOK, map[string]any{"keys": keys})
+		case http.MethodPost:
+			var req struct {
+				Name   string `json:"name"`
+				Tenant string `json:"tenant"`
+			}
+			if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&req); err != nil {
+				http.Error(w, "invalid json body", http.StatusBadRequest)
+				return
+			}
+			if !h.checkKeyTenant(ctx, w, req.Tenant) {
+				return
+			}
+			key, secret, err := h.keys.Issue(ctx, req.Name, req.Tenant)
+			if err != nil {
+				http.Error(w, "failed to issue API key", http.StatusInternalServerError)
+				return
+			}
+			audit(r, admin, "apikey.issue", key.ID)
+			writeJSON(w, http.StatusCreated, issuedKey{Key: key, Secret: secret})
+		default:
+			w.Header().Set("Allow", "GET, POST")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+		return
+	}
+
+	id, action, ok := strings.Cut(rest, "/")
+	if !ok || id == "" {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var (
+		resp any
+		err  error
+	)
+	switch action {
+	case "rotate":
+		var key apikey.Key
+		var secret string
+		key, secret, err = h.keys.Rotate(ctx, id)
+		resp = issuedKey{Key: key, Secret: secret}
+	case "freeze", "unfreeze":
+		resp, err = h.keys.SetFrozen(ctx, id, action == "freeze")
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	switch {
+	case errors.Is(err, apikey.ErrNotFound):
+		http.NotFound(w, r)
+	case err != nil:
+		http.Error(w, "failed to update API key", http.StatusInternalServerError)
+	default:
+		audit(r, admin, "apikey."+action, id)
+		writeJSON(w, http.StatusOK, resp)
+	}
+}
+
+// checkKeyTenant validates the tenant of a new key: required and existing
+// in multi-tenant mode, empty otherwise. It reports an error to the client
+// and returns false when invalid.
+func (h *Handler) checkKeyTenant(ctx context.Context, w http.ResponseWriter, id string) bool {
+	if h.tenancy == nil {
+		if id != "" {
+			http.Error(w, "tenants are not enabled", http.StatusBadRequest)
+			return false
+		}
+		return true
+	}
+	if id == "" {
+		http.Error(w, "tenant is required", http.StatusBadRequest)
+		return false
+	}
+	_, err := h.tenancy.tenants.Get(ctx, id)
+	switch {
+	case errors.Is(err, tenant.ErrNotFound):
+		http.Error(w, "unknown tenant", http.StatusBadRequest)
+		return false
+	case err != nil:
+		http.Error(w, "failed to read tenant", http.StatusInternalServerError)
+		return false
+	}
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(v)
+}
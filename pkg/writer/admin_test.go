@@ -0,0 +1,75 @@
+package writer
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/FlorinBalint/shortener/pkg/urlstore"
+)
+
+func adminDo(h http.Handler, method, path, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer root")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestAdminKeys_Lifecycle(t *testing.T) {
+	h := NewHandlerWithStore(Config{AdminToken: "root"}, urlstore.NewMemoryClient())
+
+	rec := adminDo(h, http.MethodPost, "/write/v1/admin/keys", `{"name":"partner"}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("issue: want 201, got %d", rec.Code)
+	}
+	var issued issuedKey
+	if err := json.NewDecoder(rec.Body).Decode(&issued); err != nil {
+		t.Fatal(err)
+	}
+	if rec := createAs(h, issued.Secret, "k1"); rec.Code != http.StatusOK {
+		t.Fatalf("write with new key: want 200, got %d", rec.Code)
+	}
+
+	if rec := adminDo(h, http.MethodPost, "/write/v1/admin/keys/"+issued.ID+"/freeze", ""); rec.Code != http.StatusOK {
+		t.Fatalf("freeze: want 200, got %d", rec.Code)
+	}
+	if rec := createAs(h, issued.Secret, "k2"); rec.Code != http.StatusForbidden {
+		t.Fatalf("frozen key: want 403, got %d", rec.Code)
+	}
+	if rec := adminDo(h, http.MethodPost, "/write/v1/admin/keys/"+issued.ID+"/unfreeze", ""); rec.Code != http.StatusOK {
+		t.Fatalf("unfreeze: want 200, got %d", rec.Code)
+	}
+
+	rec = adminDo(h, http.MethodPost, "/write/v1/admin/keys/"+issued.ID+"/rotate", "")
+	var rotated issuedKey
+	if err := json.NewDecoder(rec.Body).Decode(&rotated); err != nil {
+		t.Fatal(err)
+	}
+	if rec := createAs(h, issued.Secret, "k3"); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("rotated-out secret: want 401, got %d", rec.Code)
+	}
+	if rec := createAs(h, rotated.Secret, "k3"); rec.Code != http.StatusOK {
+		t.Fatalf("rotated secret: want 200, got %d", rec.Code)
+	}
+
+	rec = adminDo(h, http.MethodGet, "/write/v1/admin/keys", "")
+	if rec.Code != http.StatusOK || strings.Contains(rec.Body.String(), rotated.Secret) {
+		t.Fatalf("list: want 200 without secrets, got %d: %s", rec.Code, rec.Body)
+	}
+	if !strings.Contains(rec.Body.String(), `"name":"partner"`) {
+		t.Fatalf("list: missing key: %s", rec.Body)
+	}
+}
+
+func TestAdminKeys_RequiresToken(t *testing.T) {
+	h := NewHandlerWithStore(Config{AdminToken: "root"}, urlstore.NewMemoryClient())
+	if rec := do(h, http.MethodGet, "/write/v1/admin/keys", "", ""); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("want 401, got %d", rec.Code)
+	}
+	if rec := adminDo(h, http.MethodPost, "/write/v1/admin/keys/missing/freeze", ""); rec.Code != http.StatusNotFound {
+		t.Fatalf("unknown key: want 404, got %d", rec.Code)
+	}
+}
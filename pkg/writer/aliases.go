@@ -0,0 +1,176 @@
+package writer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/FlorinBalint/shortener/pkg/urlstore"
+)
+
+// maxAliases caps the extra aliases of a create, all written in one
+// transaction.
+const maxAliases = 20
+
+// normalizeAliases validates the extra aliases of a create of key and
+// returns them normalized as key was.
+func (h *Handler) normalizeAliases(ctx context.Context, aliases []string, key string) ([]string, error) {
+	if len(aliases) > maxAliases {
+		return nil, fmt.Errorf("aliases has more than %d entries", maxAliases)
+	}
+	trail := trailFrom(ctx)
+	seen := map[string]bool{key: true}
+	out := make([]string, 0, len(aliases))
+	for _, raw := range aliases {
+		a := urlstore.NormalizeKey(raw)
+		trail.normalized("alias", raw, a, "trimmed")
+		if h.foldCase {
+			folded := strings.ToLower(a)
+			trail.normalized("alias", a, folded, "case folded")
+			a = folded
+		}
+		h.traceReserved(trail, "alias", a)
+		if err := urlstore.UrlKey(a).Validate(); err != nil {
+			return nil, fmt.Errorf("alias %q: %v", a, err)
+		}
+		if h.reservedAlias(a) {
+			return nil, fmt.Errorf("alias %q is reserved", a)
+		}
+		if seen[a] {
+			return nil, fmt.Errorf("alias %q is given twice", a)
+		}
+		seen[a] = true
+		out = append(out, a)
+	}
+	return out, nil
+}
+
+// aliasChecks returns the checks of the extra aliases of a create, run
+// with those of its key: like a custom key, each must exist nowhere yet,
+// not be held and pass the policies.
+func (h *Handler) aliasChecks(ctx context.Context, aliases []string, target string) []func() error {
+	if len(aliases) == 0 {
+		return nil
+	}
+	trail := trailFrom(ctx)
+	checks := []func() error{trail.traced("aliases_available", strings.Join(aliases, ","), func() error { return h.aliasesAvailable(ctx, aliases) })}
+	for _, a := range aliases {
+		checks = append(checks,
+			trail.traced("hold", a, func() error { return h.holdError(ctx, a, "") }),
+			func() error { return h.policyError(ctx, Link{Key: a, Target: target, Custom: true}) },
+		)
+	}
+	return checks
+}
+
+// aliasesAvailable fails the create of aliases of which one is taken, in
+// one lookup.
+func (h *Handler) aliasesAvailable(ctx context.Context, aliases []string) error {
+	keys := make([]urlstore.UrlKey, len(aliases))
+	for i, a := range aliases {
+		keys[i] = urlstore.UrlKey(a)
+	}
+	found, err := h.store.GetEntries(ctx, keys)
+	if err != nil {
+		return failRequest(http.StatusInternalServerError, "failed checking existing key")
+	}
+	for _, a := range aliases {
+		if _, ok := found[urlstore.UrlKey(a)]; ok {
+			return failRequest(http.StatusConflict, "alias %q already exists", a)
+		}
+	}
+	return nil
+}
+
+// createLink stores entry under key and its aliases, all or none.
+func (h *Handler) createLink(ctx context.Context, key string, entry urlstore.URLEntry, aliases []string) error {
+	if len(aliases) == 0 {
+		return h.store.CreateEntry(ctx, urlstore.UrlKey(key), entry)
+	}
+	return h.store.CreateEntries(ctx, linkEntries(key, entry, aliases))
+}
+
+// linkEntries returns entry keyed by key and each of its aliases.
+func linkEntries(key string, entry urlstore.URLEntry, aliases []string) []urlstore.KeyedEntry {
+	entries := []urlstore.KeyedEntry{{Key: urlstore.UrlKey(key), Entry: entry}}
+	for _, a := range aliases {
+		entries = append(entries, urlstore.KeyedEntry{Key: urlstore.UrlKey(a), Entry: entry})
+	}
+	return entries
+}
+
+// validateAliasRequest checks that a create gives a target or, for an
+// alias, the link it is an alias of and nothing its canonical link decides.
+func validateAliasRequest(req writeRequest) error {
+	switch {
+	case req.AliasOf == "" && req.URLTarget == "":
+		return errors.New("url_target is required")
+	case req.AliasOf == "":
+		return nil
+	case req.URLTarget != "":
+		return errors.New("url_target and alias_of are exclusive")
+	case len(req.Schedule) > 0:
+		return errAliasScheduled
+	case len(req.AllowedCIDRs) > 0 || len(req.AllowedReferers) > 0 || req.Interstitial != "" || req.BurnAfterReading ||
+		req.ReferrerPolicy != "" || req.ForwardQuery || len(req.ScrubParams) > 0 || req.MaxRedirectsPerSecond != 0:
+		return errors.New("an alias is restricted like its canonical link; set allowed_cidrs, allowed_referers, interstitial, burn_after_reading, referrer_policy, forward_query, scrub_params and max_redirects_per_second there")
+	}
+	return nil
+}
+
+// canonicalEntry returns the entry that key, made an alias of aliasOf,
+// would be served as. It fails the request when aliasOf leads to no link,
+// back to key, or through more than urlstore.MaxAliasDepth aliases, and
+// when exactly one of key and the canonical link is a wildcard.
+func (h *Handler) canonicalEntry(ctx context.Context, key, aliasOf string) (urlstore.URLEntry, error) {
+	canonical, entry, err := urlstore.ResolveAlias(ctx, h.entries, urlstore.UrlKey(key), urlstore.URLEntry{AliasOf: aliasOf})
+	switch {
+	case errors.Is(err, urlstore.ErrNotFound):
+		return urlstore.URLEntry{}, failRequest(http.StatusBadRequest, "alias_of %q leads to no link", aliasOf)
+	case errors.Is(err, urlstore.ErrAliasChain):
+		return urlstore.URLEntry{}, failRequest(http.StatusBadRequest, "alias_of %q loops or chains more than %d aliases", aliasOf, urlstore.MaxAliasDepth)
+	case err != nil:
+		return urlstore.URLEntry{}, failRequest(http.StatusInternalServerError, "failed checking alias_of")
+	}
+	if key != "" && urlstore.UrlKey(key).IsWildcard() != canonical.IsWildcard() {
+		return urlstore.URLEntry{}, failRequest(http.StatusBadRequest, "an alias and its canonical link must both be wildcards, or neither")
+	}
+	return entry, nil
+}
+
+// errAliasRestricted refuses restrictions on an alias, which is served as
+// its canonical link.
+var errAliasRestricted = errors.New("an alias is restricted like its canonical link; set allowed_cidrs, allowed_referers, interstitial, referrer_policy, forward_query, scrub_params and max_redirects_per_second there")
+
+// validateAliasUpdate checks, and normalizes, the alias_of of an update.
+func validateAliasUpdate(req *updateRequest) error {
+	if req.AliasOf == nil {
+		return nil
+	}
+	aliasOf := urlstore.NormalizeKey(*req.AliasOf)
+	switch {
+	case aliasOf == "":
+		return errors.New("alias_of cannot be empty; set url_target to give the link a target of its own")
+	case req.URLTarget != nil:
+		return errors.New("url_target and alias_of are exclusive")
+	case schedules(*req):
+		return errAliasScheduled
+	case restricts(*req):
+		return errAliasRestricted
+	}
+	req.AliasOf = &aliasOf
+	return nil
+}
+
+// restricts reports whether an update sets restrictions.
+func restricts(req updateRequest) bool {
+	return req.AllowedCIDRs != nil || req.AllowedReferers != nil || req.Interstitial != nil ||
+		req.ReferrerPolicy != nil || req.ForwardQuery != nil || req.ScrubParams != nil || req.MaxRedirectsPerSecond != nil
+}
+
+// schedules reports whether an update sets scheduled targets.
+func schedules(req updateRequest) bool {
+	return req.Schedule != nil && len(*req.Schedule) > 0
+}
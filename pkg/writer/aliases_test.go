@@ -0,0 +1,178 @@
+package writer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/FlorinBalint/shortener/pkg/urlstore"
+	"github.com/FlorinBalint/shortener/pkg/writequeue"
+)
+
+func TestCreate_Aliases(t *testing.T) {
+	h, store := newTestHandler(t)
+	ctx := context.Background()
+
+	rec := do(h, http.MethodPost, "/write/v1", "", `{"url_key":"spring","url_target":"https://example.com/s","aliases":["/spring-2024","sale/spring"]}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("create: want 200, got %d: %s", rec.Code, rec.Body)
+	}
+	var resp writeResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil || !slices.Equal(resp.Aliases, []string{"spring-2024", "sale/spring"}) {
+		t.Fatalf("want the normalized aliases, got %+v, %v", resp, err)
+	}
+	for _, k := range []urlstore.UrlKey{"spring", "spring-2024", "sale/spring"} {
+		if e, err := store.GetEntry(ctx, k); err != nil || e.URLTarget != "https://example.com/s" {
+			t.Errorf("%s: want the link, got %+v, %v", k, e, err)
+		}
+	}
+
+	// "promo" is taken: nothing is created.
+	rec = do(h, http.MethodPost, "/write/v1", "", `{"url_key":"autumn","url_target":"https://example.com/a","aliases":["autumn-2024","promo"]}`)
+	if rec.Code != http.StatusConflict {
+		t.Fatalf("taken alias: want 409, got %d: %s", rec.Code, rec.Body)
+	}
+	for _, k := range []urlstore.UrlKey{"autumn", "autumn-2024"} {
+		if _, err := store.GetEntry(ctx, k); !errors.Is(err, urlstore.ErrNotFound) {
+			t.Errorf("%s: want nothing stored, got %v", k, err)
+		}
+	}
+
+	for _, body := range []string{
+		`{"url_key":"a","url_target":"https://example.com","aliases":["static/x"]}`,
+		`{"url_key":"a","url_target":"https://example.com","aliases":["b","b"]}`,
+		`{"url_key":"a","url_target":"https://example.com","aliases":["a"]}`,
+		`{"url_key":"a","url_target":"https://example.com","aliases":["b"],"burn_after_reading":true}`,
+	} {
+		if rec := do(h, http.MethodPost, "/write/v1", "", body); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: want 400, got %d: %s", body, rec.Code, rec.Body)
+		}
+	}
+}
+
+// raceStore takes a key between the checks of a create and its write.
+type raceStore struct {
+	urlstore.Client
+	take urlstore.UrlKey
+}
+
+func (s *raceStore) CreateEntries(ctx context.Context, entries []urlstore.KeyedEntry) error {
+	s.Client.CreateEntry(ctx, s.take, urlstore.URLEntry{URLTarget: "https://example.com/first"})
+	return s.Client.CreateEntries(ctx, entries)
+}
+
+func TestCreate_AliasTakenConcurrently(t *testing.T) {
+	store := &raceStore{Client: urlstore.NewMemoryClient(), take: "summer-2024"}
+	h := NewHandlerWithStore(Config{}, store)
+
+	rec := do(h, http.MethodPost, "/write/v1", "", `{"url_key":"summer","url_target":"https://example.com/s","aliases":["summer-2024"]}`)
+	if rec.Code != http.StatusConflict {
+		t.Fatalf("want 409, got %d: %s", rec.Code, rec.Body)
+	}
+	if _, err := store.GetEntry(context.Background(), "summer"); !errors.Is(err, urlstore.ErrNotFound) {
+		t.Fatalf("want the key left free, got %v", err)
+	}
+}
+
+func TestApplyWrite_Aliases(t *testing.T) {
+	store := urlstore.NewMemoryClient()
+	h := NewHandlerWithStore(Config{AsyncWrites: true}, store)
+	ctx := context.Background()
+	wr := writequeue.Write{Key: "k", Aliases: []string{"k-1", "k-2"}, Entry: urlstore.URLEntry{
+		URLTarget:         "https://example.com/k",
+		CreationTimestamp: time.Now().UTC(),
+	}}
+	for range 2 {
+		if err := h.applyWrite(ctx, wr); err != nil {
+			t.Fatalf("apply: %v", err)
+		}
+	}
+	for _, k := range []urlstore.UrlKey{"k", "k-1", "k-2"} {
+		if _, err := store.GetEntry(ctx, k); err != nil {
+			t.Errorf("%s: want the link, got %v", k, err)
+		}
+	}
+
+	// A link whose alias is taken is dropped whole.
+	other := writequeue.Write{Key: "j", Aliases: []string{"k-1"}, Entry: wr.Entry}
+	if err := h.applyWrite(ctx, other); err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+	if _, err := store.GetEntry(ctx, "j"); !errors.Is(err, urlstore.ErrNotFound) {
+		t.Fatalf("want the link dropped, got %v", err)
+	}
+}
+
+func TestCreate_AliasOf(t *testing.T) {
+	h, store := newTestHandler(t)
+	ctx := context.Background()
+
+	rec := do(h, http.MethodPost, "/write/v1", "", `{"url_key":"deal","alias_of":"/promo"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("create: want 200, got %d: %s", rec.Code, rec.Body)
+	}
+	if e, err := store.GetEntry(ctx, "deal"); err != nil || e.AliasOf != "promo" || e.URLTarget != "" {
+		t.Fatalf("want an alias of promo, got %+v, %v", e, err)
+	}
+	if rec := do(h, http.MethodPost, "/write/v1", "", `{"url_key":"deal2","alias_of":"deal"}`); rec.Code != http.StatusOK {
+		t.Fatalf("alias of an alias: want 200, got %d: %s", rec.Code, rec.Body)
+	}
+
+	store.CreateEntry(ctx, "loop", urlstore.URLEntry{AliasOf: "loop2"})
+	store.CreateEntry(ctx, "loop2", urlstore.URLEntry{AliasOf: "loop"})
+	for _, body := range []string{
+		`{"url_key":"x","alias_of":"missing"}`,
+		`{"url_key":"x","alias_of":"loop"}`,
+		`{"url_key":"x","alias_of":"x"}`,
+		`{"url_key":"x","alias_of":"promo","url_target":"https://example.com"}`,
+		`{"url_key":"x","alias_of":"promo","allowed_cidrs":["10.0.0.0/8"]}`,
+		`{"url_key":"x","alias_of":"promo","burn_after_reading":true}`,
+		`{"url_key":"x/*","alias_of":"promo"}`,
+	} {
+		if rec := do(h, http.MethodPost, "/write/v1", "", body); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: want 400, got %d: %s", body, rec.Code, rec.Body)
+		}
+	}
+}
+
+func TestUpdate_AliasOf(t *testing.T) {
+	h, store := newTestHandler(t)
+	ctx := context.Background()
+	store.CreateEntry(ctx, "other", urlstore.URLEntry{
+		URLTarget:    "https://example.com/other",
+		AllowedCIDRs: []string{"10.0.0.0/8"},
+		Version:      1,
+	})
+
+	rec := manage(h, http.MethodPatch, "/write/v1/links/other", `"1"`, `{"alias_of":"promo"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("make alias: want 200, got %d: %s", rec.Code, rec.Body)
+	}
+	e, err := store.GetEntry(ctx, "other")
+	if err != nil || e.AliasOf != "promo" || e.URLTarget != "" || e.AllowedCIDRs != nil {
+		t.Fatalf("want a bare alias of promo, got %+v, %v", e, err)
+	}
+
+	for _, body := range []string{
+		`{"alias_of":""}`,
+		`{"alias_of":"other"}`,
+		`{"alias_of":"promo","url_target":"https://example.com"}`,
+		`{"interstitial":"Careful"}`,
+	} {
+		if rec := manage(h, http.MethodPatch, "/write/v1/links/other", `"2"`, body); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: want 400, got %d: %s", body, rec.Code, rec.Body)
+		}
+	}
+
+	rec = manage(h, http.MethodPatch, "/write/v1/links/other", `"2"`, `{"url_target":"https://example.com/own"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("give a target: want 200, got %d: %s", rec.Code, rec.Body)
+	}
+	if e, err := store.GetEntry(ctx, "other"); err != nil || e.AliasOf != "" || e.URLTarget != "https://example.com/own" {
+		t.Fatalf("want a link of its own, got %+v, %v", e, err)
+	}
+}
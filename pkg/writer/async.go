@@ -0,0 +1,114 @@
+package writer
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/FlorinBalint/shortener/pkg/tenant"
+	"github.com/FlorinBalint/shortener/pkg/urlstore"
+	"github.com/FlorinBalint/shortener/pkg/writequeue"
+)
+
+// memoryQueueSize bounds the creates queued in memory.
+const memoryQueueSize = 1024
+
+// enqueueCreate queues the creation of key and its aliases for
+// ApplyQueuedWrites.
+func (h *Handler) enqueueCreate(ctx context.Context, key string, entry urlstore.URLEntry, aliases []string, generated bool) error {
+	wr := writequeue.Write{Key: key, Entry: entry, Generated: generated, Aliases: aliases}
+	if h.tenant != nil {
+		wr.Tenant = h.tenant.ID
+	}
+	return h.queue.Publish(ctx, wr)
+}
+
+// ApplyQueuedWrites creates the links queued in async mode until ctx is
+// done; every writer runs it. It returns right away when writes are
+// synchronous.
+func (h *Handler) ApplyQueuedWrites(ctx context.Context) error {
+	if h.queue == nil {
+		return nil
+	}
+	err := h.queue.Receive(ctx, h.applyWrite)
+	if ctx.Err() != nil {
+		return nil
+	}
+	return err
+}
+
+// applyWrite creates a queued link and its aliases; errors have it
+// retried. A key or alias found taken, other than by the link itself on a
+// redelivery, drops the link with its aliases: its creator was already
+// answered, so the drop is logged and the quota given back.
+func (h *Handler) applyWrite(ctx context.Context, wr writequeue.Write) error {
+	s, err := h.writeScope(ctx, wr.Tenant)
+	if err != nil {
+		return err
+	}
+	if s == nil {
+		log.Printf("write queue: dropping %q: tenant %s not found", wr.Key, wr.Tenant)
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	key := urlstore.UrlKey(wr.Key)
+	err = s.createLink(ctx, wr.Key, wr.Entry, wr.Aliases)
+	if errors.Is(err, urlstore.ErrAlreadyExists) {
+		existing, err := s.entries.GetEntry(ctx, key)
+		if err != nil && !errors.Is(err, urlstore.ErrNotFound) {
+			return err
+		}
+		if err == nil && sameLink(existing, wr.Entry) {
+			return nil
+		}
+		// A generated key still free lost to an alias, not a collision.
+		if wr.Generated && (err == nil || len(wr.Aliases) == 0) {
+			s.reportKeyCollision(wr.Key)
+		}
+		log.Printf("write queue: dropping %q: the key or an alias is taken", wr.Key)
+		if owner := wr.Entry.Owner; owner != "" {
+			if err := s.quotas.CancelN(ctx, owner, int64(1+len(wr.Aliases))); err != nil {
+				log.Printf("quota: cancel for %s: %v", owner, err)
+			}
+		}
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	// One-shot targets are typically secret; they are not fetched.
+	if s.previews != nil && !wr.Entry.BurnAfterReading && wr.Entry.AliasOf == "" {
+		s.previews.Enqueue(key, wr.Entry.URLTarget)
+		for _, a := range wr.Aliases {
+			s.previews.Enqueue(urlstore.UrlKey(a), wr.Entry.URLTarget)
+		}
+	}
+	return nil
+}
+
+// sameLink reports whether stored is the entry of a queued create, as
+// Datastore keeps timestamps to the microsecond.
+func sameLink(stored, queued urlstore.URLEntry) bool {
+	return stored.URLTarget == queued.URLTarget &&
+		stored.Owner == queued.Owner &&
+		stored.CreationTimestamp.Truncate(time.Microsecond).Equal(queued.CreationTimestamp.Truncate(time.Microsecond))
+}
+
+// writeScope returns the handler writing the links of tenant ("" for
+// none), or nil if the tenant is gone.
+func (h *Handler) writeScope(ctx context.Context, id string) (*Handler, error) {
+	if id == "" || h.tenancy == nil {
+		return h, nil
+	}
+	t, err := h.tenancy.tenants.Get(ctx, id)
+	if errors.Is(err, tenant.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return h.tenancy.scopes.Get(t), nil
+}
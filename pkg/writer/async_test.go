@@ -0,0 +1,69 @@
+package writer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/FlorinBalint/shortener/pkg/urlstore"
+	"github.com/FlorinBalint/shortener/pkg/writequeue"
+)
+
+func TestCreate_Async(t *testing.T) {
+	store := urlstore.NewMemoryClient()
+	h := NewHandlerWithStore(Config{AsyncWrites: true}, store)
+	q := h.queue.(*writequeue.MemoryQueue)
+
+	rec := do(h, http.MethodPost, "/write/v1", "", `{"url_key":"later","url_target":"https://example.com/later"}`)
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("create: want 202, got %d: %s", rec.Code, rec.Body)
+	}
+	var resp writeResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil || resp.URLKey != "later" || !resp.Pending {
+		t.Fatalf("want a pending link, got %+v, %v", resp, err)
+	}
+	if _, err := store.GetEntry(context.Background(), "later"); !errors.Is(err, urlstore.ErrNotFound) {
+		t.Fatalf("want the link not created before it is applied, got %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go h.ApplyQueuedWrites(ctx)
+	q.Wait()
+	e, err := store.GetEntry(context.Background(), "later")
+	if err != nil || e.URLTarget != "https://example.com/later" {
+		t.Fatalf("want the link applied, got %+v, %v", e, err)
+	}
+	if rec := do(h, http.MethodPost, "/write/v1", "", `{"url_key":"later","url_target":"https://example.com/other"}`); rec.Code != http.StatusConflict {
+		t.Fatalf("taken key: want 409, got %d", rec.Code)
+	}
+}
+
+func TestApplyWrite_Redelivered(t *testing.T) {
+	store := urlstore.NewMemoryClient()
+	h := NewHandlerWithStore(Config{AsyncWrites: true}, store)
+	ctx := context.Background()
+	wr := writequeue.Write{Key: "k", Entry: urlstore.URLEntry{
+		URLTarget:         "https://example.com/k",
+		CreationTimestamp: time.Now().UTC(),
+		Version:           1,
+	}}
+	for range 2 {
+		if err := h.applyWrite(ctx, wr); err != nil {
+			t.Fatalf("apply: %v", err)
+		}
+	}
+
+	// A different link queued under the same key is dropped.
+	other := wr
+	other.Entry.URLTarget = "https://example.com/other"
+	if err := h.applyWrite(ctx, other); err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+	if e, _ := store.GetEntry(ctx, "k"); e.URLTarget != "https://example.com/k" {
+		t.Fatalf("want the first link kept, got %q", e.URLTarget)
+	}
+}
@@ -0,0 +1,191 @@
+package writer
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/FlorinBalint/shortener/pkg/apikey"
+	"github.com/FlorinBalint/shortener/pkg/clientip"
+	"github.com/FlorinBalint/shortener/pkg/oidc"
+	"github.com/FlorinBalint/shortener/pkg/requestid"
+)
+
+// principal is the authenticated caller of a request.
+type principal struct {
+	// kind is "anonymous", "apikey", "oidc", "admin-token" or
+	// "quick-token".
+	kind string
+	// id is the API key ID or the token subject.
+	id    string
+	email string
+	// tenant is the tenant of an API key in multi-tenant mode.
+	tenant string
+	// admin is set for callers authenticated by requireAdmin.
+	admin bool
+}
+
+var anonymous = principal{kind: "anonymous"}
+
+// owner is the identity links and quotas are accounted to; anonymous
+// callers and the admin token own nothing.
+func (p principal) owner() string {
+	switch p.kind {
+	case "apikey":
+		return p.id
+	case "oidc":
+		return "oidc:" + p.id
+	default:
+		return ""
+	}
+}
+
+// mayManage reports whether p may read or change what owner owns, a link
+// or a campaign: its owner and administrators may.
+func (p principal) mayManage(owner string) bool {
+	return p.admin || (p.owner() != "" && p.owner() == owner)
+}
+
+func (p principal) String() string {
+	switch {
+	case p.email != "":
+		return p.kind + ":" + p.email
+	case p.id != "":
+		return p.kind + ":" + p.id
+	default:
+		return p.kind
+	}
+}
+
+// audit logs a state-changing action with the identity and client IP that
+// performed it.
+func audit(r *http.Request, p principal, action, target string) {
+	log.Printf("audit: principal=%s ip=%s action=%s target=%q request=%s", p, clientip.FromRequest(r), action, target, requestid.FromContext(r.Context()))
+}
+
+func (cfg Config) validateAuth() error {
+	switch cfg.AuthMode {
+	case "", AuthAPIKey:
+		return nil
+	case AuthOIDC, AuthIAP:
+		if cfg.OIDC.Issuer == "" || cfg.OIDC.Audience == "" {
+			return fmt.Errorf("auth mode %s requires an issuer and an audience", cfg.AuthMode)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown auth mode %q", cfg.AuthMode)
+	}
+}
+
+// authenticate identifies the caller of a write. In API key mode the key
+// is optional (anonymous writes are allowed); in the OIDC and IAP modes a
+// valid identity token is required. It reports an error to the client and
+// returns false when authentication fails.
+func (h *Handler) authenticate(w http.ResponseWriter, r *http.Request) (principal, bool) {
+	if h.verifier != nil {
+		id, err := h.verifier.FromRequest(r)
+		if err != nil {
+			writeTokenError(w, err)
+			return principal{}, false
+		}
+		return principal{kind: "oidc", id: id.Subject, email: id.Email}, true
+	}
+
+	secret := r.Header.Get("X-API-Key")
+	if secret == "" {
+		return anonymous, true
+	}
+	key, err := h.keys.Authenticate(r.Context(), secret)
+	switch {
+	case err == nil:
+		return principal{kind: "apikey", id: key.ID, tenant: key.Tenant}, true
+	case errors.Is(err, apikey.ErrInvalid):
+		http.Error(w, "invalid API key", http.StatusUnauthorized)
+	case errors.Is(err, apikey.ErrFrozen):
+		http.Error(w, "API key is frozen", http.StatusForbidden)
+	default:
+		log.Printf("apikey: authenticate: %v", err)
+		http.Error(w, "failed to verify API key", http.StatusServiceUnavailable)
+	}
+	return principal{}, false
+}
+
+func writeTokenError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, oidc.ErrMissingToken), errors.Is(err, oidc.ErrInvalidToken):
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		http.Error(w, "invalid identity token", http.StatusUnauthorized)
+	default:
+		log.Printf("oidc: verify: %v", err)
+		http.Error(w, "failed to verify identity token", http.StatusServiceUnavailable)
+	}
+}
+
+// requireAdmin authenticates an administrative request, with the admin
+// bearer token or, in the OIDC and IAP modes, an identity token whose email
+// is listed in AdminEmails. It reports an error to the client when neither
+// is valid. Administrative endpoints are hidden when no admin credential is
+// configured.
+func (h *Handler) requireAdmin(w http.ResponseWriter, r *http.Request) (principal, bool) {
+	adminToken := h.adminToken.Value()
+	if adminToken == "" && len(h.adminEmails) == 0 {
+		http.NotFound(w, r)
+		return principal{}, false
+	}
+	if h.hasAdminToken(r) {
+		return principal{kind: "admin-token", admin: true}, true
+	}
+	if len(h.adminEmails) > 0 {
+		id, err := h.verifier.FromRequest(r)
+		if err == nil && h.adminEmails[strings.ToLower(id.Email)] {
+			return principal{kind: "oidc", id: id.Subject, email: id.Email, admin: true}, true
+		}
+		if err == nil {
+			http.Error(w, "identity is not an administrator", http.StatusForbidden)
+			return principal{}, false
+		}
+		if !errors.Is(err, oidc.ErrMissingToken) && !errors.Is(err, oidc.ErrInvalidToken) {
+			writeTokenError(w, err)
+			return principal{}, false
+		}
+	}
+	w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+	http.Error(w, "admin credential required", http.StatusUnauthorized)
+	return principal{}, false
+}
+
+// authenticateManager identifies the caller of a request on existing
+// links: an administrator, with the credentials requireAdmin accepts, or
+// a caller that may own links. Anonymous callers are refused, as they own
+// nothing. It reports an error to the client and returns false when
+// authentication fails.
+func (h *Handler) authenticateManager(w http.ResponseWriter, r *http.Request) (principal, bool) {
+	if h.hasAdminToken(r) {
+		return principal{kind: "admin-token", admin: true}, true
+	}
+	caller, ok := h.authenticate(w, r)
+	if !ok {
+		return principal{}, false
+	}
+	if caller.kind == "oidc" && h.adminEmails[strings.ToLower(caller.email)] {
+		caller.admin = true
+	}
+	if !caller.admin && caller.owner() == "" {
+		http.Error(w, "API key required", http.StatusUnauthorized)
+		return principal{}, false
+	}
+	return caller, true
+}
+
+// hasAdminToken reports whether r carries the admin bearer token.
+func (h *Handler) hasAdminToken(r *http.Request) bool {
+	adminToken := h.adminToken.Value()
+	if adminToken == "" {
+		return false
+	}
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) == 1
+}
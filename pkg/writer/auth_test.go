@@ -0,0 +1,173 @@
+package writer
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/FlorinBalint/shortener/pkg/hmacsig"
+	"github.com/FlorinBalint/shortener/pkg/oidc"
+	"github.com/FlorinBalint/shortener/pkg/urlstore"
+)
+
+// newTestIssuer serves a JWKS for a fresh RSA key and returns the config
+// verifying its tokens and a function minting tokens for an email.
+func newTestIssuer(t *testing.T) (oidc.Config, func(email string) string) {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{{
+			"kid": "k1", "kty": "RSA", "e": "AQAB",
+			"n": base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		}}})
+	}))
+	t.Cleanup(srv.Close)
+	cfg := oidc.Config{Issuer: "https://issuer.example", Audience: "writer", JWKSURL: srv.URL}
+
+	enc := func(v any) string {
+		b, _ := json.Marshal(v)
+		return base64.RawURLEncoding.EncodeToString(b)
+	}
+	mint := func(email string) string {
+		signing := enc(map[string]string{"alg": "RS256", "kid": "k1"}) + "." + enc(map[string]any{
+			"iss": cfg.Issuer, "aud": cfg.Audience, "sub": "sub-" + email, "email": email,
+			"exp": time.Now().Add(time.Hour).Unix(),
+		})
+		digest := sha256.Sum256([]byte(signing))
+		sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signing + "." + base64.RawURLEncoding.EncodeToString(sig)
+	}
+	return cfg, mint
+}
+
+func withBearer(h http.Handler, method, path, token, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestOIDCMode_Writes(t *testing.T) {
+	cfg, mint := newTestIssuer(t)
+	store := urlstore.NewMemoryClient()
+	h := NewHandlerWithStore(Config{AuthMode: AuthOIDC, OIDC: cfg}, store)
+	body := `{"url_key":"sso","url_target":"https://example.com"}`
+
+	if rec := withBearer(h, http.MethodPost, "/write/v1", "", body); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("no token: want 401, got %d", rec.Code)
+	}
+	if rec := withBearer(h, http.MethodPost, "/write/v1", "garbage", body); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("bad token: want 401, got %d", rec.Code)
+	}
+	if rec := withBearer(h, http.MethodPost, "/write/v1", mint("dev@example.com"), body); rec.Code != http.StatusOK {
+		t.Fatalf("valid token: want 200, got %d: %s", rec.Code, rec.Body)
+	}
+	entry, err := store.GetEntry(context.Background(), "sso")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.Owner != "oidc:sub-dev@example.com" {
+		t.Fatalf("owner: got %q", entry.Owner)
+	}
+}
+
+func TestOIDCMode_Admin(t *testing.T) {
+	cfg, mint := newTestIssuer(t)
+	h := NewHandlerWithStore(Config{
+		AuthMode:    AuthOIDC,
+		OIDC:        cfg,
+		AdminEmails: []string{"Ops@example.com"},
+		AdminToken:  "root",
+	}, urlstore.NewMemoryClient())
+
+	if rec := withBearer(h, http.MethodGet, "/write/v1/admin/keys", mint("ops@example.com"), ""); rec.Code != http.StatusOK {
+		t.Fatalf("admin identity: want 200, got %d", rec.Code)
+	}
+	if rec := withBearer(h, http.MethodGet, "/write/v1/admin/keys", mint("dev@example.com"), ""); rec.Code != http.StatusForbidden {
+		t.Fatalf("non-admin identity: want 403, got %d", rec.Code)
+	}
+	if rec := withBearer(h, http.MethodGet, "/write/v1/admin/keys", "root", ""); rec.Code != http.StatusOK {
+		t.Fatalf("admin token: want 200, got %d", rec.Code)
+	}
+	if rec := withBearer(h, http.MethodGet, "/write/v1/admin/keys", "", ""); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("no credential: want 401, got %d", rec.Code)
+	}
+}
+
+func TestValidateAuth(t *testing.T) {
+	if err := (Config{AuthMode: "kerberos"}).validateAuth(); err == nil {
+		t.Fatal("unknown mode: want error")
+	}
+	if err := (Config{AuthMode: AuthIAP, OIDC: oidc.IAPConfig("")}).validateAuth(); err == nil {
+		t.Fatal("iap without audience: want error")
+	}
+}
+
+func TestSignedWrites(t *testing.T) {
+	h := NewHandlerWithStore(Config{SigningKeys: "k1:secret", AdminToken: "root"}, urlstore.NewMemoryClient())
+	body := `{"url_key":"signed","url_target":"https://example.com"}`
+
+	if rec := do(h, http.MethodPost, "/write/v1", "", body); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("unsigned write: want 401, got %d", rec.Code)
+	}
+
+	keys, _ := hmacsig.ParseKeyring("k1:secret")
+	req := httptest.NewRequest(http.MethodPost, "/write/v1", strings.NewReader(body))
+	keys.SignRequest(req, []byte(body))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("signed write: want 200, got %d: %s", rec.Code, rec.Body)
+	}
+
+	// Reads are not signed.
+	if rec := manage(h, http.MethodGet, "/write/v1/links/signed", "", ""); rec.Code != http.StatusOK {
+		t.Fatalf("read: want 200, got %d", rec.Code)
+	}
+}
+
+func TestCreate_AuthenticatesFirst(t *testing.T) {
+	store := urlstore.NewMemoryClient()
+	store.Seed("taken", urlstore.URLEntry{URLTarget: "https://example.com", Version: 1})
+	generated := 0
+	h := NewHandlerWithStore(Config{KeyGenerator: func(context.Context) (string, error) {
+		generated++
+		return "fresh", nil
+	}}, store)
+
+	// A taken key and a missing campaign are not revealed to a bad key,
+	// which spends no generated key either.
+	for _, body := range []string{
+		`{"url_key":"taken","url_target":"https://example.com"}`,
+		`{"url_target":"https://example.com","campaign":"missing"}`,
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/write/v1", strings.NewReader(body))
+		req.Header.Set("X-API-Key", "bogus")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != http.StatusUnauthorized {
+			t.Errorf("%s: want 401, got %d: %s", body, rec.Code, rec.Body)
+		}
+	}
+	if generated != 0 {
+		t.Fatalf("want no key generated for unauthenticated creates, got %d", generated)
+	}
+}
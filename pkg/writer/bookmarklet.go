@@ -0,0 +1,380 @@
+package writer
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/FlorinBalint/shortener/pkg/apikey"
+	"github.com/FlorinBalint/shortener/pkg/hmacsig"
+	"github.com/FlorinBalint/shortener/pkg/tenant"
+)
+
+const (
+	bookmarkletPath = "/write/v1/bookmarklet"
+	shortenPath     = "/write/v1/shorten"
+)
+
+const (
+	// defaultBookmarkletTTL is how long bookmarklet tokens live.
+	defaultBookmarkletTTL = 90 * 24 * time.Hour
+	// defaultBookmarkletRate is the creates a minute a token may make.
+	defaultBookmarkletRate = 30
+)
+
+// Errors of bookmarklet tokens.
+var (
+	errBookmarkletInvalid = errors.New("invalid bookmarklet token")
+	errBookmarkletExpired = errors.New("bookmarklet token expired")
+)
+
+// bookmarkletClaims are who a bookmarklet token creates links as. Tokens
+// are "<base64url claims>.<base64url signature>", signed with
+// BookmarkletKeys as pkg/viewtoken signs preview tokens.
+type bookmarkletClaims struct {
+	ID      string `json:"jti"`
+	Kind    string `json:"kind"`
+	Subject string `json:"sub,omitempty"`
+	Email   string `json:"email,omitempty"`
+	Tenant  string `json:"tenant,omitempty"`
+	// IssuedAt revokes the tokens of API keys rotated since.
+	IssuedAt  time.Time `json:"iat"`
+	ExpiresAt time.Time `json:"exp"`
+}
+
+// mintBookmarklet signs c.
+func mintBookmarklet(k *hmacsig.Keyring, c bookmarkletClaims, now time.Time) (string, error) {
+	payload, err := json.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+	sig := k.Sign(payload, now)
+	return base64.RawURLEncoding.EncodeToString(payload) + "." + base64.RawURLEncoding.EncodeToString([]byte(sig)), nil
+}
+
+// verifyBookmarklet checks token at now; tokens are signed at most ttl
+// before they expire.
+func verifyBookmarklet(k *hmacsig.Keyring, token string, now time.Time, ttl time.Duration) (bookmarkletClaims, error) {
+	encPayload, encSig, ok := strings.Cut(token, ".")
+	if !ok {
+		return bookmarkletClaims{}, errBookmarkletInvalid
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encPayload)
+	if err != nil {
+		return bookmarkletClaims{}, errBookmarkletInvalid
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(encSig)
+	if err != nil {
+		return bookmarkletClaims{}, errBookmarkletInvalid
+	}
+	switch err := k.Verify(string(sig), payload, now, ttl); {
+	case errors.Is(err, hmacsig.ErrExpired):
+		return bookmarkletClaims{}, errBookmarkletExpired
+	case err != nil:
+		return bookmarkletClaims{}, errBookmarkletInvalid
+	}
+	var c bookmarkletClaims
+	if err := json.Unmarshal(payload, &c); err != nil || c.ID == "" {
+		return bookmarkletClaims{}, errBookmarkletInvalid
+	}
+	if !now.Before(c.ExpiresAt) {
+		return bookmarkletClaims{}, errBookmarkletExpired
+	}
+	return c, nil
+}
+
+// bookmarkletKeyring parses BookmarkletKeys; it returns nil when
+// bookmarklets are off.
+func (cfg Config) bookmarkletKeyring() (*hmacsig.Keyring, error) {
+	if cfg.BookmarkletKeys == "" {
+		return nil, nil
+	}
+	if cfg.BookmarkletTTL <= 0 {
+		return nil, errors.New("BOOKMARKLET_TTL must be positive with BOOKMARKLET_KEYS")
+	}
+	k, err := hmacsig.ParseKeyring(cfg.BookmarkletKeys)
+	if err != nil {
+		return nil, fmt.Errorf("bookmarklet keys: %w", err)
+	}
+	return k, nil
+}
+
+type bookmarkletRequest struct {
+	TTLSeconds int `json:"ttl_seconds,omitempty"`
+}
+
+type bookmarkletResponse struct {
+	Token string `json:"token"`
+	// Bookmarklet is the javascript: URL to bookmark.
+	Bookmarklet string    `json:"bookmarklet"`
+	ExpiresAt   time.Time `json:"expires_at"`
+}
+
+// Named handler for /write/v1/bookmarklet: mints the token of a
+// bookmarklet shortening the page it is clicked on, as the caller. The
+// optional body sets ttl_seconds, at most BookmarkletTTL.
+func (h *Handler) handleBookmarklet(w http.ResponseWriter, r *http.Request) {
+	if h.bookmarklets == nil {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	caller, ok := h.authenticate(w, r)
+	if !ok {
+		return
+	}
+	if caller.kind == "anonymous" {
+		http.Error(w, "API key required", http.StatusUnauthorized)
+		return
+	}
+	var req bookmarkletRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&req); err != nil && err != io.EOF {
+		http.Error(w, "invalid json body", http.StatusBadRequest)
+		return
+	}
+	ttl := time.Duration(req.TTLSeconds) * time.Second
+	if ttl < 0 || ttl > h.bookmarkletTTL {
+		http.Error(w, fmt.Sprintf("ttl_seconds must be between 0 and %d", int64(h.bookmarkletTTL/time.Second)), http.StatusBadRequest)
+		return
+	}
+	if ttl == 0 {
+		ttl = h.bookmarkletTTL
+	}
+	now := time.Now()
+	claims := bookmarkletClaims{
+		ID:        rand.Text(),
+		Kind:      caller.kind,
+		Subject:   caller.id,
+		Email:     caller.email,
+		IssuedAt:  now.UTC(),
+		ExpiresAt: now.Add(ttl).UTC().Truncate(time.Second),
+	}
+	if h.tenant != nil {
+		claims.Tenant = h.tenant.ID
+	}
+	token, err := mintBookmarklet(h.bookmarklets, claims, now)
+	if err != nil {
+		http.Error(w, "failed to mint token", http.StatusInternalServerError)
+		return
+	}
+	audit(r, caller, "bookmarklet.mint", claims.ID)
+	shorten := requestOrigin(r) + shortenPath + "?token=" + token + "&url="
+	writeJSON(w, http.StatusCreated, bookmarkletResponse{
+		Token:       token,
+		Bookmarklet: "javascript:location.href='" + shorten + "'+encodeURIComponent(location.href)",
+		ExpiresAt:   claims.ExpiresAt,
+	})
+}
+
+// Named handler for /write/v1/shorten?url=...&token=...: creates a link to
+// url as the bookmarklet token's caller, under ?key= or a generated key,
+// and shows it on a page that copies it to the clipboard; with ?return=1
+// it redirects back to url instead, the link in its #shortlink= fragment.
+// The token is given in the URL rather than in a cookie, so other sites
+// cannot create links in a visitor's name.
+func (h *Handler) handleShorten(w http.ResponseWriter, r *http.Request) {
+	if h.bookmarklets == nil {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	// The token must not be kept, nor leave in a Referer.
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	release, ok := h.writeLimit.Acquire(w, r)
+	if !ok {
+		return
+	}
+	defer release()
+
+	q := r.URL.Query()
+	now := time.Now()
+	claims, err := verifyBookmarklet(h.bookmarklets, q.Get("token"), now, h.bookmarkletTTL)
+	if errors.Is(err, errBookmarkletExpired) {
+		http.Error(w, "bookmarklet token expired; mint a new bookmarklet", http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	if ok, retry := h.bookmarkletThrottle.Allow(claims.ID, now); !ok {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retry.Seconds()))))
+		http.Error(w, "too many links shortened", http.StatusTooManyRequests)
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	scoped, ok := h.bookmarkletScope(ctx, w, claims)
+	if !ok {
+		return
+	}
+	caller, ok := scoped.bookmarkletCaller(ctx, w, claims)
+	if !ok {
+		return
+	}
+	target := q.Get("url")
+	if target == "" {
+		http.Error(w, "url is required", http.StatusBadRequest)
+		return
+	}
+	resp, ok := scoped.create(w, r, writeRequest{URLTarget: target, URLKey: q.Get("key")}, false, func(http.ResponseWriter, *http.Request) (principal, bool) {
+		return caller, true
+	})
+	if !ok {
+		return
+	}
+	short := scoped.shortURL(r, resp.URLKey)
+	back, err := url.Parse(target)
+	if ret, _ := strconv.ParseBool(q.Get("return")); ret && err == nil && (back.Scheme == "http" || back.Scheme == "https") {
+		back.Fragment = "shortlink=" + short
+		http.Redirect(w, r, back.String(), http.StatusFound)
+		return
+	}
+	page := shortenPage{ShortURL: short, Target: target, TargetHost: target, Pending: resp.Pending}
+	if err == nil && back.Host != "" {
+		page.TargetHost = back.Hostname()
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; script-src '"+shortenScriptHash+"'; base-uri 'none'; form-action 'none'; frame-ancestors 'none'")
+	if err := shortenTemplate.Execute(w, page); err != nil {
+		log.Printf("shorten: rendering the page of %q: %v", resp.URLKey, err)
+	}
+}
+
+// bookmarkletScope returns the handler of the tenant of claims.
+func (h *Handler) bookmarkletScope(ctx context.Context, w http.ResponseWriter, claims bookmarkletClaims) (*Handler, bool) {
+	if h.tenancy == nil || h.tenant != nil {
+		if claims.Tenant != "" {
+			http.Error(w, errBookmarkletInvalid.Error(), http.StatusUnauthorized)
+			return nil, false
+		}
+		return h, true
+	}
+	if claims.Tenant == "" {
+		http.Error(w, errBookmarkletInvalid.Error(), http.StatusUnauthorized)
+		return nil, false
+	}
+	t, err := h.tenancy.tenants.Get(ctx, claims.Tenant)
+	switch {
+	case errors.Is(err, tenant.ErrNotFound):
+		http.Error(w, "tenant of bookmarklet not found", http.StatusForbidden)
+		return nil, false
+	case err != nil:
+		log.Printf("tenant: resolve %s: %v", claims.Tenant, err)
+		http.Error(w, "failed to resolve tenant", http.StatusServiceUnavailable)
+		return nil, false
+	}
+	return h.tenancy.scopes.Get(t), true
+}
+
+// bookmarkletCaller returns the caller claims name. The API key of the
+// caller must still be in use: freezing it suspends its bookmarklets, and
+// rotating it revokes them.
+func (h *Handler) bookmarkletCaller(ctx context.Context, w http.ResponseWriter, claims bookmarkletClaims) (principal, bool) {
+	caller := principal{kind: claims.Kind, id: claims.Subject, email: claims.Email, tenant: claims.Tenant}
+	if caller.kind != "apikey" {
+		return caller, true
+	}
+	key, err := h.keys.Get(ctx, caller.id)
+	switch {
+	case errors.Is(err, apikey.ErrNotFound):
+		http.Error(w, "API key of the bookmarklet not found", http.StatusUnauthorized)
+	case err != nil:
+		log.Printf("apikey: get %s: %v", caller.id, err)
+		http.Error(w, "failed to verify API key", http.StatusServiceUnavailable)
+	case key.Frozen:
+		http.Error(w, "API key is frozen", http.StatusForbidden)
+	case key.RotatedAt.After(claims.IssuedAt):
+		http.Error(w, "API key was rotated; mint a new bookmarklet", http.StatusUnauthorized)
+	default:
+		return caller, true
+	}
+	return principal{}, false
+}
+
+// requestOrigin returns the scheme and host r was sent to.
+func requestOrigin(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
+
+// shortURL returns the link of key, on the first domain the handler's
+// links are served on or, without any, on the host of r, which the reader
+// shares behind the load balancer.
+func (h *Handler) shortURL(r *http.Request, key string) string {
+	if domains := h.servedOn(); len(domains) > 0 {
+		return (&url.URL{Scheme: "https", Host: domains[0], Path: "/" + key}).String()
+	}
+	return requestOrigin(r) + (&url.URL{Path: "/" + key}).EscapedPath()
+}
+
+// shortenPage holds the variables of the page of a shortened link.
+type shortenPage struct {
+	ShortURL   string
+	Target     string
+	TargetHost string
+	// Pending links are served once their queued create is applied.
+	Pending bool
+}
+
+// shortenScript selects the link and copies it, on load when the browser
+// lets it and on the click of Copy.
+const shortenScript = `
+const link = document.getElementById("link");
+const copy = document.getElementById("copy");
+const copied = () => { copy.textContent = "Copied"; };
+link.select();
+navigator.clipboard.writeText(link.value).then(copied, () => {});
+copy.addEventListener("click", () => {
+  link.select();
+  navigator.clipboard.writeText(link.value).then(copied, () => document.execCommand("copy") && copied());
+});
+`
+
+// shortenScriptHash allows shortenScript in the page's CSP.
+var shortenScriptHash = func() string {
+	sum := sha256.Sum256([]byte(shortenScript))
+	return "sha256-" + base64.StdEncoding.EncodeToString(sum[:])
+}()
+
+var shortenTemplate = template.Must(template.New("shorten").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta name="robots" content="noindex">
+<title>Short link</title>
+</head>
+<body>
+<p><input id="link" value="{{.ShortURL}}" size="40" readonly aria-label="Short link"> <button id="copy" type="button">Copy</button></p>
+{{if .Pending}}<p>The link works once its create is applied, in a few seconds.</p>
+{{end}}<p><a href="{{.Target}}">Back to {{.TargetHost}}</a></p>
+<script>` + shortenScript + `</script>
+</body>
+</html>
+`))
@@ -0,0 +1,136 @@
+package writer
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/FlorinBalint/shortener/pkg/hmacsig"
+	"github.com/FlorinBalint/shortener/pkg/urlstore"
+	"github.com/FlorinBalint/shortener/pkg/viewtoken"
+)
+
+func TestBookmarklet(t *testing.T) {
+	h := NewHandlerWithStore(Config{
+		BookmarkletKeys: "k1:secret",
+		BookmarkletTTL:  time.Hour,
+		BookmarkletRate: 3,
+		ShortDomains:    []string{"sho.rt"},
+	}, urlstore.NewMemoryClient())
+	id, secret := issueKey(t, h)
+	mint := func(apiKey, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "https://writer.example.com"+bookmarkletPath, strings.NewReader(body))
+		if apiKey != "" {
+			req.Header.Set("X-API-Key", apiKey)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+	shorten := func(q url.Values) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, shortenPath+"?"+q.Encode(), nil))
+		return rec
+	}
+
+	if rec := mint("", ""); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("anonymous: want 401, got %d", rec.Code)
+	}
+	if rec := mint(secret, `{"ttl_seconds":7200}`); rec.Code != http.StatusBadRequest {
+		t.Fatalf("long ttl: want 400, got %d", rec.Code)
+	}
+	rec := mint(secret, "")
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("mint: want 201, got %d: %s", rec.Code, rec.Body)
+	}
+	var resp bookmarkletResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if want := "javascript:location.href='https://writer.example.com" + shortenPath + "?token=" + resp.Token + "&url='+encodeURIComponent(location.href)"; resp.Bookmarklet != want {
+		t.Errorf("bookmarklet = %q, want %q", resp.Bookmarklet, want)
+	}
+
+	rec = shorten(url.Values{"token": {resp.Token}, "url": {"https://example.com/article"}, "key": {"article"}})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("shorten: want 200, got %d: %s", rec.Code, rec.Body)
+	}
+	if !strings.Contains(rec.Body.String(), `value="https://sho.rt/article"`) {
+		t.Errorf("want the short link on the page, got %s", rec.Body)
+	}
+	if rec.Header().Get("Referrer-Policy") != "no-referrer" || !strings.Contains(rec.Header().Get("Content-Security-Policy"), shortenScriptHash) {
+		t.Errorf("unexpected headers: %v", rec.Header())
+	}
+	e, err := h.store.GetEntry(context.Background(), "article")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Owner != id {
+		t.Errorf("want the link owned by the minting key, got %q", e.Owner)
+	}
+
+	rec = shorten(url.Values{"token": {resp.Token}, "url": {"https://example.com/b#top"}, "key": {"b"}, "return": {"1"}})
+	if rec.Code != http.StatusFound || rec.Header().Get("Location") != "https://example.com/b#shortlink=https://sho.rt/b" {
+		t.Errorf("return: want a redirect back, got %d %q", rec.Code, rec.Header().Get("Location"))
+	}
+
+	for name, q := range map[string]url.Values{
+		"no token":  {"url": {"https://example.com/"}},
+		"bad token": {"token": {"x.y"}, "url": {"https://example.com/"}},
+	} {
+		if rec := shorten(q); rec.Code != http.StatusUnauthorized {
+			t.Errorf("%s: want 401, got %d", name, rec.Code)
+		}
+	}
+	if rec := shorten(url.Values{"token": {resp.Token}}); rec.Code != http.StatusBadRequest {
+		t.Errorf("no url: want 400, got %d", rec.Code)
+	}
+	// The three creates of the minute are spent.
+	if rec := shorten(url.Values{"token": {resp.Token}, "url": {"https://example.com/c"}}); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("over the rate: want 429, got %d", rec.Code)
+	}
+
+	// Rotating the key revokes its bookmarklets.
+	h.bookmarkletThrottle = viewtoken.NewThrottle(0)
+	if _, _, err := h.keys.Rotate(context.Background(), id); err != nil {
+		t.Fatal(err)
+	}
+	if rec := shorten(url.Values{"token": {resp.Token}, "url": {"https://example.com/d"}}); rec.Code != http.StatusUnauthorized {
+		t.Errorf("rotated key: want 401, got %d: %s", rec.Code, rec.Body)
+	}
+
+	off := NewHandlerWithStore(Config{}, urlstore.NewMemoryClient())
+	if rec := do(off, http.MethodPost, bookmarkletPath, "", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("mint off: want 404, got %d", rec.Code)
+	}
+	if rec := do(off, http.MethodGet, shortenPath, "", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("shorten off: want 404, got %d", rec.Code)
+	}
+}
+
+func TestVerifyBookmarklet(t *testing.T) {
+	keys, _ := hmacsig.ParseKeyring("k1:secret")
+	now := time.Now()
+	token, err := mintBookmarklet(keys, bookmarkletClaims{ID: "t", Kind: "oidc", ExpiresAt: now.Add(time.Hour)}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c, err := verifyBookmarklet(keys, token, now.Add(time.Minute), time.Hour); err != nil || c.Kind != "oidc" {
+		t.Fatalf("verify: %+v, %v", c, err)
+	}
+	if _, err := verifyBookmarklet(keys, token, now.Add(2*time.Hour), 24*time.Hour); err != errBookmarkletExpired {
+		t.Errorf("past expiry: want errBookmarkletExpired, got %v", err)
+	}
+	if _, err := verifyBookmarklet(keys, token, now.Add(2*time.Minute), time.Minute); err != errBookmarkletExpired {
+		t.Errorf("signed before the TTL: want errBookmarkletExpired, got %v", err)
+	}
+	other, _ := hmacsig.ParseKeyring("k2:other")
+	if _, err := verifyBookmarklet(other, token, now, time.Hour); err != errBookmarkletInvalid {
+		t.Errorf("other keys: want errBookmarkletInvalid, got %v", err)
+	}
+}
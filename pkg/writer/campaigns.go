@@ -0,0 +1,228 @@
+package writer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/FlorinBalint/shortener/pkg/campaign"
+	"github.com/FlorinBalint/shortener/pkg/urlstore"
+)
+
+const (
+	campaignsPath   = "/write/v1/campaigns"
+	campaignsPrefix = campaignsPath + "/"
+
+	// maxCampaignLinks bounds the links counted in campaign stats.
+	maxCampaignLinks = 100000
+)
+
+type campaignListResponse struct {
+	Campaigns  []campaign.Campaign `json:"campaigns"`
+	NextCursor string              `json:"next_cursor,omitempty"`
+}
+
+type campaignStatsResponse struct {
+	campaign.Campaign
+	Stats campaign.Stats `json:"stats"`
+}
+
+// Named handler for /write/v1/campaigns, grouping links. Links join a
+// campaign with the campaign field when created or patched, and are listed
+// with /write/v1/links?campaign={id}.
+//
+//	GET    /write/v1/campaigns                 → list campaigns (prefix, owner, limit, cursor)
+//	POST   /write/v1/campaigns                 → create a campaign
+//	GET    /write/v1/campaigns/{id}            → read a campaign
+//	DELETE /write/v1/campaigns/{id}            → delete a campaign without live links
+//	GET    /write/v1/campaigns/{id}/stats      → aggregate the campaign's links
+//
+// Like links, campaigns are their owner's and the administrators': other
+// callers neither see them nor attach links to them.
+func (h *Handler) handleCampaigns(w http.ResponseWriter, r *http.Request, path string) {
+	caller, ok := h.authenticateManager(w, r)
+	if !ok {
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	id, sub, _ := strings.Cut(path, "/")
+	switch {
+	case id == "":
+		switch r.Method {
+		case http.MethodGet:
+			h.listCampaigns(ctx, w, caller, r.URL.Query())
+		case http.MethodPost:
+			h.createCampaign(ctx, w, r, caller)
+		default:
+			w.Header().Set("Allow", "GET, POST")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	case sub == "stats":
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", "GET")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		h.campaignStats(ctx, w, r, caller, id)
+	case sub != "":
+		http.NotFound(w, r)
+	case r.Method == http.MethodGet:
+		c, ok := h.getCampaign(ctx, w, r, caller, id)
+		if ok {
+			writeJSON(w, http.StatusOK, c)
+		}
+	case r.Method == http.MethodDelete:
+		h.deleteCampaign(ctx, w, r, caller, id)
+	default:
+		w.Header().Set("Allow", "GET, DELETE")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// listCampaigns lists every campaign to administrators, and their own to
+// other callers.
+func (h *Handler) listCampaigns(ctx context.Context, w http.ResponseWriter, caller principal, q url.Values) {
+	opts := campaign.ListOptions{Prefix: q.Get("prefix"), Owner: q.Get("owner"), Cursor: q.Get("cursor")}
+	if !caller.admin {
+		opts.Owner = caller.owner()
+	}
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > maxListLimit {
+			http.Error(w, fmt.Sprintf("limit must be between 1 and %d", maxListLimit), http.StatusBadRequest)
+			return
+		}
+		opts.Limit = n
+	}
+	page, err := h.campaigns.List(ctx, opts)
+	if errors.Is(err, campaign.ErrInvalidCursor) {
+		http.Error(w, "invalid cursor", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, "failed to list campaigns", http.StatusInternalServerError)
+		return
+	}
+	resp := campaignListResponse{Campaigns: page.Campaigns, NextCursor: page.NextCursor}
+	if resp.Campaigns == nil {
+		resp.Campaigns = []campaign.Campaign{}
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (h *Handler) createCampaign(ctx context.Context, w http.ResponseWriter, r *http.Request, caller principal) {
+	var c campaign.Campaign
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&c); err != nil {
+		http.Error(w, "invalid json body", http.StatusBadRequest)
+		return
+	}
+	if err := c.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	c.Owner = caller.owner()
+	c.CreatedAt = time.Now().UTC()
+	err := h.campaigns.Create(ctx, c)
+	switch {
+	case errors.Is(err, campaign.ErrAlreadyExists):
+		http.Error(w, "campaign already exists", http.StatusConflict)
+	case err != nil:
+		http.Error(w, "failed to store campaign", http.StatusInternalServerError)
+	default:
+		audit(r, caller, "campaign.create", c.ID)
+		writeJSON(w, http.StatusCreated, c)
+	}
+}
+
+// getCampaign reads campaign id, reporting failures to the client. The
+// campaigns of others are not found.
+func (h *Handler) getCampaign(ctx context.Context, w http.ResponseWriter, r *http.Request, caller principal, id string) (campaign.Campaign, bool) {
+	c, err := h.campaigns.Get(ctx, id)
+	if err == nil && !caller.mayManage(c.Owner) {
+		err = campaign.ErrNotFound
+	}
+	switch {
+	case errors.Is(err, campaign.ErrNotFound):
+		http.NotFound(w, r)
+		return c, false
+	case err != nil:
+		http.Error(w, "failed to read campaign", http.StatusInternalServerError)
+		return c, false
+	}
+	return c, true
+}
+
+// deleteCampaign refuses to delete a campaign live links still belong to:
+// they would silently join a new campaign reusing the ID.
+func (h *Handler) deleteCampaign(ctx context.Context, w http.ResponseWriter, r *http.Request, caller principal, id string) {
+	if _, ok := h.getCampaign(ctx, w, r, caller, id); !ok {
+		return
+	}
+	page, err := h.entries.ListEntries(ctx, urlstore.ListOptions{Campaign: id, Limit: 1})
+	if err != nil {
+		http.Error(w, "failed to list the campaign's links", http.StatusInternalServerError)
+		return
+	}
+	if len(page.Entries) > 0 {
+		http.Error(w, "campaign still has links", http.StatusConflict)
+		return
+	}
+	if err := h.campaigns.Delete(ctx, id); err != nil {
+		http.Error(w, "failed to delete campaign", http.StatusInternalServerError)
+		return
+	}
+	audit(r, caller, "campaign.delete", id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) campaignStats(ctx context.Context, w http.ResponseWriter, r *http.Request, caller principal, id string) {
+	c, ok := h.getCampaign(ctx, w, r, caller, id)
+	if !ok {
+		return
+	}
+	st, err := campaign.Summarize(ctx, h.entries, id, maxCampaignLinks)
+	if err != nil {
+		log.Printf("campaign: stats of %q: %v", id, err)
+		http.Error(w, "failed to aggregate the campaign's links", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, campaignStatsResponse{Campaign: c, Stats: st})
+}
+
+// checkCampaign reports a link attached to a campaign missing to caller
+// to the client. Empty IDs attach to no campaign.
+func (h *Handler) checkCampaign(ctx context.Context, w http.ResponseWriter, caller principal, id string) bool {
+	if err := h.campaignError(ctx, caller, id); err != nil {
+		writeRequestError(w, err)
+		return false
+	}
+	return true
+}
+
+// campaignError fails the request of a link attached to a missing
+// campaign, or to one caller may not manage, which is not found either.
+func (h *Handler) campaignError(ctx context.Context, caller principal, id string) error {
+	if id == "" {
+		return nil
+	}
+	c, err := h.campaigns.Get(ctx, id)
+	if err == nil && !caller.mayManage(c.Owner) {
+		err = campaign.ErrNotFound
+	}
+	switch {
+	case errors.Is(err, campaign.ErrNotFound):
+		return failRequest(http.StatusBadRequest, "campaign not found")
+	case err != nil:
+		return failRequest(http.StatusInternalServerError, "failed to read campaign")
+	}
+	return nil
+}
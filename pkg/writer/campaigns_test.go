@@ -0,0 +1,120 @@
+package writer
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/FlorinBalint/shortener/pkg/campaign"
+)
+
+func TestCampaigns(t *testing.T) {
+	h, _ := newTestHandler(t)
+	ownerID, owner := issueKey(t, h)
+	_, other := issueKey(t, h)
+	// as sends a request with an API key; "" is anonymous.
+	as := func(apiKey, method, path, ifMatch, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if apiKey != "" {
+			req.Header.Set("X-API-Key", apiKey)
+		}
+		if ifMatch != "" {
+			req.Header.Set("If-Match", ifMatch)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := as(owner, http.MethodPost, campaignsPath, "", `{"id":"launch","name":"Spring launch"}`); rec.Code != http.StatusCreated {
+		t.Fatalf("create: want 201, got %d: %s", rec.Code, rec.Body)
+	}
+	if rec := as(owner, http.MethodPost, campaignsPath, "", `{"id":"launch","name":"Again"}`); rec.Code != http.StatusConflict {
+		t.Fatalf("duplicate: want 409, got %d", rec.Code)
+	}
+	if rec := as(owner, http.MethodPost, campaignsPath, "", `{"id":"Bad ID","name":"x"}`); rec.Code != http.StatusBadRequest {
+		t.Fatalf("invalid id: want 400, got %d", rec.Code)
+	}
+
+	// Links join at create time or later, and only existing campaigns.
+	if rec := as(owner, http.MethodPost, "/write/v1", "", `{"url_key":"spring","url_target":"https://shop.example/spring","campaign":"launch"}`); rec.Code != http.StatusOK {
+		t.Fatalf("create link: want 200, got %d: %s", rec.Code, rec.Body)
+	}
+	if rec := as(owner, http.MethodPost, "/write/v1", "", `{"url_key":"x","url_target":"https://shop.example/","campaign":"missing"}`); rec.Code != http.StatusBadRequest {
+		t.Fatalf("missing campaign: want 400, got %d", rec.Code)
+	}
+	if rec := manage(h, http.MethodPatch, linksPrefix+"promo", etag(1), `{"campaign":"launch"}`); rec.Code != http.StatusOK {
+		t.Fatalf("attach: want 200, got %d: %s", rec.Code, rec.Body)
+	}
+
+	rec := manage(h, http.MethodGet, linksPath+"?campaign=launch", "", "")
+	var list listResponse
+	if err := json.NewDecoder(rec.Body).Decode(&list); err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Links) != 2 {
+		t.Fatalf("want the campaign's 2 links, got %+v", list.Links)
+	}
+
+	rec = as(owner, http.MethodGet, campaignsPrefix+"launch/stats", "", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("stats: want 200, got %d", rec.Code)
+	}
+	var stats campaignStatsResponse
+	if err := json.NewDecoder(rec.Body).Decode(&stats); err != nil {
+		t.Fatal(err)
+	}
+	if stats.Name != "Spring launch" || stats.Stats.Links != 2 || stats.Stats.Live != 2 || stats.Stats.TargetHosts["shop.example"] != 1 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+
+	rec = as(owner, http.MethodGet, campaignsPath+"?prefix=la", "", "")
+	var campaigns campaignListResponse
+	if err := json.NewDecoder(rec.Body).Decode(&campaigns); err != nil {
+		t.Fatal(err)
+	}
+	if len(campaigns.Campaigns) != 1 || campaigns.Campaigns[0].ID != "launch" {
+		t.Fatalf("unexpected list: %+v", campaigns)
+	}
+
+	// Other callers neither see the campaign nor use it.
+	if rec := as("", http.MethodGet, campaignsPath, "", ""); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("anonymous list: want 401, got %d", rec.Code)
+	}
+	rec = as(other, http.MethodGet, campaignsPath+"?owner="+ownerID, "", "")
+	campaigns = campaignListResponse{}
+	if err := json.NewDecoder(rec.Body).Decode(&campaigns); err != nil || len(campaigns.Campaigns) != 0 {
+		t.Fatalf("other list: want no campaign, got %+v, %v", campaigns, err)
+	}
+	for _, path := range []string{campaignsPrefix + "launch", campaignsPrefix + "launch/stats"} {
+		if rec := as(other, http.MethodGet, path, "", ""); rec.Code != http.StatusNotFound {
+			t.Fatalf("other %s: want 404, got %d", path, rec.Code)
+		}
+	}
+	if rec := as(other, http.MethodPost, "/write/v1", "", `{"url_key":"theirs","url_target":"https://shop.example/","campaign":"launch"}`); rec.Code != http.StatusBadRequest {
+		t.Fatalf("other attach: want 400, got %d", rec.Code)
+	}
+	if rec := as(other, http.MethodDelete, campaignsPrefix+"launch", "", ""); rec.Code != http.StatusNotFound {
+		t.Fatalf("other delete: want 404, got %d", rec.Code)
+	}
+
+	// Campaigns with links are not deleted.
+	if rec := as(owner, http.MethodDelete, campaignsPrefix+"launch", "", ""); rec.Code != http.StatusConflict {
+		t.Fatalf("delete with links: want 409, got %d", rec.Code)
+	}
+	if rec := manage(h, http.MethodPatch, linksPrefix+"promo", etag(2), `{"campaign":""}`); rec.Code != http.StatusOK {
+		t.Fatalf("detach promo: want 200, got %d: %s", rec.Code, rec.Body)
+	}
+	if rec := as(owner, http.MethodPatch, linksPrefix+"spring", etag(1), `{"campaign":""}`); rec.Code != http.StatusOK {
+		t.Fatalf("detach spring: want 200, got %d: %s", rec.Code, rec.Body)
+	}
+	if rec := as(owner, http.MethodDelete, campaignsPrefix+"launch", "", ""); rec.Code != http.StatusNoContent {
+		t.Fatalf("delete: want 204, got %d: %s", rec.Code, rec.Body)
+	}
+	if _, err := h.campaigns.Get(t.Context(), "launch"); !errors.Is(err, campaign.ErrNotFound) {
+		t.Fatalf("want the campaign deleted, got %v", err)
+	}
+}
@@ -0,0 +1,55 @@
+package writer
+
+import (
+	"fmt"
+	"net/http"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// requestError fails a request with what the client is told.
+type requestError struct {
+	status int
+	msg    string
+}
+
+func (e *requestError) Error() string {
+	return e.msg
+}
+
+// failRequest returns the error failing a request with status.
+func failRequest(status int, format string, args ...any) error {
+	return &requestError{status: status, msg: fmt.Sprintf(format, args...)}
+}
+
+// writeRequestError reports err, a requestError, to the client.
+func writeRequestError(w http.ResponseWriter, err error) {
+	if re, ok := err.(*requestError); ok {
+		http.Error(w, re.msg, re.status)
+		return
+	}
+	http.Error(w, "internal error", http.StatusInternalServerError)
+}
+
+// runChecks runs independent checks of a request concurrently, so it waits
+// for the slowest of their dependencies rather than for all of them in
+// turn. When checks fail, the first failing one in argument order is
+// reported to the client, as if they had run one after the other.
+func runChecks(w http.ResponseWriter, checks ...func() error) bool {
+	errs := make([]error, len(checks))
+	var g errgroup.Group
+	for i, check := range checks {
+		g.Go(func() error {
+			errs[i] = check()
+			return nil
+		})
+	}
+	g.Wait()
+	for _, err := range errs {
+		if err != nil {
+			writeRequestError(w, err)
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,52 @@
+package writer
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+func TestRunChecks(t *testing.T) {
+	// Each check waits for the other to start: they only finish when run
+	// concurrently.
+	var started sync.WaitGroup
+	started.Add(2)
+	barrier := func() error {
+		started.Done()
+		started.Wait()
+		return nil
+	}
+	if rec := httptest.NewRecorder(); !runChecks(rec, barrier, barrier) {
+		t.Fatalf("want the checks to pass, got %d", rec.Code)
+	}
+
+	// Failures are reported in argument order.
+	rec := httptest.NewRecorder()
+	ok := runChecks(rec,
+		func() error { return nil },
+		func() error { return failRequest(http.StatusConflict, "url_key already exists") },
+		func() error { return failRequest(http.StatusBadRequest, "rejected") },
+	)
+	if ok || rec.Code != http.StatusConflict {
+		t.Fatalf("want the first failure reported, got %v, %d: %s", ok, rec.Code, rec.Body)
+	}
+}
+
+func TestCreate_CustomKeyChecks(t *testing.T) {
+	h, _ := newTestHandler(t)
+	tests := []struct {
+		body string
+		want int
+	}{
+		// Taken and with a missing campaign: the campaign is reported.
+		{`{"url_key":"promo","url_target":"https://example.com/","campaign":"missing"}`, http.StatusBadRequest},
+		{`{"url_key":"promo","url_target":"https://example.com/"}`, http.StatusConflict},
+		{`{"url_key":"fresh","url_target":"https://example.com/"}`, http.StatusOK},
+	}
+	for _, tt := range tests {
+		if rec := do(h, http.MethodPost, "/write/v1", "", tt.body); rec.Code != tt.want {
+			t.Errorf("%s: want %d, got %d: %s", tt.body, tt.want, rec.Code, rec.Body)
+		}
+	}
+}
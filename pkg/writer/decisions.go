@@ -0,0 +1,190 @@
+package writer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/FlorinBalint/shortener/pkg/urlstore"
+)
+
+// decisionsHeader asks for the decision trail of a create, e.g. with
+// curl -H 'X-Debug-Decisions: 1'. Only administrators get it.
+const decisionsHeader = "X-Debug-Decisions"
+
+// Outcomes of decisions.
+const (
+	outcomePassed   = "passed"
+	outcomeRejected = "rejected"
+	outcomeChanged  = "changed"
+	outcomeMatched  = "matched"
+	outcomeSkipped  = "skipped"
+	outcomeFailed   = "failed"
+)
+
+// decision is a step of a decision trail.
+type decision struct {
+	Step    string `json:"step"`
+	Outcome string `json:"outcome"`
+	Detail  string `json:"detail,omitempty"`
+}
+
+// trail records the decisions taken on a request, in order, so support can
+// tell why a link was refused, renamed or stored as it was. It is safe for
+// the checks run at once. The nil *trail records nothing.
+type trail struct {
+	mu        sync.Mutex
+	decisions []decision
+}
+
+// add records a decision.
+func (t *trail) add(step, outcome, format string, args ...any) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.decisions = append(t.decisions, decision{Step: step, Outcome: outcome, Detail: fmt.Sprintf(format, args...)})
+}
+
+// result records step on subject, e.g. a key, as passed, or rejected with
+// err.
+func (t *trail) result(step, subject string, err error) {
+	switch {
+	case err == nil:
+		t.add(step, outcomePassed, "%s", subject)
+	case subject == "":
+		t.add(step, outcomeRejected, "%v", err)
+	default:
+		t.add(step, outcomeRejected, "%s: %v", subject, err)
+	}
+}
+
+// normalized records the normalization of what from raw to v, if it
+// changed it.
+func (t *trail) normalized(what, raw, v, how string) {
+	if raw != v {
+		t.add("normalize", outcomeChanged, "%s %q → %q (%s)", what, raw, v, how)
+	}
+}
+
+// traced returns check, recording its result as step on subject.
+func (t *trail) traced(step, subject string, check func() error) func() error {
+	if t == nil {
+		return check
+	}
+	return func() error {
+		err := check()
+		t.result(step, subject, err)
+		return err
+	}
+}
+
+// policies checks l against each policy of ps in turn, like ps.Check,
+// recording each one's verdict.
+func (t *trail) policies(ctx context.Context, ps Policies, l Link) error {
+	for i, p := range ps {
+		err := p.Check(ctx, l)
+		switch reason, ok := rejected(err); {
+		case err == nil:
+			t.add("policy", outcomePassed, "%s on %q", policyName(i), l.Key)
+		case ok:
+			t.add("policy", outcomeRejected, "%s on %q: %s", policyName(i), l.Key, reason)
+			return err
+		default:
+			t.add("policy", outcomeFailed, "%s on %q: %v", policyName(i), l.Key, err)
+			return err
+		}
+	}
+	return nil
+}
+
+type trailKey struct{}
+
+// trailFrom returns the decision trail of ctx, or nil.
+func trailFrom(ctx context.Context) *trail {
+	t, _ := ctx.Value(trailKey{}).(*trail)
+	return t
+}
+
+// explained serves an administrator's request with next, and answers with
+// the status, headers and response next wrote, wrapped with the decision
+// trail:
+//
+//	{"status":400, "response":"url_key is reserved", "decisions":[{"step":"reserved", "outcome":"matched", "detail":"..."}]}
+//
+// The response is next's JSON as is, or its text.
+func (h *Handler) explained(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	if _, ok := h.requireAdmin(w, r); !ok {
+		return
+	}
+	t := &trail{}
+	rec := &bufferedResponse{header: http.Header{}}
+	next(rec, r.WithContext(context.WithValue(r.Context(), trailKey{}, t)))
+
+	status := rec.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	var resp any = strings.TrimSuffix(rec.body.String(), "\n")
+	if strings.HasPrefix(rec.header.Get("Content-Type"), "application/json") && json.Valid(rec.body.Bytes()) {
+		resp = json.RawMessage(bytes.TrimSpace(rec.body.Bytes()))
+	}
+	for k, vs := range rec.header {
+		w.Header()[k] = vs
+	}
+	w.Header().Del("Content-Length")
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	writeJSON(w, status, struct {
+		Status    int        `json:"status"`
+		Response  any        `json:"response"`
+		Decisions []decision `json:"decisions"`
+	}{status, resp, t.decisions})
+}
+
+// bufferedResponse keeps a response for explained to wrap.
+type bufferedResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (b *bufferedResponse) Header() http.Header {
+	return b.header
+}
+
+func (b *bufferedResponse) WriteHeader(status int) {
+	if b.status == 0 {
+		b.status = status
+	}
+}
+
+func (b *bufferedResponse) Write(p []byte) (int, error) {
+	if b.status == 0 {
+		b.status = http.StatusOK
+	}
+	return b.body.Write(p)
+}
+
+// traceReserved records whether key, the url_key or an alias, hits a key
+// or prefix reserved everywhere or in the handler's tenant.
+func (h *Handler) traceReserved(t *trail, what, key string) {
+	if t == nil {
+		return
+	}
+	switch by := urlstore.UrlKey(key).ReservedBy(); {
+	case strings.HasSuffix(by, "/"):
+		t.add("reserved", outcomeMatched, "%s %q is under the reserved prefix %q", what, key, by)
+	case by != "":
+		t.add("reserved", outcomeMatched, "%s %q is the reserved key %q", what, key, by)
+	case h.reservedAlias(key):
+		t.add("reserved", outcomeMatched, "%s %q is reserved by the tenant", what, key)
+	default:
+		t.add("reserved", outcomePassed, "%s %q", what, key)
+	}
+}
@@ -0,0 +1,104 @@
+package writer
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/FlorinBalint/shortener/pkg/urlstore"
+)
+
+// explain creates a link as the admin, asking for the decision trail.
+func explain(t *testing.T, h http.Handler, body string) (int, json.RawMessage, []decision) {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/write/v1", strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer root")
+	req.Header.Set(decisionsHeader, "1")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	var got struct {
+		Status    int             `json:"status"`
+		Response  json.RawMessage `json:"response"`
+		Decisions []decision      `json:"decisions"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatalf("want a decision trail, got %d %q", rec.Code, rec.Body)
+	}
+	if got.Status != rec.Code {
+		t.Fatalf("want the status %d in the trail, got %d", rec.Code, got.Status)
+	}
+	return rec.Code, got.Response, got.Decisions
+}
+
+func hasDecision(ds []decision, step, outcome, detail string) bool {
+	return slices.ContainsFunc(ds, func(d decision) bool {
+		return d.Step == step && d.Outcome == outcome && strings.Contains(d.Detail, detail)
+	})
+}
+
+func TestDecisions(t *testing.T) {
+	store := urlstore.NewMemoryClient()
+	if err := store.CreateEntry(context.Background(), "taken", urlstore.URLEntry{URLTarget: "https://example.com", Version: 1}); err != nil {
+		t.Fatal(err)
+	}
+	h := NewHandlerWithStore(Config{
+		AdminToken:          "root",
+		CaseInsensitiveKeys: true,
+		Limits:              Limits{MaxAliasLength: 10},
+	}, store)
+
+	code, resp, ds := explain(t, h, `{"url_key":" /Static/Promo","url_target":"https://example.com"}`)
+	if code != http.StatusBadRequest || string(resp) != `"url_key is reserved"` {
+		t.Fatalf("reserved: want 400 url_key is reserved, got %d %s", code, resp)
+	}
+	for _, want := range []decision{
+		{"normalize", outcomeChanged, `" /Static/Promo" → "Static/Promo" (trimmed)`},
+		{"normalize", outcomeChanged, `"Static/Promo" → "static/promo" (case folded)`},
+		{"reserved", outcomeMatched, `under the reserved prefix "static/"`},
+		{"url_key", outcomeRejected, "url_key is reserved"},
+	} {
+		if !hasDecision(ds, want.Step, want.Outcome, want.Detail) {
+			t.Errorf("reserved: want %+v in %+v", want, ds)
+		}
+	}
+
+	_, _, ds = explain(t, h, `{"url_key":"much-too-long","url_target":"https://example.com"}`)
+	if !hasDecision(ds, "policy", outcomeRejected, `limits on "much-too-long": url_key is longer than 10`) {
+		t.Errorf("policy: want the limits rule rejecting the key in %+v", ds)
+	}
+	_, _, ds = explain(t, h, `{"url_key":"taken","url_target":"https://example.com"}`)
+	if !hasDecision(ds, "key_available", outcomeRejected, "already exists") || !hasDecision(ds, "policy", outcomePassed, `loops on "taken"`) {
+		t.Errorf("taken: want the key refused as taken, after every policy passed, in %+v", ds)
+	}
+
+	code, resp, ds = explain(t, h, `{"url_key":"fresh","url_target":"https://example.com","aliases":["/Other"]}`)
+	if code != http.StatusOK || !json.Valid(resp) || !strings.Contains(string(resp), `"url_key":"fresh"`) {
+		t.Fatalf("created: want 200 with the link, got %d %s", code, resp)
+	}
+	for _, want := range []decision{
+		{"normalize", outcomeChanged, `alias "/Other" → "Other"`},
+		{"reserved", outcomePassed, `alias "other"`},
+		{"aliases_available", outcomePassed, "other"},
+		{"store", outcomePassed, "fresh"},
+	} {
+		if !hasDecision(ds, want.Step, want.Outcome, want.Detail) {
+			t.Errorf("created: want %+v in %+v", want, ds)
+		}
+	}
+
+	// Only administrators get the trail.
+	req := httptest.NewRequest(http.MethodPost, "/write/v1", strings.NewReader(`{"url_key":"anon","url_target":"https://example.com"}`))
+	req.Header.Set(decisionsHeader, "1")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("anonymous: want 401, got %d %q", rec.Code, rec.Body)
+	}
+	if _, err := store.GetEntry(context.Background(), "anon"); err == nil {
+		t.Fatal("anonymous: want nothing created")
+	}
+}
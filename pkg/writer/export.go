@@ -0,0 +1,160 @@
+package writer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/FlorinBalint/shortener/pkg/bundle"
+	"github.com/FlorinBalint/shortener/pkg/hmacsig"
+	"github.com/FlorinBalint/shortener/pkg/urlstore"
+)
+
+const (
+	exportPath = "/write/v1/export"
+
+	// exportPageSize is the number of entries read, and held in memory, at
+	// a time while exporting.
+	exportPageSize = 500
+)
+
+// Named handler for /write/v1/export?format=ndjson: streams every link as
+// one JSON object per line, admin only.
+//
+// It accepts the filters of the links listing, and include_inactive=true to
+// export expired and deleted links as well. format=bundle wraps the lines
+// in a bundle encrypted and/or signed with the export keys (see
+// pkg/bundle), a frame per page. Pages are read from the store one at a
+// time and flushed as they are written, so memory stays bounded by a page
+// and a slow client slows the export down instead of buffering it.
+// A failure mid-stream aborts the response, so a truncated export cannot be
+// mistaken for a complete one.
+func (h *Handler) handleExport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	admin, ok := h.requireAdmin(w, r)
+	if !ok {
+		return
+	}
+	q := r.URL.Query()
+	format := q.Get("format")
+	switch format {
+	case "", "ndjson":
+	case "bundle":
+		if h.exportCipher == nil && h.exportKeys == nil {
+			http.Error(w, "bundles need export encryption or signing keys", http.StatusBadRequest)
+			return
+		}
+	default:
+		http.Error(w, "format must be ndjson or bundle", http.StatusBadRequest)
+		return
+	}
+	opts, err := parseListOptions(q)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if v := q.Get("include_inactive"); v != "" {
+		if opts.IncludeInactive, err = strconv.ParseBool(v); err != nil {
+			http.Error(w, "include_inactive must be a boolean", http.StatusBadRequest)
+			return
+		}
+	}
+	if opts.Limit == 0 {
+		opts.Limit = exportPageSize
+	}
+
+	audit(r, admin, "link.export", q.Encode())
+	rc := http.NewResponseController(w)
+	// Lines go to w, or to the frame of their page in a bundle.
+	var out io.Writer = w
+	var frame bytes.Buffer
+	var bw *bundle.Writer
+	if format == "bundle" {
+		out = &frame
+	}
+	enc := json.NewEncoder(out)
+	started := false
+	for {
+		page, err := h.exportPage(r.Context(), opts)
+		if err != nil {
+			if !started {
+				if errors.Is(err, urlstore.ErrInvalidCursor) {
+					http.Error(w, "invalid cursor", http.StatusBadRequest)
+				} else {
+					http.Error(w, "failed to list entries", http.StatusInternalServerError)
+				}
+				return
+			}
+			if r.Context().Err() == nil {
+				log.Printf("export aborted after cursor %q: %v", opts.Cursor, err)
+			}
+			panic(http.ErrAbortHandler)
+		}
+		if !started {
+			w.Header().Set("Content-Type", "application/x-ndjson")
+			started = true
+			if format == "bundle" {
+				if bw, err = bundle.NewWriter(w, h.exportCipher, h.exportKeys); err != nil {
+					return
+				}
+			}
+		}
+		for _, ke := range page.Entries {
+			if err := enc.Encode(linkResponse{URLKey: string(ke.Key), URLEntry: ke.Entry}); err != nil {
+				// the client went away
+				return
+			}
+		}
+		if bw != nil {
+			if _, err := bw.Write(frame.Bytes()); err != nil {
+				return
+			}
+			frame.Reset()
+		}
+		if err := rc.Flush(); err != nil && !errors.Is(err, http.ErrNotSupported) {
+			return
+		}
+		if page.NextCursor == "" {
+			if bw != nil {
+				bw.Close()
+			}
+			return
+		}
+		opts.Cursor = page.NextCursor
+	}
+}
+
+// exportPage reads one page, with its own timeout so a long export is not
+// bounded by a single deadline.
+func (h *Handler) exportPage(ctx context.Context, opts urlstore.ListOptions) (urlstore.ListPage, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	return h.entries.ListEntries(ctx, opts)
+}
+
+// exportKeyring parses ExportSigningKeys; it returns nil when bundles are
+// not signed.
+func (cfg Config) exportKeyring() (*hmacsig.Keyring, error) {
+	if cfg.ExportSigningKeys == "" {
+		if cfg.RequireSignedImports {
+			return nil, errors.New("REQUIRE_SIGNED_IMPORTS needs EXPORT_SIGNING_KEYS")
+		}
+		return nil, nil
+	}
+	k, err := hmacsig.ParseKeyring(cfg.ExportSigningKeys)
+	if err != nil {
+		return nil, fmt.Errorf("export signing keys: %w", err)
+	}
+	return k, nil
+}
@@ -0,0 +1,125 @@
+package writer
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/FlorinBalint/shortener/pkg/bundle"
+	"github.com/FlorinBalint/shortener/pkg/envelope"
+	"github.com/FlorinBalint/shortener/pkg/urlstore"
+)
+
+func TestExport(t *testing.T) {
+	store := urlstore.NewMemoryClient()
+	ctx := context.Background()
+	for i := range 5 {
+		if err := store.CreateEntry(ctx, urlstore.UrlKey(fmt.Sprintf("k%d", i)), urlstore.URLEntry{URLTarget: "https://example.com"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.CreateEntry(ctx, "gone", urlstore.URLEntry{URLTarget: "https://example.com", DeletedAt: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	h := NewHandlerWithStore(Config{AdminToken: "root"}, store)
+
+	export := func(query string) []string {
+		t.Helper()
+		rec := adminDo(h, http.MethodGet, "/write/v1/export?"+query, "")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: want 200, got %d", query, rec.Code)
+		}
+		if ct := rec.Header().Get("Content-Type"); ct != "application/x-ndjson" {
+			t.Fatalf("content type: got %q", ct)
+		}
+		var keys []string
+		sc := bufio.NewScanner(rec.Body)
+		for sc.Scan() {
+			var l linkResponse
+			if err := json.Unmarshal(sc.Bytes(), &l); err != nil {
+				t.Fatalf("line %q: %v", sc.Text(), err)
+			}
+			keys = append(keys, l.URLKey)
+		}
+		return keys
+	}
+
+	// Small pages exercise the cursor loop.
+	if got := export("format=ndjson&limit=2"); len(got) != 5 || got[0] != "k0" || got[4] != "k4" {
+		t.Fatalf("want k0..k4, got %v", got)
+	}
+	if got := export("include_inactive=true"); len(got) != 6 {
+		t.Fatalf("include_inactive: want 6 links, got %v", got)
+	}
+	if got := export("prefix=k3"); len(got) != 1 {
+		t.Fatalf("prefix: want k3, got %v", got)
+	}
+
+	if rec := adminDo(h, http.MethodGet, "/write/v1/export?format=csv", ""); rec.Code != http.StatusBadRequest {
+		t.Fatalf("csv: want 400, got %d", rec.Code)
+	}
+	if rec := adminDo(h, http.MethodGet, "/write/v1/export?cursor=bogus", ""); rec.Code != http.StatusBadRequest {
+		t.Fatalf("bad cursor: want 400, got %d", rec.Code)
+	}
+	if rec := do(h, http.MethodGet, "/write/v1/export", "", ""); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("anonymous: want 401, got %d", rec.Code)
+	}
+}
+
+func TestExport_Bundle(t *testing.T) {
+	ctx := context.Background()
+	cfg := Config{AdminToken: "root", ExportSigningKeys: "k1:backup-secret", RequireSignedImports: true}
+	cipher, _ := envelope.NewCipher(bytes.Repeat([]byte{7}, envelope.DataKeySize))
+	source := urlstore.NewMemoryClient()
+	for i := range 3 {
+		if err := source.CreateEntry(ctx, urlstore.UrlKey(fmt.Sprintf("k%d", i)), urlstore.URLEntry{URLTarget: "https://example.com/?token=s3cret"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	h := NewHandlerWithStore(cfg, source)
+	h.exportCipher = cipher
+
+	rec := adminDo(h, http.MethodGet, "/write/v1/export?format=bundle&limit=2", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("export: want 200, got %d: %s", rec.Code, rec.Body)
+	}
+	exported := rec.Body.String()
+	if !bundle.IsBundle([]byte(exported)) || strings.Contains(exported, "s3cret") {
+		t.Fatalf("want an encrypted bundle, got %q", exported)
+	}
+
+	restored := urlstore.NewMemoryClient()
+	into := NewHandlerWithStore(cfg, restored)
+	into.exportCipher = cipher
+	if rec := adminDo(into, http.MethodPost, "/write/v1/import", exported); rec.Code != http.StatusOK {
+		t.Fatalf("import: want 200, got %d: %s", rec.Code, rec.Body)
+	}
+	if e, err := restored.GetEntry(ctx, "k2"); err != nil || e.URLTarget != "https://example.com/?token=s3cret" {
+		t.Fatalf("want k2 restored, got %+v, %v", e, err)
+	}
+
+	// Tampered, truncated and plain bodies are refused.
+	lines := strings.SplitAfter(exported, "\n")
+	forged := NewHandlerWithStore(Config{AdminToken: "root", ExportSigningKeys: "k1:forged"}, source)
+	forged.exportCipher = cipher
+	for name, body := range map[string]string{
+		"truncated": strings.Join(lines[:len(lines)-3], ""),
+		"forged":    adminDo(forged, http.MethodGet, "/write/v1/export?format=bundle", "").Body.String(),
+		"plain":     `{"url_key":"x","url_target":"https://example.com"}`,
+	} {
+		if rec := adminDo(into, http.MethodPost, "/write/v1/import", body); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: want 400, got %d: %s", name, rec.Code, rec.Body)
+		}
+	}
+
+	plain := NewHandlerWithStore(Config{AdminToken: "root"}, source)
+	if rec := adminDo(plain, http.MethodGet, "/write/v1/export?format=bundle", ""); rec.Code != http.StatusBadRequest {
+		t.Errorf("bundle without keys: want 400, got %d", rec.Code)
+	}
+}
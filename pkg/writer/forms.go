@@ -0,0 +1,151 @@
+package writer
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// maxWriteBody bounds the body of a create.
+const maxWriteBody = 1 << 20
+
+// decodeWriteRequest reads the create in the body of r: JSON or, for
+// integrations and HTML forms that can only post forms, form fields,
+// urlencoded or multipart. A urlencoded body that is a JSON object is read
+// as JSON, as curl -d sends one. Form posts from browsers on other origins
+// than the writer's and FormOrigins are refused, so pages cannot post
+// links in the name of signed-in visitors.
+func (h *Handler) decodeWriteRequest(w http.ResponseWriter, r *http.Request) (writeRequest, error) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxWriteBody)
+	var req writeRequest
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case "application/x-www-form-urlencoded":
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			return req, failRequest(http.StatusBadRequest, "invalid form body")
+		}
+		if trimmed := bytes.TrimSpace(body); len(trimmed) > 0 && trimmed[0] == '{' {
+			if err := json.Unmarshal(trimmed, &req); err != nil {
+				return req, failRequest(http.StatusBadRequest, "invalid json body")
+			}
+			return req, nil
+		}
+		if err := h.checkFormOrigin(r); err != nil {
+			return req, err
+		}
+		form, err := url.ParseQuery(string(body))
+		if err != nil {
+			return req, failRequest(http.StatusBadRequest, "invalid form body")
+		}
+		return formWriteRequest(form)
+	case "multipart/form-data":
+		if err := h.checkFormOrigin(r); err != nil {
+			return req, err
+		}
+		if err := r.ParseMultipartForm(maxWriteBody); err != nil {
+			return req, failRequest(http.StatusBadRequest, "invalid multipart body")
+		}
+		defer r.MultipartForm.RemoveAll()
+		return formWriteRequest(r.MultipartForm.Value)
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return req, failRequest(http.StatusBadRequest, "invalid json body")
+	}
+	return req, nil
+}
+
+// checkFormOrigin refuses form posts made by browsers from other origins.
+func (h *Handler) checkFormOrigin(r *http.Request) error {
+	if err := h.formOrigins.Check(r); err != nil {
+		return failRequest(http.StatusForbidden, "form posts from other origins are refused; add the form's origin to FORM_ORIGINS")
+	}
+	return nil
+}
+
+// formWriteRequest maps form fields to a create. Fields are named as in
+// JSON; lists are given as repeated fields or comma-separated, booleans as
+// strconv.ParseBool or a checkbox's "on", and metadata as a JSON object.
+func formWriteRequest(form url.Values) (writeRequest, error) {
+	req := writeRequest{
+		URLKey:          form.Get("url_key"),
+		URLTarget:       form.Get("url_target"),
+		AliasOf:         form.Get("alias_of"),
+		AllowedCIDRs:    formList(form, "allowed_cidrs"),
+		AllowedReferers: formList(form, "allowed_referers"),
+		Interstitial:    form.Get("interstitial"),
+		ReferrerPolicy:  form.Get("referrer_policy"),
+		ScrubParams:     formList(form, "scrub_params"),
+		HoldToken:       form.Get("hold_token"),
+		Campaign:        form.Get("campaign"),
+		Notes:           form.Get("notes"),
+		Aliases:         formList(form, "aliases"),
+	}
+	var err error
+	if v := form.Get("expires_at"); v != "" {
+		if req.ExpiresAt, err = time.Parse(time.RFC3339, v); err != nil {
+			return req, failRequest(http.StatusBadRequest, "expires_at must be an RFC 3339 time")
+		}
+	}
+	if req.ForwardQuery, err = formBool(form, "forward_query"); err != nil {
+		return req, err
+	}
+	if req.BurnAfterReading, err = formBool(form, "burn_after_reading"); err != nil {
+		return req, err
+	}
+	if v := form.Get("max_redirects_per_second"); v != "" {
+		if req.MaxRedirectsPerSecond, err = strconv.Atoi(v); err != nil {
+			return req, failRequest(http.StatusBadRequest, "max_redirects_per_second must be an integer")
+		}
+	}
+	if v := form.Get("metadata"); v != "" {
+		if !json.Valid([]byte(v)) {
+			return req, failRequest(http.StatusBadRequest, "metadata must be a JSON object")
+		}
+		req.Metadata = json.RawMessage(v)
+	}
+	return req, nil
+}
+
+// formList returns the values of a repeated or comma-separated field.
+func formList(form url.Values, name string) []string {
+	var out []string
+	for _, v := range form[name] {
+		out = append(out, splitList(v)...)
+	}
+	return out
+}
+
+// formBool parses a boolean field; unset is false.
+func formBool(form url.Values, name string) (bool, error) {
+	switch v := form.Get(name); v {
+	case "":
+		return false, nil
+	case "on":
+		return true, nil
+	default:
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return false, failRequest(http.StatusBadRequest, "%s must be a boolean", name)
+		}
+		return b, nil
+	}
+}
+
+// formOrigins returns the check of the origins of form posts, trusting
+// FormOrigins besides the writer's own.
+func (cfg Config) formOrigins() (*http.CrossOriginProtection, error) {
+	p := http.NewCrossOriginProtection()
+	for _, origin := range cfg.FormOrigins {
+		if err := p.AddTrustedOrigin(origin); err != nil {
+			return nil, fmt.Errorf("FORM_ORIGINS: %v", err)
+		}
+	}
+	return p, nil
+}
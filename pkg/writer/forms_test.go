@@ -0,0 +1,76 @@
+package writer
+
+import (
+	"bytes"
+	"context"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/FlorinBalint/shortener/pkg/urlstore"
+)
+
+func TestCreate_Forms(t *testing.T) {
+	h := NewHandlerWithStore(Config{FormOrigins: []string{"https://intranet.example.com"}}, urlstore.NewMemoryClient())
+	post := func(contentType, body string, header ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "https://sho.rt/write/v1", strings.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		for i := 0; i < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+	const form = "application/x-www-form-urlencoded"
+
+	rec := post(form, "url_key=docs&url_target=https%3A%2F%2Fexample.com%2Fdocs&allowed_referers=example.com,*.example.com&forward_query=on")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("form: want 200, got %d: %s", rec.Code, rec.Body)
+	}
+	e, err := h.store.GetEntry(context.Background(), "docs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.URLTarget != "https://example.com/docs" || !e.ForwardQuery || !slices.Equal(e.AllowedReferers, []string{"example.com", "*.example.com"}) {
+		t.Errorf("want the form fields kept, got %+v", e)
+	}
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	mw.WriteField("url_key", "blog")
+	mw.WriteField("url_target", "https://example.com/blog")
+	mw.Close()
+	if rec := post(mw.FormDataContentType(), body.String()); rec.Code != http.StatusOK {
+		t.Fatalf("multipart: want 200, got %d: %s", rec.Code, rec.Body)
+	}
+	if _, err := h.store.GetEntry(context.Background(), "blog"); err != nil {
+		t.Errorf("multipart: %v", err)
+	}
+
+	// curl -d sends JSON as a form.
+	if rec := post(form, `{"url_key":"curl","url_target":"https://example.com/"}`); rec.Code != http.StatusOK {
+		t.Errorf("JSON sent as a form: want 200, got %d: %s", rec.Code, rec.Body)
+	}
+
+	for name, tt := range map[string]struct {
+		body   string
+		header []string
+		want   int
+	}{
+		"cross-site":      {"url_target=https%3A%2F%2Fexample.com", []string{"Sec-Fetch-Site", "cross-site", "Origin", "https://evil.example"}, http.StatusForbidden},
+		"trusted origin":  {"url_key=trusted&url_target=https%3A%2F%2Fexample.com", []string{"Sec-Fetch-Site", "cross-site", "Origin", "https://intranet.example.com"}, http.StatusOK},
+		"same origin":     {"url_key=same&url_target=https%3A%2F%2Fexample.com", []string{"Sec-Fetch-Site", "same-origin"}, http.StatusOK},
+		"bad boolean":     {"url_target=https%3A%2F%2Fexample.com&burn_after_reading=maybe", nil, http.StatusBadRequest},
+		"bad expiry":      {"url_target=https%3A%2F%2Fexample.com&expires_at=tomorrow", nil, http.StatusBadRequest},
+		"missing target":  {"url_key=nothing", nil, http.StatusBadRequest},
+		"bad form escape": {"url_target=%zz", nil, http.StatusBadRequest},
+	} {
+		if rec := post(form, tt.body, tt.header...); rec.Code != tt.want {
+			t.Errorf("%s: want %d, got %d: %s", name, tt.want, rec.Code, rec.Body)
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package writer
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/FlorinBalint/shortener/pkg/machineclaim"
+)
+
+const adminGeneratorsPath = "/write/v1/admin/generators"
+
+// defaultKeygenClaimTTL matches keygen's default -claim.heartbeat of 10s.
+const defaultKeygenClaimTTL = 30 * time.Second
+
+// Named handler for /write/v1/admin/generators (admin only):
+//
+//	GET /write/v1/admin/generators → the keygens' machine claims, each live,
+//	                                 stale or in conflict
+func (h *Handler) handleAdminGenerators(w http.ResponseWriter, r *http.Request) {
+	if _, ok := h.requireAdmin(w, r); !ok {
+		return
+	}
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	claims, err := h.claims.List(ctx)
+	if err != nil {
+		http.Error(w, "failed to list generators", http.StatusInternalServerError)
+		return
+	}
+	ttl := h.claimTTL
+	if ttl <= 0 {
+		ttl = defaultKeygenClaimTTL
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"generators": machineclaim.Inventory(claims, ttl, time.Now())})
+}
@@ -0,0 +1,43 @@
+package writer
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/FlorinBalint/shortener/pkg/machineclaim"
+	"github.com/FlorinBalint/shortener/pkg/urlstore"
+)
+
+func TestAdminGenerators(t *testing.T) {
+	h := NewHandlerWithStore(Config{AdminToken: "root"}, urlstore.NewMemoryClient())
+	ctx := context.Background()
+	for _, c := range []machineclaim.Claim{
+		{ClusterID: 4, MachineID: 1, Pod: "keygen-1", Zone: "europe-west1-b"},
+		{ClusterID: 4, MachineID: 0, Pod: "keygen-0", Zone: "europe-west1-b"},
+	} {
+		if err := machineclaim.Acquire(ctx, h.claims, c, time.Minute); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rec := adminDo(h, http.MethodGet, adminGeneratorsPath, "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("want 200, got %d", rec.Code)
+	}
+	var resp struct {
+		Generators []machineclaim.Generator `json:"generators"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Generators) != 2 || resp.Generators[0].Pod != "keygen-0" || resp.Generators[0].Status != machineclaim.StatusLive {
+		t.Fatalf("want both generators live, ordered by machine, got %+v", resp.Generators)
+	}
+
+	if rec := do(h, http.MethodGet, adminGeneratorsPath, "", ""); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("without the admin token: want 401, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,482 @@
+package writer
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/FlorinBalint/shortener/pkg/bundle"
+	"github.com/FlorinBalint/shortener/pkg/referer"
+	"github.com/FlorinBalint/shortener/pkg/urlstore"
+)
+
+const (
+	importPath = "/write/v1/import"
+
+	// maxImportRecords and maxImportBytes bound a single import run.
+	maxImportRecords = 10000
+	maxImportBytes   = 32 << 20
+
+	// maxSuffixAttempts bounds the aliases tried by the suffix policy.
+	maxSuffixAttempts = 100
+)
+
+// Collision policies of an import run, for records whose alias is taken.
+const (
+	// onConflictFail imports nothing if any alias is taken.
+	onConflictFail = "fail"
+	// onConflictSkip leaves the existing link and skips the record.
+	onConflictSkip = "skip"
+	// onConflictOverwrite replaces the existing link, live or not.
+	onConflictOverwrite = "overwrite"
+	// onConflictSuffix imports the record under alias-2, alias-3, ...
+	onConflictSuffix = "suffix"
+)
+
+// Outcomes of an import record.
+const (
+	importCreated     = "created"
+	importOverwritten = "overwritten"
+	importRenamed     = "renamed"
+	importSkipped     = "skipped"
+	importConflict    = "conflict"
+	importInvalid     = "invalid"
+	importFailed      = "failed"
+)
+
+// importRecord is one line of an import; export lines are valid records.
+type importRecord struct {
+	URLKey                string    `json:"url_key"`
+	URLTarget             string    `json:"url_target"`
+	AliasOf               string    `json:"alias_of,omitempty"`
+	ExpiresAt             time.Time `json:"expires_at,omitzero"`
+	AllowedCIDRs          []string  `json:"allowed_cidrs,omitempty"`
+	AllowedReferers       []string  `json:"allowed_referers,omitempty"`
+	Interstitial          string    `json:"interstitial,omitempty"`
+	ReferrerPolicy        string    `json:"referrer_policy,omitempty"`
+	ForwardQuery          bool      `json:"forward_query,omitempty"`
+	ScrubParams           []string  `json:"scrub_params,omitempty"`
+	MaxRedirectsPerSecond int       `json:"max_redirects_per_second,omitempty"`
+	// Schedule is kept, so event links keep switching targets.
+	Schedule []urlstore.ScheduledTarget `json:"schedule,omitempty"`
+	// BurnAfterReading is kept, so one-shot links stay one-shot.
+	BurnAfterReading bool `json:"burn_after_reading,omitempty"`
+	// Flag is kept, so links under review stay flagged.
+	Flag string `json:"flag,omitempty"`
+	// Notes and Metadata round-trip through export.
+	Notes    string          `json:"notes,omitempty"`
+	Metadata json.RawMessage `json:"metadata,omitempty"`
+}
+
+// importResult is one line of the results file, in input order.
+type importResult struct {
+	Line   int    `json:"line"`
+	URLKey string `json:"url_key,omitempty"`
+	Status string `json:"status"`
+	// Key is the alias the record was imported under, when renamed.
+	Key   string `json:"key,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// importItem is a parsed record, or the reason it cannot be imported.
+type importItem struct {
+	line  int
+	key   string
+	entry urlstore.URLEntry
+	err   string
+}
+
+// Named handler for /write/v1/import: imports links from NDJSON, admin only.
+//
+// Query parameters: on_conflict (fail, skip, overwrite or suffix; default
+// fail) and dry_run=true, which reports what a run would do without
+// writing. The response is an NDJSON results file with one line per input
+// line. With on_conflict=fail, any taken alias fails the whole run with 409
+// before anything is written. The body may be an export bundle, which is
+// verified and decrypted first.
+func (h *Handler) handleImport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	admin, ok := h.requireAdmin(w, r)
+	if !ok {
+		return
+	}
+	q := r.URL.Query()
+	policy := q.Get("on_conflict")
+	switch policy {
+	case "":
+		policy = onConflictFail
+	case onConflictFail, onConflictSkip, onConflictOverwrite, onConflictSuffix:
+	default:
+		http.Error(w, "on_conflict must be one of fail, skip, overwrite, suffix", http.StatusBadRequest)
+		return
+	}
+	var dryRun bool
+	if v := q.Get("dry_run"); v != "" {
+		var err error
+		if dryRun, err = strconv.ParseBool(v); err != nil {
+			http.Error(w, "dry_run must be a boolean", http.StatusBadRequest)
+			return
+		}
+	}
+	body, err := h.importBody(http.MaxBytesReader(w, r.Body, maxImportBytes))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	items, err := readImport(bytes.NewReader(body))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	ctx := r.Context()
+	for i := range items {
+		if items[i].err == "" && h.reservedAlias(items[i].key) {
+			items[i].err = "url_key is reserved"
+		}
+		// An alias has no target to check: its canonical link, maybe later
+		// in the file, is checked as its own record.
+		if items[i].err == "" && items[i].entry.AliasOf == "" {
+			items[i].err = h.importProblem(ctx, Link{Key: items[i].key, Target: items[i].entry.URLTarget, Custom: true})
+		}
+		if items[i].err == "" {
+			items[i].err = h.importScheduleProblem(ctx, Link{Key: items[i].key, Custom: true}, items[i].entry.Schedule)
+		}
+	}
+
+	results := make([]importResult, len(items))
+	status := http.StatusOK
+	if policy == onConflictFail || dryRun {
+		// Plan every record against the store, remembering aliases claimed
+		// earlier in the run.
+		claimed := make(map[string]bool)
+		conflicts := false
+		for i, it := range items {
+			results[i] = h.planImport(ctx, it, policy, claimed)
+			conflicts = conflicts || results[i].Status == importConflict
+		}
+		if policy == onConflictFail && conflicts {
+			status = http.StatusConflict
+		}
+	}
+	if !dryRun && status == http.StatusOK {
+		for i, it := range items {
+			results[i] = h.applyImport(ctx, it, policy)
+		}
+		audit(r, admin, "link.import", fmt.Sprintf("on_conflict=%s records=%d", policy, len(items)))
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Content-Disposition", `attachment; filename="import-results.ndjson"`)
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	for _, res := range results {
+		_ = enc.Encode(res)
+	}
+}
+
+// importBody reads the body of an import, opening it if it is a bundle.
+// Unsigned bodies are refused when imports must be signed.
+func (h *Handler) importBody(r io.Reader) ([]byte, error) {
+	body, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("reading import: %v", err)
+	}
+	var signed bool
+	if bundle.IsBundle(body) {
+		var info bundle.Info
+		if body, info, err = bundle.Open(body, h.exportCipher, h.exportKeys); err != nil {
+			return nil, err
+		}
+		signed = info.Signed
+	}
+	if h.requireSignedImports && !signed {
+		return nil, errors.New("imports must be signed bundles")
+	}
+	return body, nil
+}
+
+// readImport parses the NDJSON body. Malformed lines become invalid items
+// rather than failing the run.
+func readImport(body io.Reader) ([]importItem, error) {
+	var items []importItem
+	sc := bufio.NewScanner(body)
+	sc.Buffer(make([]byte, 0, 64<<10), 1<<20)
+	for line := 1; sc.Scan(); line++ {
+		if len(sc.Bytes()) == 0 {
+			continue
+		}
+		if len(items) == maxImportRecords {
+			return nil, fmt.Errorf("imports are limited to %d records", maxImportRecords)
+		}
+		items = append(items, parseImportRecord(line, sc.Bytes()))
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("reading import: %v", err)
+	}
+	return items, nil
+}
+
+func parseImportRecord(line int, raw []byte) importItem {
+	it := importItem{line: line}
+	var rec importRecord
+	if err := json.Unmarshal(raw, &rec); err != nil {
+		it.err = "invalid json"
+		return it
+	}
+	it.key = urlstore.NormalizeKey(rec.URLKey)
+	if err := urlstore.UrlKey(it.key).Validate(); err != nil {
+		it.err = err.Error()
+		return it
+	}
+	rec.AliasOf = urlstore.NormalizeKey(rec.AliasOf)
+	if err := validateAliasRequest(writeRequest{
+		URLTarget:             rec.URLTarget,
+		AliasOf:               rec.AliasOf,
+		Schedule:              rec.Schedule,
+		AllowedCIDRs:          rec.AllowedCIDRs,
+		AllowedReferers:       rec.AllowedReferers,
+		Interstitial:          rec.Interstitial,
+		BurnAfterReading:      rec.BurnAfterReading,
+		ReferrerPolicy:        rec.ReferrerPolicy,
+		ForwardQuery:          rec.ForwardQuery,
+		ScrubParams:           rec.ScrubParams,
+		MaxRedirectsPerSecond: rec.MaxRedirectsPerSecond,
+	}); err != nil {
+		it.err = err.Error()
+		return it
+	}
+	if !rec.ExpiresAt.IsZero() && !rec.ExpiresAt.After(time.Now()) {
+		it.err = "expires_at must be in the future"
+		return it
+	}
+	if err := urlstore.ValidateSchedule(rec.Schedule); err != nil {
+		it.err = err.Error()
+		return it
+	}
+	if rec.BurnAfterReading && urlstore.UrlKey(it.key).IsWildcard() {
+		it.err = "burn_after_reading is not supported for wildcard keys"
+		return it
+	}
+	allowed, err := normalizeCIDRs(rec.AllowedCIDRs)
+	if err != nil {
+		it.err = err.Error()
+		return it
+	}
+	referers, err := referer.Normalize(rec.AllowedReferers)
+	if err != nil {
+		it.err = err.Error()
+		return it
+	}
+	if err := validateInterstitial(rec.Interstitial); err != nil {
+		it.err = err.Error()
+		return it
+	}
+	if err := validateReferrerPolicy(rec.ReferrerPolicy); err != nil {
+		it.err = err.Error()
+		return it
+	}
+	scrubParams, err := normalizeScrubParams(rec.ScrubParams)
+	if err != nil {
+		it.err = err.Error()
+		return it
+	}
+	if err := validateRedirectRate(rec.MaxRedirectsPerSecond); err != nil {
+		it.err = err.Error()
+		return it
+	}
+	if err := validateNotes(rec.Notes); err != nil {
+		it.err = err.Error()
+		return it
+	}
+	if err := validateFlag(rec.Flag); err != nil {
+		it.err = err.Error()
+		return it
+	}
+	metadata, err := normalizeMetadata(rec.Metadata)
+	if err != nil {
+		it.err = err.Error()
+		return it
+	}
+	it.entry = urlstore.URLEntry{
+		URLTarget:             rec.URLTarget,
+		AliasOf:               rec.AliasOf,
+		ExpiresAt:             rec.ExpiresAt,
+		Schedule:              rec.Schedule,
+		AllowedCIDRs:          allowed,
+		AllowedReferers:       referers,
+		Interstitial:          rec.Interstitial,
+		ReferrerPolicy:        rec.ReferrerPolicy,
+		ForwardQuery:          rec.ForwardQuery,
+		ScrubParams:           scrubParams,
+		MaxRedirectsPerSecond: rec.MaxRedirectsPerSecond,
+		Version:               1,
+		BurnAfterReading:      rec.BurnAfterReading,
+		Flag:                  rec.Flag,
+		Notes:                 rec.Notes,
+		Metadata:              metadata,
+	}
+	return it
+}
+
+// planImport reports what applyImport would do with it.
+func (h *Handler) planImport(ctx context.Context, it importItem, policy string, claimed map[string]bool) importResult {
+	res := importResult{Line: it.line, URLKey: it.key}
+	if it.err != "" {
+		res.Status, res.Error = importInvalid, it.err
+		return res
+	}
+	taken, err := h.aliasTaken(ctx, it.key, claimed)
+	if err != nil {
+		res.Status, res.Error = importFailed, "failed checking existing key"
+		return res
+	}
+	if !taken {
+		claimed[it.key] = true
+		res.Status = importCreated
+		return res
+	}
+	switch policy {
+	case onConflictSkip:
+		res.Status = importSkipped
+	case onConflictOverwrite:
+		res.Status = importOverwritten
+	case onConflictSuffix:
+		for n := 2; n <= maxSuffixAttempts; n++ {
+			key := suffixed(it.key, n)
+			taken, err := h.aliasTaken(ctx, key, claimed)
+			if err != nil {
+				res.Status, res.Error = importFailed, "failed checking existing key"
+				return res
+			}
+			if !taken {
+				claimed[key] = true
+				res.Status, res.Key = importRenamed, key
+				return res
+			}
+		}
+		res.Status, res.Error = importConflict, "no free suffixed alias"
+	default:
+		res.Status = importConflict
+	}
+	return res
+}
+
+// applyImport writes one record according to policy.
+func (h *Handler) applyImport(ctx context.Context, it importItem, policy string) importResult {
+	res := importResult{Line: it.line, URLKey: it.key}
+	if it.err != "" {
+		res.Status, res.Error = importInvalid, it.err
+		return res
+	}
+	entry := it.entry
+	entry.CreationTimestamp = time.Now().UTC()
+
+	err := h.createImported(ctx, it.key, entry)
+	switch {
+	case err == nil:
+		res.Status = importCreated
+		return res
+	case !errors.Is(err, urlstore.ErrAlreadyExists):
+		res.Status, res.Error = importFailed, "failed to store entry"
+		return res
+	}
+
+	switch policy {
+	case onConflictSkip:
+		res.Status = importSkipped
+	case onConflictOverwrite:
+		if err := h.overwriteImported(ctx, it.key, entry); err != nil {
+			res.Status, res.Error = importFailed, "failed to overwrite entry"
+		} else {
+			res.Status = importOverwritten
+		}
+	case onConflictSuffix:
+		res.Status, res.Error = importConflict, "no free suffixed alias"
+		for n := 2; n <= maxSuffixAttempts; n++ {
+			key := suffixed(it.key, n)
+			err := h.createImported(ctx, key, entry)
+			if errors.Is(err, urlstore.ErrAlreadyExists) {
+				continue
+			}
+			if err != nil {
+				res.Status, res.Error = importFailed, "failed to store entry"
+			} else {
+				res.Status, res.Key, res.Error = importRenamed, key, ""
+			}
+			break
+		}
+	default:
+		// The alias was taken after planning.
+		res.Status = importConflict
+	}
+	return res
+}
+
+func (h *Handler) createImported(ctx context.Context, key string, entry urlstore.URLEntry) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	return h.store.CreateEntry(ctx, urlstore.UrlKey(key), entry)
+}
+
+// overwriteImported replaces the link at key with entry. A link that
+// expired or was deleted but not purged yet is removed and recreated.
+func (h *Handler) overwriteImported(ctx context.Context, key string, entry urlstore.URLEntry) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	err := h.store.UpdateEntry(ctx, urlstore.UrlKey(key), func(e *urlstore.URLEntry) error {
+		entry.Version = e.Version + 1
+		entry.CreationTimestamp = e.CreationTimestamp
+		entry.Owner = e.Owner
+		entry.UpdatedAt = time.Now().UTC()
+		*e = entry
+		return nil
+	})
+	if !errors.Is(err, urlstore.ErrNotFound) {
+		return err
+	}
+	if err := h.store.DeleteEntry(ctx, urlstore.UrlKey(key)); err != nil {
+		return err
+	}
+	return h.store.CreateEntry(ctx, urlstore.UrlKey(key), entry)
+}
+
+// aliasTaken reports whether key is claimed earlier in the run or held by a
+// stored link, including expired and deleted links not purged yet.
+func (h *Handler) aliasTaken(ctx context.Context, key string, claimed map[string]bool) (bool, error) {
+	if claimed[key] {
+		return true, nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	// key sorts first among the keys it prefixes. Pages may come back short
+	// of matches when a store filters in process, so read on until one.
+	opts := urlstore.ListOptions{Prefix: key, IncludeInactive: true, Limit: 100}
+	for {
+		page, err := h.entries.ListEntries(ctx, opts)
+		if err != nil {
+			return false, err
+		}
+		if len(page.Entries) > 0 {
+			return string(page.Entries[0].Key) == key, nil
+		}
+		if page.NextCursor == "" {
+			return false, nil
+		}
+		opts.Cursor = page.NextCursor
+	}
+}
+
+func suffixed(key string, n int) string {
+	return key + "-" + strconv.Itoa(n)
+}
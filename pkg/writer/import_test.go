@@ -0,0 +1,122 @@
+package writer
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/FlorinBalint/shortener/pkg/gcputil"
+	"github.com/FlorinBalint/shortener/pkg/urlstore"
+)
+
+const importBody = `{"url_key":"promo","url_target":"https://new.example.com/promo"}
+{"url_key":"fresh","url_target":"https://new.example.com/fresh"}
+not json
+{"url_key":"static/x","url_target":"https://new.example.com"}
+{"url_key":"gone","url_target":"https://new.example.com/gone"}
+`
+
+func runImport(t *testing.T, h http.Handler, query string) (int, []importResult) {
+	t.Helper()
+	rec := adminDo(h, http.MethodPost, "/write/v1/import?"+query, importBody)
+	var results []importResult
+	sc := bufio.NewScanner(rec.Body)
+	for sc.Scan() {
+		var res importResult
+		if err := json.Unmarshal(sc.Bytes(), &res); err != nil {
+			t.Fatalf("%s: line %q: %v", query, sc.Text(), err)
+		}
+		results = append(results, res)
+	}
+	return rec.Code, results
+}
+
+func statuses(results []importResult) string {
+	var s []string
+	for _, r := range results {
+		s = append(s, r.Status)
+	}
+	return strings.Join(s, ",")
+}
+
+func newImportHandler(t *testing.T) (*Handler, urlstore.Client) {
+	t.Helper()
+	h, store := newTestHandler(t)
+	h.adminToken = gcputil.StaticSecret("root")
+	// A deleted link still holds its alias until the janitor purges it.
+	if err := store.CreateEntry(context.Background(), "gone", urlstore.URLEntry{URLTarget: "https://old.example.com", DeletedAt: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	return h, store
+}
+
+func TestImport_Policies(t *testing.T) {
+	tests := []struct {
+		policy     string
+		wantCode   int
+		want       string
+		wantTarget string // of promo afterwards
+	}{
+		{"fail", http.StatusConflict, "conflict,created,invalid,invalid,conflict", "https://example.com/v1"},
+		{"skip", http.StatusOK, "skipped,created,invalid,invalid,skipped", "https://example.com/v1"},
+		{"overwrite", http.StatusOK, "overwritten,created,invalid,invalid,overwritten", "https://new.example.com/promo"},
+		{"suffix", http.StatusOK, "renamed,created,invalid,invalid,renamed", "https://example.com/v1"},
+	}
+	for _, tt := range tests {
+		h, store := newImportHandler(t)
+		for _, dryRun := range []bool{true, false} {
+			query := "on_conflict=" + tt.policy
+			if dryRun {
+				query += "&dry_run=true"
+			}
+			code, results := runImport(t, h, query)
+			if code != tt.wantCode || statuses(results) != tt.want {
+				t.Fatalf("%s: want %d %s, got %d %s", query, tt.wantCode, tt.want, code, statuses(results))
+			}
+			_, err := store.GetEntry(context.Background(), "fresh")
+			if wantFresh := !dryRun && tt.policy != "fail"; (err == nil) != wantFresh {
+				t.Fatalf("%s: fresh imported = %v, want %v", query, err == nil, wantFresh)
+			}
+		}
+		e, err := store.GetEntry(context.Background(), "promo")
+		if err != nil || e.URLTarget != tt.wantTarget {
+			t.Fatalf("%s: promo = %+v, %v", tt.policy, e, err)
+		}
+		if tt.policy == "overwrite" {
+			if e.Version != 2 {
+				t.Fatalf("overwrite: want version 2, got %d", e.Version)
+			}
+			if _, err := store.GetEntry(context.Background(), "gone"); err != nil {
+				t.Fatalf("overwrite: want the deleted link recreated, got %v", err)
+			}
+		}
+	}
+}
+
+func TestImport_SuffixSkipsTakenAliases(t *testing.T) {
+	h, store := newImportHandler(t)
+	if err := store.CreateEntry(context.Background(), "promo-2", urlstore.URLEntry{URLTarget: "https://example.com/2"}); err != nil {
+		t.Fatal(err)
+	}
+	_, results := runImport(t, h, "on_conflict=suffix")
+	if results[0].Key != "promo-3" || results[4].Key != "gone-2" {
+		t.Fatalf("want promo-3 and gone-2, got %+v", results)
+	}
+	if e, err := store.GetEntry(context.Background(), "promo-3"); err != nil || e.URLTarget != "https://new.example.com/promo" {
+		t.Fatalf("promo-3 = %+v, %v", e, err)
+	}
+}
+
+func TestImport_Validation(t *testing.T) {
+	h, _ := newImportHandler(t)
+	if rec := adminDo(h, http.MethodPost, "/write/v1/import?on_conflict=merge", importBody); rec.Code != http.StatusBadRequest {
+		t.Fatalf("unknown policy: want 400, got %d", rec.Code)
+	}
+	if rec := do(h, http.MethodPost, "/write/v1/import", "", importBody); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("anonymous: want 401, got %d", rec.Code)
+	}
+}
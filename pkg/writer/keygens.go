@@ -0,0 +1,262 @@
+package writer
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/FlorinBalint/shortener/pkg/discovery"
+)
+
+// defaultKeygenProbeInterval is how often keygens are probed, and their
+// name re-resolved once its records' TTL ran out.
+const defaultKeygenProbeInterval = 5 * time.Second
+
+// keygenProbeTimeout bounds a health probe of one keygen.
+const keygenProbeTimeout = 2 * time.Second
+
+// minKeygenResolveGap spaces the re-resolutions failures trigger.
+const minKeygenResolveGap = time.Second
+
+// keygenPool tracks the keygens behind the host of KeygenBase, the name of
+// keygen's headless service, or KeygenDiscovery, so that creates reach
+// live ones only. It resolves the name to the keygens (see pkg/discovery),
+// again once the records' TTL runs out, probes their /health and dials
+// the healthy ones in turn. A failed probe or dial marks the keygen
+// unhealthy, closes the idle connections, which may lead to it, and
+// re-resolves the name right away: otherwise a dead pod keeps failing
+// writes until DNS caches and its connections expire.
+type keygenPool struct {
+	// addr is the host:port of KeygenBase that dial replaces.
+	addr   string
+	health string
+	// resolve returns the keygens' host:port addresses, and expire has
+	// the next call resolve them again.
+	resolve func(ctx context.Context) ([]string, error)
+	expire  func()
+	// transport carries the writer's requests to keygen.
+	transport *http.Transport
+	// prober opens a new connection per probe, to the keygen its context
+	// names.
+	prober *http.Client
+	dialer net.Dialer
+	// wake asks run to re-resolve now.
+	wake chan struct{}
+
+	mu        sync.Mutex
+	endpoints []string
+	healthy   map[string]bool
+	next      int
+	resolved  time.Time
+}
+
+// probeEndpoint is the context key of the keygen a probe dials.
+type probeEndpoint struct{}
+
+// newKeygenPool tracks the keygens of base, found through target, a
+// discovery target, or base's host when empty, dialed by transport.
+func newKeygenPool(base, target string, transport *http.Transport) (*keygenPool, error) {
+	u, err := url.Parse(base)
+	if err != nil || u.Hostname() == "" {
+		return nil, fmt.Errorf("invalid KEYGEN_BASE_URL %q", base)
+	}
+	port := u.Port()
+	if port == "" {
+		port = "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+	}
+	addr := net.JoinHostPort(u.Hostname(), port)
+	if target == "" {
+		target = "dns:" + addr
+	}
+	keygens, err := discovery.NewWatcher(target, discovery.Config{})
+	if err != nil {
+		return nil, fmt.Errorf("KEYGEN_DISCOVERY: %w", err)
+	}
+	p := &keygenPool{
+		addr:      addr,
+		health:    base + "/health",
+		resolve:   keygens.Lookup,
+		expire:    keygens.Expire,
+		transport: transport,
+		wake:      make(chan struct{}, 1),
+		healthy:   make(map[string]bool),
+	}
+	probes := transport.Clone()
+	probes.DisableKeepAlives = true
+	probes.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if ep, ok := ctx.Value(probeEndpoint{}).(string); ok {
+			addr = ep
+		}
+		return p.dialer.DialContext(ctx, network, addr)
+	}
+	p.prober = &http.Client{Transport: probes, Timeout: keygenProbeTimeout}
+	transport.DialContext = p.dial
+	return p, nil
+}
+
+// Available reports whether a keygen is healthy.
+func (p *keygenPool) Available() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, ok := range p.healthy {
+		if ok {
+			return true
+		}
+	}
+	return false
+}
+
+// candidates returns the keygens to dial, in order: the healthy ones,
+// starting with the next in turn, or all of them when none is, or the
+// name itself when none was resolved.
+func (p *keygenPool) candidates() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var healthy []string
+	for _, ep := range p.endpoints {
+		if p.healthy[ep] {
+			healthy = append(healthy, ep)
+		}
+	}
+	switch {
+	case len(healthy) > 0:
+		p.next++
+		i := p.next % len(healthy)
+		return slices.Concat(healthy[i:], healthy[:i])
+	case len(p.endpoints) > 0:
+		return slices.Clone(p.endpoints)
+	}
+	return []string{p.addr}
+}
+
+// dial connects to a healthy keygen in place of the name, falling back to
+// the next on errors.
+func (p *keygenPool) dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	if addr != p.addr {
+		return p.dialer.DialContext(ctx, network, addr)
+	}
+	var err error
+	for _, ep := range p.candidates() {
+		var conn net.Conn
+		if conn, err = p.dialer.DialContext(ctx, network, ep); err == nil {
+			return conn, nil
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		p.markUnhealthy(ep, err)
+	}
+	return nil, err
+}
+
+// markUnhealthy records a failure of the keygen at ep. The first after it
+// was healthy has the name re-resolved.
+func (p *keygenPool) markUnhealthy(ep string, err error) {
+	p.mu.Lock()
+	was := p.healthy[ep]
+	p.healthy[ep] = false
+	p.mu.Unlock()
+	if !was {
+		return
+	}
+	log.Printf("keygen %s unhealthy: %v", ep, err)
+	p.transport.CloseIdleConnections()
+	p.expire()
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+}
+
+// probe checks the health of the keygen at ep.
+func (p *keygenPool) probe(ctx context.Context, ep string) error {
+	req, err := http.NewRequestWithContext(context.WithValue(ctx, probeEndpoint{}, ep), http.MethodGet, p.health, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := p.prober.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("health status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// refresh re-resolves the name, keeping the last keygens when that fails,
+// then probes every keygen at once.
+func (p *keygenPool) refresh(ctx context.Context) {
+	endpoints, err := p.resolve(ctx)
+	p.mu.Lock()
+	p.resolved = time.Now()
+	if err != nil {
+		log.Printf("resolving keygen %s: %v", p.addr, err)
+		endpoints = p.endpoints
+	} else {
+		slices.Sort(endpoints)
+		p.endpoints = endpoints
+		for ep := range p.healthy {
+			if !slices.Contains(endpoints, ep) {
+				delete(p.healthy, ep)
+			}
+		}
+	}
+	p.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, ep := range endpoints {
+		wg.Go(func() {
+			if err := p.probe(ctx, ep); err != nil {
+				p.markUnhealthy(ep, err)
+				return
+			}
+			p.mu.Lock()
+			was, known := p.healthy[ep]
+			p.healthy[ep] = true
+			p.mu.Unlock()
+			if known && !was {
+				log.Printf("keygen %s healthy again", ep)
+			}
+		})
+	}
+	wg.Wait()
+}
+
+// run refreshes the keygens every interval, and after failures, at most
+// every minKeygenResolveGap, until ctx is done.
+func (p *keygenPool) run(ctx context.Context, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		case <-p.wake:
+			p.mu.Lock()
+			wait := minKeygenResolveGap - time.Since(p.resolved)
+			p.mu.Unlock()
+			if wait > 0 {
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(wait):
+				}
+			}
+		}
+		p.refresh(ctx)
+	}
+}
@@ -0,0 +1,86 @@
+package writer
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestKeygenPool(t *testing.T) {
+	keygen := func(key string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/generate/v1" {
+				io.WriteString(w, key)
+			}
+		}))
+	}
+	a, b := keygen("a"), keygen("b")
+	defer b.Close()
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	p, err := newKeygenPool("http://keygen.invalid:8083", "", transport)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resolved := []string{a.Listener.Addr().String(), b.Listener.Addr().String()}
+	var resolveErr error
+	p.resolve = func(context.Context) ([]string, error) { return resolved, resolveErr }
+	client := &http.Client{Transport: transport}
+	fetch := func() (string, error) {
+		resp, err := client.Get("http://keygen.invalid:8083/generate/v1")
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		return string(body), err
+	}
+
+	ctx := context.Background()
+	if p.Available() {
+		t.Fatal("want no keygen available before the first probes")
+	}
+	p.refresh(ctx)
+	if !p.Available() {
+		t.Fatal("want the keygens available once probed")
+	}
+	if _, err := fetch(); err != nil {
+		t.Fatal(err)
+	}
+
+	// A keygen dying between probes is skipped on the first failed dial,
+	// which asks for a re-resolution.
+	a.Close()
+	for range 4 {
+		transport.CloseIdleConnections()
+		if key, err := fetch(); err != nil || key != "b" {
+			t.Fatalf("want the live keygen, got %q, %v", key, err)
+		}
+	}
+	select {
+	case <-p.wake:
+	default:
+		t.Error("want a re-resolution asked for")
+	}
+
+	// A failed resolution keeps the last keygens.
+	resolveErr = errors.New("no such host")
+	p.refresh(ctx)
+	if !p.Available() {
+		t.Error("want the keygens kept when resolving fails")
+	}
+
+	resolveErr = nil
+	resolved = []string{a.Listener.Addr().String()}
+	p.refresh(ctx)
+	if p.Available() {
+		t.Error("want no keygen available once the live one is gone from DNS")
+	}
+	transport.CloseIdleConnections()
+	if _, err := fetch(); err == nil || !strings.Contains(err.Error(), "refused") {
+		t.Errorf("want the dead keygen dialed as a last resort, got %v", err)
+	}
+}
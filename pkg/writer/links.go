@@ -0,0 +1,596 @@
+package writer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/FlorinBalint/shortener/pkg/notify"
+	"github.com/FlorinBalint/shortener/pkg/referer"
+	"github.com/FlorinBalint/shortener/pkg/urlstore"
+)
+
+const (
+	linksPath   = "/write/v1/links"
+	linksPrefix = linksPath + "/"
+
+	reversePath = "/write/v1/reverse"
+
+	// maxListLimit caps the page size a client may request, and the number
+	// of keys returned by a reverse lookup.
+	maxListLimit = 1000
+)
+
+// errPreconditionFailed aborts an update whose If-Match does not match.
+var errPreconditionFailed = errors.New("entry was modified concurrently")
+
+type linkResponse struct {
+	URLKey string `json:"url_key"`
+	urlstore.URLEntry
+}
+
+type listResponse struct {
+	Links      []linkResponse `json:"links"`
+	NextCursor string         `json:"next_cursor,omitempty"`
+	// Counts counts every link by status, when asked for with counts=true.
+	Counts *urlstore.StatusCounts `json:"counts,omitempty"`
+}
+
+type reverseResponse struct {
+	Target string   `json:"target"`
+	Keys   []string `json:"keys"`
+	// Truncated is set when more than maxListLimit keys map to the target.
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+// listOrders maps the order query parameter to store orderings.
+var listOrders = map[string]urlstore.ListOrder{
+	"":             urlstore.OrderByKey,
+	"key":          urlstore.OrderByKey,
+	"created_asc":  urlstore.OrderByCreatedAsc,
+	"created_desc": urlstore.OrderByCreatedDesc,
+}
+
+type updateRequest struct {
+	// URLTarget gives the link a target, making an alias a link of its own.
+	URLTarget *string `json:"url_target,omitempty"`
+	// AliasOf makes the link an alias of another, dropping its target,
+	// schedule, preview and restrictions.
+	AliasOf   *string    `json:"alias_of,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	// Schedule replaces the scheduled targets; an empty list removes them.
+	Schedule *[]urlstore.ScheduledTarget `json:"schedule,omitempty"`
+	// AllowedCIDRs replaces the IP restriction; an empty list lifts it.
+	AllowedCIDRs *[]string `json:"allowed_cidrs,omitempty"`
+	// AllowedReferers replaces the referer restriction; an empty list
+	// lifts it.
+	AllowedReferers *[]string `json:"allowed_referers,omitempty"`
+	// Interstitial replaces the page shown before redirecting; empty
+	// removes it.
+	Interstitial *string `json:"interstitial,omitempty"`
+	// ReferrerPolicy, ForwardQuery and ScrubParams replace those of the
+	// link; an empty policy keeps the reader's.
+	ReferrerPolicy *string   `json:"referrer_policy,omitempty"`
+	ForwardQuery   *bool     `json:"forward_query,omitempty"`
+	ScrubParams    *[]string `json:"scrub_params,omitempty"`
+	// MaxRedirectsPerSecond replaces the link's cap on redirects; 0 lifts
+	// it.
+	MaxRedirectsPerSecond *int `json:"max_redirects_per_second,omitempty"`
+	// Campaign moves the link to another campaign; an empty ID detaches it.
+	Campaign *string `json:"campaign,omitempty"`
+	Notes    *string `json:"notes,omitempty"`
+	// Flag flags the link for review, saying why; empty clears it.
+	Flag *string `json:"flag,omitempty"`
+	// Metadata replaces the link's metadata; null removes it.
+	Metadata json.RawMessage `json:"metadata,omitempty"`
+}
+
+// etag renders an entry version as a strong entity tag.
+func etag(version int64) string {
+	return `"` + strconv.FormatInt(version, 10) + `"`
+}
+
+// ifMatch reports whether an If-Match header value matches tag.
+// Weak tags never match, as If-Match requires strong comparison.
+func ifMatch(header, tag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || candidate == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// Named handler for /write/v1/links/{key}: read-back, conditional update and delete.
+func (h *Handler) handleLink(w http.ResponseWriter, r *http.Request, key string) {
+	key = urlstore.NormalizeKey(key)
+	if key == "" {
+		http.NotFound(w, r)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		caller, ok := h.authenticateManager(w, r)
+		if !ok {
+			return
+		}
+		h.getLink(w, r, caller, urlstore.UrlKey(key))
+	case http.MethodPatch, http.MethodDelete:
+		caller, ok := h.authenticateManager(w, r)
+		if !ok {
+			return
+		}
+		if r.Header.Get("If-Match") == "" {
+			http.Error(w, "If-Match header is required", http.StatusPreconditionRequired)
+			return
+		}
+		if r.Method == http.MethodPatch {
+			h.updateLink(w, r, caller, urlstore.UrlKey(key))
+		} else {
+			h.deleteLink(w, r, caller, urlstore.UrlKey(key))
+		}
+	default:
+		w.Header().Set("Allow", "GET, PATCH, DELETE")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// Named handler for /write/v1/links: paginated listing.
+//
+// Query parameters: order (key, created_asc or created_desc), prefix,
+// target_host (every link pointing at a domain), campaign, created_after
+// and created_before (RFC 3339, half-open range), status (active,
+// expired, flagged or deleted; active and flagged links by default),
+// limit and cursor (the next_cursor of the previous page, listed with the
+// same parameters). counts=true adds the count of every link by status,
+// counted while the page is listed.
+//
+// Administrators list and count every link; other callers list their own
+// links only, and cannot count.
+func (h *Handler) handleListLinks(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	caller, ok := h.authenticateManager(w, r)
+	if !ok {
+		return
+	}
+	opts, err := parseListOptions(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	withCounts := false
+	if v := r.URL.Query().Get("counts"); v != "" {
+		if withCounts, err = strconv.ParseBool(v); err != nil {
+			http.Error(w, "counts must be a boolean", http.StatusBadRequest)
+			return
+		}
+	}
+	if !caller.admin {
+		if withCounts {
+			http.Error(w, "counts are reserved to administrators", http.StatusForbidden)
+			return
+		}
+		opts.Owner = caller.owner()
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	var page urlstore.ListPage
+	var counts urlstore.StatusCounts
+	checks := []func() error{func() error {
+		var err error
+		page, err = h.entries.ListEntries(ctx, opts)
+		switch {
+		case errors.Is(err, urlstore.ErrInvalidCursor):
+			return failRequest(http.StatusBadRequest, "invalid cursor")
+		case err != nil:
+			return failRequest(http.StatusInternalServerError, "failed to list entries")
+		}
+		return nil
+	}}
+	if withCounts {
+		checks = append(checks, func() error {
+			var err error
+			if counts, err = h.entries.CountEntries(ctx); err != nil {
+				return failRequest(http.StatusInternalServerError, "failed to count entries")
+			}
+			return nil
+		})
+	}
+	if !runChecks(w, checks...) {
+		return
+	}
+
+	resp := listResponse{Links: make([]linkResponse, 0, len(page.Entries)), NextCursor: page.NextCursor}
+	if withCounts {
+		resp.Counts = &counts
+	}
+	for _, ke := range page.Entries {
+		resp.Links = append(resp.Links, linkResponse{URLKey: string(ke.Key), URLEntry: ke.Entry})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+// Named handler for /write/v1/reverse?target=<url>: the keys of live links
+// whose normalized target equals the given one. Administrators only.
+func (h *Handler) handleReverse(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if _, ok := h.requireAdmin(w, r); !ok {
+		return
+	}
+	target := r.URL.Query().Get("target")
+	if target == "" {
+		http.Error(w, "target is required", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	resp := reverseResponse{Target: urlstore.NormalizeTarget(target), Keys: []string{}}
+	opts := urlstore.ListOptions{Target: target, Limit: maxListLimit}
+	for {
+		page, err := h.entries.ListEntries(ctx, opts)
+		if err != nil {
+			http.Error(w, "failed to look up target", http.StatusInternalServerError)
+			return
+		}
+		for _, ke := range page.Entries {
+			if len(resp.Keys) == maxListLimit {
+				resp.Truncated = true
+				break
+			}
+			resp.Keys = append(resp.Keys, string(ke.Key))
+		}
+		if resp.Truncated || page.NextCursor == "" {
+			break
+		}
+		opts.Cursor = page.NextCursor
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+func parseListOptions(q url.Values) (urlstore.ListOptions, error) {
+	order, ok := listOrders[q.Get("order")]
+	if !ok {
+		return urlstore.ListOptions{}, fmt.Errorf("order must be one of key, created_asc, created_desc")
+	}
+	opts := urlstore.ListOptions{
+		Cursor: q.Get("cursor"),
+		Order:  order,
+		Prefix: urlstore.NormalizeKey(q.Get("prefix")),
+
+		TargetHost: urlstore.NormalizeHost(q.Get("target_host")),
+		Campaign:   q.Get("campaign"),
+	}
+	if v := q.Get("status"); v != "" {
+		status, err := urlstore.ParseStatus(v)
+		if err != nil {
+			return urlstore.ListOptions{}, err
+		}
+		opts.Status = status
+	}
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > maxListLimit {
+			return urlstore.ListOptions{}, fmt.Errorf("limit must be between 1 and %d", maxListLimit)
+		}
+		opts.Limit = n
+	}
+	for _, bound := range []struct {
+		param string
+		dst   *time.Time
+	}{
+		{"created_after", &opts.CreatedAfter},
+		{"created_before", &opts.CreatedBefore},
+	} {
+		v := q.Get(bound.param)
+		if v == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return urlstore.ListOptions{}, fmt.Errorf("%s must be an RFC 3339 timestamp", bound.param)
+		}
+		*bound.dst = t
+	}
+	return opts, nil
+}
+
+// getLink reads back a link of the caller; the links of others are not found.
+func (h *Handler) getLink(w http.ResponseWriter, r *http.Request, caller principal, key urlstore.UrlKey) {
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	entry, err := h.entries.GetEntry(ctx, key)
+	if err == nil && !caller.mayManage(entry.Owner) {
+		err = urlstore.ErrNotFound
+	}
+	if errors.Is(err, urlstore.ErrNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, "failed to read entry", http.StatusInternalServerError)
+		return
+	}
+	writeLink(w, key, entry)
+}
+
+func (h *Handler) updateLink(w http.ResponseWriter, r *http.Request, caller principal, key urlstore.UrlKey) {
+	var req updateRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&req); err != nil {
+		http.Error(w, "invalid json body", http.StatusBadRequest)
+		return
+	}
+	if req.URLTarget != nil && *req.URLTarget == "" {
+		http.Error(w, "url_target cannot be empty", http.StatusBadRequest)
+		return
+	}
+	if err := validateAliasUpdate(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.ExpiresAt != nil && !req.ExpiresAt.IsZero() && !req.ExpiresAt.After(time.Now()) {
+		http.Error(w, "expires_at must be in the future", http.StatusBadRequest)
+		return
+	}
+	var schedule []urlstore.ScheduledTarget
+	if req.Schedule != nil {
+		if err := urlstore.ValidateSchedule(*req.Schedule); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if len(*req.Schedule) > 0 {
+			schedule = *req.Schedule
+		}
+	}
+	var allowed []string
+	if req.AllowedCIDRs != nil {
+		var err error
+		if allowed, err = normalizeCIDRs(*req.AllowedCIDRs); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	var referers []string
+	if req.AllowedReferers != nil {
+		var err error
+		if referers, err = referer.Normalize(*req.AllowedReferers); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if req.Interstitial != nil {
+		if err := validateInterstitial(*req.Interstitial); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if req.ReferrerPolicy != nil {
+		if err := validateReferrerPolicy(*req.ReferrerPolicy); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	var scrubParams []string
+	if req.ScrubParams != nil {
+		var err error
+		if scrubParams, err = normalizeScrubParams(*req.ScrubParams); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if req.MaxRedirectsPerSecond != nil {
+		if err := validateRedirectRate(*req.MaxRedirectsPerSecond); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if req.Notes != nil {
+		if err := validateNotes(*req.Notes); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if req.Flag != nil {
+		if err := validateFlag(*req.Flag); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	metadata, err := normalizeMetadata(req.Metadata)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	if req.URLTarget != nil && !h.checkPolicies(ctx, w, Link{Key: string(key), Target: *req.URLTarget}) {
+		return
+	}
+	if req.AliasOf != nil {
+		canonical, err := h.canonicalEntry(ctx, string(key), *req.AliasOf)
+		if err != nil {
+			writeRequestError(w, err)
+			return
+		}
+		if !h.checkPolicies(ctx, w, Link{Key: string(key), Target: canonical.URLTarget}) {
+			return
+		}
+	}
+	if !runChecks(w, h.scheduleChecks(ctx, Link{Key: string(key)}, schedule)...) {
+		return
+	}
+	if req.Campaign != nil && !h.checkCampaign(ctx, w, caller, *req.Campaign) {
+		return
+	}
+
+	var updated urlstore.URLEntry
+	err = h.store.UpdateEntry(ctx, key, func(e *urlstore.URLEntry) error {
+		if !caller.mayManage(e.Owner) {
+			return urlstore.ErrNotFound
+		}
+		if !ifMatch(r.Header.Get("If-Match"), etag(e.Version)) {
+			return errPreconditionFailed
+		}
+		if e.AliasOf != "" && req.URLTarget == nil && restricts(req) {
+			return errAliasRestricted
+		}
+		if e.AliasOf != "" && req.URLTarget == nil && schedules(req) {
+			return errAliasScheduled
+		}
+		if req.URLTarget != nil {
+			if *req.URLTarget != e.URLTarget {
+				// the old preview describes the old target
+				e.Preview = nil
+			}
+			e.URLTarget = *req.URLTarget
+			e.AliasOf = ""
+		}
+		if req.AliasOf != nil {
+			e.AliasOf = *req.AliasOf
+			e.URLTarget, e.Preview, e.Schedule = "", nil, nil
+			e.AllowedCIDRs, e.AllowedReferers, e.Interstitial, e.BurnAfterReading = nil, nil, "", false
+			e.ReferrerPolicy, e.ForwardQuery, e.ScrubParams, e.MaxRedirectsPerSecond = "", false, nil, 0
+		}
+		if req.ExpiresAt != nil {
+			e.ExpiresAt = *req.ExpiresAt
+		}
+		if req.Schedule != nil {
+			e.Schedule = schedule
+		}
+		if req.AllowedCIDRs != nil {
+			e.AllowedCIDRs = allowed
+		}
+		if req.AllowedReferers != nil {
+			e.AllowedReferers = referers
+		}
+		if req.Interstitial != nil {
+			e.Interstitial = *req.Interstitial
+		}
+		if req.ReferrerPolicy != nil {
+			e.ReferrerPolicy = *req.ReferrerPolicy
+		}
+		if req.ForwardQuery != nil {
+			e.ForwardQuery = *req.ForwardQuery
+		}
+		if req.ScrubParams != nil {
+			e.ScrubParams = scrubParams
+		}
+		if req.MaxRedirectsPerSecond != nil {
+			e.MaxRedirectsPerSecond = *req.MaxRedirectsPerSecond
+		}
+		if req.Campaign != nil {
+			e.Campaign = *req.Campaign
+		}
+		if req.Notes != nil {
+			e.Notes = *req.Notes
+		}
+		if req.Flag != nil {
+			e.Flag = *req.Flag
+		}
+		if req.Metadata != nil {
+			e.Metadata = metadata
+		}
+		e.UpdatedAt = time.Now().UTC()
+		e.Version++
+		updated = *e
+		return nil
+	})
+	if !h.writeMutationError(w, r, err) {
+		return
+	}
+	audit(r, caller, "link.update", string(key))
+	if h.previews != nil && updated.Preview == nil && updated.AliasOf == "" {
+		h.previews.Enqueue(key, updated.URLTarget)
+	}
+	writeLink(w, key, updated)
+}
+
+// notify reports the create or delete of a link to the notifier, if any.
+// Whether a deleted link had a custom alias is not known.
+func (h *Handler) notify(caller principal, action, key string, e urlstore.URLEntry, custom bool) {
+	if h.notifier == nil {
+		return
+	}
+	ev := notify.Event{Action: action, Key: key, Target: e.URLTarget, Owner: e.Owner, Custom: custom, By: caller.String()}
+	if h.tenant != nil {
+		ev.Tenant = h.tenant.ID
+	}
+	h.notifier.Notify(ev)
+}
+
+// deleteLink soft-deletes the entry; the janitor purges it after the retention window.
+func (h *Handler) deleteLink(w http.ResponseWriter, r *http.Request, caller principal, key urlstore.UrlKey) {
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	var deleted urlstore.URLEntry
+	err := h.store.UpdateEntry(ctx, key, func(e *urlstore.URLEntry) error {
+		if !caller.mayManage(e.Owner) {
+			return urlstore.ErrNotFound
+		}
+		if !ifMatch(r.Header.Get("If-Match"), etag(e.Version)) {
+			return errPreconditionFailed
+		}
+		e.DeletedAt = time.Now().UTC()
+		e.Version++
+		deleted = *e
+		return nil
+	})
+	if !h.writeMutationError(w, r, err) {
+		return
+	}
+	audit(r, caller, "link.delete", string(key))
+	h.notify(caller, notify.Deleted, string(key), deleted, false)
+	if owner := deleted.Owner; owner != "" {
+		if err := h.quotas.Release(ctx, owner); err != nil {
+			log.Printf("quota: release for %s: %v", owner, err)
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// writeMutationError reports err to the client and returns whether the mutation succeeded.
+func (h *Handler) writeMutationError(w http.ResponseWriter, r *http.Request, err error) bool {
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, urlstore.ErrNotFound):
+		http.NotFound(w, r)
+	case errors.Is(err, errPreconditionFailed):
+		http.Error(w, "entry does not match If-Match", http.StatusPreconditionFailed)
+	case errors.Is(err, errAliasRestricted), errors.Is(err, errAliasScheduled):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		http.Error(w, "failed to update entry", http.StatusInternalServerError)
+	}
+	return false
+}
+
+func writeLink(w http.ResponseWriter, key urlstore.UrlKey, entry urlstore.URLEntry) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", etag(entry.Version))
+	_ = json.NewEncoder(w).Encode(linkResponse{URLKey: string(key), URLEntry: entry})
+}
@@ -0,0 +1,809 @@
+package writer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/FlorinBalint/shortener/pkg/keycheck"
+	"github.com/FlorinBalint/shortener/pkg/testutil"
+	"github.com/FlorinBalint/shortener/pkg/urlstore"
+)
+
+func newTestHandler(t *testing.T) (*Handler, urlstore.Client) {
+	t.Helper()
+	store := urlstore.NewMemoryClient()
+	err := store.CreateEntry(context.Background(), "promo", urlstore.URLEntry{
+		URLTarget: "https://example.com/v1",
+		Version:   1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewHandlerWithStore(Config{AdminToken: "root"}, store), store
+}
+
+func do(h http.Handler, method, path, ifMatch, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if ifMatch != "" {
+		req.Header.Set("If-Match", ifMatch)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+// manage is do as the administrator, who may read and change every link,
+// for handlers with the admin token "root".
+func manage(h http.Handler, method, path, ifMatch, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer root")
+	if ifMatch != "" {
+		req.Header.Set("If-Match", ifMatch)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestCreate_DryRun(t *testing.T) {
+	h, store := newTestHandler(t)
+
+	rec := do(h, http.MethodPost, "/write/v1?dry_run=true", "", `{"url_key":"/Spring","url_target":"https://example.com/s","allowed_cidrs":["10.1.2.3/8"]}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("dry run: want 200, got %d: %s", rec.Code, rec.Body)
+	}
+	var resp dryRunResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if !resp.DryRun || resp.URLKey != "Spring" || resp.URLTarget != "https://example.com/s" || resp.AllowedCIDRs[0] != "10.0.0.0/8" {
+		t.Fatalf("want the normalized link, got %+v", resp)
+	}
+	if _, err := store.GetEntry(context.Background(), "Spring"); !errors.Is(err, urlstore.ErrNotFound) {
+		t.Fatalf("dry run stored the link: %v", err)
+	}
+
+	// No keygen is configured: a dry run must not ask it for a key.
+	if rec := do(h, http.MethodPost, "/write/v1?dry_run=1", "", `{"url_target":"https://example.com/g"}`); rec.Code != http.StatusOK {
+		t.Fatalf("generated key: want 200, got %d: %s", rec.Code, rec.Body)
+	}
+	if rec := do(h, http.MethodPost, "/write/v1?dry_run=true", "", `{"url_key":"promo","url_target":"https://example.com"}`); rec.Code != http.StatusConflict {
+		t.Fatalf("taken key: want 409, got %d", rec.Code)
+	}
+	if rec := do(h, http.MethodPost, "/write/v1?dry_run=true", "", `{"url_key":"static/app.js","url_target":"https://example.com"}`); rec.Code != http.StatusBadRequest {
+		t.Fatalf("invalid key: want 400, got %d", rec.Code)
+	}
+	if rec := do(h, http.MethodPost, "/write/v1?dry_run=maybe", "", `{"url_key":"x","url_target":"https://example.com"}`); rec.Code != http.StatusBadRequest {
+		t.Fatalf("invalid dry_run: want 400, got %d", rec.Code)
+	}
+}
+
+func TestCreate_CaseInsensitiveKeys(t *testing.T) {
+	store := urlstore.NewMemoryClient()
+	h := NewHandlerWithStore(Config{CaseInsensitiveKeys: true}, store)
+	if rec := createAs(h, "", "Spring/Sale"); rec.Code != http.StatusOK {
+		t.Fatalf("create: want 200, got %d: %s", rec.Code, rec.Body)
+	}
+	if _, err := store.GetEntry(context.Background(), "spring/sale"); err != nil {
+		t.Fatalf("want the alias stored lowercase: %v", err)
+	}
+	if rec := createAs(h, "", "SPRING/sale"); rec.Code != http.StatusConflict {
+		t.Fatalf("differently cased duplicate: want 409, got %d", rec.Code)
+	}
+}
+
+func TestCreate_WildcardKeys(t *testing.T) {
+	store := urlstore.NewMemoryClient()
+	h := NewHandlerWithStore(Config{}, store)
+	tests := []struct {
+		body string
+		want int
+	}{
+		{`{"url_key":"docs/*","url_target":"https://docs.example.com/{rest}"}`, http.StatusOK},
+		{`{"url_key":"docs/api/*","url_target":"https://api.example.com/{rest}"}`, http.StatusOK},
+		{`{"url_key":"*","url_target":"https://example.com/{rest}"}`, http.StatusBadRequest},
+		{`{"url_key":"docs/*/x","url_target":"https://example.com/"}`, http.StatusBadRequest},
+		{`{"url_key":"static/*","url_target":"https://example.com/{rest}"}`, http.StatusBadRequest},
+		{`{"url_key":"health/*","url_target":"https://example.com/{rest}"}`, http.StatusBadRequest},
+		{`{"url_key":"once/*","url_target":"https://example.com/{rest}","burn_after_reading":true}`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		if rec := do(h, http.MethodPost, "/write/v1", "", tt.body); rec.Code != tt.want {
+			t.Errorf("%s: want %d, got %d: %s", tt.body, tt.want, rec.Code, rec.Body)
+		}
+	}
+	if e, err := store.GetEntry(context.Background(), "docs/*"); err != nil || e.URLTarget != "https://docs.example.com/{rest}" {
+		t.Fatalf("want the wildcard stored with its template, got %+v, %v", e, err)
+	}
+}
+
+func TestIfMatch(t *testing.T) {
+	tests := []struct {
+		header string
+		want   bool
+	}{
+		{`"3"`, true},
+		{`"2", "3"`, true},
+		{`*`, true},
+		{`"2"`, false},
+		{`W/"3"`, false},
+	}
+	for _, tt := range tests {
+		if got := ifMatch(tt.header, `"3"`); got != tt.want {
+			t.Errorf("ifMatch(%s): want %v, got %v", tt.header, tt.want, got)
+		}
+	}
+}
+
+func TestGetLink_ReturnsETag(t *testing.T) {
+	h, _ := newTestHandler(t)
+	rec := manage(h, http.MethodGet, "/write/v1/links/promo", "", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("want 200, got %d", rec.Code)
+	}
+	if got := rec.Header().Get("ETag"); got != `"1"` {
+		t.Fatalf("ETag: want %q, got %q", `"1"`, got)
+	}
+	var resp linkResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.URLKey != "promo" || resp.URLTarget != "https://example.com/v1" {
+		t.Fatalf("unexpected body: %+v", resp)
+	}
+}
+
+func TestUpdateLink_Conditional(t *testing.T) {
+	h, store := newTestHandler(t)
+	body := `{"url_target":"https://example.com/v2"}`
+
+	if rec := manage(h, http.MethodPatch, "/write/v1/links/promo", "", body); rec.Code != http.StatusPreconditionRequired {
+		t.Fatalf("missing If-Match: want 428, got %d", rec.Code)
+	}
+	if rec := manage(h, http.MethodPatch, "/write/v1/links/promo", `"7"`, body); rec.Code != http.StatusPreconditionFailed {
+		t.Fatalf("stale If-Match: want 412, got %d", rec.Code)
+	}
+
+	rec := manage(h, http.MethodPatch, "/write/v1/links/promo", `"1"`, body)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("matching If-Match: want 200, got %d", rec.Code)
+	}
+	if got := rec.Header().Get("ETag"); got != `"2"` {
+		t.Fatalf("ETag after update: want %q, got %q", `"2"`, got)
+	}
+
+	// A second editor still holding the first version loses.
+	if rec := manage(h, http.MethodPatch, "/write/v1/links/promo", `"1"`, `{"url_target":"https://example.com/other"}`); rec.Code != http.StatusPreconditionFailed {
+		t.Fatalf("lost update: want 412, got %d", rec.Code)
+	}
+	entry, _ := store.GetEntry(context.Background(), "promo")
+	if entry.URLTarget != "https://example.com/v2" {
+		t.Fatalf("target: want the first editor's change, got %q", entry.URLTarget)
+	}
+}
+
+func TestDeleteLink_Conditional(t *testing.T) {
+	h, store := newTestHandler(t)
+
+	if rec := manage(h, http.MethodDelete, "/write/v1/links/promo", `"9"`, ""); rec.Code != http.StatusPreconditionFailed {
+		t.Fatalf("stale If-Match: want 412, got %d", rec.Code)
+	}
+	if rec := manage(h, http.MethodDelete, "/write/v1/links/promo", `"1"`, ""); rec.Code != http.StatusNoContent {
+		t.Fatalf("matching If-Match: want 204, got %d", rec.Code)
+	}
+	if _, err := store.GetEntry(context.Background(), "promo"); err != urlstore.ErrNotFound {
+		t.Fatalf("deleted entry: want ErrNotFound, got %v", err)
+	}
+	if rec := manage(h, http.MethodDelete, "/write/v1/links/promo", "*", ""); rec.Code != http.StatusNotFound {
+		t.Fatalf("second delete: want 404, got %d", rec.Code)
+	}
+}
+
+func TestLink_OwnerOnly(t *testing.T) {
+	h, _ := newTestHandler(t)
+	_, owner := issueKey(t, h)
+	_, other := issueKey(t, h)
+	if rec := createAs(h, owner, "mine"); rec.Code != http.StatusOK {
+		t.Fatalf("create: want 200, got %d", rec.Code)
+	}
+	as := func(apiKey, method string) int {
+		req := httptest.NewRequest(method, "/write/v1/links/mine", strings.NewReader(`{"notes":"taken over"}`))
+		req.Header.Set("If-Match", "*")
+		if apiKey != "" {
+			req.Header.Set("X-API-Key", apiKey)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	for _, method := range []string{http.MethodGet, http.MethodPatch, http.MethodDelete} {
+		if code := as("", method); code != http.StatusUnauthorized {
+			t.Errorf("anonymous %s: want 401, got %d", method, code)
+		}
+		if code := as(other, method); code != http.StatusNotFound {
+			t.Errorf("other key %s: want 404, got %d", method, code)
+		}
+	}
+	if code := as(owner, http.MethodGet); code != http.StatusOK {
+		t.Fatalf("owner read: want 200, got %d", code)
+	}
+	if code := as(owner, http.MethodPatch); code != http.StatusOK {
+		t.Fatalf("owner update: want 200, got %d", code)
+	}
+	if rec := manage(h, http.MethodDelete, "/write/v1/links/mine", "*", ""); rec.Code != http.StatusNoContent {
+		t.Fatalf("admin delete: want 204, got %d", rec.Code)
+	}
+}
+
+func TestListLinks_OrderedPages(t *testing.T) {
+	h, store := newTestHandler(t)
+	base := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i, k := range []urlstore.UrlKey{"team/a", "team/b", "team/c"} {
+		err := store.CreateEntry(context.Background(), k, urlstore.URLEntry{
+			URLTarget:         "https://example.com/" + string(k),
+			CreationTimestamp: base.Add(time.Duration(i) * time.Hour),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var keys []string
+	path := "/write/v1/links?order=created_desc&prefix=team/&limit=2"
+	for cursor := ""; ; {
+		rec := manage(h, http.MethodGet, path+"&cursor="+url.QueryEscape(cursor), "", "")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("want 200, got %d: %s", rec.Code, rec.Body)
+		}
+		var resp listResponse
+		if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+		for _, l := range resp.Links {
+			keys = append(keys, l.URLKey)
+		}
+		if resp.NextCursor == "" {
+			break
+		}
+		cursor = resp.NextCursor
+	}
+	if got := strings.Join(keys, ","); got != "team/c,team/b,team/a" {
+		t.Fatalf("want newest first, got %s", got)
+	}
+}
+
+func TestListLinks_Scoped(t *testing.T) {
+	h, _ := newTestHandler(t)
+	_, owner := issueKey(t, h)
+	_, other := issueKey(t, h)
+	createAs(h, owner, "mine")
+	createAs(h, other, "theirs")
+	list := func(apiKey, query string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/write/v1/links?"+query, nil)
+		if apiKey != "" {
+			req.Header.Set("X-API-Key", apiKey)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := list("", ""); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("anonymous: want 401, got %d", rec.Code)
+	}
+	if rec := list(owner, "counts=true"); rec.Code != http.StatusForbidden {
+		t.Fatalf("counts: want 403 for a caller other than an administrator, got %d", rec.Code)
+	}
+	rec := list(owner, "status=active")
+	var resp listResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Links) != 1 || resp.Links[0].URLKey != "mine" {
+		t.Fatalf("owner: want only their own link, got %+v", resp.Links)
+	}
+
+	rec = manage(h, http.MethodGet, "/write/v1/links?counts=true", "", "")
+	resp = listResponse{}
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Links) != 3 || resp.Counts == nil || resp.Counts.Active != 3 {
+		t.Fatalf("admin: want every link counted, got %+v, %+v", resp.Links, resp.Counts)
+	}
+}
+
+func TestListLinks_InvalidParams(t *testing.T) {
+	h, _ := newTestHandler(t)
+	for _, query := range []string{
+		"order=random",
+		"limit=0",
+		"limit=5000",
+		"created_after=yesterday",
+		"cursor=!bad!",
+		"status=archived",
+		"counts=maybe",
+	} {
+		if rec := manage(h, http.MethodGet, "/write/v1/links?"+query, "", ""); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: want 400, got %d", query, rec.Code)
+		}
+	}
+}
+
+func TestListLinks_TargetHost(t *testing.T) {
+	h, store := newTestHandler(t)
+	if err := store.CreateEntry(context.Background(), "elsewhere", urlstore.URLEntry{URLTarget: "https://other.org/"}); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := manage(h, http.MethodGet, "/write/v1/links?target_host=WWW.Example.com", "", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("want 200, got %d", rec.Code)
+	}
+	var resp listResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Links) != 1 || resp.Links[0].URLKey != "promo" {
+		t.Fatalf("want only promo, got %+v", resp.Links)
+	}
+}
+
+func TestListLinks_Status(t *testing.T) {
+	h, store := newTestHandler(t)
+	expired := urlstore.URLEntry{URLTarget: "https://example.com/old", ExpiresAt: time.Now().Add(-time.Hour)}
+	if err := store.CreateEntry(context.Background(), "old", expired); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.CreateEntry(context.Background(), "sketchy", urlstore.URLEntry{URLTarget: "https://example.com/s", Version: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if rec := manage(h, http.MethodPatch, "/write/v1/links/sketchy", etag(1), `{"flag":"reported as phishing"}`); rec.Code != http.StatusOK {
+		t.Fatalf("flag: want 200, got %d: %s", rec.Code, rec.Body)
+	}
+
+	list := func(query string) listResponse {
+		t.Helper()
+		rec := manage(h, http.MethodGet, "/write/v1/links?"+query, "", "")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: want 200, got %d: %s", query, rec.Code, rec.Body)
+		}
+		var resp listResponse
+		if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+	for status, want := range map[string]string{"active": "promo", "expired": "old", "flagged": "sketchy", "deleted": ""} {
+		var keys []string
+		for _, l := range list("status=" + status).Links {
+			keys = append(keys, l.URLKey)
+		}
+		if got := strings.Join(keys, ","); got != want {
+			t.Errorf("status=%s: want %q, got %q", status, want, got)
+		}
+	}
+
+	resp := list("counts=true&limit=1")
+	want := urlstore.StatusCounts{Active: 1, Expired: 1, Flagged: 1}
+	if resp.Counts == nil || *resp.Counts != want {
+		t.Fatalf("counts: want %+v, got %+v", want, resp.Counts)
+	}
+	if resp := list(""); resp.Counts != nil {
+		t.Errorf("want no counts unless asked for, got %+v", resp.Counts)
+	}
+
+	if rec := manage(h, http.MethodPatch, "/write/v1/links/sketchy", etag(2), `{"flag":""}`); rec.Code != http.StatusOK {
+		t.Fatalf("unflag: want 200, got %d: %s", rec.Code, rec.Body)
+	}
+	if resp := list("status=flagged"); len(resp.Links) != 0 {
+		t.Errorf("want no flagged link left, got %+v", resp.Links)
+	}
+}
+
+func TestReverse(t *testing.T) {
+	h, store := newTestHandler(t)
+	for key, target := range map[urlstore.UrlKey]string{
+		"promo-copy": "https://EXAMPLE.com/v1#section",
+		"other":      "https://example.com/v2",
+	} {
+		if err := store.CreateEntry(context.Background(), key, urlstore.URLEntry{URLTarget: target}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rec := manage(h, http.MethodGet, "/write/v1/reverse?target="+url.QueryEscape("https://example.com:443/v1"), "", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("want 200, got %d", rec.Code)
+	}
+	var resp reverseResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(resp.Keys, ","); got != "promo,promo-copy" {
+		t.Fatalf("want promo,promo-copy, got %s", got)
+	}
+	if resp.Target != "https://example.com/v1" {
+		t.Fatalf("normalized target: got %q", resp.Target)
+	}
+
+	if rec := manage(h, http.MethodGet, "/write/v1/reverse", "", ""); rec.Code != http.StatusBadRequest {
+		t.Fatalf("missing target: want 400, got %d", rec.Code)
+	}
+	if rec := do(h, http.MethodGet, "/write/v1/reverse?target=https://example.com/v1", "", ""); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("without the admin token: want 401, got %d", rec.Code)
+	}
+}
+
+func TestAllowedCIDRs(t *testing.T) {
+	h, store := newTestHandler(t)
+
+	rec := do(h, http.MethodPost, "/write/v1", "", `{"url_key":"internal","url_target":"https://example.com/i","allowed_cidrs":["10.1.2.3/8","2001:db8::1"]}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("create: want 200, got %d: %s", rec.Code, rec.Body)
+	}
+	e, err := store.GetEntry(context.Background(), "internal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(e.AllowedCIDRs, ","); got != "10.0.0.0/8,2001:db8::1/128" {
+		t.Fatalf("want normalized CIDRs, got %s", got)
+	}
+
+	if rec := do(h, http.MethodPost, "/write/v1", "", `{"url_key":"bad","url_target":"https://example.com","allowed_cidrs":["10.0.0.0/33"]}`); rec.Code != http.StatusBadRequest {
+		t.Fatalf("invalid CIDR: want 400, got %d", rec.Code)
+	}
+
+	if rec := manage(h, http.MethodPatch, "/write/v1/links/internal", "*", `{"allowed_cidrs":[]}`); rec.Code != http.StatusOK {
+		t.Fatalf("patch: want 200, got %d", rec.Code)
+	}
+	if e, _ := store.GetEntry(context.Background(), "internal"); !e.TargetOnly() {
+		t.Fatalf("want restriction lifted, got %v", e.AllowedCIDRs)
+	}
+}
+
+func TestAllowedReferers(t *testing.T) {
+	h, store := newTestHandler(t)
+
+	rec := do(h, http.MethodPost, "/write/v1", "", `{"url_key":"spring","url_target":"https://example.com/s","allowed_referers":["WWW.News.example.com","none"]}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("create: want 200, got %d: %s", rec.Code, rec.Body)
+	}
+	e, err := store.GetEntry(context.Background(), "spring")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(e.AllowedReferers, ","); got != "news.example.com,none" {
+		t.Fatalf("want normalized referers, got %s", got)
+	}
+	if e.TargetOnly() {
+		t.Fatal("restricted link must not be cached as a bare target")
+	}
+
+	if rec := do(h, http.MethodPost, "/write/v1", "", `{"url_key":"bad","url_target":"https://example.com","allowed_referers":["https://news.example.com/"]}`); rec.Code != http.StatusBadRequest {
+		t.Fatalf("invalid pattern: want 400, got %d", rec.Code)
+	}
+
+	if rec := manage(h, http.MethodPatch, "/write/v1/links/spring", "*", `{"allowed_referers":[]}`); rec.Code != http.StatusOK {
+		t.Fatalf("patch: want 200, got %d", rec.Code)
+	}
+	if e, _ := store.GetEntry(context.Background(), "spring"); !e.TargetOnly() {
+		t.Fatalf("want restriction lifted, got %v", e.AllowedReferers)
+	}
+}
+
+func TestInterstitial(t *testing.T) {
+	h, store := newTestHandler(t)
+
+	rec := do(h, http.MethodPost, "/write/v1", "", `{"url_key":"fund","url_target":"https://broker.example.com","interstitial":"finance"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("create: want 200, got %d: %s", rec.Code, rec.Body)
+	}
+	e, err := store.GetEntry(context.Background(), "fund")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Interstitial != "finance" || e.TargetOnly() {
+		t.Fatalf("want the interstitial kept and the link not cached as a bare target, got %+v", e)
+	}
+
+	if rec := do(h, http.MethodPost, "/write/v1", "", `{"url_key":"bad","url_target":"https://example.com","interstitial":"../etc/passwd"}`); rec.Code != http.StatusBadRequest {
+		t.Fatalf("invalid template name: want 400, got %d", rec.Code)
+	}
+
+	if rec := manage(h, http.MethodPatch, "/write/v1/links/fund", "*", `{"interstitial":""}`); rec.Code != http.StatusOK {
+		t.Fatalf("patch: want 200, got %d", rec.Code)
+	}
+	if e, _ := store.GetEntry(context.Background(), "fund"); e.Interstitial != "" {
+		t.Fatalf("want the interstitial removed, got %q", e.Interstitial)
+	}
+}
+
+func TestQueryForwarding(t *testing.T) {
+	h, store := newTestHandler(t)
+
+	rec := do(h, http.MethodPost, "/write/v1", "", `{"url_key":"landing","url_target":"https://example.com","referrer_policy":"origin","forward_query":true,"scrub_params":["UTM_*","ref"]}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("create: want 200, got %d: %s", rec.Code, rec.Body)
+	}
+	e, err := store.GetEntry(context.Background(), "landing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.ReferrerPolicy != "origin" || !e.ForwardQuery || !slices.Equal(e.ScrubParams, []string{"utm_*", "ref"}) || e.TargetOnly() {
+		t.Fatalf("want the settings kept, normalized, and the link not cached as a bare target, got %+v", e)
+	}
+
+	for _, body := range []string{
+		`{"url_key":"bad","url_target":"https://example.com","referrer_policy":"unsafe-url"}`,
+		`{"url_key":"bad","url_target":"https://example.com","referrer_policy":"no-referrer-when-downgrade"}`,
+		`{"url_key":"bad","url_target":"https://example.com","referrer_policy":"never"}`,
+		`{"url_key":"bad","url_target":"https://example.com","scrub_params":["*"]}`,
+		`{"url_key":"bad","alias_of":"landing","forward_query":true}`,
+	} {
+		if rec := do(h, http.MethodPost, "/write/v1", "", body); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: want 400, got %d", body, rec.Code)
+		}
+	}
+
+	if rec := manage(h, http.MethodPatch, "/write/v1/links/landing", "*", `{"referrer_policy":"","forward_query":false,"scrub_params":[]}`); rec.Code != http.StatusOK {
+		t.Fatalf("patch: want 200, got %d: %s", rec.Code, rec.Body)
+	}
+	if e, _ := store.GetEntry(context.Background(), "landing"); !e.TargetOnly() || len(e.ScrubParams) != 0 {
+		t.Fatalf("want the settings removed, got %+v", e)
+	}
+	if rec := manage(h, http.MethodPatch, "/write/v1/links/landing", "*", `{"referrer_policy":"unsafe-url"}`); rec.Code != http.StatusBadRequest {
+		t.Fatalf("patch: want unsafe-url refused, got %d", rec.Code)
+	}
+}
+
+func TestRedirectRate(t *testing.T) {
+	h, store := newTestHandler(t)
+
+	if rec := do(h, http.MethodPost, "/write/v1", "", `{"url_key":"fragile","url_target":"https://example.com","max_redirects_per_second":5}`); rec.Code != http.StatusOK {
+		t.Fatalf("create: want 200, got %d: %s", rec.Code, rec.Body)
+	}
+	e, err := store.GetEntry(context.Background(), "fragile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.MaxRedirectsPerSecond != 5 || e.TargetOnly() {
+		t.Fatalf("want the cap kept, and the link not cached as a bare target, got %+v", e)
+	}
+	for _, body := range []string{
+		`{"url_key":"bad","url_target":"https://example.com","max_redirects_per_second":-1}`,
+		`{"url_key":"bad","alias_of":"fragile","max_redirects_per_second":1}`,
+	} {
+		if rec := do(h, http.MethodPost, "/write/v1", "", body); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: want 400, got %d", body, rec.Code)
+		}
+	}
+	if rec := manage(h, http.MethodPatch, "/write/v1/links/fragile", "*", `{"max_redirects_per_second":0}`); rec.Code != http.StatusOK {
+		t.Fatalf("patch: want 200, got %d: %s", rec.Code, rec.Body)
+	}
+	if e, _ := store.GetEntry(context.Background(), "fragile"); e.MaxRedirectsPerSecond != 0 {
+		t.Fatalf("want the cap lifted, got %+v", e)
+	}
+}
+
+func TestWriterIPFilter(t *testing.T) {
+	h := NewHandlerWithStore(Config{
+		AdminToken:     "root",
+		AllowCIDRs:     "203.0.113.0/24",
+		DenyCIDRs:      "203.0.113.66",
+		TrustedProxies: "192.0.2.0/24", // httptest's RemoteAddr
+	}, urlstore.NewMemoryClient())
+
+	get := func(path, xff string) int {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("Authorization", "Bearer root")
+		if xff != "" {
+			req.Header.Set("X-Forwarded-For", xff)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	tests := []struct {
+		name, path, xff string
+		want            int
+	}{
+		{"allowed client", "/write/v1/links", "198.51.100.7, 203.0.113.5", http.StatusOK},
+		{"denied client", "/write/v1/links", "203.0.113.66", http.StatusForbidden},
+		{"outside allow list", "/write/v1/links", "198.51.100.7", http.StatusForbidden},
+		{"proxy itself", "/write/v1/links", "", http.StatusForbidden},
+		{"health stays open", "/health", "", http.StatusOK},
+	}
+	for _, tt := range tests {
+		if got := get(tt.path, tt.xff); got != tt.want {
+			t.Errorf("%s: want %d, got %d", tt.name, tt.want, got)
+		}
+	}
+}
+
+func TestCreate_GeneratedKeyCollision(t *testing.T) {
+	// keygen hands out a taken key before a fresh one, as a misconfigured
+	// replica sharing another's machine ID would.
+	keys := []string{"promo", "promo", "fresh"}
+	var mu sync.Mutex
+	keygen := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if len(keys) == 0 {
+			http.Error(w, "exhausted", http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte(keys[0]))
+		keys = keys[1:]
+	}))
+	defer keygen.Close()
+	store := urlstore.NewMemoryClient()
+	if err := store.CreateEntry(context.Background(), "promo", urlstore.URLEntry{URLTarget: "https://example.com/v1"}); err != nil {
+		t.Fatal(err)
+	}
+	h := NewHandlerWithStore(Config{KeygenBase: keygen.URL}, store)
+
+	rec := do(h, http.MethodPost, "/write/v1", "", `{"url_target":"https://example.com/new"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("create: want 200, got %d: %s", rec.Code, rec.Body)
+	}
+	var resp writeResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.URLKey != "fresh" {
+		t.Errorf("want key fresh, got %q", resp.URLKey)
+	}
+	if got := h.keyCollisions.Load(); got != 2 {
+		t.Errorf("want 2 collisions counted, got %d", got)
+	}
+	if e, err := store.GetEntry(context.Background(), "promo"); err != nil || e.URLTarget != "https://example.com/v1" {
+		t.Errorf("promo overwritten: %+v, %v", e, err)
+	}
+}
+
+func TestCreate_MalformedGeneratedKey(t *testing.T) {
+	// A misrouted request is answered by something other than keygen.
+	answers := []string{"<html><body>Welcome to nginx!</body></html>", "1gXyZ42kQ\n"}
+	var mu sync.Mutex
+	keygen := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if len(answers) == 0 {
+			w.Write([]byte(strings.Repeat("a", 100)))
+			return
+		}
+		w.Write([]byte(answers[0]))
+		answers = answers[1:]
+	}))
+	defer keygen.Close()
+	h := NewHandlerWithStore(Config{KeygenBase: keygen.URL}, urlstore.NewMemoryClient())
+
+	rec := do(h, http.MethodPost, "/write/v1", "", `{"url_target":"https://example.com/new"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("create: want 200, got %d: %s", rec.Code, rec.Body)
+	}
+	var resp writeResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.URLKey != "1gXyZ42kQ" {
+		t.Errorf("want the key of the second answer, got %q", resp.URLKey)
+	}
+
+	// Answers past KeygenMaxResponse are refused, every attempt.
+	if rec := do(h, http.MethodPost, "/write/v1", "", `{"url_target":"https://example.com/new"}`); rec.Code != http.StatusBadGateway {
+		t.Errorf("long answers: want 502, got %d: %s", rec.Code, rec.Body)
+	}
+	for _, key := range []string{"1gXyZ42kQ", "2000FQw7mN1b", strings.Repeat("z", maxGeneratedKeyLength)} {
+		if !generatedKey(key) {
+			t.Errorf("%q: want a generated key", key)
+		}
+	}
+	for _, key := range []string{"", "a-b", "a b", "ünï", strings.Repeat("z", maxGeneratedKeyLength+1)} {
+		if generatedKey(key) {
+			t.Errorf("%q: want no generated key", key)
+		}
+	}
+}
+
+func TestCreate_KeyChecksum(t *testing.T) {
+	keygen := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("1gXyZ42kQ"))
+	}))
+	defer keygen.Close()
+	h := NewHandlerWithStore(Config{KeygenBase: keygen.URL, KeyChecksum: 2}, urlstore.NewMemoryClient())
+
+	rec := do(h, http.MethodPost, "/write/v1", "", `{"url_target":"https://example.com/new"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("create: want 200, got %d: %s", rec.Code, rec.Body)
+	}
+	var resp writeResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if want := keycheck.Append("1gXyZ42kQ", 2); resp.URLKey != want {
+		t.Errorf("want key %q, got %q", want, resp.URLKey)
+	}
+
+	// Custom aliases are kept as given.
+	rec = do(h, http.MethodPost, "/write/v1", "", `{"url_target":"https://example.com/new","url_key":"promo"}`)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"url_key":"promo"`) {
+		t.Fatalf("custom alias: got %d: %s", rec.Code, rec.Body)
+	}
+}
+
+func TestCreate_KeyGenerator(t *testing.T) {
+	generate := func(context.Context) (string, error) { return "1gXyZ42kQ", nil }
+	h := NewHandlerWithStore(Config{KeygenBase: "http://keygen.invalid", KeyGenerator: generate, KeyChecksum: 2}, urlstore.NewMemoryClient())
+	if err := h.Warm(context.Background()); err != nil {
+		t.Fatalf("want no keygen to warm, got %v", err)
+	}
+
+	rec := do(h, http.MethodPost, "/write/v1", "", `{"url_target":"https://example.com/new"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("create: want 200, got %d: %s", rec.Code, rec.Body)
+	}
+	var resp writeResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if want := keycheck.Append("1gXyZ42kQ", 2); resp.URLKey != want {
+		t.Errorf("want key %q, got %q", want, resp.URLKey)
+	}
+}
+
+func TestNotify_CreatesAndDeletes(t *testing.T) {
+	texts := make(chan string, 10)
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var msg struct{ Text string }
+		json.NewDecoder(r.Body).Decode(&msg)
+		texts <- msg.Text
+	}))
+	defer hook.Close()
+	keygen := testutil.NewKeygenServer(t)
+	h := NewHandlerWithStore(Config{KeygenBase: keygen.URL, NotifyWebhook: hook.URL, NotifyRules: "custom,domain:evil.example", AdminToken: "root"}, urlstore.NewMemoryClient())
+	defer h.Close()
+
+	for _, body := range []string{
+		`{"url_target":"https://example.com/quiet"}`,
+		`{"url_target":"https://example.com/sale","url_key":"sale"}`,
+		`{"url_target":"https://login.evil.example/"}`,
+	} {
+		if rec := do(h, http.MethodPost, "/write/v1", "", body); rec.Code != http.StatusOK {
+			t.Fatalf("create %s: got %d: %s", body, rec.Code, rec.Body)
+		}
+	}
+	if rec := manage(h, http.MethodDelete, "/write/v1/links/sale", "*", ""); rec.Code != http.StatusNoContent {
+		t.Fatalf("delete: got %d", rec.Code)
+	}
+
+	// The deleted alias no longer counts as custom, and its target is not
+	// flagged.
+	want := []string{"Link created: sale -> https://example.com/sale", "-> https://login.evil.example/"}
+	for _, w := range want {
+		select {
+		case text := <-texts:
+			if !strings.Contains(text, w) {
+				t.Errorf("want %q in %q", w, text)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("no notification for %q", w)
+		}
+	}
+	select {
+	case text := <-texts:
+		t.Errorf("unexpected notification %q", text)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
@@ -0,0 +1,88 @@
+package writer
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/FlorinBalint/shortener/pkg/urlstore"
+)
+
+// defaultMaxRedirectHops bounds the short links a target may chain through.
+const defaultMaxRedirectHops = 5
+
+// servedOn returns the hosts the reader serves the handler's links on.
+func (h *Handler) servedOn() []string {
+	if h.tenant != nil {
+		return h.tenant.Domains
+	}
+	return h.shortDomains
+}
+
+// shortKey returns the key target redirects through when it points at one
+// of our own short links, parsing paths as the reader does.
+func (h *Handler) shortKey(target string) (string, bool) {
+	u, err := url.Parse(target)
+	if err != nil || !u.IsAbs() {
+		return "", false
+	}
+	host := strings.ToLower(u.Hostname())
+	if !slices.ContainsFunc(h.servedOn(), func(d string) bool { return strings.EqualFold(d, host) }) {
+		return "", false
+	}
+	path := strings.Trim(u.Path, "/")
+	if path == "" || path == "health" || path == "ready" {
+		return "", false
+	}
+	key, _, _ := strings.Cut(path, "/")
+	return key, true
+}
+
+// checkLoops is the policy following a link's target through the short
+// links it points at. It rejects the link when the chain comes back to it,
+// or to any link seen before, or takes more than maxRedirectHops hops.
+// Chains ending in a missing key are accepted: creating that key is
+// checked in turn.
+func (h *Handler) checkLoops(ctx context.Context, l Link) error {
+	if len(h.servedOn()) == 0 {
+		return nil
+	}
+	target := l.Target
+	seen := map[string]bool{l.Key: true}
+	for hops := 0; ; hops++ {
+		next, ok := h.shortKey(target)
+		if !ok {
+			return nil
+		}
+		// Readers matching keys case-insensitively send unknown keys on
+		// to their lowercase form.
+		candidates := []string{next}
+		if lower := strings.ToLower(next); h.foldCase && lower != next {
+			candidates = append(candidates, lower)
+		}
+		var found bool
+		for _, next := range candidates {
+			if seen[next] {
+				return Reject("url_target redirects back to %q", next)
+			}
+			if hops == h.maxRedirectHops {
+				return Reject("url_target chains more than %d short links", h.maxRedirectHops)
+			}
+			seen[next] = true
+			e, err := h.entries.GetEntry(ctx, urlstore.UrlKey(next))
+			if errors.Is(err, urlstore.ErrNotFound) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			target, found = e.URLTarget, true
+			break
+		}
+		if !found {
+			return nil
+		}
+	}
+}
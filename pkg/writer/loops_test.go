@@ -0,0 +1,56 @@
+package writer
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/FlorinBalint/shortener/pkg/urlstore"
+)
+
+func TestRedirectLoops(t *testing.T) {
+	store := urlstore.NewMemoryClient()
+	if err := store.CreateEntry(context.Background(), "promo", urlstore.URLEntry{URLTarget: "https://example.com", Version: 1}); err != nil {
+		t.Fatal(err)
+	}
+	h := NewHandlerWithStore(Config{AdminToken: "root", ShortDomains: []string{"sho.rt"}, MaxRedirectHops: 2, CaseInsensitiveKeys: true}, store)
+	create := func(key, target string) int {
+		return do(h, http.MethodPost, "/write/v1", "", `{"url_key":"`+key+`","url_target":"`+target+`"}`).Code
+	}
+
+	tests := []struct {
+		name, key, target string
+		want              int
+	}{
+		{"self", "self", "https://sho.rt/self", http.StatusBadRequest},
+		{"self in another case", "mirror", "https://SHO.RT/Mirror?utm=x", http.StatusBadRequest},
+		{"through a link", "via", "https://sho.rt/promo", http.StatusOK},
+		{"through two links", "via2", "https://sho.rt/via", http.StatusOK},
+		{"too many hops", "via3", "https://sho.rt/via2", http.StatusBadRequest},
+		{"missing link", "dangling", "https://sho.rt/nothing", http.StatusOK},
+		{"other domain", "away", "https://example.com/away", http.StatusOK},
+		{"health page", "health-check", "https://sho.rt/health", http.StatusOK},
+	}
+	for _, tt := range tests {
+		if got := create(tt.key, tt.target); got != tt.want {
+			t.Errorf("%s: want %d, got %d", tt.name, tt.want, got)
+		}
+	}
+
+	// Closing the chain into a loop is rejected on update.
+	rec := manage(h, http.MethodPatch, "/write/v1/links/promo", etag(1), `{"url_target":"https://sho.rt/via"}`)
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "redirects back") {
+		t.Fatalf("update into a loop: want 400, got %d: %s", rec.Code, rec.Body)
+	}
+
+	rec = adminDo(h, http.MethodPost, "/write/v1/import", `{"url_key":"loop","url_target":"https://sho.rt/loop"}`)
+	var res importResult
+	if err := json.NewDecoder(rec.Body).Decode(&res); err != nil {
+		t.Fatal(err)
+	}
+	if res.Status != importInvalid {
+		t.Fatalf("import of a loop: want invalid, got %+v", res)
+	}
+}
@@ -0,0 +1,54 @@
+package writer
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"unicode/utf8"
+)
+
+const (
+	// maxNotesLength bounds a link's notes, in characters.
+	maxNotesLength = 2000
+	// maxFlagLength bounds why a link was flagged, in characters.
+	maxFlagLength = 200
+	// maxMetadataBytes bounds a link's metadata, compacted: it is stored
+	// with the entry and read on every listing.
+	maxMetadataBytes = 4 << 10
+)
+
+// validateNotes checks a link's notes.
+func validateNotes(notes string) error {
+	if utf8.RuneCountInString(notes) > maxNotesLength {
+		return fmt.Errorf("notes is longer than %d characters", maxNotesLength)
+	}
+	return nil
+}
+
+// validateFlag checks why a link is flagged.
+func validateFlag(flag string) error {
+	if utf8.RuneCountInString(flag) > maxFlagLength {
+		return fmt.Errorf("flag is longer than %d characters", maxFlagLength)
+	}
+	return nil
+}
+
+// normalizeMetadata checks a link's metadata, which must be a JSON object
+// or absent, and returns it compacted. null, like absence, clears it.
+func normalizeMetadata(raw json.RawMessage) (json.RawMessage, error) {
+	raw = bytes.TrimSpace(raw)
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil, nil
+	}
+	if raw[0] != '{' {
+		return nil, fmt.Errorf("metadata must be a JSON object")
+	}
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, raw); err != nil {
+		return nil, fmt.Errorf("metadata must be a JSON object")
+	}
+	if buf.Len() > maxMetadataBytes {
+		return nil, fmt.Errorf("metadata is larger than %d bytes", maxMetadataBytes)
+	}
+	return buf.Bytes(), nil
+}
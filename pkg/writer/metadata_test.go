@@ -0,0 +1,81 @@
+package writer
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/FlorinBalint/shortener/pkg/urlstore"
+)
+
+func TestNotesAndMetadata(t *testing.T) {
+	h, store := newTestHandler(t)
+	ctx := context.Background()
+
+	body := `{"url_key":"docs","url_target":"https://example.com/docs","notes":"for the launch","metadata":{ "ticket": "OPS-12", "cms": {"id": 7} }}`
+	if rec := do(h, http.MethodPost, "/write/v1", "", body); rec.Code != http.StatusOK {
+		t.Fatalf("create: want 200, got %d: %s", rec.Code, rec.Body)
+	}
+	rec := manage(h, http.MethodGet, linksPrefix+"docs", "", "")
+	if got := rec.Body.String(); !strings.Contains(got, `"notes":"for the launch"`) || !strings.Contains(got, `"metadata":{"ticket":"OPS-12","cms":{"id":7}}`) {
+		t.Fatalf("want notes and compacted metadata returned, got %s", got)
+	}
+
+	// Fields left out of a PATCH are kept; null metadata removes it.
+	if rec := manage(h, http.MethodPatch, linksPrefix+"docs", etag(1), `{"notes":"moved"}`); rec.Code != http.StatusOK {
+		t.Fatalf("patch notes: want 200, got %d: %s", rec.Code, rec.Body)
+	}
+	e, err := store.GetEntry(ctx, "docs")
+	if err != nil || e.Notes != "moved" || string(e.Metadata) != `{"ticket":"OPS-12","cms":{"id":7}}` {
+		t.Fatalf("unexpected entry after patch: %+v, %v", e, err)
+	}
+	if rec := manage(h, http.MethodPatch, linksPrefix+"docs", etag(2), `{"metadata":null}`); rec.Code != http.StatusOK {
+		t.Fatalf("clear metadata: want 200, got %d: %s", rec.Code, rec.Body)
+	}
+	if e, _ := store.GetEntry(ctx, "docs"); e.Metadata != nil || e.Notes != "moved" {
+		t.Fatalf("want metadata removed, got %+v", e)
+	}
+
+	invalid := []string{
+		`{"url_target":"https://example.com/","metadata":["a"]}`,
+		`{"url_target":"https://example.com/","metadata":"OPS-12"}`,
+		`{"url_target":"https://example.com/","metadata":{"blob":"` + strings.Repeat("x", maxMetadataBytes) + `"}}`,
+		`{"url_target":"https://example.com/","notes":"` + strings.Repeat("n", maxNotesLength+1) + `"}`,
+	}
+	for _, body := range invalid {
+		if rec := do(h, http.MethodPost, "/write/v1", "", body); rec.Code != http.StatusBadRequest {
+			t.Errorf("%.60s: want 400, got %d", body, rec.Code)
+		}
+	}
+	if page, _ := store.ListEntries(ctx, urlstore.ListOptions{}); len(page.Entries) != 2 {
+		t.Errorf("want no link created by invalid requests, got %d links", len(page.Entries))
+	}
+}
+
+func TestNotesAndMetadata_ExportImport(t *testing.T) {
+	ctx := context.Background()
+	src := urlstore.NewMemoryClient()
+	err := src.CreateEntry(ctx, "docs", urlstore.URLEntry{
+		URLTarget: "https://example.com/docs",
+		Notes:     "for the launch",
+		Metadata:  []byte(`{"ticket":"OPS-12"}`),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := adminDo(NewHandlerWithStore(Config{AdminToken: "root"}, src), http.MethodGet, exportPath, "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("export: want 200, got %d", rec.Code)
+	}
+
+	dst := urlstore.NewMemoryClient()
+	rec = adminDo(NewHandlerWithStore(Config{AdminToken: "root"}, dst), http.MethodPost, importPath, rec.Body.String())
+	if rec.Code != http.StatusOK {
+		t.Fatalf("import: want 200, got %d: %s", rec.Code, rec.Body)
+	}
+	e, err := dst.GetEntry(ctx, "docs")
+	if err != nil || e.Notes != "for the launch" || string(e.Metadata) != `{"ticket":"OPS-12"}` {
+		t.Fatalf("want notes and metadata imported, got %+v, %v", e, err)
+	}
+}
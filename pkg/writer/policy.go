@@ -0,0 +1,162 @@
+package writer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+)
+
+// Link is what policies check: a link about to be created, or updated
+// to a new target, or imported.
+type Link struct {
+	Key    string
+	Target string
+	// Custom is set when the client chose Key.
+	Custom bool
+}
+
+// Policy validates links before they are written. Check returns an error
+// made with Reject to refuse the link with 400; other errors fail the
+// request.
+type Policy interface {
+	Check(ctx context.Context, l Link) error
+}
+
+// PolicyFunc adapts a function to Policy.
+type PolicyFunc func(ctx context.Context, l Link) error
+
+// Check implements Policy.
+func (f PolicyFunc) Check(ctx context.Context, l Link) error {
+	return f(ctx, l)
+}
+
+// Policies checks links against each policy in turn, stopping at the
+// first failure.
+type Policies []Policy
+
+// Check implements Policy.
+func (ps Policies) Check(ctx context.Context, l Link) error {
+	for _, p := range ps {
+		if err := p.Check(ctx, l); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rejection is a link refused by a policy.
+type rejection struct {
+	reason string
+}
+
+func (r *rejection) Error() string {
+	return r.reason
+}
+
+// Reject returns the error refusing a link, with reason shown to the
+// client.
+func Reject(format string, args ...any) error {
+	return &rejection{reason: fmt.Sprintf(format, args...)}
+}
+
+// rejected returns the reason a policy refused a link, if it did.
+func rejected(err error) (string, bool) {
+	var r *rejection
+	if errors.As(err, &r) {
+		return r.reason, true
+	}
+	return "", false
+}
+
+// Limits bound the size of links; zero values leave them unbounded (aliases
+// never exceed 128 characters).
+type Limits struct {
+	MaxTargetLength int
+	MaxAliasLength  int
+	MaxQueryParams  int
+}
+
+// Check implements Policy.
+func (lim Limits) Check(ctx context.Context, l Link) error {
+	if lim.MaxTargetLength > 0 && len(l.Target) > lim.MaxTargetLength {
+		return Reject("url_target is longer than %d characters", lim.MaxTargetLength)
+	}
+	if lim.MaxAliasLength > 0 && l.Custom && len(l.Key) > lim.MaxAliasLength {
+		return Reject("url_key is longer than %d characters", lim.MaxAliasLength)
+	}
+	if lim.MaxQueryParams > 0 {
+		u, err := url.Parse(l.Target)
+		if err != nil {
+			return Reject("url_target is not a valid URL")
+		}
+		n := 0
+		for _, vs := range u.Query() {
+			n += len(vs)
+		}
+		if n > lim.MaxQueryParams {
+			return Reject("url_target has more than %d query parameters", lim.MaxQueryParams)
+		}
+	}
+	return nil
+}
+
+// policies returns the chain links are checked against: the size limits,
+// redirect loops, then the policies added by Config.Policies.
+func (h *Handler) policies() Policies {
+	ps := Policies{h.limits, PolicyFunc(h.checkLoops)}
+	return append(ps, h.extraPolicies...)
+}
+
+// policyName names the ith policy of the chain in decision trails.
+func policyName(i int) string {
+	switch i {
+	case 0:
+		return "limits"
+	case 1:
+		return "loops"
+	}
+	return fmt.Sprintf("Config.Policies[%d]", i-2)
+}
+
+// checkPolicies reports a link refused by a policy to the client.
+func (h *Handler) checkPolicies(ctx context.Context, w http.ResponseWriter, l Link) bool {
+	if err := h.policyError(ctx, l); err != nil {
+		writeRequestError(w, err)
+		return false
+	}
+	return true
+}
+
+// policyError fails the request of a link refused by a policy.
+func (h *Handler) policyError(ctx context.Context, l Link) error {
+	var err error
+	if t := trailFrom(ctx); t != nil {
+		err = t.policies(ctx, h.policies(), l)
+	} else {
+		err = h.policies().Check(ctx, l)
+	}
+	if err == nil {
+		return nil
+	}
+	if reason, ok := rejected(err); ok {
+		return failRequest(http.StatusBadRequest, "%s", reason)
+	}
+	log.Printf("checking link %q: %v", l.Key, err)
+	return failRequest(http.StatusInternalServerError, "failed checking the link")
+}
+
+// importProblem returns why an import record is refused by a policy.
+func (h *Handler) importProblem(ctx context.Context, l Link) string {
+	err := h.policies().Check(ctx, l)
+	if err == nil {
+		return ""
+	}
+	if reason, ok := rejected(err); ok {
+		return reason
+	}
+	log.Printf("checking link %q: %v", l.Key, err)
+	return "failed checking the link"
+}
@@ -0,0 +1,54 @@
+package writer
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/FlorinBalint/shortener/pkg/urlstore"
+)
+
+func TestPolicies(t *testing.T) {
+	noEvil := PolicyFunc(func(ctx context.Context, l Link) error {
+		if u, err := url.Parse(l.Target); err == nil && u.Hostname() == "evil.example" {
+			return Reject("url_target host is blocked")
+		}
+		if strings.Contains(l.Target, "unavailable") {
+			return errors.New("blocklist unavailable")
+		}
+		return nil
+	})
+	h := NewHandlerWithStore(Config{
+		AdminToken: "root",
+		Limits:     Limits{MaxTargetLength: 40, MaxAliasLength: 8, MaxQueryParams: 2},
+		Policies:   []Policy{noEvil},
+	}, urlstore.NewMemoryClient())
+
+	tests := []struct {
+		name, key, target string
+		want              int
+		reason            string
+	}{
+		{"ok", "fine", "https://example.com/?a=1&b=2", http.StatusOK, ""},
+		{"long target", "long", "https://example.com/" + strings.Repeat("x", 30), http.StatusBadRequest, "longer than 40"},
+		{"long alias", "much-too-long", "https://example.com", http.StatusBadRequest, "longer than 8"},
+		{"query params", "params", "https://example.com/?a=1&a=2&b=3", http.StatusBadRequest, "more than 2 query"},
+		{"custom policy", "evil", "https://evil.example/", http.StatusBadRequest, "blocked"},
+		{"failing policy", "broken", "https://unavailable.example", http.StatusInternalServerError, ""},
+	}
+	for _, tt := range tests {
+		rec := do(h, http.MethodPost, "/write/v1", "", `{"url_key":"`+tt.key+`","url_target":"`+tt.target+`"}`)
+		if rec.Code != tt.want || !strings.Contains(rec.Body.String(), tt.reason) {
+			t.Errorf("%s: want %d %q, got %d %q", tt.name, tt.want, tt.reason, rec.Code, rec.Body)
+		}
+	}
+
+	// Updates to a new target are checked too.
+	rec := manage(h, http.MethodPatch, "/write/v1/links/fine", etag(1), `{"url_target":"https://evil.example/x"}`)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("update: want 400, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,69 @@
+package writer
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/FlorinBalint/shortener/pkg/urlstore"
+	"github.com/FlorinBalint/shortener/pkg/viewtoken"
+)
+
+const previewTokensPath = "/write/v1/preview-tokens"
+
+type previewTokenRequest struct {
+	// Prefix restricts the token to keys starting with it.
+	Prefix     string `json:"prefix,omitempty"`
+	TTLSeconds int    `json:"ttl_seconds,omitempty"`
+}
+
+type previewTokenResponse struct {
+	Token     string    `json:"token"`
+	Prefix    string    `json:"prefix,omitempty"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// Named handler for /write/v1/preview-tokens: mints a token opening the
+// reader's /preview endpoint for a few minutes, e.g. for a dashboard. Only
+// authenticated callers get tokens; tenants' tokens open their own links
+// only.
+func (h *Handler) handlePreviewTokens(w http.ResponseWriter, r *http.Request) {
+	if h.previewTokens == nil {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	caller, ok := h.authenticate(w, r)
+	if !ok {
+		return
+	}
+	if caller.kind == "anonymous" {
+		http.Error(w, "API key required", http.StatusUnauthorized)
+		return
+	}
+	var req previewTokenRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&req); err != nil {
+		http.Error(w, "invalid json body", http.StatusBadRequest)
+		return
+	}
+	ttl := time.Duration(req.TTLSeconds) * time.Second
+	if ttl < 0 || ttl > viewtoken.MaxTTL {
+		http.Error(w, "ttl_seconds must be between 0 and 3600", http.StatusBadRequest)
+		return
+	}
+	claims := viewtoken.Claims{Subject: caller.String(), Prefix: urlstore.NormalizeKey(req.Prefix)}
+	if h.tenant != nil {
+		claims.Tenant = h.tenant.ID
+	}
+	token, claims, err := viewtoken.Mint(h.previewTokens, claims, ttl, time.Now())
+	if err != nil {
+		http.Error(w, "failed to mint token", http.StatusInternalServerError)
+		return
+	}
+	audit(r, caller, "preview_token.mint", claims.ID)
+	writeJSON(w, http.StatusCreated, previewTokenResponse{Token: token, Prefix: claims.Prefix, ExpiresAt: claims.ExpiresAt})
+}
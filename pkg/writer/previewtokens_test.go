@@ -0,0 +1,56 @@
+package writer
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/FlorinBalint/shortener/pkg/hmacsig"
+	"github.com/FlorinBalint/shortener/pkg/urlstore"
+	"github.com/FlorinBalint/shortener/pkg/viewtoken"
+)
+
+func TestPreviewTokens(t *testing.T) {
+	h := NewHandlerWithStore(Config{PreviewTokenKeys: "k1:secret"}, urlstore.NewMemoryClient())
+	_, secret := issueKey(t, h)
+	mint := func(apiKey, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, previewTokensPath, strings.NewReader(body))
+		if apiKey != "" {
+			req.Header.Set("X-API-Key", apiKey)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := mint("", `{}`); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("anonymous: want 401, got %d", rec.Code)
+	}
+	if rec := mint(secret, `{"ttl_seconds":7200}`); rec.Code != http.StatusBadRequest {
+		t.Fatalf("long ttl: want 400, got %d", rec.Code)
+	}
+	rec := mint(secret, `{"prefix":"team/","ttl_seconds":60}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("mint: want 201, got %d: %s", rec.Code, rec.Body)
+	}
+	var resp previewTokenResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	keys, _ := hmacsig.ParseKeyring("k1:secret")
+	c, err := viewtoken.Verify(keys, resp.Token, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Prefix != "team/" || !c.ExpiresAt.Equal(resp.ExpiresAt) || resp.ExpiresAt.After(time.Now().Add(time.Minute)) {
+		t.Fatalf("unexpected claims: %+v, response %+v", c, resp)
+	}
+
+	off := NewHandlerWithStore(Config{}, urlstore.NewMemoryClient())
+	if rec := do(off, http.MethodPost, previewTokensPath, "", `{}`); rec.Code != http.StatusNotFound {
+		t.Fatalf("minting off: want 404, got %d", rec.Code)
+	}
+}
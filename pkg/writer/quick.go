@@ -0,0 +1,87 @@
+package writer
+
+import (
+	"crypto/subtle"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// quickPath creates links by GET, for legacy systems that can only fire
+// GET webhooks.
+const quickPath = "/write/v1/quick"
+
+// defaultQuickCreateRate is the creates per minute quick creates are
+// limited to by default.
+const defaultQuickCreateRate = 60
+
+// quickCaller is the principal of quick creates: the token names no one,
+// so their links have no owner and count against no quota.
+var quickCaller = principal{kind: "quick-token"}
+
+// handleQuick creates the link of ?target= under ?key=, or a generated
+// key, for callers giving QuickCreateToken as ?token=. It is not found
+// unless a token is configured, and is limited to QuickCreateRate creates
+// a minute per replica, as a token in a URL leaks more easily than a
+// header.
+func (h *Handler) handleQuick(w http.ResponseWriter, r *http.Request) {
+	if h.quickToken == "" {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	q := r.URL.Query()
+	if subtle.ConstantTimeCompare([]byte(q.Get("token")), []byte(h.quickToken)) != 1 {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if res := h.quickLimit.Reserve(); !res.OK() || res.Delay() > 0 {
+		retry := time.Minute
+		if res.OK() {
+			retry = res.Delay()
+			res.Cancel()
+		}
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retry.Seconds()))))
+		http.Error(w, "too many quick creates", http.StatusTooManyRequests)
+		return
+	}
+	if q.Get("target") == "" {
+		http.Error(w, "target is required", http.StatusBadRequest)
+		return
+	}
+	req := writeRequest{URLTarget: q.Get("target"), URLKey: q.Get("key")}
+	resp, ok := h.create(w, r, req, false, func(http.ResponseWriter, *http.Request) (principal, bool) {
+		return quickCaller, true
+	})
+	if ok {
+		writeCreated(w, resp)
+	}
+}
+
+// validateQuickCreate checks the quick create config. The token names no
+// tenant, so quick creates are refused in multi-tenant mode.
+func (cfg Config) validateQuickCreate() error {
+	if cfg.QuickCreateToken == "" {
+		return nil
+	}
+	if cfg.MultiTenant {
+		return errors.New("QUICK_CREATE_TOKEN is not supported with MULTI_TENANT")
+	}
+	if cfg.QuickCreateRate <= 0 {
+		return errors.New("QUICK_CREATE_RATE must be positive with QUICK_CREATE_TOKEN")
+	}
+	return nil
+}
+
+// quickLimiter limits quick creates to perMinute, in bursts of as many.
+func quickLimiter(perMinute int) *rate.Limiter {
+	return rate.NewLimiter(rate.Limit(float64(perMinute)/60), perMinute)
+}
@@ -0,0 +1,79 @@
+package writer
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/FlorinBalint/shortener/pkg/urlstore"
+)
+
+func TestQuickCreate(t *testing.T) {
+	get := func(h *Handler, method string, q url.Values) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "https://sho.rt/write/v1/quick?"+q.Encode(), nil)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+	quick := func(key, token string) url.Values {
+		return url.Values{"target": {"https://example.com/" + key}, "key": {key}, "token": {token}}
+	}
+
+	off := NewHandlerWithStore(Config{}, urlstore.NewMemoryClient())
+	if rec := get(off, http.MethodGet, quick("off", "")); rec.Code != http.StatusNotFound {
+		t.Errorf("disabled: want 404, got %d", rec.Code)
+	}
+
+	h := NewHandlerWithStore(Config{QuickCreateToken: "s3cret", QuickCreateRate: 2}, urlstore.NewMemoryClient())
+	for name, tt := range map[string]struct {
+		method string
+		q      url.Values
+		want   int
+	}{
+		"post":           {http.MethodPost, quick("post", "s3cret"), http.StatusMethodNotAllowed},
+		"no token":       {http.MethodGet, quick("none", ""), http.StatusUnauthorized},
+		"wrong token":    {http.MethodGet, quick("wrong", "guess"), http.StatusUnauthorized},
+		"missing target": {http.MethodGet, url.Values{"key": {"x"}, "token": {"s3cret"}}, http.StatusBadRequest},
+	} {
+		if rec := get(h, tt.method, tt.q); rec.Code != tt.want {
+			t.Errorf("%s: want %d, got %d: %s", name, tt.want, rec.Code, rec.Body)
+		}
+	}
+
+	// The missing target spent one of the two creates of the burst.
+	rec := get(h, http.MethodGet, quick("legacy", "s3cret"))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("want 200, got %d: %s", rec.Code, rec.Body)
+	}
+	if rec.Header().Get("Cache-Control") != "no-store" {
+		t.Error("want the response not cached")
+	}
+	e, err := h.store.GetEntry(context.Background(), "legacy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.URLTarget != "https://example.com/legacy" || e.Owner != "" {
+		t.Errorf("want an unowned link to the target, got %+v", e)
+	}
+
+	rec = get(h, http.MethodGet, quick("limited", "s3cret"))
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") == "" {
+		t.Errorf("over the rate: want 429 with Retry-After, got %d %v", rec.Code, rec.Header())
+	}
+}
+
+func TestValidateQuickCreate(t *testing.T) {
+	for _, cfg := range []Config{
+		{QuickCreateToken: "s3cret", QuickCreateRate: 0},
+		{QuickCreateToken: "s3cret", QuickCreateRate: 10, MultiTenant: true},
+	} {
+		if err := cfg.validateQuickCreate(); err == nil {
+			t.Errorf("%+v: want an error", cfg)
+		}
+	}
+	if err := (Config{QuickCreateRate: 0, MultiTenant: true}).validateQuickCreate(); err != nil {
+		t.Errorf("disabled: %v", err)
+	}
+}
@@ -0,0 +1,115 @@
+package writer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/FlorinBalint/shortener/pkg/quota"
+)
+
+const quotasPrefix = "/write/v1/quotas/"
+
+// reserveQuota accounts the creation of n links by owner and reports the
+// quota status in response headers. It rejects the request when the quota
+// cannot take them all; quota store failures are logged and let the write
+// through.
+func (h *Handler) reserveQuota(ctx context.Context, w http.ResponseWriter, owner string, n int) bool {
+	st, err := h.quotas.ReserveN(ctx, owner, int64(n))
+	switch {
+	case err == nil:
+		writeQuotaHeaders(w.Header(), st)
+		return true
+	case errors.Is(err, quota.ErrExceeded):
+		writeQuotaHeaders(w.Header(), st)
+		http.Error(w, "quota exceeded", http.StatusTooManyRequests)
+		return false
+	default:
+		log.Printf("quota: reserve for %s: %v", owner, err)
+		return true
+	}
+}
+
+// checkQuota is reserveQuota for dry runs: it reports a create that would
+// exceed owner's quota without accounting one.
+func (h *Handler) checkQuota(ctx context.Context, w http.ResponseWriter, owner string, n int) bool {
+	st, err := h.quotas.Status(ctx, owner)
+	if err != nil {
+		log.Printf("quota: status of %s: %v", owner, err)
+		return true
+	}
+	writeQuotaHeaders(w.Header(), st)
+	if st.ExceededBy(int64(n)) {
+		http.Error(w, "quota exceeded", http.StatusTooManyRequests)
+		return false
+	}
+	return true
+}
+
+// writeQuotaHeaders reports the limits in effect and what remains of them.
+func writeQuotaHeaders(h http.Header, st quota.Status) {
+	if l := st.Limits.MaxLinks; l > 0 {
+		h.Set("X-Quota-Links-Limit", strconv.FormatInt(l, 10))
+		h.Set("X-Quota-Links-Remaining", strconv.FormatInt(max(l-st.Usage.Links, 0), 10))
+	}
+	if l := st.Limits.MaxDailyWrites; l > 0 {
+		h.Set("X-Quota-Daily-Limit", strconv.FormatInt(l, 10))
+		h.Set("X-Quota-Daily-Remaining", strconv.FormatInt(max(l-st.Usage.DailyWrites, 0), 10))
+	}
+}
+
+// Named handler for /write/v1/quotas/{key_id} (admin only): GET reads the
+// quota of an API key, PUT overrides its limits and DELETE restores the
+// defaults.
+func (h *Handler) handleQuota(w http.ResponseWriter, r *http.Request, id string) {
+	admin, ok := h.requireAdmin(w, r)
+	if !ok {
+		return
+	}
+	if id == "" {
+		http.NotFound(w, r)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	var (
+		st  quota.Status
+		err error
+	)
+	switch r.Method {
+	case http.MethodGet:
+		st, err = h.quotas.Status(ctx, id)
+	case http.MethodPut:
+		var limits quota.Limits
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&limits); err != nil {
+			http.Error(w, "invalid json body", http.StatusBadRequest)
+			return
+		}
+		if limits.MaxLinks < 0 || limits.MaxDailyWrites < 0 {
+			http.Error(w, "limits cannot be negative", http.StatusBadRequest)
+			return
+		}
+		st, err = h.quotas.SetLimits(ctx, id, &limits)
+	case http.MethodDelete:
+		st, err = h.quotas.SetLimits(ctx, id, nil)
+	default:
+		w.Header().Set("Allow", "GET, PUT, DELETE")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err != nil {
+		http.Error(w, "failed to access quota", http.StatusInternalServerError)
+		return
+	}
+	if r.Method != http.MethodGet {
+		audit(r, admin, "quota.set", id)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(st)
+}
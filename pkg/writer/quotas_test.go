@@ -0,0 +1,110 @@
+package writer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/FlorinBalint/shortener/pkg/quota"
+	"github.com/FlorinBalint/shortener/pkg/urlstore"
+)
+
+func createAs(h http.Handler, apiKey, urlKey string) *httptest.ResponseRecorder {
+	body := fmt.Sprintf(`{"url_key":%q,"url_target":"https://example.com/%s"}`, urlKey, urlKey)
+	req := httptest.NewRequest(http.MethodPost, "/write/v1", strings.NewReader(body))
+	req.Header.Set("X-API-Key", apiKey)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func issueKey(t *testing.T, h *Handler) (id, secret string) {
+	t.Helper()
+	key, secret, err := h.keys.Issue(context.Background(), "test", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key.ID, secret
+}
+
+func TestWrite_QuotaEnforced(t *testing.T) {
+	h := NewHandlerWithStore(Config{Quota: quota.Limits{MaxLinks: 2}}, urlstore.NewMemoryClient())
+	_, secret := issueKey(t, h)
+	_, other := issueKey(t, h)
+
+	rec := createAs(h, secret, "q1")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("first write: want 200, got %d", rec.Code)
+	}
+	if got := rec.Header().Get("X-Quota-Links-Remaining"); got != "1" {
+		t.Fatalf("remaining: want 1, got %q", got)
+	}
+	if rec := createAs(h, secret, "q2"); rec.Code != http.StatusOK {
+		t.Fatalf("second write: want 200, got %d", rec.Code)
+	}
+	if rec := createAs(h, secret, "q3"); rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("over quota: want 429, got %d", rec.Code)
+	}
+	// A conflicting write does not consume quota.
+	if rec := createAs(h, other, "q1"); rec.Code != http.StatusConflict {
+		t.Fatalf("conflict: want 409, got %d", rec.Code)
+	}
+	if rec := createAs(h, other, "o1"); rec.Header().Get("X-Quota-Links-Remaining") != "1" {
+		t.Fatalf("other key: want its own quota, got %v", rec.Header())
+	}
+
+	// Deleting a link frees its slot.
+	del := httptest.NewRequest(http.MethodDelete, "/write/v1/links/q1", nil)
+	del.Header.Set("If-Match", "*")
+	del.Header.Set("X-API-Key", secret)
+	rec = httptest.NewRecorder()
+	if h.ServeHTTP(rec, del); rec.Code != http.StatusNoContent {
+		t.Fatalf("delete: want 204, got %d", rec.Code)
+	}
+	if rec := createAs(h, secret, "q3"); rec.Code != http.StatusOK {
+		t.Fatalf("after delete: want 200, got %d", rec.Code)
+	}
+}
+
+func TestQuotaAdmin(t *testing.T) {
+	h := NewHandlerWithStore(Config{Quota: quota.Limits{MaxLinks: 1}, AdminToken: "root"}, urlstore.NewMemoryClient())
+	id, secret := issueKey(t, h)
+	createAs(h, secret, "a1")
+
+	admin := func(method, body, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/write/v1/quotas/"+id, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := admin(http.MethodGet, "", "wrong"); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("bad token: want 401, got %d", rec.Code)
+	}
+	rec := admin(http.MethodPut, `{"max_links":3}`, "root")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("put: want 200, got %d", rec.Code)
+	}
+	var st quota.Status
+	if err := json.NewDecoder(rec.Body).Decode(&st); err != nil {
+		t.Fatal(err)
+	}
+	if st.Limits.MaxLinks != 3 || st.Usage.Links != 1 || st.Default {
+		t.Fatalf("unexpected status: %+v", st)
+	}
+	if rec := createAs(h, secret, "a2"); rec.Code != http.StatusOK {
+		t.Fatalf("raised quota: want 200, got %d", rec.Code)
+	}
+}
+
+func TestQuotaAdmin_DisabledWithoutToken(t *testing.T) {
+	h := NewHandlerWithStore(Config{}, urlstore.NewMemoryClient())
+	if rec := do(h, http.MethodGet, "/write/v1/quotas/abc", "", ""); rec.Code != http.StatusNotFound {
+		t.Fatalf("want 404, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,122 @@
+package writer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/FlorinBalint/shortener/pkg/hold"
+	"github.com/FlorinBalint/shortener/pkg/urlstore"
+)
+
+const reservePath = "/write/v1/reserve"
+
+// Hold durations: the default, and the longest a client may ask for.
+const (
+	defaultHoldTTL = 5 * time.Minute
+	maxHoldTTL     = 30 * time.Minute
+)
+
+type reserveRequest struct {
+	URLKey string `json:"url_key"`
+	// HoldToken extends the hold it was returned for.
+	HoldToken  string `json:"hold_token,omitempty"`
+	TTLSeconds int    `json:"ttl_seconds,omitempty"`
+}
+
+type reserveResponse struct {
+	URLKey    string    `json:"url_key"`
+	HoldToken string    `json:"hold_token"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// holdKey names the hold of alias, per tenant.
+func (h *Handler) holdKey(alias string) string {
+	if h.tenant != nil {
+		return h.tenant.ID + ":" + alias
+	}
+	return alias
+}
+
+// Named handler for /write/v1/reserve: holds a free custom alias for a few
+// minutes. Creates of the alias then need the returned hold_token until
+// the hold expires.
+func (h *Handler) handleReserve(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req reserveRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&req); err != nil {
+		http.Error(w, "invalid json body", http.StatusBadRequest)
+		return
+	}
+	ttl := defaultHoldTTL
+	if req.TTLSeconds != 0 {
+		ttl = time.Duration(req.TTLSeconds) * time.Second
+		if ttl < 0 || ttl > maxHoldTTL {
+			http.Error(w, "ttl_seconds must be between 1 and 1800", http.StatusBadRequest)
+			return
+		}
+	}
+	key := urlstore.NormalizeKey(req.URLKey)
+	if h.foldCase {
+		key = strings.ToLower(key)
+	}
+	if err := urlstore.UrlKey(key).Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if h.reservedAlias(key) {
+		http.Error(w, "url_key is reserved", http.StatusBadRequest)
+		return
+	}
+	caller, ok := h.authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	_, err := h.store.GetEntry(ctx, urlstore.UrlKey(key))
+	if err == nil {
+		http.Error(w, "url_key already exists", http.StatusConflict)
+		return
+	}
+	if !errors.Is(err, urlstore.ErrNotFound) {
+		http.Error(w, "failed checking existing key", http.StatusInternalServerError)
+		return
+	}
+	placed, err := h.holds.Place(ctx, h.holdKey(key), req.HoldToken, ttl)
+	if errors.Is(err, hold.ErrHeld) {
+		http.Error(w, "url_key is held by another reservation", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Printf("hold: place %q: %v", key, err)
+		http.Error(w, "failed to reserve url_key", http.StatusInternalServerError)
+		return
+	}
+
+	audit(r, caller, "alias.reserve", key)
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(reserveResponse{URLKey: key, HoldToken: placed.Token, ExpiresAt: placed.ExpiresAt})
+}
+
+// holdError rejects the create of a held alias without its hold token.
+func (h *Handler) holdError(ctx context.Context, key, token string) error {
+	err := h.holds.Check(ctx, h.holdKey(key), token)
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, hold.ErrHeld):
+		return failRequest(http.StatusConflict, "url_key is held by a reservation")
+	default:
+		log.Printf("hold: check %q: %v", key, err)
+		return failRequest(http.StatusInternalServerError, "failed checking reservations")
+	}
+}
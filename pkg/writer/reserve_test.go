@@ -0,0 +1,76 @@
+package writer
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func reserve(t *testing.T, h http.Handler, body string, want int) reserveResponse {
+	t.Helper()
+	rec := do(h, http.MethodPost, reservePath, "", body)
+	if rec.Code != want {
+		t.Fatalf("reserve %s: want %d, got %d: %s", body, want, rec.Code, rec.Body)
+	}
+	var resp reserveResponse
+	if want == http.StatusOK {
+		if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return resp
+}
+
+func TestReserve(t *testing.T) {
+	h, _ := newTestHandler(t)
+
+	held := reserve(t, h, `{"url_key":"spring","ttl_seconds":60}`, http.StatusOK)
+	if held.URLKey != "spring" || held.HoldToken == "" || held.ExpiresAt.IsZero() {
+		t.Fatalf("unexpected reservation %+v", held)
+	}
+	reserve(t, h, `{"url_key":"spring"}`, http.StatusConflict)
+	reserve(t, h, `{"url_key":"promo"}`, http.StatusConflict) // an existing link
+	reserve(t, h, `{"url_key":"health"}`, http.StatusBadRequest)
+	reserve(t, h, `{"url_key":"x","ttl_seconds":7200}`, http.StatusBadRequest)
+	if renewed := reserve(t, h, `{"url_key":"spring","hold_token":"`+held.HoldToken+`"}`, http.StatusOK); renewed.HoldToken != held.HoldToken {
+		t.Fatalf("renewal changed the token: %+v", renewed)
+	}
+
+	create := func(token string) int {
+		return do(h, http.MethodPost, "/write/v1", "", `{"url_key":"spring","url_target":"https://example.com/spring","hold_token":"`+token+`"}`).Code
+	}
+	if code := create(""); code != http.StatusConflict {
+		t.Fatalf("create without token: want 409, got %d", code)
+	}
+	if code := create("wrong"); code != http.StatusConflict {
+		t.Fatalf("create with another token: want 409, got %d", code)
+	}
+	if code := create(held.HoldToken); code != http.StatusOK {
+		t.Fatalf("create with token: want 200, got %d", code)
+	}
+	if err := h.holds.Check(t.Context(), "spring", ""); err != nil {
+		t.Fatalf("hold not released after create: %v", err)
+	}
+}
+
+func TestReserve_PerTenant(t *testing.T) {
+	h, acme, globex := newTenantHandler(t)
+	req := func(apiKey string) int {
+		r := httptest.NewRequest(http.MethodPost, reservePath, strings.NewReader(`{"url_key":"launch"}`))
+		r.Header.Set("X-API-Key", apiKey)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, r)
+		return rec.Code
+	}
+	if code := req(acme); code != http.StatusOK {
+		t.Fatalf("acme reserve: want 200, got %d", code)
+	}
+	if code := req(globex); code != http.StatusOK {
+		t.Fatalf("globex reserve of the same alias: want 200, got %d", code)
+	}
+	if code := req(acme); code != http.StatusConflict {
+		t.Fatalf("acme reserve again: want 409, got %d", code)
+	}
+}
@@ -0,0 +1,36 @@
+package writer
+
+import (
+	"context"
+	"errors"
+
+	"github.com/FlorinBalint/shortener/pkg/urlstore"
+)
+
+// errAliasScheduled refuses a schedule on an alias, which redirects to the
+// targets of its canonical link.
+var errAliasScheduled = errors.New("an alias redirects like its canonical link; set schedule there")
+
+// scheduleChecks returns the checks of the scheduled targets of link l:
+// like its target, each must pass the policies.
+func (h *Handler) scheduleChecks(ctx context.Context, l Link, schedule []urlstore.ScheduledTarget) []func() error {
+	checks := make([]func() error, 0, len(schedule))
+	for _, s := range schedule {
+		scheduled := l
+		scheduled.Target = s.URLTarget
+		checks = append(checks, func() error { return h.policyError(ctx, scheduled) })
+	}
+	return checks
+}
+
+// importScheduleProblem returns why a scheduled target of an import record
+// is refused by a policy.
+func (h *Handler) importScheduleProblem(ctx context.Context, l Link, schedule []urlstore.ScheduledTarget) string {
+	for _, s := range schedule {
+		l.Target = s.URLTarget
+		if problem := h.importProblem(ctx, l); problem != "" {
+			return problem
+		}
+	}
+	return ""
+}
@@ -0,0 +1,107 @@
+package writer
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/FlorinBalint/shortener/pkg/urlstore"
+)
+
+func TestCreate_Schedule(t *testing.T) {
+	h, store := newTestHandler(t)
+	ctx := context.Background()
+	start := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	end := start.Add(2 * time.Hour)
+	window := func(target string, from, until time.Time) string {
+		s := `{"url_target":"` + target + `"`
+		if !from.IsZero() {
+			s += `,"from":"` + from.Format(time.RFC3339) + `"`
+		}
+		if !until.IsZero() {
+			s += `,"until":"` + until.Format(time.RFC3339) + `"`
+		}
+		return s + "}"
+	}
+
+	body := `{"url_key":"event","url_target":"https://example.com/event","schedule":[` +
+		window("https://example.com/recording", end, time.Time{}) + "," +
+		window("https://example.com/live", start, end) + `]}`
+	if rec := do(h, http.MethodPost, "/write/v1", "", body); rec.Code != http.StatusOK {
+		t.Fatalf("create: want 200, got %d: %s", rec.Code, rec.Body)
+	}
+	e, err := store.GetEntry(ctx, "event")
+	if err != nil || len(e.Schedule) != 2 || e.Schedule[0].URLTarget != "https://example.com/live" {
+		t.Fatalf("want the schedule stored by start, got %+v, %v", e, err)
+	}
+	if got := e.TargetAt(start); got != "https://example.com/live" {
+		t.Errorf("TargetAt(start) = %q", got)
+	}
+
+	for _, body := range []string{
+		`{"url_target":"https://example.com","schedule":[` + window("", start, end) + `]}`,
+		`{"url_target":"https://example.com","schedule":[` + window("https://example.com/a", end, start) + `]}`,
+		`{"url_target":"https://example.com","schedule":[` + window("https://example.com/a", start, end) + "," +
+			window("https://example.com/b", start.Add(time.Hour), time.Time{}) + `]}`,
+		`{"url_key":"x","alias_of":"promo","schedule":[` + window("https://example.com/a", start, end) + `]}`,
+	} {
+		if rec := do(h, http.MethodPost, "/write/v1", "", body); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: want 400, got %d: %s", body, rec.Code, rec.Body)
+		}
+	}
+}
+
+func TestUpdate_Schedule(t *testing.T) {
+	h, store := newTestHandler(t)
+	ctx := context.Background()
+	store.CreateEntry(ctx, "alias", urlstore.URLEntry{AliasOf: "promo", Version: 1})
+	start := time.Now().Add(time.Hour).UTC().Truncate(time.Second).Format(time.RFC3339)
+
+	rec := manage(h, http.MethodPatch, "/write/v1/links/promo", `"1"`, `{"schedule":[{"url_target":"https://example.com/live","from":"`+start+`"}]}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("set schedule: want 200, got %d: %s", rec.Code, rec.Body)
+	}
+	if e, err := store.GetEntry(ctx, "promo"); err != nil || len(e.Schedule) != 1 || e.URLTarget != "https://example.com/v1" {
+		t.Fatalf("want a scheduled target, got %+v, %v", e, err)
+	}
+	rec = manage(h, http.MethodPatch, "/write/v1/links/promo", `"2"`, `{"schedule":[]}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("clear schedule: want 200, got %d: %s", rec.Code, rec.Body)
+	}
+	if e, err := store.GetEntry(ctx, "promo"); err != nil || e.Schedule != nil {
+		t.Fatalf("want the schedule removed, got %+v, %v", e, err)
+	}
+
+	rec = manage(h, http.MethodPatch, "/write/v1/links/alias", `"1"`, `{"schedule":[{"url_target":"https://example.com/live"}]}`)
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "canonical link") {
+		t.Errorf("schedule an alias: want 400, got %d: %s", rec.Code, rec.Body)
+	}
+}
+
+func TestSchedule_Policies(t *testing.T) {
+	h := NewHandlerWithStore(Config{AdminToken: "root", Limits: Limits{MaxTargetLength: 40}}, urlstore.NewMemoryClient())
+	long := "https://example.com/" + strings.Repeat("x", 30)
+
+	// Generated keys, which a dry run spends none of, and custom keys alike
+	// have their scheduled targets checked.
+	for _, path := range []string{"/write/v1?dry_run=true", "/write/v1"} {
+		body := `{"url_target":"https://example.com","schedule":[{"url_target":"` + long + `"}]}`
+		if path == "/write/v1" {
+			body = `{"url_key":"event",` + body[1:]
+		}
+		rec := do(h, http.MethodPost, path, "", body)
+		if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "longer than 40") {
+			t.Errorf("%s %s: want 400, got %d: %s", path, body, rec.Code, rec.Body)
+		}
+	}
+
+	if rec := do(h, http.MethodPost, "/write/v1", "", `{"url_key":"event","url_target":"https://example.com"}`); rec.Code != http.StatusOK {
+		t.Fatalf("create: want 200, got %d: %s", rec.Code, rec.Body)
+	}
+	rec := manage(h, http.MethodPatch, "/write/v1/links/event", etag(1), `{"schedule":[{"url_target":"`+long+`"}]}`)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("update: want 400, got %d: %s", rec.Code, rec.Body)
+	}
+}
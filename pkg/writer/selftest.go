@@ -0,0 +1,100 @@
+package writer
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/FlorinBalint/shortener/pkg/gcputil"
+	"github.com/FlorinBalint/shortener/pkg/selftest"
+	"github.com/FlorinBalint/shortener/pkg/writequeue"
+)
+
+// SelfTest checks that a writer can run with cfg: that the settings are
+// valid and the secrets resolve, then that each dependency configured
+// answers, with write access to Datastore and memcache and permission to
+// use the write queue.
+func SelfTest(ctx context.Context, cfg Config) selftest.Report {
+	checks := []selftest.Check{
+		{Name: "config", Fatal: true, Run: func(context.Context) error {
+			if err := cfg.validate(); err != nil {
+				return err
+			}
+			_, err := cfg.keygenTransport()
+			return err
+		}},
+		{Name: "secrets", Fatal: true, Run: cfg.checkSecrets},
+		{Name: "datastore", Run: func(ctx context.Context) error {
+			return checkDatastore(ctx, cfg.ProjectID, cfg.DSEndpoint, cfg.DSNamespace)
+		}},
+		{Name: "keygen", Run: func(ctx context.Context) error {
+			transport, err := cfg.keygenTransport()
+			if err != nil {
+				return err
+			}
+			return selftest.HTTPGet(&http.Client{Transport: transport}, strings.TrimRight(cfg.KeygenBase, "/")+"/health")(ctx)
+		}},
+	}
+	if cfg.MemcacheServers != "" || cfg.MemcacheDiscoveryEndpoint != "" {
+		checks = append(checks, selftest.Check{Name: "memcache", Run: selftest.Memcache(cfg.MemcacheServers, cfg.MemcacheDiscoveryEndpoint, true)})
+	}
+	if cfg.ShadowProjectID != "" || cfg.ShadowNamespace != "" {
+		checks = append(checks, selftest.Check{Name: "shadow_datastore", Run: func(ctx context.Context) error {
+			return checkDatastore(ctx, cmp.Or(cfg.ShadowProjectID, cfg.ProjectID), cfg.DSEndpoint, cfg.ShadowNamespace)
+		}})
+	}
+	if cfg.TargetKMSKey != "" || cfg.TargetWrappedKey != "" {
+		checks = append(checks, selftest.Check{Name: "kms", Run: func(ctx context.Context) error {
+			_, err := cfg.storeSetup().TargetCipher(ctx)
+			return err
+		}})
+	}
+	if cfg.AuthMode == AuthOIDC || cfg.AuthMode == AuthIAP {
+		jwks := cfg.OIDC.JWKSURL
+		if jwks == "" {
+			jwks = strings.TrimRight(cfg.OIDC.Issuer, "/") + "/.well-known/openid-configuration"
+		}
+		checks = append(checks, selftest.Check{Name: "jwks", Run: selftest.HTTPGet(http.DefaultClient, jwks)})
+	}
+	if cfg.AsyncWrites {
+		checks = append(checks, selftest.Check{Name: "write_queue", Run: func(ctx context.Context) error {
+			q, err := writequeue.NewPubSubQueue(ctx, cfg.WriteQueueTopic, cfg.WriteQueueSubscription)
+			if err != nil {
+				return err
+			}
+			return q.CheckPermissions(ctx)
+		}})
+	}
+	return selftest.Run(ctx, "writer", 0, checks...)
+}
+
+// checkSecrets resolves the secret references and parses what they hold.
+func (cfg Config) checkSecrets(ctx context.Context) error {
+	sec, err := cfg.loadSecrets(ctx)
+	if err != nil {
+		return err
+	}
+	sec.Close()
+	if cfg.PreviewTokenKeys, err = gcputil.ResolveSecret(ctx, cfg.PreviewTokenKeys); err != nil {
+		return fmt.Errorf("preview token keys: %w", err)
+	}
+	if _, err := cfg.previewKeyring(); err != nil {
+		return err
+	}
+	if _, err := gcputil.ResolveSecret(ctx, cfg.NotifyWebhook); err != nil {
+		return fmt.Errorf("notify webhook: %w", err)
+	}
+	return nil
+}
+
+// checkDatastore creates, reads and deletes a probe entity.
+func checkDatastore(ctx context.Context, project, endpoint, namespace string) error {
+	client, err := gcputil.NewDSClient(ctx, project, endpoint, namespace)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	return selftest.DatastoreWrite(client)(ctx)
+}
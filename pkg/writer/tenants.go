@@ -0,0 +1,225 @@
+package writer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/FlorinBalint/shortener/pkg/campaign"
+	"github.com/FlorinBalint/shortener/pkg/quota"
+	"github.com/FlorinBalint/shortener/pkg/tenant"
+	"github.com/FlorinBalint/shortener/pkg/urlstore"
+)
+
+const (
+	adminTenantsPath = "/write/v1/admin/tenants"
+
+	// tenantHeader selects the tenant of an administrative request.
+	tenantHeader = "X-Shortener-Tenant"
+)
+
+// tenantStores are the link and campaign stores of a tenant namespace.
+type tenantStores struct {
+	store urlstore.Client
+	// entries bypasses the cache, like Handler.entries.
+	entries   urlstore.Client
+	campaigns campaign.Store
+}
+
+// tenancy serves each tenant from its own namespace in multi-tenant mode.
+type tenancy struct {
+	tenants *tenant.Registry
+	scopes  *tenant.Scopes[*Handler]
+}
+
+// enableTenancy makes h serve every tenant with a copy of itself bound to
+// the stores open returns for the tenant. Quota records stay in quotaStore,
+// keyed by API key like in single-tenant mode, with the tenant's limits as
+// the defaults and defaults filling in limits the tenant leaves at zero.
+func (h *Handler) enableTenancy(tenants *tenant.Registry, open func(tenant.Tenant) tenantStores, quotaStore quota.Store, defaults quota.Limits) {
+	h.tenancy = &tenancy{
+		tenants: tenants,
+		scopes: tenant.NewScopes(func(t tenant.Tenant) *Handler {
+			stores := open(t)
+			limits := t.Quota
+			if limits.MaxLinks == 0 {
+				limits.MaxLinks = defaults.MaxLinks
+			}
+			if limits.MaxDailyWrites == 0 {
+				limits.MaxDailyWrites = defaults.MaxDailyWrites
+			}
+			scoped := *h
+			scoped.store = stores.store
+			scoped.entries = stores.entries
+			scoped.campaigns = stores.campaigns
+			scoped.quotas = quota.NewEnforcer(quotaStore, limits)
+			// Previews are written to the default namespace's store.
+			scoped.previews = nil
+			scoped.tenant = &t
+			return &scoped
+		}),
+	}
+}
+
+// memoryTenantStores opens in-memory stores, one per namespace, which
+// outlive changes to their tenant.
+func memoryTenantStores() func(tenant.Tenant) tenantStores {
+	var mu sync.Mutex
+	byNamespace := make(map[string]tenantStores)
+	return func(t tenant.Tenant) tenantStores {
+		mu.Lock()
+		defer mu.Unlock()
+		s, ok := byNamespace[t.Namespace]
+		if !ok {
+			store := urlstore.NewMemoryClient()
+			s = tenantStores{store: store, entries: store, campaigns: campaign.NewMemoryStore()}
+			byNamespace[t.Namespace] = s
+		}
+		return s
+	}
+}
+
+// scope resolves the tenant of a request and returns the handler bound to
+// its stores. API keys write to their own tenant; administrators pick one
+// with the X-Shortener-Tenant header. Anonymous and OIDC callers have no
+// tenant. It reports an error to the client and returns false when no
+// tenant can be resolved.
+func (h *Handler) scope(w http.ResponseWriter, r *http.Request) (*Handler, bool) {
+	id := r.Header.Get(tenantHeader)
+	if id != "" {
+		if _, ok := h.requireAdmin(w, r); !ok {
+			return nil, false
+		}
+	} else {
+		caller, ok := h.authenticate(w, r)
+		if !ok {
+			return nil, false
+		}
+		switch {
+		case caller.tenant != "":
+			id = caller.tenant
+		case caller.kind == "anonymous":
+			http.Error(w, "API key required", http.StatusUnauthorized)
+			return nil, false
+		default:
+			http.Error(w, "caller has no tenant", http.StatusForbidden)
+			return nil, false
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+	t, err := h.tenancy.tenants.Get(ctx, id)
+	switch {
+	case errors.Is(err, tenant.ErrNotFound):
+		if r.Header.Get(tenantHeader) != "" {
+			http.Error(w, "tenant not found", http.StatusNotFound)
+		} else {
+			http.Error(w, "tenant of API key not found", http.StatusForbidden)
+		}
+		return nil, false
+	case err != nil:
+		log.Printf("tenant: resolve %s: %v", id, err)
+		http.Error(w, "failed to resolve tenant", http.StatusServiceUnavailable)
+		return nil, false
+	}
+	return h.tenancy.scopes.Get(t), true
+}
+
+// reservedAlias reports whether alias is reserved in the handler's tenant.
+// Aliases reserved everywhere are checked by urlstore.UrlKey.Validate.
+func (h *Handler) reservedAlias(alias string) bool {
+	return h.tenant != nil && h.tenant.Reserved(alias)
+}
+
+// Named handler for /write/v1/admin/tenants (admin only, multi-tenant mode):
+//
+//	GET    /write/v1/admin/tenants       → list tenants
+//	GET    /write/v1/admin/tenants/{id}  → read a tenant
+//	PUT    /write/v1/admin/tenants/{id}  → create or replace a tenant
+//	DELETE /write/v1/admin/tenants/{id}  → delete a tenant, keeping its links
+func (h *Handler) handleAdminTenants(w http.ResponseWriter, r *http.Request, id string) {
+	if h.tenancy == nil {
+		http.NotFound(w, r)
+		return
+	}
+	admin, ok := h.requireAdmin(w, r)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	if id == "" {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", "GET")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		tenants, err := h.tenancy.tenants.List(ctx)
+		if err != nil {
+			http.Error(w, "failed to list tenants", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"tenants": tenants})
+		return
+	}
+	if strings.Contains(id, "/") {
+		http.NotFound(w, r)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		t, err := h.tenancy.tenants.Get(ctx, id)
+		switch {
+		case errors.Is(err, tenant.ErrNotFound):
+			http.NotFound(w, r)
+		case err != nil:
+			http.Error(w, "failed to read tenant", http.StatusInternalServerError)
+		default:
+			writeJSON(w, http.StatusOK, t)
+		}
+	case http.MethodPut:
+		var t tenant.Tenant
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&t); err != nil {
+			http.Error(w, "invalid json body", http.StatusBadRequest)
+			return
+		}
+		if t.ID != "" && t.ID != id {
+			http.Error(w, "id does not match the path", http.StatusBadRequest)
+			return
+		}
+		t.ID = id
+		t, err := h.tenancy.tenants.Put(ctx, t)
+		switch {
+		case errors.Is(err, tenant.ErrInvalid):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		case err != nil:
+			http.Error(w, "failed to store tenant", http.StatusInternalServerError)
+		default:
+			audit(r, admin, "tenant.put", id)
+			writeJSON(w, http.StatusOK, t)
+		}
+	case http.MethodDelete:
+		err := h.tenancy.tenants.Delete(ctx, id)
+		switch {
+		case errors.Is(err, tenant.ErrNotFound):
+			http.NotFound(w, r)
+		case err != nil:
+			http.Error(w, "failed to delete tenant", http.StatusInternalServerError)
+		default:
+			audit(r, admin, "tenant.delete", id)
+			w.WriteHeader(http.StatusNoContent)
+		}
+	default:
+		w.Header().Set("Allow", "GET, PUT, DELETE")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
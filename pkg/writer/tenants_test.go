@@ -0,0 +1,166 @@
+package writer
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/FlorinBalint/shortener/pkg/urlstore"
+)
+
+// newTenantHandler returns a multi-tenant handler with tenants acme and
+// globex, and a key for each.
+func newTenantHandler(t *testing.T) (h *Handler, acmeKey, globexKey string) {
+	t.Helper()
+	h = NewHandlerWithStore(Config{AdminToken: "root", MultiTenant: true}, urlstore.NewMemoryClient())
+	for id, body := range map[string]string{
+		"acme":   `{"domains":["go.acme.example"],"reserved_aliases":["careers"]}`,
+		"globex": `{"domains":["go.globex.example"]}`,
+	} {
+		if rec := adminDo(h, http.MethodPut, adminTenantsPath+"/"+id, body); rec.Code != http.StatusOK {
+			t.Fatalf("put tenant %s: want 200, got %d: %s", id, rec.Code, rec.Body)
+		}
+	}
+	issue := func(tenant string) string {
+		rec := adminDo(h, http.MethodPost, adminKeysPath, `{"name":"ci","tenant":"`+tenant+`"}`)
+		if rec.Code != http.StatusCreated {
+			t.Fatalf("issue key for %s: want 201, got %d: %s", tenant, rec.Code, rec.Body)
+		}
+		var issued issuedKey
+		if err := json.NewDecoder(rec.Body).Decode(&issued); err != nil {
+			t.Fatal(err)
+		}
+		return issued.Secret
+	}
+	return h, issue("acme"), issue("globex")
+}
+
+func getLinkAs(h http.Handler, apiKey, urlKey string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, linksPrefix+urlKey, nil)
+	req.Header.Set("X-API-Key", apiKey)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestTenants_Isolation(t *testing.T) {
+	h, acme, globex := newTenantHandler(t)
+
+	if rec := createAs(h, acme, "promo"); rec.Code != http.StatusOK {
+		t.Fatalf("acme create: want 200, got %d: %s", rec.Code, rec.Body)
+	}
+	// The same alias is free in another tenant.
+	if rec := createAs(h, globex, "promo"); rec.Code != http.StatusOK {
+		t.Fatalf("globex create: want 200, got %d: %s", rec.Code, rec.Body)
+	}
+	if rec := createAs(h, acme, "promo"); rec.Code != http.StatusConflict {
+		t.Fatalf("acme duplicate: want 409, got %d", rec.Code)
+	}
+	if rec := createAs(h, acme, "acme-only"); rec.Code != http.StatusOK {
+		t.Fatalf("acme create: want 200, got %d", rec.Code)
+	}
+	if rec := getLinkAs(h, globex, "acme-only"); rec.Code != http.StatusNotFound {
+		t.Fatalf("globex reading acme link: want 404, got %d", rec.Code)
+	}
+	if rec := getLinkAs(h, acme, "acme-only"); rec.Code != http.StatusOK {
+		t.Fatalf("acme reading own link: want 200, got %d", rec.Code)
+	}
+
+	// Administrators pick the tenant with a header.
+	req := httptest.NewRequest(http.MethodGet, linksPrefix+"acme-only", nil)
+	req.Header.Set("Authorization", "Bearer root")
+	req.Header.Set(tenantHeader, "acme")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("admin with tenant header: want 200, got %d", rec.Code)
+	}
+
+	// Anonymous writes and keys without a tenant are refused.
+	if rec := do(h, http.MethodPost, "/write/v1", "", `{"url_key":"anon","url_target":"https://example.com"}`); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("anonymous: want 401, got %d", rec.Code)
+	}
+	_, untenanted := issueKey(t, h)
+	if rec := createAs(h, untenanted, "x"); rec.Code != http.StatusForbidden {
+		t.Fatalf("key without tenant: want 403, got %d", rec.Code)
+	}
+	// Non-administrators cannot pick a tenant.
+	req = httptest.NewRequest(http.MethodGet, linksPrefix+"acme-only", nil)
+	req.Header.Set("X-API-Key", globex)
+	req.Header.Set(tenantHeader, "acme")
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("tenant header without admin: want 401, got %d", rec.Code)
+	}
+}
+
+func TestTenants_ReservedAliases(t *testing.T) {
+	h, acme, globex := newTenantHandler(t)
+	if rec := createAs(h, acme, "careers"); rec.Code != http.StatusBadRequest {
+		t.Fatalf("reserved in acme: want 400, got %d", rec.Code)
+	}
+	if rec := createAs(h, globex, "careers"); rec.Code != http.StatusOK {
+		t.Fatalf("free in globex: want 200, got %d", rec.Code)
+	}
+}
+
+func TestTenants_QuotaDefaults(t *testing.T) {
+	h, acme, globex := newTenantHandler(t)
+	if rec := adminDo(h, http.MethodPut, adminTenantsPath+"/acme", `{"domains":["go.acme.example"],"quota":{"max_links":1}}`); rec.Code != http.StatusOK {
+		t.Fatalf("update tenant: want 200, got %d: %s", rec.Code, rec.Body)
+	}
+	if rec := createAs(h, acme, "a1"); rec.Code != http.StatusOK {
+		t.Fatalf("first acme link: want 200, got %d", rec.Code)
+	}
+	if rec := createAs(h, acme, "a2"); rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("over acme quota: want 429, got %d", rec.Code)
+	}
+	for _, k := range []string{"g1", "g2"} {
+		if rec := createAs(h, globex, k); rec.Code != http.StatusOK {
+			t.Fatalf("globex link %s: want 200, got %d", k, rec.Code)
+		}
+	}
+}
+
+func TestAdminTenants(t *testing.T) {
+	h, _, _ := newTenantHandler(t)
+
+	rec := adminDo(h, http.MethodGet, adminTenantsPath+"/acme", "")
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"namespace":"tenant-acme"`) {
+		t.Fatalf("get: want 200 with namespace, got %d: %s", rec.Code, rec.Body)
+	}
+	if rec := adminDo(h, http.MethodPut, adminTenantsPath+"/acme", `{"namespace":"moved"}`); rec.Code != http.StatusBadRequest {
+		t.Fatalf("namespace change: want 400, got %d", rec.Code)
+	}
+	if rec := adminDo(h, http.MethodPut, adminTenantsPath+"/initech", `{"domains":["go.acme.example"]}`); rec.Code != http.StatusBadRequest {
+		t.Fatalf("shared domain: want 400, got %d", rec.Code)
+	}
+	if rec := adminDo(h, http.MethodPost, adminKeysPath, `{"tenant":"initech"}`); rec.Code != http.StatusBadRequest {
+		t.Fatalf("key for unknown tenant: want 400, got %d", rec.Code)
+	}
+
+	rec = adminDo(h, http.MethodGet, adminTenantsPath, "")
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"id":"globex"`) {
+		t.Fatalf("list: want 200 with globex, got %d: %s", rec.Code, rec.Body)
+	}
+	if rec := adminDo(h, http.MethodDelete, adminTenantsPath+"/globex", ""); rec.Code != http.StatusNoContent {
+		t.Fatalf("delete: want 204, got %d", rec.Code)
+	}
+	if rec := adminDo(h, http.MethodGet, adminTenantsPath+"/globex", ""); rec.Code != http.StatusNotFound {
+		t.Fatalf("get deleted: want 404, got %d", rec.Code)
+	}
+	if rec := do(h, http.MethodGet, adminTenantsPath, "", ""); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("without token: want 401, got %d", rec.Code)
+	}
+
+	single := NewHandlerWithStore(Config{AdminToken: "root"}, urlstore.NewMemoryClient())
+	if rec := adminDo(single, http.MethodGet, adminTenantsPath, ""); rec.Code != http.StatusNotFound {
+		t.Fatalf("single-tenant mode: want 404, got %d", rec.Code)
+	}
+	if rec := adminDo(single, http.MethodPost, adminKeysPath, `{"tenant":"acme"}`); rec.Code != http.StatusBadRequest {
+		t.Fatalf("single-tenant key with tenant: want 400, got %d", rec.Code)
+	}
+}
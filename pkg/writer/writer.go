@@ -0,0 +1,1704 @@
+// Package writer implements the link creation service: it validates aliases,
+// obtains generated keys from keygen and persists entries in the URL store.
+package writer
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/netip"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/gomemcache/memcache"
+	"go.opentelemetry.io/otel/metric"
+	"golang.org/x/sync/errgroup"
+	"golang.org/x/time/rate"
+
+	"github.com/FlorinBalint/shortener/pkg/apikey"
+	"github.com/FlorinBalint/shortener/pkg/campaign"
+	"github.com/FlorinBalint/shortener/pkg/discovery"
+	"github.com/FlorinBalint/shortener/pkg/envelope"
+	"github.com/FlorinBalint/shortener/pkg/gcputil"
+	"github.com/FlorinBalint/shortener/pkg/hmacsig"
+	"github.com/FlorinBalint/shortener/pkg/hold"
+	"github.com/FlorinBalint/shortener/pkg/httpmw"
+	"github.com/FlorinBalint/shortener/pkg/ipfilter"
+	"github.com/FlorinBalint/shortener/pkg/keycheck"
+	"github.com/FlorinBalint/shortener/pkg/lifecycle"
+	"github.com/FlorinBalint/shortener/pkg/loadshed"
+	"github.com/FlorinBalint/shortener/pkg/machineclaim"
+	"github.com/FlorinBalint/shortener/pkg/notify"
+	"github.com/FlorinBalint/shortener/pkg/oidc"
+	"github.com/FlorinBalint/shortener/pkg/preview"
+	"github.com/FlorinBalint/shortener/pkg/queryscrub"
+	"github.com/FlorinBalint/shortener/pkg/quota"
+	"github.com/FlorinBalint/shortener/pkg/referer"
+	"github.com/FlorinBalint/shortener/pkg/servertiming"
+	"github.com/FlorinBalint/shortener/pkg/tenant"
+	"github.com/FlorinBalint/shortener/pkg/tlsutil"
+	"github.com/FlorinBalint/shortener/pkg/urlstore"
+	"github.com/FlorinBalint/shortener/pkg/viewtoken"
+	"github.com/FlorinBalint/shortener/pkg/writequeue"
+)
+
+type writeRequest struct {
+	URLKey       string    `json:"url_key,omitempty"`
+	URLTarget    string    `json:"url_target"`
+	ExpiresAt    time.Time `json:"expires_at,omitzero"`
+	AllowedCIDRs []string  `json:"allowed_cidrs,omitempty"`
+	// Schedule lists targets the link redirects to instead of URLTarget
+	// during windows of time.
+	Schedule []urlstore.ScheduledTarget `json:"schedule,omitempty"`
+	// AllowedReferers limits the sites the link may be followed from.
+	AllowedReferers []string `json:"allowed_referers,omitempty"`
+	// Interstitial names the page the reader shows before redirecting.
+	Interstitial string `json:"interstitial,omitempty"`
+	// ReferrerPolicy is the Referrer-Policy of the redirect to the target.
+	ReferrerPolicy string `json:"referrer_policy,omitempty"`
+	// ForwardQuery appends the query of the short URL to the target, less
+	// the parameters the reader scrubs and those matching ScrubParams.
+	ForwardQuery bool     `json:"forward_query,omitempty"`
+	ScrubParams  []string `json:"scrub_params,omitempty"`
+	// MaxRedirectsPerSecond caps the redirects through the link, per reader
+	// replica; 0 leaves it to the reader's cap.
+	MaxRedirectsPerSecond int `json:"max_redirects_per_second,omitempty"`
+	// HoldToken takes an alias held with /write/v1/reserve.
+	HoldToken string `json:"hold_token,omitempty"`
+	// BurnAfterReading makes a one-shot link, gone after its first redirect.
+	BurnAfterReading bool `json:"burn_after_reading,omitempty"`
+	// Campaign attaches the link to an existing campaign.
+	Campaign string `json:"campaign,omitempty"`
+	// Notes and Metadata (a JSON object) are kept with the link for its
+	// managers and integrating systems.
+	Notes    string          `json:"notes,omitempty"`
+	Metadata json.RawMessage `json:"metadata,omitempty"`
+	// Aliases are custom keys created along with URLKey for the same
+	// target, all or none.
+	Aliases []string `json:"aliases,omitempty"`
+	// AliasOf makes the link an alias of the existing link with this key,
+	// instead of giving it a target.
+	AliasOf string `json:"alias_of,omitempty"`
+}
+
+type writeResponse struct {
+	URLKey    string   `json:"url_key"`
+	URLTarget string   `json:"url_target"`
+	AliasOf   string   `json:"alias_of,omitempty"`
+	Aliases   []string `json:"aliases,omitempty"`
+	// Pending is set when the link is queued rather than created yet.
+	Pending bool `json:"pending,omitempty"`
+}
+
+// dryRunResponse is the link a create would store. Its key is empty when
+// one would be generated.
+type dryRunResponse struct {
+	linkResponse
+	Aliases []string `json:"aliases,omitempty"`
+	DryRun  bool     `json:"dry_run"`
+}
+
+type Config struct {
+	ProjectID   string
+	DSNamespace string
+	DSEndpoint  string
+	KeygenBase  string
+	// KeygenTLS enables mutual TLS towards keygen (KeygenBase must be https).
+	KeygenTLS        tlsutil.Files
+	KeygenServerName string
+	// KeyGenerator, when set, generates keys in process instead of
+	// keygen, e.g. with an embedded Kubeflake; KeygenBase is then unused.
+	KeyGenerator func(context.Context) (string, error) `statusz:"-"`
+	BindAddr     string
+	// Faults injected into the store (non-production only).
+	StoreFaults urlstore.FaultConfig
+	// SlowStoreThreshold logs Datastore operations taking longer, with
+	// their request ID (see urlstore.WithLogging); 0 logs none.
+	SlowStoreThreshold time.Duration
+	// Link previews scraped asynchronously after creation.
+	PreviewEnabled bool
+	PreviewRate    float64
+	// Memcache discovery, so edits and deletes evict cached redirects.
+	// MemcacheServers, a pkg/discovery target such as
+	// "srv:_memcache._tcp.memcached.shortener.svc.cluster.local", lists
+	// self-run memcached nodes instead of Memorystore's.
+	MemcacheDiscoveryEndpoint string
+	MemcacheServers           string
+	// Default quota of API keys without limits of their own (0 = unlimited).
+	Quota quota.Limits
+	// AdminToken guards the administrative endpoints. It, SigningKeys and
+	// TargetWrappedKey may reference a secret instead (see
+	// gcputil.ResolveSecret); Secret Manager references are refreshed every
+	// SecretRefresh.
+	AdminToken    string `statusz:"redact"`
+	SecretRefresh time.Duration
+	// AuthMode selects how writers authenticate: AuthAPIKey (default),
+	// AuthOIDC (OIDC bearer tokens) or AuthIAP (Google IAP signed headers).
+	AuthMode string
+	// OIDC configures token verification in the OIDC and IAP modes.
+	OIDC oidc.Config
+	// AdminEmails are the token identities allowed on administrative
+	// endpoints in the OIDC and IAP modes.
+	AdminEmails []string
+	// SigningKeys ("id:secret,...", first key primary) require inbound
+	// mutations to carry an HMAC signature; empty disables the check.
+	SigningKeys string `statusz:"redact"`
+	// AllowCIDRs and DenyCIDRs ("cidr,...") restrict which client IPs may
+	// use the writer; TrustedProxies may set Forwarded and X-Forwarded-For.
+	AllowCIDRs     string
+	DenyCIDRs      string
+	TrustedProxies string
+	// AccessLog logs a line per request.
+	AccessLog bool
+	// ServerTiming adds a Server-Timing header breaking the latency of
+	// responses down into cache, Datastore and keygen time: "header" for
+	// requests with an X-Debug-Timing header, "always" for every one.
+	ServerTiming servertiming.Mode
+	// FormOrigins ("https://host,...") are the origins of pages, besides
+	// the writer's, whose HTML forms may post creates.
+	FormOrigins []string
+	// QuickCreateToken (or a secret reference) enables creates by GET on
+	// /write/v1/quick for callers giving it as ?token=; empty disables
+	// them; they are not available in multi-tenant mode. QuickCreateRate
+	// limits them per minute and replica.
+	QuickCreateToken string `statusz:"redact"`
+	QuickCreateRate  int
+	// BookmarkletKeys ("id:secret,...", first key primary, or a secret
+	// reference) sign the tokens of bookmarklets, which create links by
+	// GET on /write/v1/shorten as whoever minted them; empty disables
+	// bookmarklets. Tokens live up to BookmarkletTTL and create at most
+	// BookmarkletRate links a minute per replica.
+	BookmarkletKeys string `statusz:"redact"`
+	BookmarkletTTL  time.Duration
+	BookmarkletRate int
+	// TargetKMSKey and TargetWrappedKey (base64) enable encryption of link
+	// targets at rest with a data key wrapped by the Cloud KMS key; the
+	// reader needs the same pair.
+	TargetKMSKey     string
+	TargetWrappedKey string `statusz:"redact"`
+	// ExportKMSKey and ExportWrappedKey (base64, or a secret reference)
+	// encrypt export bundles likewise, and decrypt imported ones.
+	// ExportSigningKeys ("id:secret,...", first key primary, or a secret
+	// reference) sign bundles, and verify imported ones; with
+	// RequireSignedImports, imports take signed bundles only (see
+	// pkg/bundle).
+	ExportKMSKey         string
+	ExportWrappedKey     string `statusz:"redact"`
+	ExportSigningKeys    string `statusz:"redact"`
+	RequireSignedImports bool
+	// MultiTenant keeps each tenant's links in the tenant's own Datastore
+	// namespace and cache key space; writes need a tenant's API key.
+	MultiTenant bool
+	// CaseInsensitiveKeys stores custom aliases lowercase, for readers
+	// matching keys case-insensitively. Generated and imported keys are
+	// kept as they are.
+	CaseInsensitiveKeys bool
+	// KeyChecksum appends that many check characters (up to 2, see
+	// pkg/keycheck) to generated keys, for readers to tell typos apart.
+	KeyChecksum int
+	// ShortDomains are the hosts the reader serves links on (tenants use
+	// their own domains). Targets on them are followed through at most
+	// MaxRedirectHops links, and rejected if they loop.
+	ShortDomains    []string
+	MaxRedirectHops int
+	// Limits bound the size of links, and Policies check links on top of
+	// the built-in checks, e.g. for rules of a deployment.
+	Limits   Limits
+	Policies []Policy `statusz:"-"`
+	// MeterProvider, when set, records metrics of the store's operations
+	// and cache hits (see urlstore.WithMetrics), and of requests (see
+	// httpmw.Metrics), labelled with Location, which the binaries resolve
+	// with gcputil.ResolveLocation.
+	MeterProvider metric.MeterProvider `statusz:"-"`
+	Location      gcputil.Location
+	// ShadowProjectID and ShadowNamespace name a second Datastore mirroring
+	// the store's reads and writes, to gain confidence before migrating to
+	// it (see urlstore.WithShadow). ShadowReadRate is the fraction of reads
+	// compared.
+	ShadowProjectID string
+	ShadowNamespace string
+	ShadowReadRate  float64
+	// WriteLimit bounds the link requests served at once, and BulkLimit
+	// the exports and imports, shedding the excess with 503.
+	WriteLimit loadshed.Config
+	BulkLimit  loadshed.Config
+	// Lifecycle times the warm-up and the lame-duck shutdown.
+	Lifecycle lifecycle.Config
+	// StatuszAddr serves the status page (see pkg/statusz), apart from
+	// the traffic; "off" disables it.
+	StatuszAddr string
+	// AsyncWrites answers creates with 202 once queued on WriteQueueTopic,
+	// a Pub/Sub topic ("projects/P/topics/T"); ApplyQueuedWrites creates
+	// them from WriteQueueSubscription. NewHandlerWithStore queues in
+	// memory.
+	AsyncWrites            bool
+	WriteQueueTopic        string
+	WriteQueueSubscription string
+	// PreviewTokenKeys ("id:secret,...", first key primary, or a secret
+	// reference) sign the tokens readers require for previews when given
+	// the same keys; empty disables minting.
+	PreviewTokenKeys string `statusz:"redact"`
+	// NotifyWebhook (a Slack or Google Chat incoming webhook, or a secret
+	// reference) is posted the creates and deletes of links matching
+	// NotifyRules (see pkg/notify); empty disables notifications.
+	NotifyWebhook string `statusz:"redact"`
+	NotifyRules   string
+	// KeygenClaimTTL is how long a keygen's machine claim (see
+	// pkg/machineclaim) lives without heartbeats: 3 of keygen's
+	// -claim.heartbeat. 0 means defaultKeygenClaimTTL.
+	KeygenClaimTTL time.Duration
+	// KeygenProbeInterval is how often the keygens behind KeygenBase's
+	// name have their health probed, and the name is re-resolved once
+	// its records' TTL ran out; writes go to the
+	// healthy ones, and the writer is not ready without any. 0 disables
+	// tracking, leaving keygens to DNS.
+	KeygenProbeInterval time.Duration
+	// KeygenDiscovery finds the keygens to probe, as a pkg/discovery
+	// target, e.g. "srv:_http._tcp.shortener-keygen-headless.shortener.svc.cluster.local";
+	// empty resolves the host of KeygenBase.
+	KeygenDiscovery string
+	// KeygenTimeout bounds a key request to keygen, and KeygenMaxResponse
+	// the bytes of its answer; a longer answer, or one that is no key, is
+	// refused and the key asked for again. 0 means defaultKeygenTimeout
+	// and defaultKeygenMaxResponse.
+	KeygenTimeout     time.Duration
+	KeygenMaxResponse int
+}
+
+// Authentication modes.
+const (
+	AuthAPIKey = "apikey"
+	AuthOIDC   = "oidc"
+	AuthIAP    = "iap"
+)
+
+func getenvDefault(k, def string) string {
+	if v := os.Getenv(k); v != "" {
+		return v
+	}
+	return def
+}
+
+// LoadConfigFromEnv loads config from environment variables, with defaults.
+func LoadConfigFromEnv() Config {
+	return Config{
+		ProjectID:   getenvDefault("GCP_PROJECT", ""),
+		DSNamespace: getenvDefault("DS_NAMESPACE", ""),
+		DSEndpoint:  getenvDefault("DS_ENDPOINT", ""),
+		KeygenBase:  getenvDefault("KEYGEN_BASE_URL", "http://shortener-keygen-headless.shortener.svc.cluster.local:8083"),
+		KeygenTLS: tlsutil.Files{
+			CertFile: os.Getenv("KEYGEN_TLS_CERT"),
+			KeyFile:  os.Getenv("KEYGEN_TLS_KEY"),
+			CAFile:   os.Getenv("KEYGEN_TLS_CA"),
+		},
+		KeygenServerName:    os.Getenv("KEYGEN_TLS_SERVER_NAME"),
+		KeygenClaimTTL:      getenvDuration("KEYGEN_CLAIM_TTL", defaultKeygenClaimTTL),
+		KeygenProbeInterval: getenvDuration("KEYGEN_PROBE_INTERVAL", defaultKeygenProbeInterval),
+		KeygenDiscovery:     os.Getenv("KEYGEN_DISCOVERY"),
+		KeygenTimeout:       getenvDuration("KEYGEN_TIMEOUT", defaultKeygenTimeout),
+		KeygenMaxResponse:   int(getenvInt("KEYGEN_MAX_RESPONSE", defaultKeygenMaxResponse)),
+		BindAddr:            getenvDefault("BIND_ADDR", ":8081"),
+		StoreFaults:         urlstore.FaultConfigFromEnv(),
+		SlowStoreThreshold:  getenvDuration("STORE_SLOW_THRESHOLD", urlstore.DefaultSlowThreshold),
+
+		PreviewEnabled: getenvBool("PREVIEW_ENABLED", false),
+		PreviewRate:    getenvFloat("PREVIEW_RATE", 2),
+
+		MemcacheDiscoveryEndpoint: os.Getenv("MEMCACHE_DISCOVERY_ENDPOINT"),
+		MemcacheServers:           os.Getenv("MEMCACHE_SERVERS"),
+
+		Quota: quota.Limits{
+			MaxLinks:       getenvInt("QUOTA_MAX_LINKS", 0),
+			MaxDailyWrites: getenvInt("QUOTA_MAX_DAILY_WRITES", 0),
+		},
+		AdminToken:    os.Getenv("ADMIN_TOKEN"),
+		SecretRefresh: getenvDuration("SECRET_REFRESH_INTERVAL", 5*time.Minute),
+
+		AuthMode:    getenvDefault("AUTH_MODE", AuthAPIKey),
+		OIDC:        loadOIDCConfig(),
+		AdminEmails: splitList(os.Getenv("ADMIN_EMAILS")),
+		SigningKeys: os.Getenv("WRITE_SIGNING_KEYS"),
+
+		AllowCIDRs:     os.Getenv("WRITER_ALLOW_CIDRS"),
+		DenyCIDRs:      os.Getenv("WRITER_DENY_CIDRS"),
+		TrustedProxies: os.Getenv("TRUSTED_PROXIES"),
+		AccessLog:      getenvBool("ACCESS_LOG", false),
+		ServerTiming:   servertiming.Mode(os.Getenv("SERVER_TIMING")),
+		FormOrigins:    splitList(os.Getenv("FORM_ORIGINS")),
+
+		QuickCreateToken: os.Getenv("QUICK_CREATE_TOKEN"),
+		QuickCreateRate:  int(getenvInt("QUICK_CREATE_RATE", defaultQuickCreateRate)),
+		BookmarkletKeys:  os.Getenv("BOOKMARKLET_KEYS"),
+		BookmarkletTTL:   getenvDuration("BOOKMARKLET_TTL", defaultBookmarkletTTL),
+		BookmarkletRate:  int(getenvInt("BOOKMARKLET_RATE", defaultBookmarkletRate)),
+
+		MultiTenant:         getenvBool("MULTI_TENANT", false),
+		CaseInsensitiveKeys: getenvBool("KEY_CASE_INSENSITIVE", false),
+		KeyChecksum:         int(getenvInt("KEY_CHECKSUM", 0)),
+		ShortDomains:        splitList(os.Getenv("SHORT_DOMAINS")),
+		MaxRedirectHops:     int(getenvInt("MAX_REDIRECT_HOPS", defaultMaxRedirectHops)),
+		Limits: Limits{
+			MaxTargetLength: int(getenvInt("MAX_TARGET_LENGTH", 0)),
+			MaxAliasLength:  int(getenvInt("MAX_ALIAS_LENGTH", 0)),
+			MaxQueryParams:  int(getenvInt("MAX_QUERY_PARAMS", 0)),
+		},
+
+		ShadowProjectID: os.Getenv("SHADOW_GCP_PROJECT"),
+		ShadowNamespace: os.Getenv("SHADOW_DS_NAMESPACE"),
+		ShadowReadRate:  getenvFloat("SHADOW_READ_RATE", 1),
+
+		WriteLimit: loadshed.ConfigFromEnv("WRITE"),
+		BulkLimit:  loadshed.ConfigFromEnv("BULK"),
+
+		Lifecycle:   lifecycle.ConfigFromEnv(),
+		StatuszAddr: getenvDefault("STATUSZ_ADDR", ":9091"),
+
+		AsyncWrites:            getenvBool("ASYNC_WRITES", false),
+		WriteQueueTopic:        os.Getenv("WRITE_QUEUE_TOPIC"),
+		WriteQueueSubscription: os.Getenv("WRITE_QUEUE_SUBSCRIPTION"),
+		PreviewTokenKeys:       os.Getenv("PREVIEW_TOKEN_KEYS"),
+		NotifyWebhook:          os.Getenv("NOTIFY_WEBHOOK"),
+		NotifyRules:            os.Getenv("NOTIFY_RULES"),
+
+		TargetKMSKey:     os.Getenv("TARGET_KMS_KEY"),
+		TargetWrappedKey: os.Getenv("TARGET_WRAPPED_KEY"),
+
+		ExportKMSKey:         os.Getenv("EXPORT_KMS_KEY"),
+		ExportWrappedKey:     os.Getenv("EXPORT_WRAPPED_KEY"),
+		ExportSigningKeys:    os.Getenv("EXPORT_SIGNING_KEYS"),
+		RequireSignedImports: getenvBool("REQUIRE_SIGNED_IMPORTS", false),
+	}
+}
+
+// ipFilter parses the client IP restrictions and trusted proxies.
+func (cfg Config) ipFilter() (ipfilter.List, []netip.Prefix, error) {
+	var l ipfilter.List
+	var err error
+	if l.Allow, err = ipfilter.ParseCIDRs(cfg.AllowCIDRs); err != nil {
+		return l, nil, fmt.Errorf("allowed CIDRs: %w", err)
+	}
+	if l.Deny, err = ipfilter.ParseCIDRs(cfg.DenyCIDRs); err != nil {
+		return l, nil, fmt.Errorf("denied CIDRs: %w", err)
+	}
+	trusted, err := ipfilter.ParseCIDRs(cfg.TrustedProxies)
+	if err != nil {
+		return l, nil, fmt.Errorf("trusted proxies: %w", err)
+	}
+	return l, trusted, nil
+}
+
+// middleware returns the middlewares run before routing.
+func (cfg Config) middleware() (httpmw.Middleware, error) {
+	_, trusted, err := cfg.ipFilter()
+	if err != nil {
+		return nil, err
+	}
+	return httpmw.Common(httpmw.Options{
+		Service:        "writer",
+		TrustedProxies: trusted,
+		AccessLog:      cfg.AccessLog,
+		ServerTiming:   cfg.ServerTiming,
+		MeterProvider:  cfg.MeterProvider,
+		Attributes:     cfg.Location.Attributes(),
+	})
+}
+
+// validate checks the settings that need no dependency.
+func (cfg Config) validate() error {
+	if err := cfg.validateAuth(); err != nil {
+		return err
+	}
+	if _, err := cfg.middleware(); err != nil {
+		return err
+	}
+	if err := cfg.validateKeyChecksum(); err != nil {
+		return err
+	}
+	if cfg.KeygenTimeout < 0 || cfg.KeygenMaxResponse < 0 {
+		return errors.New("KEYGEN_TIMEOUT and KEYGEN_MAX_RESPONSE must not be negative")
+	}
+	if cfg.KeygenDiscovery != "" {
+		if _, err := discovery.ParseTarget(cfg.KeygenDiscovery); err != nil {
+			return fmt.Errorf("KEYGEN_DISCOVERY: %w", err)
+		}
+	}
+	if err := discovery.ValidateMemcache(cfg.MemcacheServers, cfg.MemcacheDiscoveryEndpoint); err != nil {
+		return err
+	}
+	if _, err := notify.ParseRules(cfg.NotifyRules); err != nil {
+		return err
+	}
+	if _, err := cfg.formOrigins(); err != nil {
+		return err
+	}
+	return cfg.validateQuickCreate()
+}
+
+// keygenTransport returns the transport towards keygen, a copy of the
+// default one (proxy, timeouts and connection pooling) with mutual TLS when
+// configured.
+func (cfg Config) keygenTransport() (*http.Transport, error) {
+	if !cfg.KeygenTLS.Enabled() {
+		return http.DefaultTransport.(*http.Transport).Clone(), nil
+	}
+	if !strings.HasPrefix(cfg.KeygenBase, "https://") {
+		return nil, fmt.Errorf("keygen mutual TLS needs an https KEYGEN_BASE_URL, got %q", cfg.KeygenBase)
+	}
+	tlsConfig, err := tlsutil.ClientConfig(cfg.KeygenTLS, cfg.KeygenServerName)
+	if err != nil {
+		return nil, fmt.Errorf("keygen TLS: %w", err)
+	}
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.TLSClientConfig = tlsConfig
+	return t, nil
+}
+
+// Dependencies names the endpoints the writer talks to, for the status
+// page.
+func (cfg Config) Dependencies() map[string]string {
+	deps := map[string]string{
+		"datastore": datastoreEndpoint(cfg.DSEndpoint, cfg.ProjectID, cfg.DSNamespace),
+		"keygen":    cfg.KeygenBase,
+	}
+	if cfg.KeyGenerator != nil {
+		deps["keygen"] = "in process"
+	}
+	if cfg.MemcacheDiscoveryEndpoint != "" {
+		deps["memcache_discovery"] = cfg.MemcacheDiscoveryEndpoint
+	}
+	if cfg.MemcacheServers != "" {
+		deps["memcache"] = cfg.MemcacheServers
+	}
+	if cfg.ShadowProjectID != "" || cfg.ShadowNamespace != "" {
+		deps["shadow_datastore"] = datastoreEndpoint(cfg.DSEndpoint, cmp.Or(cfg.ShadowProjectID, cfg.ProjectID), cfg.ShadowNamespace)
+	}
+	if cfg.TargetKMSKey != "" {
+		deps["kms"] = cfg.TargetKMSKey
+	}
+	if cfg.ExportKMSKey != "" {
+		deps["export_kms"] = cfg.ExportKMSKey
+	}
+	if cfg.AuthMode == AuthOIDC || cfg.AuthMode == AuthIAP {
+		deps["jwks"] = cfg.OIDC.JWKSURL
+	}
+	if cfg.AsyncWrites {
+		deps["write_queue"] = cfg.WriteQueueTopic
+	}
+	return deps
+}
+
+// datastoreEndpoint describes a Datastore connection.
+func datastoreEndpoint(endpoint, project, namespace string) string {
+	return fmt.Sprintf("%s project=%q namespace=%q", cmp.Or(endpoint, "datastore.googleapis.com"), project, namespace)
+}
+
+// storeSetup is the part of cfg wrapping the writer's stores.
+func (cfg Config) storeSetup() urlstore.Setup {
+	return urlstore.Setup{
+		ProjectID:        cfg.ProjectID,
+		DSNamespace:      cfg.DSNamespace,
+		DSEndpoint:       cfg.DSEndpoint,
+		SlowThreshold:    cfg.SlowStoreThreshold,
+		MeterProvider:    cfg.MeterProvider,
+		Location:         cfg.Location,
+		TargetKMSKey:     cfg.TargetKMSKey,
+		TargetWrappedKey: cfg.TargetWrappedKey,
+		ShadowProjectID:  cfg.ShadowProjectID,
+		ShadowNamespace:  cfg.ShadowNamespace,
+		ShadowReadRate:   cfg.ShadowReadRate,
+	}
+}
+
+// exportCipher unwraps the bundle encryption key; it returns nil when
+// bundles are not encrypted.
+func (cfg Config) exportCipher(ctx context.Context) (*envelope.Cipher, error) {
+	return envelope.FromKMSSecret(ctx, "export encryption", cfg.ExportKMSKey, cfg.ExportWrappedKey)
+}
+
+// secrets holds the config values resolved from secret references.
+type secrets struct {
+	adminToken  *gcputil.Secret
+	signingKeys *gcputil.Secret
+	// keyring is parsed from the current signing keys.
+	keyring atomic.Pointer[hmacsig.Keyring]
+}
+
+// loadSecrets resolves the admin token and signing keys, and keeps them
+// refreshed. Refreshed signing keys are parsed before use; a malformed
+// update, or one removing every key, is rejected.
+func (cfg Config) loadSecrets(ctx context.Context) (*secrets, error) {
+	s := &secrets{}
+	var err error
+	if s.adminToken, err = gcputil.NewSecret(ctx, cfg.AdminToken, cfg.SecretRefresh, nil); err != nil {
+		return nil, fmt.Errorf("admin token: %w", err)
+	}
+	s.signingKeys, err = gcputil.NewSecret(ctx, cfg.SigningKeys, cfg.SecretRefresh, func(v string) error {
+		k, err := Config{SigningKeys: v}.signingKeyring()
+		if err != nil {
+			return err
+		}
+		if k == nil && s.keyring.Load() != nil {
+			return errors.New("signing keys cannot be removed without a restart")
+		}
+		s.keyring.Store(k)
+		return nil
+	})
+	if err != nil {
+		s.adminToken.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *secrets) Close() {
+	s.adminToken.Close()
+	s.signingKeys.Close()
+}
+
+// signingKeyring parses SigningKeys; it returns nil when signing is off.
+func (cfg Config) signingKeyring() (*hmacsig.Keyring, error) {
+	if cfg.SigningKeys == "" {
+		return nil, nil
+	}
+	k, err := hmacsig.ParseKeyring(cfg.SigningKeys)
+	if err != nil {
+		return nil, fmt.Errorf("signing keys: %w", err)
+	}
+	return k, nil
+}
+
+// loadOIDCConfig reads OIDC_ISSUER, OIDC_AUDIENCE and OIDC_JWKS_URL, or
+// IAP_AUDIENCE when AUTH_MODE is iap.
+func loadOIDCConfig() oidc.Config {
+	if os.Getenv("AUTH_MODE") == AuthIAP {
+		return oidc.IAPConfig(os.Getenv("IAP_AUDIENCE"))
+	}
+	return oidc.Config{
+		Issuer:   os.Getenv("OIDC_ISSUER"),
+		Audience: os.Getenv("OIDC_AUDIENCE"),
+		JWKSURL:  os.Getenv("OIDC_JWKS_URL"),
+	}
+}
+
+func splitList(v string) []string {
+	var out []string
+	for _, s := range strings.Split(v, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+func getenvBool(k string, def bool) bool {
+	b, err := strconv.ParseBool(getenvDefault(k, strconv.FormatBool(def)))
+	if err != nil {
+		log.Printf("ignoring invalid %s: %v", k, err)
+		return def
+	}
+	return b
+}
+
+func getenvInt(k string, def int64) int64 {
+	v := os.Getenv(k)
+	if v == "" {
+		return def
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		log.Printf("ignoring invalid %s: %v", k, err)
+		return def
+	}
+	return n
+}
+
+func getenvDuration(k string, def time.Duration) time.Duration {
+	v := os.Getenv(k)
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		log.Printf("ignoring invalid %s: %q", k, v)
+		return def
+	}
+	return d
+}
+
+func getenvFloat(k string, def float64) float64 {
+	v := os.Getenv(k)
+	if v == "" {
+		return def
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		log.Printf("ignoring invalid %s: %v", k, err)
+		return def
+	}
+	return f
+}
+
+// Handler serves link creation requests, with its dependencies.
+type Handler struct {
+	store urlstore.Client
+	// entries serves full entry reads, bypassing the cache which only holds targets.
+	entries    urlstore.Client
+	keygenBase string
+	httpClient *http.Client
+	// keygenMaxResponse bounds keygen's answers.
+	keygenMaxResponse int64
+	previews          *preview.Enricher // nil when previews are disabled
+	notifier          *notify.Notifier  // nil without a webhook
+	quotas            *quota.Enforcer
+	holds             *hold.Holds
+	// generate generates keys in process; nil asks keygen.
+	generate func(context.Context) (string, error)
+	// keygens tracks the health of the keygens; nil leaves them to DNS.
+	keygens *keygenPool
+	// claims lists the keygens' machine claims, and claimTTL tells the
+	// live ones from the stale.
+	claims      machineclaim.Store
+	claimTTL    time.Duration
+	keys        *apikey.Registry
+	campaigns   campaign.Store
+	verifier    *oidc.Verifier // nil in API key mode
+	adminToken  *gcputil.Secret
+	adminEmails map[string]bool
+	// requireSigned verifies HMAC signatures of mutations; nil when off.
+	requireSigned httpmw.Middleware
+	// ipFilter rejects clients outside the allowed CIDRs; nil when off.
+	ipFilter httpmw.Middleware
+	// common runs before both; see httpmw.Common.
+	common httpmw.Middleware
+	// tenancy is nil in single-tenant mode; tenant is set in the copies of
+	// the handler serving a tenant.
+	tenancy *tenancy
+	tenant  *tenant.Tenant
+	// foldCase lowercases custom aliases.
+	foldCase bool
+	// keyChecksum is the number of check characters of generated keys.
+	keyChecksum int
+	// shortDomains and maxRedirectHops guard against redirect loops.
+	shortDomains    []string
+	maxRedirectHops int
+	// limits and extraPolicies check links (see policies).
+	limits        Limits
+	extraPolicies Policies
+	// writeLimit and bulkLimit shed excess requests; nil when off.
+	writeLimit *loadshed.Limiter
+	bulkLimit  *loadshed.Limiter
+	// ready answers readiness probes.
+	ready *lifecycle.Readiness
+	// keyCollisions counts the generated keys found taken.
+	keyCollisions *atomic.Int64
+	// queue takes creates in async mode; nil when writes are synchronous.
+	queue writequeue.Queue
+	// previewTokens signs preview tokens; nil when minting is off.
+	previewTokens *hmacsig.Keyring
+	// formOrigins refuses form posts from browsers on other origins.
+	formOrigins *http.CrossOriginProtection
+	// quickToken authorizes quick creates, limited by quickLimit; empty
+	// when off.
+	quickToken string
+	quickLimit *rate.Limiter
+	// bookmarklets signs bookmarklet tokens, each limited by
+	// bookmarkletThrottle; nil when off.
+	bookmarklets        *hmacsig.Keyring
+	bookmarkletTTL      time.Duration
+	bookmarkletThrottle *viewtoken.Throttle
+	// exportCipher encrypts export bundles and exportKeys signs them;
+	// either may be nil. requireSignedImports refuses imports that are
+	// not signed bundles.
+	exportCipher         *envelope.Cipher
+	exportKeys           *hmacsig.Keyring
+	requireSignedImports bool
+
+	// cleanup for dependencies (store, datastore client)
+	closeFn func() error
+}
+
+// NewHandler constructs the handler with dependencies (Datastore client, store, HTTP client).
+func NewHandler(ctx context.Context, cfg Config) (*Handler, error) {
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	keygenTransport, err := cfg.keygenTransport()
+	if err != nil {
+		return nil, err
+	}
+	var keygens *keygenPool
+	if cfg.KeygenProbeInterval > 0 && cfg.KeyGenerator == nil {
+		if keygens, err = newKeygenPool(cfg.KeygenBase, cfg.KeygenDiscovery, keygenTransport); err != nil {
+			return nil, err
+		}
+	}
+	setup := cfg.storeSetup()
+	targets, err := setup.TargetCipher(ctx)
+	if err != nil {
+		return nil, err
+	}
+	exportCipher, err := cfg.exportCipher(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var queue writequeue.Queue
+	if cfg.AsyncWrites {
+		if queue, err = writequeue.NewPubSubQueue(ctx, cfg.WriteQueueTopic, cfg.WriteQueueSubscription); err != nil {
+			return nil, err
+		}
+	}
+	sec, err := cfg.loadSecrets(ctx)
+	if err != nil {
+		return nil, err
+	}
+	cfg.AdminToken, cfg.SigningKeys = sec.adminToken.Value(), sec.signingKeys.Value()
+	if cfg.PreviewTokenKeys, err = gcputil.ResolveSecret(ctx, cfg.PreviewTokenKeys); err != nil {
+		sec.Close()
+		return nil, fmt.Errorf("preview token keys: %w", err)
+	}
+	if _, err := cfg.previewKeyring(); err != nil {
+		sec.Close()
+		return nil, err
+	}
+	if cfg.BookmarkletKeys, err = gcputil.ResolveSecret(ctx, cfg.BookmarkletKeys); err != nil {
+		sec.Close()
+		return nil, fmt.Errorf("bookmarklet keys: %w", err)
+	}
+	if _, err := cfg.bookmarkletKeyring(); err != nil {
+		sec.Close()
+		return nil, err
+	}
+	if cfg.ExportSigningKeys, err = gcputil.ResolveSecret(ctx, cfg.ExportSigningKeys); err != nil {
+		sec.Close()
+		return nil, fmt.Errorf("export signing keys: %w", err)
+	}
+	if _, err := cfg.exportKeyring(); err != nil {
+		sec.Close()
+		return nil, err
+	}
+	if cfg.NotifyWebhook, err = gcputil.ResolveSecret(ctx, cfg.NotifyWebhook); err != nil {
+		sec.Close()
+		return nil, fmt.Errorf("notify webhook: %w", err)
+	}
+	if cfg.QuickCreateToken, err = gcputil.ResolveSecret(ctx, cfg.QuickCreateToken); err != nil {
+		sec.Close()
+		return nil, fmt.Errorf("quick create token: %w", err)
+	}
+	dsClient, err := gcputil.NewDSClient(ctx, cfg.ProjectID, cfg.DSEndpoint, cfg.DSNamespace)
+	if err != nil {
+		sec.Close()
+		return nil, fmt.Errorf("datastore: %w", err)
+	}
+	var base urlstore.Client = setup.Logged(urlstore.NewClient(dsClient))
+	if cfg.StoreFaults.Enabled() {
+		log.Printf("store fault injection enabled: %+v", cfg.StoreFaults)
+		base = urlstore.WithFaults(base, cfg.StoreFaults)
+	}
+	if base, err = setup.Shadowed(ctx, base); err != nil {
+		dsClient.Close()
+		sec.Close()
+		return nil, err
+	}
+
+	store := base
+	keys := apikey.NewRegistry(apikey.NewDSStore(dsClient), nil)
+	var mc *memcache.Client
+	if cfg.MemcacheServers != "" || cfg.MemcacheDiscoveryEndpoint != "" {
+		mc, err = discovery.NewMemcache(cfg.MemcacheServers, cfg.MemcacheDiscoveryEndpoint)
+		if err != nil {
+			log.Printf("memcache disabled (init failed for %s): %v", cmp.Or(cfg.MemcacheServers, cfg.MemcacheDiscoveryEndpoint), err)
+			mc = nil
+		} else {
+			store = urlstore.WithCacheAside(base, mc)
+			keys = apikey.NewRegistry(apikey.NewDSStore(dsClient), mc)
+		}
+	}
+	h := NewHandlerWithStore(cfg, setup.Metered(urlstore.Encrypted(store, targets)))
+	h.entries = setup.Metered(urlstore.Encrypted(base, targets))
+	h.exportCipher = exportCipher
+	quotaStore := quota.NewDSStore(dsClient)
+	h.quotas = quota.NewEnforcer(quotaStore, cfg.Quota)
+	h.holds = hold.NewHolds(hold.NewDSStore(dsClient))
+	h.claims = machineclaim.NewDSStore(dsClient)
+	h.keys = keys
+	h.campaigns = campaign.NewDSStore(dsClient)
+	h.queue = queue
+	if cfg.MultiTenant {
+		// Tenant stores share the connection and the cache, which the
+		// default store closes.
+		open := func(t tenant.Tenant) tenantStores {
+			var base urlstore.Client = setup.Logged(urlstore.NewClient(dsClient.WithNamespace(t.Namespace)))
+			if cfg.StoreFaults.Enabled() {
+				base = urlstore.WithFaults(base, cfg.StoreFaults)
+			}
+			entries := setup.Metered(urlstore.Encrypted(base, targets))
+			campaigns := campaign.NewDSStore(dsClient.WithNamespace(t.Namespace))
+			if mc == nil {
+				return tenantStores{store: entries, entries: entries, campaigns: campaigns}
+			}
+			cached := urlstore.WithCacheAside(base, urlstore.WithKeyPrefix(mc, t.CacheKeyPrefix()))
+			return tenantStores{store: setup.Metered(urlstore.Encrypted(cached, targets)), entries: entries, campaigns: campaigns}
+		}
+		h.enableTenancy(tenant.NewRegistry(tenant.NewDSStore(dsClient)), open, quotaStore, cfg.Quota)
+	}
+	h.httpClient.Transport = keygenTransport
+	var stopProbes context.CancelFunc
+	if keygens != nil {
+		h.keygens = keygens
+		var probes context.Context
+		probes, stopProbes = context.WithCancel(context.Background())
+		go keygens.run(probes, cfg.KeygenProbeInterval)
+		h.ready.Require("keygen", keygens.Available)
+	}
+	h.adminToken = sec.adminToken
+	if h.requireSigned != nil {
+		h.requireSigned = func(next http.Handler) http.Handler {
+			return hmacsig.Require(sec.keyring.Load(), 0)(next)
+		}
+	}
+
+	// Compose a closer that shuts down store then the DS client.
+	h.closeFn = func() error {
+		if stopProbes != nil {
+			stopProbes()
+		}
+		sec.Close()
+		var cerr error
+		if h.store != nil {
+			if err := h.store.Close(); err != nil {
+				cerr = errors.Join(cerr, err)
+			}
+		}
+		if err := dsClient.Close(); err != nil {
+			cerr = errors.Join(cerr, err)
+		}
+		return cerr
+	}
+
+	return h, nil
+}
+
+// validateKeyChecksum checks the number of check characters is supported.
+func (cfg Config) validateKeyChecksum() error {
+	if cfg.KeyChecksum < 0 || cfg.KeyChecksum > keycheck.MaxLength {
+		return fmt.Errorf("KEY_CHECKSUM must be 0 to %d, got %d", keycheck.MaxLength, cfg.KeyChecksum)
+	}
+	return nil
+}
+
+// previewKeyring parses PreviewTokenKeys; it returns nil when minting is
+// off.
+func (cfg Config) previewKeyring() (*hmacsig.Keyring, error) {
+	if cfg.PreviewTokenKeys == "" {
+		return nil, nil
+	}
+	k, err := hmacsig.ParseKeyring(cfg.PreviewTokenKeys)
+	if err != nil {
+		return nil, fmt.Errorf("preview token keys: %w", err)
+	}
+	return k, nil
+}
+
+// NewHandlerWithStore constructs a handler on top of an existing store.
+// The caller keeps ownership of the store; Close does not close it.
+// API keys, quota usage and, in multi-tenant mode, tenants and their links
+// are kept in memory. It panics if the authentication, signing, preview
+// token, bookmarklet, export signing, IP filter or quick create config is
+// invalid; NewHandler reports those as errors.
+func NewHandlerWithStore(cfg Config, store urlstore.Client) *Handler {
+	if err := cfg.validateAuth(); err != nil {
+		panic(err)
+	}
+	signing, err := cfg.signingKeyring()
+	if err != nil {
+		panic(err)
+	}
+	ips, _, err := cfg.ipFilter()
+	if err != nil {
+		panic(err)
+	}
+	common, err := cfg.middleware()
+	if err != nil {
+		panic(err)
+	}
+	previewTokens, err := cfg.previewKeyring()
+	if err != nil {
+		panic(err)
+	}
+	if err := cfg.validateKeyChecksum(); err != nil {
+		panic(err)
+	}
+	formOrigins, err := cfg.formOrigins()
+	if err != nil {
+		panic(err)
+	}
+	if err := cfg.validateQuickCreate(); err != nil {
+		panic(err)
+	}
+	bookmarklets, err := cfg.bookmarkletKeyring()
+	if err != nil {
+		panic(err)
+	}
+	exportKeys, err := cfg.exportKeyring()
+	if err != nil {
+		panic(err)
+	}
+	quotaStore := quota.NewMemoryStore()
+	h := &Handler{
+		store:             store,
+		entries:           store,
+		keygenBase:        cfg.KeygenBase,
+		generate:          cfg.KeyGenerator,
+		httpClient:        &http.Client{Timeout: cmp.Or(cfg.KeygenTimeout, defaultKeygenTimeout)},
+		keygenMaxResponse: int64(cmp.Or(cfg.KeygenMaxResponse, defaultKeygenMaxResponse)),
+		quotas:            quota.NewEnforcer(quotaStore, cfg.Quota),
+		holds:             hold.NewHolds(hold.NewMemoryStore()),
+		claims:            machineclaim.NewMemoryStore(),
+		claimTTL:          cfg.KeygenClaimTTL,
+		keys:              apikey.NewRegistry(apikey.NewMemoryStore(), nil),
+		campaigns:         campaign.NewMemoryStore(),
+		adminToken:        gcputil.StaticSecret(cfg.AdminToken),
+		foldCase:          cfg.CaseInsensitiveKeys,
+		keyChecksum:       cfg.KeyChecksum,
+		shortDomains:      cfg.ShortDomains,
+		maxRedirectHops:   cmp.Or(cfg.MaxRedirectHops, defaultMaxRedirectHops),
+		limits:            cfg.Limits,
+		extraPolicies:     cfg.Policies,
+		writeLimit:        loadshed.New("writes", cfg.WriteLimit),
+		bulkLimit:         loadshed.New("bulk", cfg.BulkLimit),
+		ready:             new(lifecycle.Readiness),
+		keyCollisions:     new(atomic.Int64),
+		previewTokens:     previewTokens,
+		formOrigins:       formOrigins,
+		quickToken:        cfg.QuickCreateToken,
+		quickLimit:        quickLimiter(cfg.QuickCreateRate),
+
+		bookmarklets:        bookmarklets,
+		bookmarkletTTL:      cfg.BookmarkletTTL,
+		bookmarkletThrottle: viewtoken.NewThrottle(cfg.BookmarkletRate),
+
+		exportKeys:           exportKeys,
+		requireSignedImports: cfg.RequireSignedImports,
+	}
+	if signing != nil {
+		h.requireSigned = hmacsig.Require(signing, 0)
+	}
+	if !ips.Empty() {
+		h.ipFilter = ipfilter.Middleware(ips)
+	}
+	h.common = common
+	if cfg.AuthMode == AuthOIDC || cfg.AuthMode == AuthIAP {
+		h.verifier = oidc.NewVerifier(cfg.OIDC)
+		h.adminEmails = make(map[string]bool, len(cfg.AdminEmails))
+		for _, e := range cfg.AdminEmails {
+			h.adminEmails[strings.ToLower(e)] = true
+		}
+	}
+	if cfg.PreviewEnabled {
+		h.previews = preview.NewEnricher(store, preview.NewFetcher(preview.Config{}), cfg.PreviewRate, 0)
+	}
+	if cfg.NotifyWebhook != "" {
+		rules, err := notify.ParseRules(cfg.NotifyRules)
+		if err != nil {
+			panic(err)
+		}
+		h.notifier = notify.New(cfg.NotifyWebhook, rules)
+	}
+	if cfg.MultiTenant {
+		h.enableTenancy(tenant.NewRegistry(tenant.NewMemoryStore()), memoryTenantStores(), quotaStore, cfg.Quota)
+	}
+	if cfg.AsyncWrites {
+		h.queue = writequeue.NewMemoryQueue(memoryQueueSize)
+	}
+	return h
+}
+
+// Named handler for /health
+func (h *Handler) handleHealth(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+}
+
+// Named handler for /write/v1
+func (h *Handler) handleWrite(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	// Administrators may ask why a link is refused or stored as it is.
+	if r.Header.Get(decisionsHeader) != "" && trailFrom(r.Context()) == nil {
+		h.explained(w, r, h.handleWrite)
+		return
+	}
+
+	// dry_run=true validates the link as a create would, without storing
+	// it or any trace of it, for forms to check as the user types.
+	var dryRun bool
+	if v := r.URL.Query().Get("dry_run"); v != "" {
+		var err error
+		if dryRun, err = strconv.ParseBool(v); err != nil {
+			http.Error(w, "dry_run must be a boolean", http.StatusBadRequest)
+			return
+		}
+	}
+
+	req, err := h.decodeWriteRequest(w, r)
+	if err != nil {
+		writeRequestError(w, err)
+		return
+	}
+	if resp, ok := h.create(w, r, req, dryRun, h.authenticate); ok {
+		writeCreated(w, resp)
+	}
+}
+
+// writeCreated answers a create with its link: 200, or 202 once queued.
+func writeCreated(w http.ResponseWriter, resp writeResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	// Links are created at version 1.
+	w.Header().Set("ETag", etag(1))
+	if resp.Pending {
+		w.WriteHeader(http.StatusAccepted)
+	}
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+// create authenticates the caller with authenticate, then validates and
+// stores the link of req, and returns the link.
+// It answers the request itself on errors and dry runs, returning false.
+func (h *Handler) create(w http.ResponseWriter, r *http.Request, req writeRequest, dryRun bool, authenticate func(http.ResponseWriter, *http.Request) (principal, bool)) (writeResponse, bool) {
+	ctx := r.Context()
+	trail := trailFrom(ctx)
+	// refuse answers the request refused by step with 400.
+	refuse := func(step string, err error) (writeResponse, bool) {
+		trail.result(step, "", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return writeResponse{}, false
+	}
+	// Callers are known before any check, so the checks tell nothing
+	// about existing keys or campaigns to those without credentials.
+	caller, ok := authenticate(w, r)
+	if !ok {
+		trail.add("auth", outcomeRejected, "")
+		return writeResponse{}, false
+	}
+	trail.add("auth", outcomePassed, "%s", caller)
+
+	aliasOf := urlstore.NormalizeKey(req.AliasOf)
+	trail.normalized("alias_of", req.AliasOf, aliasOf, "trimmed")
+	req.AliasOf = aliasOf
+	if err := validateAliasRequest(req); err != nil {
+		return refuse("alias_of", err)
+	}
+	if !req.ExpiresAt.IsZero() && !req.ExpiresAt.After(time.Now()) {
+		return refuse("expires_at", errors.New("expires_at must be in the future"))
+	}
+	if err := urlstore.ValidateSchedule(req.Schedule); err != nil {
+		return refuse("schedule", err)
+	}
+	allowed, err := normalizeCIDRs(req.AllowedCIDRs)
+	if err != nil {
+		return refuse("allowed_cidrs", err)
+	}
+	trail.normalized("allowed_cidrs", strings.Join(req.AllowedCIDRs, ","), strings.Join(allowed, ","), "canonical form")
+	referers, err := referer.Normalize(req.AllowedReferers)
+	if err != nil {
+		return refuse("allowed_referers", err)
+	}
+	trail.normalized("allowed_referers", strings.Join(req.AllowedReferers, ","), strings.Join(referers, ","), "canonical form")
+	if err := validateInterstitial(req.Interstitial); err != nil {
+		return refuse("interstitial", err)
+	}
+	if err := validateReferrerPolicy(req.ReferrerPolicy); err != nil {
+		return refuse("referrer_policy", err)
+	}
+	scrubParams, err := normalizeScrubParams(req.ScrubParams)
+	if err != nil {
+		return refuse("scrub_params", err)
+	}
+	trail.normalized("scrub_params", strings.Join(req.ScrubParams, ","), strings.Join(scrubParams, ","), "canonical form")
+	if err := validateRedirectRate(req.MaxRedirectsPerSecond); err != nil {
+		return refuse("max_redirects_per_second", err)
+	}
+	if err := validateNotes(req.Notes); err != nil {
+		return refuse("notes", err)
+	}
+	metadata, err := normalizeMetadata(req.Metadata)
+	if err != nil {
+		return refuse("metadata", err)
+	}
+	trail.add("fields", outcomePassed, "")
+
+	key := req.URLKey
+	if key == "" && dryRun {
+		// A dry run spends no key; the link is checked without one.
+		trail.add("keygen", outcomeSkipped, "dry run")
+		if !runChecks(w, trail.traced("campaign", req.Campaign, func() error { return h.campaignError(ctx, caller, req.Campaign) })) {
+			return writeResponse{}, false
+		}
+	} else if key == "" {
+		// Keygen is called while the campaign is looked up.
+		var gen string
+		ok := runChecks(w,
+			func() error {
+				var err error
+				if gen, err = h.generateNewKey(ctx); err != nil {
+					trail.add("keygen", outcomeFailed, "%v", err)
+					return failRequest(http.StatusBadGateway, "failed to generate key")
+				}
+				trail.add("keygen", outcomePassed, "generated %q", gen)
+				return nil
+			},
+			trail.traced("campaign", req.Campaign, func() error { return h.campaignError(ctx, caller, req.Campaign) }),
+		)
+		if !ok {
+			return writeResponse{}, false
+		}
+		key = gen
+	}
+
+	// added: normalize and validate alias (allows slashes, blocks static/*)
+	normalized := urlstore.NormalizeKey(key)
+	trail.normalized("url_key", key, normalized, "trimmed")
+	key = normalized
+	if h.foldCase && req.URLKey != "" {
+		folded := strings.ToLower(key)
+		trail.normalized("url_key", key, folded, "case folded")
+		key = folded
+	}
+	if key != "" || !dryRun {
+		h.traceReserved(trail, "url_key", key)
+		if err := urlstore.UrlKey(key).Validate(); err != nil {
+			return refuse("url_key", err)
+		}
+		if h.reservedAlias(key) {
+			return refuse("url_key", errors.New("url_key is reserved"))
+		}
+		trail.result("url_key", key, nil)
+	}
+	if req.BurnAfterReading && urlstore.UrlKey(key).IsWildcard() {
+		return refuse("burn_after_reading", errors.New("burn_after_reading is not supported for wildcard keys"))
+	}
+	aliases, err := h.normalizeAliases(ctx, req.Aliases, key)
+	if err != nil {
+		return refuse("aliases", err)
+	}
+	if req.BurnAfterReading && len(aliases) > 0 {
+		// Each alias would be followed once, not the link.
+		return refuse("burn_after_reading", errors.New("burn_after_reading is not supported with aliases"))
+	}
+
+	target := req.URLTarget
+	if req.AliasOf != "" {
+		// Policies see the target the alias redirects to.
+		canonical, err := h.canonicalEntry(ctx, key, req.AliasOf)
+		trail.result("alias_of", req.AliasOf, err)
+		if err != nil {
+			writeRequestError(w, err)
+			return writeResponse{}, false
+		}
+		target = canonical.URLTarget
+	}
+	link := Link{Key: key, Target: target, Custom: req.URLKey != ""}
+	if req.URLKey == "" && len(aliases) == 0 && len(req.Schedule) == 0 {
+		if !h.checkPolicies(ctx, w, link) {
+			return writeResponse{}, false
+		}
+	} else {
+		// A custom key must exist nowhere yet, nor be held, and its checks
+		// wait on different stores: run them at once, with the aliases'.
+		var checks []func() error
+		if req.URLKey != "" {
+			checks = append(checks,
+				trail.traced("campaign", req.Campaign, func() error { return h.campaignError(ctx, caller, req.Campaign) }),
+				trail.traced("hold", key, func() error { return h.holdError(ctx, key, req.HoldToken) }),
+				trail.traced("key_available", key, func() error { return h.keyAvailable(ctx, key) }),
+			)
+		}
+		checks = append(checks, func() error { return h.policyError(ctx, link) })
+		checks = append(checks, h.scheduleChecks(ctx, link, req.Schedule)...)
+		checks = append(checks, h.aliasChecks(ctx, aliases, target)...)
+		if !runChecks(w, checks...) {
+			return writeResponse{}, false
+		}
+	}
+
+	owner := caller.owner()
+	entry := urlstore.URLEntry{
+		URLTarget:             req.URLTarget,
+		CreationTimestamp:     time.Now().UTC(),
+		ExpiresAt:             req.ExpiresAt,
+		Schedule:              req.Schedule,
+		AllowedCIDRs:          allowed,
+		AllowedReferers:       referers,
+		Interstitial:          req.Interstitial,
+		ReferrerPolicy:        req.ReferrerPolicy,
+		ForwardQuery:          req.ForwardQuery,
+		ScrubParams:           scrubParams,
+		MaxRedirectsPerSecond: req.MaxRedirectsPerSecond,
+		Version:               1,
+		Owner:                 owner,
+		BurnAfterReading:      req.BurnAfterReading,
+		Campaign:              req.Campaign,
+		Notes:                 req.Notes,
+		Metadata:              metadata,
+		AliasOf:               req.AliasOf,
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	links := 1 + len(aliases)
+	if dryRun {
+		if owner != "" && !h.checkQuota(ctx, w, owner, links) {
+			trail.add("quota", outcomeRejected, "%d links for %s", links, owner)
+			return writeResponse{}, false
+		}
+		writeJSON(w, http.StatusOK, dryRunResponse{linkResponse: linkResponse{URLKey: key, URLEntry: entry}, Aliases: aliases, DryRun: true})
+		return writeResponse{}, false
+	}
+	if owner != "" && !h.reserveQuota(ctx, w, owner, links) {
+		trail.add("quota", outcomeRejected, "%d links for %s", links, owner)
+		return writeResponse{}, false
+	}
+	if owner != "" {
+		trail.add("quota", outcomePassed, "%d links for %s", links, owner)
+	}
+
+	if h.queue != nil {
+		err = h.enqueueCreate(ctx, key, entry, aliases, req.URLKey == "")
+	} else {
+		err = h.createLink(ctx, key, entry, aliases)
+	}
+	// Generated keys are meant to be unique: a taken one means keygen is
+	// misconfigured (e.g. shared machine IDs). Creates never overwrite, so
+	// the link is retried under a new key and the collision reported.
+	aliasTaken := false
+	for retries := 0; req.URLKey == "" && errors.Is(err, urlstore.ErrAlreadyExists) && retries < maxKeyCollisionRetries; retries++ {
+		if len(aliases) > 0 && h.keyAvailable(ctx, key) == nil {
+			// An alias was taken after it was checked, not the key.
+			aliasTaken = true
+			break
+		}
+		h.reportKeyCollision(key)
+		trail.add("key_collision", "retried", "generated key %q is taken", key)
+		gen, gerr := h.generateNewKey(ctx)
+		if gerr != nil {
+			err = gerr
+			break
+		}
+		key = gen
+		err = h.createLink(ctx, key, entry, aliases)
+	}
+	if err != nil {
+		trail.add("store", outcomeFailed, "%q: %v", key, err)
+		if owner != "" {
+			if err := h.quotas.CancelN(ctx, owner, int64(links)); err != nil {
+				log.Printf("quota: cancel for %s: %v", owner, err)
+			}
+		}
+		if h.queue != nil {
+			log.Printf("write queue: queue %q: %v", key, err)
+			http.Error(w, "failed to queue the link", http.StatusServiceUnavailable)
+			return writeResponse{}, false
+		}
+		if errors.Is(err, urlstore.ErrAlreadyExists) && req.URLKey == "" && !aliasTaken {
+			h.reportKeyCollision(key)
+			http.Error(w, "failed to generate a unique key", http.StatusInternalServerError)
+			return writeResponse{}, false
+		}
+		if errors.Is(err, urlstore.ErrAlreadyExists) && len(aliases) > 0 {
+			http.Error(w, "url_key or an alias already exists", http.StatusConflict)
+			return writeResponse{}, false
+		}
+		if errors.Is(err, urlstore.ErrAlreadyExists) {
+			http.Error(w, "url_key already exists", http.StatusConflict)
+			return writeResponse{}, false
+		}
+		http.Error(w, "failed to store entry", http.StatusInternalServerError)
+		return writeResponse{}, false
+	}
+	if h.queue != nil {
+		trail.add("store", "queued", "%s", key)
+	} else {
+		trail.add("store", outcomePassed, "%s", key)
+	}
+
+	audit(r, caller, "link.create", key)
+	h.notify(caller, notify.Created, key, entry, req.URLKey != "")
+	for _, a := range aliases {
+		audit(r, caller, "link.create", a)
+		h.notify(caller, notify.Created, a, entry, true)
+	}
+	if req.HoldToken != "" {
+		if err := h.holds.Release(ctx, h.holdKey(key)); err != nil {
+			log.Printf("hold: release %q: %v", key, err)
+		}
+	}
+	if h.queue != nil {
+		// The link is served once applied, previews fetched then.
+		return writeResponse{URLKey: key, URLTarget: req.URLTarget, AliasOf: req.AliasOf, Aliases: aliases, Pending: true}, true
+	}
+	// One-shot targets are typically secret; they are not fetched. Aliases
+	// show their canonical link's preview.
+	if h.previews != nil && !req.BurnAfterReading && req.AliasOf == "" {
+		h.previews.Enqueue(urlstore.UrlKey(key), req.URLTarget)
+		for _, a := range aliases {
+			h.previews.Enqueue(urlstore.UrlKey(a), req.URLTarget)
+		}
+	}
+
+	return writeResponse{URLKey: key, URLTarget: req.URLTarget, AliasOf: req.AliasOf, Aliases: aliases}, true
+}
+
+// keyAvailable fails the create of a custom key that is taken.
+func (h *Handler) keyAvailable(ctx context.Context, key string) error {
+	_, err := h.store.GetEntry(ctx, urlstore.UrlKey(key))
+	switch {
+	case err == nil:
+		return failRequest(http.StatusConflict, "url_key already exists")
+	case errors.Is(err, urlstore.ErrNotFound):
+		return nil
+	default:
+		return failRequest(http.StatusInternalServerError, "failed checking existing key")
+	}
+}
+
+// maxKeyCollisionRetries bounds the new keys tried for a link whose
+// generated key was taken.
+const maxKeyCollisionRetries = 3
+
+// reportKeyCollision logs a generated key found taken, as a line to alert
+// on: every collision is a keygen misconfiguration.
+func (h *Handler) reportKeyCollision(key string) {
+	n := h.keyCollisions.Add(1)
+	log.Printf("key collision: generated key %q is already taken (%d since start); check keygen's bit widths and machine and cluster IDs", key, n)
+}
+
+// maxAllowedCIDRs caps the per-link IP restriction checked on every redirect.
+const maxAllowedCIDRs = 32
+
+// normalizeCIDRs validates a link's allowed_cidrs and returns them in
+// canonical form.
+func normalizeCIDRs(cidrs []string) ([]string, error) {
+	if len(cidrs) > maxAllowedCIDRs {
+		return nil, fmt.Errorf("allowed_cidrs has more than %d entries", maxAllowedCIDRs)
+	}
+	var out []string
+	for _, c := range cidrs {
+		p, err := ipfilter.ParsePrefix(strings.TrimSpace(c))
+		if err != nil {
+			return nil, fmt.Errorf("allowed_cidrs: %v", err)
+		}
+		out = append(out, p.String())
+	}
+	return out, nil
+}
+
+// interstitialName matches the template names links may give as
+// interstitial, the base names of the reader's template files.
+var interstitialName = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,63}$`)
+
+// validateInterstitial checks a link's interstitial; empty means none.
+// Whether the reader has the template is not known here: it shows its
+// default page for unknown names.
+func validateInterstitial(name string) error {
+	if name != "" && !interstitialName.MatchString(name) {
+		return fmt.Errorf("interstitial %q must be lowercase letters, digits, _ and -, up to 64", name)
+	}
+	return nil
+}
+
+// validateReferrerPolicy checks a link's referrer policy; empty keeps the
+// reader's. Policies sending the full short URL to the target are
+// refused, as its query may carry tokens.
+func validateReferrerPolicy(policy string) error {
+	switch policy {
+	case "":
+		return nil
+	case "unsafe-url", "no-referrer-when-downgrade":
+		return fmt.Errorf("referrer_policy %q would send the short URL, query included, to the target", policy)
+	}
+	if err := httpmw.CheckReferrerPolicy(policy); err != nil {
+		return fmt.Errorf("referrer_policy: %v", err)
+	}
+	return nil
+}
+
+// normalizeScrubParams checks and normalizes the parameters a link
+// scrubs from the queries it forwards (see pkg/queryscrub).
+func normalizeScrubParams(params []string) ([]string, error) {
+	params, err := queryscrub.Normalize(params)
+	if err != nil {
+		return nil, fmt.Errorf("scrub_params: %v", err)
+	}
+	return params, nil
+}
+
+// validateRedirectRate checks a link's cap on redirects per second.
+func validateRedirectRate(limit int) error {
+	if limit < 0 {
+		return fmt.Errorf("max_redirects_per_second must not be negative, got %d", limit)
+	}
+	return nil
+}
+
+// Readiness returns the readiness reported on /ready. It is false until
+// set, e.g. by lifecycle.Run once Warm returns.
+func (h *Handler) Readiness() *lifecycle.Readiness {
+	return h.ready
+}
+
+// Warm readies the dependencies before the handler takes traffic: a lookup
+// opens the store connections, memcache included, and a health check the
+// connection to keygen, TLS handshake included, so the first creates do
+// not pay for them. Both run at once.
+func (h *Handler) Warm(ctx context.Context) error {
+	g, ctx := errgroup.WithContext(ctx)
+	g.Go(func() error {
+		_, err := h.store.GetEntry(ctx, urlstore.UrlKey("health"))
+		if err != nil && !errors.Is(err, urlstore.ErrNotFound) {
+			return fmt.Errorf("store: %w", err)
+		}
+		return nil
+	})
+	if h.keygenBase != "" && h.generate == nil {
+		g.Go(func() error {
+			if h.keygens != nil {
+				h.keygens.refresh(ctx)
+			}
+			return h.warmKeygen(ctx)
+		})
+	}
+	return g.Wait()
+}
+
+// warmKeygen opens a connection to keygen and checks its health.
+func (h *Handler) warmKeygen(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, h.keygenBase+"/health", nil)
+	if err != nil {
+		return err
+	}
+	resp, err := h.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("keygen: %w", err)
+	}
+	defer resp.Body.Close()
+	// Drain the body so the connection is kept for reuse.
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("keygen health status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// Implement http.Handler: run the middlewares, then route to named
+// handlers.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	httpmw.Chain(
+		h.common,
+		httpmw.When(fromClient, h.ipFilter),
+		httpmw.When(func(r *http.Request) bool { return isMutation(r.Method) }, h.requireSigned),
+	)(http.HandlerFunc(h.route)).ServeHTTP(w, r)
+}
+
+// fromClient reports whether r comes from a client: health and readiness
+// checks come from the load balancer.
+func fromClient(r *http.Request) bool {
+	return r.URL.Path != "/health" && r.URL.Path != "/ready"
+}
+
+func isMutation(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return false
+	default:
+		return true
+	}
+}
+
+func (h *Handler) route(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.URL.Path == "/health" && r.Method == http.MethodGet:
+		h.handleHealth(w, r)
+	case r.URL.Path == "/ready" && r.Method == http.MethodGet:
+		h.ready.ServeHTTP(w, r)
+	case r.URL.Path == adminKeysPath || strings.HasPrefix(r.URL.Path, adminKeysPath+"/"):
+		h.handleAdminKeys(w, r, strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, adminKeysPath), "/"))
+	case r.URL.Path == adminTenantsPath || strings.HasPrefix(r.URL.Path, adminTenantsPath+"/"):
+		h.handleAdminTenants(w, r, strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, adminTenantsPath), "/"))
+	case r.URL.Path == adminGeneratorsPath:
+		h.handleAdminGenerators(w, r)
+	case r.URL.Path == shortenPath:
+		// The token names the tenant.
+		h.handleShorten(w, r)
+	case h.tenancy != nil && h.tenant == nil:
+		if scoped, ok := h.scope(w, r); ok {
+			scoped.routeLinks(w, r)
+		}
+	default:
+		h.routeLinks(w, r)
+	}
+}
+
+// routeLinks dispatches the endpoints served per tenant.
+func (h *Handler) routeLinks(w http.ResponseWriter, r *http.Request) {
+	limit := h.writeLimit
+	if r.URL.Path == exportPath || r.URL.Path == importPath {
+		limit = h.bulkLimit
+	}
+	release, ok := limit.Acquire(w, r)
+	if !ok {
+		return
+	}
+	defer release()
+
+	switch {
+	case r.URL.Path == "/write/v1":
+		h.handleWrite(w, r)
+	case r.URL.Path == quickPath:
+		h.handleQuick(w, r)
+	case r.URL.Path == linksPath:
+		h.handleListLinks(w, r)
+	case r.URL.Path == reservePath:
+		h.handleReserve(w, r)
+	case r.URL.Path == reversePath:
+		h.handleReverse(w, r)
+	case r.URL.Path == exportPath:
+		h.handleExport(w, r)
+	case r.URL.Path == importPath:
+		h.handleImport(w, r)
+	case r.URL.Path == bookmarkletPath:
+		h.handleBookmarklet(w, r)
+	case r.URL.Path == previewTokensPath:
+		h.handlePreviewTokens(w, r)
+	case r.URL.Path == campaignsPath || strings.HasPrefix(r.URL.Path, campaignsPrefix):
+		h.handleCampaigns(w, r, strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, campaignsPath), "/"))
+	case strings.HasPrefix(r.URL.Path, quotasPrefix):
+		h.handleQuota(w, r, strings.TrimPrefix(r.URL.Path, quotasPrefix))
+	case strings.HasPrefix(r.URL.Path, linksPrefix):
+		h.handleLink(w, r, strings.TrimPrefix(r.URL.Path, linksPrefix))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// Helper used by handleWrite
+func (h *Handler) generateNewKey(ctx context.Context) (string, error) {
+	generate := h.generate
+	if generate == nil {
+		generate = h.fetchKey
+	}
+	start := time.Now()
+	key, err := generate(ctx)
+	servertiming.Since(ctx, servertiming.Keygen, start)
+	if err != nil {
+		return "", err
+	}
+	if h.keyChecksum > 0 {
+		key = keycheck.Append(key, h.keyChecksum)
+	}
+	return key, nil
+}
+
+const (
+	// defaultKeygenTimeout bounds a key request to keygen.
+	defaultKeygenTimeout = 5 * time.Second
+	// defaultKeygenMaxResponse leaves room for the longest key and a
+	// newline.
+	defaultKeygenMaxResponse = 64
+	// maxKeygenAttempts bounds the requests for a key when keygen answers
+	// with something else.
+	maxKeygenAttempts = 3
+	// maxGeneratedKeyLength is the length of the longest key keygen
+	// mints: a layout version and a padded ID, each up to 11 base62 digits.
+	maxGeneratedKeyLength = 22
+)
+
+// errMalformedKey is a keygen answer that is no key, as from a misrouted
+// request.
+var errMalformedKey = errors.New("keygen answered with no key")
+
+// fetchKey asks keygen for a key, again when it answers with no key.
+func (h *Handler) fetchKey(ctx context.Context) (string, error) {
+	var err error
+	for range maxKeygenAttempts {
+		var key string
+		key, err = h.requestKey(ctx)
+		if !errors.Is(err, errMalformedKey) {
+			return key, err
+		}
+		log.Printf("keygen: %v", err)
+	}
+	return "", err
+}
+
+// requestKey asks keygen for a key once.
+func (h *Handler) requestKey(ctx context.Context) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, h.keygenBase+"/generate/v1", nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := h.httpClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		io.Copy(io.Discard, resp.Body)
+		return "", fmt.Errorf("keygen status %d", resp.StatusCode)
+	}
+
+	// A longer answer is not read on: its connection is closed.
+	b, err := io.ReadAll(io.LimitReader(resp.Body, h.keygenMaxResponse+1))
+	if err != nil {
+		return "", err
+	}
+	if int64(len(b)) > h.keygenMaxResponse {
+		return "", fmt.Errorf("%w: answer longer than %d bytes", errMalformedKey, h.keygenMaxResponse)
+	}
+	key := string(bytes.TrimSpace(b))
+	if !generatedKey(key) {
+		return "", fmt.Errorf("%w: %.32q", errMalformedKey, key)
+	}
+	return key, nil
+}
+
+// generatedKey reports whether key may have been minted by keygen: up to
+// maxGeneratedKeyLength base62 digits.
+func generatedKey(key string) bool {
+	if key == "" || len(key) > maxGeneratedKeyLength {
+		return false
+	}
+	for _, c := range []byte(key) {
+		if !('0' <= c && c <= '9' || 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z') {
+			return false
+		}
+	}
+	return true
+}
+
+// Close releases handler resources (preview and notification workers,
+// store, datastore client).
+func (h *Handler) Close() error {
+	if h.previews != nil {
+		h.previews.Close()
+	}
+	if h.notifier != nil {
+		h.notifier.Close()
+	}
+	if h.closeFn != nil {
+		return h.closeFn()
+	}
+	return nil
+}